@@ -227,27 +227,23 @@ func (h *ExtensionsHandler) ExtensionConfigHandler(w http.ResponseWriter, r *htt
 	}
 	
 	if r.Method == "GET" {
-		// Get current config schema
-		config := ext.ConfigSchema()
+		// Get the config schema and the saved config, without its secrets
 		w.Header().Set("Content-Type", "application/json")
-		w.Write(config)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"schema": ext.ConfigSchema(),
+			"config": h.manager.GetExtensionConfig(name),
+		})
 	} else {
 		// Update config
-		var config json.RawMessage
+		var config map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
 			http.Error(w, "Invalid config", http.StatusBadRequest)
 			return
 		}
 		
-		// Validate config
-		if err := ext.ValidateConfig(config); err != nil {
-			http.Error(w, "Invalid config: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-		
-		// Apply config
-		if err := ext.ApplyConfig(config); err != nil {
-			http.Error(w, "Failed to apply config: "+err.Error(), http.StatusInternalServerError)
+		// Validate, apply and save config with its secrets encrypted
+		if err := h.manager.SaveExtensionConfig(name, config); err != nil {
+			http.Error(w, "Failed to save config: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 		
@@ -1,5 +1,5 @@
 import type { 
-	User, LoginRequest, LoginResponse, SignupRequest,
+	User, LoginRequest, LoginResponse, SignupRequest, OAuthProvider, Lockouts,
 	DatabaseTable, DatabaseColumn, QueryResult,
 	StorageObject, StorageBucket,
 	Collection, CollectionSchema,
@@ -11,11 +11,14 @@ const API_BASE = '/api';
 
 class ApiClient {
 	private token: string | null = null;
+	private refreshToken: string | null = null;
+	private refreshing: Promise<boolean> | null = null;
 
 	constructor() {
 		// Try to restore token from localStorage
 		if (typeof window !== 'undefined') {
 			this.token = localStorage.getItem('auth_token');
+			this.refreshToken = localStorage.getItem('refresh_token');
 		}
 	}
 
@@ -26,9 +29,58 @@ class ApiClient {
 		}
 	}
 
+	// Store an access/refresh token pair returned by login, refresh or password change
+	setTokens(token: string, refreshToken?: string) {
+		this.setToken(token);
+		if (refreshToken) {
+			this.refreshToken = refreshToken;
+			if (typeof window !== 'undefined') {
+				localStorage.setItem('refresh_token', refreshToken);
+			}
+		}
+	}
+
+	private clearTokens() {
+		this.token = null;
+		this.refreshToken = null;
+		if (typeof window !== 'undefined') {
+			localStorage.removeItem('auth_token');
+			localStorage.removeItem('refresh_token');
+		}
+	}
+
+	// Exchange the refresh token for a new token pair. Concurrent callers share one request
+	// because the server rejects a refresh token that is presented twice.
+	private refreshSession(): Promise<boolean> {
+		if (!this.refreshToken) {
+			return Promise.resolve(false);
+		}
+		if (!this.refreshing) {
+			this.refreshing = fetch(`${API_BASE}/auth/refresh`, {
+				method: 'POST',
+				headers: { 'Content-Type': 'application/json' },
+				body: JSON.stringify({ refresh_token: this.refreshToken })
+			})
+				.then(async (response) => {
+					if (!response.ok) {
+						return false;
+					}
+					const data: LoginResponse = await response.json();
+					this.setTokens(data.token, data.refresh_token);
+					return true;
+				})
+				.catch(() => false)
+				.finally(() => {
+					this.refreshing = null;
+				});
+		}
+		return this.refreshing;
+	}
+
 	private async request<T>(
 		endpoint: string,
-		options: RequestInit = {}
+		options: RequestInit = {},
+		retried = false
 	): Promise<ApiResponse<T>> {
 		const headers: HeadersInit = {
 			'Content-Type': 'application/json',
@@ -67,13 +119,13 @@ class ApiClient {
 			}
 
 			if (!response.ok) {
-				// If we get a 401, clear the invalid token
+				// If we get a 401, try to refresh the session once before clearing the token
 				if (response.status === 401 && this.token) {
-					console.log('Token invalid, clearing from storage');
-					this.token = null;
-					if (typeof window !== 'undefined') {
-						localStorage.removeItem('auth_token');
+					if (!retried && !endpoint.startsWith('/auth/login') && await this.refreshSession()) {
+						return this.request<T>(endpoint, options, true);
 					}
+					console.log('Token invalid, clearing from storage');
+					this.clearTokens();
 				}
 				throw new Error(data.error || `HTTP ${response.status}`);
 			}
@@ -97,11 +149,106 @@ class ApiClient {
 		console.log('API login response:', response);
 
 		if (response.data?.token) {
-			this.token = response.data.token;
-			if (typeof window !== 'undefined') {
-				localStorage.setItem('auth_token', this.token);
-				console.log('Token stored in localStorage');
-			}
+			this.setTokens(response.data.token, response.data.refresh_token);
+			console.log('Token stored in localStorage');
+		}
+
+		return response;
+	}
+
+	// Complete a login that returned mfa_required with an authenticator or recovery code
+	async loginTwoFactor(mfaToken: string, code: string, useRecoveryCode = false): Promise<ApiResponse<LoginResponse>> {
+		const body = useRecoveryCode
+			? { mfa_token: mfaToken, recovery_code: code }
+			: { mfa_token: mfaToken, code };
+		const response = await this.request<LoginResponse>('/auth/login/2fa', {
+			method: 'POST',
+			body: JSON.stringify(body)
+		});
+
+		if (response.data?.token) {
+			this.setTokens(response.data.token, response.data.refresh_token);
+		}
+
+		return response;
+	}
+
+	// Start a login with a passkey instead of a password
+	async getPasskeyLoginOptions(): Promise<ApiResponse<{ publicKey: any }>> {
+		return this.request('/auth/passkeys/login/options', { method: 'POST' });
+	}
+
+	async loginWithPasskey(credential: any): Promise<ApiResponse<LoginResponse>> {
+		const response = await this.request<LoginResponse>('/auth/passkeys/login', {
+			method: 'POST',
+			body: JSON.stringify(credential)
+		});
+
+		if (response.data?.token) {
+			this.setTokens(response.data.token, response.data.refresh_token);
+		}
+
+		return response;
+	}
+
+	// Challenge for using a passkey as the second factor of a pending login
+	async getPasskeyTwoFactorOptions(mfaToken: string): Promise<ApiResponse<{ publicKey: any }>> {
+		return this.request('/auth/login/2fa/passkey', {
+			method: 'POST',
+			body: JSON.stringify({ mfa_token: mfaToken })
+		});
+	}
+
+	// Complete a login that returned mfa_required with a passkey
+	async loginTwoFactorPasskey(mfaToken: string, credential: any): Promise<ApiResponse<LoginResponse>> {
+		const response = await this.request<LoginResponse>('/auth/login/2fa', {
+			method: 'POST',
+			body: JSON.stringify({ mfa_token: mfaToken, passkey: credential })
+		});
+
+		if (response.data?.token) {
+			this.setTokens(response.data.token, response.data.refresh_token);
+		}
+
+		return response;
+	}
+
+	// Login providers (Google, GitHub, OpenID Connect) enabled on the login page
+	async getOAuthProviders(): Promise<ApiResponse<OAuthProvider[]>> {
+		return this.request<OAuthProvider[]>('/auth/oauth/providers');
+	}
+
+	// Trade the one-time code from a provider login for a session
+	async exchangeOAuthCode(code: string): Promise<ApiResponse<LoginResponse>> {
+		const response = await this.request<LoginResponse>('/auth/oauth/exchange', {
+			method: 'POST',
+			body: JSON.stringify({ code })
+		});
+
+		if (response.data?.token) {
+			this.setTokens(response.data.token, response.data.refresh_token);
+		}
+
+		return response;
+	}
+
+	// Email a single-use login link instead of using a password
+	async requestMagicLink(email: string): Promise<ApiResponse<{ message: string }>> {
+		return this.request('/auth/magic-link', {
+			method: 'POST',
+			body: JSON.stringify({ email })
+		});
+	}
+
+	// Trade the token from a login link for a session
+	async loginWithMagicLink(token: string): Promise<ApiResponse<LoginResponse>> {
+		const response = await this.request<LoginResponse>('/auth/magic-link/verify', {
+			method: 'POST',
+			body: JSON.stringify({ token })
+		});
+
+		if (response.data?.token) {
+			this.setTokens(response.data.token, response.data.refresh_token);
 		}
 
 		return response;
@@ -112,10 +259,7 @@ class ApiClient {
 			method: 'POST'
 		});
 
-		this.token = null;
-		if (typeof window !== 'undefined') {
-			localStorage.removeItem('auth_token');
-		}
+		this.clearTokens();
 
 		return response;
 	}
@@ -127,6 +271,41 @@ class ApiClient {
 		});
 	}
 
+	async confirmEmail(token: string): Promise<ApiResponse<{ message: string }>> {
+		return this.request('/auth/confirm', {
+			method: 'POST',
+			body: JSON.stringify({ token })
+		});
+	}
+
+	async resendConfirmation(email: string): Promise<ApiResponse<{ message: string }>> {
+		return this.request('/auth/confirm/resend', {
+			method: 'POST',
+			body: JSON.stringify({ email })
+		});
+	}
+
+	async forgotPassword(email: string): Promise<ApiResponse<{ message: string }>> {
+		return this.request('/auth/password/forgot', {
+			method: 'POST',
+			body: JSON.stringify({ email })
+		});
+	}
+
+	async resetPassword(token: string, password: string): Promise<ApiResponse<{ message: string }>> {
+		return this.request('/auth/password/reset', {
+			method: 'POST',
+			body: JSON.stringify({ token, password })
+		});
+	}
+
+	async unlockAccount(token: string): Promise<ApiResponse<{ message: string }>> {
+		return this.request('/auth/unlock', {
+			method: 'POST',
+			body: JSON.stringify({ token })
+		});
+	}
+
 	async getCurrentUser(): Promise<ApiResponse<User>> {
 		console.log('Getting current user, token:', this.token ? 'present' : 'missing');
 		const response = await this.request<User>('/auth/me');
@@ -150,6 +329,16 @@ class ApiClient {
 		});
 	}
 
+	async getLockouts(): Promise<ApiResponse<Lockouts>> {
+		return this.request<Lockouts>('/users/locked');
+	}
+
+	async unlockUser(id: string): Promise<ApiResponse<{ message: string }>> {
+		return this.request(`/users/${id}/unlock`, {
+			method: 'POST'
+		});
+	}
+
 	async deleteUser(id: string): Promise<ApiResponse<void>> {
 		return this.request<void>(`/users/${id}`, {
 			method: 'DELETE'
@@ -1,20 +1,79 @@
 import { writable, derived } from 'svelte/store';
-import type { User } from '$lib/types';
+import type { ApiResponse, LoginResponse, User } from '$lib/types';
 import { api } from '$lib/api';
+import { getPasskey } from '$lib/utils/webauthn';
 
 interface AuthState {
 	user: User | null;
 	loading: boolean;
 	error: string | null;
+	mfaToken: string | null;
+	mfaMethods: string[];
 }
 
 function createAuthStore() {
 	const { subscribe, set, update } = writable<AuthState>({
 		user: null,
 		loading: true,
-		error: null
+		error: null,
+		mfaToken: null,
+		mfaMethods: []
 	});
 
+	// completeLogin finishes a login that did not use the password form
+	async function completeLogin(request: Promise<ApiResponse<LoginResponse>>) {
+		update(state => ({ ...state, loading: true, error: null }));
+
+		const response = await request;
+		if (response.error) {
+			update(state => ({ ...state, loading: false, error: response.error! }));
+			return false;
+		}
+
+		if (response.data!.mfa_required) {
+			update(state => ({ ...state, loading: false, mfaToken: response.data!.mfa_token!, mfaMethods: response.data!.mfa_methods ?? ['totp'] }));
+			return false;
+		}
+
+		update(state => ({
+			...state,
+			user: response.data!.user,
+			loading: false,
+			error: null
+		}));
+		return true;
+	}
+
+	// finishTwoFactor sends the second factor for the pending login
+	async function finishTwoFactor(send: (mfaToken: string) => Promise<ApiResponse<LoginResponse>>) {
+		let mfaToken: string | null = null;
+		update(state => {
+			mfaToken = state.mfaToken;
+			return { ...state, loading: true, error: null };
+		});
+		if (!mfaToken) {
+			update(state => ({ ...state, loading: false, error: 'Please sign in again' }));
+			return false;
+		}
+
+		const response = await send(mfaToken);
+
+		// The challenge is single-use, so any failure means starting over
+		if (response.error) {
+			update(state => ({ ...state, loading: false, error: response.error!, mfaToken: null }));
+			return false;
+		}
+
+		update(state => ({
+			...state,
+			user: response.data!.user,
+			loading: false,
+			error: null,
+			mfaToken: null
+		}));
+		return true;
+	}
+
 	return {
 		subscribe,
 		async login(email: string, password: string) {
@@ -30,6 +89,11 @@ function createAuthStore() {
 				return false;
 			}
 
+			if (response.data!.mfa_required) {
+				update(state => ({ ...state, loading: false, mfaToken: response.data!.mfa_token!, mfaMethods: response.data!.mfa_methods ?? ['totp'] }));
+				return false;
+			}
+
 			console.log('Login successful, user:', response.data!.user);
 			update(state => ({ 
 				...state, 
@@ -39,10 +103,55 @@ function createAuthStore() {
 			}));
 			return true;
 		},
+		// Finish a login started at an external provider
+		async loginWithOAuthCode(code: string) {
+			return completeLogin(api.exchangeOAuthCode(code));
+		},
+		// Finish a login from an emailed link
+		async loginWithMagicLink(token: string) {
+			return completeLogin(api.loginWithMagicLink(token));
+		},
+		async verifyTwoFactor(code: string, useRecoveryCode = false) {
+			return finishTwoFactor(mfaToken => api.loginTwoFactor(mfaToken, code, useRecoveryCode));
+		},
+		// Use a passkey as the second factor
+		async verifyTwoFactorPasskey() {
+			return finishTwoFactor(async mfaToken => {
+				const options = await api.getPasskeyTwoFactorOptions(mfaToken);
+				if (options.error) {
+					return { error: options.error };
+				}
+				try {
+					return await api.loginTwoFactorPasskey(mfaToken, await getPasskey(options.data!.publicKey));
+				} catch {
+					return { error: 'Passkey was not used, please sign in again' };
+				}
+			});
+		},
+		// Log in with a passkey instead of a password
+		async loginWithPasskey() {
+			update(state => ({ ...state, loading: true, error: null }));
+			const options = await api.getPasskeyLoginOptions();
+			if (options.error) {
+				update(state => ({ ...state, loading: false, error: options.error! }));
+				return false;
+			}
+			let credential;
+			try {
+				credential = await getPasskey(options.data!.publicKey);
+			} catch {
+				update(state => ({ ...state, loading: false, error: 'Passkey login was cancelled' }));
+				return false;
+			}
+			return completeLogin(api.loginWithPasskey(credential));
+		},
+		cancelTwoFactor() {
+			update(state => ({ ...state, mfaToken: null, error: null }));
+		},
 		async logout() {
 			console.log('Logging out...');
 			await api.logout();
-			set({ user: null, loading: false, error: null });
+			set({ user: null, loading: false, error: null, mfaToken: null, mfaMethods: [] });
 			console.log('Logout complete, auth store cleared');
 		},
 		async checkAuth() {
@@ -54,7 +163,7 @@ function createAuthStore() {
 			
 			if (response.error) {
 				console.log('Auth check failed:', response.error);
-				set({ user: null, loading: false, error: null });
+				set({ user: null, loading: false, error: null, mfaToken: null, mfaMethods: [] });
 				return false;
 			}
 
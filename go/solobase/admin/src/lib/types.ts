@@ -20,9 +20,42 @@ export interface LoginRequest {
 	password: string;
 }
 
+export interface OAuthProvider {
+	name: string;
+	display_name: string;
+	preset?: string;
+}
+
+// Accounts and addresses locked out after failed logins
+export interface Lockouts {
+	accounts: {
+		user_id: string;
+		email: string;
+		failed_attempts: number;
+		last_attempt: string;
+		locked_until: string;
+	}[];
+	clients: {
+		id: string;
+		email: string;
+		ip_address: string;
+		failed_count: number;
+		last_failed_at: string;
+		locked_until?: string;
+	}[];
+}
+
 export interface LoginResponse {
 	token: string;
+	refresh_token?: string;
+	expires_in?: number;
 	user: User;
+	// Set when the password was accepted but a second factor is still needed
+	mfa_required?: boolean;
+	mfa_token?: string;
+	// Second factors the user can choose from: totp and passkey
+	mfa_methods?: string[];
+	two_factor_setup_required?: boolean;
 }
 
 export interface SignupRequest {
@@ -106,17 +139,23 @@ export interface AppSettings {
 	app_url: string;
 	allow_signup: boolean;
 	require_email_confirmation: boolean;
+	enable_magic_link: boolean;
 	smtp_enabled: boolean;
 	smtp_host?: string;
 	smtp_port?: number;
 	smtp_user?: string;
+	smtp_from?: string;
 	storage_provider: 'local' | 's3';
 	s3_bucket?: string;
 	s3_region?: string;
 	max_upload_size: number;
 	allowed_file_types: string;
+	trash_retention_days?: number;
 	session_timeout: number;
 	password_min_length: number;
+	lockout_threshold?: number;
+	lockout_ip_threshold?: number;
+	lockout_duration?: number;
 	enable_api_logs: boolean;
 	enable_debug_mode: boolean;
 	maintenance_mode: boolean;
@@ -0,0 +1,77 @@
+// Passkey ceremonies. The server sends WebAuthn options as JSON with base64url
+// encoded binary values, and expects credentials back in the same form.
+
+function fromBase64URL(value: string): ArrayBuffer {
+	const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+	const padded = base64 + '='.repeat((4 - (base64.length % 4)) % 4);
+	const binary = atob(padded);
+	const bytes = new Uint8Array(binary.length);
+	for (let i = 0; i < binary.length; i++) {
+		bytes[i] = binary.charCodeAt(i);
+	}
+	return bytes.buffer;
+}
+
+function toBase64URL(buffer: ArrayBuffer): string {
+	let binary = '';
+	for (const byte of new Uint8Array(buffer)) {
+		binary += String.fromCharCode(byte);
+	}
+	return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+function descriptors(list: any[] | undefined): PublicKeyCredentialDescriptor[] | undefined {
+	return list?.map((descriptor) => ({ ...descriptor, id: fromBase64URL(descriptor.id) }));
+}
+
+export function passkeysSupported(): boolean {
+	return typeof window !== 'undefined' && !!window.PublicKeyCredential;
+}
+
+// Create a passkey with the options from /auth/passkeys/register/options
+export async function createPasskey(options: any): Promise<any> {
+	const credential = (await navigator.credentials.create({
+		publicKey: {
+			...options,
+			challenge: fromBase64URL(options.challenge),
+			user: { ...options.user, id: fromBase64URL(options.user.id) },
+			excludeCredentials: descriptors(options.excludeCredentials)
+		}
+	})) as PublicKeyCredential;
+	const response = credential.response as AuthenticatorAttestationResponse;
+
+	return {
+		id: credential.id,
+		rawId: toBase64URL(credential.rawId),
+		type: credential.type,
+		response: {
+			clientDataJSON: toBase64URL(response.clientDataJSON),
+			attestationObject: toBase64URL(response.attestationObject),
+			transports: response.getTransports?.() ?? []
+		}
+	};
+}
+
+// Sign a login challenge with a passkey
+export async function getPasskey(options: any): Promise<any> {
+	const credential = (await navigator.credentials.get({
+		publicKey: {
+			...options,
+			challenge: fromBase64URL(options.challenge),
+			allowCredentials: descriptors(options.allowCredentials)
+		}
+	})) as PublicKeyCredential;
+	const response = credential.response as AuthenticatorAssertionResponse;
+
+	return {
+		id: credential.id,
+		rawId: toBase64URL(credential.rawId),
+		type: credential.type,
+		response: {
+			clientDataJSON: toBase64URL(response.clientDataJSON),
+			authenticatorData: toBase64URL(response.authenticatorData),
+			signature: toBase64URL(response.signature),
+			userHandle: response.userHandle ? toBase64URL(response.userHandle) : undefined
+		}
+	};
+}
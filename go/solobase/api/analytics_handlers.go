@@ -158,7 +158,7 @@ func (h *AnalyticsHandlers) HandleTrack() http.HandlerFunc {
 					"page_url":   pageURL,
 					"referrer":   r.Referer(),
 					"user_agent": r.UserAgent(),
-					"ip_address": r.RemoteAddr,
+					"ip_address": requestIP(r),
 					"created_at": time.Now(),
 				}
 				
@@ -313,10 +313,7 @@ func (h *AnalyticsHandlers) trackPageView(r *http.Request) {
 	}
 	
 	// Get client IP
-	clientIP := r.RemoteAddr
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		clientIP = forwarded
-	}
+	clientIP := requestIP(r)
 	
 	// Insert page view
 	pageView := map[string]interface{}{
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+)
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// ExpiresIn is an alternative to ExpiresAt, in days
+	ExpiresIn int `json:"expires_in_days,omitempty"`
+}
+
+type CreateAPIKeyResponse struct {
+	models.APIKey
+	// Key is only returned when the key is created
+	Key string `json:"key"`
+}
+
+// HandleListAPIKeys lists the current user's API keys
+func HandleListAPIKeys(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value("user").(*auth.User)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		keys, err := authService.ListAPIKeys(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to list API keys")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, keys)
+	}
+}
+
+// HandleCreateAPIKey creates an API key for the current user
+func HandleCreateAPIKey(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value("user").(*auth.User)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var req CreateAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		expiresAt := req.ExpiresAt
+		if expiresAt == nil && req.ExpiresIn > 0 {
+			t := time.Now().AddDate(0, 0, req.ExpiresIn)
+			expiresAt = &t
+		}
+
+		apiKey, key, err := authService.CreateAPIKey(user.ID, req.Name, req.Scopes, expiresAt)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("API key %s created for user %s", apiKey.Prefix, user.Email)
+		respondWithJSON(w, http.StatusCreated, CreateAPIKeyResponse{APIKey: *apiKey, Key: key})
+	}
+}
+
+// HandleRevokeAPIKey deletes one of the current user's API keys
+func HandleRevokeAPIKey(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value("user").(*auth.User)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		if err := authService.RevokeAPIKey(user.ID, mux.Vars(r)["id"]); err != nil {
+			if errors.Is(err, services.ErrAPIKeyNotFound) {
+				respondWithError(w, http.StatusNotFound, "API key not found")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to revoke API key")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "API key revoked"})
+	}
+}
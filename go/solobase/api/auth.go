@@ -2,14 +2,19 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	auth "github.com/suppers-ai/auth"
 	"github.com/suppers-ai/solobase/extensions/core"
 	"github.com/suppers-ai/solobase/services"
+	"github.com/suppers-ai/solobase/utils"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -26,8 +31,17 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string       `json:"token"`
-	User  *auth.User `json:"user"`
+	Token        string     `json:"token"`
+	RefreshToken string     `json:"refresh_token"`
+	ExpiresIn    int        `json:"expires_in"`
+	User         *auth.User `json:"user"`
+	// TwoFactorSetupRequired is set when the user's role requires 2FA they have not
+	// set up; the token only allows setting it up until then
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 type SignupRequest struct {
@@ -40,10 +54,19 @@ type Claims struct {
 	UserID string `json:"user_id"`
 	Email  string `json:"email"`
 	Role   string `json:"role"`
+	// SessionID ties the access token to the session that issued it so
+	// revoking the session also rejects the token
+	SessionID string `json:"sid,omitempty"`
+	// TwoFactorSetup restricts the token to setting up 2FA
+	TwoFactorSetup bool `json:"mfa_setup,omitempty"`
+	// OrgID is the active organization, which new resources belong to by default
+	OrgID string `json:"org,omitempty"`
+	// Impersonation is set on tokens an admin was issued to act as this user
+	Impersonation *Impersonation `json:"impersonation,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func HandleLogin(authService *services.AuthService, storageService *services.StorageService, extensionRegistry *core.ExtensionRegistry) http.HandlerFunc {
+func HandleLogin(authService *services.AuthService, settingsService *services.SettingsService, mailService *services.MailService, storageService *services.StorageService, extensionRegistry *core.ExtensionRegistry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Login request received")
 		
@@ -56,84 +79,269 @@ func HandleLogin(authService *services.AuthService, storageService *services.Sto
 
 		log.Printf("Login attempt for email: %s", req.Email)
 		
-		user, err := authService.AuthenticateUser(req.Email, req.Password)
+		ip := requestIP(r)
+		user, err := authService.AuthenticateUser(req.Email, req.Password, ip, lockoutPolicy(settingsService))
 		if err != nil {
-			log.Printf("Authentication failed for %s: %v", req.Email, err)
+			var failure *services.LoginFailedError
+			if !errors.As(err, &failure) {
+				log.Printf("Failed to authenticate %s: %v", req.Email, err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to log in")
+				return
+			}
+
+			log.Printf("Authentication failed for %s from %s: %s", req.Email, ip, failure.Reason)
+			executeLoginFailedHooks(w, r, req.Email, ip, failure, storageService, extensionRegistry)
+			if failure.NewlyLocked {
+				sendUnlockEmail(authService, settingsService, mailService, failure.User, failure.LockedUntil)
+			}
+			if failure.Locked() {
+				respondLockedOut(w, failure.LockedUntil)
+				return
+			}
 			respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
 			return
 		}
 
-		// Execute PostLogin hooks for extensions
-		if extensionRegistry != nil {
-			// Get app ID from storage service if available
-			appID := "solobase" // default
-			if storageService != nil {
-				appID = storageService.GetAppID()
-			}
-			
-			log.Printf("Executing PostLogin hooks with appID=%s for user %s", appID, user.Email)
-			
-			hookCtx := &core.HookContext{
-				Request:  r,
-				Response: w,
-				Data: map[string]interface{}{
-					"userID":    user.ID.String(),
-					"userEmail": user.Email,
-					"userRole":  user.Role,
-					"appID":     appID,
-				},
-				Services: nil, // Services will be set by the registry
-			}
-			
-			// Set the storage service in the hook context if available
-			if storageService != nil && hookCtx.Services != nil {
-				// The registry should have already set up services
-				// We just add the storage reference for backwards compatibility
+		continueLogin(w, r, user, authService, settingsService, storageService, extensionRegistry)
+	}
+}
+
+// continueLogin takes a user who proved their identity with a first factor
+// through the remaining login checks: email confirmation and two-factor authentication
+func continueLogin(w http.ResponseWriter, r *http.Request, user *auth.User, authService *services.AuthService, settingsService *services.SettingsService, storageService *services.StorageService, extensionRegistry *core.ExtensionRegistry) {
+	if !checkEmailConfirmed(w, settingsService, user) {
+		return
+	}
+
+	hasPasskeys, err := authService.HasPasskeys(user.ID)
+	if err != nil {
+		log.Printf("Failed to look up passkeys of %s: %v", user.Email, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to log in")
+		return
+	}
+
+	// Users with two-factor authentication finish at /auth/login/2fa
+	if methods := secondFactorMethods(user, hasPasskeys); len(methods) > 0 {
+		challenge, err := authService.CreateMFAChallenge(user.ID)
+		if err != nil {
+			log.Printf("Failed to create MFA challenge for %s: %v", user.Email, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to start two-factor login")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    challenge,
+			ExpiresIn:   int(services.MFAChallengeTTL.Seconds()),
+			Methods:     methods,
+		})
+		return
+	}
+
+	completeLogin(w, r, user, authService, settingsService, storageService, extensionRegistry)
+}
+
+// completeLogin runs the post-login hooks and starts a session for a user who
+// has passed every authentication step, clearing their failed logins
+func completeLogin(w http.ResponseWriter, r *http.Request, user *auth.User, authService *services.AuthService, settingsService *services.SettingsService, storageService *services.StorageService, extensionRegistry *core.ExtensionRegistry) {
+	if err := authService.ResetLoginFailures(user, requestIP(r)); err != nil {
+		log.Printf("Failed to reset failed logins of %s: %v", user.Email, err)
+	}
+
+	// Execute PostLogin hooks for extensions
+	if extensionRegistry != nil {
+		// Get app ID from storage service if available
+		appID := "solobase" // default
+		if storageService != nil {
+			appID = storageService.GetAppID()
+		}
+		
+		log.Printf("Executing PostLogin hooks with appID=%s for user %s", appID, user.Email)
+		
+		hookCtx := &core.HookContext{
+			Request:  r,
+			Response: w,
+			Data: map[string]interface{}{
+				"userID":    user.ID.String(),
+				"userEmail": user.Email,
+				"userRole":  user.Role,
+				"appID":     appID,
+				"success":   true,
+			},
+			Services: nil, // Services will be set by the registry
+		}
+		
+		// Set the storage service in the hook context if available
+		if storageService != nil && hookCtx.Services != nil {
+			// The registry should have already set up services
+			// We just add the storage reference for backwards compatibility
+		}
+		
+		// Execute post-login hooks (e.g., CloudStorage extension will create "My Files" folder)
+		if err := extensionRegistry.ExecuteHooks(r.Context(), core.HookPostLogin, hookCtx); err != nil {
+			// Log the error but don't fail the login
+			log.Printf("Warning: PostLogin hook failed: %v", err)
+		} else {
+			log.Printf("PostLogin hooks executed successfully")
+		}
+	} else {
+		log.Printf("Warning: extensionRegistry is nil, skipping PostLogin hooks")
+	}
+
+	response, err := issueSession(authService, settingsService, user, r)
+	if err != nil {
+		log.Printf("Failed to start session for %s: %v", user.Email, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	log.Printf("Login successful for %s", user.Email)
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func HandleLogout(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := r.Context().Value("user").(*auth.User)
+		sessionID, _ := r.Context().Value("sessionID").(string)
+		if user != nil && sessionID != "" {
+			if err := authService.RevokeSession(user.ID, sessionID); err != nil && !errors.Is(err, services.ErrSessionNotFound) {
+				log.Printf("Failed to revoke session %s: %v", sessionID, err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to log out")
+				return
 			}
-			
-			// Execute post-login hooks (e.g., CloudStorage extension will create "My Files" folder)
-			if err := extensionRegistry.ExecuteHooks(r.Context(), core.HookPostLogin, hookCtx); err != nil {
-				// Log the error but don't fail the login
-				log.Printf("Warning: PostLogin hook failed: %v", err)
-			} else {
-				log.Printf("PostLogin hooks executed successfully")
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Logged out successfully"})
+	}
+}
+
+// HandleRefreshToken exchanges a refresh token for a new access and refresh token pair
+func HandleRefreshToken(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		policy := sessionPolicy(settingsService)
+		session, refreshToken, err := authService.RefreshSession(req.RefreshToken, r.UserAgent(), requestIP(r), policy)
+		if err != nil {
+			if errors.Is(err, services.ErrRefreshTokenReused) {
+				log.Printf("Refresh token reuse detected, session revoked")
+			} else if !errors.Is(err, services.ErrInvalidRefreshToken) {
+				log.Printf("Failed to refresh session: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to refresh token")
+				return
 			}
-		} else {
-			log.Printf("Warning: extensionRegistry is nil, skipping PostLogin hooks")
+			respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
 		}
 
-		// Generate JWT token
-		token, err := generateToken(user)
+		user, err := authService.GetUserByID(session.UserID.String())
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "User not found")
+			return
+		}
+
+		setupRequired := twoFactorSetupRequired(authService, settingsService, user)
+		token, err := generateToken(user, session.ID, services.SessionOrganization(session), setupRequired, policy.AccessTokenTTL())
 		if err != nil {
-			log.Printf("Failed to generate token for %s: %v", req.Email, err)
 			respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
 			return
 		}
 
-		log.Printf("Login successful for %s", req.Email)
 		respondWithJSON(w, http.StatusOK, LoginResponse{
-			Token: token,
-			User:  user,
+			Token:                  token,
+			RefreshToken:           refreshToken,
+			ExpiresIn:              int(policy.AccessTokenTTL().Seconds()),
+			User:                   user,
+			TwoFactorSetupRequired: setupRequired,
 		})
 	}
 }
 
-func HandleLogout() http.HandlerFunc {
+// HandleListSessions lists the current user's active sessions
+func HandleListSessions(authService *services.AuthService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// In a JWT-based system, logout is handled client-side
-		// We could implement a token blacklist here if needed
-		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Logged out successfully"})
+		user, ok := r.Context().Value("user").(*auth.User)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+		sessionID, _ := r.Context().Value("sessionID").(string)
+
+		sessions, err := authService.ListSessions(user.ID, sessionID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to list sessions")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, sessions)
 	}
 }
 
-func HandleSignup(authService *services.AuthService) http.HandlerFunc {
+// HandleRevokeSession revokes one of the current user's sessions
+func HandleRevokeSession(authService *services.AuthService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value("user").(*auth.User)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		if err := authService.RevokeSession(user.ID, mux.Vars(r)["id"]); err != nil {
+			if errors.Is(err, services.ErrSessionNotFound) {
+				respondWithError(w, http.StatusNotFound, "Session not found")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to revoke session")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Session revoked"})
+	}
+}
+
+// HandleRevokeOtherSessions revokes every session of the current user except the one making the request
+func HandleRevokeOtherSessions(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value("user").(*auth.User)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var keep []string
+		if sessionID, _ := r.Context().Value("sessionID").(string); sessionID != "" {
+			keep = append(keep, sessionID)
+		}
+		if err := authService.RevokeUserSessions(user.ID, keep...); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Other sessions revoked"})
+	}
+}
+
+func HandleSignup(authService *services.AuthService, settingsService *services.SettingsService, mailService *services.MailService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !currentSettings(settingsService).AllowSignup {
+			respondWithError(w, http.StatusForbidden, "Signups are disabled")
+			return
+		}
+
 		var req SignupRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid request body")
 			return
 		}
 
+		req.Email = strings.TrimSpace(req.Email)
+		if req.Email == "" {
+			respondWithError(w, http.StatusBadRequest, "Email is required")
+			return
+		}
+		if !checkNewPassword(w, settingsService, req.Password, req.Email) {
+			return
+		}
+
 		// Hash password
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
@@ -153,6 +361,8 @@ func HandleSignup(authService *services.AuthService) http.HandlerFunc {
 			return
 		}
 
+		sendConfirmationEmail(authService, settingsService, mailService, user)
+
 		respondWithJSON(w, http.StatusCreated, user)
 	}
 }
@@ -169,7 +379,7 @@ type ChangePasswordRequest struct {
 	NewPassword     string `json:"new_password"`
 }
 
-func HandleChangePassword(authService *services.AuthService) http.HandlerFunc {
+func HandleChangePassword(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get user from context (set by auth middleware)
 		user, ok := r.Context().Value("user").(*auth.User)
@@ -184,15 +394,21 @@ func HandleChangePassword(authService *services.AuthService) http.HandlerFunc {
 			return
 		}
 
+		// The context user is built from token claims, so load the stored hash
+		storedUser, err := authService.GetUserByID(user.ID.String())
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "User not found")
+			return
+		}
+
 		// Verify current password
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		if err := bcrypt.CompareHashAndPassword([]byte(storedUser.Password), []byte(req.CurrentPassword)); err != nil {
 			respondWithError(w, http.StatusUnauthorized, "Current password is incorrect")
 			return
 		}
 
 		// Validate new password
-		if len(req.NewPassword) < 8 {
-			respondWithError(w, http.StatusBadRequest, "Password must be at least 8 characters")
+		if !checkNewPassword(w, settingsService, req.NewPassword, storedUser.Email) {
 			return
 		}
 
@@ -210,21 +426,69 @@ func HandleChangePassword(authService *services.AuthService) http.HandlerFunc {
 			return
 		}
 
-		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Password updated successfully"})
+		// Updating the password signed out every device, including this one;
+		// the caller continues with a fresh session
+		response, err := issueSession(authService, settingsService, storedUser, r)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"message":       "Password updated successfully",
+			"token":         response.Token,
+			"refresh_token": response.RefreshToken,
+			"expires_in":    response.ExpiresIn,
+		})
 	}
 }
 
-func generateToken(user *auth.User) (string, error) {
+// issueSession starts a session for the user and returns its first token pair
+func issueSession(authService *services.AuthService, settingsService *services.SettingsService, user *auth.User, r *http.Request) (*LoginResponse, error) {
+	policy := sessionPolicy(settingsService)
+	session, refreshToken, err := authService.CreateSession(user.ID, r.UserAgent(), requestIP(r), policy)
+	if err != nil {
+		return nil, err
+	}
+
+	setupRequired := twoFactorSetupRequired(authService, settingsService, user)
+	token, err := generateToken(user, session.ID, "", setupRequired, policy.AccessTokenTTL())
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
+		Token:                  token,
+		RefreshToken:           refreshToken,
+		ExpiresIn:              int(policy.AccessTokenTTL().Seconds()),
+		User:                   user,
+		TwoFactorSetupRequired: setupRequired,
+	}, nil
+}
+
+// generateToken issues an access token for a session. The lifetime comes from
+// the session policy so tokens never outlive the session timeout.
+func generateToken(user *auth.User, sessionID, orgID string, twoFactorSetup bool, ttl time.Duration) (string, error) {
 	claims := &Claims{
-		UserID: user.ID.String(),
-		Email:  user.Email,
-		Role:   user.Role,
+		UserID:         user.ID.String(),
+		Email:          user.Email,
+		Role:           user.Role,
+		SessionID:      sessionID,
+		TwoFactorSetup: twoFactorSetup,
+		OrgID:          orgID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(jwtSecret)
-}
\ No newline at end of file
+}
+
+// requestIP returns the client address, taken from X-Forwarded-For only
+// when the request comes through a trusted proxy
+func requestIP(r *http.Request) string {
+	return utils.ClientIP(r)
+}
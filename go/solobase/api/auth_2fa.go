@@ -0,0 +1,349 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"log"
+	"net/http"
+
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/services"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MFAChallengeResponse is returned by login when a second factor is needed
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	// Methods are the second factors the user has: totp and passkey
+	Methods []string `json:"mfa_methods"`
+}
+
+type TwoFactorLoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+	// Passkey answers the challenge from /auth/login/2fa/passkey instead of a code
+	Passkey *services.AssertionResponse `json:"passkey,omitempty"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorDisableRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type TwoFactorEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+	// QRCode is a PNG data URL of the otpauth URL for authenticator apps to scan
+	QRCode string `json:"qr_code"`
+}
+
+type TwoFactorStatusResponse struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+	// Passkeys is the number of registered passkeys, which also count as a second factor
+	Passkeys int `json:"passkeys"`
+}
+
+// HandleLoginTwoFactor completes a login started with a password by checking
+// the authenticator code or a recovery code
+func HandleLoginTwoFactor(authService *services.AuthService, settingsService *services.SettingsService, mailService *services.MailService, storageService *services.StorageService, extensionRegistry *core.ExtensionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TwoFactorLoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		user, err := authService.ConsumeMFAChallenge(req.MFAToken)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Two-factor login expired, please sign in again")
+			return
+		}
+
+		if req.Passkey != nil {
+			rp, ok := relyingParty(w, settingsService)
+			if !ok {
+				return
+			}
+			if err := authService.VerifyPasskeyMFA(user, rp, req.Passkey); err != nil {
+				logPasskeyFailure("second factor for "+user.Email, err)
+				recordSecondFactorFailure(w, r, user, authService, settingsService, mailService, storageService, extensionRegistry)
+				respondWithError(w, http.StatusUnauthorized, "Passkey was not accepted, please sign in again")
+				return
+			}
+		} else if err := authService.VerifySecondFactor(user, req.Code, req.RecoveryCode); err != nil {
+			log.Printf("Two-factor verification failed for %s: %v", user.Email, err)
+			recordSecondFactorFailure(w, r, user, authService, settingsService, mailService, storageService, extensionRegistry)
+			respondWithError(w, http.StatusUnauthorized, "Invalid two-factor code, please sign in again")
+			return
+		}
+
+		completeLogin(w, r, user, authService, settingsService, storageService, extensionRegistry)
+	}
+}
+
+// recordSecondFactorFailure counts a wrong second factor towards the lockouts
+// like a wrong password and runs the post_login hooks for it
+func recordSecondFactorFailure(w http.ResponseWriter, r *http.Request, user *auth.User, authService *services.AuthService, settingsService *services.SettingsService, mailService *services.MailService, storageService *services.StorageService, extensionRegistry *core.ExtensionRegistry) {
+	ip := requestIP(r)
+	err := authService.RecordSecondFactorFailure(user, ip, lockoutPolicy(settingsService))
+	var failure *services.LoginFailedError
+	if !errors.As(err, &failure) {
+		log.Printf("Failed to record two-factor failure for %s: %v", user.Email, err)
+		return
+	}
+
+	executeLoginFailedHooks(w, r, user.Email, ip, failure, storageService, extensionRegistry)
+	if failure.NewlyLocked {
+		sendUnlockEmail(authService, settingsService, mailService, user, failure.LockedUntil)
+	}
+}
+
+// HandleTwoFactorStatus reports whether the current user has 2FA enabled or is required to
+func HandleTwoFactorStatus(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentFullUser(w, r, authService)
+		if !ok {
+			return
+		}
+
+		passkeys, err := authService.ListPasskeys(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to load two-factor status")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, TwoFactorStatusResponse{
+			Enabled:                user.GetTOTPSecretKey() != "",
+			Required:               twoFactorRequired(settingsService, user),
+			RecoveryCodesRemaining: services.RecoveryCodesRemaining(user),
+			Passkeys:               len(passkeys),
+		})
+	}
+}
+
+// HandleTwoFactorEnroll starts TOTP enrollment and returns the secret as an otpauth URL and QR code
+func HandleTwoFactorEnroll(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentFullUser(w, r, authService)
+		if !ok {
+			return
+		}
+
+		issuer := "Solobase"
+		if settingsService != nil {
+			if settings, err := settingsService.GetSettings(); err == nil && settings.AppName != "" {
+				issuer = settings.AppName
+			}
+		}
+
+		key, err := authService.BeginTOTPEnrollment(user, issuer)
+		if err != nil {
+			if errors.Is(err, services.ErrTOTPAlreadyEnabled) {
+				respondWithError(w, http.StatusConflict, err.Error())
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to start two-factor enrollment")
+			return
+		}
+
+		img, err := key.Image(256, 256)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate QR code")
+			return
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate QR code")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, TwoFactorEnrollResponse{
+			Secret:     key.Secret(),
+			OTPAuthURL: key.URL(),
+			QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+		})
+	}
+}
+
+// HandleTwoFactorConfirm enables TOTP once the user enters a code from their authenticator.
+// The recovery codes are only shown in this response.
+func HandleTwoFactorConfirm(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentFullUser(w, r, authService)
+		if !ok {
+			return
+		}
+
+		var req TwoFactorCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		codes, err := authService.ConfirmTOTPEnrollment(user.ID, req.Code)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrNoTOTPEnrollment):
+				respondWithError(w, http.StatusBadRequest, "No pending two-factor enrollment, please start again")
+			case errors.Is(err, services.ErrInvalidTOTPCode):
+				respondWithError(w, http.StatusBadRequest, "Invalid two-factor code")
+			default:
+				respondWithError(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+			}
+			return
+		}
+
+		response := map[string]interface{}{
+			"message":        "Two-factor authentication enabled",
+			"recovery_codes": codes,
+		}
+
+		// Replace a token that was restricted to 2FA setup
+		if sessionID, _ := r.Context().Value("sessionID").(string); sessionID != "" {
+			orgID, _ := r.Context().Value("orgID").(string)
+			if token, err := generateToken(user, sessionID, orgID, false, sessionPolicy(settingsService).AccessTokenTTL()); err == nil {
+				response["token"] = token
+			}
+		}
+
+		respondWithJSON(w, http.StatusOK, response)
+	}
+}
+
+// HandleTwoFactorDisable turns off 2FA after checking the password and a second factor
+func HandleTwoFactorDisable(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentFullUser(w, r, authService)
+		if !ok {
+			return
+		}
+
+		var req TwoFactorDisableRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if twoFactorRequired(settingsService, user) {
+			respondWithError(w, http.StatusForbidden, "Two-factor authentication is required for your role")
+			return
+		}
+
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Password is incorrect")
+			return
+		}
+
+		if err := authService.VerifySecondFactor(user, req.Code, req.RecoveryCode); err != nil {
+			if errors.Is(err, services.ErrTOTPNotEnabled) {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			respondWithError(w, http.StatusUnauthorized, "Invalid two-factor code")
+			return
+		}
+
+		if err := authService.DisableTOTP(user.ID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+	}
+}
+
+// HandleRegenerateRecoveryCodes replaces the current user's recovery codes after checking an authenticator code
+func HandleRegenerateRecoveryCodes(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentFullUser(w, r, authService)
+		if !ok {
+			return
+		}
+
+		var req TwoFactorCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := authService.VerifySecondFactor(user, req.Code, ""); err != nil {
+			if errors.Is(err, services.ErrTOTPNotEnabled) {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			respondWithError(w, http.StatusUnauthorized, "Invalid two-factor code")
+			return
+		}
+
+		codes, err := authService.RegenerateRecoveryCodes(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate recovery codes")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+	}
+}
+
+// currentFullUser loads the authenticated user from the database, since the
+// context user is built from token claims and lacks credentials
+func currentFullUser(w http.ResponseWriter, r *http.Request, authService *services.AuthService) (*auth.User, bool) {
+	user, ok := r.Context().Value("user").(*auth.User)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return nil, false
+	}
+
+	fullUser, err := authService.GetUserByID(user.ID.String())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not found")
+		return nil, false
+	}
+	return fullUser, true
+}
+
+// twoFactorRequired reports whether the settings require 2FA for the user's role
+func twoFactorRequired(settingsService *services.SettingsService, user *auth.User) bool {
+	if settingsService == nil {
+		return false
+	}
+	settings, err := settingsService.GetSettings()
+	if err != nil {
+		return false
+	}
+	return settings.RequiresTwoFactor(user.Role)
+}
+
+// twoFactorSetupRequired reports whether the user must set up 2FA before using
+// their session. Either TOTP or a passkey will do.
+func twoFactorSetupRequired(authService *services.AuthService, settingsService *services.SettingsService, user *auth.User) bool {
+	if user.GetTOTPSecretKey() != "" || !twoFactorRequired(settingsService, user) {
+		return false
+	}
+	hasPasskeys, err := authService.HasPasskeys(user.ID)
+	return err != nil || !hasPasskeys
+}
+
+// secondFactorMethods lists the second factors a user has set up
+func secondFactorMethods(user *auth.User, hasPasskeys bool) []string {
+	var methods []string
+	if user.GetTOTPSecretKey() != "" {
+		methods = append(methods, "totp")
+	}
+	if hasPasskeys {
+		methods = append(methods, "passkey")
+	}
+	return methods
+}
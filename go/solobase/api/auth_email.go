@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mailSendTimeout bounds how long a background email delivery may take
+const mailSendTimeout = time.Minute
+
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+type ConfirmEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// HandleConfirmEmail confirms the email address of the user a confirmation link was sent to
+func HandleConfirmEmail(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ConfirmEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if _, err := authService.ConfirmEmail(req.Token); err != nil {
+			if errors.Is(err, services.ErrInvalidConfirmToken) {
+				respondWithError(w, http.StatusBadRequest, "This confirmation link is invalid or has already been used")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to confirm email")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Email confirmed"})
+	}
+}
+
+// HandleResendConfirmation sends a new confirmation link. The response is the
+// same whether or not the account exists.
+func HandleResendConfirmation(authService *services.AuthService, settingsService *services.SettingsService, mailService *services.MailService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req EmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if user, err := authService.GetUserByEmail(req.Email); err == nil && !user.Confirmed {
+			sendConfirmationEmail(authService, settingsService, mailService, user)
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{
+			"message": "If the account exists and is not confirmed yet, a confirmation email has been sent",
+		})
+	}
+}
+
+// HandleForgotPassword emails a password reset link. The response is the same
+// whether or not the account exists.
+func HandleForgotPassword(authService *services.AuthService, settingsService *services.SettingsService, mailService *services.MailService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req EmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if !mailService.Enabled() {
+			respondWithError(w, http.StatusServiceUnavailable, "Password recovery is not available because email is not configured")
+			return
+		}
+
+		if user, err := authService.GetUserByEmail(req.Email); err == nil {
+			token, err := authService.CreatePasswordResetToken(user.ID)
+			if err != nil {
+				log.Printf("Failed to create password reset token for %s: %v", user.Email, err)
+			} else {
+				resetURL := appLink(settingsService, "/auth/reset-password", token)
+				sendMailAsync("password reset", user, func(ctx context.Context) error {
+					return mailService.SendPasswordReset(ctx, user, resetURL, services.PasswordResetTTL)
+				})
+			}
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{
+			"message": "If the account exists, a password reset email has been sent",
+		})
+	}
+}
+
+// HandleResetPassword sets a new password using the token from a reset email
+func HandleResetPassword(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ResetPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if !checkNewPassword(w, settingsService, req.Password, "") {
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to process password")
+			return
+		}
+
+		if _, err := authService.ResetPassword(req.Token, string(hashedPassword)); err != nil {
+			if errors.Is(err, services.ErrInvalidResetToken) {
+				respondWithError(w, http.StatusBadRequest, "This password reset link is invalid or has expired")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to reset password")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Password has been reset, please log in"})
+	}
+}
+
+// sendConfirmationEmail sends the welcome email with a fresh confirmation link.
+// Nothing is sent when email is not configured.
+func sendConfirmationEmail(authService *services.AuthService, settingsService *services.SettingsService, mailService *services.MailService, user *auth.User) {
+	if !mailService.Enabled() {
+		if emailConfirmationRequired(settingsService) {
+			log.Printf("Warning: email confirmation is required but email is not configured, %s cannot confirm", user.Email)
+		}
+		return
+	}
+
+	token, err := authService.CreateConfirmToken(user.ID)
+	if err != nil {
+		log.Printf("Failed to create confirmation token for %s: %v", user.Email, err)
+		return
+	}
+
+	confirmURL := appLink(settingsService, "/auth/confirm", token)
+	sendMailAsync("welcome", user, func(ctx context.Context) error {
+		return mailService.SendWelcome(ctx, user, confirmURL)
+	})
+}
+
+// sendMailAsync delivers an email in the background so slow mail servers do
+// not hold up the request and timing does not reveal which accounts exist
+func sendMailAsync(kind string, user *auth.User, send func(ctx context.Context) error) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		if err := send(ctx); err != nil {
+			log.Printf("Failed to send %s email to %s: %v", kind, user.Email, err)
+		}
+	}()
+}
+
+// checkEmailConfirmed responds with 403 and returns false when the user has
+// to confirm their email address before logging in
+func checkEmailConfirmed(w http.ResponseWriter, settingsService *services.SettingsService, user *auth.User) bool {
+	var settings *models.AppSettings
+	if settingsService != nil {
+		settings, _ = settingsService.GetSettings()
+	}
+	if err := services.CheckEmailConfirmed(user, settings); err != nil {
+		respondWithError(w, http.StatusForbidden, "Please confirm your email address before logging in")
+		return false
+	}
+	return true
+}
+
+// emailConfirmationRequired reports whether users must confirm their email before logging in
+func emailConfirmationRequired(settingsService *services.SettingsService) bool {
+	if settingsService == nil {
+		return false
+	}
+	settings, err := settingsService.GetSettings()
+	return err == nil && settings.RequireEmailConfirmation
+}
+
+// appLink builds a link to a page of the app carrying a token
+func appLink(settingsService *services.SettingsService, path, token string) string {
+	return appBaseURL(settingsService) + path + "?token=" + url.QueryEscape(token)
+}
+
+// appBaseURL returns the public URL of the app from the settings, without a trailing slash
+func appBaseURL(settingsService *services.SettingsService) string {
+	if settingsService != nil {
+		if settings, err := settingsService.GetSettings(); err == nil && settings.AppURL != "" {
+			return strings.TrimRight(settings.AppURL, "/")
+		}
+	}
+	return strings.TrimRight(models.DefaultSettings().AppURL, "/")
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/constants"
+	"github.com/suppers-ai/solobase/services"
+)
+
+const (
+	// ImpersonationTTL is how long an impersonation token lasts by default
+	ImpersonationTTL = 15 * time.Minute
+	// MaxImpersonationTTL caps the lifetime an admin can ask for
+	MaxImpersonationTTL = services.MaxImpersonationTTL
+)
+
+// Impersonation identifies the admin acting as the user of a token. Banner is
+// the text the UI shows for as long as the token is used.
+type Impersonation struct {
+	AdminID    string `json:"admin_id"`
+	AdminEmail string `json:"admin_email"`
+	Banner     string `json:"banner"`
+}
+
+type ImpersonateRequest struct {
+	// Reason is written to the audit log
+	Reason  string `json:"reason"`
+	Minutes int    `json:"minutes"`
+}
+
+type ImpersonateResponse struct {
+	Token         string         `json:"token"`
+	ExpiresIn     int            `json:"expires_in"`
+	User          *auth.User     `json:"user"`
+	Impersonation *Impersonation `json:"impersonation"`
+}
+
+// HandleImpersonateUser issues a short-lived token that lets an admin act as
+// another user. The token has no refresh token and stops working when the
+// admin's own session is revoked.
+func HandleImpersonateUser(userService *services.UserService, rbacService *services.RBACService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := r.Context().Value("user").(*auth.User)
+		if impersonatorID(r) != "" {
+			respondWithError(w, http.StatusForbidden, "Already impersonating a user")
+			return
+		}
+		// API keys have no session to tie the token to
+		sessionID, _ := r.Context().Value("sessionID").(string)
+		if sessionID == "" {
+			respondWithError(w, http.StatusForbidden, "Impersonation requires a signed-in session")
+			return
+		}
+
+		var req ImpersonateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		ttl := ImpersonationTTL
+		if req.Minutes > 0 {
+			ttl = time.Duration(req.Minutes) * time.Minute
+		}
+		if ttl > MaxImpersonationTTL {
+			ttl = MaxImpersonationTTL
+		}
+
+		target, err := userService.GetUserByID(mux.Vars(r)["id"])
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		switch {
+		case target.ID == admin.ID:
+			respondWithError(w, http.StatusBadRequest, "Cannot impersonate yourself")
+			return
+		case target.Role == constants.RoleDeleted.String():
+			respondWithError(w, http.StatusBadRequest, "Cannot impersonate a deleted user")
+			return
+		case rbacService.NewPermissionChecker(target.ID.String(), target.Role).Has(services.PermissionUsersImpersonate):
+			// Admins impersonating each other would hide who did what
+			respondWithError(w, http.StatusForbidden, "Cannot impersonate a user who can impersonate others")
+			return
+		}
+
+		impersonation := &Impersonation{
+			AdminID:    admin.ID.String(),
+			AdminEmail: admin.Email,
+			Banner:     fmt.Sprintf("You are signed in as %s by %s", target.Email, admin.Email),
+		}
+		token, err := generateImpersonationToken(target, impersonation, sessionID, ttl)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		reason := strings.TrimSpace(req.Reason)
+		if reason == "" {
+			reason = "none given"
+		}
+		log.Printf("Impersonation: %s started acting as %s for %s (reason: %s)", admin.Email, target.Email, ttl, reason)
+
+		respondWithJSON(w, http.StatusOK, ImpersonateResponse{
+			Token:         token,
+			ExpiresIn:     int(ttl.Seconds()),
+			User:          target,
+			Impersonation: impersonation,
+		})
+	}
+}
+
+// generateImpersonationToken signs an access token for the target user that
+// also names the admin. It is tied to the admin's session.
+func generateImpersonationToken(target *auth.User, impersonation *Impersonation, sessionID string, ttl time.Duration) (string, error) {
+	claims := &Claims{
+		UserID:        target.ID.String(),
+		Email:         target.Email,
+		Role:          target.Role,
+		SessionID:     sessionID,
+		Impersonation: impersonation,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// withImpersonation records the impersonating admin in the request context and
+// flags the request in the request log
+func withImpersonation(ctx context.Context, impersonation *Impersonation) context.Context {
+	ctx = context.WithValue(ctx, "impersonatorID", impersonation.AdminID)
+	ctx = context.WithValue(ctx, "impersonation", impersonation)
+	if audit := services.RequestAuditFromContext(ctx); audit != nil {
+		audit.ImpersonatorID = impersonation.AdminID
+	}
+	return ctx
+}
+
+// impersonatorID returns the admin impersonating the caller, or ""
+func impersonatorID(r *http.Request) string {
+	id, _ := r.Context().Value("impersonatorID").(string)
+	return id
+}
+
+// DenyWhileImpersonating blocks account changes that only the user themselves
+// should make, such as changing the password or deleting an account
+func DenyWhileImpersonating(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if impersonatorID(r) != "" {
+			respondWithError(w, http.StatusForbidden, "Not allowed while impersonating a user")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+)
+
+type UnlockAccountRequest struct {
+	Token string `json:"token"`
+}
+
+// LockoutsResponse lists the lockouts currently in effect
+type LockoutsResponse struct {
+	Accounts []services.LockedAccount `json:"accounts"`
+	// Clients are addresses locked out of a single account
+	Clients []models.LoginAttempt `json:"clients"`
+}
+
+// HandleUnlockAccount unlocks an account with the token from the lockout email
+func HandleUnlockAccount(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UnlockAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if _, err := authService.UnlockWithToken(req.Token); err != nil {
+			if errors.Is(err, services.ErrInvalidUnlockToken) {
+				respondWithError(w, http.StatusBadRequest, "This unlock link is invalid or has expired")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to unlock account")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Your account has been unlocked, you can log in again"})
+	}
+}
+
+// HandleListLockouts lists the locked accounts and locked out clients for admins
+func HandleListLockouts(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accounts, clients, err := authService.ListLockedAccounts(lockoutPolicy(settingsService))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch locked accounts")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, LockoutsResponse{Accounts: accounts, Clients: clients})
+	}
+}
+
+// HandleUnlockUser lets an admin clear a user's failed logins
+func HandleUnlockUser(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(*auth.User)
+		unlocked, err := authService.UnlockAccount(mux.Vars(r)["id"])
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		log.Printf("Account %s unlocked by %s", unlocked.Email, user.Email)
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Account unlocked"})
+	}
+}
+
+// lockoutPolicy reads the lockout thresholds from the settings
+func lockoutPolicy(settingsService *services.SettingsService) services.LockoutPolicy {
+	return services.LockoutPolicyFromSettings(currentSettings(settingsService))
+}
+
+// respondLockedOut refuses a login during a lockout, telling the client when to retry
+func respondLockedOut(w http.ResponseWriter, until time.Time) {
+	if wait := time.Until(until); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, please try again later")
+}
+
+// sendUnlockEmail emails a user whose account was just locked a link to unlock it
+func sendUnlockEmail(authService *services.AuthService, settingsService *services.SettingsService, mailService *services.MailService, user *auth.User, lockedUntil time.Time) {
+	if user == nil || mailService == nil || !mailService.Enabled() {
+		return
+	}
+
+	token, err := authService.CreateUnlockToken(user.ID)
+	if err != nil {
+		log.Printf("Failed to create unlock token for %s: %v", user.Email, err)
+		return
+	}
+
+	unlockURL := appLink(settingsService, "/auth/unlock", token)
+	sendMailAsync("account locked", user, func(ctx context.Context) error {
+		return mailService.SendAccountLocked(ctx, user, unlockURL, lockedUntil)
+	})
+}
+
+// executeLoginFailedHooks runs the post_login hooks for a failed password
+// login with success set to false, so extensions can react to attacks
+func executeLoginFailedHooks(w http.ResponseWriter, r *http.Request, email, ip string, failure *services.LoginFailedError, storageService *services.StorageService, extensionRegistry *core.ExtensionRegistry) {
+	if extensionRegistry == nil {
+		return
+	}
+
+	appID := "solobase"
+	if storageService != nil {
+		appID = storageService.GetAppID()
+	}
+
+	data := map[string]interface{}{
+		"success":   false,
+		"reason":    failure.Reason,
+		"userEmail": email,
+		"ipAddress": ip,
+		"appID":     appID,
+	}
+	if failure.User != nil {
+		data["userID"] = failure.User.ID.String()
+		data["userRole"] = failure.User.Role
+		data["failedAttempts"] = failure.User.AttemptCount
+	}
+	if !failure.LockedUntil.IsZero() {
+		data["lockedUntil"] = failure.LockedUntil
+	}
+
+	hookCtx := &core.HookContext{Request: r, Response: w, Data: data}
+	if err := extensionRegistry.ExecuteHooks(r.Context(), core.HookPostLogin, hookCtx); err != nil {
+		log.Printf("Warning: PostLogin hook failed: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/suppers-ai/solobase/constants"
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+)
+
+type MagicLinkLoginRequest struct {
+	Token string `json:"token"`
+}
+
+// HandleRequestMagicLink emails a single-use login link. When signups are
+// allowed, unknown addresses get a link that creates their account once it is
+// opened. The response is the same whether or not a link was sent.
+func HandleRequestMagicLink(authService *services.AuthService, settingsService *services.SettingsService, mailService *services.MailService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req EmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		settings, ok := magicLinkSettings(w, settingsService)
+		if !ok {
+			return
+		}
+		if !mailService.Enabled() {
+			respondWithError(w, http.StatusServiceUnavailable, "Login links are not available because email is not configured")
+			return
+		}
+
+		user, err := authService.MagicLinkUser(req.Email, settings.AllowSignup)
+		switch {
+		case errors.Is(err, services.ErrSignupDisabled):
+		case err != nil:
+			log.Printf("Failed to find the account for a login link to %s: %v", req.Email, err)
+		case user.Role == constants.RoleDeleted.String():
+		default:
+			token, err := authService.CreateMagicLinkToken(user)
+			if err != nil {
+				log.Printf("Failed to create login link for %s: %v", user.Email, err)
+				break
+			}
+			loginURL := appLink(settingsService, "/auth/magic-link", token)
+			sendMailAsync("login link", user, func(ctx context.Context) error {
+				return mailService.SendMagicLink(ctx, user, loginURL, services.MagicLinkTTL)
+			})
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{
+			"message": "If this address can log in, a login link has been sent",
+		})
+	}
+}
+
+// HandleMagicLinkLogin exchanges the token from a login link for a session.
+// Users with two-factor authentication still have to enter a code.
+func HandleMagicLinkLogin(authService *services.AuthService, settingsService *services.SettingsService, storageService *services.StorageService, extensionRegistry *core.ExtensionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MagicLinkLoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if _, ok := magicLinkSettings(w, settingsService); !ok {
+			return
+		}
+
+		user, err := authService.ConsumeMagicLinkToken(req.Token)
+		if err != nil {
+			if !errors.Is(err, services.ErrInvalidMagicLink) {
+				log.Printf("Failed to use login link: %v", err)
+			}
+			respondWithError(w, http.StatusUnauthorized, "This login link is invalid or has expired")
+			return
+		}
+		if user.Role == constants.RoleDeleted.String() {
+			respondWithError(w, http.StatusUnauthorized, "This login link is invalid or has expired")
+			return
+		}
+
+		continueLogin(w, r, user, authService, settingsService, storageService, extensionRegistry)
+	}
+}
+
+// magicLinkSettings returns the app settings, responding with 404 when
+// magic link login is turned off
+func magicLinkSettings(w http.ResponseWriter, settingsService *services.SettingsService) (*models.AppSettings, bool) {
+	settings, err := settingsService.GetSettings()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to load settings")
+		return nil, false
+	}
+	if !settings.EnableMagicLink {
+		respondWithError(w, http.StatusNotFound, "Login links are not enabled")
+		return nil, false
+	}
+	return settings, true
+}
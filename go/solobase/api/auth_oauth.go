@@ -0,0 +1,337 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+)
+
+const (
+	// oauthCookieName holds the signed state of a provider login in progress
+	oauthCookieName = "solobase_oauth"
+	oauthCookiePath = "/api/auth/oauth"
+	oauthLoginTTL   = 10 * time.Minute
+)
+
+// OAuthProviderInfo is the public description of a login provider shown on the login page
+type OAuthProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Preset      string `json:"preset,omitempty"`
+}
+
+// OAuthProviderSettings is a provider as shown to admins
+type OAuthProviderSettings struct {
+	models.OAuthProvider
+	HasClientSecret bool `json:"has_client_secret"`
+	// RedirectURL must be registered with the provider
+	RedirectURL string `json:"redirect_url"`
+}
+
+type SaveOAuthProviderRequest struct {
+	DisplayName  string   `json:"display_name"`
+	Preset       string   `json:"preset"`
+	IssuerURL    string   `json:"issuer_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"` // Leave empty to keep the stored secret
+	Scopes       []string `json:"scopes"`
+	Enabled      bool     `json:"enabled"`
+}
+
+type OAuthExchangeRequest struct {
+	Code string `json:"code"`
+}
+
+// oauthStateClaims carry a login request in the state cookie, signed so the
+// browser cannot alter the nonce or PKCE verifier
+type oauthStateClaims struct {
+	services.OAuthLoginRequest
+	Redirect string `json:"redirect,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// HandleListOAuthProviders lists the enabled login providers
+func HandleListOAuthProviders(oauthService *services.OAuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		providers, err := oauthService.ListEnabledProviders()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch login providers")
+			return
+		}
+
+		infos := make([]OAuthProviderInfo, 0, len(providers))
+		for _, p := range providers {
+			infos = append(infos, OAuthProviderInfo{Name: p.Name, DisplayName: p.DisplayName, Preset: p.Preset})
+		}
+		respondWithJSON(w, http.StatusOK, infos)
+	}
+}
+
+// HandleOAuthAuthorize starts a provider login by redirecting the browser to the provider
+func HandleOAuthAuthorize(oauthService *services.OAuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, err := oauthService.GetProvider(mux.Vars(r)["provider"])
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "Login provider not found")
+			return
+		}
+
+		loginRequest, err := services.NewOAuthLoginRequest(provider.Name)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to start login")
+			return
+		}
+
+		authURL, err := oauthService.AuthCodeURL(r.Context(), provider, oauthCallbackURL(settingsService, provider.Name), loginRequest)
+		if err != nil {
+			log.Printf("Failed to start %s login: %v", provider.Name, err)
+			respondWithError(w, http.StatusBadGateway, "Login provider is unavailable")
+			return
+		}
+
+		claims := &oauthStateClaims{
+			OAuthLoginRequest: *loginRequest,
+			Redirect:          safeRedirectPath(r.URL.Query().Get("redirect")),
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(oauthLoginTTL)),
+			},
+		}
+		state, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to start login")
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     oauthCookieName,
+			Value:    state,
+			Path:     oauthCookiePath,
+			MaxAge:   int(oauthLoginTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil || strings.HasPrefix(appBaseURL(settingsService), "https://"),
+			// Lax lets the cookie through on the provider's top-level redirect back
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// HandleOAuthCallback finishes a provider login. The browser is sent back to
+// the app with a one-time code that the app exchanges for a session.
+func HandleOAuthCallback(authService *services.AuthService, oauthService *services.OAuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		providerName := mux.Vars(r)["provider"]
+		query := r.URL.Query()
+
+		// The state cookie is single use
+		http.SetCookie(w, &http.Cookie{Name: oauthCookieName, Path: oauthCookiePath, MaxAge: -1, HttpOnly: true})
+
+		fail := func(message string) {
+			http.Redirect(w, r, appBaseURL(settingsService)+"/auth/login?oauth_error="+url.QueryEscape(message), http.StatusFound)
+		}
+
+		if providerError := query.Get("error"); providerError != "" {
+			log.Printf("%s login returned an error: %s %s", providerName, providerError, query.Get("error_description"))
+			fail("Login was cancelled or denied")
+			return
+		}
+
+		claims, err := parseOAuthState(r)
+		if err != nil || claims.Provider != providerName || claims.State == "" || claims.State != query.Get("state") {
+			fail("Login session expired, please try again")
+			return
+		}
+
+		provider, err := oauthService.GetProvider(providerName)
+		if err != nil {
+			fail("Login provider not found")
+			return
+		}
+
+		identity, err := oauthService.Exchange(r.Context(), provider, oauthCallbackURL(settingsService, providerName), query.Get("code"), &claims.OAuthLoginRequest)
+		if err != nil {
+			log.Printf("%s login failed: %v", providerName, err)
+			fail("Login with " + provider.DisplayName + " failed")
+			return
+		}
+
+		allowSignup := true
+		if settings, err := settingsService.GetSettings(); err == nil {
+			allowSignup = settings.AllowSignup
+		}
+
+		user, err := authService.LoginWithIdentity(identity, allowSignup)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrOAuthEmailRequired), errors.Is(err, services.ErrOAuthEmailUnverified), errors.Is(err, services.ErrSignupDisabled):
+				fail(err.Error())
+			case errors.Is(err, services.ErrOAuthAccountDeleted):
+				fail("Login with " + provider.DisplayName + " failed")
+			default:
+				log.Printf("Failed to log in %s identity %s: %v", providerName, identity.Subject, err)
+				fail("Login failed")
+			}
+			return
+		}
+
+		code, err := authService.CreateOAuthLoginCode(user.ID)
+		if err != nil {
+			fail("Login failed")
+			return
+		}
+
+		target := appBaseURL(settingsService) + "/auth/oauth?code=" + url.QueryEscape(code)
+		if claims.Redirect != "" {
+			target += "&redirect=" + url.QueryEscape(claims.Redirect)
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// HandleOAuthExchange trades the one-time code from a provider login for a
+// session, subject to the same checks as a password login
+func HandleOAuthExchange(authService *services.AuthService, settingsService *services.SettingsService, storageService *services.StorageService, extensionRegistry *core.ExtensionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req OAuthExchangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		user, err := authService.ConsumeOAuthLoginCode(req.Code)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Login expired, please try again")
+			return
+		}
+
+		continueLogin(w, r, user, authService, settingsService, storageService, extensionRegistry)
+	}
+}
+
+// HandleListIdentities lists the provider accounts linked to the current user
+func HandleListIdentities(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value("user").(*auth.User)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		identities, err := authService.ListIdentities(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch linked accounts")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, identities)
+	}
+}
+
+// HandleGetOAuthProviderSettings lists every configured login provider for admins
+func HandleGetOAuthProviderSettings(oauthService *services.OAuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		providers, err := oauthService.ListProviders()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch login providers")
+			return
+		}
+
+		response := make([]OAuthProviderSettings, 0, len(providers))
+		for _, p := range providers {
+			response = append(response, OAuthProviderSettings{
+				OAuthProvider:   p,
+				HasClientSecret: p.ClientSecret != "",
+				RedirectURL:     oauthCallbackURL(settingsService, p.Name),
+			})
+		}
+		respondWithJSON(w, http.StatusOK, response)
+	}
+}
+
+// HandleSaveOAuthProvider creates or updates a login provider
+func HandleSaveOAuthProvider(oauthService *services.OAuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SaveOAuthProviderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		provider := &models.OAuthProvider{
+			Name:         mux.Vars(r)["name"],
+			DisplayName:  req.DisplayName,
+			Preset:       req.Preset,
+			IssuerURL:    strings.TrimSpace(req.IssuerURL),
+			ClientID:     strings.TrimSpace(req.ClientID),
+			ClientSecret: req.ClientSecret,
+			Scopes:       req.Scopes,
+			Enabled:      req.Enabled,
+		}
+		if err := oauthService.SaveProvider(provider); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, OAuthProviderSettings{
+			OAuthProvider:   *provider,
+			HasClientSecret: provider.ClientSecret != "",
+			RedirectURL:     oauthCallbackURL(settingsService, provider.Name),
+		})
+	}
+}
+
+// HandleDeleteOAuthProvider removes a login provider
+func HandleDeleteOAuthProvider(oauthService *services.OAuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := oauthService.DeleteProvider(mux.Vars(r)["name"]); err != nil {
+			if errors.Is(err, services.ErrOAuthProviderNotFound) {
+				respondWithError(w, http.StatusNotFound, "Login provider not found")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to delete login provider")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Login provider deleted"})
+	}
+}
+
+// parseOAuthState verifies the state cookie set when the login started
+func parseOAuthState(r *http.Request) (*oauthStateClaims, error) {
+	cookie, err := r.Cookie(oauthCookieName)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &oauthStateClaims{}
+	_, err = jwt.ParseWithClaims(cookie.Value, claims, func(t *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// oauthCallbackURL is the redirect URL registered with a provider
+func oauthCallbackURL(settingsService *services.SettingsService, provider string) string {
+	return appBaseURL(settingsService) + oauthCookiePath + "/" + url.PathEscape(provider) + "/callback"
+}
+
+// safeRedirectPath only allows paths within the app, so a login link cannot
+// send the user to another site
+func safeRedirectPath(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.Contains(redirect, "\\") {
+		return ""
+	}
+	return redirect
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/suppers-ai/solobase/constants"
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/services"
+)
+
+// PasskeyOptionsResponse wraps ceremony options so they can be passed to
+// navigator.credentials as they are
+type PasskeyOptionsResponse struct {
+	PublicKey interface{} `json:"publicKey"`
+}
+
+type PasskeyMFAOptionsRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+type RegisterPasskeyRequest struct {
+	Name       string                        `json:"name"`
+	Credential *services.AttestationResponse `json:"credential"`
+}
+
+// HandlePasskeyLoginOptions starts a passwordless login with a passkey
+func HandlePasskeyLoginOptions(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rp, ok := relyingParty(w, settingsService)
+		if !ok {
+			return
+		}
+
+		options, err := authService.BeginPasskeyLogin(rp)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to start passkey login")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, PasskeyOptionsResponse{PublicKey: options})
+	}
+}
+
+// HandlePasskeyLogin logs a user in with a passkey instead of a password. The
+// authenticator verified the user, so no second factor is asked for.
+func HandlePasskeyLogin(authService *services.AuthService, settingsService *services.SettingsService, storageService *services.StorageService, extensionRegistry *core.ExtensionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req services.AssertionResponse
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		rp, ok := relyingParty(w, settingsService)
+		if !ok {
+			return
+		}
+
+		user, err := authService.FinishPasskeyLogin(rp, &req)
+		if err != nil {
+			logPasskeyFailure("login", err)
+			respondWithError(w, http.StatusUnauthorized, "Passkey login failed")
+			return
+		}
+		if user.Role == constants.RoleDeleted.String() {
+			respondWithError(w, http.StatusUnauthorized, "Passkey login failed")
+			return
+		}
+		if !checkEmailConfirmed(w, settingsService, user) {
+			return
+		}
+
+		completeLogin(w, r, user, authService, settingsService, storageService, extensionRegistry)
+	}
+}
+
+// HandlePasskeyMFAOptions starts the passkey check for a login waiting for its
+// second factor. The MFA token stays valid for POST /auth/login/2fa.
+func HandlePasskeyMFAOptions(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PasskeyMFAOptionsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		user, err := authService.MFAChallengeUser(req.MFAToken)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Two-factor login expired, please sign in again")
+			return
+		}
+
+		rp, ok := relyingParty(w, settingsService)
+		if !ok {
+			return
+		}
+
+		options, err := authService.BeginPasskeyMFA(user.ID, rp)
+		if err != nil {
+			if errors.Is(err, services.ErrPasskeyNotFound) {
+				respondWithError(w, http.StatusBadRequest, "No passkeys are registered")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to start passkey login")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, PasskeyOptionsResponse{PublicKey: options})
+	}
+}
+
+// HandleListPasskeys lists the current user's passkeys
+func HandleListPasskeys(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentFullUser(w, r, authService)
+		if !ok {
+			return
+		}
+
+		passkeys, err := authService.ListPasskeys(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to list passkeys")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, passkeys)
+	}
+}
+
+// HandlePasskeyRegistrationOptions starts registering a passkey for the current user
+func HandlePasskeyRegistrationOptions(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentFullUser(w, r, authService)
+		if !ok {
+			return
+		}
+
+		rp, ok := relyingParty(w, settingsService)
+		if !ok {
+			return
+		}
+
+		options, err := authService.BeginPasskeyRegistration(user, rp)
+		if err != nil {
+			if errors.Is(err, services.ErrTooManyPasskeys) {
+				respondWithError(w, http.StatusConflict, "You have registered the maximum number of passkeys")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to start passkey registration")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, PasskeyOptionsResponse{PublicKey: options})
+	}
+}
+
+// HandleRegisterPasskey stores the passkey created by the browser. A passkey
+// is also a second factor, so it completes a required 2FA setup.
+func HandleRegisterPasskey(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentFullUser(w, r, authService)
+		if !ok {
+			return
+		}
+
+		var req RegisterPasskeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Credential == nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		rp, ok := relyingParty(w, settingsService)
+		if !ok {
+			return
+		}
+
+		passkey, err := authService.FinishPasskeyRegistration(user, rp, req.Name, req.Credential)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrPasskeyExists):
+				respondWithError(w, http.StatusConflict, "This passkey is already registered")
+			case errors.Is(err, services.ErrInvalidPasskeyChallenge):
+				respondWithError(w, http.StatusBadRequest, "Passkey registration expired, please start again")
+			case errors.Is(err, services.ErrInvalidWebAuthnResponse):
+				logPasskeyFailure("registration", err)
+				respondWithError(w, http.StatusBadRequest, "Invalid passkey")
+			default:
+				log.Printf("Failed to register passkey for %s: %v", user.Email, err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to register passkey")
+			}
+			return
+		}
+
+		response := map[string]interface{}{"passkey": passkey}
+
+		// Replace a token that was restricted to 2FA setup
+		if sessionID, _ := r.Context().Value("sessionID").(string); sessionID != "" {
+			orgID, _ := r.Context().Value("orgID").(string)
+			if token, err := generateToken(user, sessionID, orgID, false, sessionPolicy(settingsService).AccessTokenTTL()); err == nil {
+				response["token"] = token
+			}
+		}
+
+		respondWithJSON(w, http.StatusCreated, response)
+	}
+}
+
+// HandleDeletePasskey removes one of the current user's passkeys. Users whose
+// role requires 2FA cannot remove the last factor they have.
+func HandleDeletePasskey(authService *services.AuthService, settingsService *services.SettingsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentFullUser(w, r, authService)
+		if !ok {
+			return
+		}
+
+		if user.GetTOTPSecretKey() == "" && twoFactorRequired(settingsService, user) {
+			passkeys, err := authService.ListPasskeys(user.ID)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Failed to delete passkey")
+				return
+			}
+			if len(passkeys) <= 1 {
+				respondWithError(w, http.StatusForbidden, "Two-factor authentication is required for your role")
+				return
+			}
+		}
+
+		if err := authService.DeletePasskey(user.ID, mux.Vars(r)["id"]); err != nil {
+			if errors.Is(err, services.ErrPasskeyNotFound) {
+				respondWithError(w, http.StatusNotFound, "Passkey not found")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to delete passkey")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Passkey deleted"})
+	}
+}
+
+// relyingParty returns the site passkeys are registered with, which is the
+// host of the app URL in the settings
+func relyingParty(w http.ResponseWriter, settingsService *services.SettingsService) (services.RelyingParty, bool) {
+	name := "Solobase"
+	if settingsService != nil {
+		if settings, err := settingsService.GetSettings(); err == nil && settings.AppName != "" {
+			name = settings.AppName
+		}
+	}
+
+	rp, err := services.RelyingPartyFromURL(appBaseURL(settingsService), name)
+	if err != nil {
+		log.Printf("Passkeys are unavailable: %v", err)
+		respondWithError(w, http.StatusServiceUnavailable, "Passkeys are not available because the app URL is not set")
+		return services.RelyingParty{}, false
+	}
+	return rp, true
+}
+
+// logPasskeyFailure logs why a passkey was rejected. A counter going backwards
+// suggests a cloned authenticator, so it stands out in the log.
+func logPasskeyFailure(ceremony string, err error) {
+	if errors.Is(err, services.ErrPasskeyCloned) {
+		log.Printf("WARNING: passkey %s rejected, the authenticator may have been cloned: %v", ceremony, err)
+		return
+	}
+	log.Printf("Passkey %s failed: %v", ceremony, err)
+}
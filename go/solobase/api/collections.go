@@ -2,41 +2,125 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/dynamicfields"
+	"github.com/suppers-ai/solobase/constants"
+	"github.com/suppers-ai/solobase/models"
 	"github.com/suppers-ai/solobase/services"
 )
 
 type Collection struct {
-	ID           string           `json:"id"`
-	Name         string           `json:"name"`
-	Schema       CollectionSchema `json:"schema"`
-	CreatedAt    time.Time        `json:"created_at"`
-	UpdatedAt    time.Time        `json:"updated_at"`
-	RecordsCount int              `json:"records_count"`
+	ID             string                `json:"id"`
+	Name           string                `json:"name"`
+	DisplayName    string                `json:"display_name,omitempty"`
+	Description    string                `json:"description,omitempty"`
+	Schema         *dynamicfields.Schema `json:"schema"`
+	AuthRules      models.JSON           `json:"auth_rules,omitempty"`
+	OrganizationID *string               `json:"organization_id,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+	RecordsCount   int                   `json:"records_count"`
 }
 
-type CollectionSchema struct {
-	Fields []CollectionField `json:"fields"`
+type CreateCollectionRequest struct {
+	Name        string                 `json:"name"`
+	DisplayName string                 `json:"display_name,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Schema      *dynamicfields.Schema  `json:"schema"`
+	AuthRules   map[string]interface{} `json:"auth_rules,omitempty"`
+	// OrganizationID defaults to the caller's active organization
+	OrganizationID *string `json:"organization_id,omitempty"`
 }
 
-type CollectionField struct {
-	Name     string      `json:"name"`
-	Type     string      `json:"type"`
-	Required bool        `json:"required"`
-	Unique   bool        `json:"unique,omitempty"`
-	Default  interface{} `json:"default,omitempty"`
-	Options  interface{} `json:"options,omitempty"`
+type PaginatedRecordsResponse struct {
+	Data       []models.CollectionRecord `json:"data"`
+	Total      int                       `json:"total"`
+	Page       int                       `json:"page"`
+	PageSize   int                       `json:"page_size"`
+	TotalPages int                       `json:"total_pages"`
+	NextCursor string                    `json:"next_cursor,omitempty"`
 }
 
-type CreateCollectionRequest struct {
-	Name   string           `json:"name"`
-	Schema CollectionSchema `json:"schema"`
+func toCollectionResponse(c *models.Collection, recordsCount int) Collection {
+	schema, err := c.ParsedSchema()
+	if err != nil {
+		schema = &dynamicfields.Schema{Name: c.Name}
+	}
+	return Collection{
+		ID:             c.ID.String(),
+		Name:           c.Name,
+		DisplayName:    c.DisplayName,
+		Description:    c.Description,
+		Schema:         schema,
+		AuthRules:      c.AuthRules,
+		OrganizationID: c.OrganizationID,
+		CreatedAt:      c.CreatedAt,
+		UpdatedAt:      c.UpdatedAt,
+		RecordsCount:   recordsCount,
+	}
+}
+
+// respondWithCollectionError maps collection service errors to HTTP responses
+func respondWithCollectionError(w http.ResponseWriter, err error, fallback string) {
+	var verrs *dynamicfields.ValidationErrors
+	switch {
+	case errors.Is(err, services.ErrCollectionNotFound):
+		respondWithError(w, http.StatusNotFound, "Collection not found")
+	case errors.Is(err, services.ErrRecordNotFound):
+		respondWithError(w, http.StatusNotFound, "Record not found")
+	case errors.Is(err, services.ErrRecordForbidden):
+		respondWithError(w, http.StatusForbidden, "Access denied")
+	case errors.As(err, &verrs):
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "Validation failed",
+			"errors": verrs.Errors,
+		})
+	default:
+		respondWithError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
+// canAccessCollection reports whether the caller has at least minRole in the
+// organization owning a collection. Collections without an organization are
+// open to everyone, and callers with collections.manage can access every
+// collection.
+func canAccessCollection(r *http.Request, access *OrganizationAccess, collection *models.Collection, minRole string) bool {
+	if collection.OrganizationID == nil {
+		return true
+	}
+	if access != nil && access.rbac != nil && hasPermission(access.rbac, r, services.PermissionCollectionsManage) {
+		return true
+	}
+	return access.Can(r, *collection.OrganizationID, minRole)
+}
+
+// findAccessibleCollection loads the collection of the request, hiding
+// organization collections from outsiders and refusing members below minRole
+func findAccessibleCollection(w http.ResponseWriter, r *http.Request, collectionService *services.CollectionService, access *OrganizationAccess, minRole string) (*models.Collection, bool) {
+	collection, err := collectionService.GetCollection(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithCollectionError(w, err, "Failed to fetch collection")
+		return nil, false
+	}
+	if !canAccessCollection(r, access, collection, services.OrgRoleViewer) {
+		respondWithCollectionError(w, services.ErrCollectionNotFound, "")
+		return nil, false
+	}
+	if !canAccessCollection(r, access, collection, minRole) {
+		respondWithError(w, http.StatusForbidden, "Insufficient organization role")
+		return nil, false
+	}
+	return collection, true
 }
 
-func HandleGetCollections(collectionService *services.CollectionService) http.HandlerFunc {
+func HandleGetCollections(collectionService *services.CollectionService, access *OrganizationAccess) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		collections, err := collectionService.GetCollections()
 		if err != nil {
@@ -44,26 +128,39 @@ func HandleGetCollections(collectionService *services.CollectionService) http.Ha
 			return
 		}
 
-		respondWithJSON(w, http.StatusOK, collections)
+		counts, err := collectionService.GetRecordCounts()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch collections")
+			return
+		}
+
+		response := make([]Collection, 0, len(collections))
+		for i := range collections {
+			if !canAccessCollection(r, access, &collections[i], services.OrgRoleViewer) {
+				continue
+			}
+			response = append(response, toCollectionResponse(&collections[i], counts[collections[i].ID]))
+		}
+
+		respondWithJSON(w, http.StatusOK, response)
 	}
 }
 
-func HandleGetCollection(collectionService *services.CollectionService) http.HandlerFunc {
+func HandleGetCollection(collectionService *services.CollectionService, access *OrganizationAccess) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		collectionID := vars["id"]
-
-		collection, err := collectionService.GetCollection(collectionID)
-		if err != nil {
-			respondWithError(w, http.StatusNotFound, "Collection not found")
+		collection, ok := findAccessibleCollection(w, r, collectionService, access, services.OrgRoleViewer)
+		if !ok {
 			return
 		}
 
-		respondWithJSON(w, http.StatusOK, collection)
+		counts, _ := collectionService.GetRecordCounts()
+		respondWithJSON(w, http.StatusOK, toCollectionResponse(collection, counts[collection.ID]))
 	}
 }
 
-func HandleCreateCollection(collectionService *services.CollectionService) http.HandlerFunc {
+// HandleCreateCollection creates a collection. Collections of an
+// organization can be created by its admins.
+func HandleCreateCollection(collectionService *services.CollectionService, access *OrganizationAccess) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req CreateCollectionRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -71,20 +168,41 @@ func HandleCreateCollection(collectionService *services.CollectionService) http.
 			return
 		}
 
-		collection, err := collectionService.CreateCollection(req.Name, req.Schema)
+		orgID := resourceOrganization(r, req.OrganizationID)
+		if orgID != "" && !access.Can(r, orgID, services.OrgRoleAdmin) {
+			respondWithError(w, http.StatusForbidden, "Insufficient organization role")
+			return
+		}
+
+		if _, err := collectionService.GetCollectionByName(req.Name); err == nil {
+			respondWithError(w, http.StatusConflict, "Collection already exists")
+			return
+		}
+
+		collection, err := collectionService.CreateCollection(services.CollectionInput{
+			Name:           req.Name,
+			DisplayName:    req.DisplayName,
+			Description:    req.Description,
+			Schema:         req.Schema,
+			AuthRules:      req.AuthRules,
+			OrganizationID: orgID,
+		})
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Failed to create collection")
+			respondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		respondWithJSON(w, http.StatusCreated, collection)
+		respondWithJSON(w, http.StatusCreated, toCollectionResponse(collection, 0))
 	}
 }
 
-func HandleUpdateCollection(collectionService *services.CollectionService) http.HandlerFunc {
+func HandleUpdateCollection(collectionService *services.CollectionService, access *OrganizationAccess) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		collectionID := vars["id"]
+		existing, ok := findAccessibleCollection(w, r, collectionService, access, services.OrgRoleAdmin)
+		if !ok {
+			return
+		}
+		collectionID := existing.ID.String()
 
 		var updates map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
@@ -94,24 +212,193 @@ func HandleUpdateCollection(collectionService *services.CollectionService) http.
 
 		collection, err := collectionService.UpdateCollection(collectionID, updates)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Failed to update collection")
+			if errors.Is(err, services.ErrCollectionNotFound) {
+				respondWithCollectionError(w, err, "")
+				return
+			}
+			respondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		respondWithJSON(w, http.StatusOK, collection)
+		counts, _ := collectionService.GetRecordCounts()
+		respondWithJSON(w, http.StatusOK, toCollectionResponse(collection, counts[collection.ID]))
 	}
 }
 
-func HandleDeleteCollection(collectionService *services.CollectionService) http.HandlerFunc {
+func HandleDeleteCollection(collectionService *services.CollectionService, access *OrganizationAccess) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		collectionID := vars["id"]
+		collection, ok := findAccessibleCollection(w, r, collectionService, access, services.OrgRoleAdmin)
+		if !ok {
+			return
+		}
 
-		if err := collectionService.DeleteCollection(collectionID); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Failed to delete collection")
+		if err := collectionService.DeleteCollection(collection.ID.String()); err != nil {
+			respondWithCollectionError(w, err, "Failed to delete collection")
 			return
 		}
 
 		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Collection deleted successfully"})
 	}
-}
\ No newline at end of file
+}
+
+// HandleListRecords lists collection records. Supported query parameters:
+// filter, sort, fields, expand, cursor, page and page_size.
+func HandleListRecords(collectionService *services.CollectionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		collection, err := collectionService.GetCollection(mux.Vars(r)["id"])
+		if err != nil {
+			respondWithCollectionError(w, err, "Failed to fetch collection")
+			return
+		}
+
+		params := r.URL.Query()
+
+		page, _ := strconv.Atoi(params.Get("page"))
+		if page < 1 {
+			page = constants.DefaultPage
+		}
+
+		pageSize, _ := strconv.Atoi(params.Get("page_size"))
+		if pageSize < constants.MinPageSize || pageSize > constants.MaxPageSize {
+			pageSize = constants.CollectionsPageSize
+		}
+
+		result, err := collectionService.ListRecords(collection, services.RecordQuery{
+			Auth:     recordAuthFromRequest(r),
+			Filter:   params.Get("filter"),
+			Sort:     params.Get("sort"),
+			Fields:   splitQueryList(params.Get("fields")),
+			Expand:   splitQueryList(params.Get("expand")),
+			Cursor:   params.Get("cursor"),
+			Page:     page,
+			PageSize: pageSize,
+		})
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidRecordQuery) {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			respondWithCollectionError(w, err, "Failed to fetch records")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, PaginatedRecordsResponse{
+			Data:       result.Records,
+			Total:      result.Total,
+			Page:       page,
+			PageSize:   pageSize,
+			TotalPages: (result.Total + pageSize - 1) / pageSize,
+			NextCursor: result.NextCursor,
+		})
+	}
+}
+
+// recordAuthFromRequest describes the authenticated caller that collection
+// access rules are evaluated for
+func recordAuthFromRequest(r *http.Request) *services.RecordAuth {
+	recordAuth := &services.RecordAuth{}
+	if user, ok := r.Context().Value("user").(*auth.User); ok && user != nil {
+		recordAuth.UserID = user.ID.String()
+		recordAuth.Role = user.Role
+	} else if userID, ok := r.Context().Value("userID").(string); ok {
+		recordAuth.UserID = userID
+	}
+	return recordAuth
+}
+
+// splitQueryList splits a comma separated query parameter, dropping empty entries
+func splitQueryList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func HandleGetRecord(collectionService *services.CollectionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		collection, err := collectionService.GetCollection(vars["id"])
+		if err != nil {
+			respondWithCollectionError(w, err, "Failed to fetch collection")
+			return
+		}
+
+		record, err := collectionService.GetRecord(collection, vars["recordId"], recordAuthFromRequest(r))
+		if err != nil {
+			respondWithCollectionError(w, err, "Failed to fetch record")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, record)
+	}
+}
+
+func HandleCreateRecord(collectionService *services.CollectionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		collection, err := collectionService.GetCollection(mux.Vars(r)["id"])
+		if err != nil {
+			respondWithCollectionError(w, err, "Failed to fetch collection")
+			return
+		}
+
+		var data map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		record, err := collectionService.CreateRecord(collection, data, recordAuthFromRequest(r))
+		if err != nil {
+			respondWithCollectionError(w, err, "Failed to create record")
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, record)
+	}
+}
+
+func HandleUpdateRecord(collectionService *services.CollectionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		collection, err := collectionService.GetCollection(vars["id"])
+		if err != nil {
+			respondWithCollectionError(w, err, "Failed to fetch collection")
+			return
+		}
+
+		var data map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		record, err := collectionService.UpdateRecord(collection, vars["recordId"], data, recordAuthFromRequest(r))
+		if err != nil {
+			respondWithCollectionError(w, err, "Failed to update record")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, record)
+	}
+}
+
+func HandleDeleteRecord(collectionService *services.CollectionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		collection, err := collectionService.GetCollection(vars["id"])
+		if err != nil {
+			respondWithCollectionError(w, err, "Failed to fetch collection")
+			return
+		}
+
+		if err := collectionService.DeleteRecord(collection, vars["recordId"], recordAuthFromRequest(r)); err != nil {
+			respondWithCollectionError(w, err, "Failed to delete record")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Record deleted successfully"})
+	}
+}
@@ -154,7 +154,7 @@ func HandleExtensionsManagement() http.HandlerFunc {
         .header-icon {
             width: 60px;
             height: 60px;
-            background: linear-gradient(135deg, #8b5cf6 0%, #7c3aed 100%);
+            background: linear-gradient(135deg, #8b5cf6 0%%, #7c3aed 100%%);
             border-radius: 12px;
             display: flex;
             align-items: center;
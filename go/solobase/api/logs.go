@@ -96,9 +96,10 @@ func HandleGetRequestLogs(logsService *services.LogsService) http.HandlerFunc {
 
 		minStatus, _ := strconv.Atoi(r.URL.Query().Get("minStatus"))
 		maxStatus, _ := strconv.Atoi(r.URL.Query().Get("maxStatus"))
+		impersonated := r.URL.Query().Get("impersonated") == "true"
 
 		// Get request logs
-		logs, total, err := logsService.GetRequestLogs(page, size, method, path, timeRange, minStatus, maxStatus)
+		logs, total, err := logsService.GetRequestLogs(page, size, method, path, timeRange, minStatus, maxStatus, impersonated)
 		if err != nil {
 			RespondWithError(w, http.StatusInternalServerError, "Failed to fetch request logs")
 			return
@@ -118,19 +119,20 @@ func HandleGetRequestLogs(logsService *services.LogsService) http.HandlerFunc {
 			}
 
 			logMap := map[string]interface{}{
-				"id":         log.ID.String(),
-				"level":      level,
-				"method":     log.Method,
-				"path":       log.Path,
-				"status":     log.StatusCode,
-				"duration":   duration,
-				"userIP":     log.UserIP,
-				"userID":     log.UserID,
-				"message":    fmt.Sprintf("%s %s", log.Method, log.Path),
-				"createdAt":  log.CreatedAt.Format(time.RFC3339),
-				"error":      log.Error,
-				"userAgent":  log.UserAgent,
-				"traceID":    log.TraceID,
+				"id":             log.ID.String(),
+				"level":          level,
+				"method":         log.Method,
+				"path":           log.Path,
+				"status":         log.StatusCode,
+				"duration":       duration,
+				"userIP":         log.UserIP,
+				"userID":         log.UserID,
+				"impersonatorID": log.ImpersonatorID,
+				"message":        fmt.Sprintf("%s %s", log.Method, log.Path),
+				"createdAt":      log.CreatedAt.Format(time.RFC3339),
+				"error":          log.Error,
+				"userAgent":      log.UserAgent,
+				"traceID":        log.TraceID,
 			}
 
 			responseLogs = append(responseLogs, logMap)
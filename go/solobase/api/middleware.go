@@ -2,23 +2,32 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/extensions/core"
 	"github.com/suppers-ai/solobase/services"
 )
 
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS, PATCH")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Checksum")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Tus-Checksum-Algorithm, Upload-Offset, Upload-Length, Upload-Expires")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		if r.Method == "OPTIONS" {
+			// tus clients learn the server's capabilities from OPTIONS
+			if route := mux.CurrentRoute(r); route != nil && strings.HasPrefix(route.GetName(), tusRoutePrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
@@ -27,57 +36,256 @@ func CORSMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// AuthMiddleware requires a valid JWT or API key and puts the caller in the request context
 func AuthMiddleware(authService *services.AuthService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				respondWithError(w, http.StatusUnauthorized, "No authorization header")
+			// Already authenticated by OptionalAuthMiddleware
+			if _, ok := r.Context().Value("user").(*auth.User); ok {
+				next.ServeHTTP(w, r)
 				return
 			}
 
-			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			if tokenString == authHeader {
-				respondWithError(w, http.StatusUnauthorized, "Invalid authorization header format")
+			ctx, status, message := authenticateRequest(authService, r)
+			if status != 0 {
+				respondWithError(w, status, message)
 				return
 			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
 
-			claims := &Claims{}
-			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-				return jwtSecret, nil
-			})
+// OptionalAuthMiddleware puts the caller in the request context when the request
+// carries valid credentials and otherwise lets it through anonymously, so public
+// routes can still tell who is calling
+func OptionalAuthMiddleware(authService *services.AuthService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "" {
+				if ctx, status, _ := authenticateRequest(authService, r); status == 0 {
+					r = r.WithContext(ctx)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticateRequest resolves the bearer credentials of a request. On failure it
+// returns the status and message to respond with.
+func authenticateRequest(authService *services.AuthService, r *http.Request) (context.Context, int, string) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, http.StatusUnauthorized, "No authorization header"
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if tokenString == authHeader {
+		return nil, http.StatusUnauthorized, "Invalid authorization header format"
+	}
+
+	if strings.HasPrefix(tokenString, services.APIKeyPrefix) {
+		return authenticateAPIKey(authService, r, tokenString)
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	})
+
+	if err != nil || !token.Valid {
+		return nil, http.StatusUnauthorized, "Invalid token"
+	}
+
+	if claims.SessionID != "" && authService.IsSessionRevoked(claims.SessionID) {
+		return nil, http.StatusUnauthorized, "Session has been revoked"
+	}
+
+	if claims.TwoFactorSetup && !twoFactorSetupPaths[apiPath(r)] {
+		return nil, http.StatusForbidden, "Two-factor authentication must be set up before continuing"
+	}
+
+	// For /auth/me endpoint, we need full user data from DB
+	// For other endpoints, we can use token claims to avoid DB lookup
+	var user *auth.User
+	if r.URL.Path == "/api/auth/me" {
+		// Get full user from database for profile endpoint
+		fullUser, err := authService.GetUserByID(claims.UserID)
+		if err != nil {
+			return nil, http.StatusUnauthorized, "User not found"
+		}
+		user = fullUser
+	} else {
+		// For other endpoints, create lightweight user from token claims
+		// This avoids database lookup on every request
+		userID, err := uuid.Parse(claims.UserID)
+		if err != nil {
+			return nil, http.StatusUnauthorized, "Invalid token"
+		}
+		user = &auth.User{
+			ID:    userID,
+			Email: claims.Email,
+			Role:  claims.Role,
+		}
+	}
+
+	ctx := withUser(r.Context(), user)
+	ctx = context.WithValue(ctx, "sessionID", claims.SessionID)
+	ctx = context.WithValue(ctx, "orgID", claims.OrgID)
+	if claims.Impersonation != nil {
+		ctx = withImpersonation(ctx, claims.Impersonation)
+	}
+	return ctx, 0, ""
+}
+
+// authenticateAPIKey resolves an API key and checks that its scopes cover the request
+func authenticateAPIKey(authService *services.AuthService, r *http.Request, key string) (context.Context, int, string) {
+	apiKey, user, err := authService.AuthenticateAPIKey(key)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "Invalid API key"
+	}
+
+	scope, allowed := apiKeyScope(r)
+	if !allowed || (scope != "" && !services.HasScope(apiKey.Scopes, scope)) {
+		return nil, http.StatusForbidden, "API key does not grant access to this endpoint"
+	}
 
-			if err != nil || !token.Valid {
-				respondWithError(w, http.StatusUnauthorized, "Invalid token")
+	ctx := withUser(r.Context(), user)
+	ctx = context.WithValue(ctx, "apiKeyID", apiKey.ID)
+	ctx = context.WithValue(ctx, "apiKeyScopes", apiKey.Scopes)
+	return ctx, 0, ""
+}
+
+// withUser adds the authenticated user to a context under the keys read by
+// handlers, extensions and the request logger
+func withUser(ctx context.Context, user *auth.User) context.Context {
+	ctx = context.WithValue(ctx, "user", user)
+	// Also add just the user ID for easier access
+	ctx = context.WithValue(ctx, "userID", user.ID.String())
+	ctx = context.WithValue(ctx, "user_id", user.ID.String())
+	ctx = context.WithValue(ctx, "user_role", user.Role)
+	if audit := services.RequestAuditFromContext(ctx); audit != nil {
+		audit.UserID = user.ID.String()
+	}
+	return ctx
+}
+
+// MaintenanceMiddleware answers 503 while maintenance mode is on. Callers who
+// can manage settings still get through, and anyone can reach the endpoints
+// needed to sign in, so an admin can always switch maintenance mode off.
+func MaintenanceMiddleware(settingsService *services.SettingsService, rbacService *services.RBACService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			settings := currentSettings(settingsService)
+			if !settings.MaintenanceMode || maintenancePaths[apiPath(r)] || strings.HasPrefix(apiPath(r), "/auth/oauth/") {
+				next.ServeHTTP(w, r)
 				return
 			}
-
-			// For /auth/me endpoint, we need full user data from DB
-			// For other endpoints, we can use token claims to avoid DB lookup
-			var user interface{}
-			if r.URL.Path == "/api/auth/me" {
-				// Get full user from database for profile endpoint
-				fullUser, err := authService.GetUserByID(claims.UserID)
-				if err != nil {
-					respondWithError(w, http.StatusUnauthorized, "User not found")
-					return
-				}
-				user = fullUser
-			} else {
-				// For other endpoints, create lightweight user from token claims
-				// This avoids database lookup on every request
-				user = &auth.User{
-					ID:    uuid.MustParse(claims.UserID),
-					Email: claims.Email,
-					Role:  claims.Role,
-				}
+			// Impersonation tokens are only issued to admins
+			if impersonatorID(r) != "" || hasPermission(rbacService, r, services.PermissionSettingsManage) {
+				next.ServeHTTP(w, r)
+				return
 			}
 
-			// Add user to context
-			ctx := context.WithValue(r.Context(), "user", user)
-			// Also add just the user ID for easier access
-			ctx = context.WithValue(ctx, "userID", claims.UserID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			message := settings.MaintenanceMessage
+			if message == "" {
+				message = "The site is down for maintenance, please try again later"
+			}
+			respondWithJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+				"error":       message,
+				"maintenance": true,
+			})
 		})
 	}
-}
\ No newline at end of file
+}
+
+// maintenancePaths stay open in maintenance mode so admins can sign in
+var maintenancePaths = map[string]bool{
+	"/health":                      true,
+	"/auth/login":                  true,
+	"/auth/login/2fa":              true,
+	"/auth/login/2fa/passkey":      true,
+	"/auth/passkeys/login":         true,
+	"/auth/passkeys/login/options": true,
+	"/auth/magic-link":             true,
+	"/auth/magic-link/verify":      true,
+	"/auth/refresh":                true,
+	"/auth/logout":                 true,
+	"/auth/me":                     true,
+}
+
+// twoFactorSetupPaths are the only endpoints a user who must set up two-factor
+// authentication can reach until they have done so
+var twoFactorSetupPaths = map[string]bool{
+	"/auth/me":          true,
+	"/auth/logout":      true,
+	"/auth/2fa":         true,
+	"/auth/2fa/enroll":  true,
+	"/auth/2fa/confirm": true,
+
+	"/auth/passkeys":                  true,
+	"/auth/passkeys/register/options": true,
+}
+
+// apiPath returns the request path relative to the /api mount point
+func apiPath(r *http.Request) string {
+	return "/" + strings.Trim(strings.TrimPrefix(r.URL.Path, "/api"), "/")
+}
+
+// apiKeyScope returns the scope an API key needs for a request. Endpoints that
+// are not mapped to a scope, such as managing sessions and API keys, cannot be
+// called with an API key at all.
+func apiKeyScope(r *http.Request) (string, bool) {
+	segments := strings.Split(strings.Trim(apiPath(r), "/"), "/")
+
+	access := services.ScopeWrite
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		access = services.ScopeRead
+	}
+
+	switch segments[0] {
+	case "auth":
+		// An API key may identify its owner but not manage credentials
+		return "", len(segments) == 2 && segments[1] == "me" && access == services.ScopeRead
+	case "ext":
+		if len(segments) < 2 || segments[1] == "" {
+			return "", false
+		}
+		return services.ScopeExtPrefix + segments[1], true
+	}
+
+	for _, resource := range services.APIKeyResources {
+		if segments[0] == resource {
+			return resource + ":" + access, true
+		}
+	}
+	return "", false
+}
+
+// ExtensionAuthenticator identifies callers of extension routes wrapped with
+// RequireAuth. API key callers carry their scopes so the extension router can
+// check its ext:<name> scope.
+func ExtensionAuthenticator(authService *services.AuthService) core.ExtensionAuthenticator {
+	return func(r *http.Request) (*core.AuthIdentity, error) {
+		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if strings.HasPrefix(tokenString, services.APIKeyPrefix) {
+			apiKey, user, err := authService.AuthenticateAPIKey(tokenString)
+			if err != nil {
+				return nil, err
+			}
+			scopes := apiKey.Scopes
+			if scopes == nil {
+				scopes = []string{}
+			}
+			return &core.AuthIdentity{UserID: user.ID.String(), Email: user.Email, Role: user.Role, Scopes: scopes}, nil
+		}
+
+		ctx, status, message := authenticateRequest(authService, r)
+		if status != 0 {
+			return nil, errors.New(message)
+		}
+		user := ctx.Value("user").(*auth.User)
+		return &core.AuthIdentity{UserID: user.ID.String(), Email: user.Email, Role: user.Role}, nil
+	}
+}
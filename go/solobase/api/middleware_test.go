@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/database"
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+)
+
+// newAPIKeyTestUser returns an auth service backed by a fresh database and a
+// user to issue API keys for
+func newAPIKeyTestUser(t *testing.T) (*services.AuthService, *database.DB, *auth.User) {
+	db, err := database.New(database.Config{Type: "sqlite", Database: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&auth.User{}, &auth.Session{}, &auth.Token{}, &models.APIKey{}))
+
+	authService := services.NewAuthService(db)
+	user := &auth.User{Email: "alice@example.com", Role: "user"}
+	require.NoError(t, authService.CreateUser(user))
+	return authService, db, user
+}
+
+func newTestAPIKey(t *testing.T, authService *services.AuthService, user *auth.User, scopes ...string) string {
+	_, key, err := authService.CreateAPIKey(user.ID, "test", scopes, nil)
+	require.NoError(t, err)
+	return key
+}
+
+func TestAPIKeyScope(t *testing.T) {
+	tests := []struct {
+		method, path string
+		scope        string
+		allowed      bool
+	}{
+		{"GET", "/api/storage/buckets", "storage:read", true},
+		{"HEAD", "/api/storage/buckets/docs/objects/1", "storage:read", true},
+		{"POST", "/api/storage/buckets/docs/upload", "storage:write", true},
+		{"GET", "/api/collections/posts/records", "collections:read", true},
+		{"PATCH", "/api/collections/posts/records/1", "collections:write", true},
+		{"DELETE", "/api/users/1", "users:write", true},
+		{"GET", "/api/database/tables", "database:read", true},
+		{"PUT", "/api/settings", "settings:write", true},
+		{"GET", "/api/logs", "logs:read", true},
+		{"GET", "/api/ext/products/items", "ext:products", true},
+		{"POST", "/api/ext/products", "ext:products", true},
+		{"GET", "/api/auth/me", "", true},
+
+		// Credentials and unmapped endpoints are closed to API keys
+		{"PATCH", "/api/auth/me", "", false},
+		{"GET", "/api/auth/tokens", "", false},
+		{"POST", "/api/auth/tokens", "", false},
+		{"GET", "/api/auth/sessions", "", false},
+		{"POST", "/api/auth/logout", "", false},
+		{"GET", "/api/ext/", "", false},
+		{"GET", "/api/extensions", "", false},
+		{"GET", "/api/dashboard/stats", "", false},
+	}
+	for _, tt := range tests {
+		scope, allowed := apiKeyScope(httptest.NewRequest(tt.method, tt.path, nil))
+		assert.Equal(t, tt.allowed, allowed, "%s %s", tt.method, tt.path)
+		assert.Equal(t, tt.scope, scope, "%s %s", tt.method, tt.path)
+	}
+}
+
+func TestAuthMiddlewareChecksAPIKeyScopes(t *testing.T) {
+	authService, _, user := newAPIKeyTestUser(t)
+	handler := AuthMiddleware(authService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Context().Value("userID"))
+	}))
+	request := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	reader := newTestAPIKey(t, authService, user, "storage:read")
+	rec := request("GET", "/api/storage/buckets", reader)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, user.ID.String(), rec.Body.String())
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/storage/buckets", reader).Code)
+	assert.Equal(t, http.StatusForbidden, request("GET", "/api/collections", reader).Code)
+	assert.Equal(t, http.StatusForbidden, request("GET", "/api/auth/tokens", reader).Code)
+	assert.Equal(t, http.StatusOK, request("GET", "/api/auth/me", reader).Code)
+
+	writer := newTestAPIKey(t, authService, user, "collections:write")
+	assert.Equal(t, http.StatusOK, request("GET", "/api/collections", writer).Code)
+	assert.Equal(t, http.StatusOK, request("POST", "/api/collections/posts/records", writer).Code)
+	assert.Equal(t, http.StatusForbidden, request("GET", "/api/users", writer).Code)
+
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/storage/buckets", services.APIKeyPrefix+"unknown").Code)
+}
+
+func TestAuthMiddlewareRejectsRevokedAndExpiredAPIKeys(t *testing.T) {
+	authService, db, user := newAPIKeyTestUser(t)
+	handler := AuthMiddleware(authService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	status := func(key string) int {
+		req := httptest.NewRequest("GET", "/api/storage/buckets", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	apiKey, key, err := authService.CreateAPIKey(user.ID, "ci", []string{"storage:read"}, &expiresAt)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status(key))
+
+	require.NoError(t, authService.RevokeAPIKey(user.ID, apiKey.ID))
+	assert.Equal(t, http.StatusUnauthorized, status(key))
+
+	apiKey, key, err = authService.CreateAPIKey(user.ID, "ci", []string{"storage:read"}, &expiresAt)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&models.APIKey{}).Where("id = ?", apiKey.ID).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	assert.Equal(t, http.StatusUnauthorized, status(key))
+}
+
+func TestExtensionAuthenticatorChecksExtensionScope(t *testing.T) {
+	authService, _, user := newAPIKeyTestUser(t)
+	suite := core.NewExtensionTestSuite(t)
+	defer suite.Cleanup()
+	suite.Registry.SetAuthenticator(ExtensionAuthenticator(authService))
+
+	handler := core.NewExtensionRouter("products", suite.Registry).RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Context().Value("user_id"))
+	}))
+	status := func(key string) int {
+		req := httptest.NewRequest("GET", "/ext/products/items", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, status(newTestAPIKey(t, authService, user, "ext:products")))
+	assert.Equal(t, http.StatusOK, status(newTestAPIKey(t, authService, user, "storage:read", "ext:products")))
+	assert.Equal(t, http.StatusForbidden, status(newTestAPIKey(t, authService, user, "ext:webhooks")))
+	assert.Equal(t, http.StatusForbidden, status(newTestAPIKey(t, authService, user, "storage:write")))
+	assert.Equal(t, http.StatusUnauthorized, status(services.APIKeyPrefix+"unknown"))
+}
@@ -0,0 +1,475 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+)
+
+type OrganizationRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type OrganizationMemberRequest struct {
+	Role string `json:"role"`
+}
+
+type OrganizationInviteRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type AcceptInviteRequest struct {
+	Token string `json:"token"`
+}
+
+type SwitchOrganizationRequest struct {
+	// OrganizationID is the organization to make active, empty for the personal workspace
+	OrganizationID string `json:"organization_id"`
+}
+
+// OrganizationAccess resolves the role the caller of a request has in an
+// organization. Holders of the organizations.manage permission act as the
+// owner of every organization.
+type OrganizationAccess struct {
+	orgs *services.OrganizationService
+	rbac *services.RBACService
+}
+
+func NewOrganizationAccess(orgService *services.OrganizationService, rbacService *services.RBACService) *OrganizationAccess {
+	return &OrganizationAccess{orgs: orgService, rbac: rbacService}
+}
+
+// Role returns the caller's role in an organization, or "" without access
+func (a *OrganizationAccess) Role(r *http.Request, orgID string) string {
+	user, ok := r.Context().Value("user").(*auth.User)
+	if a == nil || !ok || orgID == "" {
+		return ""
+	}
+	if role, err := a.orgs.MemberRole(orgID, user.ID.String()); err == nil {
+		return role
+	}
+	if a.rbac != nil && hasPermission(a.rbac, r, services.PermissionOrganizationsManage) {
+		return services.OrgRoleOwner
+	}
+	return ""
+}
+
+// Can reports whether the caller has at least the given role in an organization
+func (a *OrganizationAccess) Can(r *http.Request, orgID, minRole string) bool {
+	return services.OrgRoleAtLeast(a.Role(r, orgID), minRole)
+}
+
+// Roles maps the organizations the caller belongs to onto their role in
+// each. all is set for callers who can see every organization.
+func (a *OrganizationAccess) Roles(r *http.Request) (roles map[string]string, all bool) {
+	user, ok := r.Context().Value("user").(*auth.User)
+	if a == nil || !ok {
+		return map[string]string{}, false
+	}
+	roles, err := a.orgs.UserOrganizationRoles(user.ID.String())
+	if err != nil {
+		roles = map[string]string{}
+	}
+	return roles, a.rbac != nil && hasPermission(a.rbac, r, services.PermissionOrganizationsManage)
+}
+
+// resourceOrganization returns the organization a new resource belongs to:
+// the one requested, or the caller's active organization when the request
+// does not say. An empty requested ID creates a personal resource.
+func resourceOrganization(r *http.Request, requested *string) string {
+	if requested != nil {
+		return *requested
+	}
+	orgID, _ := r.Context().Value("orgID").(string)
+	return orgID
+}
+
+// requireOrganization loads the organization of the request and checks the
+// caller's role in it. Callers without access get a 404 so that
+// organizations they do not belong to stay hidden.
+func requireOrganization(w http.ResponseWriter, r *http.Request, access *OrganizationAccess, minRole string) (*models.Organization, string, bool) {
+	org, err := access.orgs.GetOrganization(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithOrganizationError(w, err, "Failed to fetch organization")
+		return nil, "", false
+	}
+
+	role := access.Role(r, org.ID)
+	if role == "" {
+		respondWithError(w, http.StatusNotFound, "Organization not found")
+		return nil, "", false
+	}
+	if !services.OrgRoleAtLeast(role, minRole) {
+		respondWithError(w, http.StatusForbidden, "Insufficient organization role")
+		return nil, "", false
+	}
+	return org, role, true
+}
+
+// canAssignOrgRole reports whether a member with actorRole may give role to
+// someone. Admins manage roles up to their own, only owners create owners.
+func canAssignOrgRole(actorRole, role string) bool {
+	return services.OrgRoleAtLeast(actorRole, services.OrgRoleAdmin) && services.OrgRoleAtLeast(actorRole, role)
+}
+
+// HandleListOrganizations lists the organizations of the current user
+func HandleListOrganizations(orgService *services.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(*auth.User)
+		orgs, err := orgService.ListUserOrganizations(user.ID.String())
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch organizations")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, orgs)
+	}
+}
+
+// HandleCreateOrganization creates an organization owned by the current user
+func HandleCreateOrganization(orgService *services.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req OrganizationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		user := r.Context().Value("user").(*auth.User)
+		org, err := orgService.CreateOrganization(req.Name, req.Slug, user.ID.String())
+		if err != nil {
+			respondWithOrganizationError(w, err, "Failed to create organization")
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, services.UserOrganization{Organization: *org, Role: services.OrgRoleOwner})
+	}
+}
+
+// HandleGetOrganization returns an organization with the caller's role in it
+func HandleGetOrganization(access *OrganizationAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		org, role, ok := requireOrganization(w, r, access, services.OrgRoleViewer)
+		if !ok {
+			return
+		}
+		respondWithJSON(w, http.StatusOK, services.UserOrganization{Organization: *org, Role: role})
+	}
+}
+
+// HandleUpdateOrganization renames an organization
+func HandleUpdateOrganization(access *OrganizationAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		org, _, ok := requireOrganization(w, r, access, services.OrgRoleAdmin)
+		if !ok {
+			return
+		}
+
+		var req OrganizationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		updated, err := access.orgs.UpdateOrganization(org.ID, req.Name)
+		if err != nil {
+			respondWithOrganizationError(w, err, "Failed to update organization")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, updated)
+	}
+}
+
+// HandleDeleteOrganization deletes an organization that no longer owns resources
+func HandleDeleteOrganization(access *OrganizationAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		org, _, ok := requireOrganization(w, r, access, services.OrgRoleOwner)
+		if !ok {
+			return
+		}
+
+		if err := access.orgs.DeleteOrganization(org.ID); err != nil {
+			respondWithOrganizationError(w, err, "Failed to delete organization")
+			return
+		}
+
+		log.Printf("Organization %s deleted by %s", org.Slug, r.Context().Value("user").(*auth.User).Email)
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Organization deleted"})
+	}
+}
+
+// HandleListOrganizationMembers lists the members of an organization
+func HandleListOrganizationMembers(access *OrganizationAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		org, _, ok := requireOrganization(w, r, access, services.OrgRoleViewer)
+		if !ok {
+			return
+		}
+
+		members, err := access.orgs.ListMembers(org.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch members")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, members)
+	}
+}
+
+// HandleUpdateOrganizationMember changes the role of a member
+func HandleUpdateOrganizationMember(access *OrganizationAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		org, role, ok := requireOrganization(w, r, access, services.OrgRoleAdmin)
+		if !ok {
+			return
+		}
+
+		var req OrganizationMemberRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if !services.ValidOrgRole(req.Role) {
+			respondWithOrganizationError(w, services.ErrInvalidOrganizationRole, "Failed to update member")
+			return
+		}
+
+		userID := mux.Vars(r)["userId"]
+		current, err := access.orgs.MemberRole(org.ID, userID)
+		if err != nil {
+			respondWithOrganizationError(w, err, "Failed to fetch member")
+			return
+		}
+		if !canAssignOrgRole(role, current) || !canAssignOrgRole(role, req.Role) {
+			respondWithError(w, http.StatusForbidden, "Insufficient organization role")
+			return
+		}
+
+		member, err := access.orgs.UpdateMemberRole(org.ID, userID, req.Role)
+		if err != nil {
+			respondWithOrganizationError(w, err, "Failed to update member")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, member)
+	}
+}
+
+// HandleRemoveOrganizationMember removes a member. Members can always remove
+// themselves to leave an organization.
+func HandleRemoveOrganizationMember(access *OrganizationAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		org, role, ok := requireOrganization(w, r, access, services.OrgRoleViewer)
+		if !ok {
+			return
+		}
+
+		user := r.Context().Value("user").(*auth.User)
+		userID := mux.Vars(r)["userId"]
+		if userID != user.ID.String() {
+			current, err := access.orgs.MemberRole(org.ID, userID)
+			if err != nil {
+				respondWithOrganizationError(w, err, "Failed to fetch member")
+				return
+			}
+			if !canAssignOrgRole(role, current) {
+				respondWithError(w, http.StatusForbidden, "Insufficient organization role")
+				return
+			}
+		}
+
+		if err := access.orgs.RemoveMember(org.ID, userID); err != nil {
+			respondWithOrganizationError(w, err, "Failed to remove member")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Member removed"})
+	}
+}
+
+// HandleListOrganizationInvites lists the pending invitations of an organization
+func HandleListOrganizationInvites(access *OrganizationAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		org, _, ok := requireOrganization(w, r, access, services.OrgRoleAdmin)
+		if !ok {
+			return
+		}
+
+		invites, err := access.orgs.ListInvites(org.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch invitations")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, invites)
+	}
+}
+
+// HandleCreateOrganizationInvite emails an invitation to join an organization
+func HandleCreateOrganizationInvite(access *OrganizationAccess, settingsService *services.SettingsService, mailService *services.MailService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		org, role, ok := requireOrganization(w, r, access, services.OrgRoleAdmin)
+		if !ok {
+			return
+		}
+
+		var req OrganizationInviteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if req.Role == "" {
+			req.Role = services.OrgRoleMember
+		}
+		if services.ValidOrgRole(req.Role) && !canAssignOrgRole(role, req.Role) {
+			respondWithError(w, http.StatusForbidden, "Insufficient organization role")
+			return
+		}
+
+		if mailService == nil || !mailService.Enabled() {
+			respondWithError(w, http.StatusServiceUnavailable, "Email delivery is not configured")
+			return
+		}
+
+		user := r.Context().Value("user").(*auth.User)
+		invite, token, err := access.orgs.CreateInvite(org.ID, req.Email, req.Role, user.ID.String())
+		if err != nil {
+			respondWithOrganizationError(w, err, "Failed to create invitation")
+			return
+		}
+
+		inviteURL := appLink(settingsService, "/organizations/invite", token)
+		sendMailAsync("organization invite", &auth.User{Email: invite.Email}, func(ctx context.Context) error {
+			return mailService.SendOrganizationInvite(ctx, invite.Email, org.Name, user.Email, inviteURL, invite.ExpiresAt)
+		})
+
+		respondWithJSON(w, http.StatusCreated, invite)
+	}
+}
+
+// HandleRevokeOrganizationInvite withdraws a pending invitation
+func HandleRevokeOrganizationInvite(access *OrganizationAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		org, _, ok := requireOrganization(w, r, access, services.OrgRoleAdmin)
+		if !ok {
+			return
+		}
+
+		if err := access.orgs.RevokeInvite(org.ID, mux.Vars(r)["inviteId"]); err != nil {
+			if errors.Is(err, services.ErrInvalidInvite) {
+				respondWithError(w, http.StatusNotFound, "Invitation not found")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to revoke invitation")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Invitation revoked"})
+	}
+}
+
+// HandleAcceptOrganizationInvite adds the current user to the organization
+// named in an invitation sent to their email address
+func HandleAcceptOrganizationInvite(orgService *services.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AcceptInviteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		user := r.Context().Value("user").(*auth.User)
+		member, err := orgService.AcceptInvite(req.Token, user)
+		if err != nil {
+			respondWithOrganizationError(w, err, "Failed to accept invitation")
+			return
+		}
+
+		org, err := orgService.GetOrganization(member.OrganizationID)
+		if err != nil {
+			respondWithOrganizationError(w, err, "Failed to fetch organization")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, services.UserOrganization{Organization: *org, Role: member.Role})
+	}
+}
+
+// HandleSwitchOrganization changes the active organization of the current
+// session and returns an access token carrying it
+func HandleSwitchOrganization(authService *services.AuthService, settingsService *services.SettingsService, access *OrganizationAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SwitchOrganizationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		sessionID, _ := r.Context().Value("sessionID").(string)
+		if sessionID == "" {
+			respondWithError(w, http.StatusBadRequest, "The active organization can only be set for a login session")
+			return
+		}
+
+		orgID := ""
+		if req.OrganizationID != "" {
+			org, err := access.orgs.GetOrganization(req.OrganizationID)
+			if err != nil || access.Role(r, org.ID) == "" {
+				respondWithError(w, http.StatusNotFound, "Organization not found")
+				return
+			}
+			orgID = org.ID
+		}
+
+		user, err := authService.GetUserByID(r.Context().Value("user").(*auth.User).ID.String())
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "User not found")
+			return
+		}
+		if err := authService.SetSessionOrganization(user.ID, sessionID, orgID); err != nil {
+			if errors.Is(err, services.ErrSessionNotFound) {
+				respondWithError(w, http.StatusUnauthorized, "Session has ended")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to switch organization")
+			return
+		}
+
+		ttl := sessionPolicy(settingsService).AccessTokenTTL()
+		token, err := generateToken(user, sessionID, orgID, false, ttl)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"token":           token,
+			"expires_in":      int(ttl.Seconds()),
+			"organization_id": orgID,
+		})
+	}
+}
+
+// respondWithOrganizationError maps organization errors to responses
+func respondWithOrganizationError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrOrganizationNotFound):
+		respondWithError(w, http.StatusNotFound, "Organization not found")
+	case errors.Is(err, services.ErrNotOrganizationMember):
+		respondWithError(w, http.StatusNotFound, "Member not found")
+	case errors.Is(err, services.ErrOrganizationSlugTaken), errors.Is(err, services.ErrAlreadyOrganizationMember):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrLastOrganizationOwner), errors.Is(err, services.ErrOrganizationNotEmpty):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrInviteEmailMismatch):
+		respondWithError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrInvalidInvite):
+		respondWithError(w, http.StatusBadRequest, "This invitation is invalid or has expired")
+	case errors.Is(err, services.ErrInvalidOrganization), errors.Is(err, services.ErrInvalidOrganizationSlug),
+		errors.Is(err, services.ErrInvalidOrganizationRole), errors.Is(err, services.ErrInvalidInviteEmail):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, message)
+	}
+}
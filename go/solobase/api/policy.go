@@ -0,0 +1,51 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+)
+
+// currentSettings returns the app settings, or the defaults when they cannot be read.
+// Settings are read on each call, so changes apply without a restart.
+func currentSettings(settingsService *services.SettingsService) *models.AppSettings {
+	if settingsService != nil {
+		if settings, err := settingsService.GetSettings(); err == nil {
+			return settings
+		}
+	}
+	return models.DefaultSettings()
+}
+
+// sessionPolicy reads the session timeout from the settings
+func sessionPolicy(settingsService *services.SettingsService) services.SessionPolicy {
+	return services.SessionPolicyFromSettings(currentSettings(settingsService))
+}
+
+// uploadPolicy reads the upload limits from the settings
+func uploadPolicy(settingsService *services.SettingsService) services.UploadPolicy {
+	return services.UploadPolicyFromSettings(currentSettings(settingsService))
+}
+
+// checkNewPassword applies the password policy, responding with the broken rule
+func checkNewPassword(w http.ResponseWriter, settingsService *services.SettingsService, password, email string) bool {
+	policy := services.PasswordPolicyFromSettings(currentSettings(settingsService))
+	if err := policy.Validate(password, email); err != nil {
+		message := err.Error()
+		respondWithError(w, http.StatusBadRequest, strings.ToUpper(message[:1])+message[1:])
+		return false
+	}
+	return true
+}
+
+// respondUploadRejected responds to an upload the upload policy does not allow
+func respondUploadRejected(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrFileTooLarge) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "File exceeds the maximum upload size")
+		return
+	}
+	respondWithError(w, http.StatusUnsupportedMediaType, "File type is not allowed")
+}
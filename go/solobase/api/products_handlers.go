@@ -2,13 +2,16 @@ package api
 
 import (
 	"net/http"
+	auth "github.com/suppers-ai/auth"
 	"github.com/suppers-ai/solobase/extensions/official/products"
+	"github.com/suppers-ai/solobase/services"
 	"gorm.io/gorm"
 )
 
 // ProductsExtensionHandlers wraps the products extension for the API router
 type ProductsExtensionHandlers struct {
-	ext *products.ProductsExtension
+	ext       *products.ProductsExtension
+	orgAccess *OrganizationAccess
 }
 
 // NewProductsExtensionHandlers creates a new wrapper for products extension handlers
@@ -30,6 +33,36 @@ func (h *ProductsExtensionHandlers) SetExtension(ext *products.ProductsExtension
 	h.ext = ext
 }
 
+// SetOrganizationAccess lets users share groups with their organizations
+func (h *ProductsExtensionHandlers) SetOrganizationAccess(access *OrganizationAccess) {
+	h.orgAccess = access
+}
+
+// withCaller puts the authenticated user and the organizations whose groups
+// they share into the request context for the user API
+func (h *ProductsExtensionHandlers) withCaller(r *http.Request) *http.Request {
+	user, ok := r.Context().Value("user").(*auth.User)
+	if !ok {
+		return r
+	}
+
+	caller := products.Caller{UserID: user.ID.String(), Organizations: map[string]bool{}}
+	caller.ActiveOrganizationID, _ = r.Context().Value("orgID").(string)
+
+	roles, all := h.orgAccess.Roles(r)
+	if all {
+		if orgs, err := h.orgAccess.orgs.ListOrganizations(); err == nil {
+			for _, org := range orgs {
+				roles[org.ID] = services.OrgRoleOwner
+			}
+		}
+	}
+	for orgID, role := range roles {
+		caller.Organizations[orgID] = services.OrgRoleAtLeast(role, services.OrgRoleMember)
+	}
+	return r.WithContext(products.WithCaller(r.Context(), caller))
+}
+
 // Admin API handlers - Variables
 func (h *ProductsExtensionHandlers) HandleListVariables() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -119,7 +152,7 @@ func (h *ProductsExtensionHandlers) HandleListGroups() http.HandlerFunc {
 			http.Error(w, "Extension not initialized", http.StatusServiceUnavailable)
 			return
 		}
-		h.ext.GetUserAPI().ListMyGroups(w, r)
+		h.ext.GetUserAPI().ListMyGroups(w, h.withCaller(r))
 	}
 }
 
@@ -129,7 +162,7 @@ func (h *ProductsExtensionHandlers) HandleCreateGroup() http.HandlerFunc {
 			http.Error(w, "Extension not initialized", http.StatusServiceUnavailable)
 			return
 		}
-		h.ext.GetUserAPI().CreateGroup(w, r)
+		h.ext.GetUserAPI().CreateGroup(w, h.withCaller(r))
 	}
 }
 
@@ -139,7 +172,7 @@ func (h *ProductsExtensionHandlers) HandleUpdateGroup() http.HandlerFunc {
 			http.Error(w, "Extension not initialized", http.StatusServiceUnavailable)
 			return
 		}
-		h.ext.GetUserAPI().UpdateGroup(w, r)
+		h.ext.GetUserAPI().UpdateGroup(w, h.withCaller(r))
 	}
 }
 
@@ -149,7 +182,7 @@ func (h *ProductsExtensionHandlers) HandleDeleteGroup() http.HandlerFunc {
 			http.Error(w, "Extension not initialized", http.StatusServiceUnavailable)
 			return
 		}
-		h.ext.GetUserAPI().DeleteGroup(w, r)
+		h.ext.GetUserAPI().DeleteGroup(w, h.withCaller(r))
 	}
 }
 
@@ -159,7 +192,7 @@ func (h *ProductsExtensionHandlers) HandleGetGroup() http.HandlerFunc {
 			http.Error(w, "Extension not initialized", http.StatusServiceUnavailable)
 			return
 		}
-		h.ext.GetUserAPI().GetGroup(w, r)
+		h.ext.GetUserAPI().GetGroup(w, h.withCaller(r))
 	}
 }
 
@@ -169,7 +202,7 @@ func (h *ProductsExtensionHandlers) HandleGroupProducts() http.HandlerFunc {
 			http.Error(w, "Extension not initialized", http.StatusServiceUnavailable)
 			return
 		}
-		h.ext.GetUserAPI().ListGroupProducts(w, r)
+		h.ext.GetUserAPI().ListGroupProducts(w, h.withCaller(r))
 	}
 }
 
@@ -273,7 +306,7 @@ func (h *ProductsExtensionHandlers) HandleProductsList() http.HandlerFunc {
 			return
 		}
 		// List all products across all user's entities
-		h.ext.GetUserAPI().ListMyProducts(w, r)
+		h.ext.GetUserAPI().ListMyProducts(w, h.withCaller(r))
 	}
 }
 
@@ -283,7 +316,7 @@ func (h *ProductsExtensionHandlers) HandleProductsCreate() http.HandlerFunc {
 			http.Error(w, "Extension not initialized", http.StatusServiceUnavailable)
 			return
 		}
-		h.ext.GetUserAPI().CreateProduct(w, r)
+		h.ext.GetUserAPI().CreateProduct(w, h.withCaller(r))
 	}
 }
 
@@ -293,6 +326,6 @@ func (h *ProductsExtensionHandlers) HandleProductsStats() http.HandlerFunc {
 			http.Error(w, "Extension not initialized", http.StatusServiceUnavailable)
 			return
 		}
-		h.ext.GetUserAPI().GetProductStats(w, r)
+		h.ext.GetUserAPI().GetProductStats(w, h.withCaller(r))
 	}
 }
\ No newline at end of file
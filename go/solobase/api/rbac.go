@@ -0,0 +1,274 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+)
+
+// permissionCheckerKey holds the request's *services.PermissionChecker in the context
+const permissionCheckerKey = "permissionChecker"
+
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type BindRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// UserRolesResponse lists the roles of a user and the permissions they add up to
+type UserRolesResponse struct {
+	Role        string   `json:"role"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// RequirePermission only lets callers through whose roles grant the
+// permission. The caller's permissions are loaded once per request and
+// shared with later checks through the request context.
+func RequirePermission(rbacService *services.RBACService, permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := r.Context().Value("user").(*auth.User); !ok {
+				respondWithError(w, http.StatusUnauthorized, "Authentication required")
+				return
+			}
+
+			checker, r := requestPermissions(rbacService, r)
+			if !checker.Has(permission) {
+				respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// hasPermission reports whether the authenticated caller has a permission
+func hasPermission(rbacService *services.RBACService, r *http.Request, permission string) bool {
+	if _, ok := r.Context().Value("user").(*auth.User); !ok {
+		return false
+	}
+	checker, _ := requestPermissions(rbacService, r)
+	return checker.Has(permission)
+}
+
+// requestPermissions returns the permission checker of the request's caller,
+// adding one to the request if this is the first check
+func requestPermissions(rbacService *services.RBACService, r *http.Request) (*services.PermissionChecker, *http.Request) {
+	if checker, ok := r.Context().Value(permissionCheckerKey).(*services.PermissionChecker); ok {
+		return checker, r
+	}
+	user := r.Context().Value("user").(*auth.User)
+	checker := rbacService.NewPermissionChecker(user.ID.String(), user.Role)
+	return checker, r.WithContext(context.WithValue(r.Context(), permissionCheckerKey, checker))
+}
+
+// ExtensionPermissionChecker checks the permissions of extension route
+// callers and of users extensions ask about
+func ExtensionPermissionChecker(rbacService *services.RBACService) core.ExtensionPermissionChecker {
+	return func(ctx context.Context, userID string, permission string) bool {
+		if checker, ok := ctx.Value(permissionCheckerKey).(*services.PermissionChecker); ok && checker.UserID() == userID {
+			return checker.Has(permission)
+		}
+
+		// The role is in the context when the request identified the user
+		role, _ := ctx.Value("user_role").(string)
+		if ctxUserID, _ := ctx.Value("user_id").(string); role == "" || ctxUserID != userID {
+			var err error
+			if role, err = rbacService.UserRole(userID); err != nil {
+				return false
+			}
+		}
+		return rbacService.NewPermissionChecker(userID, role).Has(permission)
+	}
+}
+
+// ExtensionPermissionRegistrar adds the permissions an extension requires to
+// the permission catalog when it is enabled
+func ExtensionPermissionRegistrar(rbacService *services.RBACService) core.ExtensionPermissionRegistrar {
+	return func(extension string, permissions []core.Permission) error {
+		catalog := make([]models.Permission, 0, len(permissions))
+		for _, permission := range permissions {
+			catalog = append(catalog, models.Permission{Name: permission.Name, Description: permission.Description})
+		}
+		return rbacService.RegisterPermissions(extension, catalog)
+	}
+}
+
+// HandleListRoles returns every role with its permissions
+func HandleListRoles(rbacService *services.RBACService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roles, err := rbacService.ListRoles()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch roles")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, roles)
+	}
+}
+
+// HandleListPermissions returns the permission catalog roles can be granted from
+func HandleListPermissions(rbacService *services.RBACService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		permissions, err := rbacService.ListPermissions()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch permissions")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, permissions)
+	}
+}
+
+// HandleCreateRole adds a custom role
+func HandleCreateRole(rbacService *services.RBACService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		role := &models.Role{
+			Name:        req.Name,
+			DisplayName: req.DisplayName,
+			Description: req.Description,
+			Permissions: req.Permissions,
+		}
+		if err := rbacService.CreateRole(role); err != nil {
+			respondWithRoleError(w, err, "Failed to create role")
+			return
+		}
+
+		log.Printf("Role %s created by %s", role.Name, r.Context().Value("user").(*auth.User).Email)
+		respondWithJSON(w, http.StatusCreated, role)
+	}
+}
+
+// HandleUpdateRole changes the description and permissions of a role
+func HandleUpdateRole(rbacService *services.RBACService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		role, err := rbacService.UpdateRole(mux.Vars(r)["name"], &models.Role{
+			DisplayName: req.DisplayName,
+			Description: req.Description,
+			Permissions: req.Permissions,
+		})
+		if err != nil {
+			respondWithRoleError(w, err, "Failed to update role")
+			return
+		}
+
+		log.Printf("Role %s updated by %s", role.Name, r.Context().Value("user").(*auth.User).Email)
+		respondWithJSON(w, http.StatusOK, role)
+	}
+}
+
+// HandleDeleteRole removes a custom role
+func HandleDeleteRole(rbacService *services.RBACService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if err := rbacService.DeleteRole(name); err != nil {
+			respondWithRoleError(w, err, "Failed to delete role")
+			return
+		}
+
+		log.Printf("Role %s deleted by %s", name, r.Context().Value("user").(*auth.User).Email)
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Role deleted"})
+	}
+}
+
+// HandleGetUserRoles lists the roles of a user and their effective permissions
+func HandleGetUserRoles(userService *services.UserService, rbacService *services.RBACService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userService.GetUserByID(mux.Vars(r)["id"])
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		roles, err := rbacService.UserRoles(user.ID.String())
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch roles")
+			return
+		}
+		permissions, err := rbacService.PermissionsForUser(user.ID.String(), user.Role)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch permissions")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, UserRolesResponse{Role: user.Role, Roles: roles, Permissions: permissions})
+	}
+}
+
+// HandleBindUserRole gives a user an extra role
+func HandleBindUserRole(userService *services.UserService, rbacService *services.RBACService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BindRoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Role == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		user, err := userService.GetUserByID(mux.Vars(r)["id"])
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		if err := rbacService.BindRole(user.ID.String(), req.Role); err != nil {
+			respondWithRoleError(w, err, "Failed to assign role")
+			return
+		}
+
+		log.Printf("Role %s given to %s by %s", req.Role, user.Email, r.Context().Value("user").(*auth.User).Email)
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Role assigned"})
+	}
+}
+
+// HandleUnbindUserRole takes an extra role away from a user
+func HandleUnbindUserRole(rbacService *services.RBACService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := rbacService.UnbindRole(vars["id"], vars["role"]); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to remove role")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Role removed"})
+	}
+}
+
+// respondWithRoleError maps role errors to responses
+func respondWithRoleError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrRoleNotFound):
+		respondWithError(w, http.StatusNotFound, "Role not found")
+	case errors.Is(err, services.ErrRoleExists):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrBuiltinRole):
+		respondWithError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrInvalidRoleName), errors.Is(err, services.ErrUnknownPermission):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, message)
+	}
+}
@@ -19,7 +19,13 @@ type API struct {
 	CollectionService *services.CollectionService
 	DatabaseService   *services.DatabaseService
 	SettingsService   *services.SettingsService
+	MailService       *services.MailService
+	OAuthService      *services.OAuthService
 	LogsService       *services.LogsService
+	RBACService       *services.RBACService
+	OrgService        *services.OrganizationService
+	UserDataService   *services.UserDataService
+	orgAccess         *OrganizationAccess
 	productHandlers   *ProductsExtensionHandlers
 	analyticsHandlers *AnalyticsHandlers
 	storageHandlers   *StorageHandlers
@@ -35,7 +41,12 @@ func NewAPI(
 	collectionService *services.CollectionService,
 	databaseService *services.DatabaseService,
 	settingsService *services.SettingsService,
+	mailService *services.MailService,
+	oauthService *services.OAuthService,
 	logsService *services.LogsService,
+	rbacService *services.RBACService,
+	orgService *services.OrganizationService,
+	userDataService *services.UserDataService,
 	extensionRegistry *core.ExtensionRegistry,
 ) *API {
 	api := &API{
@@ -47,12 +58,19 @@ func NewAPI(
 		CollectionService: collectionService,
 		DatabaseService:   databaseService,
 		SettingsService:   settingsService,
+		MailService:       mailService,
+		OAuthService:      oauthService,
 		LogsService:       logsService,
+		RBACService:       rbacService,
+		OrgService:        orgService,
+		UserDataService:   userDataService,
 		ExtensionRegistry: extensionRegistry,
 	}
 	
+	api.orgAccess = NewOrganizationAccess(orgService, rbacService)
+
 	// Initialize storage handlers with hook support
-	api.storageHandlers = NewStorageHandlers(storageService, db, extensionRegistry)
+	api.storageHandlers = NewStorageHandlers(storageService, settingsService, db, extensionRegistry, rbacService, api.orgAccess)
 	
 	// Initialize shares handler
 	api.sharesHandler = NewSharesHandler(db)
@@ -68,6 +86,9 @@ func (a *API) setupRoutes() {
 	// Apply CORS and Metrics middleware to all API routes
 	apiRouter.Use(CORSMiddleware)
 	apiRouter.Use(MetricsMiddleware)
+	// Identify callers on public routes too; protected routes reuse the result
+	apiRouter.Use(OptionalAuthMiddleware(a.AuthService))
+	apiRouter.Use(MaintenanceMiddleware(a.SettingsService, a.RBACService))
 
 	// Health check endpoint for debugging
 	apiRouter.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -91,8 +112,24 @@ func (a *API) setupRoutes() {
 	}).Methods("GET", "OPTIONS")
 	
 	// Public routes (no auth required)
-	apiRouter.HandleFunc("/auth/login", HandleLogin(a.AuthService, a.StorageService, a.ExtensionRegistry)).Methods("POST", "OPTIONS")
-	apiRouter.HandleFunc("/auth/signup", HandleSignup(a.AuthService)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/login", HandleLogin(a.AuthService, a.SettingsService, a.MailService, a.StorageService, a.ExtensionRegistry)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/login/2fa", HandleLoginTwoFactor(a.AuthService, a.SettingsService, a.MailService, a.StorageService, a.ExtensionRegistry)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/login/2fa/passkey", HandlePasskeyMFAOptions(a.AuthService, a.SettingsService)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/passkeys/login/options", HandlePasskeyLoginOptions(a.AuthService, a.SettingsService)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/passkeys/login", HandlePasskeyLogin(a.AuthService, a.SettingsService, a.StorageService, a.ExtensionRegistry)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/signup", HandleSignup(a.AuthService, a.SettingsService, a.MailService)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/confirm", HandleConfirmEmail(a.AuthService)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/confirm/resend", HandleResendConfirmation(a.AuthService, a.SettingsService, a.MailService)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/password/forgot", HandleForgotPassword(a.AuthService, a.SettingsService, a.MailService)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/password/reset", HandleResetPassword(a.AuthService, a.SettingsService)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/magic-link", HandleRequestMagicLink(a.AuthService, a.SettingsService, a.MailService)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/magic-link/verify", HandleMagicLinkLogin(a.AuthService, a.SettingsService, a.StorageService, a.ExtensionRegistry)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/unlock", HandleUnlockAccount(a.AuthService)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/refresh", HandleRefreshToken(a.AuthService, a.SettingsService)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/oauth/providers", HandleListOAuthProviders(a.OAuthService)).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/auth/oauth/exchange", HandleOAuthExchange(a.AuthService, a.SettingsService, a.StorageService, a.ExtensionRegistry)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/auth/oauth/{provider}/authorize", HandleOAuthAuthorize(a.OAuthService, a.SettingsService)).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/auth/oauth/{provider}/callback", HandleOAuthCallback(a.AuthService, a.OAuthService, a.SettingsService)).Methods("GET", "OPTIONS")
 	
 	// Temporarily make dashboard public for testing
 	apiRouter.HandleFunc("/dashboard/stats", HandleGetDashboardStats(
@@ -105,16 +142,71 @@ func (a *API) setupRoutes() {
 	protected := apiRouter.PathPrefix("").Subrouter()
 	protected.Use(AuthMiddleware(a.AuthService))
 
-	// Auth routes
-	protected.HandleFunc("/auth/logout", HandleLogout()).Methods("POST", "OPTIONS")
+	// Auth routes. Account and security changes are refused to admins
+	// impersonating the user.
+	protected.HandleFunc("/auth/logout", HandleLogout(a.AuthService)).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/sessions", HandleListSessions(a.AuthService)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/sessions", DenyWhileImpersonating(HandleRevokeOtherSessions(a.AuthService))).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/auth/sessions/{id}", DenyWhileImpersonating(HandleRevokeSession(a.AuthService))).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/auth/2fa", HandleTwoFactorStatus(a.AuthService, a.SettingsService)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/2fa/enroll", DenyWhileImpersonating(HandleTwoFactorEnroll(a.AuthService, a.SettingsService))).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/2fa/confirm", DenyWhileImpersonating(HandleTwoFactorConfirm(a.AuthService, a.SettingsService))).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/2fa/disable", DenyWhileImpersonating(HandleTwoFactorDisable(a.AuthService, a.SettingsService))).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/2fa/recovery-codes", DenyWhileImpersonating(HandleRegenerateRecoveryCodes(a.AuthService))).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/passkeys", HandleListPasskeys(a.AuthService)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/passkeys", DenyWhileImpersonating(HandleRegisterPasskey(a.AuthService, a.SettingsService))).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/passkeys/register/options", DenyWhileImpersonating(HandlePasskeyRegistrationOptions(a.AuthService, a.SettingsService))).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/passkeys/{id}", DenyWhileImpersonating(HandleDeletePasskey(a.AuthService, a.SettingsService))).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/auth/tokens", HandleListAPIKeys(a.AuthService)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/tokens", DenyWhileImpersonating(HandleCreateAPIKey(a.AuthService))).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/tokens/{id}", DenyWhileImpersonating(HandleRevokeAPIKey(a.AuthService))).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/auth/s3-credentials", HandleListS3Credentials(a.AuthService)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/s3-credentials", DenyWhileImpersonating(HandleCreateS3Credential(a.AuthService))).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/s3-credentials/{id}", DenyWhileImpersonating(HandleRevokeS3Credential(a.AuthService))).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/auth/identities", HandleListIdentities(a.AuthService)).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/auth/me", HandleGetCurrentUser()).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/auth/change-password", HandleChangePassword(a.AuthService)).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/change-password", DenyWhileImpersonating(HandleChangePassword(a.AuthService, a.SettingsService))).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/organization", DenyWhileImpersonating(HandleSwitchOrganization(a.AuthService, a.SettingsService, a.orgAccess))).Methods("POST", "OPTIONS")
 
 	// User routes
-	protected.HandleFunc("/users", HandleGetUsers(a.UserService)).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/users/{id}", HandleGetUser(a.UserService)).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/users/{id}", HandleUpdateUser(a.UserService)).Methods("PATCH", "OPTIONS")
-	protected.HandleFunc("/users/{id}", HandleDeleteUser(a.UserService)).Methods("DELETE", "OPTIONS")
+	protected.Handle("/users", a.requirePermission(services.PermissionUsersRead, HandleGetUsers(a.UserService))).Methods("GET", "OPTIONS")
+	protected.Handle("/users/locked", a.requirePermission(services.PermissionUsersRead, HandleListLockouts(a.AuthService, a.SettingsService))).Methods("GET", "OPTIONS")
+	protected.Handle("/users/{id}", a.requirePermission(services.PermissionUsersRead, HandleGetUser(a.UserService))).Methods("GET", "OPTIONS")
+	protected.Handle("/users/{id}", a.requirePermission(services.PermissionUsersManage, DenyWhileImpersonating(HandleUpdateUser(a.UserService, a.RBACService)))).Methods("PATCH", "OPTIONS")
+	protected.Handle("/users/{id}", a.requirePermission(services.PermissionUsersManage, DenyWhileImpersonating(HandleDeleteUser(a.UserService, a.RBACService, a.OrgService)))).Methods("DELETE", "OPTIONS")
+	protected.Handle("/users/{id}/impersonate", a.requirePermission(services.PermissionUsersImpersonate, HandleImpersonateUser(a.UserService, a.RBACService))).Methods("POST", "OPTIONS")
+	protected.Handle("/users/{id}/unlock", a.requirePermission(services.PermissionUsersManage, HandleUnlockUser(a.AuthService))).Methods("POST", "OPTIONS")
+	protected.Handle("/users/{id}/roles", a.requirePermission(services.PermissionUsersRead, HandleGetUserRoles(a.UserService, a.RBACService))).Methods("GET", "OPTIONS")
+	protected.Handle("/users/{id}/roles", a.requirePermission(services.PermissionRolesManage, HandleBindUserRole(a.UserService, a.RBACService))).Methods("POST", "OPTIONS")
+	protected.Handle("/users/{id}/roles/{role}", a.requirePermission(services.PermissionRolesManage, HandleUnbindUserRole(a.RBACService))).Methods("DELETE", "OPTIONS")
+
+	// Data export and erasure jobs, for subject access and deletion requests
+	protected.Handle("/users/{id}/data-export", a.requirePermission(services.PermissionUsersManage, HandleStartUserDataExport(a.UserDataService))).Methods("POST", "OPTIONS")
+	protected.Handle("/users/{id}/data-erasure", a.requirePermission(services.PermissionUsersManage, DenyWhileImpersonating(HandleStartUserDataErasure(a.UserDataService)))).Methods("POST", "OPTIONS")
+	protected.Handle("/users/{id}/data-jobs", a.requirePermission(services.PermissionUsersRead, HandleListUserDataJobs(a.UserDataService))).Methods("GET", "OPTIONS")
+	protected.Handle("/data-jobs/{id}", a.requirePermission(services.PermissionUsersRead, HandleGetUserDataJob(a.UserDataService))).Methods("GET", "OPTIONS")
+	protected.Handle("/data-jobs/{id}/download", a.requirePermission(services.PermissionUsersManage, HandleDownloadUserDataExport(a.UserDataService))).Methods("GET", "OPTIONS")
+
+	// Role routes
+	protected.Handle("/roles", a.requirePermission(services.PermissionRolesManage, HandleListRoles(a.RBACService))).Methods("GET", "OPTIONS")
+	protected.Handle("/roles", a.requirePermission(services.PermissionRolesManage, HandleCreateRole(a.RBACService))).Methods("POST", "OPTIONS")
+	protected.Handle("/roles/permissions", a.requirePermission(services.PermissionRolesManage, HandleListPermissions(a.RBACService))).Methods("GET", "OPTIONS")
+	protected.Handle("/roles/{name}", a.requirePermission(services.PermissionRolesManage, HandleUpdateRole(a.RBACService))).Methods("PUT", "OPTIONS")
+	protected.Handle("/roles/{name}", a.requirePermission(services.PermissionRolesManage, HandleDeleteRole(a.RBACService))).Methods("DELETE", "OPTIONS")
+
+	// Organization routes, membership is checked by the handlers
+	protected.HandleFunc("/organizations", HandleListOrganizations(a.OrgService)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/organizations", HandleCreateOrganization(a.OrgService)).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/organizations/invites/accept", HandleAcceptOrganizationInvite(a.OrgService)).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/organizations/{id}", HandleGetOrganization(a.orgAccess)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/organizations/{id}", HandleUpdateOrganization(a.orgAccess)).Methods("PATCH", "OPTIONS")
+	protected.HandleFunc("/organizations/{id}", DenyWhileImpersonating(HandleDeleteOrganization(a.orgAccess))).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/organizations/{id}/members", HandleListOrganizationMembers(a.orgAccess)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/organizations/{id}/members/{userId}", HandleUpdateOrganizationMember(a.orgAccess)).Methods("PATCH", "OPTIONS")
+	protected.HandleFunc("/organizations/{id}/members/{userId}", HandleRemoveOrganizationMember(a.orgAccess)).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/organizations/{id}/invites", HandleListOrganizationInvites(a.orgAccess)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/organizations/{id}/invites", HandleCreateOrganizationInvite(a.orgAccess, a.SettingsService, a.MailService)).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/organizations/{id}/invites/{inviteId}", HandleRevokeOrganizationInvite(a.orgAccess)).Methods("DELETE", "OPTIONS")
 
 	// Dashboard routes
 	protected.HandleFunc("/dashboard/stats", HandleGetDashboardStats(
@@ -135,8 +227,9 @@ func (a *API) setupRoutes() {
 
 	// Storage routes (temporarily public for development)
 	apiRouter.HandleFunc("/storage/buckets", a.storageHandlers.HandleGetStorageBuckets).Methods("GET", "OPTIONS")
+	// Bucket creation and deletion need the storage permissions or an organization admin role
 	apiRouter.HandleFunc("/storage/buckets", a.storageHandlers.HandleCreateBucket).Methods("POST", "OPTIONS")
-	apiRouter.HandleFunc("/storage/buckets/{bucket}", a.storageHandlers.HandleDeleteBucket).Methods("DELETE", "OPTIONS")
+	apiRouter.HandleFunc("/storage/buckets/{bucket}", DenyWhileImpersonating(a.storageHandlers.HandleDeleteBucket)).Methods("DELETE", "OPTIONS")
 	apiRouter.HandleFunc("/storage/buckets/{bucket}/objects", a.storageHandlers.HandleGetBucketObjects).Methods("GET", "OPTIONS")
 	apiRouter.HandleFunc("/storage/buckets/{bucket}/upload", a.storageHandlers.HandleUploadFile).Methods("POST", "OPTIONS")
 	apiRouter.HandleFunc("/storage/buckets/{bucket}/upload-url", a.storageHandlers.HandleGenerateUploadURL).Methods("POST", "OPTIONS")
@@ -148,12 +241,34 @@ func (a *API) setupRoutes() {
 	apiRouter.HandleFunc("/storage/direct/{token}", a.storageHandlers.HandleDirectDownload).Methods("GET", "OPTIONS")
 	apiRouter.HandleFunc("/storage/buckets/{bucket}/objects/{id}/rename", a.storageHandlers.HandleRenameObject).Methods("PATCH", "OPTIONS")
 	apiRouter.HandleFunc("/storage/buckets/{bucket}/objects/{id}/metadata", a.storageHandlers.HandleUpdateObjectMetadata).Methods("PATCH", "OPTIONS")
+	// Versioned buckets keep the prior content of overwritten files
+	apiRouter.HandleFunc("/storage/buckets/{bucket}/versioning", a.storageHandlers.HandleUpdateBucketVersioning).Methods("PATCH", "OPTIONS")
+	apiRouter.HandleFunc("/storage/buckets/{bucket}/objects/{id}", a.storageHandlers.HandleReplaceObject).Methods("PUT", "OPTIONS")
+	apiRouter.HandleFunc("/storage/buckets/{bucket}/objects/{id}/versions", a.storageHandlers.HandleListObjectVersions).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/storage/buckets/{bucket}/objects/{id}/versions", a.storageHandlers.HandlePurgeObjectVersions).Methods("DELETE", "OPTIONS")
+	apiRouter.HandleFunc("/storage/buckets/{bucket}/objects/{id}/versions/{versionId}", a.storageHandlers.HandleDeleteObjectVersion).Methods("DELETE", "OPTIONS")
+	apiRouter.HandleFunc("/storage/buckets/{bucket}/objects/{id}/versions/{versionId}/download", a.storageHandlers.HandleDownloadObjectVersion).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/storage/buckets/{bucket}/objects/{id}/versions/{versionId}/restore", a.storageHandlers.HandleRestoreObjectVersion).Methods("POST", "OPTIONS")
 	apiRouter.HandleFunc("/storage/buckets/{bucket}/folders", a.storageHandlers.HandleCreateFolder).Methods("POST", "OPTIONS")
 	
+	// Trash routes, for deleted objects until they are purged
+	apiRouter.HandleFunc("/storage/trash", a.storageHandlers.HandleGetTrash).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/storage/trash", DenyWhileImpersonating(a.storageHandlers.HandleEmptyTrash)).Methods("DELETE", "OPTIONS")
+	apiRouter.HandleFunc("/storage/trash/{id}/restore", a.storageHandlers.HandleRestoreTrashedObject).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/storage/trash/{id}", DenyWhileImpersonating(a.storageHandlers.HandlePurgeTrashedObject)).Methods("DELETE", "OPTIONS")
+
+	// Resumable uploads (tus 1.0)
+	apiRouter.HandleFunc("/storage/uploads", a.storageHandlers.HandleCreateUpload).Methods("POST")
+	apiRouter.HandleFunc("/storage/uploads", a.storageHandlers.HandleUploadOptions).Methods("OPTIONS").Name(tusRoutePrefix + "uploads")
+	apiRouter.HandleFunc("/storage/uploads/{id}", a.storageHandlers.HandleGetUploadOffset).Methods("HEAD")
+	apiRouter.HandleFunc("/storage/uploads/{id}", a.storageHandlers.HandlePatchUpload).Methods("PATCH")
+	apiRouter.HandleFunc("/storage/uploads/{id}", a.storageHandlers.HandleTerminateUpload).Methods("DELETE")
+	apiRouter.HandleFunc("/storage/uploads/{id}", a.storageHandlers.HandleUploadOptions).Methods("OPTIONS").Name(tusRoutePrefix + "upload")
+
 	// Storage quota and statistics routes
 	apiRouter.HandleFunc("/storage/quota", a.storageHandlers.HandleGetStorageQuota).Methods("GET", "OPTIONS")
 	apiRouter.HandleFunc("/storage/stats", a.storageHandlers.HandleGetStorageStats).Methods("GET", "OPTIONS")
-	apiRouter.HandleFunc("/storage/admin/stats", a.storageHandlers.HandleGetAdminStorageStats).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/storage/admin/stats", a.requirePermission(services.PermissionStorageAdmin, a.storageHandlers.HandleGetAdminStorageStats)).Methods("GET", "OPTIONS")
 	
 	// Recently viewed routes
 	apiRouter.HandleFunc("/storage/recently-viewed", a.storageHandlers.HandleGetRecentlyViewed).Methods("GET", "OPTIONS")
@@ -171,17 +286,25 @@ func (a *API) setupRoutes() {
 	apiRouter.HandleFunc("/logs/export", HandleExportLogs(a.LogsService)).Methods("GET", "OPTIONS")
 
 	// Collection routes
-	protected.HandleFunc("/collections", HandleGetCollections(a.CollectionService)).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/collections", HandleCreateCollection(a.CollectionService)).Methods("POST", "OPTIONS")
-	protected.HandleFunc("/collections/{id}", HandleGetCollection(a.CollectionService)).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/collections/{id}", HandleUpdateCollection(a.CollectionService)).Methods("PATCH", "OPTIONS")
-	protected.HandleFunc("/collections/{id}", HandleDeleteCollection(a.CollectionService)).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/collections", HandleGetCollections(a.CollectionService, a.orgAccess)).Methods("GET", "OPTIONS")
+	protected.Handle("/collections", a.requirePermission(services.PermissionCollectionsManage, HandleCreateCollection(a.CollectionService, a.orgAccess))).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/collections/{id}", HandleGetCollection(a.CollectionService, a.orgAccess)).Methods("GET", "OPTIONS")
+	protected.Handle("/collections/{id}", a.requirePermission(services.PermissionCollectionsManage, HandleUpdateCollection(a.CollectionService, a.orgAccess))).Methods("PATCH", "OPTIONS")
+	protected.Handle("/collections/{id}", a.requirePermission(services.PermissionCollectionsManage, HandleDeleteCollection(a.CollectionService, a.orgAccess))).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/collections/{id}/records", HandleListRecords(a.CollectionService)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/collections/{id}/records", HandleCreateRecord(a.CollectionService)).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/collections/{id}/records/{recordId}", HandleGetRecord(a.CollectionService)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/collections/{id}/records/{recordId}", HandleUpdateRecord(a.CollectionService)).Methods("PATCH", "OPTIONS")
+	protected.HandleFunc("/collections/{id}/records/{recordId}", HandleDeleteRecord(a.CollectionService)).Methods("DELETE", "OPTIONS")
 
 	// Settings routes
 	protected.HandleFunc("/settings", HandleGetSettings(a.SettingsService)).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/settings", HandleUpdateSettings(a.SettingsService)).Methods("PATCH", "OPTIONS")
-	protected.HandleFunc("/settings", HandleSetSetting(a.SettingsService)).Methods("POST", "OPTIONS")
-	protected.HandleFunc("/settings/reset", HandleResetSettings(a.SettingsService)).Methods("POST", "OPTIONS")
+	protected.Handle("/settings", a.requirePermission(services.PermissionSettingsManage, HandleUpdateSettings(a.SettingsService))).Methods("PATCH", "OPTIONS")
+	protected.Handle("/settings", a.requirePermission(services.PermissionSettingsManage, HandleSetSetting(a.SettingsService))).Methods("POST", "OPTIONS")
+	protected.Handle("/settings/reset", a.requirePermission(services.PermissionSettingsManage, HandleResetSettings(a.SettingsService))).Methods("POST", "OPTIONS")
+	protected.Handle("/settings/auth-providers", a.requirePermission(services.PermissionSettingsManage, HandleGetOAuthProviderSettings(a.OAuthService, a.SettingsService))).Methods("GET", "OPTIONS")
+	protected.Handle("/settings/auth-providers/{name}", a.requirePermission(services.PermissionSettingsManage, HandleSaveOAuthProvider(a.OAuthService, a.SettingsService))).Methods("PUT", "OPTIONS")
+	protected.Handle("/settings/auth-providers/{name}", a.requirePermission(services.PermissionSettingsManage, HandleDeleteOAuthProvider(a.OAuthService))).Methods("DELETE", "OPTIONS")
 	protected.HandleFunc("/settings/{key}", HandleGetSetting(a.SettingsService)).Methods("GET", "OPTIONS")
 	
 	// Extensions routes (temporarily public for development)
@@ -213,6 +336,7 @@ func (a *API) setupRoutes() {
 	// Products extension routes
 	// Initialize products handlers lazily to ensure migrations have run
 	a.productHandlers = NewProductsExtensionHandlersWithDB(a.DB.DB)
+	a.productHandlers.SetOrganizationAccess(a.orgAccess)
 	
 	// Products basic CRUD (for compatibility)
 	apiRouter.HandleFunc("/ext/products/api/products", a.productHandlers.HandleProductsList()).Methods("GET", "OPTIONS")
@@ -284,6 +408,11 @@ func (a *API) setupRoutes() {
 	apiRouter.HandleFunc("/shares/{id}", a.sharesHandler.HandleShareByID()).Methods("GET", "DELETE", "OPTIONS")
 }
 
+// requirePermission wraps a route's handler with RequirePermission
+func (a *API) requirePermission(permission string, handler http.HandlerFunc) http.Handler {
+	return RequirePermission(a.RBACService, permission)(handler)
+}
+
 func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.Router.ServeHTTP(w, r)
 }
\ No newline at end of file
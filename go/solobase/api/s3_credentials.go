@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+)
+
+type CreateS3CredentialRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// ExpiresIn is an alternative to ExpiresAt, in days
+	ExpiresIn int `json:"expires_in_days,omitempty"`
+}
+
+type CreateS3CredentialResponse struct {
+	models.S3Credential
+	// SecretAccessKey is only returned when the credential is created
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+// HandleListS3Credentials lists the current user's S3 credentials
+func HandleListS3Credentials(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value("user").(*auth.User)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		credentials, err := authService.ListS3Credentials(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to list S3 credentials")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, credentials)
+	}
+}
+
+// HandleCreateS3Credential creates an S3 access key for the current user
+func HandleCreateS3Credential(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value("user").(*auth.User)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var req CreateS3CredentialRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		expiresAt := req.ExpiresAt
+		if expiresAt == nil && req.ExpiresIn > 0 {
+			t := time.Now().AddDate(0, 0, req.ExpiresIn)
+			expiresAt = &t
+		}
+
+		credential, secret, err := authService.CreateS3Credential(user.ID, req.Name, req.Scopes, expiresAt)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("S3 credential %s created for user %s", credential.ID, user.Email)
+		respondWithJSON(w, http.StatusCreated, CreateS3CredentialResponse{S3Credential: *credential, SecretAccessKey: secret})
+	}
+}
+
+// HandleRevokeS3Credential deletes one of the current user's S3 credentials
+func HandleRevokeS3Credential(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value("user").(*auth.User)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		if err := authService.RevokeS3Credential(user.ID, mux.Vars(r)["id"]); err != nil {
+			if errors.Is(err, services.ErrS3CredentialNotFound) {
+				respondWithError(w, http.StatusNotFound, "S3 credential not found")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to revoke S3 credential")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "S3 credential revoked"})
+	}
+}
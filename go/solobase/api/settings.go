@@ -5,7 +5,6 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
-	auth "github.com/suppers-ai/auth"
 	"github.com/suppers-ai/solobase/services"
 )
 
@@ -23,13 +22,6 @@ func HandleGetSettings(settingsService *services.SettingsService) http.HandlerFu
 
 func HandleUpdateSettings(settingsService *services.SettingsService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check if user is admin
-		user := r.Context().Value("user").(*auth.User)
-		if user.Role != "admin" {
-			respondWithError(w, http.StatusForbidden, "Insufficient permissions")
-			return
-		}
-
 		var updates map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid request body")
@@ -48,13 +40,6 @@ func HandleUpdateSettings(settingsService *services.SettingsService) http.Handle
 
 func HandleResetSettings(settingsService *services.SettingsService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check if user is admin
-		user := r.Context().Value("user").(*auth.User)
-		if user.Role != "admin" {
-			respondWithError(w, http.StatusForbidden, "Insufficient permissions")
-			return
-		}
-
 		if err := settingsService.ResetToDefaults(); err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Failed to reset settings")
 			return
@@ -92,16 +77,6 @@ func HandleGetSetting(settingsService *services.SettingsService) http.HandlerFun
 // HandleSetSetting creates or updates a single setting
 func HandleSetSetting(settingsService *services.SettingsService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check if user is admin
-		userVal := r.Context().Value("user")
-		if userVal != nil {
-			user := userVal.(*auth.User)
-			if user.Role != "admin" {
-				respondWithError(w, http.StatusForbidden, "Insufficient permissions")
-				return
-			}
-		}
-
 		var req struct {
 			Key   string      `json:"key"`
 			Value interface{} `json:"value"`
@@ -123,10 +98,15 @@ func HandleSetSetting(settingsService *services.SettingsService) http.HandlerFun
 			return
 		}
 
+		value := req.Value
+		if services.IsSecretSetting(req.Key) {
+			value = services.RedactedSecret
+		}
+
 		respondWithJSON(w, http.StatusOK, map[string]interface{}{
 			"success": true,
 			"key":     req.Key,
-			"value":   req.Value,
+			"value":   value,
 		})
 	}
 }
\ No newline at end of file
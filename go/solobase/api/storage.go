@@ -1,9 +1,9 @@
 package api
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,9 +12,9 @@ import (
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	auth "github.com/suppers-ai/auth"
 	"github.com/suppers-ai/solobase/database"
 	"github.com/suppers-ai/solobase/extensions/core"
 	"github.com/suppers-ai/solobase/models"
@@ -24,43 +24,69 @@ import (
 
 // StorageHandlers contains all storage-related handlers with hook support
 type StorageHandlers struct {
-	storageService *services.StorageService
-	db             *database.DB
-	hookRegistry   *core.ExtensionRegistry
+	storageService  *services.StorageService
+	settingsService *services.SettingsService
+	db              *database.DB
+	hookRegistry    *core.ExtensionRegistry
+	rbacService     *services.RBACService
+	orgAccess       *OrganizationAccess
 }
 
-// extractUserIDFromToken extracts user ID from JWT token in Authorization header
+// extractUserIDFromToken returns the ID of the caller identified by
+// OptionalAuthMiddleware, which has already checked the JWT or API key,
+// including session revocation and two-factor setup restrictions
 func extractUserIDFromToken(r *http.Request) string {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return ""
+	if user, ok := r.Context().Value("user").(*auth.User); ok {
+		return user.ID.String()
 	}
+	return ""
+}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	if tokenString == authHeader {
-		return ""
+// NewStorageHandlers creates new storage handlers with hook support
+func NewStorageHandlers(storageService *services.StorageService, settingsService *services.SettingsService, db *database.DB, hookRegistry *core.ExtensionRegistry, rbacService *services.RBACService, orgAccess *OrganizationAccess) *StorageHandlers {
+	return &StorageHandlers{
+		storageService:  storageService,
+		settingsService: settingsService,
+		db:              db,
+		hookRegistry:    hookRegistry,
+		rbacService:     rbacService,
+		orgAccess:       orgAccess,
 	}
+}
 
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Use the same secret as in middleware.go
-		return jwtSecret, nil
-	})
-
-	if err != nil || !token.Valid {
-		return ""
+// checkBucketAccess makes sure the caller has at least minRole in the
+// organization owning a bucket, responding with an error otherwise. Buckets
+// without an organization are left to the per-object checks.
+func (h *StorageHandlers) checkBucketAccess(w http.ResponseWriter, r *http.Request, bucket, minRole string) bool {
+	if bucket == "user-files" || bucket == "int_storage" {
+		return true
+	}
+	record, err := h.storageService.GetBucket(bucket)
+	if err != nil || record.OrganizationID == nil {
+		return true
 	}
 
-	return claims.UserID
+	role := h.orgAccess.Role(r, *record.OrganizationID)
+	switch {
+	case extractUserIDFromToken(r) == "":
+		respondWithError(w, http.StatusUnauthorized, "Authentication required")
+	case role == "":
+		respondWithError(w, http.StatusNotFound, "Bucket not found")
+	case !services.OrgRoleAtLeast(role, minRole):
+		respondWithError(w, http.StatusForbidden, "Access denied")
+	default:
+		return true
+	}
+	return false
 }
 
-// NewStorageHandlers creates new storage handlers with hook support
-func NewStorageHandlers(storageService *services.StorageService, db *database.DB, hookRegistry *core.ExtensionRegistry) *StorageHandlers {
-	return &StorageHandlers{
-		storageService: storageService,
-		db:             db,
-		hookRegistry:   hookRegistry,
+// canManageBucket reports whether the caller may create or delete a bucket of
+// an organization, or any bucket with the given permission
+func (h *StorageHandlers) canManageBucket(r *http.Request, orgID, permission string) bool {
+	if orgID != "" && h.orgAccess.Can(r, orgID, services.OrgRoleAdmin) {
+		return true
 	}
+	return h.rbacService != nil && hasPermission(h.rbacService, r, permission)
 }
 
 // HandleGetStorageBuckets handles bucket listing
@@ -71,7 +97,19 @@ func (h *StorageHandlers) HandleGetStorageBuckets(w http.ResponseWriter, r *http
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, buckets)
+	// Hide the buckets of organizations the caller does not belong to
+	roles, all := h.orgAccess.Roles(r)
+	visible := make([]interface{}, 0, len(buckets))
+	for _, bucket := range buckets {
+		if info, ok := bucket.(map[string]interface{}); ok && !all {
+			if orgID, _ := info["organization_id"].(*string); orgID != nil && roles[*orgID] == "" {
+				continue
+			}
+		}
+		visible = append(visible, bucket)
+	}
+
+	respondWithJSON(w, http.StatusOK, visible)
 }
 
 // HandleGetBucketObjects handles object listing in a bucket
@@ -79,6 +117,10 @@ func (h *StorageHandlers) HandleGetBucketObjects(w http.ResponseWriter, r *http.
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
+	if !h.checkBucketAccess(w, r, bucket, services.OrgRoleViewer) {
+		return
+	}
+
 	// Get user ID from context if available, otherwise try to extract from token
 	userID, _ := r.Context().Value("user_id").(string)
 	if userID == "" {
@@ -117,6 +159,8 @@ func (h *StorageHandlers) HandleCreateBucket(w http.ResponseWriter, r *http.Requ
 	var request struct {
 		Name   string `json:"name"`
 		Public bool   `json:"public"`
+		// OrganizationID defaults to the caller's active organization
+		OrganizationID *string `json:"organization_id"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -129,15 +173,24 @@ func (h *StorageHandlers) HandleCreateBucket(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err := h.storageService.CreateBucket(request.Name, request.Public)
+	// Organization admins create their organization's buckets, any other
+	// bucket needs the storage.bucket.create permission
+	orgID := resourceOrganization(r, request.OrganizationID)
+	if !h.canManageBucket(r, orgID, services.PermissionStorageBucketCreate) {
+		respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+
+	err := h.storageService.CreateOrganizationBucket(request.Name, request.Public, orgID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
-		"message": "Bucket created successfully",
-		"name":    request.Name,
+		"message":         "Bucket created successfully",
+		"name":            request.Name,
+		"organization_id": orgID,
 	})
 }
 
@@ -146,6 +199,15 @@ func (h *StorageHandlers) HandleDeleteBucket(w http.ResponseWriter, r *http.Requ
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
+	orgID := ""
+	if record, err := h.storageService.GetBucket(bucket); err == nil && record.OrganizationID != nil {
+		orgID = *record.OrganizationID
+	}
+	if !h.canManageBucket(r, orgID, services.PermissionStorageBucketDelete) {
+		respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+
 	err := h.storageService.DeleteBucket(bucket)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
@@ -162,9 +224,25 @@ func (h *StorageHandlers) HandleUploadFile(w http.ResponseWriter, r *http.Reques
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
+	if !h.checkBucketAccess(w, r, bucket, services.OrgRoleMember) {
+		return
+	}
+
+	// Stop reading bodies well over the upload limit; the form fields around
+	// the file are allowed a little room
+	policy := uploadPolicy(h.settingsService)
+	if policy.MaxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, policy.MaxSize+1<<20)
+	}
+
 	// Parse multipart form
 	err := r.ParseMultipartForm(32 << 20) // 32MB max
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondUploadRejected(w, services.ErrFileTooLarge)
+			return
+		}
 		respondWithError(w, http.StatusBadRequest, "Failed to parse form")
 		return
 	}
@@ -185,6 +263,11 @@ func (h *StorageHandlers) HandleUploadFile(w http.ResponseWriter, r *http.Reques
 		contentType = "application/octet-stream"
 	}
 
+	if err := policy.Check(header.Filename, contentType, header.Size); err != nil {
+		respondUploadRejected(w, err)
+		return
+	}
+
 	// Get user ID from context if available, otherwise try to extract from token
 	userID, _ := r.Context().Value("user_id").(string)
 	if userID == "" {
@@ -224,20 +307,13 @@ func (h *StorageHandlers) HandleUploadFile(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	// Read file content for upload
-	fileContent, err := io.ReadAll(file)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to read file")
-		return
-	}
-
 	// Upload file using the storage service
 	var parentFolderPtr *string
 	if parentFolderID != "" {
 		parentFolderPtr = &parentFolderID
 	}
 
-	object, err := h.storageService.UploadFile(bucket, header.Filename, userID, bytes.NewReader(fileContent), header.Size, contentType, parentFolderPtr)
+	object, err := h.storageService.UploadFile(bucket, header.Filename, userID, file, header.Size, contentType, parentFolderPtr)
 
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to upload file: "+err.Error())
@@ -274,12 +350,16 @@ func (h *StorageHandlers) HandleUploadFile(w http.ResponseWriter, r *http.Reques
 	respondWithJSON(w, http.StatusCreated, object)
 }
 
-// HandleDeleteObject handles object deletion
+// HandleDeleteObject moves an object to the trash
 func (h *StorageHandlers) HandleDeleteObject(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 	objectID := vars["id"]
 
+	if !h.checkBucketAccess(w, r, bucket, services.OrgRoleMember) {
+		return
+	}
+
 	// Get user ID from context if available, otherwise try to extract from token
 	userID, _ := r.Context().Value("user_id").(string)
 	if userID == "" {
@@ -317,18 +397,22 @@ func (h *StorageHandlers) HandleDeleteObject(w http.ResponseWriter, r *http.Requ
 		}
 
 		// Delete from int_storage bucket
-		err = h.storageService.DeleteObject("int_storage", objectID)
+		err = h.storageService.DeleteObject("int_storage", objectID, userID)
 	} else {
 		// For other buckets, proceed normally
-		err = h.storageService.DeleteObject(bucket, objectID)
+		err = h.storageService.DeleteObject(bucket, objectID, userID)
 	}
 
+	if errors.Is(err, services.ErrObjectNotFound) {
+		respondWithError(w, http.StatusNotFound, "Object not found")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to delete object: "+err.Error())
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Object deleted successfully"})
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Object moved to trash"})
 }
 
 // HandleDownloadObject handles file downloads with hook support
@@ -337,6 +421,10 @@ func (h *StorageHandlers) HandleDownloadObject(w http.ResponseWriter, r *http.Re
 	bucket := vars["bucket"]
 	objectID := vars["id"]
 
+	if !h.checkBucketAccess(w, r, bucket, services.OrgRoleViewer) {
+		return
+	}
+
 	log.Printf("HandleDownloadObject: bucket=%s, objectID=%s", bucket, objectID)
 
 	// Get user ID from context if available, otherwise try to extract from token
@@ -456,6 +544,10 @@ func (h *StorageHandlers) HandleGetObject(w http.ResponseWriter, r *http.Request
 	bucket := vars["bucket"]
 	objectID := vars["id"]
 
+	if !h.checkBucketAccess(w, r, bucket, services.OrgRoleViewer) {
+		return
+	}
+
 	// Get user ID from context if available, otherwise try to extract from token
 	userID, _ := r.Context().Value("user_id").(string)
 	if userID == "" {
@@ -510,6 +602,10 @@ func (h *StorageHandlers) HandleRenameObject(w http.ResponseWriter, r *http.Requ
 	bucket := vars["bucket"]
 	objectID := vars["id"]
 
+	if !h.checkBucketAccess(w, r, bucket, services.OrgRoleMember) {
+		return
+	}
+
 	// Get user ID from context if available, otherwise try to extract from token
 	userID, _ := r.Context().Value("user_id").(string)
 	if userID == "" {
@@ -577,6 +673,10 @@ func (h *StorageHandlers) HandleCreateFolder(w http.ResponseWriter, r *http.Requ
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
+	if !h.checkBucketAccess(w, r, bucket, services.OrgRoleMember) {
+		return
+	}
+
 	// Get user ID from context if available, otherwise try to extract from token
 	userID, _ := r.Context().Value("user_id").(string)
 	if userID == "" {
@@ -655,6 +755,10 @@ func (h *StorageHandlers) HandleGenerateDownloadURL(w http.ResponseWriter, r *ht
 	bucket := vars["bucket"]
 	objectID := vars["id"]
 
+	if !h.checkBucketAccess(w, r, bucket, services.OrgRoleViewer) {
+		return
+	}
+
 	// Get user ID from context
 	userID, _ := r.Context().Value("user_id").(string)
 	if userID == "" {
@@ -738,6 +842,10 @@ func (h *StorageHandlers) HandleGenerateUploadURL(w http.ResponseWriter, r *http
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
+	if !h.checkBucketAccess(w, r, bucket, services.OrgRoleMember) {
+		return
+	}
+
 	var request struct {
 		Filename       string  `json:"filename"`
 		ParentFolderID *string `json:"parent_folder_id,omitempty"`
@@ -759,9 +867,17 @@ func (h *StorageHandlers) HandleGenerateUploadURL(w http.ResponseWriter, r *http
 		request.ContentType = "application/octet-stream"
 	}
 
+	policy := uploadPolicy(h.settingsService)
+	if request.MaxSize == 0 {
+		request.MaxSize = policy.MaxSize
+	}
 	if request.MaxSize == 0 {
 		request.MaxSize = 10 << 20 // 10MB default
 	}
+	if err := policy.Check(request.Filename, request.ContentType, request.MaxSize); err != nil {
+		respondUploadRejected(w, err)
+		return
+	}
 
 	// Get user ID from context
 	userID, _ := r.Context().Value("user_id").(string)
@@ -932,17 +1048,8 @@ func (h *StorageHandlers) HandleDirectUpload(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Read file from request body
-	fileContent, err := io.ReadAll(io.LimitReader(r.Body, token.MaxSize+1))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Failed to read file")
-		return
-	}
-
-	fileSize := int64(len(fileContent))
-
 	// Check file size
-	if fileSize > token.MaxSize {
+	if r.ContentLength > token.MaxSize {
 		respondWithError(w, http.StatusRequestEntityTooLarge, "File exceeds maximum size")
 		return
 	}
@@ -953,18 +1060,27 @@ func (h *StorageHandlers) HandleDirectUpload(w http.ResponseWriter, r *http.Requ
 	if false { // ObjectPath no longer used
 		fullPath = "" + "/" + token.ObjectName
 	}
-	object, err := h.storageService.UploadFile(token.Bucket, fullPath, token.UserID, bytes.NewReader(fileContent), fileSize, token.ContentType, nil)
+	// Stream the request body, which stops being read past the maximum size
+	body := http.MaxBytesReader(w, r.Body, token.MaxSize)
+	object, err := h.storageService.UploadFile(token.Bucket, fullPath, token.UserID, body, r.ContentLength, token.ContentType, nil)
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "File exceeds maximum size")
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Failed to upload file")
 		return
 	}
 
-	// Extract object ID from response
+	// Extract object ID and size from response
 	objectIDStr := ""
+	var fileSize int64
 	if objMap, ok := object.(map[string]interface{}); ok {
 		if id, ok := objMap["id"].(string); ok {
 			objectIDStr = id
 		}
+		fileSize, _ = objMap["size"].(int64)
 	}
 
 	// Mark token as completed
@@ -1128,8 +1244,8 @@ func (h *StorageHandlers) HandleGetStorageStats(w http.ResponseWriter, r *http.R
 		"folderCount":   stats["folder_count"],
 		"sharedCount":   stats["shared_count"],
 		"recentUploads": stats["recent_uploads"],
+		"trashedCount":  stats["trashed_count"],
 		// Additional fields for compatibility
-		"trashedCount": 0, // We don't have trash functionality yet
 		"totalFiles":   stats["file_count"],
 		"totalFolders": stats["folder_count"],
 	}
@@ -1137,10 +1253,9 @@ func (h *StorageHandlers) HandleGetStorageStats(w http.ResponseWriter, r *http.R
 	respondWithJSON(w, http.StatusOK, response)
 }
 
-// HandleGetAdminStorageStats returns storage statistics for all users (admin only)
+// HandleGetAdminStorageStats returns storage statistics for all users. The
+// route requires the storage.admin permission.
 func (h *StorageHandlers) HandleGetAdminStorageStats(w http.ResponseWriter, r *http.Request) {
-	// TODO: Add admin role check here
-
 	stats, err := h.storageService.GetAllUsersStorageStats()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to get storage statistics")
@@ -1296,6 +1411,10 @@ func (h *StorageHandlers) HandleUpdateObjectMetadata(w http.ResponseWriter, r *h
 	bucket := vars["bucket"]
 	objectID := vars["id"]
 
+	if !h.checkBucketAccess(w, r, bucket, services.OrgRoleMember) {
+		return
+	}
+
 	// Get user ID from context if available, otherwise try to extract from token
 	userID, _ := r.Context().Value("user_id").(string)
 	if userID == "" {
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/suppers-ai/solobase/services"
+	pkgstorage "github.com/suppers-ai/storage"
+)
+
+// authorizeTrashedObject makes sure the caller owns or deleted an object in
+// the trash and can still write to its bucket. It responds with an error and
+// returns a nil object otherwise.
+func (h *StorageHandlers) authorizeTrashedObject(w http.ResponseWriter, r *http.Request) *pkgstorage.StorageObject {
+	userID := extractUserIDFromToken(r)
+	if userID == "" {
+		respondWithError(w, http.StatusUnauthorized, "Authentication required")
+		return nil
+	}
+
+	object, err := h.storageService.GetTrashedObject(mux.Vars(r)["id"])
+	if err != nil {
+		respondTrashError(w, err, "Failed to get object")
+		return nil
+	}
+
+	allowed := object.UserID == userID || (object.DeletedBy != nil && *object.DeletedBy == userID)
+	if allowed && h.storageService.GetAppID() != "" {
+		allowed = object.AppID != nil && *object.AppID == h.storageService.GetAppID()
+	}
+	if !allowed {
+		// Other users' trash is not revealed
+		respondWithError(w, http.StatusNotFound, "Object not found in trash")
+		return nil
+	}
+
+	if !h.checkBucketAccess(w, r, object.BucketName, services.OrgRoleMember) {
+		return nil
+	}
+	return object
+}
+
+// respondTrashError responds to a failed trash operation
+func respondTrashError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrObjectNotFound), errors.Is(err, services.ErrObjectNotInTrash):
+		respondWithError(w, http.StatusNotFound, "Object not found in trash")
+	default:
+		respondWithError(w, http.StatusInternalServerError, message+": "+err.Error())
+	}
+}
+
+// HandleGetTrash lists the objects the caller owns or deleted that are in the
+// trash. A deleted folder is listed without its contents.
+func (h *StorageHandlers) HandleGetTrash(w http.ResponseWriter, r *http.Request) {
+	userID := extractUserIDFromToken(r)
+	if userID == "" {
+		respondWithError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	objects, err := h.storageService.GetTrash(userID)
+	if err != nil {
+		respondTrashError(w, err, "Failed to list trash")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, objects)
+}
+
+// HandleRestoreTrashedObject takes an object out of the trash, along with the
+// contents of a folder
+func (h *StorageHandlers) HandleRestoreTrashedObject(w http.ResponseWriter, r *http.Request) {
+	object := h.authorizeTrashedObject(w, r)
+	if object == nil {
+		return
+	}
+
+	restored, err := h.storageService.RestoreObject(object.ID)
+	if err != nil {
+		respondTrashError(w, err, "Failed to restore object")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, restored)
+}
+
+// HandlePurgeTrashedObject permanently deletes an object in the trash
+func (h *StorageHandlers) HandlePurgeTrashedObject(w http.ResponseWriter, r *http.Request) {
+	object := h.authorizeTrashedObject(w, r)
+	if object == nil {
+		return
+	}
+
+	if err := h.storageService.PurgeObject(object.ID); err != nil {
+		respondTrashError(w, err, "Failed to delete object")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Object deleted permanently"})
+}
+
+// HandleEmptyTrash permanently deletes everything in the caller's trash
+func (h *StorageHandlers) HandleEmptyTrash(w http.ResponseWriter, r *http.Request) {
+	userID := extractUserIDFromToken(r)
+	if userID == "" {
+		respondWithError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	purged, err := h.storageService.EmptyTrash(userID)
+	if err != nil {
+		respondTrashError(w, err, "Failed to empty trash")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Trash emptied successfully",
+		"purged":  purged,
+	})
+}
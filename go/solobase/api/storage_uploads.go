@@ -0,0 +1,355 @@
+package api
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/services"
+	pkgstorage "github.com/suppers-ai/storage"
+)
+
+// Resumable uploads follow the tus 1.0 protocol (https://tus.io/protocols/resumable-upload)
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,checksum,termination"
+	// tusRoutePrefix names the upload routes, whose OPTIONS requests are
+	// answered by the tus handler rather than the CORS middleware
+	tusRoutePrefix = "tus-"
+	// statusChecksumMismatch is the tus status of a chunk that does not match
+	// its checksum
+	statusChecksumMismatch = 460
+)
+
+// tusChecksums are the checksum algorithms chunks can be sent with
+var tusChecksums = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// checkTusResumable sets the tus version on the response and makes sure the
+// client speaks it
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version")
+		return false
+	}
+	return true
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header, a comma separated
+// list of keys with base64 encoded values
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for metadata key %q", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// parseUploadChecksum decodes an Upload-Checksum header, an algorithm and a
+// base64 encoded checksum. It returns nil when no checksum was sent.
+func parseUploadChecksum(header string) (*services.UploadChecksum, error) {
+	if header == "" {
+		return nil, nil
+	}
+	algorithm, encoded, _ := strings.Cut(header, " ")
+	newHash, ok := tusChecksums[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum")
+	}
+	return &services.UploadChecksum{Hash: newHash(), Sum: sum}, nil
+}
+
+// setUploadHeaders describes where an upload stands
+func setUploadHeaders(w http.ResponseWriter, upload *pkgstorage.StorageUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Offset < upload.Size {
+		w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// respondUploadError responds to a failed resumable upload request
+func respondUploadError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		respondWithError(w, http.StatusNotFound, "Upload not found")
+	case errors.Is(err, services.ErrUploadExpired):
+		respondWithError(w, http.StatusGone, "Upload has expired")
+	case errors.Is(err, services.ErrUploadOffsetMismatch):
+		respondWithError(w, http.StatusConflict, "Upload-Offset does not match the upload's offset")
+	case errors.Is(err, services.ErrUploadChecksumMismatch):
+		respondWithError(w, statusChecksumMismatch, "Checksum mismatch")
+	case errors.Is(err, services.ErrUploadLocked):
+		respondWithError(w, http.StatusLocked, "Upload is being written by another request")
+	default:
+		respondWithError(w, http.StatusInternalServerError, message+": "+err.Error())
+	}
+}
+
+// authorizeUpload makes sure the caller started the upload in the request
+// and can still write to its bucket. It responds with an error and returns a
+// nil upload otherwise.
+func (h *StorageHandlers) authorizeUpload(w http.ResponseWriter, r *http.Request) *pkgstorage.StorageUpload {
+	userID := extractUserIDFromToken(r)
+	if userID == "" {
+		respondWithError(w, http.StatusUnauthorized, "Authentication required")
+		return nil
+	}
+
+	upload, err := h.storageService.GetUpload(mux.Vars(r)["id"])
+	if err != nil {
+		respondUploadError(w, err, "Failed to get upload")
+		return nil
+	}
+
+	allowed := upload.UserID == userID
+	if allowed && h.storageService.GetAppID() != "" {
+		allowed = upload.AppID != nil && *upload.AppID == h.storageService.GetAppID()
+	}
+	if !allowed {
+		// Other users' uploads are not revealed
+		respondWithError(w, http.StatusNotFound, "Upload not found")
+		return nil
+	}
+
+	if !h.checkBucketAccess(w, r, upload.BucketName, services.OrgRoleMember) {
+		return nil
+	}
+	return upload
+}
+
+// runAfterUploadHooks runs the after upload hooks for a completed upload
+func (h *StorageHandlers) runAfterUploadHooks(w http.ResponseWriter, r *http.Request, upload *pkgstorage.StorageUpload) {
+	if h.hookRegistry == nil {
+		return
+	}
+
+	hookCtx := &core.HookContext{
+		Request:  r,
+		Response: w,
+		Data: map[string]interface{}{
+			"userID":   upload.UserID,
+			"bucket":   upload.BucketName,
+			"objectID": upload.ObjectID,
+			"filename": upload.ObjectName,
+			"fileSize": upload.Size,
+		},
+		Services: nil,
+	}
+
+	// Execute after upload hooks (async)
+	go h.hookRegistry.ExecuteHooks(context.Background(), core.HookAfterUpload, hookCtx)
+}
+
+// HandleUploadOptions tells tus clients which protocol version and
+// extensions are supported
+func (h *StorageHandlers) HandleUploadOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Checksum-Algorithm", "md5,sha1,sha256")
+	if maxSize := uploadPolicy(h.settingsService).MaxSize; maxSize > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleCreateUpload starts a resumable upload. The file's name, type,
+// bucket and parent folder come from the Upload-Metadata header as filename,
+// filetype, bucket and parent_folder_id. The before upload hooks run once,
+// here, for the whole file.
+func (h *StorageHandlers) HandleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	// Get user ID from context if available, otherwise try to extract from token
+	userID, _ := r.Context().Value("user_id").(string)
+	if userID == "" {
+		userID = extractUserIDFromToken(r)
+	}
+	if userID == "" {
+		respondWithError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		respondWithError(w, http.StatusBadRequest, "Upload-Length is required")
+		return
+	}
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata: "+err.Error())
+		return
+	}
+
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+	if filename == "" {
+		respondWithError(w, http.StatusBadRequest, "Upload-Metadata must include a filename")
+		return
+	}
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = metadata["type"]
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	bucket := metadata["bucket"]
+	if bucket == "" || bucket == "user-files" {
+		bucket = "int_storage"
+	}
+	if !h.checkBucketAccess(w, r, bucket, services.OrgRoleMember) {
+		return
+	}
+
+	if err := uploadPolicy(h.settingsService).Check(filename, contentType, size); err != nil {
+		respondUploadRejected(w, err)
+		return
+	}
+
+	var parentFolderPtr *string
+	if parentFolderID := metadata["parent_folder_id"]; parentFolderID != "" {
+		parentFolderPtr = &parentFolderID
+	}
+
+	// Execute before upload hooks
+	if h.hookRegistry != nil {
+		hookCtx := &core.HookContext{
+			Request:  r,
+			Response: w,
+			Data: map[string]interface{}{
+				"userID":      userID,
+				"bucket":      bucket,
+				"filename":    filename,
+				"fileSize":    size,
+				"contentType": contentType,
+			},
+			Services: nil, // Will be set by registry
+		}
+
+		if err := h.hookRegistry.ExecuteHooks(r.Context(), core.HookBeforeUpload, hookCtx); err != nil {
+			respondWithError(w, http.StatusInsufficientStorage, err.Error())
+			return
+		}
+	}
+
+	upload, err := h.storageService.CreateUpload(bucket, filename, userID, size, contentType, parentFolderPtr, r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondUploadError(w, err, "Failed to create upload")
+		return
+	}
+	if size == 0 {
+		h.runAfterUploadHooks(w, r, upload)
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+upload.ID)
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HandleGetUploadOffset tells a client where to resume an upload
+func (h *StorageHandlers) HandleGetUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	upload := h.authorizeUpload(w, r)
+	if upload == nil {
+		return
+	}
+
+	setUploadHeaders(w, upload)
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandlePatchUpload writes a chunk of an upload, streaming it to the storage
+// provider. The chunk that completes the file stores it as an object and runs
+// the after upload hooks.
+func (h *StorageHandlers) HandlePatchUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "Upload-Offset is required")
+		return
+	}
+	checksum, err := parseUploadChecksum(r.Header.Get("Upload-Checksum"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Checksum: "+err.Error())
+		return
+	}
+
+	upload := h.authorizeUpload(w, r)
+	if upload == nil {
+		return
+	}
+
+	upload, err = h.storageService.WriteUpload(upload.ID, offset, r.Body, checksum)
+	if err != nil {
+		respondUploadError(w, err, "Failed to write upload")
+		return
+	}
+	if upload.Offset == upload.Size {
+		h.runAfterUploadHooks(w, r, upload)
+	}
+
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleTerminateUpload discards an upload and the bytes written so far
+func (h *StorageHandlers) HandleTerminateUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	upload := h.authorizeUpload(w, r)
+	if upload == nil {
+		return
+	}
+
+	if err := h.storageService.TerminateUpload(upload.ID); err != nil {
+		respondUploadError(w, err, "Failed to terminate upload")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
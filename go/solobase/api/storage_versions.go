@@ -0,0 +1,290 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/services"
+	pkgstorage "github.com/suppers-ai/storage"
+)
+
+// authorizeObject makes sure the caller has at least minRole on an object's
+// bucket and, in internal storage, owns the object. It responds with an error
+// and returns a nil object otherwise.
+func (h *StorageHandlers) authorizeObject(w http.ResponseWriter, r *http.Request, minRole string, requireAuth bool) (*pkgstorage.StorageObject, string) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	if !h.checkBucketAccess(w, r, bucket, minRole) {
+		return nil, ""
+	}
+
+	userID := extractUserIDFromToken(r)
+	internal := bucket == "user-files" || bucket == "int_storage"
+	if internal {
+		bucket = "int_storage"
+	}
+	if userID == "" && (requireAuth || internal) {
+		respondWithError(w, http.StatusUnauthorized, "Authentication required")
+		return nil, ""
+	}
+
+	object, err := h.storageService.GetObjectInfo(bucket, vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Object not found")
+		return nil, ""
+	}
+
+	if internal {
+		isOwner := object.UserID == userID
+		if isOwner && h.storageService.GetAppID() != "" {
+			isOwner = object.AppID != nil && *object.AppID == h.storageService.GetAppID()
+		}
+		if !isOwner {
+			respondWithError(w, http.StatusForbidden, "Access denied")
+			return nil, ""
+		}
+	}
+
+	return object, userID
+}
+
+// respondVersionError responds to a failed object version operation
+func respondVersionError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrObjectNotFound):
+		respondWithError(w, http.StatusNotFound, "Object not found")
+	case errors.Is(err, services.ErrObjectVersionNotFound):
+		respondWithError(w, http.StatusNotFound, "Version not found")
+	case errors.Is(err, services.ErrObjectIsFolder):
+		respondWithError(w, http.StatusBadRequest, "Folders have no content")
+	case errors.Is(err, services.ErrVersioningNotSupported):
+		respondWithError(w, http.StatusNotImplemented, "Storage provider does not support versioning")
+	default:
+		respondWithError(w, http.StatusInternalServerError, message+": "+err.Error())
+	}
+}
+
+// HandleUpdateBucketVersioning enables or suspends versioning for a bucket and
+// sets how many prior versions are kept per file
+func (h *StorageHandlers) HandleUpdateBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	record, err := h.storageService.GetBucket(bucket)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Bucket not found")
+		return
+	}
+	orgID := ""
+	if record.OrganizationID != nil {
+		orgID = *record.OrganizationID
+	}
+	if !h.canManageBucket(r, orgID, services.PermissionStorageBucketCreate) {
+		respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+
+	var request struct {
+		Versioning  *bool `json:"versioning"`
+		MaxVersions *int  `json:"max_versions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	enabled, maxVersions := record.Versioning, record.MaxVersions
+	if request.Versioning != nil {
+		enabled = *request.Versioning
+	}
+	if request.MaxVersions != nil {
+		if *request.MaxVersions < 0 {
+			respondWithError(w, http.StatusBadRequest, "max_versions cannot be negative")
+			return
+		}
+		maxVersions = *request.MaxVersions
+	}
+
+	record, err = h.storageService.UpdateBucketVersioning(bucket, enabled, maxVersions)
+	if err != nil {
+		respondVersionError(w, err, "Failed to update bucket")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, record)
+}
+
+// HandleReplaceObject overwrites the content of a file with an uploaded one.
+// Versioned buckets keep the prior content as a version.
+func (h *StorageHandlers) HandleReplaceObject(w http.ResponseWriter, r *http.Request) {
+	object, userID := h.authorizeObject(w, r, services.OrgRoleMember, true)
+	if object == nil {
+		return
+	}
+
+	policy := uploadPolicy(h.settingsService)
+	if policy.MaxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, policy.MaxSize+1<<20)
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondUploadRejected(w, services.ErrFileTooLarge)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Failed to parse form")
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to get file")
+		return
+	}
+	defer file.Close()
+
+	// The file keeps its name, so that is what the policy checks
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if err := policy.Check(object.ObjectName, contentType, header.Size); err != nil {
+		respondUploadRejected(w, err)
+		return
+	}
+
+	hookData := map[string]interface{}{
+		"userID":      userID,
+		"bucket":      object.BucketName,
+		"objectID":    object.ID,
+		"filename":    object.ObjectName,
+		"fileSize":    header.Size,
+		"contentType": contentType,
+	}
+	if h.hookRegistry != nil {
+		hookCtx := &core.HookContext{Request: r, Response: w, Data: hookData}
+		if err := h.hookRegistry.ExecuteHooks(r.Context(), core.HookBeforeUpload, hookCtx); err != nil {
+			respondWithError(w, http.StatusInsufficientStorage, err.Error())
+			return
+		}
+	}
+
+	updated, err := h.storageService.ReplaceObjectContent(object.BucketName, object.ID, file, header.Size, contentType)
+	if err != nil {
+		respondVersionError(w, err, "Failed to replace file")
+		return
+	}
+
+	if h.hookRegistry != nil {
+		hookCtx := &core.HookContext{Request: r, Response: w, Data: hookData}
+		go h.hookRegistry.ExecuteHooks(context.Background(), core.HookAfterUpload, hookCtx)
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// HandleListObjectVersions lists the prior versions of a file, newest first
+func (h *StorageHandlers) HandleListObjectVersions(w http.ResponseWriter, r *http.Request) {
+	object, _ := h.authorizeObject(w, r, services.OrgRoleViewer, false)
+	if object == nil {
+		return
+	}
+
+	versions, err := h.storageService.ListObjectVersions(object.BucketName, object.ID)
+	if err != nil {
+		respondVersionError(w, err, "Failed to list versions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, versions)
+}
+
+// HandleDownloadObjectVersion downloads the content of a prior version
+func (h *StorageHandlers) HandleDownloadObjectVersion(w http.ResponseWriter, r *http.Request) {
+	object, _ := h.authorizeObject(w, r, services.OrgRoleViewer, false)
+	if object == nil {
+		return
+	}
+
+	reader, version, err := h.storageService.GetObjectVersion(object.BucketName, object.ID, mux.Vars(r)["versionId"])
+	if err != nil {
+		respondVersionError(w, err, "Failed to get version")
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", version.ContentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+version.ObjectName+"\"")
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("Error streaming file version: %v", err)
+	}
+}
+
+// HandleRestoreObjectVersion makes a prior version the current content of a
+// file
+func (h *StorageHandlers) HandleRestoreObjectVersion(w http.ResponseWriter, r *http.Request) {
+	object, _ := h.authorizeObject(w, r, services.OrgRoleMember, true)
+	if object == nil {
+		return
+	}
+
+	restored, err := h.storageService.RestoreObjectVersion(object.BucketName, object.ID, mux.Vars(r)["versionId"])
+	if err != nil {
+		respondVersionError(w, err, "Failed to restore version")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, restored)
+}
+
+// HandleDeleteObjectVersion permanently deletes a prior version
+func (h *StorageHandlers) HandleDeleteObjectVersion(w http.ResponseWriter, r *http.Request) {
+	object, _ := h.authorizeObject(w, r, services.OrgRoleMember, true)
+	if object == nil {
+		return
+	}
+
+	if err := h.storageService.DeleteObjectVersion(object.BucketName, object.ID, mux.Vars(r)["versionId"]); err != nil {
+		respondVersionError(w, err, "Failed to delete version")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Version deleted successfully"})
+}
+
+// HandlePurgeObjectVersions permanently deletes the prior versions of a file
+// other than the newest ?keep=N, all of them by default
+func (h *StorageHandlers) HandlePurgeObjectVersions(w http.ResponseWriter, r *http.Request) {
+	object, _ := h.authorizeObject(w, r, services.OrgRoleMember, true)
+	if object == nil {
+		return
+	}
+
+	keep := 0
+	if value := r.URL.Query().Get("keep"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			respondWithError(w, http.StatusBadRequest, "keep must be a non-negative number")
+			return
+		}
+		keep = parsed
+	}
+
+	purged, err := h.storageService.PurgeObjectVersions(object.BucketName, object.ID, keep)
+	if err != nil {
+		respondVersionError(w, err, "Failed to purge versions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Versions purged successfully",
+		"purged":  purged,
+	})
+}
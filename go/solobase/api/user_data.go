@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+	"gorm.io/gorm"
+)
+
+// HandleStartUserDataExport starts collecting a user's data into a ZIP archive
+func HandleStartUserDataExport(userDataService *services.UserDataService) http.HandlerFunc {
+	return handleStartUserDataJob(userDataService.StartExport)
+}
+
+// HandleStartUserDataErasure starts deleting a user's data. The user is
+// deleted when the job completes.
+func HandleStartUserDataErasure(userDataService *services.UserDataService) http.HandlerFunc {
+	return handleStartUserDataJob(userDataService.StartErasure)
+}
+
+func handleStartUserDataJob(start func(userID, requestedBy string) (*models.UserDataJob, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := r.Context().Value("user").(*auth.User)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		job, err := start(mux.Vars(r)["id"], admin.ID.String())
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to start job")
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// HandleListUserDataJobs lists the export and erasure jobs for a user
+func HandleListUserDataJobs(userDataService *services.UserDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := userDataService.ListJobs(mux.Vars(r)["id"])
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to list jobs")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, jobs)
+	}
+}
+
+// HandleGetUserDataJob returns the status of an export or erasure job
+func HandleGetUserDataJob(userDataService *services.UserDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := userDataService.GetJob(mux.Vars(r)["id"])
+		if errors.Is(err, services.ErrUserDataJobNotFound) {
+			respondWithError(w, http.StatusNotFound, "Job not found")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to get job")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, job)
+	}
+}
+
+// HandleDownloadUserDataExport downloads the archive of a completed export
+func HandleDownloadUserDataExport(userDataService *services.UserDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := userDataService.GetJob(mux.Vars(r)["id"])
+		if errors.Is(err, services.ErrUserDataJobNotFound) {
+			respondWithError(w, http.StatusNotFound, "Job not found")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to get job")
+			return
+		}
+
+		reader, filename, err := userDataService.OpenExport(job)
+		if errors.Is(err, services.ErrExportNotReady) {
+			respondWithError(w, http.StatusConflict, "Export is not ready")
+			return
+		}
+		if errors.Is(err, services.ErrExportExpired) {
+			respondWithError(w, http.StatusGone, "Export is no longer available")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to open export")
+			return
+		}
+		defer reader.Close()
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+		io.Copy(w, reader)
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
 
@@ -63,7 +64,7 @@ func HandleGetUser(userService *services.UserService) http.HandlerFunc {
 	}
 }
 
-func HandleUpdateUser(userService *services.UserService) http.HandlerFunc {
+func HandleUpdateUser(userService *services.UserService, rbacService *services.RBACService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		userID := vars["id"]
@@ -74,6 +75,18 @@ func HandleUpdateUser(userService *services.UserService) http.HandlerFunc {
 			return
 		}
 
+		// Changing a role grants permissions, so it needs more than managing users
+		if role, ok := updates["role"]; ok {
+			if !hasPermission(rbacService, r, services.PermissionRolesManage) {
+				respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+				return
+			}
+			if name, _ := role.(string); !rbacService.RoleExists(name) {
+				respondWithError(w, http.StatusBadRequest, "Role not found")
+				return
+			}
+		}
+
 		user, err := userService.UpdateUser(userID, updates)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Failed to update user")
@@ -84,7 +97,7 @@ func HandleUpdateUser(userService *services.UserService) http.HandlerFunc {
 	}
 }
 
-func HandleDeleteUser(userService *services.UserService) http.HandlerFunc {
+func HandleDeleteUser(userService *services.UserService, rbacService *services.RBACService, orgService *services.OrganizationService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		userID := vars["id"]
@@ -93,6 +106,12 @@ func HandleDeleteUser(userService *services.UserService) http.HandlerFunc {
 			respondWithError(w, http.StatusInternalServerError, "Failed to delete user")
 			return
 		}
+		if err := rbacService.RemoveUserBindings(userID); err != nil {
+			log.Printf("Failed to remove roles of deleted user %s: %v", userID, err)
+		}
+		if err := orgService.RemoveUserMemberships(userID); err != nil {
+			log.Printf("Failed to remove organization memberships of deleted user %s: %v", userID, err)
+		}
 
 		respondWithJSON(w, http.StatusOK, map[string]string{"message": "User deleted successfully"})
 	}
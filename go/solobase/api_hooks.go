@@ -0,0 +1,137 @@
+package solobase
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// APIError lets an OnBeforeAPI hook reject a request with a specific status
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// NewAPIError creates an APIError with the given status and message
+func NewAPIError(status int, message string) *APIError {
+	return &APIError{Status: status, Message: message}
+}
+
+// wrapAPIHooks runs the OnBeforeAPI hooks as middleware around the API
+// handler and the OnAfterAPI hooks once the response has been written.
+//
+// Before-hooks may replace e.Request or e.Response before calling e.Next. A
+// hook that writes a response without calling e.Next short-circuits the
+// request; one that writes nothing and returns nil lets the chain continue.
+// After-hooks see the final status code and body size.
+func (app *App) wrapAPIHooks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(app.onBeforeAPIHooks) == 0 && len(app.onAfterAPIHooks) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &apiResponseRecorder{ResponseWriter: w}
+		event := &APIEvent{
+			App:      app,
+			Request:  r,
+			Response: recorder,
+		}
+
+		err := runAPIHooks(app.onBeforeAPIHooks, event, recorder, func(e *APIEvent) error {
+			// After-hooks see the request as rewritten by the before-hooks
+			event.Request = e.Request
+			next.ServeHTTP(e.Response, e.Request)
+			return nil
+		})
+		if err != nil && recorder.status == 0 {
+			writeAPIHookError(recorder, err)
+		}
+
+		event.Status = recorder.status
+		if event.Status == 0 {
+			event.Status = http.StatusOK
+		}
+		event.Size = recorder.size
+
+		if err := runAPIHooks(app.onAfterAPIHooks, event, nil, func(*APIEvent) error { return nil }); err != nil {
+			log.Printf("OnAfterAPI hook failed for %s %s: %v", r.Method, r.URL.Path, err)
+		}
+	})
+}
+
+// runAPIHooks calls hooks as a middleware chain ending in final. When a hook
+// returns without calling Next, the chain stops only if it wrote a response.
+func runAPIHooks(hooks []func(*APIEvent) error, event *APIEvent, recorder *apiResponseRecorder, final func(*APIEvent) error) error {
+	if len(hooks) == 0 {
+		return final(event)
+	}
+
+	called := false
+	e := *event
+	e.Next = func() error {
+		called = true
+		next := e
+		return runAPIHooks(hooks[1:], &next, recorder, final)
+	}
+	if err := hooks[0](&e); err != nil {
+		return err
+	}
+	if called || (recorder != nil && recorder.status != 0) {
+		return nil
+	}
+	return runAPIHooks(hooks[1:], &e, recorder, final)
+}
+
+// writeAPIHookError responds with the error returned by a before-hook
+func writeAPIHookError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status != 0 {
+		status = apiErr.Status
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// apiResponseRecorder captures the status code and body size of an API response
+type apiResponseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (rw *apiResponseRecorder) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *apiResponseRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += int64(n)
+	return n, err
+}
+
+// Flush forwards to the underlying writer so streaming responses keep working
+func (rw *apiResponseRecorder) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *apiResponseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
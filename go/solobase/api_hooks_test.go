@@ -0,0 +1,155 @@
+package solobase
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bufferedResponse holds a response back so a hook can rewrite it
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+
+func newAPIHookTestApp() *App {
+	return &App{onModelHooks: make(map[string][]func(*ModelEvent) error)}
+}
+
+// serveAPI sends a request through the hooks to a handler that echoes the
+// request path with a 201
+func serveAPI(app *App, path string) (*httptest.ResponseRecorder, *bool) {
+	called := false
+	handler := app.wrapAPIHooks(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(r.URL.Path))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec, &called
+}
+
+func TestAfterAPIHookSeesResponse(t *testing.T) {
+	app := newAPIHookTestApp()
+	var seen APIEvent
+	app.OnAfterAPI().BindFunc(func(e *APIEvent) error {
+		seen = *e
+		return nil
+	})
+
+	rec, _ := serveAPI(app, "/collections")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "/collections", rec.Body.String())
+	assert.Equal(t, http.StatusCreated, seen.Status)
+	assert.EqualValues(t, len("/collections"), seen.Size)
+	assert.Equal(t, "/collections", seen.Request.URL.Path)
+}
+
+func TestBeforeAPIHookRewritesResponse(t *testing.T) {
+	app := newAPIHookTestApp()
+	app.OnBeforeAPI().BindFunc(func(e *APIEvent) error {
+		// Route the request elsewhere and hold the handler's response back
+		r := e.Request.Clone(e.Request.Context())
+		r.URL.Path = "/tenants/acme" + r.URL.Path
+		e.Request = r
+		w := e.Response
+		buffered := &bufferedResponse{header: http.Header{}}
+		e.Response = buffered
+		if err := e.Next(); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		_, err := w.Write(bytes.ToUpper(buffered.body.Bytes()))
+		return err
+	})
+	var seen APIEvent
+	app.OnAfterAPI().BindFunc(func(e *APIEvent) error {
+		seen = *e
+		return nil
+	})
+
+	rec, called := serveAPI(app, "/collections")
+	assert.True(t, *called)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "/TENANTS/ACME/COLLECTIONS", rec.Body.String())
+	// After-hooks see the rewritten request and the response as it was sent
+	assert.Equal(t, "/tenants/acme/collections", seen.Request.URL.Path)
+	assert.Equal(t, http.StatusAccepted, seen.Status)
+	assert.EqualValues(t, rec.Body.Len(), seen.Size)
+}
+
+func TestBeforeAPIHookShortCircuits(t *testing.T) {
+	app := newAPIHookTestApp()
+	var order []string
+	app.OnBeforeAPI().BindFunc(func(e *APIEvent) error {
+		// Writing nothing and not calling Next lets the chain continue
+		order = append(order, "first")
+		return nil
+	}).BindFunc(func(e *APIEvent) error {
+		order = append(order, "second")
+		e.Response.WriteHeader(http.StatusForbidden)
+		return nil
+	}).BindFunc(func(e *APIEvent) error {
+		order = append(order, "third")
+		return e.Next()
+	})
+	var status int
+	app.OnAfterAPI().BindFunc(func(e *APIEvent) error {
+		status = e.Status
+		return nil
+	})
+
+	rec, called := serveAPI(app, "/collections")
+	assert.False(t, *called)
+	assert.Equal(t, []string{"first", "second"}, order)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, http.StatusForbidden, status)
+}
+
+func TestBeforeAPIHookError(t *testing.T) {
+	app := newAPIHookTestApp()
+	app.OnBeforeAPI().BindFunc(func(e *APIEvent) error {
+		return NewAPIError(http.StatusTooManyRequests, "slow down")
+	})
+	var seen APIEvent
+	app.OnAfterAPI().BindFunc(func(e *APIEvent) error {
+		seen = *e
+		return nil
+	})
+
+	rec, called := serveAPI(app, "/collections")
+	assert.False(t, *called)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.JSONEq(t, `{"error":"slow down"}`, rec.Body.String())
+	assert.Equal(t, http.StatusTooManyRequests, seen.Status)
+	assert.EqualValues(t, rec.Body.Len(), seen.Size)
+}
+
+func TestAPIResponseRecorderKeepsFirstStatus(t *testing.T) {
+	rec := httptest.NewRecorder()
+	recorder := &apiResponseRecorder{ResponseWriter: rec}
+	_, err := recorder.Write([]byte("hello"))
+	require.NoError(t, err)
+	recorder.WriteHeader(http.StatusInternalServerError)
+	_, err = recorder.Write([]byte(" world"))
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, recorder.status)
+	assert.EqualValues(t, len("hello world"), recorder.size)
+	assert.Equal(t, "hello world", rec.Body.String())
+
+	// Streaming handlers can still flush through the recorder
+	require.NoError(t, http.NewResponseController(recorder).Flush())
+	assert.True(t, rec.Flushed)
+}
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the environment variable holding the config file path
+const ConfigFileEnv = "SOLOBASE_CONFIG"
+
+// LoadOptions controls the sources merged by LoadWithOptions
+type LoadOptions struct {
+	// File is a YAML, JSON or TOML config file. When empty, SOLOBASE_CONFIG
+	// or the --config flag is used if set.
+	File string
+
+	// Args are command line flags, e.g. os.Args[1:]. Nil skips flag parsing.
+	Args []string
+
+	// LookupEnv reads environment variables. Defaults to os.LookupEnv.
+	LookupEnv func(string) (string, bool)
+}
+
+// setting describes one configuration value and where it can be set from
+type setting struct {
+	key   string // Dotted key used in config files, e.g. "database.url"
+	env   string // Environment variable, e.g. "DATABASE_URL"
+	usage string
+	apply func(cfg *Config, value string) error
+}
+
+// flagName returns the command line flag for the setting, e.g. "database-url"
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+// settings lists every configurable value. Within a layer they are applied
+// in this order, so explicit database fields override those from database.url.
+var settings = []setting{
+	{"server.port", "PORT", "HTTP port", func(c *Config, v string) error { c.Port = v; return nil }},
+	{"environment", "ENVIRONMENT", "Environment name (development, production)", func(c *Config, v string) error { c.Environment = v; return nil }},
+	{"app_id", "APP_ID", "Application ID used for storage isolation", func(c *Config, v string) error { c.AppID = v; return nil }},
+	{"server.trusted_proxies", "TRUSTED_PROXIES", "Comma separated proxy IPs or CIDR ranges allowed to set X-Forwarded-For", sliceSetting(func(c *Config) *[]string { return &c.TrustedProxies })},
+
+	{"database.type", "DATABASE_TYPE", "Database type (sqlite, postgres)", func(c *Config, v string) error { c.Database.Type = normalizeDatabaseType(v); return nil }},
+	{"database.url", "DATABASE_URL", "Database URL or DSN", func(c *Config, v string) error { return c.SetDatabaseURL(v) }},
+	{"database.host", "DATABASE_HOST", "PostgreSQL host", func(c *Config, v string) error { c.Database.Host = v; return nil }},
+	{"database.port", "DATABASE_PORT", "PostgreSQL port", intSetting(func(c *Config) *int { return &c.Database.Port })},
+	{"database.name", "DATABASE_NAME", "Database name", func(c *Config, v string) error { c.Database.Database = v; return nil }},
+	{"database.user", "DATABASE_USER", "Database user", func(c *Config, v string) error { c.Database.Username = v; return nil }},
+	{"database.password", "DATABASE_PASSWORD", "Database password", func(c *Config, v string) error { c.Database.Password = v; return nil }},
+	{"database.sslmode", "DATABASE_SSL_MODE", "PostgreSQL sslmode", func(c *Config, v string) error { c.Database.SSLMode = v; return nil }},
+	{"database.max_open_conns", "DATABASE_MAX_OPEN_CONNS", "Maximum open connections", intSetting(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"database.max_idle_conns", "DATABASE_MAX_IDLE_CONNS", "Maximum idle connections", intSetting(func(c *Config) *int { return &c.Database.MaxIdleConns })},
+	{"database.conn_max_lifetime", "DATABASE_CONN_MAX_LIFETIME", "Maximum connection lifetime, e.g. 1h", durationSetting(func(c *Config) *time.Duration { return &c.Database.ConnMaxLifetime })},
+
+	{"storage.type", "STORAGE_TYPE", "Storage type (local, s3)", func(c *Config, v string) error { c.Storage.Type = v; return nil }},
+	{"storage.local_path", "LOCAL_STORAGE_PATH", "Local storage directory", func(c *Config, v string) error { c.Storage.LocalStoragePath = v; return nil }},
+	{"storage.s3_endpoint", "S3_ENDPOINT", "S3 endpoint", func(c *Config, v string) error { c.Storage.S3Endpoint = v; return nil }},
+	{"storage.s3_access_key", "S3_ACCESS_KEY", "S3 access key", func(c *Config, v string) error { c.Storage.S3AccessKey = v; return nil }},
+	{"storage.s3_secret_key", "S3_SECRET_KEY", "S3 secret key", func(c *Config, v string) error { c.Storage.S3SecretKey = v; return nil }},
+	{"storage.s3_bucket", "S3_BUCKET", "S3 bucket", func(c *Config, v string) error { c.Storage.S3Bucket = v; return nil }},
+	{"storage.s3_region", "S3_REGION", "S3 region", func(c *Config, v string) error { c.Storage.S3Region = v; return nil }},
+	{"storage.s3_use_ssl", "S3_USE_SSL", "Use SSL for S3", boolSetting(func(c *Config) *bool { return &c.Storage.S3UseSSL })},
+	{"storage.enable_s3_gateway", "ENABLE_S3_GATEWAY", "Serve the S3 compatible API at /s3", boolSetting(func(c *Config) *bool { return &c.Storage.EnableS3Gateway })},
+
+	{"smtp.host", "SMTP_HOST", "SMTP host", func(c *Config, v string) error { c.SMTPHost = v; return nil }},
+	{"smtp.port", "SMTP_PORT", "SMTP port", intSetting(func(c *Config) *int { return &c.SMTPPort })},
+	{"smtp.username", "SMTP_USERNAME", "SMTP username", func(c *Config, v string) error { c.SMTPUsername = v; return nil }},
+	{"smtp.password", "SMTP_PASSWORD", "SMTP password", func(c *Config, v string) error { c.SMTPPassword = v; return nil }},
+	{"smtp.from", "SMTP_FROM", "Sender address", func(c *Config, v string) error { c.SMTPFrom = v; return nil }},
+	{"smtp.use_tls", "SMTP_USE_TLS", "Use TLS for SMTP", boolSetting(func(c *Config) *bool { return &c.SMTPUseTLS })},
+
+	{"auth.jwt_secret", "JWT_SECRET", "Secret used to sign JWTs", func(c *Config, v string) error { c.JWTSecret = v; return nil }},
+	{"auth.enable_signup", "ENABLE_SIGNUP", "Allow new users to sign up", boolSetting(func(c *Config) *bool { return &c.EnableSignup })},
+	{"auth.enable_api", "ENABLE_API", "Enable the API", boolSetting(func(c *Config) *bool { return &c.EnableAPI })},
+
+	{"secrets.master_key", "SOLOBASE_MASTER_KEY", "Master keys for encrypting secrets, as id:base64key entries with the active key first", func(c *Config, v string) error { c.MasterKey = v; return nil }},
+	{"secrets.master_key_file", "SOLOBASE_MASTER_KEY_FILE", "File holding the master keys, created when missing", func(c *Config, v string) error { c.MasterKeyFile = v; return nil }},
+
+	{"admin.email", "DEFAULT_ADMIN_EMAIL", "Default admin email", func(c *Config, v string) error { c.AdminEmail = v; return nil }},
+	{"admin.password", "DEFAULT_ADMIN_PASSWORD", "Default admin password", func(c *Config, v string) error { c.AdminPassword = v; return nil }},
+	{"admin.disable_ui", "DISABLE_ADMIN_UI", "Disable the admin UI", boolSetting(func(c *Config) *bool { return &c.DisableAdminUI })},
+
+	{"log.level", "LOG_LEVEL", "Log level", func(c *Config, v string) error { c.LogLevel = v; return nil }},
+
+	{"cors.allowed_origins", "CORS_ALLOWED_ORIGINS", "Comma separated allowed origins", sliceSetting(func(c *Config) *[]string { return &c.CORSAllowedOrigins })},
+	{"cors.allowed_methods", "CORS_ALLOWED_METHODS", "Comma separated allowed methods", sliceSetting(func(c *Config) *[]string { return &c.CORSAllowedMethods })},
+	{"cors.allowed_headers", "CORS_ALLOWED_HEADERS", "Comma separated allowed headers", sliceSetting(func(c *Config) *[]string { return &c.CORSAllowedHeaders })},
+
+	{"rate_limit.enabled", "RATE_LIMIT_ENABLED", "Enable rate limiting", boolSetting(func(c *Config) *bool { return &c.RateLimitEnabled })},
+	{"rate_limit.requests_per_minute", "RATE_LIMIT_REQUESTS_PER_MINUTE", "Requests per minute per client", intSetting(func(c *Config) *int { return &c.RateLimitRequestsPerMinute })},
+}
+
+// LoadWithOptions builds a Config from defaults, a config file, environment
+// variables and command line flags, with later sources taking precedence.
+// The result is not validated; call Validate before using it.
+func LoadWithOptions(opts LoadOptions) (*Config, error) {
+	lookupEnv := opts.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+
+	flagValues, configFlag, err := parseFlags(opts.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	path := opts.File
+	if envPath, ok := lookupEnv(ConfigFileEnv); ok && path == "" {
+		path = envPath
+	}
+	if configFlag != "" {
+		path = configFlag
+	}
+
+	var fileValues map[string]string
+	if path != "" {
+		if fileValues, err = readConfigFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	envValues := make(map[string]string)
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok && value != "" {
+			envValues[s.key] = value
+		}
+	}
+
+	cfg := Default()
+	layers := []struct {
+		source string
+		values map[string]string
+	}{
+		{"config file " + path, fileValues},
+		{"environment", envValues},
+		{"flags", flagValues},
+	}
+	for _, layer := range layers {
+		for _, s := range settings {
+			value, ok := layer.values[s.key]
+			if !ok {
+				continue
+			}
+			if err := s.apply(cfg, value); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", layer.source, s.key, err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// parseFlags parses command line flags, returning the values that were set
+// keyed by setting key, and the value of --config
+func parseFlags(args []string) (map[string]string, string, error) {
+	if args == nil {
+		return nil, "", nil
+	}
+
+	fs := flag.NewFlagSet("solobase", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configPath := fs.String("config", "", "Path to a YAML, JSON or TOML config file")
+	byFlag := make(map[string]string, len(settings))
+	for _, s := range settings {
+		fs.String(s.flagName(), "", s.usage)
+		byFlag[s.flagName()] = s.key
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, "", fmt.Errorf("flags: %w", err)
+	}
+
+	values := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := byFlag[f.Name]; ok {
+			values[key] = f.Value.String()
+		}
+	})
+	return values, *configPath, nil
+}
+
+// readConfigFile reads a config file and flattens it into dotted keys. The
+// format is chosen from the extension: .yaml, .yml, .json or .toml.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flattenConfig("", raw, values)
+
+	known := make(map[string]bool, len(settings))
+	for _, s := range settings {
+		known[s.key] = true
+	}
+	var unknown []string
+	for key := range values {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("config file %s: unknown keys: %s", path, strings.Join(unknown, ", "))
+	}
+	return values, nil
+}
+
+// flattenConfig turns nested maps into dotted keys with string values
+func flattenConfig(prefix string, value interface{}, out map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenConfig(key, child, out)
+		}
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = configString(item)
+		}
+		out[prefix] = strings.Join(items, ",")
+	case nil:
+	default:
+		out[prefix] = configString(v)
+	}
+}
+
+func configString(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+func intSetting(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		*field(c) = i
+		return nil
+	}
+}
+
+func boolSetting(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func durationSetting(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func sliceSetting(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}
+}
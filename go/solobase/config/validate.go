@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/suppers-ai/solobase/utils"
+)
+
+// Insecure defaults that are refused in production
+const (
+	DefaultJWTSecret     = "your-secret-key-change-in-production"
+	DefaultAdminPassword = "admin123"
+)
+
+// insecureJWTSecrets are well-known secrets that must never sign production tokens
+var insecureJWTSecrets = map[string]bool{
+	DefaultJWTSecret: true,
+	"solobase-dev-jwt-secret-do-not-use-in-production-2024": true,
+}
+
+var validSSLModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// Validate checks the configuration and reports every problem found.
+// In production it also rejects the insecure JWT secret and admin password defaults.
+func (c *Config) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+		addf("server.port %q is not a valid port", c.Port)
+	}
+	if c.AppID == "" || c.AppID == "." || c.AppID == ".." || strings.ContainsAny(c.AppID, `/\`) {
+		addf("app_id %q must be a non-empty name without path separators", c.AppID)
+	}
+	if _, err := utils.ParseTrustedProxies(c.TrustedProxies); err != nil {
+		addf("server.trusted_proxies: %v", err)
+	}
+
+	switch c.Database.Type {
+	case "sqlite":
+		if c.Database.Database == "" {
+			addf("database.url must be set to a SQLite file path")
+		}
+	case "postgres":
+		if c.Database.Host == "" {
+			addf("database.host is required for postgres")
+		}
+		if c.Database.Port <= 0 || c.Database.Port > 65535 {
+			addf("database.port %d is not a valid port", c.Database.Port)
+		}
+		if c.Database.Database == "" {
+			addf("database.name is required for postgres")
+		}
+		if c.Database.Username == "" {
+			addf("database.user is required for postgres")
+		}
+		if !validSSLModes[c.Database.SSLMode] {
+			addf("database.sslmode %q is not a valid sslmode", c.Database.SSLMode)
+		}
+	default:
+		addf("database.type %q is not supported (use sqlite or postgres)", c.Database.Type)
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 || c.Database.ConnMaxLifetime < 0 {
+		addf("database pool settings must not be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		addf("database.max_idle_conns (%d) exceeds database.max_open_conns (%d)", c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	}
+
+	switch c.Storage.Type {
+	case "local":
+		if c.Storage.LocalStoragePath == "" {
+			addf("storage.local_path is required for local storage")
+		}
+	case "s3":
+		if c.Storage.S3Bucket == "" {
+			addf("storage.s3_bucket is required for s3 storage")
+		}
+	default:
+		addf("storage.type %q is not supported (use local or s3)", c.Storage.Type)
+	}
+
+	if c.JWTSecret == "" {
+		addf("auth.jwt_secret must be set")
+	}
+	if c.MasterKey == "" && c.MasterKeyFile == "" {
+		addf("secrets.master_key or secrets.master_key_file must be set")
+	}
+	if c.AdminEmail != "" && !strings.Contains(c.AdminEmail, "@") {
+		addf("admin.email %q is not a valid email address", c.AdminEmail)
+	}
+
+	if c.IsProduction() {
+		if insecureJWTSecrets[c.JWTSecret] {
+			addf("auth.jwt_secret uses a built-in default; set JWT_SECRET in production")
+		} else if len(c.JWTSecret) < 32 {
+			addf("auth.jwt_secret must be at least 32 characters in production")
+		}
+		if c.AdminPassword == DefaultAdminPassword {
+			addf("admin.password uses the built-in default; set DEFAULT_ADMIN_PASSWORD in production")
+		} else if c.AdminPassword != "" {
+			if err := utils.ValidatePasswordStrength(c.AdminPassword); err != nil {
+				addf("admin.password is too weak for production: %v", err)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
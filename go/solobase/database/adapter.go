@@ -37,6 +37,11 @@ type Config struct {
 	Username string
 	Password string
 	SSLMode  string
+
+	// Connection pool; zero values use the package defaults
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
 }
 
 // DB wraps the package database DB
@@ -57,6 +62,10 @@ func New(cfg Config) (*DB, error) {
 		Username: cfg.Username,
 		Password: cfg.Password,
 		SSLMode:  cfg.SSLMode,
+
+		MaxOpenConns:    cfg.MaxOpenConns,
+		MaxIdleConns:    cfg.MaxIdleConns,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
 	}
 	
 	// For SQLite, set the DSN properly
@@ -70,6 +79,8 @@ func New(cfg Config) (*DB, error) {
 				pkgConfig.DSN = "file:" + cfg.Database
 			}
 		}
+	} else if cfg.Type == "postgres" {
+		pkgConfig.DSN = postgresDSN(cfg)
 	}
 
 	// Create database connection using package
@@ -101,6 +112,23 @@ func New(cfg Config) (*DB, error) {
 	}, nil
 }
 
+// postgresDSN builds a key/value connection string, quoting values so that
+// passwords and names containing spaces or quotes survive
+func postgresDSN(cfg Config) string {
+	quote := func(v string) string {
+		v = strings.ReplaceAll(v, `\`, `\\`)
+		v = strings.ReplaceAll(v, `'`, `\'`)
+		return "'" + v + "'"
+	}
+
+	sslMode := cfg.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		quote(cfg.Host), cfg.Port, quote(cfg.Username), quote(cfg.Password), quote(cfg.Database), quote(sslMode))
+}
+
 // Close closes the database connection
 func (db *DB) Close() error {
 	return db.sqlDB.Close()
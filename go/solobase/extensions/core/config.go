@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 	
+	"github.com/suppers-ai/solobase/services"
 	"gopkg.in/yaml.v3"
 )
 
@@ -288,4 +289,42 @@ type ConfigValidator interface {
 	ValidateConfig(config interface{}) error
 	DefaultConfig() interface{}
 	ConfigSchema() interface{}
-}
\ No newline at end of file
+}
+// RedactedSecret replaces secret values in API responses. Saving it back
+// keeps the saved secret.
+const RedactedSecret = services.RedactedSecret
+
+// SecretConfigFields returns the config fields an extension's schema marks
+// with "secret": true. They are encrypted at rest, redacted in API responses
+// and only decrypted for the extension itself.
+func SecretConfigFields(schema json.RawMessage) map[string]bool {
+	var parsed struct {
+		Properties map[string]struct {
+			Secret bool `json:"secret"`
+		} `json:"properties"`
+	}
+	if len(schema) == 0 || json.Unmarshal(schema, &parsed) != nil {
+		return nil
+	}
+
+	fields := make(map[string]bool)
+	for name, property := range parsed.Properties {
+		if property.Secret {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// RedactConfig returns a copy of an extension config with the secret fields
+// that are set replaced by RedactedSecret
+func RedactConfig(config map[string]interface{}, secretFields map[string]bool) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		if secretFields[key] && value != nil && value != "" {
+			value = RedactedSecret
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
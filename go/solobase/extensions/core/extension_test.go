@@ -1,8 +1,11 @@
 package core
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +14,7 @@ import (
 	
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"github.com/suppers-ai/solobase/services"
 )
 
 func TestExtensionLifecycle(t *testing.T) {
@@ -75,6 +79,102 @@ func TestExtensionRouting(t *testing.T) {
 	assert.Contains(t, resp.Body.String(), "Mock extension test endpoint")
 }
 
+func TestExtensionRequireAuth(t *testing.T) {
+	suite := NewExtensionTestSuite(t)
+	defer suite.Cleanup()
+
+	router := NewExtensionRouter("products", suite.Registry)
+	handler := router.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Context().Value("user_id"))
+	}))
+
+	request := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/ext/products/items", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Without an authenticator nobody gets through
+	assert.Equal(t, http.StatusUnauthorized, request("jwt").Code)
+
+	suite.Registry.SetAuthenticator(func(req *http.Request) (*AuthIdentity, error) {
+		switch req.Header.Get("Authorization") {
+		case "Bearer jwt":
+			return &AuthIdentity{UserID: "user-1", Role: "user"}, nil
+		case "Bearer products-key":
+			return &AuthIdentity{UserID: "user-2", Scopes: []string{"ext:products"}}, nil
+		case "Bearer storage-key":
+			return &AuthIdentity{UserID: "user-3", Scopes: []string{"storage:read"}}, nil
+		}
+		return nil, fmt.Errorf("invalid credentials")
+	})
+
+	assert.Equal(t, http.StatusUnauthorized, request("").Code)
+
+	rec := request("jwt")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "user-1", rec.Body.String())
+
+	rec = request("products-key")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "user-2", rec.Body.String())
+
+	assert.Equal(t, http.StatusForbidden, request("storage-key").Code)
+}
+
+// permissionedExtension is a mock extension that requires permissions
+type permissionedExtension struct {
+	*MockExtension
+}
+
+func (e *permissionedExtension) RequiredPermissions() []Permission {
+	return []Permission{{Name: "reports.view", Description: "View reports"}}
+}
+
+func TestExtensionRequirePermission(t *testing.T) {
+	suite := NewExtensionTestSuite(t)
+	defer suite.Cleanup()
+
+	registered := map[string][]Permission{}
+	suite.Registry.SetPermissionRegistrar(func(extension string, permissions []Permission) error {
+		registered[extension] = permissions
+		return nil
+	})
+	suite.Registry.SetAuthenticator(func(req *http.Request) (*AuthIdentity, error) {
+		return &AuthIdentity{UserID: req.Header.Get("Authorization")[len("Bearer "):], Role: "user"}, nil
+	})
+	suite.Registry.SetPermissionChecker(func(ctx context.Context, userID string, permission string) bool {
+		return userID == "analyst" && permission == "reports.view"
+	})
+
+	// Permissions are registered when the extension is enabled
+	assert.NoError(t, suite.Registry.Register(&permissionedExtension{NewMockExtension("reports", "1.0.0")}))
+	assert.NoError(t, suite.Registry.Enable("reports"))
+	assert.Equal(t, "reports.view", registered["reports"][0].Name)
+
+	router := NewExtensionRouter("reports", suite.Registry)
+	handler := router.RequirePermission("reports.view", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "report")
+	}))
+	request := func(userID string) int {
+		req := httptest.NewRequest("GET", "/ext/reports/summary", nil)
+		req.Header.Set("Authorization", "Bearer "+userID)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, request("analyst"))
+	assert.Equal(t, http.StatusForbidden, request("intern"))
+
+	auth := suite.Registry.services.ForExtension("reports").Auth()
+	assert.True(t, auth.CheckPermission(context.Background(), "analyst", "reports.view"))
+	assert.False(t, auth.CheckPermission(context.Background(), "analyst", "reports.delete"))
+}
+
 func TestExtensionConfiguration(t *testing.T) {
 	suite := NewExtensionTestSuite(t)
 	defer suite.Cleanup()
@@ -96,6 +196,25 @@ func TestExtensionConfiguration(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestExtensionSecretConfig(t *testing.T) {
+	schema := json.RawMessage(`{"type": "object", "properties": {
+		"apiKey": {"type": "string", "secret": true},
+		"endpoint": {"type": "string"}
+	}}`)
+	fields := SecretConfigFields(schema)
+	assert.Equal(t, map[string]bool{"apiKey": true}, fields)
+	assert.Empty(t, SecretConfigFields(nil))
+	
+	config := map[string]interface{}{"apiKey": "sk-123", "endpoint": "https://example.com"}
+	redacted := RedactConfig(config, fields)
+	assert.Equal(t, RedactedSecret, redacted["apiKey"])
+	assert.Equal(t, "https://example.com", redacted["endpoint"])
+	assert.Equal(t, "sk-123", config["apiKey"])
+	
+	// Unset secrets are shown as unset
+	assert.Equal(t, "", RedactConfig(map[string]interface{}{"apiKey": ""}, fields)["apiKey"])
+}
+
 func TestExtensionHooks(t *testing.T) {
 	suite := NewExtensionTestSuite(t)
 	defer suite.Cleanup()
@@ -132,6 +251,54 @@ func TestExtensionHooks(t *testing.T) {
 	assert.Equal(t, "value", data["test"])
 }
 
+func TestUserDataHooks(t *testing.T) {
+	suite := NewExtensionTestSuite(t)
+	defer suite.Cleanup()
+	
+	mockExt := &MockExtension{
+		name:    "hook-test",
+		version: "1.0.0",
+		hooks: []HookRegistration{
+			{
+				Extension: "hook-test",
+				Name:      "export",
+				Type:      HookUserDataExport,
+				Handler: func(ctx context.Context, hctx *HookContext) error {
+					return UserDataArchive(hctx).AddJSON("data.json", hctx.Data["userID"])
+				},
+			},
+			{
+				Extension: "hook-test",
+				Name:      "erase",
+				Type:      HookUserDataErase,
+				Handler: func(ctx context.Context, hctx *HookContext) error {
+					return errors.New("erase failed")
+				},
+			},
+		},
+	}
+	suite.Registry.Register(mockExt)
+	suite.Registry.Enable("hook-test")
+	hook := UserDataHook(suite.Registry)
+	
+	// Exported files go in a directory named after the extension
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	assert.NoError(t, hook(context.Background(), "user-1", services.NewUserDataArchive(zw)))
+	assert.NoError(t, zw.Close())
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	if assert.Len(t, zr.File, 1) {
+		assert.Equal(t, "hook-test/data.json", zr.File[0].Name)
+	}
+	
+	// Unlike other hooks, failures are returned
+	err = hook(context.Background(), "user-1", nil)
+	var hookErr *HookExecutionError
+	assert.ErrorAs(t, err, &hookErr)
+	assert.Equal(t, "hook-test", hookErr.Extension)
+}
+
 func TestExtensionMiddleware(t *testing.T) {
 	suite := NewExtensionTestSuite(t)
 	defer suite.Cleanup()
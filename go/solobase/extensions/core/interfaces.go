@@ -183,10 +183,15 @@ const (
 	HookAfterUpload    HookType = "after_upload"
 	HookBeforeDownload HookType = "before_download"
 	HookAfterDownload  HookType = "after_download"
+	HookAfterPurge     HookType = "after_purge" // Content deleted for good, see StoragePurgeHook
 	
 	// User lifecycle hooks
 	HookPostLogin      HookType = "post_login"
 	HookPostSignup     HookType = "post_signup"
+
+	// User data hooks, run by data export and erasure jobs
+	HookUserDataExport HookType = "user_data_export"
+	HookUserDataErase  HookType = "user_data_erase"
 )
 
 // HookContext provides context for hook execution
@@ -232,6 +232,14 @@ func (r *MockRouter) RequireRole(role string, handler http.Handler) http.Handler
 	})
 }
 
+func (r *MockRouter) RequirePermission(permission string, handler http.Handler) http.Handler {
+	// Wrap handler with mock permission check
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// In tests, just pass through
+		handler.ServeHTTP(w, req)
+	})
+}
+
 // GetRoutes returns the registered routes for testing
 func (r *MockRouter) GetRoutes() map[string]http.Handler {
 	return r.routes
@@ -29,6 +29,14 @@ type ExtensionRegistry struct {
 	// Error handling
 	errorHandler ExtensionErrorHandler
 	panicHandler ExtensionPanicHandler
+
+	// authenticator resolves callers for routes wrapped with RequireAuth
+	authenticator ExtensionAuthenticator
+
+	// permissionChecker answers RequirePermission and ExtensionAuth.CheckPermission
+	permissionChecker ExtensionPermissionChecker
+	// permissionRegistrar records each extension's required permissions when it is enabled
+	permissionRegistrar ExtensionPermissionRegistrar
 }
 
 // NewExtensionRegistry creates a new extension registry
@@ -111,6 +119,13 @@ func (r *ExtensionRegistry) Enable(name string) error {
 	if err := ext.Start(ctx); err != nil {
 		return fmt.Errorf("failed to start extension %s: %w", name, err)
 	}
+
+	// Make the extension's permissions available to roles
+	if r.permissionRegistrar != nil {
+		if err := r.permissionRegistrar(name, ext.RequiredPermissions()); err != nil {
+			r.logger.Error(ctx, fmt.Sprintf("Failed to register permissions of extension %s: %v", name, err))
+		}
+	}
 	
 	// Update health status
 	health, _ := ext.Health(ctx)
@@ -325,6 +340,29 @@ func (r *ExtensionRegistry) ExecuteHooks(ctx context.Context, hookType HookType,
 	return nil
 }
 
+// ExecuteRequiredHooks executes hooks of the specified type like
+// ExecuteHooks, but stops at the first failing hook and returns its error.
+// It is used where skipping an extension's work is not acceptable.
+func (r *ExtensionRegistry) ExecuteRequiredHooks(ctx context.Context, hookType HookType, hookCtx *HookContext) error {
+	r.mu.RLock()
+	hooks := r.hooks[hookType]
+	r.mu.RUnlock()
+
+	for _, hook := range hooks {
+		hookCtx.Extension = hook.Extension
+		if err := r.executeHookSafely(ctx, hook, hookCtx); err != nil {
+			return &HookExecutionError{
+				Extension: hook.Extension,
+				Hook:      hook.Name,
+				Type:      hook.Type,
+				Err:       err,
+			}
+		}
+	}
+
+	return nil
+}
+
 // initializeExtension initializes an extension
 func (r *ExtensionRegistry) initializeExtension(ctx context.Context, ext Extension) error {
 	metadata := ext.Metadata()
@@ -504,6 +542,26 @@ func (r *ExtensionRegistry) SetPanicHandler(handler ExtensionPanicHandler) {
 	r.panicHandler = handler
 }
 
+// SetAuthenticator sets how RequireAuth identifies callers of extension routes
+func (r *ExtensionRegistry) SetAuthenticator(authenticator ExtensionAuthenticator) {
+	r.authenticator = authenticator
+}
+
+// SetPermissionChecker sets how permissions of extension route callers are
+// checked. Set it before enabling extensions so their services can use it too.
+func (r *ExtensionRegistry) SetPermissionChecker(checker ExtensionPermissionChecker) {
+	r.permissionChecker = checker
+	if r.services != nil {
+		r.services.permissionChecker = checker
+	}
+}
+
+// SetPermissionRegistrar sets where the permissions extensions require are
+// recorded when they are enabled
+func (r *ExtensionRegistry) SetPermissionRegistrar(registrar ExtensionPermissionRegistrar) {
+	r.permissionRegistrar = registrar
+}
+
 // defaultErrorHandler returns the default error handler
 func defaultErrorHandler(log logger.Logger) ExtensionErrorHandler {
 	return func(err *ExtensionError) {
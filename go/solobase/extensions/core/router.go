@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -18,8 +19,29 @@ type ExtensionRouter interface {
 	// Restricted methods that require permissions
 	RequireAuth(handler http.Handler) http.Handler
 	RequireRole(role string, handler http.Handler) http.Handler
+	RequirePermission(permission string, handler http.Handler) http.Handler
 }
 
+// AuthIdentity is the caller resolved by an ExtensionAuthenticator
+type AuthIdentity struct {
+	UserID string
+	Email  string
+	Role   string
+	// Scopes limits API key callers; nil means the caller is not restricted
+	Scopes []string
+}
+
+// ExtensionAuthenticator resolves the caller of a request from its credentials
+type ExtensionAuthenticator func(req *http.Request) (*AuthIdentity, error)
+
+// ExtensionPermissionChecker reports whether a user has a permission. The
+// context carries the user's role when the request identified them.
+type ExtensionPermissionChecker func(ctx context.Context, userID string, permission string) bool
+
+// ExtensionPermissionRegistrar records the permissions an extension requires
+// so they can be granted to roles
+type ExtensionPermissionRegistrar func(extension string, permissions []Permission) error
+
 // extensionRouter implements ExtensionRouter
 type extensionRouter struct {
 	extension string
@@ -86,17 +108,38 @@ func (r *extensionRouter) Use(middleware ...mux.MiddlewareFunc) {
 	}
 }
 
-// RequireAuth wraps a handler to require authentication
+// RequireAuth wraps a handler to require authentication. Callers already
+// identified upstream pass through; otherwise the registry's authenticator
+// checks the request's JWT or API key. API keys must carry the ext:<name>
+// scope of this extension.
 func (r *extensionRouter) RequireAuth(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		// Check if user is authenticated
-		userID := req.Context().Value("user_id")
-		if userID == nil {
+		if userID, _ := req.Context().Value("user_id").(string); userID != "" {
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		authenticate := r.registry.authenticator
+		if authenticate == nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		
-		handler.ServeHTTP(w, req)
+
+		identity, err := authenticate(req)
+		if err != nil || identity == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if identity.Scopes != nil && !hasScope(identity.Scopes, "ext:"+r.extension) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), "user_id", identity.UserID)
+		ctx = context.WithValue(ctx, "user_email", identity.Email)
+		ctx = context.WithValue(ctx, "user_role", identity.Role)
+		handler.ServeHTTP(w, req.WithContext(ctx))
 	})
 }
 
@@ -114,6 +157,30 @@ func (r *extensionRouter) RequireRole(role string, handler http.Handler) http.Ha
 	})
 }
 
+// RequirePermission wraps a handler to require authentication and a
+// permission granted through the caller's roles
+func (r *extensionRouter) RequirePermission(permission string, handler http.Handler) http.Handler {
+	return r.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		userID, _ := req.Context().Value("user_id").(string)
+		check := r.registry.permissionChecker
+		if check == nil || !check(req.Context(), userID, permission) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, req)
+	}))
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 // ensurePrefix ensures the path has the extension prefix
 func (r *extensionRouter) ensurePrefix(path string) string {
 	// Remove leading slash if present
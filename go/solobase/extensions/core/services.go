@@ -3,6 +3,10 @@ package core
 import (
 	"context"
 	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
 	
 	"github.com/suppers-ai/auth"
 	"github.com/suppers-ai/database"
@@ -21,6 +25,9 @@ type ExtensionServices struct {
 	config      *config.Config
 	collections *services.CollectionsService
 	stats       *services.StatsService
+
+	// permissionChecker is set by the registry, see SetPermissionChecker
+	permissionChecker ExtensionPermissionChecker
 	
 	// Extension-specific context
 	extensionName string
@@ -51,15 +58,16 @@ func NewExtensionServices(
 // ForExtension creates extension-specific services
 func (s *ExtensionServices) ForExtension(extensionName string) *ExtensionServices {
 	return &ExtensionServices{
-		db:            s.db,
-		auth:          s.auth,
-		logger:        s.logger,
-		storage:       s.storage,
-		config:        s.config,
-		collections:   s.collections,
-		stats:         s.stats,
-		extensionName: extensionName,
-		schemaName:    fmt.Sprintf("ext_%s", extensionName),
+		db:                s.db,
+		auth:              s.auth,
+		logger:            s.logger,
+		storage:           s.storage,
+		config:            s.config,
+		collections:       s.collections,
+		stats:             s.stats,
+		permissionChecker: s.permissionChecker,
+		extensionName:     extensionName,
+		schemaName:        fmt.Sprintf("ext_%s", extensionName),
 	}
 }
 
@@ -74,7 +82,8 @@ func (s *ExtensionServices) Database() ExtensionDatabase {
 // Auth returns the extension auth interface
 func (s *ExtensionServices) Auth() ExtensionAuth {
 	return &extensionAuth{
-		auth: s.auth,
+		auth:        s.auth,
+		permissions: s.permissionChecker,
 	}
 }
 
@@ -197,7 +206,8 @@ type ExtensionAuth interface {
 
 // extensionAuth implements ExtensionAuth
 type extensionAuth struct {
-	auth *auth.Service
+	auth        *auth.Service
+	permissions ExtensionPermissionChecker
 }
 
 func (a *extensionAuth) GetUser(ctx context.Context, userID string) (interface{}, error) {
@@ -213,9 +223,11 @@ func (a *extensionAuth) ValidateToken(ctx context.Context, token string) (interf
 }
 
 func (a *extensionAuth) CheckPermission(ctx context.Context, userID string, permission string) bool {
-	// Check if user has permission
-	// TODO: Implement permission checking
-	return false
+	// Without a checker nobody has any permission
+	if a.permissions == nil || userID == "" {
+		return false
+	}
+	return a.permissions(ctx, userID, permission)
 }
 
 // ExtensionLogger provides extension-scoped logging
@@ -252,10 +264,14 @@ func (l *extensionLogger) Error(ctx context.Context, msg string, fields ...logge
 	l.logger.Error(ctx, msg, fields...)
 }
 
-// ExtensionStorage provides controlled storage access
+// ExtensionStorage provides controlled storage access. Content streams in
+// and out rather than being held in memory. Paths are kept apart per
+// extension, under extensions/<name>/ in the bucket.
 type ExtensionStorage interface {
-	Upload(ctx context.Context, bucket, path string, content []byte) error
-	Download(ctx context.Context, bucket, path string) ([]byte, error)
+	// Upload streams content to a path; size is -1 when it is not known
+	Upload(ctx context.Context, bucket, path string, content io.Reader, size int64) error
+	// Download opens the content at a path; the caller must close it
+	Download(ctx context.Context, bucket, path string) (io.ReadCloser, error)
 	Delete(ctx context.Context, bucket, path string) error
 	List(ctx context.Context, bucket, prefix string) ([]string, error)
 }
@@ -266,24 +282,55 @@ type extensionStorage struct {
 	extension string
 }
 
-func (s *extensionStorage) Upload(ctx context.Context, bucket, path string, content []byte) error {
-	// TODO: Implement file upload through storage service
-	return fmt.Errorf("not implemented")
+// key returns the storage key of an extension's path. Paths cannot climb
+// out of the extension's folder.
+func (s *extensionStorage) key(p string) string {
+	return "extensions/" + s.extension + path.Clean("/"+p)
 }
 
-func (s *extensionStorage) Download(ctx context.Context, bucket, path string) ([]byte, error) {
-	// TODO: Implement file download through storage service
-	return nil, fmt.Errorf("not implemented")
+func (s *extensionStorage) Upload(ctx context.Context, bucket, p string, content io.Reader, size int64) error {
+	if s.storage == nil {
+		return fmt.Errorf("storage not initialized")
+	}
+	contentType := mime.TypeByExtension(path.Ext(p))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return s.storage.PutKeyedObject(bucket, s.key(p), content, size, contentType)
 }
 
-func (s *extensionStorage) Delete(ctx context.Context, bucket, path string) error {
-	// TODO: Implement file deletion through storage service
-	return fmt.Errorf("not implemented")
+func (s *extensionStorage) Download(ctx context.Context, bucket, p string) (io.ReadCloser, error) {
+	if s.storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+	return s.storage.GetKeyedObject(bucket, s.key(p))
+}
+
+func (s *extensionStorage) Delete(ctx context.Context, bucket, p string) error {
+	if s.storage == nil {
+		return fmt.Errorf("storage not initialized")
+	}
+	return s.storage.DeleteKeyedObject(bucket, s.key(p))
 }
 
 func (s *extensionStorage) List(ctx context.Context, bucket, prefix string) ([]string, error) {
-	// TODO: Implement file listing through storage service
-	return nil, fmt.Errorf("not implemented")
+	if s.storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+	keys, err := s.storage.ListKeyedObjects(bucket, strings.TrimSuffix(s.key(prefix), "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Return paths as the extension knows them
+	root := s.key("/")
+	paths := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if strings.HasPrefix(key, root) {
+			paths = append(paths, strings.TrimPrefix(key, root))
+		}
+	}
+	return paths, nil
 }
 
 // ExtensionConfigInterface provides extension configuration
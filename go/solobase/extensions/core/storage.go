@@ -0,0 +1,22 @@
+package core
+
+import (
+	"context"
+
+	"github.com/suppers-ai/solobase/services"
+)
+
+// StoragePurgeHook runs the after purge hooks of enabled extensions when
+// storage content is deleted for good. Hooks find the user, bucket and object
+// in hookCtx.Data["userID"], ["bucket"] and ["objectID"], and the bytes freed
+// in hookCtx.Data["fileSize"] as an int64.
+func StoragePurgeHook(registry *ExtensionRegistry) services.StoragePurgeHook {
+	return func(ctx context.Context, userID, bucket, objectID string, size int64) {
+		registry.ExecuteHooks(ctx, HookAfterPurge, &HookContext{Data: map[string]interface{}{
+			"userID":   userID,
+			"bucket":   bucket,
+			"objectID": objectID,
+			"fileSize": size,
+		}})
+	}
+}
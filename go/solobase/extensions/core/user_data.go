@@ -0,0 +1,35 @@
+package core
+
+import (
+	"context"
+
+	"github.com/suppers-ai/solobase/services"
+)
+
+// User data hooks find the user in hookCtx.Data["userID"]. Export hooks add
+// the user's data to the archive returned by UserDataArchive; erase hooks
+// delete it, or anonymize rows that must stay. A failing hook fails the job.
+
+// UserDataHook runs the user data hooks of enabled extensions for data export
+// and erasure jobs
+func UserDataHook(registry *ExtensionRegistry) services.UserDataHook {
+	return func(ctx context.Context, userID string, archive *services.UserDataArchive) error {
+		hookType := HookUserDataErase
+		data := map[string]interface{}{"userID": userID}
+		if archive != nil {
+			hookType = HookUserDataExport
+			data["archive"] = archive
+		}
+		return registry.ExecuteRequiredHooks(ctx, hookType, &HookContext{Data: data})
+	}
+}
+
+// UserDataArchive returns the archive an export hook adds the user's data to.
+// Files are placed in a directory named after the extension.
+func UserDataArchive(hookCtx *HookContext) *services.UserDataArchive {
+	archive, ok := hookCtx.Data["archive"].(*services.UserDataArchive)
+	if !ok {
+		return nil
+	}
+	return archive.Dir(hookCtx.Extension)
+}
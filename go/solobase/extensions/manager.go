@@ -11,6 +11,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/suppers-ai/logger"
 	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/services"
 	"gorm.io/gorm"
 )
 
@@ -21,6 +22,9 @@ type ExtensionManager struct {
 	config   *ExtensionConfig
 	logger   logger.Logger
 	db       *gorm.DB
+
+	// secrets encrypts the config fields extensions mark as secret
+	secrets *services.SecretsService
 }
 
 // ExtensionConfig holds the configuration for all extensions
@@ -75,6 +79,11 @@ func (m *ExtensionManager) Initialize(ctx context.Context) error {
 		return fmt.Errorf("failed to register extensions: %w", err)
 	}
 
+	// Encrypt secrets saved as plaintext or with an old master key
+	if err := m.sealSavedSecrets(); err != nil {
+		m.logger.Error(ctx, "Failed to encrypt extension secrets", logger.Err(err))
+	}
+
 	// Enable configured extensions
 	for name, settings := range m.config.Extensions {
 		if settings.Enabled {
@@ -138,6 +147,66 @@ func (m *ExtensionManager) GetExtension(name string) (core.Extension, bool) {
 	return m.registry.Get(name)
 }
 
+// SetSecrets encrypts the config fields extensions mark with "secret": true
+// in their config schema. It must be called before Initialize.
+func (m *ExtensionManager) SetSecrets(secrets *services.SecretsService) {
+	m.secrets = secrets
+}
+
+// GetExtensionConfig returns the saved config of an extension with its
+// secret fields redacted
+func (m *ExtensionManager) GetExtensionConfig(name string) map[string]interface{} {
+	return core.RedactConfig(m.config.Extensions[name].Config, m.secretFields(name))
+}
+
+// SaveExtensionConfig validates and applies a new config for an extension,
+// then saves it with the secret fields encrypted. Secret fields that are left
+// out or sent back redacted keep their saved value.
+func (m *ExtensionManager) SaveExtensionConfig(name string, config map[string]interface{}) error {
+	ext, exists := m.registry.Get(name)
+	if !exists {
+		return fmt.Errorf("extension %s not found", name)
+	}
+
+	settings := m.config.Extensions[name]
+	merged := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		merged[key] = value
+	}
+	for field := range m.secretFields(name) {
+		saved, hasSaved := settings.Config[field]
+		if value, sent := merged[field]; hasSaved && (!sent || value == core.RedactedSecret) {
+			merged[field] = saved
+		}
+	}
+
+	opened, err := m.openSecrets(name, merged)
+	if err != nil {
+		return err
+	}
+	configJSON, err := json.Marshal(opened)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config for %s: %w", name, err)
+	}
+	if err := ext.ValidateConfig(configJSON); err != nil {
+		return fmt.Errorf("invalid config for %s: %w", name, err)
+	}
+	if err := ext.ApplyConfig(configJSON); err != nil {
+		return fmt.Errorf("failed to apply config for %s: %w", name, err)
+	}
+
+	sealed, _, err := m.sealSecrets(name, merged)
+	if err != nil {
+		return err
+	}
+	if m.config.Extensions == nil {
+		m.config.Extensions = make(map[string]ExtensionSettings)
+	}
+	settings.Config = sealed
+	m.config.Extensions[name] = settings
+	return m.saveConfig()
+}
+
 // SaveExtensionState saves the enabled/disabled state of an extension
 func (m *ExtensionManager) SaveExtensionState(name string, enabled bool) {
 	if m.config.Extensions == nil {
@@ -167,6 +236,10 @@ func (m *ExtensionManager) enableExtension(ctx context.Context, name string, con
 
 	// Apply configuration if provided
 	if len(config) > 0 {
+		config, err := m.openSecrets(name, config)
+		if err != nil {
+			return err
+		}
 		configJSON, err := json.Marshal(config)
 		if err != nil {
 			return fmt.Errorf("failed to marshal config for %s: %w", name, err)
@@ -192,6 +265,86 @@ func (m *ExtensionManager) enableExtension(ctx context.Context, name string, con
 	return nil
 }
 
+// secretFields returns the config fields an extension marks as secret
+func (m *ExtensionManager) secretFields(name string) map[string]bool {
+	ext, exists := m.registry.Get(name)
+	if !exists {
+		return nil
+	}
+	return core.SecretConfigFields(ext.ConfigSchema())
+}
+
+// configSecretContext binds an encrypted config field to its extension
+func configSecretContext(name, field string) string {
+	return "extension:" + name + ":" + field
+}
+
+// sealSecrets returns a copy of a config with plaintext secret fields
+// encrypted and those encrypted with an old master key rewrapped. Without a
+// secrets service the config is returned unchanged.
+func (m *ExtensionManager) sealSecrets(name string, config map[string]interface{}) (map[string]interface{}, bool, error) {
+	fields := m.secretFields(name)
+	sealed := make(map[string]interface{}, len(config))
+	changed := false
+	for key, value := range config {
+		if secret, ok := value.(string); ok && fields[key] && secret != "" && m.secrets != nil && m.secrets.NeedsRewrap(secret) {
+			encrypted, err := m.secrets.Rewrap(secret, configSecretContext(name, key))
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to encrypt %s config field %s: %w", name, key, err)
+			}
+			value, changed = encrypted, true
+		}
+		sealed[key] = value
+	}
+	return sealed, changed, nil
+}
+
+// openSecrets returns a copy of a config with the secret fields decrypted,
+// for applying to the extension
+func (m *ExtensionManager) openSecrets(name string, config map[string]interface{}) (map[string]interface{}, error) {
+	fields := m.secretFields(name)
+	opened := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		if secret, ok := value.(string); ok && fields[key] && services.IsEncryptedSecret(secret) {
+			if m.secrets == nil {
+				return nil, fmt.Errorf("%s config field %s is encrypted but no master key is loaded", name, key)
+			}
+			decrypted, err := m.secrets.Decrypt(secret, configSecretContext(name, key))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt %s config field %s: %w", name, key, err)
+			}
+			value = decrypted
+		}
+		opened[key] = value
+	}
+	return opened, nil
+}
+
+// sealSavedSecrets encrypts the secret fields of the saved configs that are
+// plaintext or use an old master key, and saves the result
+func (m *ExtensionManager) sealSavedSecrets() error {
+	if m.secrets == nil {
+		return nil
+	}
+
+	changed := false
+	for name, settings := range m.config.Extensions {
+		sealed, sealedChanged, err := m.sealSecrets(name, settings.Config)
+		if err != nil {
+			return err
+		}
+		if sealedChanged {
+			settings.Config = sealed
+			m.config.Extensions[name] = settings
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return m.saveConfig()
+}
+
 // saveConfig saves the extension configuration to file
 func (m *ExtensionManager) saveConfig() error {
 	configPath := filepath.Join("extensions", "config.json")
@@ -190,6 +190,15 @@ func (e *CloudStorageExtension) RegisterHooks() []core.HookRegistration {
 			Handler:   e.updateStorageUsageHook,
 		})
 		
+		// After purge - release the storage deleted for good
+		hooks = append(hooks, core.HookRegistration{
+			Extension: "cloudstorage",
+			Name:      "release_storage_usage",
+			Type:      core.HookAfterPurge,
+			Priority:  10,
+			Handler:   e.releaseStorageUsageHook,
+		})
+		
 		// After download - update bandwidth usage
 		hooks = append(hooks, core.HookRegistration{
 			Extension: "cloudstorage",
@@ -200,6 +209,23 @@ func (e *CloudStorageExtension) RegisterHooks() []core.HookRegistration {
 		})
 	}
 	
+	// User data export and erasure
+	hooks = append(hooks, core.HookRegistration{
+		Extension: "cloudstorage",
+		Name:      "export_user_data",
+		Type:      core.HookUserDataExport,
+		Priority:  10,
+		Handler:   e.exportUserDataHook,
+	})
+	
+	hooks = append(hooks, core.HookRegistration{
+		Extension: "cloudstorage",
+		Name:      "erase_user_data",
+		Type:      core.HookUserDataErase,
+		Priority:  10,
+		Handler:   e.eraseUserDataHook,
+	})
+	
 	// Access logging hooks
 	if e.config.EnableAccessLogs {
 		hooks = append(hooks, core.HookRegistration{
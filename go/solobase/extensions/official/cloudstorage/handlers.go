@@ -183,6 +183,7 @@ func (e *CloudStorageExtension) handleShareAccess(w http.ResponseWriter, r *http
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		defer content.Close()
 
 		// Update bandwidth usage for the file owner
 		if e.quotaService != nil && e.config.EnableQuotas && obj.UserID != "" {
@@ -197,8 +198,8 @@ func (e *CloudStorageExtension) handleShareAccess(w http.ResponseWriter, r *http
 		w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", obj.ObjectName))
 
-		// Write content
-		w.Write(content)
+		// Stream content
+		io.Copy(w, content)
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -406,13 +407,6 @@ func (e *CloudStorageExtension) handleUpload(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	// Read file content
-	content, err := io.ReadAll(file)
-	if err != nil {
-		http.Error(w, "Failed to read file", http.StatusInternalServerError)
-		return
-	}
-
 	// Upload file
 	filename := header.Filename
 	contentType := header.Header.Get("Content-Type")
@@ -439,7 +433,7 @@ func (e *CloudStorageExtension) handleUpload(w http.ResponseWriter, r *http.Requ
 	// AppID for the cloudstorage extension
 	appID := "cloudstorage"
 
-	obj, err := e.manager.UploadObject(ctx, bucketName, filename, parentFolderPtr, content, contentType, userUUID, &appID)
+	obj, err := e.manager.UploadObject(ctx, bucketName, filename, parentFolderPtr, file, header.Size, contentType, userUUID, &appID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -688,6 +682,7 @@ func (e *CloudStorageExtension) handleDownload(w http.ResponseWriter, r *http.Re
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer content.Close()
 	
 	// Update bandwidth usage for the file owner
 	if e.quotaService != nil && e.config.EnableQuotas && obj.UserID != "" {
@@ -714,8 +709,8 @@ func (e *CloudStorageExtension) handleDownload(w http.ResponseWriter, r *http.Re
 	w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", obj.ObjectName))
 	
-	// Write content
-	w.Write(content)
+	// Stream content
+	io.Copy(w, content)
 }
 
 // handleUserSearch handles user search for admin panel
@@ -77,6 +77,31 @@ func (e *CloudStorageExtension) updateStorageUsageHook(ctx context.Context, hook
 	return nil
 }
 
+// releaseStorageUsageHook gives back the storage of content deleted for good.
+// Files moved to the trash keep counting until they are purged.
+func (e *CloudStorageExtension) releaseStorageUsageHook(ctx context.Context, hookCtx *core.HookContext) error {
+	if e.db == nil || e.quotaService == nil {
+		return nil
+	}
+	
+	userID, ok := hookCtx.Data["userID"].(string)
+	if !ok || userID == "" {
+		return nil
+	}
+	
+	fileSize, ok := hookCtx.Data["fileSize"].(int64)
+	if !ok || fileSize == 0 {
+		return nil
+	}
+	
+	if err := e.quotaService.ReleaseStorageUsage(ctx, userID, fileSize); err != nil {
+		log.Printf("Failed to release storage usage for user %s: %v", userID, err)
+		return err
+	}
+	
+	return nil
+}
+
 // updateBandwidthUsageHook updates bandwidth usage after download
 func (e *CloudStorageExtension) updateBandwidthUsageHook(ctx context.Context, hookCtx *core.HookContext) error {
 	if e.db == nil || e.quotaService == nil {
@@ -181,6 +206,11 @@ func (e *CloudStorageExtension) logDownloadAccessHook(ctx context.Context, hookC
 
 // setupUserResourcesHook creates the user's "My Files" folder on login
 func (e *CloudStorageExtension) setupUserResourcesHook(ctx context.Context, hookCtx *core.HookContext) error {
+	// Failed logins are reported too
+	if success, ok := hookCtx.Data["success"].(bool); ok && !success {
+		return nil
+	}
+	
 	// Extract user data
 	userID, ok := hookCtx.Data["userID"].(string)
 	if !ok || userID == "" {
@@ -244,5 +274,67 @@ func (e *CloudStorageExtension) setupUserResourcesHook(ctx context.Context, hook
 	return nil
 }
 
+// exportUserDataHook adds the user's shares, quota and access logs to a data export
+func (e *CloudStorageExtension) exportUserDataHook(ctx context.Context, hookCtx *core.HookContext) error {
+	if e.db == nil {
+		return nil
+	}
+	
+	userID, _ := hookCtx.Data["userID"].(string)
+	archive := core.UserDataArchive(hookCtx)
+	if userID == "" || archive == nil {
+		return nil
+	}
+	
+	var shares []StorageShare
+	if err := e.db.WithContext(ctx).Where("created_by = ? OR shared_with_user_id = ?", userID, userID).Find(&shares).Error; err != nil {
+		return err
+	}
+	// Share tokens give access to files, they are not the user's data
+	for i := range shares {
+		shares[i].ShareToken = nil
+	}
+	if err := archive.AddJSON("shares.json", shares); err != nil {
+		return err
+	}
+	
+	var quotas []StorageQuota
+	if err := e.db.WithContext(ctx).Where("user_id = ?", userID).Find(&quotas).Error; err != nil {
+		return err
+	}
+	if err := archive.AddJSON("quotas.json", quotas); err != nil {
+		return err
+	}
+	
+	var accessLogs []StorageAccessLog
+	if err := e.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&accessLogs).Error; err != nil {
+		return err
+	}
+	return archive.AddJSON("access_logs.json", accessLogs)
+}
+
+// eraseUserDataHook deletes the user's shares and quota, and removes the
+// user from access logs, which are kept as a record of access to files
+func (e *CloudStorageExtension) eraseUserDataHook(ctx context.Context, hookCtx *core.HookContext) error {
+	if e.db == nil {
+		return nil
+	}
+	
+	userID, _ := hookCtx.Data["userID"].(string)
+	if userID == "" {
+		return nil
+	}
+	
+	db := e.db.WithContext(ctx)
+	if err := db.Where("created_by = ? OR shared_with_user_id = ?", userID, userID).Delete(&StorageShare{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("user_id = ?", userID).Delete(&StorageQuota{}).Error; err != nil {
+		return err
+	}
+	return db.Model(&StorageAccessLog{}).Where("user_id = ?", userID).
+		Updates(map[string]interface{}{"user_id": nil, "ip_address": nil, "user_agent": nil}).Error
+}
+
 // Helper methods for quota service
 
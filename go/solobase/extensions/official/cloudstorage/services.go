@@ -211,6 +211,17 @@ func (q *QuotaService) UpdateStorageUsage(ctx context.Context, userID string, si
 		}).Error
 }
 
+// ReleaseStorageUsage takes storage deleted for good off a user's usage,
+// stopping at zero for files uploaded before the quota was tracked
+func (q *QuotaService) ReleaseStorageUsage(ctx context.Context, userID string, size int64) error {
+	return q.db.Model(&StorageQuota{}).
+		Where("user_id = ?", userID).
+		Updates(map[string]interface{}{
+			"storage_used": gorm.Expr("CASE WHEN storage_used > ? THEN storage_used - ? ELSE 0 END", size, size),
+			"updated_at":   time.Now(),
+		}).Error
+}
+
 // UpdateBandwidthUsage updates the bandwidth usage for a user
 func (q *QuotaService) UpdateBandwidthUsage(ctx context.Context, userID string, sizeDelta int64) error {
 	return q.db.Model(&StorageQuota{}).
@@ -2,6 +2,7 @@ package products
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	
@@ -321,12 +322,24 @@ func NewUserAPI(db *gorm.DB, es *GroupService, ps *ProductService, prs *PricingS
 	}
 }
 
+// requireCaller returns the caller of a user API request, responding with
+// 401 for anonymous requests
+func requireCaller(w http.ResponseWriter, r *http.Request) (Caller, bool) {
+	caller, ok := CallerFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+	}
+	return caller, ok
+}
+
 // Group management for users
 func (u *UserAPI) ListMyGroups(w http.ResponseWriter, r *http.Request) {
-	// TODO: Get user ID from context/session
-	userID := uint(1) // Placeholder
+	caller, ok := requireCaller(w, r)
+	if !ok {
+		return
+	}
 	
-	groups, err := u.groupService.ListByUser(userID)
+	groups, err := u.groupService.ListForCaller(caller)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -337,7 +350,10 @@ func (u *UserAPI) ListMyGroups(w http.ResponseWriter, r *http.Request) {
 }
 
 func (u *UserAPI) CreateGroup(w http.ResponseWriter, r *http.Request) {
-	userID := uint(1) // TODO: Get from context
+	caller, ok := requireCaller(w, r)
+	if !ok {
+		return
+	}
 	
 	var group models.Group
 	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
@@ -345,7 +361,20 @@ func (u *UserAPI) CreateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	group.UserID = userID
+	// Groups belong to the active organization unless the request names one,
+	// an empty organization_id creates a personal group
+	if group.OrganizationID == nil && caller.ActiveOrganizationID != "" {
+		group.OrganizationID = &caller.ActiveOrganizationID
+	}
+	if group.OrganizationID != nil && *group.OrganizationID == "" {
+		group.OrganizationID = nil
+	}
+	if group.OrganizationID != nil && !caller.CanWrite(*group.OrganizationID) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	
+	group.UserID = caller.UserID
 	if err := u.groupService.Create(&group); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -357,7 +386,10 @@ func (u *UserAPI) CreateGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 func (u *UserAPI) UpdateGroup(w http.ResponseWriter, r *http.Request) {
-	userID := uint(1) // TODO: Get from context
+	caller, ok := requireCaller(w, r)
+	if !ok {
+		return
+	}
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 32)
 	if err != nil {
@@ -371,7 +403,11 @@ func (u *UserAPI) UpdateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	if err := u.groupService.Update(uint(id), userID, &group); err != nil {
+	if err := u.groupService.Update(uint(id), caller, &group); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -381,7 +417,10 @@ func (u *UserAPI) UpdateGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 func (u *UserAPI) DeleteGroup(w http.ResponseWriter, r *http.Request) {
-	userID := uint(1) // TODO: Get from context
+	caller, ok := requireCaller(w, r)
+	if !ok {
+		return
+	}
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 32)
 	if err != nil {
@@ -389,7 +428,11 @@ func (u *UserAPI) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	if err := u.groupService.Delete(uint(id), userID); err != nil {
+	if err := u.groupService.Delete(uint(id), caller); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -398,7 +441,10 @@ func (u *UserAPI) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 func (u *UserAPI) GetGroup(w http.ResponseWriter, r *http.Request) {
-	userID := uint(1) // TODO: Get from context
+	caller, ok := requireCaller(w, r)
+	if !ok {
+		return
+	}
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 32)
 	if err != nil {
@@ -406,7 +452,7 @@ func (u *UserAPI) GetGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	group, err := u.groupService.GetByID(uint(id), userID)
+	group, err := u.groupService.GetByID(uint(id), caller)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -418,6 +464,10 @@ func (u *UserAPI) GetGroup(w http.ResponseWriter, r *http.Request) {
 
 // Product management
 func (u *UserAPI) ListGroupProducts(w http.ResponseWriter, r *http.Request) {
+	caller, ok := requireCaller(w, r)
+	if !ok {
+		return
+	}
 	vars := mux.Vars(r)
 	groupID, err := strconv.ParseUint(vars["id"], 10, 32)
 	if err != nil {
@@ -425,6 +475,11 @@ func (u *UserAPI) ListGroupProducts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	if _, err := u.groupService.GetByID(uint(groupID), caller); err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	
 	products, err := u.productService.ListByGroup(uint(groupID))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -436,9 +491,12 @@ func (u *UserAPI) ListGroupProducts(w http.ResponseWriter, r *http.Request) {
 }
 
 func (u *UserAPI) ListMyProducts(w http.ResponseWriter, r *http.Request) {
-	userID := uint(1) // TODO: Get from context
+	caller, ok := requireCaller(w, r)
+	if !ok {
+		return
+	}
 	
-	products, err := u.productService.ListByUser(userID)
+	products, err := u.productService.ListForCaller(caller)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -449,13 +507,21 @@ func (u *UserAPI) ListMyProducts(w http.ResponseWriter, r *http.Request) {
 }
 
 func (u *UserAPI) CreateProduct(w http.ResponseWriter, r *http.Request) {
+	caller, ok := requireCaller(w, r)
+	if !ok {
+		return
+	}
+	
 	var product models.Product
 	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	
-	// TODO: Verify user owns the group
+	if !u.groupService.CanModify(product.GroupID, caller) {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
 	
 	if err := u.productService.Create(&product); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -493,16 +559,18 @@ func (u *UserAPI) CalculatePrice(w http.ResponseWriter, r *http.Request) {
 }
 
 func (u *UserAPI) GetProductStats(w http.ResponseWriter, r *http.Request) {
-	userID := uint(1) // TODO: Get from context
+	caller, ok := requireCaller(w, r)
+	if !ok {
+		return
+	}
 	
 	// Get counts
 	var groupCount int64
-	u.db.Model(&models.Group{}).Where("user_id = ?", userID).Count(&groupCount)
+	caller.groupScope(u.db.Model(&models.Group{}), false).Count(&groupCount)
 	
 	var productCount int64
 	u.db.Model(&models.Product{}).
-		Joins("JOIN groups ON products.group_id = groups.id").
-		Where("groups.user_id = ?", userID).
+		Where("group_id IN (?)", caller.groupScope(u.db.Model(&models.Group{}).Select("id"), false)).
 		Count(&productCount)
 	
 	stats := map[string]interface{}{
@@ -0,0 +1,59 @@
+package products
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Caller identifies the user acting on groups and the organizations whose
+// groups they share. It is put in the request context by the host app.
+type Caller struct {
+	UserID string
+	// Organizations maps the IDs of the caller's organizations onto whether
+	// they may change the organization's groups or only view them
+	Organizations map[string]bool
+	// ActiveOrganizationID is the organization new groups belong to when the
+	// request does not name one
+	ActiveOrganizationID string
+}
+
+type callerContextKey struct{}
+
+// WithCaller returns a context carrying the caller of a request
+func WithCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, callerContextKey{}, caller)
+}
+
+// CallerFromContext returns the caller put in the context by WithCaller
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	caller, ok := ctx.Value(callerContextKey{}).(Caller)
+	return caller, ok && caller.UserID != ""
+}
+
+// CanWrite reports whether the caller may change the groups of an organization
+func (c Caller) CanWrite(orgID string) bool {
+	return c.Organizations[orgID]
+}
+
+// organizationIDs returns the organizations whose groups the caller can
+// view, or change when write is set
+func (c Caller) organizationIDs(write bool) []string {
+	ids := make([]string, 0, len(c.Organizations))
+	for id, canWrite := range c.Organizations {
+		if canWrite || !write {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// groupScope limits a query on groups to the caller's own groups and those of
+// their organizations
+func (c Caller) groupScope(db *gorm.DB, write bool) *gorm.DB {
+	orgIDs := c.organizationIDs(write)
+	if len(orgIDs) == 0 {
+		return db.Where("user_id = ? AND organization_id IS NULL", c.UserID)
+	}
+	return db.Where("((user_id = ? AND organization_id IS NULL) OR organization_id IN ?)", c.UserID, orgIDs)
+}
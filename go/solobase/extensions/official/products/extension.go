@@ -170,8 +170,22 @@ func (e *ProductsExtension) Migrations() []core.Migration {
 
 // RegisterHooks registers the extension's hooks
 func (e *ProductsExtension) RegisterHooks() []core.HookRegistration {
-	// No hooks to register for now
-	return []core.HookRegistration{}
+	return []core.HookRegistration{
+		{
+			Extension: "products",
+			Name:      "export_user_data",
+			Type:      core.HookUserDataExport,
+			Priority:  10,
+			Handler:   e.exportUserDataHook,
+		},
+		{
+			Extension: "products",
+			Name:      "erase_user_data",
+			Type:      core.HookUserDataErase,
+			Priority:  10,
+			Handler:   e.eraseUserDataHook,
+		},
+	}
 }
 
 // RegisterMiddleware registers the extension's middleware
@@ -0,0 +1,65 @@
+package products
+
+import (
+	"context"
+
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/extensions/official/products/models"
+	"gorm.io/gorm"
+)
+
+// exportUserDataHook adds the user's groups and their products to a data export
+func (e *ProductsExtension) exportUserDataHook(ctx context.Context, hookCtx *core.HookContext) error {
+	if e.db == nil {
+		return nil
+	}
+
+	userID, _ := hookCtx.Data["userID"].(string)
+	archive := core.UserDataArchive(hookCtx)
+	if userID == "" || archive == nil {
+		return nil
+	}
+
+	var groups []models.Group
+	if err := e.db.WithContext(ctx).Where("user_id = ?", userID).Find(&groups).Error; err != nil {
+		return err
+	}
+	if err := archive.AddJSON("groups.json", groups); err != nil {
+		return err
+	}
+
+	var products []models.Product
+	if err := e.db.WithContext(ctx).Where("group_id IN (?)", e.userGroupIDs(ctx, userID, false)).Find(&products).Error; err != nil {
+		return err
+	}
+	return archive.AddJSON("products.json", products)
+}
+
+// eraseUserDataHook deletes the user's personal groups and their products.
+// Groups shared with an organization belong to its members and are kept.
+func (e *ProductsExtension) eraseUserDataHook(ctx context.Context, hookCtx *core.HookContext) error {
+	if e.db == nil {
+		return nil
+	}
+
+	userID, _ := hookCtx.Data["userID"].(string)
+	if userID == "" {
+		return nil
+	}
+
+	return e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id IN (?)", e.userGroupIDs(ctx, userID, true)).Delete(&models.Product{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND organization_id IS NULL", userID).Delete(&models.Group{}).Error
+	})
+}
+
+// userGroupIDs selects the IDs of the user's groups, only personal ones if asked
+func (e *ProductsExtension) userGroupIDs(ctx context.Context, userID string, personalOnly bool) *gorm.DB {
+	query := e.db.WithContext(ctx).Model(&models.Group{}).Select("id").Where("user_id = ?", userID)
+	if personalOnly {
+		query = query.Where("organization_id IS NULL")
+	}
+	return query
+}
@@ -92,7 +92,8 @@ func (GroupTemplate) TableName() string {
 // Group represents a business group (restaurant, store, etc)
 type Group struct {
 	ID              uint          `gorm:"primaryKey" json:"id"`
-	UserID          uint          `gorm:"index;not null" json:"user_id"`
+	UserID          string        `gorm:"size:36;index;not null" json:"user_id"`
+	OrganizationID  *string       `gorm:"size:36;index" json:"organization_id,omitempty"` // Set for groups shared by an organization's members
 	GroupTemplateID uint          `gorm:"index;not null" json:"group_template_id"`
 	GroupTemplate   GroupTemplate `json:"group_template,omitempty" gorm:"foreignKey:GroupTemplateID"`
 	Name             string         `gorm:"not null" json:"name"`
@@ -87,9 +87,10 @@ func NewGroupService(db *gorm.DB) *GroupService {
 	}
 }
 
-func (s *GroupService) ListByUser(userID uint) ([]models.Group, error) {
+// ListForCaller lists the caller's own groups and those of their organizations
+func (s *GroupService) ListForCaller(caller Caller) ([]models.Group, error) {
 	var groups []models.Group
-	err := s.db.Preload("GroupTemplate").Where("user_id = ?", userID).Find(&groups).Error
+	err := caller.groupScope(s.db.Preload("GroupTemplate"), false).Find(&groups).Error
 	return groups, err
 }
 
@@ -177,23 +178,41 @@ func (s *GroupService) mapToFilterColumn(tx *gorm.DB, group *models.Group, field
 	}
 }
 
-func (s *GroupService) Update(id uint, userID uint, group *models.Group) error {
-	return s.db.Model(&models.Group{}).Where("id = ? AND user_id = ?", id, userID).Updates(group).Error
+// Update changes a group the caller may change. Ownership cannot be changed.
+func (s *GroupService) Update(id uint, caller Caller, group *models.Group) error {
+	group.UserID = ""
+	group.OrganizationID = nil
+	result := caller.groupScope(s.db.Model(&models.Group{}).Where("id = ?", id), true).Updates(group)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
 }
 
-func (s *GroupService) Delete(id uint, userID uint) error {
-	return s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Group{}).Error
+func (s *GroupService) Delete(id uint, caller Caller) error {
+	result := caller.groupScope(s.db.Where("id = ?", id), true).Delete(&models.Group{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
 }
 
-func (s *GroupService) GetByID(id uint, userID uint) (*models.Group, error) {
+func (s *GroupService) GetByID(id uint, caller Caller) (*models.Group, error) {
 	var group models.Group
-	err := s.db.Preload("GroupTemplate").Where("id = ? AND user_id = ?", id, userID).First(&group).Error
+	err := caller.groupScope(s.db.Preload("GroupTemplate").Where("id = ?", id), false).First(&group).Error
 	if err != nil {
 		return nil, err
 	}
 	return &group, nil
 }
 
+// CanModify reports whether the caller may change a group and its products
+func (s *GroupService) CanModify(id uint, caller Caller) bool {
+	var count int64
+	caller.groupScope(s.db.Model(&models.Group{}).Where("id = ?", id), true).Count(&count)
+	return count > 0
+}
+
 // ProductService handles product operations
 type ProductService struct {
 	db              *gorm.DB
@@ -213,12 +232,13 @@ func (s *ProductService) ListByGroup(groupID uint) ([]models.Product, error) {
 	return products, err
 }
 
-func (s *ProductService) ListByUser(userID uint) ([]models.Product, error) {
+// ListForCaller lists the products of the groups the caller can view
+func (s *ProductService) ListForCaller(caller Caller) ([]models.Product, error) {
 	var products []models.Product
 	
-	// First, get all group IDs for the user
+	// First, get all group IDs for the caller
 	var groupIDs []uint
-	if err := s.db.Table("groups").Where("user_id = ?", userID).Pluck("id", &groupIDs).Error; err != nil {
+	if err := caller.groupScope(s.db.Model(&models.Group{}), false).Pluck("id", &groupIDs).Error; err != nil {
 		return nil, err
 	}
 	
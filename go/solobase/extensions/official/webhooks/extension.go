@@ -20,6 +20,8 @@ type WebhooksExtension struct {
 	enabled  bool
 	client   *http.Client
 	hooks    []WebhookConfig
+	// signingSecret signs deliveries of webhooks without their own secret
+	signingSecret string
 }
 
 // WebhookConfig defines a webhook configuration
@@ -35,6 +37,14 @@ type WebhookConfig struct {
 	UpdatedAt time.Time              `json:"updated_at"`
 }
 
+// redacted returns a copy of the webhook with its secret hidden, for API responses
+func (w WebhookConfig) redacted() WebhookConfig {
+	if w.Secret != "" {
+		w.Secret = core.RedactedSecret
+	}
+	return w
+}
+
 // WebhookDelivery represents a webhook delivery attempt
 type WebhookDelivery struct {
 	ID         string                 `json:"id"`
@@ -200,6 +210,11 @@ func (e *WebhooksExtension) ConfigSchema() json.RawMessage {
 				"description": "Request timeout in seconds",
 				"default":     10,
 			},
+			"signingSecret": map[string]interface{}{
+				"type":        "string",
+				"description": "Secret that signs deliveries of webhooks without their own secret",
+				"secret":      true,
+			},
 		},
 	}
 	
@@ -228,6 +243,10 @@ func (e *WebhooksExtension) ApplyConfig(config json.RawMessage) error {
 		e.client.Timeout = time.Duration(v) * time.Second
 	}
 	
+	if v, ok := cfg["signingSecret"].(string); ok {
+		e.signingSecret = v
+	}
+	
 	return nil
 }
 
@@ -395,9 +414,14 @@ func (e *WebhooksExtension) Documentation() core.ExtensionDocumentation {
 }
 
 func (e *WebhooksExtension) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks := make([]WebhookConfig, len(e.hooks))
+	for i, hook := range e.hooks {
+		hooks[i] = hook.redacted()
+	}
+	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"webhooks": e.hooks,
+		"webhooks": hooks,
 		"total":    len(e.hooks),
 	})
 }
@@ -416,7 +440,7 @@ func (e *WebhooksExtension) handleCreateWebhook(w http.ResponseWriter, r *http.R
 	e.hooks = append(e.hooks, webhook)
 	
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(webhook)
+	json.NewEncoder(w).Encode(webhook.redacted())
 }
 
 func (e *WebhooksExtension) handleGetWebhook(w http.ResponseWriter, r *http.Request) {
@@ -507,8 +531,12 @@ func (e *WebhooksExtension) DeliverWebhook(webhook WebhookConfig, event string,
 	}
 	
 	// Add signature if secret is configured
-	if webhook.Secret != "" {
-		signature := e.generateSignature(webhook.Secret, body)
+	secret := webhook.Secret
+	if secret == "" {
+		secret = e.signingSecret
+	}
+	if secret != "" {
+		signature := e.generateSignature(secret, body)
 		req.Header.Set("X-Webhook-Signature", signature)
 	}
 	
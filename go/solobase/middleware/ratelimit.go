@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/suppers-ai/solobase/utils"
 )
 
 type visitor struct {
@@ -34,11 +36,8 @@ func RateLimit(requestsPerMinute int) func(http.Handler) http.Handler {
 	
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Get client IP
-			ip := r.RemoteAddr
-			if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-				ip = xff
-			}
+			// Get client IP, trusting X-Forwarded-For only from configured proxies
+			ip := utils.ClientIP(r)
 			
 			mu.Lock()
 			v, exists := visitors[ip]
@@ -0,0 +1,94 @@
+package solobase
+
+import (
+	"gorm.io/gorm"
+)
+
+// Model event types reported in ModelEvent.Type
+const (
+	ModelBeforeCreate = "before_create"
+	ModelAfterCreate  = "after_create"
+	ModelBeforeUpdate = "before_update"
+	ModelAfterUpdate  = "after_update"
+	ModelBeforeDelete = "before_delete"
+	ModelAfterDelete  = "after_delete"
+)
+
+// commitCallback is the GORM callback that ends the default transaction.
+// After-hooks are ordered before it explicitly, since GORM would otherwise
+// sort them after the commit.
+const commitCallback = "gorm:commit_or_rollback_transaction"
+
+// registerModelHooks installs GORM callbacks that run the OnModel hooks.
+// The callbacks sit inside GORM's default transaction, so an error returned
+// by a hook rolls back the write that triggered it.
+func (app *App) registerModelHooks(db *gorm.DB) {
+	db.Callback().Create().Before("gorm:create").Register("solobase:before_create", app.modelCallback(ModelBeforeCreate))
+	db.Callback().Create().After("gorm:create").Before(commitCallback).Register("solobase:after_create", app.modelCallback(ModelAfterCreate))
+	db.Callback().Update().Before("gorm:update").Register("solobase:before_update", app.modelCallback(ModelBeforeUpdate))
+	db.Callback().Update().After("gorm:update").Before(commitCallback).Register("solobase:after_update", app.modelCallback(ModelAfterUpdate))
+	db.Callback().Delete().Before("gorm:delete").Register("solobase:before_delete", app.modelCallback(ModelBeforeDelete))
+	db.Callback().Delete().After("gorm:delete").Before(commitCallback).Register("solobase:after_delete", app.modelCallback(ModelAfterDelete))
+}
+
+// modelCallback returns a GORM callback that fires the hooks bound to the
+// statement's model for the given event type
+func (app *App) modelCallback(eventType string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		if tx.Error != nil || tx.Statement.Schema == nil {
+			return
+		}
+
+		hooks := app.modelHooksFor(tx.Statement.Schema.Name, tx.Statement.Schema.Table)
+		if len(hooks) == 0 {
+			return
+		}
+
+		model := tx.Statement.Model
+		if model == nil {
+			model = tx.Statement.Dest
+		}
+
+		event := &ModelEvent{
+			App:   app,
+			Type:  eventType,
+			Model: model,
+			DB:    tx,
+		}
+		if err := runModelHooks(hooks, event); err != nil {
+			tx.AddError(err)
+		}
+	}
+}
+
+// modelHooksFor returns the hooks bound to a model by Go type name or table name
+func (app *App) modelHooksFor(typeName, tableName string) []func(*ModelEvent) error {
+	hooks := app.onModelHooks[typeName]
+	if tableName != typeName {
+		hooks = append(hooks[:len(hooks):len(hooks)], app.onModelHooks[tableName]...)
+	}
+	return hooks
+}
+
+// runModelHooks runs hooks in order. Each hook may call Next to run the
+// remaining hooks and continue afterwards; if it returns without calling
+// Next the remaining hooks still run.
+func runModelHooks(hooks []func(*ModelEvent) error, event *ModelEvent) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	called := false
+	e := *event
+	e.Next = func() error {
+		called = true
+		return runModelHooks(hooks[1:], event)
+	}
+	if err := hooks[0](&e); err != nil {
+		return err
+	}
+	if !called {
+		return runModelHooks(hooks[1:], event)
+	}
+	return nil
+}
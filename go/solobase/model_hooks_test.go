@@ -0,0 +1,92 @@
+package solobase
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suppers-ai/solobase/database"
+)
+
+type hookNote struct {
+	ID    uint `gorm:"primaryKey"`
+	Title string
+}
+
+func (hookNote) TableName() string {
+	return "hook_notes"
+}
+
+func newModelHookTestApp(t *testing.T) (*App, *database.DB) {
+	db, err := database.New(database.Config{Type: "sqlite", Database: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&hookNote{}))
+
+	app := &App{onModelHooks: make(map[string][]func(*ModelEvent) error)}
+	app.registerModelHooks(db.DB)
+	return app, db
+}
+
+func TestModelHookErrorAbortsWrite(t *testing.T) {
+	app, db := newModelHookTestApp(t)
+	errRejected := errors.New("rejected")
+	app.OnModel("hookNote").BindFunc(func(e *ModelEvent) error {
+		if e.Type == ModelBeforeCreate && e.Model.(*hookNote).Title == "bad" {
+			return errRejected
+		}
+		return nil
+	})
+
+	assert.ErrorIs(t, db.Create(&hookNote{Title: "bad"}).Error, errRejected)
+	note := &hookNote{Title: "good"}
+	require.NoError(t, db.Create(note).Error)
+
+	var count int64
+	require.NoError(t, db.Model(&hookNote{}).Count(&count).Error)
+	assert.EqualValues(t, 1, count)
+
+	// After-hooks run inside the same transaction, so failing one rolls back
+	// the write that has already reached the database
+	app.OnModel("hook_notes").BindFunc(func(e *ModelEvent) error {
+		switch e.Type {
+		case ModelAfterUpdate, ModelAfterDelete:
+			return errRejected
+		}
+		return nil
+	})
+
+	err := db.Model(note).Update("title", "changed").Error
+	assert.ErrorIs(t, err, errRejected)
+	var stored hookNote
+	require.NoError(t, db.First(&stored, note.ID).Error)
+	assert.Equal(t, "good", stored.Title)
+
+	assert.ErrorIs(t, db.Delete(note).Error, errRejected)
+	require.NoError(t, db.Model(&hookNote{}).Count(&count).Error)
+	assert.EqualValues(t, 1, count)
+}
+
+func TestModelHooksRunInOrder(t *testing.T) {
+	app, db := newModelHookTestApp(t)
+	var calls []string
+	app.OnModel("hookNote").BindFunc(func(e *ModelEvent) error {
+		calls = append(calls, "first:"+e.Type)
+		if e.Type != ModelBeforeCreate {
+			return nil
+		}
+		err := e.Next()
+		calls = append(calls, "first:done")
+		return err
+	}).BindFunc(func(e *ModelEvent) error {
+		calls = append(calls, "second:"+e.Type)
+		return nil
+	})
+
+	require.NoError(t, db.Create(&hookNote{Title: "note"}).Error)
+	assert.Equal(t, []string{
+		"first:" + ModelBeforeCreate, "second:" + ModelBeforeCreate, "first:done",
+		"first:" + ModelAfterCreate, "second:" + ModelAfterCreate,
+	}, calls)
+}
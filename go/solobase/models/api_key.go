@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKey is a personal access token that authenticates scripts and servers
+// as its owner, limited to the listed scopes
+type APIKey struct {
+	ID         string     `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID     string     `gorm:"type:uuid;index;not null" json:"user_id"`
+	Name       string     `gorm:"not null" json:"name"`
+	Prefix     string     `gorm:"not null" json:"prefix"`        // Leading characters of the key, shown to tell keys apart
+	KeyHash    string     `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the key, to look it up by
+	Scopes     []string   `gorm:"type:text;serializer:json" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // nil for keys that never expire
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TableName sets the table name
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// IsExpired checks if the key has expired
+func (k *APIKey) IsExpired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
@@ -1,12 +1,32 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/suppers-ai/dynamicfields"
 	"gorm.io/gorm"
 )
 
+// Collection access rule operations, used as the keys of Collection.AuthRules
+const (
+	CollectionRuleList   = "list"
+	CollectionRuleView   = "view"
+	CollectionRuleCreate = "create"
+	CollectionRuleUpdate = "update"
+	CollectionRuleDelete = "delete"
+)
+
+// CollectionRuleOperations lists every operation an access rule can be set for
+var CollectionRuleOperations = []string{
+	CollectionRuleList,
+	CollectionRuleView,
+	CollectionRuleCreate,
+	CollectionRuleUpdate,
+	CollectionRuleDelete,
+}
+
 // Collection represents a dynamic collection/table
 type Collection struct {
 	ID          uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
@@ -16,8 +36,10 @@ type Collection struct {
 	Schema      JSON      `gorm:"type:text;not null" json:"schema"`
 	Indexes     JSON      `gorm:"type:text" json:"indexes,omitempty"`
 	AuthRules   JSON      `gorm:"type:text" json:"auth_rules,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	// OrganizationID is set for collections whose records only the organization's members can access
+	OrganizationID *string   `gorm:"type:char(36);index" json:"organization_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 
 	// Relationships
 	Records []CollectionRecord `gorm:"foreignKey:CollectionID;constraint:OnDelete:CASCADE" json:"-"`
@@ -46,6 +68,9 @@ type CollectionRecord struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 
+	// Expand holds related records loaded on request; it is never persisted
+	Expand map[string]interface{} `gorm:"-" json:"expand,omitempty"`
+
 	// Relationships
 	Collection Collection `gorm:"foreignKey:CollectionID" json:"-"`
 }
@@ -61,4 +86,37 @@ func (r *CollectionRecord) BeforeCreate(tx *gorm.DB) error {
 		r.ID = uuid.New()
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// ParsedSchema decodes the collection's stored schema into a dynamicfields schema
+func (c *Collection) ParsedSchema() (*dynamicfields.Schema, error) {
+	var schema dynamicfields.Schema
+	if err := c.Schema.Unmarshal(&schema); err != nil {
+		return nil, err
+	}
+	if schema.Name == "" {
+		schema.Name = c.Name
+	}
+	return &schema, nil
+}
+
+// SetSchema encodes a dynamicfields schema into the collection's Schema column
+func (c *Collection) SetSchema(schema *dynamicfields.Schema) error {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	var encoded JSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	c.Schema = encoded
+	return nil
+}
+
+// Rule returns the access rule configured for an operation, or an empty
+// string when the operation has no rule
+func (c *Collection) Rule(operation string) string {
+	rule, _ := c.AuthRules[operation].(string)
+	return rule
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// LoginAttempt counts the failed logins to one account from one client
+// address, so a single client can be locked out before the whole account is
+type LoginAttempt struct {
+	ID           string     `gorm:"primaryKey;type:uuid" json:"id"`
+	Email        string     `gorm:"not null;uniqueIndex:idx_login_attempt_client" json:"email"` // Lower case, may not belong to an account
+	IPAddress    string     `gorm:"size:64;not null;uniqueIndex:idx_login_attempt_client" json:"ip_address"`
+	FailedCount  int        `gorm:"default:0" json:"failed_count"`
+	LastFailedAt time.Time  `json:"last_failed_at"`
+	LockedUntil  *time.Time `gorm:"index" json:"locked_until,omitempty"`
+}
+
+// TableName sets the table name
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
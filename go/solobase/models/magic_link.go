@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// MagicLinkSignup is a login link sent to an address without an account. The
+// account is only created once the link is opened.
+type MagicLinkSignup struct {
+	Token     string    `gorm:"primaryKey;size:64"` // SHA-256 of the link's token, hex encoded
+	Email     string    `gorm:"size:255;index;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
+
+// TableName sets the table name
+func (MagicLinkSignup) TableName() string {
+	return "magic_link_signups"
+}
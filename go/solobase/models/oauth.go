@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+// OAuthProvider is an external login provider configured by an admin. Presets
+// fill in the endpoints of well known providers; other providers are found
+// through OpenID Connect discovery on IssuerURL.
+type OAuthProvider struct {
+	Name         string    `gorm:"primaryKey;size:50" json:"name"` // Used in URLs, e.g. /api/auth/oauth/google/authorize
+	DisplayName  string    `json:"display_name"`
+	Preset       string    `gorm:"size:50" json:"preset,omitempty"` // google, github, microsoft, or empty for generic OIDC
+	IssuerURL    string    `json:"issuer_url,omitempty"`
+	ClientID     string    `gorm:"not null" json:"client_id"`
+	ClientSecret string    `json:"-"` // Never expose in JSON
+	Scopes       []string  `gorm:"type:text;serializer:json" json:"scopes"`
+	Enabled      bool      `json:"enabled"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// TableName sets the table name
+func (OAuthProvider) TableName() string {
+	return "oauth_providers"
+}
+
+// OAuthIdentity links a user to their account at an external provider
+type OAuthIdentity struct {
+	ID          string     `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID      string     `gorm:"type:uuid;index;not null" json:"user_id"`
+	Provider    string     `gorm:"size:50;not null;uniqueIndex:idx_oauth_identity_subject" json:"provider"`
+	Subject     string     `gorm:"not null;uniqueIndex:idx_oauth_identity_subject" json:"subject"` // The provider's stable user ID
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TableName sets the table name
+func (OAuthIdentity) TableName() string {
+	return "oauth_identities"
+}
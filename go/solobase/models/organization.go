@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+)
+
+// Organization is a shared workspace. Storage buckets, collections and
+// product groups can belong to an organization instead of a single user.
+type Organization struct {
+	ID        string    `gorm:"primaryKey;type:uuid" json:"id"`
+	Name      string    `gorm:"not null" json:"name"`
+	Slug      string    `gorm:"size:64;uniqueIndex;not null" json:"slug"` // URL friendly name, unique across organizations
+	CreatedBy string    `gorm:"type:uuid" json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName sets the table name
+func (Organization) TableName() string {
+	return "organizations"
+}
+
+// OrganizationMember gives a user a role in an organization
+type OrganizationMember struct {
+	ID             string    `gorm:"primaryKey;type:uuid" json:"id"`
+	OrganizationID string    `gorm:"type:uuid;not null;uniqueIndex:idx_organization_member" json:"organization_id"`
+	UserID         string    `gorm:"type:uuid;not null;uniqueIndex:idx_organization_member;index" json:"user_id"`
+	Role           string    `gorm:"size:32;not null" json:"role"` // owner, admin, member or viewer
+	Email          string    `gorm:"-" json:"email,omitempty"`     // Filled in when listing members
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// TableName sets the table name
+func (OrganizationMember) TableName() string {
+	return "organization_members"
+}
+
+// OrganizationInvite is an emailed invitation to join an organization. Only
+// the hash of the invitation token is stored.
+type OrganizationInvite struct {
+	ID             string     `gorm:"primaryKey;type:uuid" json:"id"`
+	OrganizationID string     `gorm:"type:uuid;not null;index" json:"organization_id"`
+	Email          string     `gorm:"not null;index" json:"email"`
+	Role           string     `gorm:"size:32;not null" json:"role"` // Role given to the user on acceptance
+	TokenHash      string     `gorm:"uniqueIndex;not null" json:"-"`
+	InvitedBy      string     `gorm:"type:uuid" json:"invited_by"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// TableName sets the table name
+func (OrganizationInvite) TableName() string {
+	return "organization_invites"
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+)
+
+// Role is a named set of permissions. Every user has the role in their Role
+// column and may be bound to further roles.
+type Role struct {
+	Name        string    `gorm:"primaryKey;size:64" json:"name"`
+	DisplayName string    `json:"display_name"`
+	Description string    `json:"description"`
+	Builtin     bool      `gorm:"default:false" json:"builtin"` // Built-in roles cannot be deleted
+	Permissions []string  `gorm:"-" json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TableName sets the table name
+func (Role) TableName() string {
+	return "roles"
+}
+
+// Permission is an action that can be granted to roles, such as storage.bucket.delete
+type Permission struct {
+	Name        string `gorm:"primaryKey;size:128" json:"name"`
+	Description string `json:"description"`
+	Extension   string `gorm:"size:64;index" json:"extension,omitempty"` // Empty for core permissions
+}
+
+// TableName sets the table name
+func (Permission) TableName() string {
+	return "permissions"
+}
+
+// RolePermission grants a permission to a role. A permission ending in *
+// grants every permission starting with what comes before it.
+type RolePermission struct {
+	Role       string `gorm:"primaryKey;size:64" json:"role"`
+	Permission string `gorm:"primaryKey;size:128" json:"permission"`
+}
+
+// TableName sets the table name
+func (RolePermission) TableName() string {
+	return "role_permissions"
+}
+
+// RoleBinding gives a user a role in addition to the one in their Role column
+type RoleBinding struct {
+	ID        string    `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID    string    `gorm:"type:uuid;not null;uniqueIndex:idx_role_binding_user_role" json:"user_id"`
+	Role      string    `gorm:"size:64;not null;uniqueIndex:idx_role_binding_user_role;index" json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName sets the table name
+func (RoleBinding) TableName() string {
+	return "role_bindings"
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// S3Credential is an access key ID and secret access key for the S3 API,
+// limited to storage scopes. S3 signatures can only be checked with the
+// secret itself, so unlike API keys it is kept encrypted rather than hashed.
+type S3Credential struct {
+	ID         string     `gorm:"primaryKey;size:32" json:"access_key_id"`
+	UserID     string     `gorm:"type:uuid;index;not null" json:"user_id"`
+	Name       string     `gorm:"not null" json:"name"`
+	Scopes     []string   `gorm:"type:text;serializer:json" json:"scopes"`
+	Secret     string     `gorm:"type:text;not null" json:"-"` // The secret access key encrypted with the master key
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`        // nil for credentials that never expire
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TableName sets the table name
+func (S3Credential) TableName() string {
+	return "s3_credentials"
+}
+
+// IsExpired checks if the credential has expired
+func (c *S3Credential) IsExpired() bool {
+	return c.ExpiresAt != nil && time.Now().After(*c.ExpiresAt)
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -34,20 +35,27 @@ type AppSettings struct {
 	AppURL                   string `json:"app_url"`
 	AllowSignup              bool   `json:"allow_signup"`
 	RequireEmailConfirmation bool   `json:"require_email_confirmation"`
+	EnableMagicLink          bool   `json:"enable_magic_link"` // Passwordless login with emailed links
 	SMTPEnabled              bool   `json:"smtp_enabled"`
 	SMTPHost                 string `json:"smtp_host,omitempty"`
 	SMTPPort                 int    `json:"smtp_port,omitempty"`
 	SMTPUser                 string `json:"smtp_user,omitempty"`
-	SMTPPassword             string `json:"-"` // Never expose password in JSON
+	SMTPPassword             string `json:"-"` // Secret, read with SettingsService.GetSecret
+	SMTPFrom                 string `json:"smtp_from,omitempty"` // Sender address, defaults to the SMTP user
 	StorageProvider          string `json:"storage_provider"`
 	S3Bucket                 string `json:"s3_bucket,omitempty"`
 	S3Region                 string `json:"s3_region,omitempty"`
-	S3AccessKey              string `json:"-"` // Never expose in JSON
-	S3SecretKey              string `json:"-"` // Never expose in JSON
+	S3AccessKey              string `json:"-"` // Secret, read with SettingsService.GetSecret
+	S3SecretKey              string `json:"-"` // Secret, read with SettingsService.GetSecret
 	MaxUploadSize            int64  `json:"max_upload_size"`
 	AllowedFileTypes         string `json:"allowed_file_types"`
+	TrashRetentionDays       int    `json:"trash_retention_days"` // Days deleted files stay in the trash before they are purged, 0 keeps them until the trash is emptied
 	SessionTimeout           int    `json:"session_timeout"` // in minutes
 	PasswordMinLength        int    `json:"password_min_length"`
+	TwoFactorRequiredRoles   string `json:"two_factor_required_roles"` // Comma separated roles that must use 2FA, e.g. "admin,manager"
+	LockoutThreshold         int    `json:"lockout_threshold"`    // Failed logins before an account is locked, 0 disables
+	LockoutIPThreshold       int    `json:"lockout_ip_threshold"` // Failed logins to one account from one address before that address is locked out, 0 disables
+	LockoutDuration          int    `json:"lockout_duration"`     // First lockout in minutes, doubled for each further failure
 	EnableAPILogs            bool   `json:"enable_api_logs"`
 	EnableDebugMode          bool   `json:"enable_debug_mode"`
 	MaintenanceMode          bool   `json:"maintenance_mode"`
@@ -62,15 +70,29 @@ func DefaultSettings() *AppSettings {
 		AppURL:                   "http://localhost:8080",
 		AllowSignup:              true,
 		RequireEmailConfirmation: false,
+		EnableMagicLink:          false,
 		SMTPEnabled:              false,
 		SMTPPort:                 587,
 		StorageProvider:          "local",
 		MaxUploadSize:            10 * 1024 * 1024, // 10MB
 		AllowedFileTypes:         "image/*,application/pdf,text/*",
+		TrashRetentionDays:       30,
 		SessionTimeout:           1440, // 24 hours
 		PasswordMinLength:        8,
+		LockoutThreshold:         10,
+		LockoutIPThreshold:       5,
+		LockoutDuration:          15,
 		EnableAPILogs:            true,
 		EnableDebugMode:          false,
 		MaintenanceMode:          false,
 	}
-}
\ No newline at end of file
+}
+// RequiresTwoFactor reports whether users with the role must use two-factor authentication
+func (s *AppSettings) RequiresTwoFactor(role string) bool {
+	for _, required := range strings.Split(s.TwoFactorRequiredRoles, ",") {
+		if role != "" && strings.TrimSpace(required) == role {
+			return true
+		}
+	}
+	return false
+}
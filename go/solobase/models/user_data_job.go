@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+// User data job kinds
+const (
+	UserDataJobExport = "export" // Collects the user's data into a ZIP archive
+	UserDataJobErase  = "erase"  // Deletes or anonymizes the user's data
+)
+
+// User data job statuses
+const (
+	UserDataJobPending   = "pending"
+	UserDataJobRunning   = "running"
+	UserDataJobCompleted = "completed"
+	UserDataJobFailed    = "failed"
+)
+
+// UserDataJob is a data export or erasure requested for a user, answering a
+// subject access or deletion request. Jobs run in the background.
+type UserDataJob struct {
+	ID          string     `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID      string     `gorm:"type:uuid;index;not null" json:"user_id"` // Kept after erasure, as the record that it happened
+	Kind        string     `gorm:"size:20;not null" json:"kind"`
+	Status      string     `gorm:"size:20;not null;index" json:"status"`
+	RequestedBy string     `gorm:"type:uuid" json:"requested_by"`
+	Archive     string     `gorm:"size:255" json:"-"` // File name of the exported archive in the export directory
+	Size        int64      `json:"size,omitempty"`
+	ExpiresAt   *time.Time `gorm:"index" json:"expires_at,omitempty"` // When the exported archive is deleted
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// TableName sets the table name
+func (UserDataJob) TableName() string {
+	return "user_data_jobs"
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// WebAuthnCredential is a passkey registered by a user. It can replace the
+// password or serve as a second factor.
+type WebAuthnCredential struct {
+	ID             string     `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID         string     `gorm:"type:uuid;index;not null" json:"user_id"`
+	CredentialID   string     `gorm:"uniqueIndex;not null;size:1400" json:"credential_id"` // Base64url, as sent by the browser
+	PublicKey      []byte     `gorm:"not null" json:"-"`                                   // COSE encoded
+	Algorithm      int        `json:"algorithm"`
+	SignCount      uint32     `json:"sign_count"`
+	AAGUID         string     `gorm:"size:36" json:"aaguid,omitempty"` // Identifies the authenticator model
+	Transports     []string   `gorm:"type:text;serializer:json" json:"transports,omitempty"`
+	BackupEligible bool       `json:"backup_eligible"` // Synced passkeys, e.g. in a password manager
+	BackedUp       bool       `json:"backed_up"`
+	Name           string     `gorm:"size:100" json:"name"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// TableName sets the table name
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
+
+// WebAuthnChallenge is an unanswered registration or login ceremony. Each
+// challenge can be answered once.
+type WebAuthnChallenge struct {
+	Challenge        string    `gorm:"primaryKey;size:64"` // SHA-256 of the challenge, hex encoded
+	UserID           *string   `gorm:"type:uuid;index"`    // Not set for passwordless logins
+	Purpose          string    `gorm:"size:20;not null"`
+	UserVerification string    `gorm:"size:20"`
+	ExpiresAt        time.Time `gorm:"index;not null"`
+	CreatedAt        time.Time
+}
+
+// TableName sets the table name
+func (WebAuthnChallenge) TableName() string {
+	return "webauthn_challenges"
+}
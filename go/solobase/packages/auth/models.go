@@ -56,6 +56,7 @@ type User struct {
 	TOTPSecretBackup *string `gorm:"size:255" json:"-"`
 	SMSPhoneNumber   *string `gorm:"size:50" json:"-"`
 	RecoveryCodes    *string `gorm:"type:text" json:"-"`
+	TOTPLastStep     *int64  `json:"-"` // Time step of the last accepted TOTP code, so it cannot be replayed
 
 	// Relationships
 	Sessions []Session `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
@@ -26,6 +26,11 @@ func (e *LiteralExpression) Type() ExpressionType {
 	return e.exprType
 }
 
+// Value returns the literal value
+func (e *LiteralExpression) Value() interface{} {
+	return e.value
+}
+
 // VariableExpression represents a variable reference
 type VariableExpression struct {
 	name string
@@ -43,6 +48,11 @@ func (e *VariableExpression) Type() ExpressionType {
 	return TypeUnknown
 }
 
+// Name returns the variable name
+func (e *VariableExpression) Name() string {
+	return e.name
+}
+
 // BinaryExpression represents a binary operation
 type BinaryExpression struct {
 	left     Expression
@@ -206,6 +216,21 @@ func (e *BinaryExpression) Evaluate(ctx context.Context, resolver VariableResolv
 	}
 }
 
+// Left returns the left operand
+func (e *BinaryExpression) Left() Expression {
+	return e.left
+}
+
+// Operator returns the binary operator
+func (e *BinaryExpression) Operator() string {
+	return e.operator
+}
+
+// Right returns the right operand
+func (e *BinaryExpression) Right() Expression {
+	return e.right
+}
+
 func (e *BinaryExpression) String() string {
 	return fmt.Sprintf("(%s %s %s)", e.left.String(), e.operator, e.right.String())
 }
@@ -412,6 +437,16 @@ func (e *FunctionExpression) Evaluate(ctx context.Context, resolver VariableReso
 	}
 }
 
+// Name returns the function name
+func (e *FunctionExpression) Name() string {
+	return e.name
+}
+
+// Args returns the function arguments
+func (e *FunctionExpression) Args() []Expression {
+	return e.args
+}
+
 func (e *FunctionExpression) String() string {
 	args := make([]string, len(e.args))
 	for i, arg := range e.args {
@@ -433,6 +468,10 @@ func (e *FunctionExpression) Type() ExpressionType {
 
 // Helper function to compare equality
 func compareEqual(left, right interface{}) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	
 	// Try numeric comparison first
 	leftNum, leftErr := toFloat64(left)
 	rightNum, rightErr := toFloat64(right)
@@ -60,6 +60,7 @@ const (
 	tokenComma
 	tokenFunction
 	tokenBoolean
+	tokenNull
 )
 
 type token struct {
@@ -148,10 +149,14 @@ func tokenize(input string) []token {
 			continue
 		}
 		
-		// Variables, functions, and keywords
-		if unicode.IsLetter(rune(input[i])) || input[i] == '_' {
+		// Variables, functions, and keywords. A leading '@' marks a
+		// context variable such as "@request.auth.id"
+		if unicode.IsLetter(rune(input[i])) || input[i] == '_' || isContextPrefix(input, i) {
 			start := i
-			for i < len(input) && (unicode.IsLetter(rune(input[i])) || unicode.IsDigit(rune(input[i])) || input[i] == '_') {
+			if input[i] == '@' {
+				i++
+			}
+			for i < len(input) && (isIdentChar(input[i]) || isPathSeparator(input, i)) {
 				i++
 			}
 			
@@ -163,6 +168,12 @@ func tokenize(input string) []token {
 				continue
 			}
 			
+			// Check for null literal
+			if word == "null" {
+				tokens = append(tokens, token{typ: tokenNull, value: word})
+				continue
+			}
+			
 			// Check if it's a function (followed by parenthesis)
 			j := i
 			for j < len(input) && unicode.IsSpace(rune(input[j])) {
@@ -183,6 +194,22 @@ func tokenize(input string) []token {
 	return tokens
 }
 
+// isIdentChar reports whether c may appear inside a variable name
+func isIdentChar(c byte) bool {
+	return unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) || c == '_'
+}
+
+// isContextPrefix reports whether the '@' at position i starts a context variable name
+func isContextPrefix(input string, i int) bool {
+	return input[i] == '@' && i+1 < len(input) && (unicode.IsLetter(rune(input[i+1])) || input[i+1] == '_')
+}
+
+// isPathSeparator reports whether the '.' at position i joins two parts of a
+// dotted variable name such as "meta.color"
+func isPathSeparator(input string, i int) bool {
+	return input[i] == '.' && i+1 < len(input) && (unicode.IsLetter(rune(input[i+1])) || input[i+1] == '_')
+}
+
 // Token parser
 type tokenParser struct {
 	tokens []token
@@ -281,8 +308,12 @@ func (p *tokenParser) parseEquality() (Expression, error) {
 		return nil, err
 	}
 	
-	for p.current().typ == tokenOperator && (p.current().value == "==" || p.current().value == "!=") {
+	for p.current().typ == tokenOperator && (p.current().value == "==" || p.current().value == "!=" || p.current().value == "=") {
 		op := p.current().value
+		if op == "=" {
+			// A single '=' is accepted as equality for filter-style conditions
+			op = "=="
+		}
 		p.advance()
 		right, err := p.parseComparison()
 		if err != nil {
@@ -393,6 +424,12 @@ func (p *tokenParser) parsePrimary() (Expression, error) {
 		return &LiteralExpression{value: val, exprType: TypeBoolean}, nil
 	}
 	
+	// Null literal
+	if p.current().typ == tokenNull {
+		p.advance()
+		return &LiteralExpression{value: nil, exprType: TypeUnknown}, nil
+	}
+	
 	// Function call
 	if p.current().typ == tokenFunction {
 		name := p.current().value
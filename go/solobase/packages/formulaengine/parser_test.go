@@ -0,0 +1,71 @@
+package formulaengine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParser_FilterSyntax(t *testing.T) {
+	parser := NewFormulaParser()
+
+	expr, err := parser.ParseCondition(`price > 10 && status = "open"`)
+	require.NoError(t, err)
+
+	and, ok := expr.(*BinaryExpression)
+	require.True(t, ok)
+	assert.Equal(t, "&&", and.Operator())
+
+	eq, ok := and.Right().(*BinaryExpression)
+	require.True(t, ok)
+	assert.Equal(t, "==", eq.Operator())
+	assert.Equal(t, "status", eq.Left().(*VariableExpression).Name())
+	assert.Equal(t, "open", eq.Right().(*LiteralExpression).Value())
+}
+
+func TestParser_DottedVariables(t *testing.T) {
+	parser := NewFormulaParser()
+
+	expr, err := parser.ParseCondition("meta.color == 'red' && .5 < 1")
+	require.NoError(t, err)
+
+	and := expr.(*BinaryExpression)
+	left := and.Left().(*BinaryExpression)
+	assert.Equal(t, "meta.color", left.Left().(*VariableExpression).Name())
+	right := and.Right().(*BinaryExpression)
+	assert.Equal(t, 0.5, right.Left().(*LiteralExpression).Value())
+}
+
+func TestParser_NullLiteral(t *testing.T) {
+	evaluator := NewConditionEvaluator()
+	resolver := NewSimpleResolver(map[string]interface{}{"name": "x", "empty": nil})
+
+	result, err := evaluator.Evaluate(context.Background(), "name != null", resolver)
+	require.NoError(t, err)
+	assert.True(t, result)
+
+	result, err = evaluator.Evaluate(context.Background(), "empty = null", resolver)
+	require.NoError(t, err)
+	assert.True(t, result)
+}
+
+func TestParser_ContextVariables(t *testing.T) {
+	parser := NewFormulaParser()
+
+	expr, err := parser.ParseCondition("user_id = @request.auth.id || @request.auth.role == 'admin'")
+	require.NoError(t, err)
+
+	or := expr.(*BinaryExpression)
+	owner := or.Left().(*BinaryExpression)
+	assert.Equal(t, "@request.auth.id", owner.Right().(*VariableExpression).Name())
+	role := or.Right().(*BinaryExpression)
+	assert.Equal(t, "@request.auth.role", role.Left().(*VariableExpression).Name())
+
+	evaluator := NewConditionEvaluator()
+	resolver := NewSimpleResolver(map[string]interface{}{"user_id": "u1", "@request.auth.id": "u1", "@request.auth.role": "user"})
+	result, err := evaluator.Evaluate(context.Background(), "user_id = @request.auth.id", resolver)
+	require.NoError(t, err)
+	assert.True(t, result)
+}
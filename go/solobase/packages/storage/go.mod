@@ -10,6 +10,8 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/suppers-ai/logger v0.0.0
 	gorm.io/datatypes v1.2.0
+	gorm.io/driver/sqlite v1.5.6
+	gorm.io/driver/sqlite v1.5.6
 	gorm.io/gorm v1.25.12
 )
 
@@ -48,5 +50,4 @@ require (
 	golang.org/x/text v0.14.0 // indirect
 	gorm.io/driver/mysql v1.4.7 // indirect
 	gorm.io/driver/postgres v1.5.9 // indirect
-	gorm.io/driver/sqlite v1.5.6 // indirect
 )
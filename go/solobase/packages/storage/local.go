@@ -4,16 +4,23 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
-// LocalProvider implements storage using the local filesystem
+// LocalProvider implements storage using the local filesystem. In buckets
+// with versioning enabled, overwritten and deleted objects are moved to
+// .versions/<bucket>/<key>/<versionID> under the base path. Resumable uploads
+// are appended to .uploads/<bucket>/<uploadID> until they are completed.
 type LocalProvider struct {
 	basePath string
 	baseURL  string
@@ -66,6 +73,7 @@ func (l *LocalProvider) CreateBucket(ctx context.Context, name string, opts Crea
 	metadata := map[string]interface{}{
 		"created_at": time.Now(),
 		"public":     opts.Public,
+		"versioning": opts.Versioning,
 	}
 	
 	if err := l.writeBucketMetadata(name, metadata); err != nil {
@@ -91,9 +99,11 @@ func (l *LocalProvider) DeleteBucket(ctx context.Context, name string) error {
 		return fmt.Errorf("failed to delete bucket: %w", err)
 	}
 	
-	// Remove metadata
+	// Remove metadata and prior versions
 	metadataPath := filepath.Join(l.basePath, ".metadata", name+".json")
 	os.Remove(metadataPath)
+	os.RemoveAll(filepath.Join(l.basePath, ".versions", name))
+	os.RemoveAll(filepath.Join(l.basePath, ".uploads", name))
 	
 	return nil
 }
@@ -151,6 +161,38 @@ func (l *LocalProvider) ListBuckets(ctx context.Context) ([]BucketInfo, error) {
 
 // PutObject stores an object in a bucket
 func (l *LocalProvider) PutObject(ctx context.Context, bucket, key string, reader io.Reader, size int64, opts PutObjectOptions) error {
+	return l.replaceObject(ctx, bucket, key, opts, func(objectPath string) error {
+		// Write to a hidden file next to the object and move it into place,
+		// so content that fails part way never replaces the object
+		file, err := os.CreateTemp(filepath.Dir(objectPath), ".upload-*")
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+		defer os.Remove(file.Name())
+		
+		// Copy the content
+		written, err := io.Copy(file, reader)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+		
+		// Verify size if provided
+		if size > 0 && written != size {
+			return fmt.Errorf("size mismatch: expected %d, got %d", size, written)
+		}
+		if err := os.Chmod(file.Name(), 0644); err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+		return os.Rename(file.Name(), objectPath)
+	})
+}
+
+// replaceObject makes the file written by write the content of an object,
+// keeping the content it replaces as a prior version in a versioned bucket
+func (l *LocalProvider) replaceObject(ctx context.Context, bucket, key string, opts PutObjectOptions, write func(objectPath string) error) error {
 	// Validate bucket exists
 	if exists, err := l.BucketExists(ctx, bucket); err != nil {
 		return err
@@ -167,24 +209,25 @@ func (l *LocalProvider) PutObject(ctx context.Context, bucket, key string, reade
 		return fmt.Errorf("failed to create directories: %w", err)
 	}
 	
-	// Create the file
-	file, err := os.Create(objectPath)
+	// Keep the content being replaced as a prior version
+	versioned := l.versioningEnabled(bucket)
+	restore, err := l.archiveCurrent(bucket, key, versioned)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return err
 	}
-	defer file.Close()
 	
-	// Copy the content
-	written, err := io.Copy(file, reader)
-	if err != nil {
-		os.Remove(objectPath)
-		return fmt.Errorf("failed to write file: %w", err)
+	if err := write(objectPath); err != nil {
+		restore()
+		return err
 	}
 	
-	// Verify size if provided
-	if size > 0 && written != size {
-		os.Remove(objectPath)
-		return fmt.Errorf("size mismatch: expected %d, got %d", size, written)
+	if versioned {
+		if err := l.writeCurrentVersionID(bucket, key, uuid.New().String()); err != nil {
+			return err
+		}
+	} else {
+		// There is only one version written without versioning
+		os.Remove(filepath.Join(l.versionsPath(bucket, key), NullVersionID))
 	}
 	
 	// Store object metadata
@@ -248,18 +291,25 @@ func (l *LocalProvider) GetObjectInfo(ctx context.Context, bucket, key string) (
 		ContentType:  contentType,
 		LastModified: stat.ModTime(),
 		IsDir:        stat.IsDir(),
+		VersionID:    l.currentVersionID(bucket, key),
 	}, nil
 }
 
-// DeleteObject deletes an object from a bucket
+// DeleteObject deletes an object from a bucket. In a versioned bucket the
+// object is kept as a prior version.
 func (l *LocalProvider) DeleteObject(ctx context.Context, bucket, key string) error {
 	key = cleanKey(key)
 	objectPath := filepath.Join(l.basePath, bucket, key)
 	
-	if err := os.Remove(objectPath); err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("object not found")
-		}
+	if _, err := os.Stat(objectPath); os.IsNotExist(err) {
+		return fmt.Errorf("object not found")
+	}
+	if _, err := l.archiveCurrent(bucket, key, l.versioningEnabled(bucket)); err != nil {
+		return err
+	}
+	
+	// Objects without a version are removed for good
+	if err := os.Remove(objectPath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
 	
@@ -376,6 +426,111 @@ func (l *LocalProvider) GeneratePresignedURL(ctx context.Context, bucket, key st
 	return url, nil
 }
 
+// SetBucketVersioning enables or suspends versioning for a bucket. Prior
+// versions kept while versioning was enabled are not removed.
+func (l *LocalProvider) SetBucketVersioning(ctx context.Context, bucket string, enabled bool) error {
+	if exists, err := l.BucketExists(ctx, bucket); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("bucket %s does not exist", bucket)
+	}
+	
+	metadata := l.readBucketMetadata(bucket)
+	metadata["versioning"] = enabled
+	return l.writeBucketMetadata(bucket, metadata)
+}
+
+// ListObjectVersions lists the versions of an object, newest first
+func (l *LocalProvider) ListObjectVersions(ctx context.Context, bucket, key string) ([]ObjectVersionInfo, error) {
+	key = cleanKey(key)
+	var versions []ObjectVersionInfo
+	
+	if stat, err := os.Stat(filepath.Join(l.basePath, bucket, key)); err == nil && !stat.IsDir() {
+		versionID := l.currentVersionID(bucket, key)
+		if versionID == "" {
+			versionID = NullVersionID
+		}
+		versions = append(versions, ObjectVersionInfo{
+			Key:          key,
+			VersionID:    versionID,
+			Size:         stat.Size(),
+			LastModified: stat.ModTime(),
+			IsLatest:     true,
+		})
+	}
+	
+	entries, err := os.ReadDir(l.versionsPath(bucket, key))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		versions = append(versions, ObjectVersionInfo{
+			Key:          key,
+			VersionID:    entry.Name(),
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+	}
+	
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].IsLatest != versions[j].IsLatest {
+			return versions[i].IsLatest
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	
+	return versions, nil
+}
+
+// GetObjectVersion retrieves one version of an object
+func (l *LocalProvider) GetObjectVersion(ctx context.Context, bucket, key, versionID string) (io.ReadCloser, error) {
+	key = cleanKey(key)
+	versionPath, _, err := l.resolveVersion(bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	
+	file, err := os.Open(versionPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
+
+// DeleteObjectVersion permanently deletes one version of an object
+func (l *LocalProvider) DeleteObjectVersion(ctx context.Context, bucket, key, versionID string) error {
+	key = cleanKey(key)
+	versionPath, current, err := l.resolveVersion(bucket, key, versionID)
+	if err != nil {
+		return err
+	}
+	
+	if err := os.Remove(versionPath); err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	if current {
+		os.Remove(filepath.Join(l.versionsPath(bucket, key), ".current"))
+		l.deleteObjectMetadata(bucket, key)
+	}
+	
+	// Drop the directories left empty once the last prior version is gone
+	bucketVersions := filepath.Join(l.basePath, ".versions", bucket)
+	for dir := l.versionsPath(bucket, key); strings.HasPrefix(dir, bucketVersions+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	
+	return nil
+}
+
 // Helper functions
 
 func (l *LocalProvider) getBucketStats(bucket string) (int64, int64) {
@@ -396,14 +551,27 @@ func (l *LocalProvider) getBucketStats(bucket string) (int64, int64) {
 }
 
 func (l *LocalProvider) writeBucketMetadata(bucket string, metadata map[string]interface{}) error {
-	// For now, we'll skip metadata storage for simplicity
-	// In production, you'd want to store this in a .metadata directory
+	metadataDir := filepath.Join(l.basePath, ".metadata")
+	if err := os.MkdirAll(metadataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode bucket metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(metadataDir, bucket+".json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to write bucket metadata: %w", err)
+	}
 	return nil
 }
 
 func (l *LocalProvider) readBucketMetadata(bucket string) map[string]interface{} {
-	// For now, return empty metadata
-	return make(map[string]interface{})
+	metadata := make(map[string]interface{})
+	data, err := os.ReadFile(filepath.Join(l.basePath, ".metadata", bucket+".json"))
+	if err == nil {
+		json.Unmarshal(data, &metadata)
+	}
+	return metadata
 }
 
 func (l *LocalProvider) writeObjectMetadata(bucket, key string, metadata map[string]interface{}) error {
@@ -420,6 +588,94 @@ func (l *LocalProvider) deleteObjectMetadata(bucket, key string) {
 	// For now, this is a no-op
 }
 
+func (l *LocalProvider) versioningEnabled(bucket string) bool {
+	enabled, _ := l.readBucketMetadata(bucket)["versioning"].(bool)
+	return enabled
+}
+
+// versionsPath returns the directory holding the prior versions of an object
+func (l *LocalProvider) versionsPath(bucket, key string) string {
+	return filepath.Join(l.basePath, ".versions", bucket, key)
+}
+
+// currentVersionID returns the version of an object written while versioning
+// was enabled, or an empty string
+func (l *LocalProvider) currentVersionID(bucket, key string) string {
+	data, err := os.ReadFile(filepath.Join(l.versionsPath(bucket, key), ".current"))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (l *LocalProvider) writeCurrentVersionID(bucket, key, versionID string) error {
+	versionsPath := l.versionsPath(bucket, key)
+	if err := os.MkdirAll(versionsPath, 0755); err != nil {
+		return fmt.Errorf("failed to create versions directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(versionsPath, ".current"), []byte(versionID), 0644); err != nil {
+		return fmt.Errorf("failed to write version: %w", err)
+	}
+	return nil
+}
+
+// archiveCurrent moves the current content of an object to its versions
+// directory. Content with a version is always kept, as S3 does once versioning
+// is suspended; content written before versioning was enabled only when
+// versioned is set. The returned function moves the content back.
+func (l *LocalProvider) archiveCurrent(bucket, key string, versioned bool) (func(), error) {
+	objectPath := filepath.Join(l.basePath, bucket, key)
+	if stat, err := os.Stat(objectPath); err != nil || stat.IsDir() {
+		return func() {}, nil
+	}
+	
+	versionID := l.currentVersionID(bucket, key)
+	if versionID == "" {
+		if !versioned {
+			return func() {}, nil
+		}
+		versionID = NullVersionID
+	}
+	
+	versionsPath := l.versionsPath(bucket, key)
+	if err := os.MkdirAll(versionsPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create versions directory: %w", err)
+	}
+	archivePath := filepath.Join(versionsPath, versionID)
+	if err := os.Rename(objectPath, archivePath); err != nil {
+		return nil, fmt.Errorf("failed to keep prior version: %w", err)
+	}
+	os.Remove(filepath.Join(versionsPath, ".current"))
+	
+	return func() {
+		if err := os.Rename(archivePath, objectPath); err == nil && versionID != NullVersionID {
+			l.writeCurrentVersionID(bucket, key, versionID)
+		}
+	}, nil
+}
+
+// resolveVersion returns the file holding a version of an object, and whether
+// it is the current version
+func (l *LocalProvider) resolveVersion(bucket, key, versionID string) (string, bool, error) {
+	if versionID == "" || versionID != filepath.Base(versionID) || strings.HasPrefix(versionID, ".") {
+		return "", false, fmt.Errorf("invalid version ID")
+	}
+	
+	objectPath := filepath.Join(l.basePath, bucket, key)
+	currentID := l.currentVersionID(bucket, key)
+	if versionID == currentID || (currentID == "" && versionID == NullVersionID) {
+		if stat, err := os.Stat(objectPath); err == nil && !stat.IsDir() {
+			return objectPath, true, nil
+		}
+	}
+	
+	archivePath := filepath.Join(l.versionsPath(bucket, key), versionID)
+	if _, err := os.Stat(archivePath); err != nil {
+		return "", false, fmt.Errorf("object version not found")
+	}
+	return archivePath, false, nil
+}
+
 // cleanKey cleans and validates an object key
 func cleanKey(key string) string {
 	// Remove leading slash
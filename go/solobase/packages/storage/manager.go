@@ -1,10 +1,10 @@
 package storage
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -178,8 +178,10 @@ func (m *Manager) listBucketsFromDB(ctx context.Context) ([]BucketInfo, error) {
 	return bucketInfos, nil
 }
 
-// UploadObject uploads an object to storage and tracks it in database
-func (m *Manager) UploadObject(ctx context.Context, bucketName, filename string, parentFolderID *string, content []byte, mimeType string, userID *uuid.UUID, appID *string) (*StorageObject, error) {
+// UploadObject streams an object to storage and tracks it in database. A
+// negative size is for content of unknown length. The size, SHA-256 and ETag
+// are worked out as the content streams, so it is never held in memory.
+func (m *Manager) UploadObject(ctx context.Context, bucketName, filename string, parentFolderID *string, reader io.Reader, size int64, mimeType string, userID *uuid.UUID, appID *string) (*StorageObject, error) {
 	// Get bucket from database
 	bucket, err := m.GetBucket(ctx, bucketName)
 	if err != nil {
@@ -193,8 +195,8 @@ func (m *Manager) UploadObject(ctx context.Context, bucketName, filename string,
 	storageKey := fmt.Sprintf("%s/%s", objectID, filename)
 	
 	// Upload to storage provider
-	reader := bytes.NewReader(content)
-	err = m.provider.PutObject(ctx, bucketName, storageKey, reader, int64(len(content)), PutObjectOptions{
+	hashed := NewHashingReader(reader, size)
+	err = m.provider.PutObject(ctx, bucketName, storageKey, hashed, size, PutObjectOptions{
 		ContentType: mimeType,
 		Public:      bucket.Public,
 	})
@@ -214,8 +216,10 @@ func (m *Manager) UploadObject(ctx context.Context, bucketName, filename string,
 		BucketName:     bucket.Name,
 		ObjectName:     filename,
 		ParentFolderID: parentFolderID,
-		Size:           int64(len(content)),
+		Size:           hashed.Size(),
 		ContentType:    mimeType,
+		Checksum:       hashed.ETag(),
+		SHA256:         hashed.SHA256(),
 		UserID:         userIDStr,
 		AppID:          appID,
 		CreatedAt:      time.Now(),
@@ -255,11 +259,12 @@ func (m *Manager) GetObject(ctx context.Context, objectID string) (*StorageObjec
 	return &object, nil
 }
 
-// DeleteObject deletes an object by its unique ID
+// DeleteObject permanently deletes an object by its unique ID, including an
+// object in the trash
 func (m *Manager) DeleteObject(ctx context.Context, objectID string) error {
 	// Get object from database first
 	var object StorageObject
-	err := m.db.WithContext(ctx).Where("id = ?", objectID).First(&object).Error
+	err := m.db.WithContext(ctx).Unscoped().Where("id = ?", objectID).First(&object).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return fmt.Errorf("object not found")
@@ -270,8 +275,12 @@ func (m *Manager) DeleteObject(ctx context.Context, objectID string) error {
 	// Build storage key for the provider
 	storageKey := fmt.Sprintf("%s/%s", object.ID, object.ObjectName)
 	
-	// Delete from storage provider
-	err = m.provider.DeleteObject(ctx, object.BucketName, storageKey)
+	// Delete prior versions, and the current one for good rather than
+	// keeping it as a version
+	if err := m.purgeVersions(ctx, &object, 0); err != nil {
+		return err
+	}
+	err = m.deleteCurrent(ctx, object.BucketName, storageKey)
 	if err != nil {
 		m.logger.Error(ctx, "Failed to delete object from storage",
 			logger.String("bucket", object.BucketName),
@@ -281,7 +290,7 @@ func (m *Manager) DeleteObject(ctx context.Context, objectID string) error {
 	}
 	
 	// Delete from database
-	result := m.db.WithContext(ctx).Delete(&object)
+	result := m.db.WithContext(ctx).Unscoped().Delete(&object)
 	if result.Error != nil {
 		return fmt.Errorf("failed to delete object from database: %w", result.Error)
 	}
@@ -336,8 +345,9 @@ func (m *Manager) GenerateSignedURL(ctx context.Context, objectID string, expire
 	return m.provider.GeneratePresignedURL(ctx, obj.BucketName, storageKey, expiresIn)
 }
 
-// GetFile retrieves a file's content and type by object ID
-func (m *Manager) GetFile(ctx context.Context, objectID string) ([]byte, string, error) {
+// GetFile opens a file's content by object ID and returns it with the file's
+// type. The caller must close the reader.
+func (m *Manager) GetFile(ctx context.Context, objectID string) (io.ReadCloser, string, error) {
 	// Get object by ID
 	obj, err := m.GetObject(ctx, objectID)
 	if err != nil {
@@ -352,18 +362,14 @@ func (m *Manager) GetFile(ctx context.Context, objectID string) ([]byte, string,
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to get object from storage: %w", err)
 	}
-	defer reader.Close()
 	
-	content, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to read object content: %w", err)
-	}
-	
-	return content, obj.ContentType, nil
+	return reader, obj.ContentType, nil
 }
 
-// UpdateFile updates a file's content by object ID
-func (m *Manager) UpdateFile(ctx context.Context, objectID string, content []byte) error {
+// UpdateFile streams new content for a file by object ID. A negative size is
+// for content of unknown length. In a bucket with versioning enabled the
+// prior content is kept as a StorageObjectVersion.
+func (m *Manager) UpdateFile(ctx context.Context, objectID string, reader io.Reader, size int64) error {
 	// Get existing object
 	obj, err := m.GetObject(ctx, objectID)
 	if err != nil {
@@ -373,19 +379,140 @@ func (m *Manager) UpdateFile(ctx context.Context, objectID string, content []byt
 	// Build storage key
 	storageKey := fmt.Sprintf("%s/%s", obj.ID, obj.ObjectName)
 	
+	// Remember the version being replaced
+	bucket, _ := m.GetBucket(ctx, obj.BucketName)
+	versioning := bucket != nil && bucket.Versioning
+	_, versioned := m.provider.(VersionedProvider)
+	priorVersionID := ""
+	if versioned {
+		if info, err := m.provider.GetObjectInfo(ctx, obj.BucketName, storageKey); err == nil {
+			priorVersionID = info.VersionID
+		}
+	}
+	
 	// Upload new content to provider
-	reader := bytes.NewReader(content)
-	err = m.provider.PutObject(ctx, obj.BucketName, storageKey, reader, int64(len(content)), PutObjectOptions{
+	hashed := NewHashingReader(reader, size)
+	err = m.provider.PutObject(ctx, obj.BucketName, storageKey, hashed, size, PutObjectOptions{
 		ContentType: obj.ContentType,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to update object in storage: %w", err)
 	}
 	
+	now := time.Now()
+	if versioned && KeepsPriorVersion(versioning, priorVersionID) {
+		if priorVersionID == "" {
+			priorVersionID = NullVersionID
+		}
+		version := &StorageObjectVersion{
+			ID:          uuid.New().String(),
+			ObjectID:    obj.ID,
+			VersionID:   priorVersionID,
+			StorageKey:  storageKey,
+			ObjectName:  obj.ObjectName,
+			Size:        obj.Size,
+			ContentType: obj.ContentType,
+			Checksum:    obj.Checksum,
+			SHA256:      obj.SHA256,
+			UserID:      obj.UserID,
+			CreatedAt:   obj.UpdatedAt,
+			ReplacedAt:  now,
+		}
+		if err := m.db.WithContext(ctx).Create(version).Error; err != nil {
+			return fmt.Errorf("failed to record object version: %w", err)
+		}
+	}
+	
 	// Update database
-	obj.Size = int64(len(content))
-	obj.UpdatedAt = time.Now()
+	obj.Size = hashed.Size()
+	obj.Checksum = hashed.ETag()
+	obj.SHA256 = hashed.SHA256()
+	obj.UpdatedAt = now
+	if err := m.db.WithContext(ctx).Save(obj).Error; err != nil {
+		return err
+	}
 	
-	return m.db.WithContext(ctx).Save(obj).Error
+	if bucket != nil && bucket.MaxVersions > 0 {
+		return m.purgeVersions(ctx, obj, bucket.MaxVersions)
+	}
+	return nil
 }
 
+// SetBucketVersioning enables or suspends versioning for a bucket and sets
+// how many prior versions are kept per file, 0 keeping all of them
+func (m *Manager) SetBucketVersioning(ctx context.Context, name string, enabled bool, maxVersions int) (*StorageBucket, error) {
+	bucket, err := m.GetBucket(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	
+	versioned, ok := m.provider.(VersionedProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support versioning", m.provider.Name())
+	}
+	if err := versioned.SetBucketVersioning(ctx, name, enabled); err != nil {
+		return nil, err
+	}
+	
+	bucket.Versioning = enabled
+	bucket.MaxVersions = maxVersions
+	if err := m.db.WithContext(ctx).Save(bucket).Error; err != nil {
+		return nil, fmt.Errorf("failed to update bucket: %w", err)
+	}
+	
+	// Apply a lower limit to the versions already kept
+	if maxVersions > 0 {
+		var objects []StorageObject
+		if err := m.db.WithContext(ctx).Where("bucket_name = ?", name).Find(&objects).Error; err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		for i := range objects {
+			if err := m.purgeVersions(ctx, &objects[i], maxVersions); err != nil {
+				return nil, err
+			}
+		}
+	}
+	
+	return bucket, nil
+}
+
+// deleteCurrent deletes the current content of an object for good, where
+// DeleteObject would keep it as a prior version
+func (m *Manager) deleteCurrent(ctx context.Context, bucketName, storageKey string) error {
+	if versioned, ok := m.provider.(VersionedProvider); ok {
+		if info, err := m.provider.GetObjectInfo(ctx, bucketName, storageKey); err == nil {
+			versionID := info.VersionID
+			if bucket, _ := m.GetBucket(ctx, bucketName); versionID == "" && bucket != nil && bucket.Versioning {
+				versionID = NullVersionID
+			}
+			if versionID != "" {
+				return versioned.DeleteObjectVersion(ctx, bucketName, storageKey, versionID)
+			}
+		}
+	}
+	return m.provider.DeleteObject(ctx, bucketName, storageKey)
+}
+
+// purgeVersions deletes the prior versions of an object other than the
+// newest keep
+func (m *Manager) purgeVersions(ctx context.Context, obj *StorageObject, keep int) error {
+	versions, err := ObjectVersionsBeyond(m.db.WithContext(ctx), obj.ID, keep)
+	if err != nil {
+		return err
+	}
+	
+	versioned, ok := m.provider.(VersionedProvider)
+	for i := range versions {
+		if ok {
+			err := versioned.DeleteObjectVersion(ctx, obj.BucketName, versions[i].StorageKey, versions[i].VersionID)
+			if err != nil && !strings.Contains(err.Error(), "not found") {
+				return fmt.Errorf("failed to delete object version: %w", err)
+			}
+		}
+		if err := m.db.WithContext(ctx).Delete(&versions[i]).Error; err != nil {
+			return fmt.Errorf("failed to delete object version: %w", err)
+		}
+	}
+	
+	return nil
+}
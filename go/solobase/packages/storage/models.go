@@ -2,15 +2,23 @@ package storage
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // StorageBucket represents a storage bucket in the database
 type StorageBucket struct {
-	ID        string    `gorm:"primaryKey" json:"id"`
-	Name      string    `gorm:"uniqueIndex;not null" json:"name"`
-	Public    bool      `gorm:"default:false" json:"public"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID     string `gorm:"primaryKey" json:"id"`
+	Name   string `gorm:"uniqueIndex;not null" json:"name"`
+	Public bool   `gorm:"default:false" json:"public"`
+	// OrganizationID is set for buckets shared by the members of an organization
+	OrganizationID *string `gorm:"type:uuid;index" json:"organization_id,omitempty"`
+	// Versioning keeps the prior content of overwritten files
+	Versioning bool `gorm:"default:false" json:"versioning"`
+	// MaxVersions is the number of prior versions kept per file, 0 keeps all
+	MaxVersions int       `gorm:"default:0" json:"max_versions"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 // TableName specifies the table name
@@ -25,14 +33,22 @@ type StorageObject struct {
 	ObjectName     string     `gorm:"not null;index" json:"object_name"`       // Just the name (file.txt or foldername)
 	ParentFolderID *string    `gorm:"index" json:"parent_folder_id,omitempty"` // ID of parent folder, null for root items
 	Size           int64      `json:"size"`
-	ContentType    string     `json:"content_type"`                        // "application/x-directory" for folders
-	Checksum       string     `gorm:"index" json:"checksum,omitempty"`     // MD5 or SHA256 hash
+	ContentType    string     `json:"content_type"`                    // "application/x-directory" for folders
+	Checksum       string     `gorm:"index" json:"checksum,omitempty"` // MD5 hash, the object's ETag
+	SHA256         string     `gorm:"column:sha256" json:"sha256,omitempty"`
 	Metadata       string     `gorm:"type:text" json:"metadata,omitempty"` // JSON string
 	CreatedAt      time.Time  `json:"created_at"`
 	UpdatedAt      time.Time  `json:"updated_at"`
 	LastViewed     *time.Time `gorm:"index" json:"last_viewed,omitempty"` // Track when the item was last viewed
 	UserID         string     `gorm:"index" json:"user_id,omitempty"`
 	AppID          *string    `gorm:"index" json:"app_id,omitempty"` // Application ID, null for admin uploads
+
+	// DeletedAt is set while the object is in the trash, which hides it from
+	// queries other than Unscoped ones
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	DeletedBy *string        `gorm:"index" json:"deleted_by,omitempty"` // User who moved the object to the trash
+
+	Versions []StorageObjectVersion `gorm:"foreignKey:ObjectID;constraint:OnDelete:CASCADE" json:"-"`
 }
 
 // TableName specifies the table name
@@ -49,3 +65,89 @@ func (s *StorageObject) IsFolder() bool {
 func (s *StorageObject) IsFile() bool {
 	return s.ContentType != "application/x-directory"
 }
+
+// StorageObjectVersion is the prior content of a file overwritten in a bucket
+// with versioning enabled. The content stays in the storage provider under
+// the key the file had when it was written.
+type StorageObjectVersion struct {
+	ID          string    `gorm:"primaryKey" json:"id"`
+	ObjectID    string    `gorm:"not null;index" json:"object_id"`
+	VersionID   string    `gorm:"not null" json:"version_id"` // Version ID in the storage provider
+	StorageKey  string    `gorm:"not null" json:"-"`
+	ObjectName  string    `json:"object_name"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	Checksum    string    `json:"checksum,omitempty"`
+	SHA256      string    `gorm:"column:sha256" json:"sha256,omitempty"`
+	UserID      string    `gorm:"index" json:"user_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`  // When this content was written
+	ReplacedAt  time.Time `json:"replaced_at"` // When newer content replaced it
+}
+
+// TableName specifies the table name
+func (StorageObjectVersion) TableName() string {
+	return "storage_object_versions"
+}
+
+// StorageUpload is a resumable upload in progress. The file becomes a
+// StorageObject with ObjectID once all Size bytes are written.
+type StorageUpload struct {
+	ID             string    `gorm:"primaryKey" json:"id"`
+	BucketName     string    `gorm:"not null;index" json:"bucket_name"`
+	ObjectID       string    `gorm:"not null" json:"object_id"`
+	ObjectName     string    `gorm:"not null" json:"object_name"`
+	ParentFolderID *string   `json:"parent_folder_id,omitempty"`
+	ContentType    string    `json:"content_type"`
+	Size           int64     `json:"size"`                                // Total bytes of the file
+	Offset         int64     `gorm:"column:upload_offset" json:"offset"`  // Bytes written so far
+	Metadata       string    `gorm:"type:text" json:"metadata,omitempty"` // Upload-Metadata as sent by the client
+	UploadID       string    `gorm:"not null" json:"-"`                   // Upload ID in the storage provider
+	HashState      []byte    `json:"-"`                                   // Hash state of the bytes written so far
+	UserID         string    `gorm:"index" json:"user_id,omitempty"`
+	AppID          *string   `gorm:"index" json:"app_id,omitempty"`
+	ExpiresAt      time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name
+func (StorageUpload) TableName() string {
+	return "storage_uploads"
+}
+
+// StorageMultipartUpload is an S3 multipart upload in progress. Its parts are
+// kept in the bucket under .multipart/<id>/ until the upload is completed,
+// when they are joined into the object at Key.
+type StorageMultipartUpload struct {
+	ID          string    `gorm:"primaryKey" json:"id"` // Upload ID given to the client
+	BucketName  string    `gorm:"not null;index" json:"bucket_name"`
+	Key         string    `gorm:"column:object_key;not null" json:"key"` // Slash separated path of the object
+	ContentType string    `json:"content_type"`
+	Metadata    string    `gorm:"type:text" json:"metadata,omitempty"` // JSON string
+	UserID      string    `gorm:"index" json:"user_id,omitempty"`
+	AppID       *string   `gorm:"index" json:"app_id,omitempty"`
+	ExpiresAt   time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	Parts []StorageMultipartPart `gorm:"foreignKey:UploadID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// TableName specifies the table name
+func (StorageMultipartUpload) TableName() string {
+	return "storage_multipart_uploads"
+}
+
+// StorageMultipartPart is an uploaded part of a multipart upload
+type StorageMultipartPart struct {
+	UploadID   string    `gorm:"primaryKey" json:"upload_id"`
+	PartNumber int       `gorm:"primaryKey;autoIncrement:false" json:"part_number"`
+	Size       int64     `json:"size"`
+	ETag       string    `json:"etag"` // MD5 hash of the part
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name
+func (StorageMultipartPart) TableName() string {
+	return "storage_multipart_parts"
+}
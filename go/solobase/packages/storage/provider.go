@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
 	"time"
 )
@@ -84,6 +85,51 @@ type ObjectInfo struct {
 	LastModified time.Time
 	Metadata     map[string]string
 	IsDir        bool
+	// VersionID identifies the current version in a versioned bucket
+	VersionID string
+}
+
+// NullVersionID identifies an object written before versioning was enabled
+const NullVersionID = "null"
+
+// ObjectVersionInfo contains information about one version of an object
+type ObjectVersionInfo struct {
+	Key          string
+	VersionID    string
+	Size         int64
+	LastModified time.Time
+	IsLatest     bool
+}
+
+// VersionedProvider is implemented by providers that keep the prior versions
+// of objects overwritten or deleted in buckets with versioning enabled
+type VersionedProvider interface {
+	SetBucketVersioning(ctx context.Context, bucket string, enabled bool) error
+	ListObjectVersions(ctx context.Context, bucket, key string) ([]ObjectVersionInfo, error)
+	GetObjectVersion(ctx context.Context, bucket, key, versionID string) (io.ReadCloser, error)
+	// DeleteObjectVersion permanently deletes one version, which may be the
+	// current one
+	DeleteObjectVersion(ctx context.Context, bucket, key, versionID string) error
+}
+
+// ErrUploadOffsetMismatch is returned when a chunk is not written at the end
+// of the bytes an upload has so far
+var ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+
+// ResumableProvider is implemented by providers that can write an object in
+// chunks sent one after another, so an interrupted upload can be resumed.
+// The object only appears once the upload is completed.
+type ResumableProvider interface {
+	// CreateUpload starts an upload to key and returns its ID
+	CreateUpload(ctx context.Context, bucket, key string, opts PutObjectOptions) (string, error)
+	// WriteUpload writes the next chunk of an upload, which must start at
+	// offset, the number of bytes written so far. It returns the bytes kept,
+	// which stay written when reading the chunk fails part way.
+	WriteUpload(ctx context.Context, bucket, key, uploadID string, offset int64, reader io.Reader) (int64, error)
+	// CompleteUpload makes the bytes written the content of the object
+	CompleteUpload(ctx context.Context, bucket, key, uploadID string) error
+	// AbortUpload discards an upload and the bytes written
+	AbortUpload(ctx context.Context, bucket, key, uploadID string) error
 }
 
 // Config contains configuration for storage providers
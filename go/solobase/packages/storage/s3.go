@@ -144,14 +144,8 @@ func (s *S3Provider) CreateBucket(ctx context.Context, name string, opts CreateB
 
 	// Enable versioning if requested
 	if opts.Versioning {
-		_, err = s.client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
-			Bucket: aws.String(bucketName),
-			VersioningConfiguration: &types.VersioningConfiguration{
-				Status: types.BucketVersioningStatusEnabled,
-			},
-		})
-		if err != nil {
-			// Non-fatal: log but continue
+		if err := s.SetBucketVersioning(ctx, name, true); err != nil {
+			return err
 		}
 	}
 
@@ -246,19 +240,33 @@ func (s *S3Provider) ListBuckets(ctx context.Context) ([]BucketInfo, error) {
 	return buckets, nil
 }
 
-// PutObject uploads an object to S3
+// PutObject uploads an object to S3. Content that fits in one part is sent
+// in a single request; larger content, or content of unknown size, streams
+// as a multipart upload one part at a time, so memory use does not grow with
+// the size of the object.
 func (s *S3Provider) PutObject(ctx context.Context, bucket, key string, reader io.Reader, size int64, opts PutObjectOptions) error {
-	bucketName := s.getBucketName(bucket)
-
-	// Read all data into memory for S3 upload
-	// For large files, you'd want to use multipart upload
-	data, err := io.ReadAll(reader)
+	bufferSize := s3MinPartSize
+	if size >= 0 && size < s3MinPartSize {
+		// One byte more than the size tells content that fits from content
+		// that does not
+		bufferSize = int(size) + 1
+	}
+	data := make([]byte, bufferSize)
+	n, err := io.ReadFull(reader, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.putSinglePart(ctx, bucket, key, data[:n], opts)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to read data: %w", err)
 	}
 
+	return s.putMultipart(ctx, bucket, key, io.MultiReader(bytes.NewReader(data[:n]), reader), opts)
+}
+
+// putSinglePart uploads content in a single request
+func (s *S3Provider) putSinglePart(ctx context.Context, bucket, key string, data []byte, opts PutObjectOptions) error {
 	input := &s3.PutObjectInput{
-		Bucket: aws.String(bucketName),
+		Bucket: aws.String(s.getBucketName(bucket)),
 		Key:    aws.String(key),
 		Body:   bytes.NewReader(data),
 	}
@@ -288,7 +296,7 @@ func (s *S3Provider) PutObject(ctx context.Context, bucket, key string, reader i
 		input.ACL = types.ObjectCannedACLPublicRead
 	}
 
-	_, err = s.client.PutObject(ctx, input)
+	_, err := s.client.PutObject(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to upload object: %w", err)
 	}
@@ -296,6 +304,49 @@ func (s *S3Provider) PutObject(ctx context.Context, bucket, key string, reader i
 	return nil
 }
 
+// putMultipart uploads content as a multipart upload, reading one part at a
+// time. The upload is aborted when reading or uploading a part fails.
+func (s *S3Provider) putMultipart(ctx context.Context, bucket, key string, reader io.Reader, opts PutObjectOptions) error {
+	uploadID, err := s.CreateUpload(ctx, bucket, key, opts)
+	if err != nil {
+		return err
+	}
+	abort := func(err error) error {
+		s.AbortUpload(context.Background(), bucket, key, uploadID)
+		return err
+	}
+
+	var completed []types.CompletedPart
+	data := make([]byte, s3MinPartSize)
+	for partNumber := int32(1); ; partNumber++ {
+		n, readErr := io.ReadFull(reader, data)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return abort(fmt.Errorf("failed to read data: %w", readErr))
+		}
+		if n > 0 {
+			etag, err := s.uploadPart(ctx, bucket, key, uploadID, partNumber, data[:n])
+			if err != nil {
+				return abort(err)
+			}
+			completed = append(completed, types.CompletedPart{ETag: etag, PartNumber: aws.Int32(partNumber)})
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.getBucketName(bucket)),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return abort(fmt.Errorf("failed to complete multipart upload: %w", err))
+	}
+	return nil
+}
+
 // GetObject retrieves an object from S3
 func (s *S3Provider) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
 	bucketName := s.getBucketName(bucket)
@@ -347,6 +398,10 @@ func (s *S3Provider) GetObjectInfo(ctx context.Context, bucket, key string) (*Ob
 		info.Metadata = output.Metadata
 	}
 
+	if output.VersionId != nil {
+		info.VersionID = *output.VersionId
+	}
+
 	return info, nil
 }
 
@@ -445,6 +500,92 @@ func (s *S3Provider) GeneratePresignedURL(ctx context.Context, bucket, key strin
 	return request.URL, nil
 }
 
+// SetBucketVersioning enables or suspends versioning for a bucket
+func (s *S3Provider) SetBucketVersioning(ctx context.Context, bucket string, enabled bool) error {
+	status := types.BucketVersioningStatusSuspended
+	if enabled {
+		status = types.BucketVersioningStatusEnabled
+	}
+
+	_, err := s.client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+		Bucket: aws.String(s.getBucketName(bucket)),
+		VersioningConfiguration: &types.VersioningConfiguration{
+			Status: status,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set bucket versioning: %w", err)
+	}
+
+	return nil
+}
+
+// ListObjectVersions lists the versions of an object, newest first
+func (s *S3Provider) ListObjectVersions(ctx context.Context, bucket, key string) ([]ObjectVersionInfo, error) {
+	paginator := s3.NewListObjectVersionsPaginator(s.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.getBucketName(bucket)),
+		Prefix: aws.String(key),
+	})
+
+	var versions []ObjectVersionInfo
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions: %w", err)
+		}
+
+		for _, version := range output.Versions {
+			// The prefix also matches longer keys
+			if aws.ToString(version.Key) != key {
+				continue
+			}
+			info := ObjectVersionInfo{
+				Key:       key,
+				VersionID: aws.ToString(version.VersionId),
+				Size:      aws.ToInt64(version.Size),
+				IsLatest:  aws.ToBool(version.IsLatest),
+			}
+			if version.LastModified != nil {
+				info.LastModified = *version.LastModified
+			}
+			versions = append(versions, info)
+		}
+	}
+
+	return versions, nil
+}
+
+// GetObjectVersion retrieves one version of an object
+func (s *S3Provider) GetObjectVersion(ctx context.Context, bucket, key, versionID string) (io.ReadCloser, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(s.getBucketName(bucket)),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NoSuchKey") || strings.Contains(err.Error(), "NoSuchVersion") {
+			return nil, fmt.Errorf("object version not found")
+		}
+		return nil, fmt.Errorf("failed to get object version: %w", err)
+	}
+
+	return output.Body, nil
+}
+
+// DeleteObjectVersion permanently deletes one version of an object
+func (s *S3Provider) DeleteObjectVersion(ctx context.Context, bucket, key, versionID string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:    aws.String(s.getBucketName(bucket)),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object version: %w", err)
+	}
+
+	return nil
+}
+
 // Helper functions
 
 func (s *S3Provider) getBucketName(name string) string {
@@ -455,8 +596,9 @@ func (s *S3Provider) getBucketName(name string) string {
 }
 
 func (s *S3Provider) emptyBucket(ctx context.Context, bucket string) error {
-	// List all objects
-	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+	// List all object versions, which in an unversioned bucket are the
+	// objects themselves
+	paginator := s3.NewListObjectVersionsPaginator(s.client, &s3.ListObjectVersionsInput{
 		Bucket: aws.String(bucket),
 	})
 
@@ -466,18 +608,25 @@ func (s *S3Provider) emptyBucket(ctx context.Context, bucket string) error {
 			return err
 		}
 
-		if len(output.Contents) == 0 {
-			continue
-		}
-
-		// Build delete objects
+		// Build delete objects, including prior versions and delete markers
 		var deleteObjects []types.ObjectIdentifier
-		for _, obj := range output.Contents {
+		for _, version := range output.Versions {
+			deleteObjects = append(deleteObjects, types.ObjectIdentifier{
+				Key:       version.Key,
+				VersionId: version.VersionId,
+			})
+		}
+		for _, marker := range output.DeleteMarkers {
 			deleteObjects = append(deleteObjects, types.ObjectIdentifier{
-				Key: obj.Key,
+				Key:       marker.Key,
+				VersionId: marker.VersionId,
 			})
 		}
 
+		if len(deleteObjects) == 0 {
+			continue
+		}
+
 		// Delete objects in batch
 		_, err = s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
 			Bucket: aws.String(bucket),
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3MinPartSize is the smallest part S3 accepts other than the last one.
+// Chunks are gathered into parts of this size, and the bytes short of a part
+// are kept in a pending object until the next chunk or the completion.
+const s3MinPartSize = 5 << 20
+
+// CreateUpload starts a multipart upload
+func (s *S3Provider) CreateUpload(ctx context.Context, bucket, key string, opts PutObjectOptions) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.getBucketName(bucket)),
+		Key:    aws.String(key),
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(opts.ContentEncoding)
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if len(opts.Metadata) > 0 {
+		input.Metadata = opts.Metadata
+	}
+	if opts.Public {
+		input.ACL = types.ObjectCannedACLPublicRead
+	}
+
+	output, err := s.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	return aws.ToString(output.UploadId), nil
+}
+
+// WriteUpload uploads the parts a chunk fills and keeps the rest pending
+func (s *S3Provider) WriteUpload(ctx context.Context, bucket, key, uploadID string, offset int64, reader io.Reader) (int64, error) {
+	parts, err := s.uploadedParts(ctx, bucket, key, uploadID)
+	if err != nil {
+		return 0, err
+	}
+	pending, err := s.pendingPart(ctx, bucket, uploadID)
+	if err != nil {
+		return 0, err
+	}
+
+	var uploaded int64
+	for _, part := range parts {
+		uploaded += aws.ToInt64(part.Size)
+	}
+	if uploaded+int64(len(pending)) != offset {
+		return 0, ErrUploadOffsetMismatch
+	}
+
+	nextPart := int32(len(parts) + 1)
+	buf := bytes.NewBuffer(pending)
+	var read int64
+	var readErr error
+	for {
+		n, err := io.CopyN(buf, reader, int64(s3MinPartSize-buf.Len()))
+		read += n
+		if buf.Len() >= s3MinPartSize {
+			if _, err := s.uploadPart(ctx, bucket, key, uploadID, nextPart, buf.Bytes()); err != nil {
+				// The part is lost, so only the bytes before it are kept
+				return read - int64(buf.Len()-len(pending)), err
+			}
+			nextPart++
+			buf.Reset()
+			if len(pending) > 0 {
+				// The pending bytes went into the part
+				if err := s.putPendingPart(ctx, bucket, uploadID, nil); err != nil {
+					return read, err
+				}
+				pending = nil
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = fmt.Errorf("failed to read chunk: %w", err)
+			}
+			break
+		}
+	}
+
+	// Keep the bytes short of a part. When they cannot be stored, the bytes
+	// kept are those uploaded in parts.
+	if err := s.putPendingPart(ctx, bucket, uploadID, buf.Bytes()); err != nil {
+		return read - int64(buf.Len()-len(pending)), err
+	}
+	return read, readErr
+}
+
+// CompleteUpload uploads the pending bytes as the last part and completes the
+// multipart upload
+func (s *S3Provider) CompleteUpload(ctx context.Context, bucket, key, uploadID string) error {
+	parts, err := s.uploadedParts(ctx, bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	pending, err := s.pendingPart(ctx, bucket, uploadID)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) > 0 || len(parts) == 0 {
+		// S3 needs at least one part, even for an empty object
+		partNumber := int32(len(parts) + 1)
+		if _, err := s.uploadPart(ctx, bucket, key, uploadID, partNumber, pending); err != nil {
+			return err
+		}
+		if parts, err = s.uploadedParts(ctx, bucket, key, uploadID); err != nil {
+			return err
+		}
+	}
+
+	completed := make([]types.CompletedPart, len(parts))
+	for i, part := range parts {
+		completed[i] = types.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber}
+	}
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.getBucketName(bucket)),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	return s.putPendingPart(ctx, bucket, uploadID, nil)
+}
+
+// AbortUpload aborts the multipart upload and removes the pending bytes
+func (s *S3Provider) AbortUpload(ctx context.Context, bucket, key, uploadID string) error {
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.getBucketName(bucket)),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil && !strings.Contains(err.Error(), "NoSuchUpload") {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return s.putPendingPart(ctx, bucket, uploadID, nil)
+}
+
+// uploadedParts lists the parts of a multipart upload in order
+func (s *S3Provider) uploadedParts(ctx context.Context, bucket, key, uploadID string) ([]types.Part, error) {
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.getBucketName(bucket)),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+
+	var parts []types.Part
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			if strings.Contains(err.Error(), "NoSuchUpload") {
+				return nil, fmt.Errorf("upload not found")
+			}
+			return nil, fmt.Errorf("failed to list upload parts: %w", err)
+		}
+		parts = append(parts, output.Parts...)
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber)
+	})
+	return parts, nil
+}
+
+// uploadPart uploads a part of a multipart upload, returning its ETag
+func (s *S3Provider) uploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int32, data []byte) (*string, error) {
+	output, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(s.getBucketName(bucket)),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(partNumber),
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload part: %w", err)
+	}
+	return output.ETag, nil
+}
+
+// pendingKey is the object holding the bytes of an upload short of a part
+func pendingKey(uploadID string) string {
+	return ".uploads/" + uploadID + ".part"
+}
+
+func (s *S3Provider) pendingPart(ctx context.Context, bucket, uploadID string) ([]byte, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.getBucketName(bucket)),
+		Key:    aws.String(pendingKey(uploadID)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) || strings.Contains(err.Error(), "NoSuchKey") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read pending upload part: %w", err)
+	}
+	defer output.Body.Close()
+
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending upload part: %w", err)
+	}
+	return data, nil
+}
+
+// putPendingPart stores the bytes short of a part, replacing those stored
+// before. Prior versions of the pending object are removed so versioned
+// buckets keep nothing behind.
+func (s *S3Provider) putPendingPart(ctx context.Context, bucket, uploadID string, data []byte) error {
+	key := pendingKey(uploadID)
+	keepVersion := ""
+	if len(data) > 0 {
+		output, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.getBucketName(bucket)),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(data),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store pending upload part: %w", err)
+		}
+		if output.VersionId == nil {
+			// Without versioning the pending bytes were simply overwritten
+			return nil
+		}
+		keepVersion = *output.VersionId
+	}
+
+	versions, err := s.ListObjectVersions(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if version.VersionID == keepVersion {
+			continue
+		}
+		if err := s.DeleteObjectVersion(ctx, bucket, key, version.VersionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
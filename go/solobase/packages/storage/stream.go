@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// ErrSizeMismatch is returned when content is longer or shorter than the
+// size it was declared with
+var ErrSizeMismatch = errors.New("content size mismatch")
+
+// HashingReader counts and hashes the bytes read through it, so content is
+// checksummed while it streams to a provider instead of being held in
+// memory. With a declared size, reading fails with ErrSizeMismatch when the
+// content turns out longer or shorter, which aborts the write it feeds.
+type HashingReader struct {
+	reader   io.Reader
+	expected int64
+	size     int64
+	sha256   hash.Hash
+	md5      hash.Hash
+}
+
+// NewHashingReader hashes the content read from reader. A negative size is
+// for content of unknown length.
+func NewHashingReader(reader io.Reader, size int64) *HashingReader {
+	return &HashingReader{
+		reader:   reader,
+		expected: size,
+		sha256:   sha256.New(),
+		md5:      md5.New(),
+	}
+}
+
+// ResumeHashingReader carries on hashing content from the state saved by
+// MarshalBinary, for content written in several parts. Size then counts the
+// bytes read through the new reader only.
+func ResumeHashingReader(reader io.Reader, size int64, state []byte) (*HashingReader, error) {
+	h := NewHashingReader(reader, size)
+	if len(state) < 4 {
+		return nil, fmt.Errorf("invalid hash state")
+	}
+	md5Len := int(binary.BigEndian.Uint32(state))
+	if len(state) < 4+md5Len {
+		return nil, fmt.Errorf("invalid hash state")
+	}
+	if err := h.md5.(encoding.BinaryUnmarshaler).UnmarshalBinary(state[4 : 4+md5Len]); err != nil {
+		return nil, err
+	}
+	if err := h.sha256.(encoding.BinaryUnmarshaler).UnmarshalBinary(state[4+md5Len:]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *HashingReader) Read(p []byte) (int, error) {
+	if h.expected >= 0 && int64(len(p)) > h.expected-h.size+1 {
+		// Read at most one byte past the declared size to notice longer content
+		p = p[:h.expected-h.size+1]
+	}
+	n, err := h.reader.Read(p)
+	h.sha256.Write(p[:n])
+	h.md5.Write(p[:n])
+	h.size += int64(n)
+
+	if h.expected >= 0 {
+		if h.size > h.expected {
+			return n, fmt.Errorf("%w: more than %d bytes", ErrSizeMismatch, h.expected)
+		}
+		if err == io.EOF && h.size < h.expected {
+			return n, fmt.Errorf("%w: expected %d bytes, got %d", ErrSizeMismatch, h.expected, h.size)
+		}
+	}
+	return n, err
+}
+
+// Size returns the number of bytes read
+func (h *HashingReader) Size() int64 {
+	return h.size
+}
+
+// SHA256 returns the hex encoded SHA-256 of the content
+func (h *HashingReader) SHA256() string {
+	return hex.EncodeToString(h.sha256.Sum(nil))
+}
+
+// ETag returns the hex encoded MD5 of the content, the ETag S3 gives content
+// uploaded in a single part. It is what StorageObject.Checksum holds.
+func (h *HashingReader) ETag() string {
+	return hex.EncodeToString(h.md5.Sum(nil))
+}
+
+// MarshalBinary saves the hashes of the content read so far
+func (h *HashingReader) MarshalBinary() ([]byte, error) {
+	md5State, err := h.md5.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	sha256State, err := h.sha256.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	state := binary.BigEndian.AppendUint32(nil, uint32(len(md5State)))
+	state = append(state, md5State...)
+	return append(state, sha256State...), nil
+}
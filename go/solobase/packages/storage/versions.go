@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// KeepsPriorVersion reports whether a provider kept the content it replaced,
+// given the version that content had. Content written while versioning was
+// enabled is kept even after versioning is suspended.
+func KeepsPriorVersion(bucketVersioning bool, priorVersionID string) bool {
+	return bucketVersioning || (priorVersionID != "" && priorVersionID != NullVersionID)
+}
+
+// ObjectVersionsBeyond returns the prior versions of an object other than the
+// newest keep, oldest first
+func ObjectVersionsBeyond(db *gorm.DB, objectID string, keep int) ([]StorageObjectVersion, error) {
+	var versions []StorageObjectVersion
+	err := db.Where("object_id = ?", objectID).
+		Order("replaced_at DESC").
+		Offset(keep).
+		Find(&versions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list object versions: %w", err)
+	}
+
+	// Delete the oldest first so an interrupted purge keeps the newest
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	return versions, nil
+}
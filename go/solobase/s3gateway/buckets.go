@@ -0,0 +1,315 @@
+package s3gateway
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	xmlNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+	// timestampFormat is how S3 writes times in XML
+	timestampFormat = "2006-01-02T15:04:05.000Z"
+	// maxKeys is the most entries a listing returns, and the most keys a
+	// DeleteObjects request may name
+	maxKeys = 1000
+	// maxDeleteBody limits the size of a DeleteObjects request
+	maxDeleteBody = 1 << 20
+)
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketEntry struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Owner   owner         `xml:"Owner"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type objectEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listObjectsResult struct {
+	XMLName        xml.Name       `xml:"ListBucketResult"`
+	Xmlns          string         `xml:"xmlns,attr"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Marker         *string        `xml:"Marker,omitempty"`
+	NextMarker     string         `xml:"NextMarker,omitempty"`
+	Delimiter      string         `xml:"Delimiter,omitempty"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	EncodingType   string         `xml:"EncodingType,omitempty"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	Contents       []objectEntry  `xml:"Contents"`
+	CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+
+	// ListObjectsV2 only
+	KeyCount              *int   `xml:"KeyCount,omitempty"`
+	ContinuationToken     string `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string `xml:"StartAfter,omitempty"`
+}
+
+type locationResult struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deletedEntry struct {
+	Key string `xml:"Key"`
+}
+
+type deleteErrorEntry struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name           `xml:"DeleteResult"`
+	Xmlns   string             `xml:"xmlns,attr"`
+	Deleted []deletedEntry     `xml:"Deleted"`
+	Errors  []deleteErrorEntry `xml:"Error"`
+}
+
+// listBuckets answers ListBuckets with the buckets the caller can see
+func (g *Gateway) listBuckets(w http.ResponseWriter, r *request) {
+	buckets, err := g.visibleBuckets(r)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+
+	result := listBucketsResult{
+		Xmlns:   xmlNamespace,
+		Owner:   owner{ID: r.userID(), DisplayName: r.user.Email},
+		Buckets: make([]bucketEntry, len(buckets)),
+	}
+	for i, bucket := range buckets {
+		result.Buckets[i] = bucketEntry{Name: bucket.Name, CreationDate: formatTime(bucket.CreatedAt)}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// getBucketLocation answers GetBucketLocation. Buckets have no region, which
+// S3 reports as an empty location.
+func (g *Gateway) getBucketLocation(w http.ResponseWriter, r *request) {
+	writeXML(w, http.StatusOK, locationResult{Xmlns: xmlNamespace})
+}
+
+// listObjects answers ListObjects (version 1), paged by key
+func (g *Gateway) listObjects(w http.ResponseWriter, r *request) {
+	query := r.URL.Query()
+	marker := query.Get("marker")
+	result, last, err := g.list(r, marker)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	result.Marker = &marker
+	if result.IsTruncated && result.Delimiter != "" {
+		// Clients only work out the next marker themselves without a delimiter
+		result.NextMarker = last
+	}
+	if result.EncodingType == "url" {
+		*result.Marker = url.QueryEscape(*result.Marker)
+		result.NextMarker = url.QueryEscape(result.NextMarker)
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// listObjectsV2 answers ListObjectsV2, paged by continuation token
+func (g *Gateway) listObjectsV2(w http.ResponseWriter, r *request) {
+	query := r.URL.Query()
+	after := query.Get("start-after")
+	token := query.Get("continuation-token")
+	if token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, r.Request, &apiError{"InvalidArgument", "The continuation token provided is incorrect", http.StatusBadRequest})
+			return
+		}
+		after = string(decoded)
+	}
+
+	result, last, err := g.list(r, after)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	keyCount := len(result.Contents) + len(result.CommonPrefixes)
+	result.KeyCount = &keyCount
+	result.ContinuationToken = token
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+	result.StartAfter = query.Get("start-after")
+	if result.EncodingType == "url" {
+		result.StartAfter = url.QueryEscape(result.StartAfter)
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// list lists the keys after the given one that start with the prefix, with
+// those containing the delimiter after it rolled up into common prefixes. It
+// returns the last key or common prefix listed, to page from.
+func (g *Gateway) list(r *request, after string) (*listObjectsResult, string, error) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+
+	limit := maxKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, "", &apiError{"InvalidArgument", "max-keys must be a non-negative integer", http.StatusBadRequest}
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+
+	// Only a slash delimiter follows the folders, so others need every key
+	entries, err := g.storage.ListObjectPaths(r.bucket, r.userID(), prefix, delimiter != "/")
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	result := &listObjectsResult{
+		Xmlns:          xmlNamespace,
+		Name:           r.bucketName(),
+		Prefix:         prefix,
+		Delimiter:      delimiter,
+		MaxKeys:        limit,
+		EncodingType:   query.Get("encoding-type"),
+		Contents:       []objectEntry{},
+		CommonPrefixes: []commonPrefix{},
+	}
+
+	last := ""
+	count := 0
+	for _, entry := range entries {
+		key := entry.Path
+		if key <= after {
+			continue
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if common == last || common == after {
+					continue
+				}
+				if count == limit {
+					result.IsTruncated = true
+					break
+				}
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: encodeKey(common, result.EncodingType)})
+				last = common
+				count++
+				continue
+			}
+		}
+
+		if count == limit {
+			result.IsTruncated = true
+			break
+		}
+		obj := entry.Object
+		etag := emptyETag
+		if obj.IsFile() {
+			etag = `"` + obj.Checksum + `"`
+		}
+		result.Contents = append(result.Contents, objectEntry{
+			Key:          encodeKey(key, result.EncodingType),
+			LastModified: formatTime(obj.UpdatedAt),
+			ETag:         etag,
+			Size:         obj.Size,
+			StorageClass: "STANDARD",
+		})
+		last = key
+		count++
+	}
+
+	if result.EncodingType == "url" {
+		result.Prefix = url.QueryEscape(result.Prefix)
+		result.Delimiter = url.QueryEscape(result.Delimiter)
+	}
+	return result, last, nil
+}
+
+// deleteObjects answers DeleteObjects, deleting each key as DeleteObject
+// does and reporting the outcome per key
+func (g *Gateway) deleteObjects(w http.ResponseWriter, r *request) {
+	body, _, err := r.body()
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxDeleteBody+1))
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	var request deleteRequest
+	if len(data) > maxDeleteBody || xml.Unmarshal(data, &request) != nil || len(request.Objects) == 0 || len(request.Objects) > maxKeys {
+		writeError(w, r.Request, errMalformedXML)
+		return
+	}
+
+	result := deleteResult{Xmlns: xmlNamespace}
+	for _, object := range request.Objects {
+		if err := g.deleteKey(r, object.Key); err != nil {
+			apiErr := toAPIError(err)
+			result.Errors = append(result.Errors, deleteErrorEntry{Key: object.Key, Code: apiErr.Code, Message: apiErr.Message})
+			continue
+		}
+		if !request.Quiet {
+			result.Deleted = append(result.Deleted, deletedEntry{Key: object.Key})
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// encodeKey encodes a key for a listing asked to be URL encoded
+func encodeKey(key, encodingType string) string {
+	if encodingType == "url" {
+		return url.QueryEscape(key)
+	}
+	return key
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timestampFormat)
+}
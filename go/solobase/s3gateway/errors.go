@@ -0,0 +1,119 @@
+package s3gateway
+
+import (
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/suppers-ai/solobase/services"
+	pkgstorage "github.com/suppers-ai/storage"
+)
+
+// apiError is an error with the code and status S3 answers it with
+type apiError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+var (
+	errAccessDenied          = &apiError{"AccessDenied", "Access Denied", http.StatusForbidden}
+	errInvalidAccessKeyID    = &apiError{"InvalidAccessKeyId", "The access key ID you provided does not exist in our records", http.StatusForbidden}
+	errSignatureDoesNotMatch = &apiError{"SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided", http.StatusForbidden}
+	errRequestTimeTooSkewed  = &apiError{"RequestTimeTooSkewed", "The difference between the request time and the server's time is too large", http.StatusForbidden}
+	errExpiredRequest        = &apiError{"AccessDenied", "Request has expired", http.StatusForbidden}
+	errMalformedAuth         = &apiError{"AuthorizationHeaderMalformed", "The authorization header is malformed", http.StatusBadRequest}
+	errUnsupportedAlgorithm  = &apiError{"InvalidArgument", "Only the AWS4-HMAC-SHA256 signature algorithm is supported", http.StatusBadRequest}
+	errMissingContentSHA256  = &apiError{"InvalidRequest", "Missing required header for this request: x-amz-content-sha256", http.StatusBadRequest}
+	errContentSHA256Mismatch = &apiError{"XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed", http.StatusBadRequest}
+	errBadDigest             = &apiError{"BadDigest", "The checksum you specified did not match what we received", http.StatusBadRequest}
+	errIncompleteBody        = &apiError{"IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header", http.StatusBadRequest}
+	errMalformedChunk        = &apiError{"IncompleteBody", "The chunked request body is malformed", http.StatusBadRequest}
+	errMissingContentLength  = &apiError{"MissingContentLength", "You must provide the Content-Length HTTP header", http.StatusLengthRequired}
+	errMalformedXML          = &apiError{"MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", http.StatusBadRequest}
+	errNoSuchBucket          = &apiError{"NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound}
+	errNoSuchKey             = &apiError{"NoSuchKey", "The specified key does not exist", http.StatusNotFound}
+	errNoSuchUpload          = &apiError{"NoSuchUpload", "The specified multipart upload does not exist", http.StatusNotFound}
+	errInvalidKey            = &apiError{"InvalidArgument", "Keys with empty, \".\" or \"..\" path segments are not supported", http.StatusBadRequest}
+	errInvalidPart           = &apiError{"InvalidPart", "One or more of the specified parts could not be found or did not match its ETag", http.StatusBadRequest}
+	errInvalidPartOrder      = &apiError{"InvalidPartOrder", "The list of parts was not in ascending order", http.StatusBadRequest}
+	errInvalidPartNumber     = &apiError{"InvalidArgument", "Part number must be an integer between 1 and 10000", http.StatusBadRequest}
+	errInvalidRange          = &apiError{"InvalidRange", "The requested range is not satisfiable", http.StatusRequestedRangeNotSatisfiable}
+	errEntityTooLarge        = &apiError{"EntityTooLarge", "Your proposed upload exceeds the maximum allowed size", http.StatusBadRequest}
+	errTypeNotAllowed        = &apiError{"AccessDenied", "File type is not allowed", http.StatusForbidden}
+	errFolderContent         = &apiError{"InvalidArgument", "Keys ending in a slash are folders and cannot have content", http.StatusBadRequest}
+	errNotImplemented        = &apiError{"NotImplemented", "A header or query you provided implies functionality that is not implemented", http.StatusNotImplemented}
+	errMethodNotAllowed      = &apiError{"MethodNotAllowed", "The specified method is not allowed against this resource", http.StatusMethodNotAllowed}
+	errInternal              = &apiError{"InternalError", "We encountered an internal error. Please try again.", http.StatusInternalServerError}
+)
+
+// errorResponse is the body of an S3 error response
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// toAPIError maps an error from the services or a request body reader onto
+// the S3 error it is answered with
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, services.ErrObjectNotFound):
+		return errNoSuchKey
+	case errors.Is(err, services.ErrMultipartUploadNotFound):
+		return errNoSuchUpload
+	case errors.Is(err, services.ErrInvalidObjectPath):
+		return errInvalidKey
+	case errors.Is(err, services.ErrInvalidPart):
+		return errInvalidPart
+	case errors.Is(err, services.ErrInvalidPartOrder):
+		return errInvalidPartOrder
+	case errors.Is(err, services.ErrFileTooLarge):
+		return errEntityTooLarge
+	case errors.Is(err, services.ErrFileTypeNotAllowed):
+		return errTypeNotAllowed
+	case errors.Is(err, pkgstorage.ErrSizeMismatch):
+		return errIncompleteBody
+	}
+	log.Printf("S3 gateway: %v", err)
+	return errInternal
+}
+
+// writeError responds with an S3 error
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := toAPIError(err)
+	if r.Method == http.MethodHead {
+		// Responses to HEAD requests have no body
+		w.WriteHeader(apiErr.Status)
+		return
+	}
+	writeXML(w, apiErr.Status, errorResponse{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		Resource:  r.URL.Path,
+		RequestID: w.Header().Get("x-amz-request-id"),
+	})
+}
+
+// writeXML responds with an XML document
+func writeXML(w http.ResponseWriter, status int, body interface{}) {
+	data, err := xml.Marshal(body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
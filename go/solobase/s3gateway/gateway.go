@@ -0,0 +1,349 @@
+// Package s3gateway serves the storage buckets over a subset of the Amazon S3
+// API, so S3 clients and tools can read and write files with an S3 credential.
+//
+// Requests are addressed path style, as /s3/<bucket>/<key>, and signed with
+// AWS Signature Version 4 using the access key ID and secret access key of
+// an S3 credential, which users create separately from their API keys. Keys
+// map onto the folders of a bucket, so "docs/report.pdf" is the file
+// report.pdf in the folder docs.
+package s3gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/services"
+	pkgstorage "github.com/suppers-ai/storage"
+)
+
+// PathPrefix is where the gateway is served
+const PathPrefix = "/s3"
+
+var errServiceUnavailable = &apiError{"ServiceUnavailable", "The service is down for maintenance", http.StatusServiceUnavailable}
+
+// Gateway answers S3 requests from the storage service
+type Gateway struct {
+	auth     *services.AuthService
+	storage  *services.StorageService
+	settings *services.SettingsService
+	orgs     *services.OrganizationService
+	rbac     *services.RBACService
+	hooks    *core.ExtensionRegistry
+}
+
+func NewGateway(authService *services.AuthService, storageService *services.StorageService, settingsService *services.SettingsService, orgService *services.OrganizationService, rbacService *services.RBACService, hooks *core.ExtensionRegistry) *Gateway {
+	return &Gateway{
+		auth:     authService,
+		storage:  storageService,
+		settings: settingsService,
+		orgs:     orgService,
+		rbac:     rbacService,
+		hooks:    hooks,
+	}
+}
+
+// RegisterRoutes serves the gateway under PathPrefix
+func (g *Gateway) RegisterRoutes(router *mux.Router) {
+	router.Handle(PathPrefix, g)
+	router.PathPrefix(PathPrefix + "/").Handler(g)
+}
+
+// request is an authenticated S3 request
+type request struct {
+	*http.Request
+	sig        *signature
+	key        []byte // Signing key, for the signatures of aws-chunked payloads
+	credential *models.S3Credential
+	user       *auth.User
+	bucket     string
+	object     string
+}
+
+// userID returns the ID of the user the request is made for
+func (r *request) userID() string {
+	return r.user.ID.String()
+}
+
+// bucketName returns the bucket as named in the request, before aliases are
+// resolved
+func (r *request) bucketName() string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, PathPrefix+"/"), "/")
+	return name
+}
+
+// body returns the checked content of the request body and its size, or -1
+// when it is not known
+func (r *request) body() (io.Reader, int64, error) {
+	return requestBody(r.Request, r.sig, r.key)
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("x-amz-request-id", newRequestID())
+	w.Header().Set("Server", "Solobase")
+
+	req, err := g.authenticate(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	r = r.WithContext(withUser(r.Context(), req.user, req.credential))
+	req.Request = r
+
+	if g.inMaintenance(req) {
+		writeError(w, r, errServiceUnavailable)
+		return
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, PathPrefix), "/")
+	req.bucket, req.object, _ = strings.Cut(path, "/")
+	if req.bucket == "user-files" {
+		req.bucket = "int_storage"
+	}
+
+	scope := "storage:write"
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		scope = "storage:read"
+	}
+	if !services.HasScope(req.credential.Scopes, scope) {
+		writeError(w, r, errAccessDenied)
+		return
+	}
+
+	if req.bucket == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, r, errMethodNotAllowed)
+			return
+		}
+		g.listBuckets(w, req)
+		return
+	}
+
+	minRole := services.OrgRoleMember
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		minRole = services.OrgRoleViewer
+	}
+	if err := g.checkBucketAccess(req, minRole); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if req.object == "" {
+		g.serveBucket(w, req)
+	} else {
+		g.serveObject(w, req)
+	}
+}
+
+// serveBucket dispatches the operations on a bucket
+func (g *Gateway) serveBucket(w http.ResponseWriter, r *request) {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		switch {
+		case query.Has("location"):
+			g.getBucketLocation(w, r)
+		case query.Has("uploads"):
+			g.listMultipartUploads(w, r)
+		case query.Get("list-type") == "2":
+			g.listObjectsV2(w, r)
+		case len(query) == 0 || query.Has("prefix") || query.Has("delimiter") || query.Has("marker") || query.Has("max-keys") || query.Has("encoding-type"):
+			g.listObjects(w, r)
+		default:
+			writeError(w, r.Request, errNotImplemented)
+		}
+	case http.MethodPost:
+		if query.Has("delete") {
+			g.deleteObjects(w, r)
+			return
+		}
+		writeError(w, r.Request, errNotImplemented)
+	default:
+		// Buckets are created and configured through the REST API
+		writeError(w, r.Request, errNotImplemented)
+	}
+}
+
+// serveObject dispatches the operations on an object
+func (g *Gateway) serveObject(w http.ResponseWriter, r *request) {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		if query.Has("uploadId") {
+			g.listParts(w, r)
+			return
+		}
+		g.getObject(w, r, true)
+	case http.MethodHead:
+		g.getObject(w, r, false)
+	case http.MethodPut:
+		switch {
+		case r.Header.Get("X-Amz-Copy-Source") != "":
+			writeError(w, r.Request, errNotImplemented)
+		case query.Has("partNumber") && query.Has("uploadId"):
+			g.uploadPart(w, r)
+		default:
+			g.putObject(w, r)
+		}
+	case http.MethodPost:
+		switch {
+		case query.Has("uploads"):
+			g.createMultipartUpload(w, r)
+		case query.Has("uploadId"):
+			g.completeMultipartUpload(w, r)
+		default:
+			writeError(w, r.Request, errNotImplemented)
+		}
+	case http.MethodDelete:
+		if query.Has("uploadId") {
+			g.abortMultipartUpload(w, r)
+			return
+		}
+		g.deleteObject(w, r)
+	default:
+		writeError(w, r.Request, errMethodNotAllowed)
+	}
+}
+
+// authenticate checks the signature of a request with the S3 credential it names
+func (g *Gateway) authenticate(r *http.Request) (*request, error) {
+	sig, err := parseSignature(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := sig.checkTime(time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	req := &request{Request: r, sig: sig}
+	req.credential, req.user, err = g.auth.AuthenticateAccessKey(sig.accessKeyID, func(secret string) error {
+		key, err := sig.verify(r, secret)
+		req.key = key
+		return err
+	})
+	if errors.Is(err, services.ErrInvalidAccessKey) {
+		return nil, errInvalidAccessKeyID
+	}
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// inMaintenance reports whether maintenance mode keeps the caller out. Those
+// who can manage settings still get through, as with the REST API.
+func (g *Gateway) inMaintenance(r *request) bool {
+	if !g.currentSettings().MaintenanceMode {
+		return false
+	}
+	return g.rbac == nil || !g.rbac.NewPermissionChecker(r.userID(), r.user.Role).Has(services.PermissionSettingsManage)
+}
+
+// checkBucketAccess checks that a bucket exists and, for an organization's
+// bucket, that the caller has at least the given role in it. Buckets of
+// organizations the caller does not belong to do not exist for them.
+func (g *Gateway) checkBucketAccess(r *request, minRole string) error {
+	bucket, err := g.storage.GetBucket(r.bucket)
+	if err != nil {
+		return errNoSuchBucket
+	}
+	if bucket.OrganizationID == nil {
+		return nil
+	}
+
+	role := g.orgRole(r, *bucket.OrganizationID)
+	switch {
+	case role == "":
+		return errNoSuchBucket
+	case !services.OrgRoleAtLeast(role, minRole):
+		return errAccessDenied
+	}
+	return nil
+}
+
+// orgRole returns the caller's role in an organization, or "" without access
+func (g *Gateway) orgRole(r *request, orgID string) string {
+	if g.orgs != nil {
+		if role, err := g.orgs.MemberRole(orgID, r.userID()); err == nil {
+			return role
+		}
+	}
+	if g.canManageOrganizations(r) {
+		return services.OrgRoleOwner
+	}
+	return ""
+}
+
+func (g *Gateway) canManageOrganizations(r *request) bool {
+	return g.rbac != nil && g.rbac.NewPermissionChecker(r.userID(), r.user.Role).Has(services.PermissionOrganizationsManage)
+}
+
+// visibleBuckets returns the buckets the caller can see, hiding those of
+// organizations they do not belong to
+func (g *Gateway) visibleBuckets(r *request) ([]pkgstorage.StorageBucket, error) {
+	buckets, err := g.storage.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+
+	roles := map[string]string{}
+	if g.orgs != nil {
+		if userRoles, err := g.orgs.UserOrganizationRoles(r.userID()); err == nil {
+			roles = userRoles
+		}
+	}
+	all := g.canManageOrganizations(r)
+
+	visible := make([]pkgstorage.StorageBucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		if bucket.OrganizationID != nil && roles[*bucket.OrganizationID] == "" && !all {
+			continue
+		}
+		visible = append(visible, bucket)
+	}
+	return visible, nil
+}
+
+func (g *Gateway) currentSettings() *models.AppSettings {
+	if g.settings != nil {
+		if settings, err := g.settings.GetSettings(); err == nil {
+			return settings
+		}
+	}
+	return models.DefaultSettings()
+}
+
+// withUser adds the authenticated user to a context under the keys the REST
+// API puts them under, so hooks and logging see who made the request. The
+// credential goes where the REST API puts the caller's API key.
+func withUser(ctx context.Context, user *auth.User, credential *models.S3Credential) context.Context {
+	ctx = context.WithValue(ctx, "user", user)
+	ctx = context.WithValue(ctx, "userID", user.ID.String())
+	ctx = context.WithValue(ctx, "user_id", user.ID.String())
+	ctx = context.WithValue(ctx, "user_role", user.Role)
+	ctx = context.WithValue(ctx, "apiKeyID", credential.ID)
+	ctx = context.WithValue(ctx, "apiKeyScopes", credential.Scopes)
+	if audit := services.RequestAuditFromContext(ctx); audit != nil {
+		audit.UserID = user.ID.String()
+	}
+	return ctx
+}
+
+// newRequestID returns an ID for the x-amz-request-id header, which clients
+// report with errors
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return strings.ToUpper(hex.EncodeToString(id))
+}
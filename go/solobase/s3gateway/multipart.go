@@ -0,0 +1,325 @@
+package s3gateway
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/suppers-ai/solobase/services"
+	pkgstorage "github.com/suppers-ai/storage"
+)
+
+const (
+	maxPartNumber = 10000
+	// maxCompleteBody limits the size of a CompleteMultipartUpload request,
+	// which lists at most maxPartNumber parts
+	maxCompleteBody = 2 << 20
+)
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+type partEntry struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+type listPartsResult struct {
+	XMLName              xml.Name    `xml:"ListPartsResult"`
+	Xmlns                string      `xml:"xmlns,attr"`
+	Bucket               string      `xml:"Bucket"`
+	Key                  string      `xml:"Key"`
+	UploadID             string      `xml:"UploadId"`
+	PartNumberMarker     int         `xml:"PartNumberMarker"`
+	NextPartNumberMarker int         `xml:"NextPartNumberMarker"`
+	MaxParts             int         `xml:"MaxParts"`
+	IsTruncated          bool        `xml:"IsTruncated"`
+	StorageClass         string      `xml:"StorageClass"`
+	Parts                []partEntry `xml:"Part"`
+}
+
+type uploadEntry struct {
+	Key          string `xml:"Key"`
+	UploadID     string `xml:"UploadId"`
+	Initiated    string `xml:"Initiated"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type listMultipartUploadsResult struct {
+	XMLName     xml.Name      `xml:"ListMultipartUploadsResult"`
+	Xmlns       string        `xml:"xmlns,attr"`
+	Bucket      string        `xml:"Bucket"`
+	Prefix      string        `xml:"Prefix"`
+	MaxUploads  int           `xml:"MaxUploads"`
+	IsTruncated bool          `xml:"IsTruncated"`
+	Uploads     []uploadEntry `xml:"Upload"`
+}
+
+// createMultipartUpload answers CreateMultipartUpload. The file type is
+// checked now, and its size once the parts are complete.
+func (g *Gateway) createMultipartUpload(w http.ResponseWriter, r *request) {
+	if strings.HasSuffix(r.object, "/") {
+		writeError(w, r.Request, errFolderContent)
+		return
+	}
+	contentType := objectContentType(r)
+	policy := services.UploadPolicyFromSettings(g.currentSettings())
+	if err := policy.CheckType(path.Base(r.object), contentType); err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+
+	upload, err := g.storage.CreateMultipartUpload(r.bucket, r.object, r.userID(), contentType, requestMetadata(r))
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    xmlNamespace,
+		Bucket:   r.bucketName(),
+		Key:      r.object,
+		UploadID: upload.ID,
+	})
+}
+
+// uploadPart answers UploadPart, replacing any part sent before with the
+// same number
+func (g *Gateway) uploadPart(w http.ResponseWriter, r *request) {
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		writeError(w, r.Request, errInvalidPartNumber)
+		return
+	}
+	upload, err := g.multipartUpload(r)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+
+	body, size, err := r.body()
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	if size < 0 {
+		writeError(w, r.Request, errMissingContentLength)
+		return
+	}
+	// A part larger than the limit cannot make a file within it
+	if err := services.UploadPolicyFromSettings(g.currentSettings()).CheckSize(size); err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+
+	part, err := g.storage.UploadPart(upload, partNumber, body, size)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+part.ETag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeMultipartUpload answers CompleteMultipartUpload, joining the listed
+// parts into the file at the upload's key
+func (g *Gateway) completeMultipartUpload(w http.ResponseWriter, r *request) {
+	upload, err := g.multipartUpload(r)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+
+	body, _, err := r.body()
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxCompleteBody+1))
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	var request completeMultipartUpload
+	if len(data) > maxCompleteBody || xml.Unmarshal(data, &request) != nil || len(request.Parts) == 0 {
+		writeError(w, r.Request, errMalformedXML)
+		return
+	}
+
+	// The size of the file is known from the parts stored so far
+	uploaded, err := g.storage.ListMultipartParts(upload)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	sizes := make(map[int]int64, len(uploaded))
+	for _, part := range uploaded {
+		sizes[part.PartNumber] = part.Size
+	}
+	parts := make([]pkgstorage.StorageMultipartPart, len(request.Parts))
+	var size int64
+	for i, part := range request.Parts {
+		parts[i] = pkgstorage.StorageMultipartPart{PartNumber: part.PartNumber, ETag: part.ETag}
+		size += sizes[part.PartNumber]
+	}
+
+	if err := g.checkUpload(w, r, upload.ContentType, size); err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+
+	obj, err := g.storage.CompleteMultipartUpload(upload, parts)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	g.runAfterUploadHooks(w, r, obj)
+
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:    xmlNamespace,
+		Location: r.URL.Path,
+		Bucket:   r.bucketName(),
+		Key:      r.object,
+		ETag:     `"` + obj.Checksum + `"`,
+	})
+}
+
+// abortMultipartUpload answers AbortMultipartUpload, discarding the parts
+func (g *Gateway) abortMultipartUpload(w http.ResponseWriter, r *request) {
+	upload, err := g.multipartUpload(r)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	if err := g.storage.AbortMultipartUpload(upload); err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listParts answers ListParts, paged by part number
+func (g *Gateway) listParts(w http.ResponseWriter, r *request) {
+	upload, err := g.multipartUpload(r)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	query := r.URL.Query()
+	marker, _ := strconv.Atoi(query.Get("part-number-marker"))
+	limit := maxKeys
+	if n, err := strconv.Atoi(query.Get("max-parts")); err == nil && n >= 0 && n < limit {
+		limit = n
+	}
+
+	parts, err := g.storage.ListMultipartParts(upload)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	result := listPartsResult{
+		Xmlns:            xmlNamespace,
+		Bucket:           r.bucketName(),
+		Key:              r.object,
+		UploadID:         upload.ID,
+		PartNumberMarker: marker,
+		MaxParts:         limit,
+		StorageClass:     "STANDARD",
+		Parts:            []partEntry{},
+	}
+	for _, part := range parts {
+		if part.PartNumber <= marker {
+			continue
+		}
+		if len(result.Parts) == limit {
+			result.IsTruncated = true
+			break
+		}
+		result.Parts = append(result.Parts, partEntry{
+			PartNumber:   part.PartNumber,
+			LastModified: formatTime(part.UpdatedAt),
+			ETag:         `"` + part.ETag + `"`,
+			Size:         part.Size,
+		})
+		result.NextPartNumberMarker = part.PartNumber
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// listMultipartUploads answers ListMultipartUploads with the caller's
+// unfinished uploads to the bucket
+func (g *Gateway) listMultipartUploads(w http.ResponseWriter, r *request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	limit := maxKeys
+	if n, err := strconv.Atoi(query.Get("max-uploads")); err == nil && n >= 0 && n < limit {
+		limit = n
+	}
+
+	uploads, err := g.storage.ListMultipartUploads(r.bucket, r.userID())
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	result := listMultipartUploadsResult{
+		Xmlns:      xmlNamespace,
+		Bucket:     r.bucketName(),
+		Prefix:     prefix,
+		MaxUploads: limit,
+		Uploads:    []uploadEntry{},
+	}
+	for _, upload := range uploads {
+		if !strings.HasPrefix(upload.Key, prefix) {
+			continue
+		}
+		if len(result.Uploads) == limit {
+			result.IsTruncated = true
+			break
+		}
+		result.Uploads = append(result.Uploads, uploadEntry{
+			Key:          upload.Key,
+			UploadID:     upload.ID,
+			Initiated:    formatTime(upload.CreatedAt),
+			StorageClass: "STANDARD",
+		})
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// multipartUpload returns the upload named by the request's uploadId, which
+// must be the caller's own and to the request's key
+func (g *Gateway) multipartUpload(r *request) (*pkgstorage.StorageMultipartUpload, error) {
+	upload, err := g.storage.GetMultipartUpload(r.bucket, r.URL.Query().Get("uploadId"))
+	if err != nil {
+		return nil, err
+	}
+	if upload.Key != r.object || upload.UserID != r.userID() {
+		return nil, errNoSuchUpload
+	}
+	return upload, nil
+}
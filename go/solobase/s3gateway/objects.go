@@ -0,0 +1,341 @@
+package s3gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/suppers-ai/solobase/extensions/core"
+	"github.com/suppers-ai/solobase/services"
+	pkgstorage "github.com/suppers-ai/storage"
+)
+
+const (
+	folderContentType = "application/x-directory"
+	// emptyETag is the ETag of no content, which folders are given
+	emptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+	// metadataPrefix starts the headers user metadata is sent in
+	metadataPrefix = "X-Amz-Meta-"
+)
+
+// getObject answers GetObject, and HeadObject without the content. Keys
+// ending in a slash are folders, which have no content.
+func (g *Gateway) getObject(w http.ResponseWriter, r *request, withContent bool) {
+	obj, err := g.storage.FindObjectByPath(r.bucket, r.userID(), r.object)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	setObjectHeaders(w, obj)
+	if obj.IsFolder() {
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	start, length, partial, err := parseRange(r.Header.Get("Range"), obj.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(obj.Size, 10))
+		writeError(w, r.Request, err)
+		return
+	}
+	status := http.StatusOK
+	if partial {
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", "bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(start+length-1, 10)+"/"+strconv.FormatInt(obj.Size, 10))
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if !withContent {
+		w.WriteHeader(status)
+		return
+	}
+
+	if g.hooks != nil {
+		hookCtx := &core.HookContext{
+			Request:  r.Request,
+			Response: w,
+			Data: map[string]interface{}{
+				"userID":   r.userID(),
+				"bucket":   r.bucket,
+				"objectID": obj.ID,
+			},
+			Services: nil,
+		}
+		if err := g.hooks.ExecuteHooks(r.Context(), core.HookBeforeDownload, hookCtx); err != nil {
+			writeError(w, r.Request, &apiError{"AccessDenied", err.Error(), http.StatusForbidden})
+			return
+		}
+	}
+
+	reader, _, _, err := g.storage.GetObject(r.bucket, obj.ID)
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	defer reader.Close()
+	if start > 0 {
+		if seeker, ok := reader.(io.Seeker); ok {
+			_, err = seeker.Seek(start, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, reader, start)
+		}
+		if err != nil {
+			writeError(w, r.Request, err)
+			return
+		}
+	}
+
+	w.WriteHeader(status)
+	written, err := io.CopyN(w, reader, length)
+	if err != nil {
+		// The headers are sent, so the client sees a short body
+		log.Printf("S3 gateway: streaming %s/%s: %v", r.bucket, r.object, err)
+	}
+
+	if g.hooks != nil {
+		hookCtx := &core.HookContext{
+			Request:  r.Request,
+			Response: w,
+			Data: map[string]interface{}{
+				"userID":    r.userID(),
+				"bucket":    r.bucket,
+				"objectID":  obj.ID,
+				"bytesRead": written,
+			},
+			Services: nil,
+		}
+		go g.hooks.ExecuteHooks(context.Background(), core.HookAfterDownload, hookCtx)
+	}
+}
+
+// putObject answers PutObject, storing the body at the key and creating the
+// folders on its way. A key ending in a slash creates a folder.
+func (g *Gateway) putObject(w http.ResponseWriter, r *request) {
+	body, size, err := r.body()
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+
+	if strings.HasSuffix(r.object, "/") {
+		if size != 0 {
+			writeError(w, r.Request, errFolderContent)
+			return
+		}
+		if _, err := g.storage.EnsureFolderPath(r.bucket, r.userID(), strings.Split(strings.TrimSuffix(r.object, "/"), "/")); err != nil {
+			writeError(w, r.Request, err)
+			return
+		}
+		w.Header().Set("ETag", emptyETag)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if size < 0 {
+		writeError(w, r.Request, errMissingContentLength)
+		return
+	}
+
+	contentType := objectContentType(r)
+	if err := g.checkUpload(w, r, contentType, size); err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+
+	obj, err := g.storage.PutObjectAtPath(r.bucket, r.object, r.userID(), body, size, contentType, requestMetadata(r))
+	if err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	g.runAfterUploadHooks(w, r, obj)
+
+	w.Header().Set("ETag", `"`+obj.Checksum+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteObject answers DeleteObject, moving the file or empty folder at the
+// key to the trash. Deleting a key that does not exist succeeds, as in S3.
+func (g *Gateway) deleteObject(w http.ResponseWriter, r *request) {
+	if err := g.deleteKey(r, r.object); err != nil {
+		writeError(w, r.Request, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteKey moves the object at a key to the trash. Folders are only deleted
+// once empty, as S3 has no folders to delete with their content.
+func (g *Gateway) deleteKey(r *request, key string) error {
+	obj, err := g.storage.FindObjectByPath(r.bucket, r.userID(), key)
+	if errors.Is(err, services.ErrObjectNotFound) || errors.Is(err, services.ErrInvalidObjectPath) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if obj.IsFolder() {
+		children, err := g.storage.ListObjectPaths(r.bucket, r.userID(), key, false)
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return nil
+		}
+	}
+	return g.storage.DeleteObject(r.bucket, obj.ID, r.userID())
+}
+
+// checkUpload applies the upload policy of the settings and the before
+// upload hooks to a file about to be stored
+func (g *Gateway) checkUpload(w http.ResponseWriter, r *request, contentType string, size int64) error {
+	policy := services.UploadPolicyFromSettings(g.currentSettings())
+	if err := policy.Check(path.Base(r.object), contentType, size); err != nil {
+		return err
+	}
+
+	if g.hooks == nil {
+		return nil
+	}
+	hookCtx := &core.HookContext{
+		Request:  r.Request,
+		Response: w,
+		Data: map[string]interface{}{
+			"userID":      r.userID(),
+			"bucket":      r.bucket,
+			"filename":    path.Base(r.object),
+			"fileSize":    size,
+			"contentType": contentType,
+		},
+		Services: nil,
+	}
+	if err := g.hooks.ExecuteHooks(r.Context(), core.HookBeforeUpload, hookCtx); err != nil {
+		return &apiError{"AccessDenied", err.Error(), http.StatusForbidden}
+	}
+	return nil
+}
+
+// runAfterUploadHooks runs the after upload hooks for a stored file
+func (g *Gateway) runAfterUploadHooks(w http.ResponseWriter, r *request, obj *pkgstorage.StorageObject) {
+	if g.hooks == nil {
+		return
+	}
+
+	hookCtx := &core.HookContext{
+		Request:  r.Request,
+		Response: w,
+		Data: map[string]interface{}{
+			"userID":   r.userID(),
+			"bucket":   r.bucket,
+			"objectID": obj.ID,
+			"filename": obj.ObjectName,
+			"fileSize": obj.Size,
+		},
+		Services: nil,
+	}
+
+	// Execute after upload hooks (async)
+	go g.hooks.ExecuteHooks(context.Background(), core.HookAfterUpload, hookCtx)
+}
+
+// setObjectHeaders sets the headers describing an object
+func setObjectHeaders(w http.ResponseWriter, obj *pkgstorage.StorageObject) {
+	header := w.Header()
+	header.Set("Last-Modified", obj.UpdatedAt.UTC().Format(http.TimeFormat))
+	if obj.IsFolder() {
+		header.Set("Content-Type", folderContentType)
+		header.Set("ETag", emptyETag)
+		return
+	}
+
+	header.Set("Content-Type", obj.ContentType)
+	header.Set("Accept-Ranges", "bytes")
+	if obj.Checksum != "" {
+		header.Set("ETag", `"`+obj.Checksum+`"`)
+	}
+	var metadata map[string]interface{}
+	if obj.Metadata != "" && json.Unmarshal([]byte(obj.Metadata), &metadata) == nil {
+		for name, value := range metadata {
+			if s, ok := value.(string); ok {
+				header.Set(metadataPrefix+name, s)
+			}
+		}
+	}
+}
+
+// objectContentType returns the content type a file is stored with: the one
+// sent, or else the one its extension implies
+func objectContentType(r *request) string {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(path.Ext(r.object)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// requestMetadata returns the user metadata sent in x-amz-meta-* headers as
+// JSON, or "" when there is none
+func requestMetadata(r *request) string {
+	metadata := map[string]string{}
+	for name, values := range r.Header {
+		if key, ok := strings.CutPrefix(name, metadataPrefix); ok && len(values) > 0 {
+			metadata[strings.ToLower(key)] = values[0]
+		}
+	}
+	if len(metadata) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// parseRange reads a Range header for a single byte range of content of the
+// given size. Without one, or with several, the whole content is returned.
+func parseRange(header string, size int64) (start, length int64, partial bool, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, size, false, nil
+	}
+
+	if first == "" {
+		// The last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false, errInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, errInvalidRange
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, errInvalidRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true, nil
+}
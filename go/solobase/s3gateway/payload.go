@@ -0,0 +1,333 @@
+package s3gateway
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// crc64NVMETable is for the CRC-64/NVME checksums S3 clients send, in the
+// reversed form hash/crc64 takes its polynomial in
+var crc64NVMETable = crc64.MakeTable(0x9a6c9329ac4bc9b5)
+
+// checksumAlgorithms make the hashes for the x-amz-checksum-* headers
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"x-amz-checksum-crc32":     func() hash.Hash { return crc32.NewIEEE() },
+	"x-amz-checksum-crc32c":    func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"x-amz-checksum-crc64nvme": func() hash.Hash { return crc64.New(crc64NVMETable) },
+	"x-amz-checksum-sha1":      sha1.New,
+	"x-amz-checksum-sha256":    sha256.New,
+}
+
+// requestBody returns the content of a request's body and its size, or -1
+// when it is not known. The content is checked as it is read against the
+// payload hash it was signed with and any checksums sent with it, and
+// reading fails at the end of a body that does not match. key is the
+// signing key, for the signatures of aws-chunked payloads.
+func requestBody(r *http.Request, sig *signature, key []byte) (io.Reader, int64, error) {
+	reader, size := io.Reader(r.Body), r.ContentLength
+	verifier := &verifyingReader{}
+
+	switch sig.payloadHash {
+	case unsignedPayload:
+	case streamingPayload, streamingPayloadTrailer, streamingUnsignedTrailer:
+		chunked := &chunkedReader{
+			r:         bufio.NewReader(r.Body),
+			sig:       sig,
+			key:       key,
+			signed:    sig.payloadHash != streamingUnsignedTrailer,
+			trailer:   sig.payloadHash != streamingPayload,
+			prev:      sig.value,
+			chunkHash: sha256.New(),
+		}
+		reader, verifier.trailers = chunked, chunked
+		size = -1
+		if decoded := r.Header.Get("X-Amz-Decoded-Content-Length"); decoded != "" {
+			n, err := strconv.ParseInt(decoded, 10, 64)
+			if err != nil || n < 0 {
+				return nil, 0, errMissingContentLength
+			}
+			size = n
+		}
+	default:
+		want, err := hex.DecodeString(sig.payloadHash)
+		if err != nil || len(want) != sha256.Size {
+			return nil, 0, errContentSHA256Mismatch
+		}
+		verifier.add(sha256.New(), "", hex.EncodeToString(want), hex.EncodeToString, errContentSHA256Mismatch)
+	}
+
+	if contentMD5 := r.Header.Get("Content-Md5"); contentMD5 != "" {
+		verifier.add(md5.New(), "", contentMD5, base64.StdEncoding.EncodeToString, errBadDigest)
+	}
+	for name, newHash := range checksumAlgorithms {
+		if want := r.Header.Get(name); want != "" {
+			verifier.add(newHash(), name, want, base64.StdEncoding.EncodeToString, errBadDigest)
+		}
+	}
+	for _, name := range strings.Split(r.Header.Get("X-Amz-Trailer"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if newHash, ok := checksumAlgorithms[name]; ok && verifier.trailers != nil {
+			verifier.add(newHash(), name, "", base64.StdEncoding.EncodeToString, errBadDigest)
+		}
+	}
+
+	if len(verifier.checks) == 0 {
+		return reader, size, nil
+	}
+	verifier.r = reader
+	return verifier, size, nil
+}
+
+// checksum is a hash of a body and the value it must end up with. An empty
+// value is sent in the trailer named header.
+type checksum struct {
+	hash   hash.Hash
+	header string
+	want   string
+	encode func([]byte) string
+	err    error
+}
+
+// verifyingReader hashes the content read through it and fails at its end
+// when a hash does not match
+type verifyingReader struct {
+	r        io.Reader
+	checks   []*checksum
+	trailers interface{ Trailer() http.Header }
+}
+
+func (v *verifyingReader) add(h hash.Hash, header, want string, encode func([]byte) string, err error) {
+	v.checks = append(v.checks, &checksum{hash: h, header: header, want: want, encode: encode, err: err})
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	for _, c := range v.checks {
+		c.hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		if verr := v.verify(); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) verify() error {
+	for _, c := range v.checks {
+		want := c.want
+		if want == "" && v.trailers != nil {
+			want = v.trailers.Trailer().Get(c.header)
+		}
+		if want == "" || c.encode(c.hash.Sum(nil)) != want {
+			return c.err
+		}
+	}
+	return nil
+}
+
+// chunkedReader decodes an aws-chunked body, checking the signature of each
+// chunk against the one before it, and reads the trailer after the last
+type chunkedReader struct {
+	r       *bufio.Reader
+	sig     *signature
+	key     []byte
+	signed  bool
+	trailer bool
+
+	prev      string // Signature of the previous chunk, or the seed signature
+	chunkSig  string
+	chunkHash hash.Hash
+	remaining int64
+	done      bool
+	err       error
+	trailers  http.Header
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	for !c.done && c.remaining == 0 {
+		if err := c.nextChunk(); err != nil {
+			c.err = err
+			return 0, err
+		}
+	}
+	if c.done {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.chunkHash.Write(p[:n])
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = errIncompleteBody
+	}
+	if err == nil && c.remaining == 0 {
+		err = c.endChunk()
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// Trailer returns the trailing headers, once the body has been read
+func (c *chunkedReader) Trailer() http.Header {
+	return c.trailers
+}
+
+// nextChunk reads the header of the next chunk, and the trailer when it is
+// the last
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine()
+	if err == io.EOF {
+		return errIncompleteBody
+	}
+	if err != nil {
+		return err
+	}
+	sizeField, params, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 {
+		return errMalformedChunk
+	}
+	c.chunkSig = ""
+	if name, value, ok := strings.Cut(params, "="); ok && strings.TrimSpace(name) == "chunk-signature" {
+		c.chunkSig = strings.TrimSpace(value)
+	}
+	if c.signed && c.chunkSig == "" {
+		return errMalformedChunk
+	}
+	c.chunkHash.Reset()
+	c.remaining = size
+	if size > 0 {
+		return nil
+	}
+
+	if err := c.checkChunkSignature(); err != nil {
+		return err
+	}
+	if err := c.readTrailer(); err != nil {
+		return err
+	}
+	c.done = true
+	return nil
+}
+
+// endChunk checks the signature of a chunk once its data is read, and the
+// line break that ends it
+func (c *chunkedReader) endChunk() error {
+	if err := c.checkChunkSignature(); err != nil {
+		return err
+	}
+	line, err := c.readLine()
+	if err == io.EOF {
+		return errIncompleteBody
+	}
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return errMalformedChunk
+	}
+	return nil
+}
+
+func (c *chunkedReader) checkChunkSignature() error {
+	if !c.signed {
+		return nil
+	}
+	stringToSign := strings.Join([]string{
+		signatureAlgorithm + "-PAYLOAD",
+		c.sig.amzDate,
+		c.sig.scope(),
+		c.prev,
+		emptySHA256,
+		hex.EncodeToString(c.chunkHash.Sum(nil)),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(c.chunkSig)) {
+		return errSignatureDoesNotMatch
+	}
+	c.prev = c.chunkSig
+	return nil
+}
+
+// readTrailer reads the headers after the last chunk up to an empty line,
+// checking their signature when the payload is signed
+func (c *chunkedReader) readTrailer() error {
+	c.trailers = make(http.Header)
+	var canonical bytes.Buffer
+	trailerSig := ""
+	for {
+		line, err := c.readLine()
+		if err == io.EOF || err == nil && line == "" {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !c.trailer {
+			return errMalformedChunk
+		}
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		if name == "x-amz-trailer-signature" {
+			trailerSig = value
+			continue
+		}
+		c.trailers.Add(name, value)
+		canonical.WriteString(name + ":" + value + "\n")
+	}
+
+	if !c.signed || !c.trailer {
+		return nil
+	}
+	stringToSign := strings.Join([]string{
+		signatureAlgorithm + "-TRAILER",
+		c.sig.amzDate,
+		c.sig.scope(),
+		c.prev,
+		sha256Hex(canonical.Bytes()),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(trailerSig)) {
+		return errSignatureDoesNotMatch
+	}
+	return nil
+}
+
+// readLine reads a line ending in CRLF, without it. Lines are short in
+// aws-chunked encoding, so a long one is malformed. io.EOF is returned at the
+// end of the body, which only the trailer may run up to.
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == io.EOF && len(line) == 0 {
+		return "", io.EOF
+	}
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return "", errMalformedChunk
+		}
+		return "", errIncompleteBody
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
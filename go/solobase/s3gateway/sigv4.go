@@ -0,0 +1,309 @@
+package s3gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signatureAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat      = "20060102T150405Z"
+	scopeTerminator    = "aws4_request"
+	signingService     = "s3"
+
+	// Values of x-amz-content-sha256 for payloads that are not signed as a
+	// whole. The streaming ones are sent in aws-chunked encoding.
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// maxClockSkew is how far the time a request was signed may be from ours
+	maxClockSkew = 15 * time.Minute
+	// maxPresignedExpiry is the longest a presigned URL can be valid
+	maxPresignedExpiry = 7 * 24 * time.Hour
+)
+
+// signature is the AWS Signature Version 4 of a request, from its
+// Authorization header or, for presigned URLs, its query
+type signature struct {
+	accessKeyID   string
+	date          string // Date of the credential scope, as yyyymmdd
+	region        string
+	signedHeaders []string
+	value         string
+	amzDate       string
+	signedAt      time.Time
+	payloadHash   string
+	presigned     bool
+	expires       time.Duration
+}
+
+// parseSignature reads the signature of a request. Requests that are not
+// signed are refused, as the gateway has no anonymous access.
+func parseSignature(r *http.Request) (*signature, error) {
+	query := r.URL.Query()
+	if query.Has("X-Amz-Algorithm") {
+		return parsePresignedSignature(query)
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, errAccessDenied
+	}
+	params, found := strings.CutPrefix(header, signatureAlgorithm+" ")
+	if !found {
+		return nil, errUnsupportedAlgorithm
+	}
+
+	sig := &signature{}
+	var credential, signedHeaders string
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			sig.value = value
+		}
+	}
+	if credential == "" || signedHeaders == "" || sig.value == "" {
+		return nil, errMalformedAuth
+	}
+	if err := sig.parseCredential(credential); err != nil {
+		return nil, err
+	}
+	sig.signedHeaders = strings.Split(signedHeaders, ";")
+
+	sig.amzDate = r.Header.Get("X-Amz-Date")
+	sig.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if sig.payloadHash == "" {
+		return nil, errMissingContentSHA256
+	}
+	return sig, sig.parseDate()
+}
+
+// parsePresignedSignature reads the signature from the query of a
+// presigned URL
+func parsePresignedSignature(query url.Values) (*signature, error) {
+	if query.Get("X-Amz-Algorithm") != signatureAlgorithm {
+		return nil, errUnsupportedAlgorithm
+	}
+
+	sig := &signature{
+		value:       query.Get("X-Amz-Signature"),
+		amzDate:     query.Get("X-Amz-Date"),
+		payloadHash: query.Get("X-Amz-Content-Sha256"),
+		presigned:   true,
+	}
+	if sig.payloadHash == "" {
+		sig.payloadHash = unsignedPayload
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignedExpiry {
+		return nil, errMalformedAuth
+	}
+	sig.expires = time.Duration(expires) * time.Second
+
+	signedHeaders := query.Get("X-Amz-SignedHeaders")
+	if sig.value == "" || signedHeaders == "" {
+		return nil, errMalformedAuth
+	}
+	if err := sig.parseCredential(query.Get("X-Amz-Credential")); err != nil {
+		return nil, err
+	}
+	sig.signedHeaders = strings.Split(signedHeaders, ";")
+	return sig, sig.parseDate()
+}
+
+// parseCredential reads an access key ID and its scope, in the form
+// <access key ID>/<date>/<region>/s3/aws4_request
+func (sig *signature) parseCredential(credential string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) < 5 {
+		return errMalformedAuth
+	}
+	n := len(parts)
+	if parts[n-2] != signingService || parts[n-1] != scopeTerminator {
+		return errMalformedAuth
+	}
+	sig.accessKeyID = strings.Join(parts[:n-4], "/")
+	sig.date, sig.region = parts[n-4], parts[n-3]
+	return nil
+}
+
+func (sig *signature) parseDate() error {
+	signedAt, err := time.Parse(amzDateFormat, sig.amzDate)
+	if err != nil || sig.amzDate[:8] != sig.date {
+		return errMalformedAuth
+	}
+	sig.signedAt = signedAt
+	return nil
+}
+
+// checkTime refuses requests signed too long ago or in the future, and
+// presigned URLs that have expired
+func (sig *signature) checkTime(now time.Time) error {
+	if sig.signedAt.After(now.Add(maxClockSkew)) {
+		return errRequestTimeTooSkewed
+	}
+	if sig.presigned {
+		if now.After(sig.signedAt.Add(sig.expires)) {
+			return errExpiredRequest
+		}
+		return nil
+	}
+	if now.Sub(sig.signedAt) > maxClockSkew {
+		return errRequestTimeTooSkewed
+	}
+	return nil
+}
+
+// scope returns the credential scope the request was signed for
+func (sig *signature) scope() string {
+	return strings.Join([]string{sig.date, sig.region, signingService, scopeTerminator}, "/")
+}
+
+// signingKey derives the key requests are signed with from a secret key
+func (sig *signature) signingKey(secret string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), sig.date)
+	for _, part := range []string{sig.region, signingService, scopeTerminator} {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+// stringToSign builds the string a request's signature is made from
+func (sig *signature) stringToSign(r *http.Request) (string, error) {
+	query, err := canonicalQuery(r.URL.RawQuery, sig.presigned)
+	if err != nil {
+		return "", errMalformedAuth
+	}
+
+	var headers strings.Builder
+	for _, name := range sig.signedHeaders {
+		headers.WriteString(name + ":" + canonicalHeaderValue(r, name) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		s3Escape(r.URL.Path, false),
+		query,
+		headers.String(),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+
+	return strings.Join([]string{
+		signatureAlgorithm,
+		sig.amzDate,
+		sig.scope(),
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n"), nil
+}
+
+// verify checks the signature against the one made with a secret key,
+// returning the signing key to check the signatures of payload chunks with
+func (sig *signature) verify(r *http.Request, secret string) ([]byte, error) {
+	stringToSign, err := sig.stringToSign(r)
+	if err != nil {
+		return nil, err
+	}
+	key := sig.signingKey(secret)
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(sig.value)) {
+		return nil, errSignatureDoesNotMatch
+	}
+	return key, nil
+}
+
+// canonicalQuery sorts and encodes the query parameters, leaving out the
+// signature of a presigned URL
+func canonicalQuery(rawQuery string, presigned bool) (string, error) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", err
+	}
+
+	type param struct{ name, value string }
+	var params []param
+	for name, list := range values {
+		if presigned && name == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range list {
+			params = append(params, param{s3Escape(name, true), s3Escape(value, true)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].name != params[j].name {
+			return params[i].name < params[j].name
+		}
+		return params[i].value < params[j].value
+	})
+
+	encoded := make([]string, len(params))
+	for i, p := range params {
+		encoded[i] = p.name + "=" + p.value
+	}
+	return strings.Join(encoded, "&"), nil
+}
+
+// canonicalHeaderValue returns the values of a signed header, trimmed and
+// joined. Go keeps the Host header and a known Content-Length outside of
+// the header map.
+func canonicalHeaderValue(r *http.Request, name string) string {
+	if name == "host" {
+		return r.Host
+	}
+	values := r.Header.Values(name)
+	if len(values) == 0 && name == "content-length" && r.ContentLength >= 0 {
+		return strconv.FormatInt(r.ContentLength, 10)
+	}
+	for i, value := range values {
+		values[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and
+// slashes unless encodeSlash is set, as SigV4 requires
+func s3Escape(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -1,38 +1,23 @@
 package services
 
 import (
-	"errors"
 	"log"
+	"time"
 	"github.com/google/uuid"
 	"github.com/suppers-ai/solobase/database"
 	auth "github.com/suppers-ai/auth"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type AuthService struct {
-	db *database.DB
+	db          *database.DB
+	revocations *revocationList
+	secrets     *SecretsService
 }
 
 func NewAuthService(db *database.DB) *AuthService {
-	return &AuthService{db: db}
-}
-
-func (s *AuthService) AuthenticateUser(email, password string) (*auth.User, error) {
-	var user auth.User
-	if err := s.db.Where("email = ?", email).First(&user).Error; err != nil {
-		log.Printf("User not found for email: %s, error: %v", email, err)
-		return nil, errors.New("invalid credentials")
-	}
-
-	log.Printf("Found user: %s with role: %s", user.Email, user.Role)
-	
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		log.Printf("Password comparison failed for user: %s, error: %v", email, err)
-		return nil, errors.New("invalid credentials")
-	}
-
-	log.Printf("Authentication successful for user: %s", email)
-	return &user, nil
+	return &AuthService{db: db, revocations: newRevocationList()}
 }
 
 func (s *AuthService) CreateUser(user *auth.User) error {
@@ -94,6 +79,26 @@ func (s *AuthService) CreateDefaultAdmin(email, password string) error {
 	return nil
 }
 
+// UpdateUserPassword stores a new password hash and revokes all of the user's sessions
 func (s *AuthService) UpdateUserPassword(userID, hashedPassword string) error {
-	return s.db.Model(&auth.User{}).Where("id = ?", userID).Update("password", hashedPassword).Error
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+
+	// The password only changes if the old sessions end with it
+	var sessionIDs []string
+	var expiresAt time.Time
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&auth.User{}).Where("id = ?", userID).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		sessionIDs, expiresAt, err = revokeUserSessions(tx, id, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.rememberRevocations(sessionIDs, expiresAt)
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// APIKeyPrefix starts every API key so it can be told apart from a JWT
+	APIKeyPrefix = "sb_"
+
+	// apiKeyLastUsedInterval limits how often last_used_at is written for a busy key
+	apiKeyLastUsedInterval = time.Minute
+)
+
+// API key scopes. Resource scopes take a :read or :write suffix, where write
+// also grants read; ext:<name> grants access to an extension's routes.
+const (
+	ScopeRead      = "read"
+	ScopeWrite     = "write"
+	ScopeExtPrefix = "ext:"
+)
+
+// APIKeyResources are the core API areas an API key can be scoped to
+var APIKeyResources = []string{"storage", "collections", "users", "database", "settings", "logs"}
+
+var (
+	// ErrInvalidAPIKey is returned for unknown, revoked or expired API keys
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrAPIKeyNotFound is returned when an API key does not exist for the user
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+var extensionScopePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidateAPIKeyScopes checks that every scope is a known resource scope or an extension scope
+func ValidateAPIKeyScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if name, ok := strings.CutPrefix(scope, ScopeExtPrefix); ok {
+			if !extensionScopePattern.MatchString(name) {
+				return fmt.Errorf("invalid extension scope %q", scope)
+			}
+			continue
+		}
+
+		resource, access, _ := strings.Cut(scope, ":")
+		if access != ScopeRead && access != ScopeWrite || !isAPIKeyResource(resource) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// HasScope reports whether the granted scopes cover the required one
+func HasScope(granted []string, required string) bool {
+	resource, access, _ := strings.Cut(required, ":")
+	for _, scope := range granted {
+		if scope == required {
+			return true
+		}
+		if access == ScopeRead && scope == resource+":"+ScopeWrite {
+			return true
+		}
+	}
+	return false
+}
+
+func isAPIKeyResource(resource string) bool {
+	for _, r := range APIKeyResources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey creates an API key for a user. The key is returned once and
+// only its hash is stored.
+func (s *AuthService) CreateAPIKey(userID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (*models.APIKey, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, "", errors.New("name is required")
+	}
+	if err := ValidateAPIKeyScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", errors.New("expiry must be in the future")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	key := APIKeyPrefix + hex.EncodeToString(secret)
+
+	apiKey := &models.APIKey{
+		ID:        uuid.New().String(),
+		UserID:    userID.String(),
+		Name:      strings.TrimSpace(name),
+		Prefix:    key[:len(APIKeyPrefix)+8],
+		KeyHash:   hashToken(key),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(apiKey).Error; err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+// ListAPIKeys returns a user's API keys, newest first
+func (s *AuthService) ListAPIKeys(userID uuid.UUID) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := s.db.Where("user_id = ?", userID.String()).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey deletes one of a user's API keys
+func (s *AuthService) RevokeAPIKey(userID uuid.UUID, id string) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID.String()).Delete(&models.APIKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey resolves an API key to its record and owner and records its use
+func (s *AuthService) AuthenticateAPIKey(key string) (*models.APIKey, *auth.User, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	var apiKey models.APIKey
+	if err := s.db.Where("key_hash = ?", hashToken(key)).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	if apiKey.IsExpired() {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.GetUserByID(apiKey.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	s.recordAPIKeyUse(&apiKey)
+	return &apiKey, user, nil
+}
+
+// recordAPIKeyUse updates when a key was last used, at most once a minute
+func (s *AuthService) recordAPIKeyUse(apiKey *models.APIKey) {
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyLastUsedInterval {
+		s.db.Model(&models.APIKey{}).Where("id = ?", apiKey.ID).Update("last_used_at", now)
+		apiKey.LastUsedAt = &now
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suppers-ai/solobase/models"
+)
+
+func newAPIKeyTestService(t *testing.T) *AuthService {
+	db := newTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.APIKey{}))
+	return NewAuthService(db)
+}
+
+func TestValidateAPIKeyScopes(t *testing.T) {
+	for _, scopes := range [][]string{
+		{"storage:read"},
+		{"collections:write", "users:read", "database:read", "settings:write", "logs:read"},
+		{"ext:products", "ext:web-hooks_2"},
+	} {
+		assert.NoError(t, ValidateAPIKeyScopes(scopes), "%v", scopes)
+	}
+
+	for _, scopes := range [][]string{
+		nil,
+		{"read"},
+		{"storage"},
+		{"storage:admin"},
+		{"billing:read"},
+		{"storage:read", "ext:"},
+		{"ext:Products"},
+		{"ext:../storage"},
+	} {
+		assert.Error(t, ValidateAPIKeyScopes(scopes), "%v", scopes)
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	granted := []string{"storage:write", "collections:read", "ext:products"}
+
+	assert.True(t, HasScope(granted, "storage:read"), "write implies read")
+	assert.True(t, HasScope(granted, "storage:write"))
+	assert.True(t, HasScope(granted, "collections:read"))
+	assert.False(t, HasScope(granted, "collections:write"), "read does not imply write")
+	assert.False(t, HasScope(granted, "users:read"))
+	assert.True(t, HasScope(granted, "ext:products"))
+	assert.False(t, HasScope(granted, "ext:webhooks"))
+	assert.False(t, HasScope(nil, "storage:read"))
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	authService := newAPIKeyTestService(t)
+	user := newTestUser(t, authService, "alice@example.com")
+
+	apiKey, raw, err := authService.CreateAPIKey(user.ID, " deploy ", []string{"storage:read"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", apiKey.Name)
+	assert.True(t, strings.HasPrefix(raw, APIKeyPrefix))
+	assert.Equal(t, raw[:len(apiKey.Prefix)], apiKey.Prefix)
+
+	// Only a hash of the key is stored
+	var stored models.APIKey
+	require.NoError(t, authService.db.Where("id = ?", apiKey.ID).First(&stored).Error)
+	assert.NotEqual(t, raw, stored.KeyHash)
+	assert.NotContains(t, stored.KeyHash, raw[len(APIKeyPrefix):])
+
+	found, owner, err := authService.AuthenticateAPIKey(raw)
+	require.NoError(t, err)
+	assert.Equal(t, apiKey.ID, found.ID)
+	assert.Equal(t, user.ID, owner.ID)
+	assert.Equal(t, []string{"storage:read"}, found.Scopes)
+	assert.NotNil(t, found.LastUsedAt)
+
+	for _, key := range []string{"", raw[len(APIKeyPrefix):], raw + "0", APIKeyPrefix + "unknown"} {
+		_, _, err := authService.AuthenticateAPIKey(key)
+		assert.ErrorIs(t, err, ErrInvalidAPIKey, key)
+	}
+
+	_, _, err = authService.CreateAPIKey(user.ID, "", []string{"storage:read"}, nil)
+	assert.Error(t, err)
+	_, _, err = authService.CreateAPIKey(user.ID, "deploy", []string{"storage:admin"}, nil)
+	assert.Error(t, err)
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	authService := newAPIKeyTestService(t)
+	user := newTestUser(t, authService, "alice@example.com")
+
+	past := time.Now().Add(-time.Minute)
+	_, _, err := authService.CreateAPIKey(user.ID, "old", []string{"storage:read"}, &past)
+	assert.Error(t, err, "keys cannot be created already expired")
+
+	future := time.Now().Add(time.Hour)
+	apiKey, raw, err := authService.CreateAPIKey(user.ID, "ci", []string{"storage:read"}, &future)
+	require.NoError(t, err)
+	_, _, err = authService.AuthenticateAPIKey(raw)
+	require.NoError(t, err)
+
+	require.NoError(t, authService.db.Model(&models.APIKey{}).Where("id = ?", apiKey.ID).Update("expires_at", past).Error)
+	_, _, err = authService.AuthenticateAPIKey(raw)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	authService := newAPIKeyTestService(t)
+	user := newTestUser(t, authService, "alice@example.com")
+	other := newTestUser(t, authService, "bob@example.com")
+
+	apiKey, raw, err := authService.CreateAPIKey(user.ID, "deploy", []string{"storage:read"}, nil)
+	require.NoError(t, err)
+	_, _, err = authService.CreateAPIKey(user.ID, "backup", []string{"storage:write"}, nil)
+	require.NoError(t, err)
+
+	keys, err := authService.ListAPIKeys(user.ID)
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+	keys, err = authService.ListAPIKeys(other.ID)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// A user can only revoke their own keys
+	assert.ErrorIs(t, authService.RevokeAPIKey(other.ID, apiKey.ID), ErrAPIKeyNotFound)
+	_, _, err = authService.AuthenticateAPIKey(raw)
+	require.NoError(t, err)
+
+	require.NoError(t, authService.RevokeAPIKey(user.ID, apiKey.ID))
+	_, _, err = authService.AuthenticateAPIKey(raw)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	assert.ErrorIs(t, authService.RevokeAPIKey(user.ID, apiKey.ID), ErrAPIKeyNotFound)
+	assert.ErrorIs(t, authService.RevokeAPIKey(user.ID, uuid.New().String()), ErrAPIKeyNotFound)
+}
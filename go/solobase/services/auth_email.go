@@ -0,0 +1,173 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/utils"
+	"gorm.io/gorm"
+)
+
+// PasswordResetTTL is how long a password reset link stays valid
+const PasswordResetTTL = time.Hour
+
+var (
+	// ErrEmailNotConfirmed is returned when an unconfirmed user logs in while confirmation is required
+	ErrEmailNotConfirmed = errors.New("email address is not confirmed")
+	// ErrInvalidConfirmToken is returned for unknown or already used confirmation links
+	ErrInvalidConfirmToken = errors.New("invalid or expired confirmation link")
+	// ErrInvalidResetToken is returned for unknown, used or expired password reset links
+	ErrInvalidResetToken = errors.New("invalid or expired password reset link")
+)
+
+// GetUserByEmail looks up a user by email address, ignoring case
+func (s *AuthService) GetUserByEmail(email string) (*auth.User, error) {
+	var user auth.User
+	if err := s.db.Where("LOWER(email) = ?", strings.ToLower(strings.TrimSpace(email))).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CheckEmailConfirmed returns ErrEmailNotConfirmed for a user who has not
+// confirmed their email address yet when the settings require it to log in
+func CheckEmailConfirmed(user *auth.User, settings *models.AppSettings) error {
+	if !user.Confirmed && settings != nil && settings.RequireEmailConfirmation {
+		return ErrEmailNotConfirmed
+	}
+	return nil
+}
+
+// CreateConfirmToken returns a new email confirmation token for the user,
+// replacing any earlier one
+func (s *AuthService) CreateConfirmToken(userID uuid.UUID) (string, error) {
+	selector, secret, token, err := newSelectorToken()
+	if err != nil {
+		return "", err
+	}
+
+	verifier := hashToken(secret)
+	err = s.db.Model(&auth.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"confirm_selector": selector,
+		"confirm_token":    verifier,
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConfirmEmail marks the user owning the confirmation token as confirmed
+func (s *AuthService) ConfirmEmail(token string) (*auth.User, error) {
+	user, err := s.userBySelectorToken(token, "confirm_selector", (*auth.User).GetConfirmVerifier)
+	if err != nil {
+		return nil, ErrInvalidConfirmToken
+	}
+
+	err = s.db.Model(&auth.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"confirmed":        true,
+		"confirm_selector": nil,
+		"confirm_token":    nil,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	user.Confirmed = true
+	return user, nil
+}
+
+// CreatePasswordResetToken returns a password reset token for the user that
+// expires after PasswordResetTTL, replacing any earlier one
+func (s *AuthService) CreatePasswordResetToken(userID uuid.UUID) (string, error) {
+	selector, secret, token, err := newSelectorToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.Model(&auth.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"recover_selector":  selector,
+		"recover_token":     hashToken(secret),
+		"recover_token_exp": time.Now().Add(PasswordResetTTL),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResetPassword sets a new password for the owner of a password reset token.
+// The token is single use, all sessions are revoked, and the email counts as
+// confirmed since the user received the link.
+func (s *AuthService) ResetPassword(token, hashedPassword string) (*auth.User, error) {
+	user, err := s.userBySelectorToken(token, "recover_selector", (*auth.User).GetRecoverVerifier)
+	if err != nil || time.Now().After(user.GetRecoverExpiry()) {
+		return nil, ErrInvalidResetToken
+	}
+
+	var sessionIDs []string
+	var expiresAt time.Time
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Clearing the selector in the same statement keeps the token from being used twice
+		result := tx.Model(&auth.User{}).
+			Where("id = ? AND recover_selector = ?", user.ID, user.GetRecoverSelector()).
+			Updates(map[string]interface{}{
+				"password":          hashedPassword,
+				"confirmed":         true,
+				"recover_selector":  nil,
+				"recover_token":     nil,
+				"recover_token_exp": nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+
+		var err error
+		sessionIDs, expiresAt, err = revokeUserSessions(tx, user.ID, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.rememberRevocations(sessionIDs, expiresAt)
+	user.Password = hashedPassword
+	user.Confirmed = true
+	return user, nil
+}
+
+// userBySelectorToken finds the user whose selector column matches the token's
+// selector and checks the secret against the stored verifier
+func (s *AuthService) userBySelectorToken(token, selectorColumn string, verifier func(*auth.User) string) (*auth.User, error) {
+	selector, secret, ok := strings.Cut(token, ".")
+	if !ok || selector == "" || secret == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var user auth.User
+	if err := s.db.Where(selectorColumn+" = ?", selector).First(&user).Error; err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(verifier(&user)), []byte(hashToken(secret))) != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &user, nil
+}
+
+// newSelectorToken returns a token of the form selector.secret. The selector
+// is stored to find the user and only a hash of the secret is kept.
+func newSelectorToken() (selector, secret, token string, err error) {
+	if selector, err = utils.GenerateSecureToken(16); err != nil {
+		return "", "", "", err
+	}
+	if secret, err = utils.GenerateSecureToken(32); err != nil {
+		return "", "", "", err
+	}
+	return selector, secret, selector + "." + secret, nil
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	auth "github.com/suppers-ai/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestConfirmEmail(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+
+	first, err := authService.CreateConfirmToken(user.ID)
+	require.NoError(t, err)
+	token, err := authService.CreateConfirmToken(user.ID)
+	require.NoError(t, err)
+
+	// A new link replaces the previous one
+	_, err = authService.ConfirmEmail(first)
+	assert.ErrorIs(t, err, ErrInvalidConfirmToken)
+	selector, _, _ := strings.Cut(token, ".")
+	_, err = authService.ConfirmEmail(selector + ".wrong-secret")
+	assert.ErrorIs(t, err, ErrInvalidConfirmToken)
+
+	confirmed, err := authService.ConfirmEmail(token)
+	require.NoError(t, err)
+	assert.True(t, confirmed.Confirmed)
+	assert.True(t, reloadUser(t, authService, user).Confirmed)
+
+	_, err = authService.ConfirmEmail(token)
+	assert.ErrorIs(t, err, ErrInvalidConfirmToken)
+}
+
+func TestResetPassword(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+	session, refreshToken, err := authService.CreateSession(user.ID, "", "", SessionPolicy{})
+	require.NoError(t, err)
+
+	token, err := authService.CreatePasswordResetToken(user.ID)
+	require.NoError(t, err)
+	hashed, err := bcrypt.GenerateFromPassword([]byte("new-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	reset, err := authService.ResetPassword(token, string(hashed))
+	require.NoError(t, err)
+	assert.True(t, reset.Confirmed)
+	_, err = authService.AuthenticateUser("alice@example.com", "new-password", "10.0.0.1", LockoutPolicy{})
+	require.NoError(t, err)
+
+	// The link works once
+	_, err = authService.ResetPassword(token, "another-hash")
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+
+	// Everyone signed in with the old password is signed out
+	assert.True(t, authService.IsSessionRevoked(session.ID))
+	_, _, err = authService.RefreshSession(refreshToken, "", "", SessionPolicy{})
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+func TestResetPasswordExpires(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+
+	token, err := authService.CreatePasswordResetToken(user.ID)
+	require.NoError(t, err)
+	require.NoError(t, authService.db.Model(&auth.User{}).Where("id = ?", user.ID).
+		Update("recover_token_exp", time.Now().Add(-time.Minute)).Error)
+
+	_, err = authService.ResetPassword(token, "new-hash")
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+	assert.Equal(t, user.Password, reloadUser(t, authService, user).Password)
+}
+
+func TestUnconfirmedEmailBlocksLogin(t *testing.T) {
+	settingsService := newTestSettingsService(t)
+	authService := NewAuthService(settingsService.db)
+	user := newTestUser(t, authService, "alice@example.com")
+
+	settings, err := settingsService.GetSettings()
+	require.NoError(t, err)
+	require.NoError(t, CheckEmailConfirmed(user, settings))
+
+	_, err = settingsService.UpdateSettings(map[string]interface{}{"require_email_confirmation": true})
+	require.NoError(t, err)
+	settings, err = settingsService.GetSettings()
+	require.NoError(t, err)
+	assert.ErrorIs(t, CheckEmailConfirmed(user, settings), ErrEmailNotConfirmed)
+
+	token, err := authService.CreateConfirmToken(user.ID)
+	require.NoError(t, err)
+	_, err = authService.ConfirmEmail(token)
+	require.NoError(t, err)
+	assert.NoError(t, CheckEmailConfirmed(reloadUser(t, authService, user), settings))
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// TokenTypeAccountUnlock is the token in the unlock link emailed when an account is locked
+	TokenTypeAccountUnlock = "account_unlock"
+
+	// AccountUnlockTTL is how long the emailed unlock link works
+	AccountUnlockTTL = 24 * time.Hour
+
+	// maxLockoutDuration caps the doubling lockout time. Failures older than
+	// this are forgotten.
+	maxLockoutDuration = 24 * time.Hour
+)
+
+// Reasons a login failed, reported to post_login hooks
+const (
+	LoginFailureInvalidCredentials  = "invalid_credentials"
+	LoginFailureInvalidSecondFactor = "invalid_second_factor"
+	LoginFailureAccountLocked       = "account_locked"
+	LoginFailureClientLocked        = "client_locked"
+)
+
+// ErrInvalidUnlockToken is returned for unknown, used or expired unlock links
+var ErrInvalidUnlockToken = errors.New("invalid or expired unlock link")
+
+var (
+	// dummyPasswordHash is compared against when no account has the email, so
+	// the response time does not reveal which accounts exist
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+// LockoutPolicy controls when repeated login failures lock an account or a
+// client out. A threshold of 0 disables that kind of lockout.
+type LockoutPolicy struct {
+	AccountThreshold int
+	ClientThreshold  int
+	// Duration is the first lockout. Each further failure doubles it, up to a day.
+	Duration time.Duration
+}
+
+// LockoutPolicyFromSettings builds the policy from the app settings
+func LockoutPolicyFromSettings(settings *models.AppSettings) LockoutPolicy {
+	return LockoutPolicy{
+		AccountThreshold: settings.LockoutThreshold,
+		ClientThreshold:  settings.LockoutIPThreshold,
+		Duration:         time.Duration(settings.LockoutDuration) * time.Minute,
+	}
+}
+
+// lockedFor returns how long failures past threshold lock out for, or 0
+func (p LockoutPolicy) lockedFor(failures, threshold int) time.Duration {
+	if threshold <= 0 || failures < threshold || p.Duration <= 0 {
+		return 0
+	}
+	duration := p.Duration
+	for i := threshold; i < failures && duration < maxLockoutDuration; i++ {
+		duration *= 2
+	}
+	if duration > maxLockoutDuration {
+		duration = maxLockoutDuration
+	}
+	return duration
+}
+
+// accountLockedUntil returns when the user's lockout ends, or the zero time if not locked
+func (p LockoutPolicy) accountLockedUntil(user *auth.User) time.Time {
+	if user.LastAttempt == nil {
+		return time.Time{}
+	}
+	duration := p.lockedFor(user.AttemptCount, p.AccountThreshold)
+	if duration == 0 {
+		return time.Time{}
+	}
+	return user.LastAttempt.Add(duration)
+}
+
+// LoginFailedError describes a failed password or second factor login
+type LoginFailedError struct {
+	Reason string
+	// User is the account the email belongs to, if any
+	User *auth.User
+	// LockedUntil is set while the account or client is locked out
+	LockedUntil time.Time
+	// NewlyLocked is set when this attempt locked the account. Attempts are
+	// not counted while locked, so each failure past the threshold starts a new lockout.
+	NewlyLocked bool
+}
+
+func (e *LoginFailedError) Error() string {
+	if e.Locked() {
+		return "too many failed login attempts"
+	}
+	return "invalid credentials"
+}
+
+// Locked reports whether the login was refused because of a lockout
+func (e *LoginFailedError) Locked() bool {
+	return e.Reason == LoginFailureAccountLocked || e.Reason == LoginFailureClientLocked
+}
+
+// AuthenticateUser checks an email and password from the client at ip.
+// Failures are counted for the account and for the account and client
+// together, and past the policy thresholds the login is refused without
+// checking the password. Failed logins return a *LoginFailedError. A right
+// password does not clear the failures, as the login may still need a second
+// factor; ResetLoginFailures does once every step has passed.
+func (s *AuthService) AuthenticateUser(email, password, ip string, policy LockoutPolicy) (*auth.User, error) {
+	now := time.Now()
+	key := strings.ToLower(strings.TrimSpace(email))
+
+	var attempt models.LoginAttempt
+	err := s.db.Where("email = ? AND ip_address = ?", key, ip).First(&attempt).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var user *auth.User
+	var found auth.User
+	err = s.db.Where("email = ?", email).First(&found).Error
+	switch {
+	case err == nil:
+		user = &found
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	if attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil) {
+		return nil, &LoginFailedError{Reason: LoginFailureClientLocked, User: user, LockedUntil: *attempt.LockedUntil}
+	}
+	if user != nil {
+		if until := policy.accountLockedUntil(user); now.Before(until) {
+			return nil, &LoginFailedError{Reason: LoginFailureAccountLocked, User: user, LockedUntil: until}
+		}
+	}
+
+	if user == nil {
+		dummyPasswordHashOnce.Do(func() {
+			dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, s.recordLoginFailure(nil, &attempt, LoginFailureInvalidCredentials, key, ip, policy, now)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, s.recordLoginFailure(user, &attempt, LoginFailureInvalidCredentials, key, ip, policy, now)
+	}
+	return user, nil
+}
+
+// RecordSecondFactorFailure counts a wrong second factor from the client at
+// ip like a wrong password, and returns the *LoginFailedError for it
+func (s *AuthService) RecordSecondFactorFailure(user *auth.User, ip string, policy LockoutPolicy) error {
+	key := strings.ToLower(strings.TrimSpace(user.Email))
+	var attempt models.LoginAttempt
+	err := s.db.Where("email = ? AND ip_address = ?", key, ip).First(&attempt).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return s.recordLoginFailure(user, &attempt, LoginFailureInvalidSecondFactor, key, ip, policy, time.Now())
+}
+
+// ResetLoginFailures forgets the failures counted for a user and for the user
+// and the client at ip, once they have passed every login step
+func (s *AuthService) ResetLoginFailures(user *auth.User, ip string) error {
+	err := s.db.Model(&auth.User{}).Where("id = ? AND (attempt_count > 0 OR last_attempt IS NOT NULL)", user.ID).
+		Updates(map[string]interface{}{"attempt_count": 0, "last_attempt": nil}).Error
+	if err != nil {
+		return err
+	}
+	user.AttemptCount, user.LastAttempt = 0, nil
+	key := strings.ToLower(strings.TrimSpace(user.Email))
+	return s.db.Where("email = ? AND ip_address = ?", key, ip).Delete(&models.LoginAttempt{}).Error
+}
+
+// recordLoginFailure counts a failed login step and starts any lockout it causes
+func (s *AuthService) recordLoginFailure(user *auth.User, attempt *models.LoginAttempt, reason, key, ip string, policy LockoutPolicy, now time.Time) error {
+	failure := &LoginFailedError{Reason: reason, User: user}
+
+	if attempt.ID == "" {
+		*attempt = models.LoginAttempt{ID: uuid.New().String(), Email: key, IPAddress: ip}
+	}
+	if now.Sub(attempt.LastFailedAt) > maxLockoutDuration {
+		attempt.FailedCount = 0
+	}
+	attempt.FailedCount++
+	attempt.LastFailedAt = now
+	attempt.LockedUntil = nil
+	if duration := policy.lockedFor(attempt.FailedCount, policy.ClientThreshold); duration > 0 {
+		until := now.Add(duration)
+		attempt.LockedUntil = &until
+		failure.LockedUntil = until
+	}
+	if err := s.db.Save(attempt).Error; err != nil {
+		return err
+	}
+
+	if user == nil {
+		return failure
+	}
+
+	count := user.AttemptCount
+	if user.LastAttempt != nil && now.Sub(*user.LastAttempt) > maxLockoutDuration {
+		count = 0
+	}
+	user.AttemptCount, user.LastAttempt = count+1, &now
+	err := s.db.Model(user).Updates(map[string]interface{}{"attempt_count": user.AttemptCount, "last_attempt": now}).Error
+	if err != nil {
+		return err
+	}
+	if until := policy.accountLockedUntil(user); !until.IsZero() {
+		failure.LockedUntil = until
+		failure.NewlyLocked = true
+	}
+	return failure
+}
+
+// LockedAccount is a user locked out by failed logins
+type LockedAccount struct {
+	UserID         string    `json:"user_id"`
+	Email          string    `json:"email"`
+	FailedAttempts int       `json:"failed_attempts"`
+	LastAttempt    time.Time `json:"last_attempt"`
+	LockedUntil    time.Time `json:"locked_until"`
+}
+
+// ListLockedAccounts returns the accounts and the client lockouts currently in effect
+func (s *AuthService) ListLockedAccounts(policy LockoutPolicy) ([]LockedAccount, []models.LoginAttempt, error) {
+	now := time.Now()
+	accounts := []LockedAccount{}
+	if policy.AccountThreshold > 0 {
+		var users []auth.User
+		err := s.db.Where("attempt_count >= ? AND last_attempt IS NOT NULL", policy.AccountThreshold).
+			Order("last_attempt DESC").Find(&users).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range users {
+			if until := policy.accountLockedUntil(&users[i]); now.Before(until) {
+				accounts = append(accounts, LockedAccount{
+					UserID:         users[i].ID.String(),
+					Email:          users[i].Email,
+					FailedAttempts: users[i].AttemptCount,
+					LastAttempt:    *users[i].LastAttempt,
+					LockedUntil:    until,
+				})
+			}
+		}
+	}
+
+	clients := []models.LoginAttempt{}
+	err := s.db.Where("locked_until > ?", now).Order("locked_until DESC").Find(&clients).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return accounts, clients, nil
+}
+
+// UnlockAccount clears the failed logins of a user, including those counted per client
+func (s *AuthService) UnlockAccount(userID string) (*auth.User, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{"attempt_count": 0, "last_attempt": nil}).Error; err != nil {
+			return err
+		}
+		return tx.Where("email = ?", strings.ToLower(user.Email)).Delete(&models.LoginAttempt{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.AttemptCount, user.LastAttempt = 0, nil
+	return user, nil
+}
+
+// CreateUnlockToken returns a single-use token for the emailed unlock link
+func (s *AuthService) CreateUnlockToken(userID uuid.UUID) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND type = ?", userID, TokenTypeAccountUnlock).Delete(&auth.Token{}).Error; err != nil {
+			return err
+		}
+		return tx.Omit("User").Create(&auth.Token{
+			UserID:    userID,
+			Token:     hashToken(token),
+			Type:      TokenTypeAccountUnlock,
+			ExpiresAt: time.Now().Add(AccountUnlockTTL),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// UnlockWithToken unlocks the account an unlock link was sent to
+func (s *AuthService) UnlockWithToken(token string) (*auth.User, error) {
+	user, err := s.consumeUserToken(token, TokenTypeAccountUnlock)
+	if errors.Is(err, errTokenNotFound) {
+		return nil, ErrInvalidUnlockToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.UnlockAccount(user.ID.String())
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func failLogin(t *testing.T, authService *AuthService, email, ip string, policy LockoutPolicy) *LoginFailedError {
+	_, err := authService.AuthenticateUser(email, "wrong-password", ip, policy)
+	var failure *LoginFailedError
+	require.ErrorAs(t, err, &failure)
+	return failure
+}
+
+func TestLockoutPolicyDoublesDuration(t *testing.T) {
+	policy := LockoutPolicy{AccountThreshold: 3, Duration: 15 * time.Minute}
+	assert.Zero(t, policy.lockedFor(2, 3))
+	assert.Equal(t, 15*time.Minute, policy.lockedFor(3, 3))
+	assert.Equal(t, 30*time.Minute, policy.lockedFor(4, 3))
+	assert.Equal(t, 60*time.Minute, policy.lockedFor(5, 3))
+	assert.Equal(t, maxLockoutDuration, policy.lockedFor(50, 3))
+	assert.Zero(t, policy.lockedFor(50, 0))
+}
+
+func TestClientLockedOutBeforeAccount(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "victim@example.com")
+	policy := LockoutPolicy{AccountThreshold: 5, ClientThreshold: 3, Duration: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, LoginFailureInvalidCredentials, failLogin(t, authService, user.Email, "10.0.0.1", policy).Reason)
+	}
+	third := failLogin(t, authService, user.Email, "10.0.0.1", policy)
+	assert.False(t, third.LockedUntil.IsZero())
+	assert.False(t, third.NewlyLocked, "only the client is locked out")
+
+	// The right password is refused from the locked out address...
+	_, err := authService.AuthenticateUser(user.Email, "correct-password", "10.0.0.1", policy)
+	var failure *LoginFailedError
+	require.ErrorAs(t, err, &failure)
+	assert.Equal(t, LoginFailureClientLocked, failure.Reason)
+
+	// ...but not from anywhere else. The failures are kept until the login
+	// has passed every step.
+	loggedIn, err := authService.AuthenticateUser(user.Email, "correct-password", "10.0.0.2", policy)
+	require.NoError(t, err)
+	assert.Equal(t, 3, loggedIn.AttemptCount)
+	require.NoError(t, authService.ResetLoginFailures(loggedIn, "10.0.0.2"))
+	assert.Zero(t, reloadUser(t, authService, user).AttemptCount)
+}
+
+func TestSecondFactorFailuresAreCounted(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+	policy := LockoutPolicy{AccountThreshold: 3, ClientThreshold: 0, Duration: time.Hour}
+
+	// A right password followed by wrong codes still locks the account
+	for i := 0; i < 2; i++ {
+		_, err := authService.AuthenticateUser(user.Email, "correct-password", "10.0.0.1", policy)
+		require.NoError(t, err)
+		err = authService.RecordSecondFactorFailure(user, "10.0.0.1", policy)
+		var failure *LoginFailedError
+		require.ErrorAs(t, err, &failure)
+		assert.Equal(t, LoginFailureInvalidSecondFactor, failure.Reason)
+		assert.False(t, failure.Locked())
+	}
+	err := authService.RecordSecondFactorFailure(user, "10.0.0.1", policy)
+	var failure *LoginFailedError
+	require.ErrorAs(t, err, &failure)
+	assert.True(t, failure.NewlyLocked)
+
+	_, err = authService.AuthenticateUser(user.Email, "correct-password", "10.0.0.1", policy)
+	require.ErrorAs(t, err, &failure)
+	assert.Equal(t, LoginFailureAccountLocked, failure.Reason)
+}
+
+func TestAccountLockoutAndUnlock(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "locked@example.com")
+	policy := LockoutPolicy{AccountThreshold: 3, ClientThreshold: 0, Duration: time.Hour}
+
+	// Failures from different addresses add up on the account
+	failLogin(t, authService, user.Email, "10.0.0.1", policy)
+	failLogin(t, authService, user.Email, "10.0.0.2", policy)
+	third := failLogin(t, authService, user.Email, "10.0.0.3", policy)
+	assert.True(t, third.NewlyLocked)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), third.LockedUntil, time.Minute)
+
+	_, err := authService.AuthenticateUser(user.Email, "correct-password", "10.0.0.4", policy)
+	var failure *LoginFailedError
+	require.ErrorAs(t, err, &failure)
+	assert.Equal(t, LoginFailureAccountLocked, failure.Reason)
+
+	accounts, _, err := authService.ListLockedAccounts(policy)
+	require.NoError(t, err)
+	require.Len(t, accounts, 1)
+	assert.Equal(t, user.Email, accounts[0].Email)
+	assert.Equal(t, 3, accounts[0].FailedAttempts)
+
+	token, err := authService.CreateUnlockToken(user.ID)
+	require.NoError(t, err)
+	_, err = authService.UnlockWithToken(token)
+	require.NoError(t, err)
+	_, err = authService.UnlockWithToken(token)
+	assert.ErrorIs(t, err, ErrInvalidUnlockToken)
+
+	_, err = authService.AuthenticateUser(user.Email, "correct-password", "10.0.0.4", policy)
+	assert.NoError(t, err)
+}
+
+func TestUnknownEmailIsCountedPerClient(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	policy := LockoutPolicy{AccountThreshold: 3, ClientThreshold: 2, Duration: time.Minute}
+
+	first := failLogin(t, authService, "nobody@example.com", "10.0.0.1", policy)
+	assert.Nil(t, first.User)
+	failLogin(t, authService, "Nobody@example.com", "10.0.0.1", policy)
+	assert.Equal(t, LoginFailureClientLocked, failLogin(t, authService, "nobody@example.com", "10.0.0.1", policy).Reason)
+
+	_, clients, err := authService.ListLockedAccounts(policy)
+	require.NoError(t, err)
+	require.Len(t, clients, 1)
+	assert.Equal(t, "nobody@example.com", clients[0].Email)
+}
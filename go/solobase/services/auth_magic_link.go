@@ -0,0 +1,146 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// TokenTypeMagicLink is the token in an emailed passwordless login link
+	TokenTypeMagicLink = "magic_link"
+
+	// MagicLinkTTL is how long a login link stays valid
+	MagicLinkTTL = 15 * time.Minute
+)
+
+// ErrInvalidMagicLink is returned for unknown, used or expired login links
+var ErrInvalidMagicLink = errors.New("invalid or expired login link")
+
+// CreateMagicLinkToken returns a single-use token for an emailed login link,
+// replacing any earlier link to the same address. A user that is not saved
+// yet, as MagicLinkUser returns for a new address, gets a link that creates
+// the account when it is opened.
+func (s *AuthService) CreateMagicLinkToken(user *auth.User) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if user.ID == uuid.Nil {
+			email := strings.TrimSpace(user.Email)
+			if err := tx.Where("LOWER(email) = ?", strings.ToLower(email)).Delete(&models.MagicLinkSignup{}).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.MagicLinkSignup{
+				Token:     hashToken(token),
+				Email:     email,
+				ExpiresAt: time.Now().Add(MagicLinkTTL),
+			}).Error
+		}
+
+		if err := tx.Where("user_id = ? AND type = ?", user.ID, TokenTypeMagicLink).Delete(&auth.Token{}).Error; err != nil {
+			return err
+		}
+		return tx.Omit("User").Create(&auth.Token{
+			UserID:    user.ID,
+			Token:     hashToken(token),
+			Type:      TokenTypeMagicLink,
+			ExpiresAt: time.Now().Add(MagicLinkTTL),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeMagicLinkToken resolves a login link to its user, creating the
+// account for a link sent to a new address. Opening the link proves the user
+// owns the address, so the email counts as confirmed.
+func (s *AuthService) ConsumeMagicLinkToken(token string) (*auth.User, error) {
+	user, err := s.consumeUserToken(token, TokenTypeMagicLink)
+	if errors.Is(err, errTokenNotFound) {
+		user, err = s.consumeSignupToken(token)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Confirmed {
+		if err := s.db.Model(&auth.User{}).Where("id = ?", user.ID).Update("confirmed", true).Error; err != nil {
+			return nil, err
+		}
+		user.Confirmed = true
+	}
+	return user, nil
+}
+
+// consumeSignupToken uses up a login link sent to a new address and creates
+// its account, with a random password the user can replace through password
+// recovery. An account made for the address since the link was sent is
+// logged in to instead.
+func (s *AuthService) consumeSignupToken(token string) (*auth.User, error) {
+	var signup models.MagicLinkSignup
+	err := s.db.Where("token = ?", hashToken(token)).First(&signup).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMagicLink
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := s.db.Where("token = ?", signup.Token).Delete(&models.MagicLinkSignup{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || time.Now().After(signup.ExpiresAt) {
+		return nil, ErrInvalidMagicLink
+	}
+
+	user, err := s.GetUserByEmail(signup.Email)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	password, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user = &auth.User{Email: signup.Email, Password: string(hashed), Role: "user"}
+	if err := s.CreateUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// MagicLinkUser returns the account a login link for an email address goes
+// to. Unknown addresses get an unsaved user when allowSignup is set, so that
+// no account exists until the link is opened.
+func (s *AuthService) MagicLinkUser(email string, allowSignup bool) (*auth.User, error) {
+	email = strings.TrimSpace(email)
+	user, err := s.GetUserByEmail(email)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if !allowSignup {
+		return nil, ErrSignupDisabled
+	}
+	return &auth.User{Email: email, Role: "user"}, nil
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+)
+
+func TestMagicLinkUser(t *testing.T) {
+	db := newTestDB(t)
+	authService := NewAuthService(db)
+	existing := newTestUser(t, authService, "alice@example.com")
+
+	user, err := authService.MagicLinkUser(" Alice@Example.com ", false)
+	require.NoError(t, err)
+	assert.Equal(t, existing.ID, user.ID)
+	byEmail, err := authService.GetUserByEmail("ALICE@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, existing.ID, byEmail.ID)
+
+	_, err = authService.MagicLinkUser("bob@example.com", false)
+	assert.ErrorIs(t, err, ErrSignupDisabled)
+
+	// Nothing is created for a new address until its link is opened
+	pending, err := authService.MagicLinkUser("bob@example.com", true)
+	require.NoError(t, err)
+	assert.Equal(t, "bob@example.com", pending.Email)
+	_, err = authService.CreateMagicLinkToken(pending)
+	require.NoError(t, err)
+	var count int64
+	require.NoError(t, db.Model(&auth.User{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestConsumeMagicLinkToken(t *testing.T) {
+	db := newTestDB(t)
+	authService := NewAuthService(db)
+	user := newTestUser(t, authService, "alice@example.com")
+
+	first, err := authService.CreateMagicLinkToken(user)
+	require.NoError(t, err)
+	token, err := authService.CreateMagicLinkToken(user)
+	require.NoError(t, err)
+
+	// Asking for a new link replaces the previous one
+	_, err = authService.ConsumeMagicLinkToken(first)
+	assert.ErrorIs(t, err, ErrInvalidMagicLink)
+
+	loggedIn, err := authService.ConsumeMagicLinkToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, loggedIn.ID)
+	assert.True(t, loggedIn.Confirmed)
+
+	_, err = authService.ConsumeMagicLinkToken(token)
+	assert.ErrorIs(t, err, ErrInvalidMagicLink)
+
+	expired, err := authService.CreateMagicLinkToken(user)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&auth.Token{}).Where("type = ?", TokenTypeMagicLink).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	_, err = authService.ConsumeMagicLinkToken(expired)
+	assert.ErrorIs(t, err, ErrInvalidMagicLink)
+}
+
+func TestConsumeSignupMagicLinkToken(t *testing.T) {
+	db := newTestDB(t)
+	authService := NewAuthService(db)
+	pending, err := authService.MagicLinkUser("bob@example.com", true)
+	require.NoError(t, err)
+
+	first, err := authService.CreateMagicLinkToken(pending)
+	require.NoError(t, err)
+	token, err := authService.CreateMagicLinkToken(pending)
+	require.NoError(t, err)
+	_, err = authService.ConsumeMagicLinkToken(first)
+	assert.ErrorIs(t, err, ErrInvalidMagicLink)
+
+	created, err := authService.ConsumeMagicLinkToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "bob@example.com", created.Email)
+	assert.True(t, created.Confirmed)
+	stored, err := authService.GetUserByEmail("bob@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, stored.ID)
+
+	_, err = authService.ConsumeMagicLinkToken(token)
+	assert.ErrorIs(t, err, ErrInvalidMagicLink)
+
+	expired, err := authService.CreateMagicLinkToken(&auth.User{Email: "carol@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&models.MagicLinkSignup{}).Where("1 = 1").
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	_, err = authService.ConsumeMagicLinkToken(expired)
+	assert.ErrorIs(t, err, ErrInvalidMagicLink)
+	_, err = authService.GetUserByEmail("carol@example.com")
+	assert.Error(t, err)
+}
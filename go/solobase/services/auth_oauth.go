@@ -0,0 +1,172 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/constants"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// TokenTypeOAuthLogin is a one-time code handed to the browser after a
+	// provider login, exchanged for a session by the app
+	TokenTypeOAuthLogin = "oauth_login"
+
+	oauthLoginCodeTTL = time.Minute
+)
+
+var (
+	// ErrOAuthEmailRequired is returned when a provider does not share the user's email
+	ErrOAuthEmailRequired = errors.New("the provider did not share an email address")
+	// ErrOAuthEmailUnverified is returned when an account with the email exists but
+	// the provider has not verified that the user owns it
+	ErrOAuthEmailUnverified = errors.New("an account with this email already exists; log in with your password to continue")
+	// ErrSignupDisabled is returned when a new account would be needed but signups are off
+	ErrSignupDisabled = errors.New("signups are disabled")
+	// ErrInvalidOAuthLoginCode is returned for unknown, used or expired login codes
+	ErrInvalidOAuthLoginCode = errors.New("invalid or expired login code")
+	// ErrOAuthAccountDeleted is returned when the identity belongs to a deleted account
+	ErrOAuthAccountDeleted = errors.New("the account has been deleted")
+)
+
+// LoginWithIdentity returns the user for an identity verified by a provider.
+// Known identities log in to their user; otherwise the identity is linked to
+// the account with the same verified email, or a new account is created.
+// Deleted accounts cannot log in or have identities linked.
+func (s *AuthService) LoginWithIdentity(identity *ExternalIdentity, allowSignup bool) (*auth.User, error) {
+	now := time.Now()
+
+	var linked models.OAuthIdentity
+	err := s.db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&linked).Error
+	if err == nil {
+		user, err := s.GetUserByID(linked.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user.Role == constants.RoleDeleted.String() {
+			return nil, ErrOAuthAccountDeleted
+		}
+		s.db.Model(&linked).Updates(map[string]interface{}{"email": identity.Email, "last_login_at": now})
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	email := strings.TrimSpace(identity.Email)
+	if email == "" {
+		return nil, ErrOAuthEmailRequired
+	}
+
+	var user *auth.User
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var existing auth.User
+		err := tx.Where("LOWER(email) = ?", strings.ToLower(email)).First(&existing).Error
+		switch {
+		case err == nil:
+			if existing.Role == constants.RoleDeleted.String() {
+				return ErrOAuthAccountDeleted
+			}
+			// Only link when the provider proved the user owns the address
+			if !identity.EmailVerified {
+				return ErrOAuthEmailUnverified
+			}
+			if !existing.Confirmed {
+				if err := tx.Model(&existing).Update("confirmed", true).Error; err != nil {
+					return err
+				}
+			}
+			user = &existing
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if !allowSignup {
+				return ErrSignupDisabled
+			}
+			created, err := newOAuthUser(identity, email)
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(created).Error; err != nil {
+				return err
+			}
+			user = created
+		default:
+			return err
+		}
+
+		return tx.Create(&models.OAuthIdentity{
+			ID:          uuid.New().String(),
+			UserID:      user.ID.String(),
+			Provider:    identity.Provider,
+			Subject:     identity.Subject,
+			Email:       email,
+			LastLoginAt: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ListIdentities returns the provider accounts linked to a user
+func (s *AuthService) ListIdentities(userID uuid.UUID) ([]models.OAuthIdentity, error) {
+	var identities []models.OAuthIdentity
+	err := s.db.Where("user_id = ?", userID.String()).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+// CreateOAuthLoginCode returns a short-lived single-use code that the app
+// exchanges for a session after a provider login
+func (s *AuthService) CreateOAuthLoginCode(userID uuid.UUID) (string, error) {
+	code, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = s.db.Omit("User").Create(&auth.Token{
+		UserID:    userID,
+		Token:     hashToken(code),
+		Type:      TokenTypeOAuthLogin,
+		ExpiresAt: time.Now().Add(oauthLoginCodeTTL),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ConsumeOAuthLoginCode resolves a login code to its user
+func (s *AuthService) ConsumeOAuthLoginCode(code string) (*auth.User, error) {
+	user, err := s.consumeUserToken(code, TokenTypeOAuthLogin)
+	if errors.Is(err, errTokenNotFound) {
+		return nil, ErrInvalidOAuthLoginCode
+	}
+	return user, err
+}
+
+// newOAuthUser builds an account for a first provider login. It gets a random
+// password the user can replace through password recovery.
+func newOAuthUser(identity *ExternalIdentity, email string) (*auth.User, error) {
+	password, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.User{
+		ID:          uuid.New(),
+		Email:       email,
+		Password:    string(hashed),
+		Role:        "user",
+		Confirmed:   identity.EmailVerified,
+		DisplayName: identity.Name,
+	}, nil
+}
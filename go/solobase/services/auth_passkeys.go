@@ -0,0 +1,397 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// PasskeyCeremonyTTL is how long the browser has to answer a passkey challenge
+	PasskeyCeremonyTTL = 5 * time.Minute
+
+	passkeyPurposeRegistration = "registration"
+	passkeyPurposeLogin        = "login"
+	passkeyPurposeMFA          = "mfa"
+
+	maxPasskeysPerUser = 20
+	maxPasskeyName     = 100
+)
+
+var (
+	// ErrPasskeyNotFound is returned for credentials that are not registered to the user
+	ErrPasskeyNotFound = errors.New("passkey not found")
+	// ErrPasskeyExists is returned when registering a credential a second time
+	ErrPasskeyExists = errors.New("passkey is already registered")
+	// ErrTooManyPasskeys is returned when a user has registered the maximum number of passkeys
+	ErrTooManyPasskeys = errors.New("too many passkeys registered")
+	// ErrInvalidPasskeyChallenge is returned for unknown, used or expired passkey challenges
+	ErrInvalidPasskeyChallenge = errors.New("invalid or expired passkey challenge")
+	// ErrPasskeyCloned is returned when a passkey's signature counter goes
+	// backwards, which means two authenticators share its private key
+	ErrPasskeyCloned = errors.New("passkey signature counter did not increase")
+)
+
+// BeginPasskeyRegistration returns the options for registering a new passkey.
+// Passkeys are discoverable so they can also log in without a password.
+func (s *AuthService) BeginPasskeyRegistration(user *auth.User, rp RelyingParty) (*CredentialCreationOptions, error) {
+	existing, err := s.ListPasskeys(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxPasskeysPerUser {
+		return nil, ErrTooManyPasskeys
+	}
+
+	challenge, err := s.newPasskeyChallenge(&user.ID, passkeyPurposeRegistration, UserVerificationPreferred)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CredentialCreationOptions{
+		Challenge: challenge,
+		RP:        RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User: UserEntity{
+			ID:          encodeBase64URL(user.ID[:]),
+			Name:        user.Email,
+			DisplayName: displayName(user),
+		},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: coseAlgES256},
+			{Type: "public-key", Alg: coseAlgEdDSA},
+			{Type: "public-key", Alg: coseAlgRS256},
+		},
+		Timeout:            int(PasskeyCeremonyTTL.Milliseconds()),
+		ExcludeCredentials: credentialDescriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   UserVerificationPreferred,
+		},
+		Attestation: "none",
+	}, nil
+}
+
+// FinishPasskeyRegistration checks the browser's answer to a registration
+// challenge and stores the new passkey under the given name
+func (s *AuthService) FinishPasskeyRegistration(user *auth.User, rp RelyingParty, name string, response *AttestationResponse) (*models.WebAuthnCredential, error) {
+	_, clientData, err := parseClientData(response.Response.ClientDataJSON, "webauthn.create", rp)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := s.consumePasskeyChallenge(clientData.Challenge, passkeyPurposeRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.UserID == nil || *challenge.UserID != user.ID.String() {
+		return nil, ErrInvalidPasskeyChallenge
+	}
+
+	rawAuthData, err := parseAttestationObject(response.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	authData, err := parseAuthenticatorData(rawAuthData, rp, challenge.UserVerification)
+	if err != nil {
+		return nil, err
+	}
+	if authData.CredentialID == nil {
+		return nil, fmt.Errorf("%w: registration has no credential", ErrInvalidWebAuthnResponse)
+	}
+	if response.RawID != "" {
+		if rawID, err := decodeBase64URL(response.RawID); err != nil || !bytes.Equal(rawID, authData.CredentialID) {
+			return nil, fmt.Errorf("%w: credential ID does not match the authenticator data", ErrInvalidWebAuthnResponse)
+		}
+	}
+	_, algorithm, err := parseCOSEKey(authData.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebAuthnResponse, err)
+	}
+
+	credential := &models.WebAuthnCredential{
+		ID:             uuid.New().String(),
+		UserID:         user.ID.String(),
+		CredentialID:   encodeBase64URL(authData.CredentialID),
+		PublicKey:      authData.PublicKey,
+		Algorithm:      algorithm,
+		SignCount:      authData.SignCount,
+		AAGUID:         formatAAGUID(authData.AAGUID),
+		Transports:     response.Response.Transports,
+		BackupEligible: authData.has(authFlagBackupEligible),
+		BackedUp:       authData.has(authFlagBackedUp),
+		Name:           passkeyName(name),
+	}
+
+	var count int64
+	if err := s.db.Model(&models.WebAuthnCredential{}).Where("credential_id = ?", credential.CredentialID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrPasskeyExists
+	}
+	if err := s.db.Create(credential).Error; err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// BeginPasskeyLogin returns the options for a passwordless login. The browser
+// offers the user's passkeys for the site, so no account is named up front.
+func (s *AuthService) BeginPasskeyLogin(rp RelyingParty) (*CredentialRequestOptions, error) {
+	challenge, err := s.newPasskeyChallenge(nil, passkeyPurposeLogin, UserVerificationRequired)
+	if err != nil {
+		return nil, err
+	}
+	return &CredentialRequestOptions{
+		Challenge:        challenge,
+		Timeout:          int(PasskeyCeremonyTTL.Milliseconds()),
+		RPID:             rp.ID,
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: UserVerificationRequired,
+	}, nil
+}
+
+// FinishPasskeyLogin checks a passwordless login and returns the passkey's
+// user. The authenticator verified the user, so no second factor is needed.
+func (s *AuthService) FinishPasskeyLogin(rp RelyingParty, response *AssertionResponse) (*auth.User, error) {
+	credential, err := s.verifyPasskeyAssertion(rp, response, passkeyPurposeLogin, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(credential.UserID)
+}
+
+// BeginPasskeyMFA returns the options for using one of the user's passkeys as
+// the second factor of a password login
+func (s *AuthService) BeginPasskeyMFA(userID uuid.UUID, rp RelyingParty) (*CredentialRequestOptions, error) {
+	credentials, err := s.ListPasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) == 0 {
+		return nil, ErrPasskeyNotFound
+	}
+
+	challenge, err := s.newPasskeyChallenge(&userID, passkeyPurposeMFA, UserVerificationDiscouraged)
+	if err != nil {
+		return nil, err
+	}
+	return &CredentialRequestOptions{
+		Challenge:        challenge,
+		Timeout:          int(PasskeyCeremonyTTL.Milliseconds()),
+		RPID:             rp.ID,
+		AllowCredentials: credentialDescriptors(credentials),
+		UserVerification: UserVerificationDiscouraged,
+	}, nil
+}
+
+// VerifyPasskeyMFA checks a passkey used as the user's second factor
+func (s *AuthService) VerifyPasskeyMFA(user *auth.User, rp RelyingParty, response *AssertionResponse) error {
+	_, err := s.verifyPasskeyAssertion(rp, response, passkeyPurposeMFA, &user.ID)
+	return err
+}
+
+// ListPasskeys returns the user's passkeys, oldest first
+func (s *AuthService) ListPasskeys(userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	err := s.db.Where("user_id = ?", userID.String()).Order("created_at ASC").Find(&credentials).Error
+	return credentials, err
+}
+
+// HasPasskeys reports whether the user has registered a passkey, which makes
+// it a second factor for their password logins
+func (s *AuthService) HasPasskeys(userID uuid.UUID) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID.String()).Count(&count).Error
+	return count > 0, err
+}
+
+// DeletePasskey removes one of the user's passkeys
+func (s *AuthService) DeletePasskey(userID uuid.UUID, id string) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID.String()).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// MFAChallengeUser returns the user of an MFA challenge without using it up,
+// so a passkey challenge can be issued for the second factor
+func (s *AuthService) MFAChallengeUser(challenge string) (*auth.User, error) {
+	var token auth.Token
+	err := s.db.Where("token = ? AND type = ? AND expires_at > ?", hashToken(challenge), TokenTypeMFAChallenge, time.Now()).
+		First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(token.UserID.String())
+}
+
+// verifyPasskeyAssertion checks a signed login challenge and advances the
+// passkey's signature counter. With userID set the passkey must be theirs.
+func (s *AuthService) verifyPasskeyAssertion(rp RelyingParty, response *AssertionResponse, purpose string, userID *uuid.UUID) (*models.WebAuthnCredential, error) {
+	rawClientData, clientData, err := parseClientData(response.Response.ClientDataJSON, "webauthn.get", rp)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := s.consumePasskeyChallenge(clientData.Challenge, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if userID != nil && (challenge.UserID == nil || *challenge.UserID != userID.String()) {
+		return nil, ErrInvalidPasskeyChallenge
+	}
+
+	encodedID := response.RawID
+	if encodedID == "" {
+		encodedID = response.ID
+	}
+	rawID, err := decodeBase64URL(encodedID)
+	if err != nil || len(rawID) == 0 {
+		return nil, ErrPasskeyNotFound
+	}
+	var credential models.WebAuthnCredential
+	err = s.db.Where("credential_id = ?", encodeBase64URL(rawID)).First(&credential).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPasskeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if userID != nil && credential.UserID != userID.String() {
+		return nil, ErrPasskeyNotFound
+	}
+
+	// Discoverable passkeys name their user, which must be the owner
+	if response.Response.UserHandle != "" {
+		userHandle, err := decodeBase64URL(response.Response.UserHandle)
+		owner, parseErr := uuid.Parse(credential.UserID)
+		if err != nil || parseErr != nil || !bytes.Equal(userHandle, owner[:]) {
+			return nil, ErrPasskeyNotFound
+		}
+	}
+
+	rawAuthData, err := decodeBase64URL(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	authData, err := parseAuthenticatorData(rawAuthData, rp, challenge.UserVerification)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := decodeBase64URL(response.Response.Signature)
+	if err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if err := verifyAssertionSignature(credential.PublicKey, rawAuthData, rawClientData, signature); err != nil {
+		return nil, err
+	}
+
+	// Authenticators that do not count always report 0
+	if (authData.SignCount != 0 || credential.SignCount != 0) && authData.SignCount <= credential.SignCount {
+		return nil, ErrPasskeyCloned
+	}
+
+	now := time.Now()
+	result := s.db.Model(&models.WebAuthnCredential{}).
+		Where("id = ? AND sign_count = ?", credential.ID, credential.SignCount).
+		Updates(map[string]interface{}{
+			"sign_count":   authData.SignCount,
+			"backed_up":    authData.has(authFlagBackedUp),
+			"last_used_at": now,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Another login with the same counter value got there first
+		return nil, ErrPasskeyCloned
+	}
+	credential.SignCount = authData.SignCount
+	credential.LastUsedAt = &now
+	return &credential, nil
+}
+
+// newPasskeyChallenge stores a random challenge for a ceremony and returns it
+// base64url encoded, as it comes back in the client data
+func (s *AuthService) newPasskeyChallenge(userID *uuid.UUID, purpose, userVerification string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	challenge := encodeBase64URL(raw)
+
+	record := &models.WebAuthnChallenge{
+		Challenge:        hashToken(challenge),
+		Purpose:          purpose,
+		UserVerification: userVerification,
+		ExpiresAt:        time.Now().Add(PasskeyCeremonyTTL),
+	}
+	if userID != nil {
+		id := userID.String()
+		record.UserID = &id
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// consumePasskeyChallenge deletes a challenge so it cannot be answered twice
+func (s *AuthService) consumePasskeyChallenge(challenge, purpose string) (*models.WebAuthnChallenge, error) {
+	var record models.WebAuthnChallenge
+	err := s.db.Where("challenge = ? AND purpose = ?", hashToken(challenge), purpose).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidPasskeyChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := s.db.Where("challenge = ?", record.Challenge).Delete(&models.WebAuthnChallenge{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || time.Now().After(record.ExpiresAt) {
+		return nil, ErrInvalidPasskeyChallenge
+	}
+	return &record, nil
+}
+
+// credentialDescriptors lists passkeys for the allow and exclude lists of a ceremony
+func credentialDescriptors(credentials []models.WebAuthnCredential) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, len(credentials))
+	for i, credential := range credentials {
+		descriptors[i] = CredentialDescriptor{
+			Type:       "public-key",
+			ID:         credential.CredentialID,
+			Transports: credential.Transports,
+		}
+	}
+	return descriptors
+}
+
+func passkeyName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Passkey"
+	}
+	if runes := []rune(name); len(runes) > maxPasskeyName {
+		return string(runes[:maxPasskeyName])
+	}
+	return name
+}
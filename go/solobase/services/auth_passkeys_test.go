@@ -0,0 +1,270 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRelyingParty = RelyingParty{ID: "example.com", Name: "Example", Origin: "https://example.com"}
+
+// softAuthenticator is a software passkey that answers WebAuthn ceremonies
+// the way a browser and authenticator would
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	origin       string
+	// userVerified sets the UV flag, as after a PIN or biometric check
+	userVerified bool
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	credentialID := make([]byte, 32)
+	_, err = rand.Read(credentialID)
+	require.NoError(t, err)
+	return &softAuthenticator{key: key, credentialID: credentialID, origin: testRelyingParty.Origin, userVerified: true}
+}
+
+func (a *softAuthenticator) create(t *testing.T, options *CredentialCreationOptions) *AttestationResponse {
+	userHandle, err := decodeBase64URL(options.User.ID)
+	require.NoError(t, err)
+	a.userHandle = userHandle
+
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	coseKey := encodeTestCBOR(testCBORMap{{int64(1), int64(2)}, {int64(3), int64(coseAlgES256)}, {int64(-1), int64(1)}, {int64(-2), x}, {int64(-3), y}})
+
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(a.credentialID)))
+	attested = append(append(attested, a.credentialID...), coseKey...)
+	authData := append(a.authData(options.RP.ID, authFlagAttestedCredData), attested...)
+
+	response := &AttestationResponse{ID: encodeBase64URL(a.credentialID), RawID: encodeBase64URL(a.credentialID), Type: "public-key"}
+	response.Response.ClientDataJSON = a.clientData(t, "webauthn.create", options.Challenge)
+	response.Response.AttestationObject = encodeBase64URL(encodeTestCBOR(testCBORMap{
+		{"fmt", "none"}, {"attStmt", testCBORMap{}}, {"authData", authData},
+	}))
+	response.Response.Transports = []string{"internal"}
+	return response
+}
+
+func (a *softAuthenticator) get(t *testing.T, options *CredentialRequestOptions) *AssertionResponse {
+	a.signCount++
+	authData := a.authData(options.RPID, 0)
+	clientData := a.clientData(t, "webauthn.get", options.Challenge)
+	rawClientData, err := decodeBase64URL(clientData)
+	require.NoError(t, err)
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+
+	response := &AssertionResponse{ID: encodeBase64URL(a.credentialID), RawID: encodeBase64URL(a.credentialID), Type: "public-key"}
+	response.Response.ClientDataJSON = clientData
+	response.Response.AuthenticatorData = encodeBase64URL(authData)
+	response.Response.Signature = encodeBase64URL(signature)
+	response.Response.UserHandle = encodeBase64URL(a.userHandle)
+	return response
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	flags |= authFlagUserPresent
+	if a.userVerified {
+		flags |= authFlagUserVerified
+	}
+	data := append(rpIDHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.signCount)
+	return data
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony, challenge string) string {
+	raw, err := json.Marshal(collectedClientData{Type: ceremony, Challenge: challenge, Origin: a.origin})
+	require.NoError(t, err)
+	return encodeBase64URL(raw)
+}
+
+// testCBORMap is a CBOR map whose entries are encoded in order
+type testCBORMap [][2]interface{}
+
+func encodeTestCBOR(v interface{}) []byte {
+	header := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			b := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+			return b
+		}
+	}
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case testCBORMap:
+		out := header(5, uint64(len(v)))
+		for _, entry := range v {
+			out = append(out, encodeTestCBOR(entry[0])...)
+			out = append(out, encodeTestCBOR(entry[1])...)
+		}
+		return out
+	}
+	panic("unsupported CBOR test value")
+}
+
+func registerTestPasskey(t *testing.T, authService *AuthService, email string) (*softAuthenticator, string) {
+	user := newTestUser(t, authService, email)
+	authenticator := newSoftAuthenticator(t)
+
+	options, err := authService.BeginPasskeyRegistration(user, testRelyingParty)
+	require.NoError(t, err)
+	credential, err := authService.FinishPasskeyRegistration(user, testRelyingParty, " Laptop ", authenticator.create(t, options))
+	require.NoError(t, err)
+	assert.Equal(t, "Laptop", credential.Name)
+	assert.Equal(t, coseAlgES256, credential.Algorithm)
+	return authenticator, user.ID.String()
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	authenticator, userID := registerTestPasskey(t, authService, "alice@example.com")
+
+	options, err := authService.BeginPasskeyLogin(testRelyingParty)
+	require.NoError(t, err)
+	assert.Empty(t, options.AllowCredentials)
+	user, err := authService.FinishPasskeyLogin(testRelyingParty, authenticator.get(t, options))
+	require.NoError(t, err)
+	assert.Equal(t, userID, user.ID.String())
+
+	passkeys, err := authService.ListPasskeys(user.ID)
+	require.NoError(t, err)
+	require.Len(t, passkeys, 1)
+	assert.Equal(t, uint32(1), passkeys[0].SignCount)
+	assert.NotNil(t, passkeys[0].LastUsedAt)
+
+	// The same credential cannot be registered twice
+	registration, err := authService.BeginPasskeyRegistration(user, testRelyingParty)
+	require.NoError(t, err)
+	require.Len(t, registration.ExcludeCredentials, 1)
+	_, err = authService.FinishPasskeyRegistration(user, testRelyingParty, "", authenticator.create(t, registration))
+	assert.ErrorIs(t, err, ErrPasskeyExists)
+}
+
+func TestPasskeyLoginRejections(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	authenticator, _ := registerTestPasskey(t, authService, "alice@example.com")
+
+	begin := func() *CredentialRequestOptions {
+		options, err := authService.BeginPasskeyLogin(testRelyingParty)
+		require.NoError(t, err)
+		return options
+	}
+
+	// Challenges are single use
+	assertion := authenticator.get(t, begin())
+	_, err := authService.FinishPasskeyLogin(testRelyingParty, assertion)
+	require.NoError(t, err)
+	_, err = authService.FinishPasskeyLogin(testRelyingParty, assertion)
+	assert.ErrorIs(t, err, ErrInvalidPasskeyChallenge)
+
+	// A counter that does not move forward means a cloned authenticator
+	authenticator.signCount = 0
+	_, err = authService.FinishPasskeyLogin(testRelyingParty, authenticator.get(t, begin()))
+	assert.ErrorIs(t, err, ErrPasskeyCloned)
+	authenticator.signCount = 10
+
+	// Passwordless logins need user verification
+	authenticator.userVerified = false
+	_, err = authService.FinishPasskeyLogin(testRelyingParty, authenticator.get(t, begin()))
+	assert.ErrorIs(t, err, ErrInvalidWebAuthnResponse)
+	authenticator.userVerified = true
+
+	authenticator.origin = "https://evil.example"
+	_, err = authService.FinishPasskeyLogin(testRelyingParty, authenticator.get(t, begin()))
+	assert.ErrorIs(t, err, ErrInvalidWebAuthnResponse)
+	authenticator.origin = testRelyingParty.Origin
+
+	// A tampered signature fails
+	assertion = authenticator.get(t, begin())
+	assertion.Response.AuthenticatorData = encodeBase64URL(authenticator.authData("example.com", authFlagBackedUp))
+	_, err = authService.FinishPasskeyLogin(testRelyingParty, assertion)
+	assert.ErrorIs(t, err, ErrInvalidWebAuthnResponse)
+
+	_, err = authService.FinishPasskeyLogin(testRelyingParty, newSoftAuthenticator(t).get(t, begin()))
+	assert.ErrorIs(t, err, ErrPasskeyNotFound)
+}
+
+func TestPasskeyAsSecondFactor(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	authenticator, _ := registerTestPasskey(t, authService, "alice@example.com")
+	otherAuthenticator, _ := registerTestPasskey(t, authService, "bob@example.com")
+
+	alice, err := authService.GetUserByEmail("alice@example.com")
+	require.NoError(t, err)
+	hasPasskeys, err := authService.HasPasskeys(alice.ID)
+	require.NoError(t, err)
+	assert.True(t, hasPasskeys)
+
+	options, err := authService.BeginPasskeyMFA(alice.ID, testRelyingParty)
+	require.NoError(t, err)
+	require.Len(t, options.AllowCredentials, 1)
+	assert.Equal(t, encodeBase64URL(authenticator.credentialID), options.AllowCredentials[0].ID)
+
+	// Another user's passkey cannot answer the challenge
+	err = authService.VerifyPasskeyMFA(alice, testRelyingParty, otherAuthenticator.get(t, options))
+	assert.ErrorIs(t, err, ErrPasskeyNotFound)
+
+	// A second factor does not need user verification
+	authenticator.userVerified = false
+	options, err = authService.BeginPasskeyMFA(alice.ID, testRelyingParty)
+	require.NoError(t, err)
+	require.NoError(t, authService.VerifyPasskeyMFA(alice, testRelyingParty, authenticator.get(t, options)))
+
+	passkeys, err := authService.ListPasskeys(alice.ID)
+	require.NoError(t, err)
+	require.NoError(t, authService.DeletePasskey(alice.ID, passkeys[0].ID))
+	assert.ErrorIs(t, authService.DeletePasskey(alice.ID, passkeys[0].ID), ErrPasskeyNotFound)
+	_, err = authService.BeginPasskeyMFA(alice.ID, testRelyingParty)
+	assert.ErrorIs(t, err, ErrPasskeyNotFound)
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	value, n, err := decodeCBOR(encodeTestCBOR(testCBORMap{{int64(-3), []byte{1, 2}}, {"fmt", "none"}}))
+	require.NoError(t, err)
+	assert.Equal(t, 14, n)
+	assert.Equal(t, map[interface{}]interface{}{int64(-3): []byte{1, 2}, "fmt": "none"}, value)
+
+	for _, input := range [][]byte{
+		{},
+		{0x5f},                         // indefinite byte string
+		{0x42, 0x01},                   // truncated byte string
+		{0xa1, 0x01},                   // map missing its value
+		{0xa2, 0x01, 0x01, 0x01, 0x02}, // duplicate key
+	} {
+		_, _, err := decodeCBOR(input)
+		assert.Error(t, err, "%x", input)
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/suppers-ai/solobase/models"
+)
+
+const (
+	// minPasswordLength is the shortest minimum length the settings can ask for
+	minPasswordLength = 6
+	// maxPasswordBytes is the longest password bcrypt can hash
+	maxPasswordBytes = 72
+)
+
+// commonPasswords are rejected even though they pass the other rules
+var commonPasswords = map[string]bool{
+	"password1": true, "password123": true, "passw0rd": true, "p@ssw0rd": true,
+	"qwerty123": true, "abc12345": true, "1q2w3e4r": true, "letmein1": true,
+	"welcome1": true, "iloveyou1": true, "admin123": true, "changeme1": true,
+}
+
+// PasswordPolicy sets the rules for new passwords
+type PasswordPolicy struct {
+	MinLength int
+}
+
+// PasswordPolicyFromSettings builds the policy from the app settings
+func PasswordPolicyFromSettings(settings *models.AppSettings) PasswordPolicy {
+	minLength := settings.PasswordMinLength
+	if minLength <= 0 {
+		minLength = models.DefaultSettings().PasswordMinLength
+	}
+	if minLength < minPasswordLength {
+		minLength = minPasswordLength
+	}
+	return PasswordPolicy{MinLength: minLength}
+}
+
+// Validate checks a new password for the account with the email, which may
+// be empty when it is not known. The error says which rule was broken.
+func (p PasswordPolicy) Validate(password, email string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordBytes)
+	}
+
+	hasLetter, hasOther := false, false
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			hasLetter = true
+		} else {
+			hasOther = true
+		}
+	}
+	if !hasLetter || !hasOther {
+		return errors.New("password must mix letters with numbers or symbols")
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return errors.New("password is too common")
+	}
+	if name, _, _ := strings.Cut(strings.ToLower(email), "@"); len(name) >= 4 && strings.Contains(lower, name) {
+		return errors.New("password must not contain your email address")
+	}
+	return nil
+}
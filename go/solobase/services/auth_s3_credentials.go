@@ -0,0 +1,173 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"gorm.io/gorm"
+)
+
+// S3AccessKeyPrefix starts every S3 access key ID
+const S3AccessKeyPrefix = "SBAK"
+
+var (
+	// ErrInvalidAccessKey is returned for unknown, revoked or expired S3 access keys
+	ErrInvalidAccessKey = errors.New("invalid access key")
+	// ErrS3CredentialNotFound is returned when an S3 credential does not exist for the user
+	ErrS3CredentialNotFound = errors.New("S3 credential not found")
+)
+
+// SetSecrets sets the master keys S3 secret access keys are encrypted with.
+// Secrets encrypted with a master key that is no longer the active one are
+// rewrapped with the active key; those whose master key is not loaded are
+// left as they are and cannot be used.
+func (s *AuthService) SetSecrets(secrets *SecretsService) error {
+	s.secrets = secrets
+
+	var credentials []models.S3Credential
+	if err := s.db.Find(&credentials).Error; err != nil {
+		return err
+	}
+	for _, credential := range credentials {
+		if !secrets.NeedsRewrap(credential.Secret) {
+			continue
+		}
+		secret, err := secrets.Rewrap(credential.Secret, s3CredentialSecretContext(credential.ID))
+		if errors.Is(err, ErrUnknownMasterKey) {
+			log.Printf("Warning: S3 credential %s was encrypted with a master key that is not loaded", credential.ID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to rewrap S3 credential %s: %w", credential.ID, err)
+		}
+		if err := s.db.Model(&models.S3Credential{}).Where("id = ?", credential.ID).Update("secret", secret).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateS3Credential creates an access key ID and secret access key for a
+// user to sign S3 requests with. The secret is returned once and stored
+// encrypted with the master key.
+func (s *AuthService) CreateS3Credential(userID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (*models.S3Credential, string, error) {
+	if s.secrets == nil {
+		return nil, "", errors.New("S3 credentials need a master key")
+	}
+	if strings.TrimSpace(name) == "" {
+		return nil, "", errors.New("name is required")
+	}
+	if len(scopes) == 0 {
+		return nil, "", errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		// Credentials only reach storage, through the S3 API
+		resource, access, _ := strings.Cut(scope, ":")
+		if resource != "storage" || access != ScopeRead && access != ScopeWrite {
+			return nil, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", errors.New("expiry must be in the future")
+	}
+
+	random := make([]byte, 40)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", fmt.Errorf("failed to generate S3 credential: %w", err)
+	}
+	id := S3AccessKeyPrefix + base32.StdEncoding.EncodeToString(random[:10])
+	secret := base64.StdEncoding.EncodeToString(random[10:])
+
+	encrypted, err := s.secrets.Encrypt(secret, s3CredentialSecretContext(id))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encrypt S3 credential: %w", err)
+	}
+
+	credential := &models.S3Credential{
+		ID:        id,
+		UserID:    userID.String(),
+		Name:      strings.TrimSpace(name),
+		Scopes:    scopes,
+		Secret:    encrypted,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(credential).Error; err != nil {
+		return nil, "", err
+	}
+	return credential, secret, nil
+}
+
+// ListS3Credentials returns a user's S3 credentials, newest first
+func (s *AuthService) ListS3Credentials(userID uuid.UUID) ([]models.S3Credential, error) {
+	var credentials []models.S3Credential
+	err := s.db.Where("user_id = ?", userID.String()).Order("created_at DESC").Find(&credentials).Error
+	return credentials, err
+}
+
+// RevokeS3Credential deletes one of a user's S3 credentials
+func (s *AuthService) RevokeS3Credential(userID uuid.UUID, id string) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID.String()).Delete(&models.S3Credential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrS3CredentialNotFound
+	}
+	return nil
+}
+
+// AuthenticateAccessKey resolves an S3 access key ID to its credential and
+// owner. The secret access key is passed to verify to check the request
+// signature, and the credential's use is only recorded when verify succeeds.
+func (s *AuthService) AuthenticateAccessKey(id string, verify func(secret string) error) (*models.S3Credential, *auth.User, error) {
+	if s.secrets == nil {
+		return nil, nil, ErrInvalidAccessKey
+	}
+
+	var credential models.S3Credential
+	if err := s.db.Where("id = ?", id).First(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidAccessKey
+		}
+		return nil, nil, err
+	}
+	if credential.IsExpired() {
+		return nil, nil, ErrInvalidAccessKey
+	}
+
+	// A credential whose master key is gone, or whose secret was tampered
+	// with, is as unusable as an unknown one
+	secret, err := s.secrets.Decrypt(credential.Secret, s3CredentialSecretContext(credential.ID))
+	if err != nil {
+		return nil, nil, ErrInvalidAccessKey
+	}
+	if err := verify(secret); err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.GetUserByID(credential.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidAccessKey
+	}
+
+	now := time.Now()
+	if credential.LastUsedAt == nil || now.Sub(*credential.LastUsedAt) > apiKeyLastUsedInterval {
+		s.db.Model(&models.S3Credential{}).Where("id = ?", credential.ID).Update("last_used_at", now)
+		credential.LastUsedAt = &now
+	}
+	return &credential, user, nil
+}
+
+// s3CredentialSecretContext binds an encrypted secret access key to its credential
+func s3CredentialSecretContext(id string) string {
+	return "s3_credential:" + id
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suppers-ai/solobase/models"
+)
+
+func newS3CredentialTestService(t *testing.T) *AuthService {
+	db := newTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.S3Credential{}))
+	authService := NewAuthService(db)
+	require.NoError(t, authService.SetSecrets(newTestSecrets(t, "default")))
+	return authService
+}
+
+// storedS3Secret reads a credential's encrypted secret as stored in the database
+func storedS3Secret(t *testing.T, authService *AuthService, id string) string {
+	var credential models.S3Credential
+	require.NoError(t, authService.db.Where("id = ?", id).First(&credential).Error)
+	return credential.Secret
+}
+
+func TestCreateS3Credential(t *testing.T) {
+	authService := newS3CredentialTestService(t)
+	user := newTestUser(t, authService, "alice@example.com")
+
+	for _, scopes := range [][]string{nil, {"collections:read"}, {"storage:admin"}, {"ext:products"}} {
+		_, _, err := authService.CreateS3Credential(user.ID, "s3", scopes, nil)
+		assert.Error(t, err, "%v", scopes)
+	}
+
+	credential, secret, err := authService.CreateS3Credential(user.ID, "s3", []string{"storage:read"}, nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(credential.ID, S3AccessKeyPrefix))
+	assert.Len(t, credential.ID, 20)
+	assert.Len(t, secret, 40)
+	// Only the encrypted secret is stored
+	assert.NotContains(t, storedS3Secret(t, authService, credential.ID), secret)
+
+	found, owner, err := authService.AuthenticateAccessKey(credential.ID, func(got string) error {
+		assert.Equal(t, secret, got)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, owner.ID)
+	assert.Equal(t, []string{"storage:read"}, found.Scopes)
+
+	// A failed signature check is passed on
+	errSignature := errors.New("signature mismatch")
+	_, _, err = authService.AuthenticateAccessKey(credential.ID, func(string) error { return errSignature })
+	assert.ErrorIs(t, err, errSignature)
+}
+
+func TestS3CredentialsNeedMasterKey(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.S3Credential{}))
+	authService := NewAuthService(db)
+	user := newTestUser(t, authService, "alice@example.com")
+
+	_, _, err := authService.CreateS3Credential(user.ID, "s3", []string{"storage:read"}, nil)
+	assert.Error(t, err)
+}
+
+func TestRevokeAndExpireS3Credential(t *testing.T) {
+	authService := newS3CredentialTestService(t)
+	user := newTestUser(t, authService, "alice@example.com")
+	other := newTestUser(t, authService, "bob@example.com")
+	accept := func(string) error { return nil }
+
+	credential, _, err := authService.CreateS3Credential(user.ID, "s3", []string{"storage:write"}, nil)
+	require.NoError(t, err)
+	credentials, err := authService.ListS3Credentials(user.ID)
+	require.NoError(t, err)
+	assert.Len(t, credentials, 1)
+
+	assert.ErrorIs(t, authService.RevokeS3Credential(other.ID, credential.ID), ErrS3CredentialNotFound)
+	require.NoError(t, authService.RevokeS3Credential(user.ID, credential.ID))
+	_, _, err = authService.AuthenticateAccessKey(credential.ID, accept)
+	assert.ErrorIs(t, err, ErrInvalidAccessKey)
+
+	credential, _, err = authService.CreateS3Credential(user.ID, "s3", []string{"storage:write"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, authService.db.Model(credential).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	_, _, err = authService.AuthenticateAccessKey(credential.ID, accept)
+	assert.ErrorIs(t, err, ErrInvalidAccessKey)
+}
+
+func TestS3CredentialSecretsRewrapped(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.S3Credential{}))
+	authService := NewAuthService(db)
+	user := newTestUser(t, authService, "alice@example.com")
+
+	old := newTestSecrets(t, "old")
+	require.NoError(t, authService.SetSecrets(old))
+	credential, secret, err := authService.CreateS3Credential(user.ID, "s3", []string{"storage:write"}, nil)
+	require.NoError(t, err)
+	checkSecret := func(got string) error {
+		assert.Equal(t, secret, got)
+		return nil
+	}
+
+	newEntry, err := GenerateMasterKey("new")
+	require.NoError(t, err)
+	rotated, err := NewSecretsService(newEntry + "\nold:" + base64Key(t, old, "old"))
+	require.NoError(t, err)
+	require.NoError(t, authService.SetSecrets(rotated))
+	assert.True(t, strings.HasPrefix(storedS3Secret(t, authService, credential.ID), secretPrefix+"new:"))
+
+	// Once rewrapped the old key is no longer needed
+	onlyNew, err := NewSecretsService(newEntry)
+	require.NoError(t, err)
+	require.NoError(t, authService.SetSecrets(onlyNew))
+	_, _, err = authService.AuthenticateAccessKey(credential.ID, checkSecret)
+	require.NoError(t, err)
+
+	// Credentials whose master key is not loaded cannot be used
+	require.NoError(t, authService.SetSecrets(newTestSecrets(t, "other")))
+	_, _, err = authService.AuthenticateAccessKey(credential.ID, checkSecret)
+	assert.ErrorIs(t, err, ErrInvalidAccessKey)
+}
@@ -0,0 +1,460 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// AccessTokenTTL is the longest lifetime of the JWT access tokens issued for a session
+	AccessTokenTTL = 15 * time.Minute
+	// MaxImpersonationTTL is the longest lifetime of an impersonation token,
+	// which ends with the admin's session like any other access token
+	MaxImpersonationTTL = time.Hour
+	// RefreshTokenTTL is how long a session stays valid without being refreshed
+	// when the settings do not set a session timeout
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	// TokenTypeRefresh marks rotated refresh tokens kept for reuse detection
+	TokenTypeRefresh = "refresh"
+	// TokenTypeRevokedSession marks a revoked session whose access tokens may still be unexpired
+	TokenTypeRevokedSession = "revoked_session"
+
+	// revocationSyncInterval bounds how stale the in-memory revocation list may get
+	// when sessions are revoked by another instance
+	revocationSyncInterval = 10 * time.Second
+
+	// ExpiredAuthCleanupInterval is how often expired sessions, tokens and
+	// login bookkeeping are deleted
+	ExpiredAuthCleanupInterval = time.Hour
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown or expired refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented.
+	// The session it belonged to is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	// ErrSessionNotFound is returned when a session does not exist for the user
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SessionInfo describes an active session for listing
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// sessionData is stored in auth.Session.Data
+type sessionData struct {
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	// ActiveOrgID is the organization put in the session's access tokens
+	ActiveOrgID string `json:"active_org_id,omitempty"`
+}
+
+// SessionPolicy sets how long sessions and their access tokens last
+type SessionPolicy struct {
+	// Timeout ends a session that has not been refreshed for this long
+	Timeout time.Duration
+}
+
+// SessionPolicyFromSettings builds the policy from the app settings
+func SessionPolicyFromSettings(settings *models.AppSettings) SessionPolicy {
+	return SessionPolicy{Timeout: time.Duration(settings.SessionTimeout) * time.Minute}
+}
+
+// SessionTTL returns how long a session lasts without being refreshed
+func (p SessionPolicy) SessionTTL() time.Duration {
+	if p.Timeout <= 0 {
+		return RefreshTokenTTL
+	}
+	return p.Timeout
+}
+
+// AccessTokenTTL returns the lifetime of access tokens, which never outlive the session
+func (p SessionPolicy) AccessTokenTTL() time.Duration {
+	if ttl := p.SessionTTL(); ttl < AccessTokenTTL {
+		return ttl
+	}
+	return AccessTokenTTL
+}
+
+// revocationList caches revoked session IDs until their access tokens expire.
+// Revocations are persisted as tokens rows and re-read periodically so that
+// every instance sees them.
+type revocationList struct {
+	mu       sync.RWMutex
+	sessions map[string]time.Time
+	syncedAt time.Time
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{sessions: make(map[string]time.Time)}
+}
+
+// hashToken returns the stored form of a refresh token, API key or challenge
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken creates a refresh token. The session ID prefix lets a
+// reused token be traced back to its session.
+func newRefreshToken(sessionID string) (string, error) {
+	secret, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+	return sessionID + "." + secret, nil
+}
+
+// CreateSession starts a new session for a user and returns it with its first refresh token
+func (s *AuthService) CreateSession(userID uuid.UUID, userAgent, ipAddress string, policy SessionPolicy) (*auth.Session, string, error) {
+	sessionID := uuid.New().String()
+	refreshToken, err := newRefreshToken(sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, _ := json.Marshal(sessionData{
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		LastUsedAt: time.Now(),
+	})
+	session := &auth.Session{
+		ID:        sessionID,
+		UserID:    userID,
+		Token:     hashToken(refreshToken),
+		Data:      data,
+		ExpiresAt: time.Now().Add(policy.SessionTTL()),
+	}
+	if err := s.db.Omit("User").Create(session).Error; err != nil {
+		return nil, "", err
+	}
+	return session, refreshToken, nil
+}
+
+// RefreshSession exchanges a refresh token for a new one. The presented token
+// is marked as used; presenting it again revokes the whole session.
+func (s *AuthService) RefreshSession(refreshToken, userAgent, ipAddress string, policy SessionPolicy) (*auth.Session, string, error) {
+	hash := hashToken(refreshToken)
+	sessionID, _, _ := strings.Cut(refreshToken, ".")
+
+	var session auth.Session
+	err := s.db.Where("token = ?", hash).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var used auth.Token
+		if err := s.db.Where("token = ? AND type = ?", hash, TokenTypeRefresh).First(&used).Error; err == nil {
+			// A rotated token came back: either the client or an attacker holds a
+			// stolen copy, so end the session for both
+			if err := s.RevokeSession(used.UserID, sessionID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+				return nil, "", err
+			}
+			return nil, "", ErrRefreshTokenReused
+		}
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if session.ID != sessionID || time.Now().After(session.ExpiresAt) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	newToken, err := newRefreshToken(session.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var info sessionData
+	json.Unmarshal(session.Data, &info)
+	info.LastUsedAt = time.Now()
+	if userAgent != "" {
+		info.UserAgent = userAgent
+	}
+	if ipAddress != "" {
+		info.IPAddress = ipAddress
+	}
+	data, _ := json.Marshal(info)
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Only rotate if the token is still current, so two concurrent refreshes
+		// with the same token cannot both succeed
+		result := tx.Model(&auth.Session{}).
+			Where("id = ? AND token = ?", session.ID, hash).
+			Updates(map[string]interface{}{
+				"token":      hashToken(newToken),
+				"data":       data,
+				"expires_at": time.Now().Add(policy.SessionTTL()),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		now := time.Now()
+		return tx.Omit("User").Create(&auth.Token{
+			UserID:    session.UserID,
+			Token:     hash,
+			Type:      TokenTypeRefresh,
+			ExpiresAt: session.ExpiresAt,
+			UsedAt:    &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := s.db.Where("id = ?", session.ID).First(&session).Error; err != nil {
+		return nil, "", err
+	}
+	return &session, newToken, nil
+}
+
+// ListSessions returns the user's unexpired sessions, newest first.
+// currentSessionID marks the session making the request.
+func (s *AuthService) ListSessions(userID uuid.UUID, currentSessionID string) ([]SessionInfo, error) {
+	var sessions []auth.Session
+	if err := s.db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for i := range sessions {
+		infos = append(infos, newSessionInfo(&sessions[i], currentSessionID))
+	}
+	return infos, nil
+}
+
+// newSessionInfo describes a session without its token
+func newSessionInfo(session *auth.Session, currentSessionID string) SessionInfo {
+	var data sessionData
+	json.Unmarshal(session.Data, &data)
+	return SessionInfo{
+		ID:         session.ID,
+		UserAgent:  data.UserAgent,
+		IPAddress:  data.IPAddress,
+		CreatedAt:  session.CreatedAt,
+		LastUsedAt: data.LastUsedAt,
+		ExpiresAt:  session.ExpiresAt,
+		Current:    session.ID == currentSessionID,
+	}
+}
+
+// SessionOrganization returns the active organization of a session
+func SessionOrganization(session *auth.Session) string {
+	var data sessionData
+	json.Unmarshal(session.Data, &data)
+	return data.ActiveOrgID
+}
+
+// SetSessionOrganization changes the active organization of one of the
+// user's sessions. An empty orgID switches back to the personal workspace.
+func (s *AuthService) SetSessionOrganization(userID uuid.UUID, sessionID, orgID string) error {
+	var session auth.Session
+	err := s.db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var data sessionData
+	json.Unmarshal(session.Data, &data)
+	data.ActiveOrgID = orgID
+	encoded, _ := json.Marshal(data)
+	return s.db.Model(&auth.Session{}).Where("id = ?", session.ID).Update("data", encoded).Error
+}
+
+// RevokeSession ends one of the user's sessions. Its refresh token stops
+// working immediately and its access tokens are rejected until they expire.
+func (s *AuthService) RevokeSession(userID uuid.UUID, sessionID string) error {
+	result := s.db.Where("id = ? AND user_id = ?", sessionID, userID).Delete(&auth.Session{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return s.revoke(userID, []string{sessionID})
+}
+
+// RevokeUserSessions ends every session of a user except those listed in keep
+func (s *AuthService) RevokeUserSessions(userID uuid.UUID, keep ...string) error {
+	var sessionIDs []string
+	var expiresAt time.Time
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		sessionIDs, expiresAt, err = revokeUserSessions(tx, userID, keep)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.rememberRevocations(sessionIDs, expiresAt)
+	return nil
+}
+
+// revokeUserSessions deletes the user's sessions other than keep and records
+// their revocation within tx. The caller remembers the revocations once tx
+// has committed.
+func revokeUserSessions(tx *gorm.DB, userID uuid.UUID, keep []string) ([]string, time.Time, error) {
+	query := tx.Model(&auth.Session{}).Where("user_id = ?", userID)
+	if len(keep) > 0 {
+		query = query.Where("id NOT IN ?", keep)
+	}
+
+	var sessionIDs []string
+	if err := query.Pluck("id", &sessionIDs).Error; err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(sessionIDs) == 0 {
+		return nil, time.Time{}, nil
+	}
+
+	if err := tx.Where("id IN ?", sessionIDs).Delete(&auth.Session{}).Error; err != nil {
+		return nil, time.Time{}, err
+	}
+	expiresAt, err := recordRevocations(tx, userID, sessionIDs)
+	return sessionIDs, expiresAt, err
+}
+
+// IsSessionRevoked reports whether access tokens for a session must be rejected
+func (s *AuthService) IsSessionRevoked(sessionID string) bool {
+	s.syncRevocations()
+
+	s.revocations.mu.RLock()
+	defer s.revocations.mu.RUnlock()
+	expiresAt, ok := s.revocations.sessions[sessionID]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// revoke records session revocations and has this instance reject the
+// sessions' access tokens right away
+func (s *AuthService) revoke(userID uuid.UUID, sessionIDs []string) error {
+	expiresAt, err := recordRevocations(s.db.DB, userID, sessionIDs)
+	if err != nil {
+		return err
+	}
+	s.rememberRevocations(sessionIDs, expiresAt)
+	return nil
+}
+
+// recordRevocations stores session revocations for as long as the longest
+// lived token tied to them, an impersonation token, may still be unexpired
+func recordRevocations(db *gorm.DB, userID uuid.UUID, sessionIDs []string) (time.Time, error) {
+	expiresAt := time.Now().Add(max(AccessTokenTTL, MaxImpersonationTTL))
+	entries := make([]auth.Token, len(sessionIDs))
+	for i, id := range sessionIDs {
+		entries[i] = auth.Token{
+			UserID:    userID,
+			Token:     id,
+			Type:      TokenTypeRevokedSession,
+			ExpiresAt: expiresAt,
+		}
+	}
+	if err := db.Omit("User").Clauses(clause.OnConflict{DoNothing: true}).Create(&entries).Error; err != nil {
+		return time.Time{}, err
+	}
+	return expiresAt, nil
+}
+
+// rememberRevocations adds revocations to this instance's in-memory list
+func (s *AuthService) rememberRevocations(sessionIDs []string, expiresAt time.Time) {
+	s.revocations.mu.Lock()
+	for _, id := range sessionIDs {
+		s.revocations.sessions[id] = expiresAt
+	}
+	s.revocations.mu.Unlock()
+}
+
+// syncRevocations reloads revocations recorded by other instances
+func (s *AuthService) syncRevocations() {
+	s.revocations.mu.RLock()
+	fresh := time.Since(s.revocations.syncedAt) < revocationSyncInterval
+	s.revocations.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	now := time.Now()
+	var entries []auth.Token
+	if err := s.db.Where("type = ? AND expires_at > ?", TokenTypeRevokedSession, now).Find(&entries).Error; err != nil {
+		return
+	}
+
+	sessions := make(map[string]time.Time, len(entries))
+	for _, entry := range entries {
+		sessions[entry.Token] = entry.ExpiresAt
+	}
+
+	s.revocations.mu.Lock()
+	s.revocations.sessions = sessions
+	s.revocations.syncedAt = now
+	s.revocations.mu.Unlock()
+}
+
+// DeleteExpiredAuthRecords deletes expired sessions, single use tokens,
+// passkey challenges and magic link signups, and login failures that no
+// longer count towards a lockout
+func (s *AuthService) DeleteExpiredAuthRecords() error {
+	now := time.Now()
+	tokenTypes := []string{TokenTypeRefresh, TokenTypeRevokedSession, TokenTypeTOTPEnrollment, TokenTypeMFAChallenge, TokenTypeOAuthLogin, TokenTypeAccountUnlock, TokenTypeMagicLink}
+	deletes := []*gorm.DB{
+		s.db.Where("type IN ? AND expires_at <= ?", tokenTypes, now).Delete(&auth.Token{}),
+		s.db.Where("expires_at <= ?", now).Delete(&auth.Session{}),
+		s.db.Where("expires_at <= ?", now).Delete(&models.WebAuthnChallenge{}),
+		s.db.Where("expires_at <= ?", now).Delete(&models.MagicLinkSignup{}),
+		s.db.Where("last_failed_at <= ? AND (locked_until IS NULL OR locked_until <= ?)", now.Add(-maxLockoutDuration), now).Delete(&models.LoginAttempt{}),
+	}
+	for _, result := range deletes {
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+// StartExpiredCleanup deletes expired auth records now and then every
+// ExpiredAuthCleanupInterval in the background
+func (s *AuthService) StartExpiredCleanup() {
+	cleanup := func() {
+		if err := s.DeleteExpiredAuthRecords(); err != nil {
+			log.Printf("Failed to delete expired sessions and tokens: %v", err)
+		}
+	}
+
+	go func() {
+		cleanup()
+		ticker := time.NewTicker(ExpiredAuthCleanupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			cleanup()
+		}
+	}()
+}
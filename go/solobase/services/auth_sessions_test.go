@@ -0,0 +1,215 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/database"
+	"github.com/suppers-ai/solobase/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestDB returns a migrated SQLite database that is removed after the test
+func newTestDB(t *testing.T) *database.DB {
+	db, err := database.New(database.Config{Type: "sqlite", Database: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(
+		&auth.User{}, &auth.Session{}, &auth.Token{},
+		&models.OAuthProvider{}, &models.OAuthIdentity{}, &models.LoginAttempt{},
+		&models.Role{}, &models.Permission{}, &models.RolePermission{}, &models.RoleBinding{},
+		&models.Organization{}, &models.OrganizationMember{}, &models.OrganizationInvite{}, &models.Collection{},
+		&models.WebAuthnCredential{}, &models.WebAuthnChallenge{}, &models.MagicLinkSignup{},
+	))
+	return db
+}
+
+func newTestUser(t *testing.T, authService *AuthService, email string) *auth.User {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("correct-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := &auth.User{Email: email, Password: string(hashed), Role: "user"}
+	require.NoError(t, authService.CreateUser(user))
+	return user
+}
+
+// elapse makes the stored revocations and tokens as old as if d had passed,
+// and has the next check reload them
+func elapse(t *testing.T, authService *AuthService, d time.Duration) {
+	var tokens []auth.Token
+	require.NoError(t, authService.db.Find(&tokens).Error)
+	for _, token := range tokens {
+		require.NoError(t, authService.db.Model(&auth.Token{}).Where("id = ?", token.ID).
+			Update("expires_at", token.ExpiresAt.Add(-d)).Error)
+	}
+	authService.revocations.mu.Lock()
+	authService.revocations.syncedAt = time.Time{}
+	authService.revocations.mu.Unlock()
+}
+
+func TestRevokedSessionOutlivesImpersonationToken(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	admin := newTestUser(t, authService, "admin@example.com")
+	session, _, err := authService.CreateSession(admin.ID, "", "", SessionPolicy{})
+	require.NoError(t, err)
+
+	require.NoError(t, authService.RevokeSession(admin.ID, session.ID))
+	assert.True(t, authService.IsSessionRevoked(session.ID))
+
+	// An impersonation token issued from the session is still unexpired once
+	// ordinary access tokens are not
+	elapse(t, authService, AccessTokenTTL+time.Minute)
+	assert.True(t, authService.IsSessionRevoked(session.ID))
+
+	elapse(t, authService, MaxImpersonationTTL)
+	assert.False(t, authService.IsSessionRevoked(session.ID))
+}
+
+func TestRefreshSessionRotatesToken(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+	session, first, err := authService.CreateSession(user.ID, "agent-1", "10.0.0.1", SessionPolicy{})
+	require.NoError(t, err)
+
+	refreshed, second, err := authService.RefreshSession(first, "agent-2", "", SessionPolicy{})
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, refreshed.ID)
+	assert.NotEqual(t, first, second)
+
+	sessions, err := authService.ListSessions(user.ID, session.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "agent-2", sessions[0].UserAgent)
+	assert.Equal(t, "10.0.0.1", sessions[0].IPAddress)
+	assert.True(t, sessions[0].Current)
+
+	_, _, err = authService.RefreshSession("unknown."+first, "", "", SessionPolicy{})
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	// The new token keeps working, until it is rotated in turn
+	_, third, err := authService.RefreshSession(second, "", "", SessionPolicy{})
+	require.NoError(t, err)
+	assert.NotEqual(t, second, third)
+	assert.False(t, authService.IsSessionRevoked(session.ID))
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+	session, first, err := authService.CreateSession(user.ID, "", "", SessionPolicy{})
+	require.NoError(t, err)
+	_, second, err := authService.RefreshSession(first, "", "", SessionPolicy{})
+	require.NoError(t, err)
+
+	// Presenting the rotated token again ends the session for whoever holds
+	// either token
+	_, _, err = authService.RefreshSession(first, "", "", SessionPolicy{})
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	assert.True(t, authService.IsSessionRevoked(session.ID))
+
+	_, _, err = authService.RefreshSession(second, "", "", SessionPolicy{})
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	sessions, err := authService.ListSessions(user.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestRevokeSessions(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+	other := newTestUser(t, authService, "bob@example.com")
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		session, _, err := authService.CreateSession(user.ID, "", "", SessionPolicy{})
+		require.NoError(t, err)
+		ids = append(ids, session.ID)
+	}
+	otherSession, _, err := authService.CreateSession(other.ID, "", "", SessionPolicy{})
+	require.NoError(t, err)
+
+	// A user can only revoke their own sessions
+	assert.ErrorIs(t, authService.RevokeSession(other.ID, ids[0]), ErrSessionNotFound)
+	assert.False(t, authService.IsSessionRevoked(ids[0]))
+
+	require.NoError(t, authService.RevokeSession(user.ID, ids[0]))
+	assert.True(t, authService.IsSessionRevoked(ids[0]))
+	assert.False(t, authService.IsSessionRevoked(ids[1]))
+	assert.ErrorIs(t, authService.RevokeSession(user.ID, ids[0]), ErrSessionNotFound)
+
+	// Signing out everywhere else keeps the current session
+	require.NoError(t, authService.RevokeUserSessions(user.ID, ids[1]))
+	assert.False(t, authService.IsSessionRevoked(ids[1]))
+	assert.True(t, authService.IsSessionRevoked(ids[2]))
+	assert.False(t, authService.IsSessionRevoked(otherSession.ID))
+	sessions, err := authService.ListSessions(user.ID, ids[1])
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, ids[1], sessions[0].ID)
+
+	// Other instances see the revocations once they sync
+	fresh := NewAuthService(authService.db)
+	assert.True(t, fresh.IsSessionRevoked(ids[0]))
+	assert.True(t, fresh.IsSessionRevoked(ids[2]))
+	assert.False(t, fresh.IsSessionRevoked(ids[1]))
+}
+
+func TestDeleteExpiredAuthRecords(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+
+	expired, _, err := authService.CreateSession(user.ID, "", "", SessionPolicy{})
+	require.NoError(t, err)
+	active, _, err := authService.CreateSession(user.ID, "", "", SessionPolicy{})
+	require.NoError(t, err)
+	require.NoError(t, authService.db.Model(&auth.Session{}).Where("id = ?", expired.ID).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	require.NoError(t, authService.RevokeSession(user.ID, expired.ID))
+	elapse(t, authService, MaxImpersonationTTL+time.Minute)
+	require.NoError(t, authService.db.Create(&models.LoginAttempt{
+		ID: "00000000-0000-0000-0000-000000000001", Email: "alice@example.com", IPAddress: "192.0.2.1",
+		FailedCount: 1, LastFailedAt: time.Now().Add(-maxLockoutDuration - time.Minute),
+	}).Error)
+
+	// Checking revocations only reads them
+	assert.False(t, authService.IsSessionRevoked(expired.ID))
+	var count int64
+	require.NoError(t, authService.db.Model(&auth.Token{}).Where("type = ?", TokenTypeRevokedSession).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	require.NoError(t, authService.DeleteExpiredAuthRecords())
+	require.NoError(t, authService.db.Model(&auth.Token{}).Where("type = ?", TokenTypeRevokedSession).Count(&count).Error)
+	assert.Zero(t, count)
+	require.NoError(t, authService.db.Model(&models.LoginAttempt{}).Count(&count).Error)
+	assert.Zero(t, count)
+	var sessions []auth.Session
+	require.NoError(t, authService.db.Find(&sessions).Error)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, active.ID, sessions[0].ID)
+}
+
+func TestUpdateUserPasswordRevokesSessions(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+	session, _, err := authService.CreateSession(user.ID, "", "", SessionPolicy{})
+	require.NoError(t, err)
+
+	// The password is kept when the sessions cannot be revoked
+	require.NoError(t, authService.db.Migrator().DropTable(&auth.Token{}))
+	assert.Error(t, authService.UpdateUserPassword(user.ID.String(), "new-hash"))
+	stored, err := authService.GetUserByID(user.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, user.Password, stored.Password)
+	var count int64
+	require.NoError(t, authService.db.Model(&auth.Session{}).Where("id = ?", session.ID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	require.NoError(t, authService.db.AutoMigrate(&auth.Token{}))
+	require.NoError(t, authService.UpdateUserPassword(user.ID.String(), "new-hash"))
+	stored, err = authService.GetUserByID(user.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, "new-hash", stored.Password)
+	assert.True(t, authService.IsSessionRevoked(session.ID))
+}
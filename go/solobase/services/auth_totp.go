@@ -0,0 +1,322 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	auth "github.com/suppers-ai/auth"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// TokenTypeTOTPEnrollment holds a TOTP secret until the user confirms it with a code
+	TokenTypeTOTPEnrollment = "totp_enrollment"
+	// TokenTypeMFAChallenge marks a password-verified login waiting for its second factor
+	TokenTypeMFAChallenge = "mfa_challenge"
+
+	// MFAChallengeTTL is how long a user has to enter their second factor after the password
+	MFAChallengeTTL = 5 * time.Minute
+
+	totpEnrollmentTTL = 15 * time.Minute
+	recoveryCodeCount = 10
+	// totpPeriod is the length in seconds of a TOTP time step
+	totpPeriod = 30
+)
+
+var (
+	// ErrTOTPAlreadyEnabled is returned when enrolling a user who already uses TOTP
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTOTPNotEnabled is returned when a user without TOTP tries to use or disable it
+	ErrTOTPNotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrNoTOTPEnrollment is returned when confirming without a pending enrollment
+	ErrNoTOTPEnrollment = errors.New("no pending two-factor enrollment")
+	// ErrInvalidTOTPCode is returned for a wrong authenticator or recovery code
+	ErrInvalidTOTPCode = errors.New("invalid two-factor code")
+	// ErrInvalidMFAChallenge is returned for unknown, used or expired MFA challenge tokens
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA challenge")
+)
+
+// BeginTOTPEnrollment generates a new TOTP secret for the user. It only takes
+// effect once confirmed with ConfirmTOTPEnrollment.
+func (s *AuthService) BeginTOTPEnrollment(user *auth.User, issuer string) (*otp.Key, error) {
+	if user.GetTOTPSecretKey() != "" {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: user.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND type = ?", user.ID, TokenTypeTOTPEnrollment).Delete(&auth.Token{}).Error; err != nil {
+			return err
+		}
+		return tx.Omit("User").Create(&auth.Token{
+			UserID:    user.ID,
+			Token:     key.Secret(),
+			Type:      TokenTypeTOTPEnrollment,
+			ExpiresAt: time.Now().Add(totpEnrollmentTTL),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ConfirmTOTPEnrollment enables TOTP once the user proves their authenticator
+// works, and returns a fresh set of recovery codes
+func (s *AuthService) ConfirmTOTPEnrollment(userID uuid.UUID, code string) ([]string, error) {
+	var pending auth.Token
+	err := s.db.Where("user_id = ? AND type = ? AND expires_at > ?", userID, TokenTypeTOTPEnrollment, time.Now()).
+		First(&pending).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoTOTPEnrollment
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := totpStep(code, pending.Token, time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes, hashed, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&auth.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":    pending.Token,
+			"recovery_codes": hashed,
+			"totp_last_step": step,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&pending).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns off two-factor authentication and discards the recovery codes
+func (s *AuthService) DisableTOTP(userID uuid.UUID) error {
+	return s.db.Model(&auth.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":    nil,
+		"recovery_codes": nil,
+		"totp_last_step": nil,
+	}).Error
+}
+
+// VerifySecondFactor checks an authenticator code, or consumes a recovery code
+// if one is given instead. A code is accepted once: after it, only codes for
+// later time steps are.
+func (s *AuthService) VerifySecondFactor(user *auth.User, code, recoveryCode string) error {
+	secret := user.GetTOTPSecretKey()
+	if secret == "" {
+		return ErrTOTPNotEnabled
+	}
+
+	if recoveryCode != "" {
+		return s.useRecoveryCode(user, recoveryCode)
+	}
+	step, ok := totpStep(code, secret, time.Now())
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+
+	// Only succeed if no login used a code for this or a later step already
+	result := s.db.Model(&auth.User{}).
+		Where("id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+// totpStep returns the time step an authenticator code is for. Like
+// totp.Validate it allows one step of clock drift either way.
+func totpStep(code, secret string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	current := now.Unix() / totpPeriod
+	for _, step := range []int64{current, current - 1, current + 1} {
+		expected, err := totp.GenerateCode(secret, time.Unix(step*totpPeriod, 0))
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes
+func (s *AuthService) RegenerateRecoveryCodes(userID uuid.UUID) ([]string, error) {
+	codes, hashed, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	result := s.db.Model(&auth.User{}).
+		Where("id = ? AND totp_secret IS NOT NULL", userID).
+		Update("recovery_codes", hashed)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrTOTPNotEnabled
+	}
+	return codes, nil
+}
+
+// RecoveryCodesRemaining returns how many unused recovery codes the user has
+func RecoveryCodesRemaining(user *auth.User) int {
+	if user.GetRecoveryCodes() == "" {
+		return 0
+	}
+	return len(strings.Split(user.GetRecoveryCodes(), ","))
+}
+
+// CreateMFAChallenge records that the user passed the password step and
+// returns the token that must accompany their second factor
+func (s *AuthService) CreateMFAChallenge(userID uuid.UUID) (string, error) {
+	challenge, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.Omit("User").Create(&auth.Token{
+		UserID:    userID,
+		Token:     hashToken(challenge),
+		Type:      TokenTypeMFAChallenge,
+		ExpiresAt: time.Now().Add(MFAChallengeTTL),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// ConsumeMFAChallenge resolves an MFA challenge token to its user. A challenge
+// can only be presented once, so a wrong code means logging in again.
+func (s *AuthService) ConsumeMFAChallenge(challenge string) (*auth.User, error) {
+	user, err := s.consumeUserToken(challenge, TokenTypeMFAChallenge)
+	if errors.Is(err, errTokenNotFound) {
+		return nil, ErrInvalidMFAChallenge
+	}
+	return user, err
+}
+
+// errTokenNotFound is returned by consumeUserToken for unknown, used or expired tokens
+var errTokenNotFound = errors.New("token not found")
+
+// consumeUserToken deletes a single-use token stored as a hash and returns its user
+func (s *AuthService) consumeUserToken(raw, tokenType string) (*auth.User, error) {
+	var token auth.Token
+	err := s.db.Where("token = ? AND type = ?", hashToken(raw), tokenType).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := s.db.Delete(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || time.Now().After(token.ExpiresAt) {
+		return nil, errTokenNotFound
+	}
+
+	return s.GetUserByID(token.UserID.String())
+}
+
+// useRecoveryCode removes a matching recovery code so it cannot be used again
+func (s *AuthService) useRecoveryCode(user *auth.User, code string) error {
+	code = normalizeRecoveryCode(code)
+	stored := user.GetRecoveryCodes()
+	if stored == "" || code == "" {
+		return ErrInvalidTOTPCode
+	}
+
+	hashes := strings.Split(stored, ",")
+	for i, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) != nil {
+			continue
+		}
+
+		remaining := append(append([]string{}, hashes[:i]...), hashes[i+1:]...)
+		var value interface{}
+		if len(remaining) > 0 {
+			value = strings.Join(remaining, ",")
+		}
+
+		// Only succeed if no concurrent login used a code in the meantime
+		result := s.db.Model(&auth.User{}).
+			Where("id = ? AND recovery_codes = ?", user.ID, stored).
+			Update("recovery_codes", value)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+	return ErrInvalidTOTPCode
+}
+
+// generateRecoveryCodes returns new recovery codes and their stored form,
+// a comma separated list of bcrypt hashes
+func generateRecoveryCodes() ([]string, string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, "", err
+		}
+		encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw))[:10]
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(encoded), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", err
+		}
+		hashes[i] = string(hash)
+	}
+	return codes, strings.Join(hashes, ","), nil
+}
+
+// normalizeRecoveryCode accepts codes with or without the dash and in any case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+// randomToken returns a URL-safe random token of n bytes
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
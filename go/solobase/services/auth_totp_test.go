@@ -0,0 +1,140 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	auth "github.com/suppers-ai/auth"
+)
+
+// enrollTOTP enables TOTP for a user and returns the secret and recovery codes
+func enrollTOTP(t *testing.T, authService *AuthService, user *auth.User) (string, []string) {
+	key, err := authService.BeginTOTPEnrollment(user, "Solobase")
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(key.Secret(), time.Now().Add(-totpPeriod*time.Second))
+	require.NoError(t, err)
+	codes, err := authService.ConfirmTOTPEnrollment(user.ID, code)
+	require.NoError(t, err)
+	return key.Secret(), codes
+}
+
+// reloadUser reads a user back with their current 2FA state
+func reloadUser(t *testing.T, authService *AuthService, user *auth.User) *auth.User {
+	reloaded, err := authService.GetUserByID(user.ID.String())
+	require.NoError(t, err)
+	return reloaded
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+
+	_, err := authService.ConfirmTOTPEnrollment(user.ID, "123456")
+	assert.ErrorIs(t, err, ErrNoTOTPEnrollment)
+
+	key, err := authService.BeginTOTPEnrollment(user, "Solobase")
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", key.AccountName())
+	assert.Empty(t, reloadUser(t, authService, user).GetTOTPSecretKey())
+
+	_, err = authService.ConfirmTOTPEnrollment(user.ID, "000000")
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	require.NoError(t, err)
+	codes, err := authService.ConfirmTOTPEnrollment(user.ID, code)
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	user = reloadUser(t, authService, user)
+	assert.Equal(t, key.Secret(), user.GetTOTPSecretKey())
+	assert.Equal(t, recoveryCodeCount, RecoveryCodesRemaining(user))
+	_, err = authService.BeginTOTPEnrollment(user, "Solobase")
+	assert.ErrorIs(t, err, ErrTOTPAlreadyEnabled)
+
+	// The code that confirmed the enrollment cannot also log in
+	assert.ErrorIs(t, authService.VerifySecondFactor(user, code, ""), ErrInvalidTOTPCode)
+
+	require.NoError(t, authService.DisableTOTP(user.ID))
+	user = reloadUser(t, authService, user)
+	assert.ErrorIs(t, authService.VerifySecondFactor(user, code, ""), ErrTOTPNotEnabled)
+	assert.Zero(t, RecoveryCodesRemaining(user))
+}
+
+func TestTOTPCodeCannotBeReplayed(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+	secret, _ := enrollTOTP(t, authService, user)
+	user = reloadUser(t, authService, user)
+
+	now := time.Now()
+	code, err := totp.GenerateCode(secret, now)
+	require.NoError(t, err)
+	require.NoError(t, authService.VerifySecondFactor(user, code, ""))
+	assert.ErrorIs(t, authService.VerifySecondFactor(user, code, ""), ErrInvalidTOTPCode)
+
+	// Codes for earlier steps are refused too, while later ones still work
+	earlier, err := totp.GenerateCode(secret, now.Add(-totpPeriod*time.Second))
+	require.NoError(t, err)
+	assert.ErrorIs(t, authService.VerifySecondFactor(user, earlier, ""), ErrInvalidTOTPCode)
+	later, err := totp.GenerateCode(secret, now.Add(totpPeriod*time.Second))
+	require.NoError(t, err)
+	require.NoError(t, authService.VerifySecondFactor(user, later, ""))
+
+	assert.ErrorIs(t, authService.VerifySecondFactor(user, "not-a-code", ""), ErrInvalidTOTPCode)
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+	_, codes := enrollTOTP(t, authService, user)
+	user = reloadUser(t, authService, user)
+
+	// Codes are accepted without the dash and in any case
+	require.NoError(t, authService.VerifySecondFactor(user, "", " "+codes[0]+" "))
+	assert.ErrorIs(t, authService.VerifySecondFactor(reloadUser(t, authService, user), "", codes[0]), ErrInvalidTOTPCode)
+
+	// A second login holding the same stale user cannot spend another code
+	stale := user
+	user = reloadUser(t, authService, user)
+	assert.Equal(t, recoveryCodeCount-1, RecoveryCodesRemaining(user))
+	assert.ErrorIs(t, authService.VerifySecondFactor(stale, "", codes[1]), ErrInvalidTOTPCode)
+
+	unformatted := "  " + codes[1][:5] + codes[1][6:]
+	require.NoError(t, authService.VerifySecondFactor(user, "", unformatted))
+	assert.Equal(t, recoveryCodeCount-2, RecoveryCodesRemaining(reloadUser(t, authService, user)))
+
+	// New codes replace the old ones
+	fresh, err := authService.RegenerateRecoveryCodes(user.ID)
+	require.NoError(t, err)
+	user = reloadUser(t, authService, user)
+	assert.ErrorIs(t, authService.VerifySecondFactor(user, "", codes[2]), ErrInvalidTOTPCode)
+	require.NoError(t, authService.VerifySecondFactor(user, "", fresh[0]))
+}
+
+func TestMFAChallenge(t *testing.T) {
+	db := newTestDB(t)
+	authService := NewAuthService(db)
+	user := newTestUser(t, authService, "alice@example.com")
+
+	challenge, err := authService.CreateMFAChallenge(user.ID)
+	require.NoError(t, err)
+	challenged, err := authService.ConsumeMFAChallenge(challenge)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, challenged.ID)
+
+	// A challenge is presented once, whether or not the code was right
+	_, err = authService.ConsumeMFAChallenge(challenge)
+	assert.ErrorIs(t, err, ErrInvalidMFAChallenge)
+	_, err = authService.ConsumeMFAChallenge("unknown")
+	assert.ErrorIs(t, err, ErrInvalidMFAChallenge)
+
+	expired, err := authService.CreateMFAChallenge(user.ID)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&auth.Token{}).Where("type = ?", TokenTypeMFAChallenge).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	_, err = authService.ConsumeMFAChallenge(expired)
+	assert.ErrorIs(t, err, ErrInvalidMFAChallenge)
+}
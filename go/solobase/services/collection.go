@@ -1,7 +1,25 @@
 package services
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/suppers-ai/dynamicfields"
+	"github.com/suppers-ai/formulaengine"
 	"github.com/suppers-ai/solobase/database"
+	"github.com/suppers-ai/solobase/models"
+	"github.com/suppers-ai/solobase/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrCollectionNotFound is returned when a collection does not exist
+	ErrCollectionNotFound = errors.New("collection not found")
+	// ErrRecordNotFound is returned when a record does not exist in a collection
+	ErrRecordNotFound = errors.New("record not found")
 )
 
 // CollectionsService is an alias for CollectionService
@@ -11,38 +29,168 @@ type CollectionService struct {
 	db *database.DB
 }
 
+// CollectionInput holds the fields used to create a collection
+type CollectionInput struct {
+	Name        string
+	DisplayName string
+	Description string
+	Schema      *dynamicfields.Schema
+	AuthRules   map[string]interface{}
+	// OrganizationID makes the collection's records private to an organization
+	OrganizationID string
+}
+
 func NewCollectionService(db *database.DB) *CollectionService {
 	return &CollectionService{db: db}
 }
 
-func (s *CollectionService) GetCollections() ([]interface{}, error) {
-	// Return empty list until collections are properly implemented
-	return []interface{}{}, nil
+func (s *CollectionService) GetCollections() ([]models.Collection, error) {
+	var collections []models.Collection
+	if err := s.db.Order("name ASC").Find(&collections).Error; err != nil {
+		return nil, err
+	}
+	return collections, nil
 }
 
-func (s *CollectionService) GetCollection(id string) (interface{}, error) {
-	// Not implemented yet
-	return nil, nil
+// GetCollection looks up a collection by ID or by name
+func (s *CollectionService) GetCollection(idOrName string) (*models.Collection, error) {
+	if id, err := uuid.Parse(idOrName); err == nil {
+		return s.findCollection(s.db.Where("id = ?", id))
+	}
+	return s.GetCollectionByName(idOrName)
 }
 
-func (s *CollectionService) CreateCollection(name string, schema interface{}) (interface{}, error) {
-	// Not implemented yet
-	return nil, nil
+// GetCollectionByName looks up a collection by name only, even when the name
+// could be read as an ID
+func (s *CollectionService) GetCollectionByName(name string) (*models.Collection, error) {
+	return s.findCollection(s.db.Where("name = ?", name))
 }
 
-func (s *CollectionService) UpdateCollection(id string, updates map[string]interface{}) (interface{}, error) {
-	// Not implemented yet
-	return nil, nil
+func (s *CollectionService) findCollection(query *gorm.DB) (*models.Collection, error) {
+	var collection models.Collection
+	if err := query.First(&collection).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCollectionNotFound
+		}
+		return nil, err
+	}
+	return &collection, nil
 }
 
+func (s *CollectionService) CreateCollection(input CollectionInput) (*models.Collection, error) {
+	if err := utils.ValidateSQLIdentifier(input.Name); err != nil {
+		return nil, fmt.Errorf("invalid collection name: %w", err)
+	}
+	if input.Schema == nil {
+		input.Schema = &dynamicfields.Schema{}
+	}
+	input.Schema.Name = input.Name
+	if err := validateCollectionSchema(input.Schema); err != nil {
+		return nil, err
+	}
+	if err := validateCollectionRules(input.Schema, input.AuthRules); err != nil {
+		return nil, err
+	}
+
+	collection := &models.Collection{
+		Name:        input.Name,
+		DisplayName: input.DisplayName,
+		Description: input.Description,
+		AuthRules:   input.AuthRules,
+	}
+	if input.OrganizationID != "" {
+		collection.OrganizationID = &input.OrganizationID
+	}
+	if err := collection.SetSchema(input.Schema); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(collection).Error; err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+// UpdateCollection updates a collection's display name, description, schema or
+// access rules. Existing records are not migrated when the schema changes; they are
+// re-validated the next time they are written.
+func (s *CollectionService) UpdateCollection(id string, updates map[string]interface{}) (*models.Collection, error) {
+	collection, err := s.GetCollection(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range updates {
+		switch key {
+		case "display_name":
+			collection.DisplayName, _ = value.(string)
+		case "description":
+			collection.Description, _ = value.(string)
+		case "schema":
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid schema: %w", err)
+			}
+			var schema dynamicfields.Schema
+			if err := json.Unmarshal(data, &schema); err != nil {
+				return nil, fmt.Errorf("invalid schema: %w", err)
+			}
+			schema.Name = collection.Name
+			if err := validateCollectionSchema(&schema); err != nil {
+				return nil, err
+			}
+			if err := collection.SetSchema(&schema); err != nil {
+				return nil, err
+			}
+		case "indexes":
+			indexes, _ := value.(map[string]interface{})
+			collection.Indexes = indexes
+		case "auth_rules":
+			rules, ok := value.(map[string]interface{})
+			if value != nil && !ok {
+				return nil, fmt.Errorf("auth_rules must be an object")
+			}
+			collection.AuthRules = rules
+		}
+	}
+
+	// Rules are checked against the final schema so that a schema change
+	// cannot leave a rule pointing at a removed field
+	schema, err := collection.ParsedSchema()
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := validateCollectionRules(schema, collection.AuthRules); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Save(collection).Error; err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+// DeleteCollection removes a collection together with all of its records
 func (s *CollectionService) DeleteCollection(id string) error {
-	// Not implemented yet
-	return nil
+	collection, err := s.GetCollection(id)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", collection.ID).Delete(&models.CollectionRecord{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(collection).Error
+	})
 }
 
 func (s *CollectionService) GetCollectionCount() (int, error) {
-	// Return 0 until collections are properly implemented
-	return 0, nil
+	var count int64
+	if err := s.db.Model(&models.Collection{}).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return int(count), nil
 }
 
 func (s *CollectionService) GetTotalRecordCount() (int, error) {
@@ -52,4 +200,337 @@ func (s *CollectionService) GetTotalRecordCount() (int, error) {
 		return 0, err
 	}
 	return int(count), nil
-}
\ No newline at end of file
+}
+
+// GetRecordCounts returns the number of records in each collection, keyed by collection ID
+func (s *CollectionService) GetRecordCounts() (map[uuid.UUID]int, error) {
+	var rows []struct {
+		CollectionID uuid.UUID
+		Count        int
+	}
+	if err := s.db.Model(&models.CollectionRecord{}).
+		Select("collection_id, COUNT(*) AS count").
+		Group("collection_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uuid.UUID]int, len(rows))
+	for _, row := range rows {
+		counts[row.CollectionID] = row.Count
+	}
+	return counts, nil
+}
+
+// ListRecords returns a page of records from a collection. Filters and sort
+// keys are translated to SQL against the JSON data column so they are
+// evaluated by the database rather than in memory. The collection's list
+// rule is applied as an additional filter for q.Auth.
+func (s *CollectionService) ListRecords(collection *models.Collection, q RecordQuery) (*RecordPage, error) {
+	if err := s.checkOrganization(collection, models.CollectionRuleList, q.Auth); err != nil {
+		return nil, err
+	}
+
+	schema, err := collection.ParsedSchema()
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection schema: %w", err)
+	}
+	builder := newRecordSQLBuilder(s.db.Dialector.Name(), schema)
+	builder.vars = q.Auth.variables()
+
+	query := s.db.Model(&models.CollectionRecord{}).Where("collection_id = ?", collection.ID)
+
+	ruleWhere, ruleArgs, err := s.ruleCondition(collection, schema, models.CollectionRuleList, q.Auth)
+	if err != nil {
+		return nil, err
+	}
+	if ruleWhere != "" {
+		query = query.Where(ruleWhere, ruleArgs...)
+	}
+
+	if strings.TrimSpace(q.Filter) != "" {
+		expr, err := formulaengine.NewFormulaParser().ParseCondition(q.Filter)
+		if err != nil {
+			return nil, invalidQuery("filter: %v", err)
+		}
+		where, args, err := builder.Where(expr)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(where, args...)
+	}
+
+	sortKeys, err := builder.parseSort(q.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	pageQuery := query.Session(&gorm.Session{})
+	if q.Cursor != "" {
+		values, err := decodeRecordCursor(sortKeys, q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where, args := builder.after(sortKeys, values)
+		pageQuery = pageQuery.Where(where, args...)
+	} else if q.Page > 1 {
+		pageQuery = pageQuery.Offset((q.Page - 1) * q.PageSize)
+	}
+
+	// Fetch one extra row to know whether another page follows
+	var records []models.CollectionRecord
+	if err := pageQuery.Order(builder.orderBy(sortKeys)).Limit(q.PageSize + 1).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	page := &RecordPage{Total: int(total)}
+	if len(records) > q.PageSize {
+		records = records[:q.PageSize]
+		page.NextCursor = encodeRecordCursor(sortKeys, &records[len(records)-1])
+	}
+
+	if len(q.Expand) > 0 {
+		if err := s.expandRecords(schema, records, q.Expand, q.Auth); err != nil {
+			return nil, err
+		}
+	}
+	projectRecordFields(records, q.Fields)
+
+	page.Records = records
+	return page, nil
+}
+
+// expandRecords loads the records referenced by relation fields and attaches
+// them under each record's Expand map. Related records hidden by the target
+// collection's view rule are left out.
+func (s *CollectionService) expandRecords(schema *dynamicfields.Schema, records []models.CollectionRecord, expand []string, auth *RecordAuth) error {
+	for _, name := range expand {
+		field, ok := schema.GetField(name)
+		target, isRelation := relationTarget(field)
+		if !ok || !isRelation {
+			return invalidQuery("field %q is not a relation and cannot be expanded", name)
+		}
+
+		targetCollection, err := s.GetCollection(target)
+		if err != nil {
+			return fmt.Errorf("expand %s: %w", name, err)
+		}
+		if err := s.checkOrganization(targetCollection, models.CollectionRuleView, auth); err != nil {
+			if errors.Is(err, ErrRecordForbidden) {
+				continue
+			}
+			return err
+		}
+
+		var ids []string
+		for i := range records {
+			ids = append(ids, relationIDs(records[i].Data[name])...)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		targetSchema, err := targetCollection.ParsedSchema()
+		if err != nil {
+			return fmt.Errorf("expand %s: invalid collection schema: %w", name, err)
+		}
+		relatedQuery := s.db.Where("collection_id = ? AND id IN ?", targetCollection.ID, ids)
+		ruleWhere, ruleArgs, err := s.ruleCondition(targetCollection, targetSchema, models.CollectionRuleView, auth)
+		if err != nil {
+			return err
+		}
+		if ruleWhere != "" {
+			relatedQuery = relatedQuery.Where(ruleWhere, ruleArgs...)
+		}
+
+		var related []models.CollectionRecord
+		if err := relatedQuery.Find(&related).Error; err != nil {
+			return err
+		}
+		byID := make(map[string]models.CollectionRecord, len(related))
+		for _, r := range related {
+			byID[r.ID.String()] = r
+		}
+
+		for i := range records {
+			value := records[i].Data[name]
+			if value == nil {
+				continue
+			}
+			if records[i].Expand == nil {
+				records[i].Expand = make(map[string]interface{})
+			}
+			switch v := value.(type) {
+			case []interface{}:
+				list := []models.CollectionRecord{}
+				for _, id := range relationIDs(v) {
+					if r, found := byID[id]; found {
+						list = append(list, r)
+					}
+				}
+				records[i].Expand[name] = list
+			case string:
+				if r, found := byID[v]; found {
+					records[i].Expand[name] = r
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetRecord loads a single record, checking the collection's view rule for auth
+func (s *CollectionService) GetRecord(collection *models.Collection, recordID string, auth *RecordAuth) (*models.CollectionRecord, error) {
+	if err := s.checkOrganization(collection, models.CollectionRuleView, auth); err != nil {
+		return nil, err
+	}
+	record, err := s.findRecord(collection, recordID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRecordRule(s.db.DB, collection, models.CollectionRuleView, auth, record.ID); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// findRecord loads a record without checking access rules
+func (s *CollectionService) findRecord(collection *models.Collection, recordID string) (*models.CollectionRecord, error) {
+	id, err := uuid.Parse(recordID)
+	if err != nil {
+		return nil, ErrRecordNotFound
+	}
+
+	var record models.CollectionRecord
+	if err := s.db.Where("collection_id = ? AND id = ?", collection.ID, id).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &record, nil
+}
+
+// CreateRecord validates data against the collection schema and stores it as a
+// new record owned by auth. Validation failures are returned as
+// *dynamicfields.ValidationErrors. The create rule is evaluated against the
+// inserted row inside the transaction, so a rejected record is rolled back.
+func (s *CollectionService) CreateRecord(collection *models.Collection, data map[string]interface{}, auth *RecordAuth) (*models.CollectionRecord, error) {
+	if err := s.checkOrganization(collection, models.CollectionRuleCreate, auth); err != nil {
+		return nil, err
+	}
+
+	normalized, err := s.prepareRecordData(collection, data)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.CollectionRecord{
+		CollectionID: collection.ID,
+		Data:         normalized,
+		UserID:       auth.ownerID(),
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Collection").Create(record).Error; err != nil {
+			return err
+		}
+		return s.checkRecordRule(tx, collection, models.CollectionRuleCreate, auth, record.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// UpdateRecord merges data into an existing record and re-validates the result.
+// The update rule is checked against the record before the change and again
+// against the updated record, which is rolled back if the rule rejects it.
+func (s *CollectionService) UpdateRecord(collection *models.Collection, recordID string, data map[string]interface{}, auth *RecordAuth) (*models.CollectionRecord, error) {
+	if err := s.checkOrganization(collection, models.CollectionRuleUpdate, auth); err != nil {
+		return nil, err
+	}
+	record, err := s.findRecord(collection, recordID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRecordRule(s.db.DB, collection, models.CollectionRuleUpdate, auth, record.ID); err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]interface{}, len(record.Data)+len(data))
+	for k, v := range record.Data {
+		merged[k] = v
+	}
+	for k, v := range data {
+		merged[k] = v
+	}
+
+	normalized, err := s.prepareRecordData(collection, merged)
+	if err != nil {
+		return nil, err
+	}
+
+	record.Data = normalized
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Collection").Save(record).Error; err != nil {
+			return err
+		}
+		return s.checkRecordRule(tx, collection, models.CollectionRuleUpdate, auth, record.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// DeleteRecord removes a record after checking the collection's delete rule
+func (s *CollectionService) DeleteRecord(collection *models.Collection, recordID string, auth *RecordAuth) error {
+	if err := s.checkOrganization(collection, models.CollectionRuleDelete, auth); err != nil {
+		return err
+	}
+	record, err := s.findRecord(collection, recordID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkRecordRule(s.db.DB, collection, models.CollectionRuleDelete, auth, record.ID); err != nil {
+		return err
+	}
+	return s.db.Delete(record).Error
+}
+
+// prepareRecordData drops unknown fields, applies schema defaults and validates the result
+func (s *CollectionService) prepareRecordData(collection *models.Collection, data map[string]interface{}) (models.JSON, error) {
+	schema, err := collection.ParsedSchema()
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection schema: %w", err)
+	}
+
+	mapper := dynamicfields.NewMapper(schema)
+	normalized := mapper.ApplyDefaults(mapper.FilterFields(data))
+
+	validator := dynamicfields.NewValidator(schema)
+	if verrs := validator.ValidateDocument(&dynamicfields.Document{Values: normalized}); verrs != nil {
+		return nil, verrs
+	}
+
+	return models.JSON(normalized), nil
+}
+
+// validateCollectionSchema checks the schema and makes sure every field name
+// is a safe identifier, since field names are used as JSON paths in queries
+func validateCollectionSchema(schema *dynamicfields.Schema) error {
+	if err := schema.Validate(); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	for _, field := range schema.Fields {
+		if err := utils.ValidateSQLIdentifier(field.Name); err != nil {
+			return fmt.Errorf("invalid field name %q: %w", field.Name, err)
+		}
+	}
+	return nil
+}
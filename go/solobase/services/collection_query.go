@@ -0,0 +1,463 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/suppers-ai/dynamicfields"
+	"github.com/suppers-ai/formulaengine"
+	"github.com/suppers-ai/solobase/models"
+)
+
+// ErrInvalidRecordQuery is returned when a filter, sort, cursor or expand parameter cannot be used
+var ErrInvalidRecordQuery = errors.New("invalid record query")
+
+// RecordQuery describes a filtered, sorted and paginated record listing.
+//
+// Filter uses the formulaengine condition grammar, e.g. `price > 10 && status = "open"`.
+// Sort is a comma separated list of fields, prefixed with "-" for descending order.
+// When Cursor is set it takes precedence over Page. Auth is the caller that
+// the collection's list rule is evaluated for.
+type RecordQuery struct {
+	Auth     *RecordAuth
+	Filter   string
+	Sort     string
+	Fields   []string
+	Expand   []string
+	Cursor   string
+	Page     int
+	PageSize int
+}
+
+// RecordPage is a single page of records returned by ListRecords
+type RecordPage struct {
+	Records    []models.CollectionRecord
+	Total      int
+	NextCursor string
+}
+
+// recordSystemColumns are the record table columns that can be used in filters and sorts
+var recordSystemColumns = map[string]dynamicfields.FieldType{
+	"id":         dynamicfields.FieldTypeText,
+	"user_id":    dynamicfields.FieldTypeText,
+	"created_at": dynamicfields.FieldTypeDateTime,
+	"updated_at": dynamicfields.FieldTypeDateTime,
+}
+
+var recordPathSegmentRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// recordSQLBuilder translates filter expressions and sort keys into SQL over the
+// JSON data column, using json_extract on SQLite and ->> / #>> on PostgreSQL.
+// Variables starting with "@" are looked up in vars and bound as values.
+type recordSQLBuilder struct {
+	dialect string
+	schema  *dynamicfields.Schema
+	vars    map[string]interface{}
+}
+
+// recordColumn is a resolved filter/sort operand
+type recordColumn struct {
+	name   string
+	sql    string
+	system bool
+	path   []string
+}
+
+func newRecordSQLBuilder(dialect string, schema *dynamicfields.Schema) *recordSQLBuilder {
+	return &recordSQLBuilder{dialect: dialect, schema: schema}
+}
+
+func invalidQuery(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidRecordQuery, fmt.Sprintf(format, args...))
+}
+
+// column resolves a field name (optionally dotted into nested objects) to an SQL expression
+func (b *recordSQLBuilder) column(name string) (*recordColumn, error) {
+	path := strings.Split(name, ".")
+	for _, segment := range path {
+		if !recordPathSegmentRegex.MatchString(segment) {
+			return nil, invalidQuery("invalid field name %q", name)
+		}
+	}
+
+	field, ok := b.schema.GetField(path[0])
+	if !ok {
+		if _, isSystem := recordSystemColumns[name]; isSystem {
+			return &recordColumn{name: name, sql: name, system: true}, nil
+		}
+		return nil, invalidQuery("unknown field %q", name)
+	}
+
+	// Walk nested object properties to find the type of the leaf value
+	for _, segment := range path[1:] {
+		if field == nil || field.Properties == nil {
+			field = nil
+			break
+		}
+		field = field.Properties[segment]
+	}
+
+	fieldType := dynamicfields.FieldTypeText
+	if field != nil {
+		fieldType = field.Type
+	}
+
+	return &recordColumn{name: name, sql: b.jsonExtract(path, fieldType), path: path}, nil
+}
+
+// jsonExtract builds the dialect specific expression that reads a JSON path from the data column
+func (b *recordSQLBuilder) jsonExtract(path []string, fieldType dynamicfields.FieldType) string {
+	if b.dialect == "postgres" {
+		var expr string
+		if len(path) == 1 {
+			expr = fmt.Sprintf("(data::jsonb ->> '%s')", path[0])
+		} else {
+			expr = fmt.Sprintf("(data::jsonb #>> '{%s}')", strings.Join(path, ","))
+		}
+		// ->> always yields text, so cast to keep numeric and boolean comparisons correct
+		switch fieldType {
+		case dynamicfields.FieldTypeNumber:
+			return expr + "::numeric"
+		case dynamicfields.FieldTypeBoolean:
+			return expr + "::boolean"
+		}
+		return expr
+	}
+	return fmt.Sprintf("json_extract(data, '$.%s')", strings.Join(path, "."))
+}
+
+// Where translates a parsed condition into an SQL WHERE fragment with bind arguments
+func (b *recordSQLBuilder) Where(expr formulaengine.Expression) (string, []interface{}, error) {
+	switch e := expr.(type) {
+	case *formulaengine.BinaryExpression:
+		switch e.Operator() {
+		case "&&", "||":
+			left, leftArgs, err := b.Where(e.Left())
+			if err != nil {
+				return "", nil, err
+			}
+			right, rightArgs, err := b.Where(e.Right())
+			if err != nil {
+				return "", nil, err
+			}
+			op := "AND"
+			if e.Operator() == "||" {
+				op = "OR"
+			}
+			return fmt.Sprintf("(%s %s %s)", left, op, right), append(leftArgs, rightArgs...), nil
+		case "==", "!=", "<", "<=", ">", ">=":
+			return b.comparison(e)
+		}
+		return "", nil, invalidQuery("unsupported operator %q", e.Operator())
+
+	case *formulaengine.LiteralExpression:
+		if v, ok := e.Value().(bool); ok {
+			if v {
+				return "1 = 1", nil, nil
+			}
+			return "1 = 0", nil, nil
+		}
+	}
+	return "", nil, invalidQuery("unsupported expression %q", expr.String())
+}
+
+// comparison translates a single comparison between fields and literals
+func (b *recordSQLBuilder) comparison(e *formulaengine.BinaryExpression) (string, []interface{}, error) {
+	op := e.Operator()
+	if op == "==" {
+		op = "="
+	} else if op == "!=" {
+		op = "<>"
+	}
+
+	// Comparisons that do not reference a record field are evaluated up front
+	if b.isStatic(e.Left()) && b.isStatic(e.Right()) {
+		result, err := e.Evaluate(context.Background(), formulaengine.NewSimpleResolver(b.vars))
+		if err != nil {
+			return "", nil, invalidQuery("%s: %v", e.String(), err)
+		}
+		if matched, _ := result.(bool); matched {
+			return "1 = 1", nil, nil
+		}
+		return "1 = 0", nil, nil
+	}
+
+	// field = null / field != null become IS [NOT] NULL
+	if lit, ok := e.Right().(*formulaengine.LiteralExpression); ok && lit.Value() == nil {
+		return b.nullComparison(e.Left(), op)
+	}
+	if lit, ok := e.Left().(*formulaengine.LiteralExpression); ok && lit.Value() == nil {
+		return b.nullComparison(e.Right(), op)
+	}
+
+	left, leftArgs, err := b.operand(e.Left())
+	if err != nil {
+		return "", nil, err
+	}
+	right, rightArgs, err := b.operand(e.Right())
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("%s %s %s", left, op, right), append(leftArgs, rightArgs...), nil
+}
+
+func (b *recordSQLBuilder) nullComparison(expr formulaengine.Expression, op string) (string, []interface{}, error) {
+	sql, args, err := b.operand(expr)
+	if err != nil {
+		return "", nil, err
+	}
+	switch op {
+	case "=":
+		return sql + " IS NULL", args, nil
+	case "<>":
+		return sql + " IS NOT NULL", args, nil
+	}
+	return "", nil, invalidQuery("null can only be compared with = or !=")
+}
+
+// operand translates a comparison side into SQL
+func (b *recordSQLBuilder) operand(expr formulaengine.Expression) (string, []interface{}, error) {
+	switch e := expr.(type) {
+	case *formulaengine.VariableExpression:
+		if strings.HasPrefix(e.Name(), "@") {
+			value, ok := b.vars[e.Name()]
+			if !ok {
+				return "", nil, invalidQuery("unknown variable %q", e.Name())
+			}
+			return "?", []interface{}{value}, nil
+		}
+		col, err := b.column(e.Name())
+		if err != nil {
+			return "", nil, err
+		}
+		return col.sql, nil, nil
+	case *formulaengine.LiteralExpression:
+		return "?", []interface{}{e.Value()}, nil
+	}
+	return "", nil, invalidQuery("unsupported operand %q", expr.String())
+}
+
+// isStatic reports whether an operand is a literal or a known @ variable
+func (b *recordSQLBuilder) isStatic(expr formulaengine.Expression) bool {
+	switch e := expr.(type) {
+	case *formulaengine.LiteralExpression:
+		return true
+	case *formulaengine.VariableExpression:
+		_, ok := b.vars[e.Name()]
+		return ok && strings.HasPrefix(e.Name(), "@")
+	}
+	return false
+}
+
+// recordSortKey is one ORDER BY entry
+type recordSortKey struct {
+	column *recordColumn
+	desc   bool
+}
+
+// parseSort parses "-price,title" into sort keys. The record ID is always
+// appended as a final tie-breaker so that cursors are stable.
+func (b *recordSQLBuilder) parseSort(sort string) ([]recordSortKey, error) {
+	if strings.TrimSpace(sort) == "" {
+		sort = "-created_at"
+	}
+
+	var keys []recordSortKey
+	hasID := false
+	for _, part := range strings.Split(sort, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		desc := false
+		if strings.HasPrefix(part, "-") {
+			desc = true
+			part = part[1:]
+		} else {
+			part = strings.TrimPrefix(part, "+")
+		}
+
+		col, err := b.column(part)
+		if err != nil {
+			return nil, err
+		}
+		if col.system && col.name == "id" {
+			hasID = true
+		}
+		keys = append(keys, recordSortKey{column: col, desc: desc})
+	}
+
+	if !hasID {
+		keys = append(keys, recordSortKey{column: &recordColumn{name: "id", sql: "id", system: true}})
+	}
+	return keys, nil
+}
+
+// orderBy renders sort keys as an ORDER BY clause. NULLs sort first ascending
+// and last descending on every dialect so cursor conditions stay consistent.
+func (b *recordSQLBuilder) orderBy(keys []recordSortKey) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		if key.desc {
+			parts[i] = key.column.sql + " DESC NULLS LAST"
+		} else {
+			parts[i] = key.column.sql + " ASC NULLS FIRST"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// recordCursor is the decoded form of an opaque pagination cursor
+type recordCursor struct {
+	Values []interface{} `json:"v"`
+}
+
+func encodeRecordCursor(keys []recordSortKey, record *models.CollectionRecord) string {
+	cursor := recordCursor{Values: make([]interface{}, len(keys))}
+	for i, key := range keys {
+		cursor.Values[i] = recordSortValue(key.column, record)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeRecordCursor(keys []recordSortKey, encoded string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, invalidQuery("malformed cursor")
+	}
+	var cursor recordCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || len(cursor.Values) != len(keys) {
+		return nil, invalidQuery("cursor does not match sort order")
+	}
+
+	// Timestamps travel as strings in the cursor; bind them back as times so
+	// they compare the same way the driver stored them
+	for i, key := range keys {
+		if key.column.system && recordSystemColumns[key.column.name] == dynamicfields.FieldTypeDateTime {
+			if s, ok := cursor.Values[i].(string); ok {
+				t, err := time.Parse(time.RFC3339Nano, s)
+				if err != nil {
+					return nil, invalidQuery("malformed cursor")
+				}
+				cursor.Values[i] = t
+			}
+		}
+	}
+	return cursor.Values, nil
+}
+
+// recordSortValue reads the value of a sort key from a loaded record
+func recordSortValue(col *recordColumn, record *models.CollectionRecord) interface{} {
+	if col.system {
+		switch col.name {
+		case "id":
+			return record.ID.String()
+		case "user_id":
+			if record.UserID == nil {
+				return nil
+			}
+			return record.UserID.String()
+		case "created_at":
+			return record.CreatedAt.UTC().Format(time.RFC3339Nano)
+		case "updated_at":
+			return record.UpdatedAt.UTC().Format(time.RFC3339Nano)
+		}
+		return nil
+	}
+
+	var current interface{} = map[string]interface{}(record.Data)
+	for _, segment := range col.path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[segment]
+	}
+	return current
+}
+
+// after builds the keyset condition selecting rows that sort after the cursor values
+func (b *recordSQLBuilder) after(keys []recordSortKey, values []interface{}) (string, []interface{}) {
+	key, value := keys[0], values[0]
+
+	var strictly string
+	var strictlyArgs []interface{}
+	switch {
+	case !key.desc && value == nil:
+		strictly = key.column.sql + " IS NOT NULL"
+	case !key.desc:
+		strictly, strictlyArgs = key.column.sql+" > ?", []interface{}{value}
+	case value == nil:
+		strictly = "1 = 0"
+	default:
+		strictly = fmt.Sprintf("(%s < ? OR %s IS NULL)", key.column.sql, key.column.sql)
+		strictlyArgs = []interface{}{value}
+	}
+
+	if len(keys) == 1 {
+		return strictly, strictlyArgs
+	}
+
+	var equal string
+	var equalArgs []interface{}
+	if value == nil {
+		equal = key.column.sql + " IS NULL"
+	} else {
+		equal, equalArgs = key.column.sql+" = ?", []interface{}{value}
+	}
+
+	rest, restArgs := b.after(keys[1:], values[1:])
+	args := append(strictlyArgs, equalArgs...)
+	args = append(args, restArgs...)
+	return fmt.Sprintf("(%s OR (%s AND %s))", strictly, equal, rest), args
+}
+
+// projectRecordFields trims record data down to the requested top-level fields
+func projectRecordFields(records []models.CollectionRecord, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	for i := range records {
+		projected := make(models.JSON, len(fields))
+		for _, field := range fields {
+			if value, ok := records[i].Data[field]; ok {
+				projected[field] = value
+			}
+		}
+		records[i].Data = projected
+	}
+}
+
+// relationTarget returns the collection a relation field points at. Relation
+// fields are text or array fields with a "collection" entry in their metadata.
+func relationTarget(field *dynamicfields.Field) (string, bool) {
+	if field == nil || field.Metadata == nil {
+		return "", false
+	}
+	target, ok := field.Metadata["collection"].(string)
+	return target, ok && target != ""
+}
+
+// relationIDs extracts referenced record IDs from a relation field value
+func relationIDs(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		ids := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				ids = append(ids, s)
+			}
+		}
+		return ids
+	}
+	return nil
+}
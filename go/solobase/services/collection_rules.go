@@ -0,0 +1,166 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/suppers-ai/dynamicfields"
+	"github.com/suppers-ai/formulaengine"
+	"github.com/suppers-ai/solobase/constants"
+	"github.com/suppers-ai/solobase/models"
+	"gorm.io/gorm"
+)
+
+// ErrRecordForbidden is returned when a collection access rule denies an operation
+var ErrRecordForbidden = errors.New("access to record denied")
+
+// RecordAuth identifies the caller that collection access rules are evaluated for.
+//
+// Rules are formulaengine conditions stored in Collection.AuthRules under the
+// list, view, create, update and delete keys, e.g. `user_id = @request.auth.id`.
+// An operation without a rule is open to every authenticated caller. Records of
+// a collection owned by an organization are only open to its members, and
+// viewers can only list and view them. Admins bypass rules and organization
+// membership, and a nil *RecordAuth marks an internal call that is not checked.
+type RecordAuth struct {
+	UserID string
+	Role   string
+}
+
+// bypassesRules reports whether rules should be skipped for this caller
+func (a *RecordAuth) bypassesRules() bool {
+	return a == nil || a.Role == constants.RoleAdmin.String()
+}
+
+// variables returns the @request values a rule can reference
+func (a *RecordAuth) variables() map[string]interface{} {
+	vars := map[string]interface{}{
+		"@request.auth.id":   nil,
+		"@request.auth.role": nil,
+	}
+	if a != nil && a.UserID != "" {
+		vars["@request.auth.id"] = a.UserID
+		vars["@request.auth.role"] = a.Role
+	}
+	return vars
+}
+
+// ownerID returns the caller's user ID as the owner of new records
+func (a *RecordAuth) ownerID() *uuid.UUID {
+	if a == nil {
+		return nil
+	}
+	id, err := uuid.Parse(a.UserID)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// ruleCondition translates a collection's rule for an operation into an SQL
+// condition. An empty condition means the operation is not restricted.
+func (s *CollectionService) ruleCondition(collection *models.Collection, schema *dynamicfields.Schema, operation string, auth *RecordAuth) (string, []interface{}, error) {
+	rule := strings.TrimSpace(collection.Rule(operation))
+	if rule == "" || auth.bypassesRules() {
+		return "", nil, nil
+	}
+
+	builder := newRecordSQLBuilder(s.db.Dialector.Name(), schema)
+	builder.vars = auth.variables()
+
+	where, args, err := builder.rule(rule)
+	if err != nil {
+		return "", nil, fmt.Errorf("collection %s has an invalid %s rule: %v", collection.Name, operation, err)
+	}
+	return where, args, nil
+}
+
+// checkOrganization enforces organization membership for collections owned
+// by an organization, returning ErrRecordForbidden for outsiders and for
+// viewers attempting to change records
+func (s *CollectionService) checkOrganization(collection *models.Collection, operation string, auth *RecordAuth) error {
+	if collection.OrganizationID == nil || auth.bypassesRules() {
+		return nil
+	}
+
+	role, err := organizationRole(s.db.DB, *collection.OrganizationID, auth.UserID)
+	if errors.Is(err, ErrNotOrganizationMember) {
+		return ErrRecordForbidden
+	}
+	if err != nil {
+		return err
+	}
+	if operation != models.CollectionRuleList && operation != models.CollectionRuleView && !OrgRoleAtLeast(role, OrgRoleMember) {
+		return ErrRecordForbidden
+	}
+	return nil
+}
+
+// checkRecordRule verifies that a stored record satisfies the rule for an
+// operation, returning ErrRecordForbidden when it does not
+func (s *CollectionService) checkRecordRule(tx *gorm.DB, collection *models.Collection, operation string, auth *RecordAuth, recordID uuid.UUID) error {
+	schema, err := collection.ParsedSchema()
+	if err != nil {
+		return fmt.Errorf("invalid collection schema: %w", err)
+	}
+
+	where, args, err := s.ruleCondition(collection, schema, operation, auth)
+	if err != nil || where == "" {
+		return err
+	}
+
+	var count int64
+	if err := tx.Model(&models.CollectionRecord{}).
+		Where("collection_id = ? AND id = ?", collection.ID, recordID).
+		Where(where, args...).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrRecordForbidden
+	}
+	return nil
+}
+
+// validateCollectionRules makes sure every rule targets a known operation and
+// can be translated to SQL against the collection schema
+func validateCollectionRules(schema *dynamicfields.Schema, rules models.JSON) error {
+	for operation, value := range rules {
+		known := false
+		for _, op := range models.CollectionRuleOperations {
+			if op == operation {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown rule operation %q", operation)
+		}
+
+		rule, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s rule must be a string", operation)
+		}
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+
+		builder := newRecordSQLBuilder("", schema)
+		builder.vars = (&RecordAuth{}).variables()
+		if _, _, err := builder.rule(rule); err != nil {
+			return fmt.Errorf("invalid %s rule: %w", operation, err)
+		}
+	}
+	return nil
+}
+
+// rule parses a rule condition and translates it to SQL
+func (b *recordSQLBuilder) rule(rule string) (string, []interface{}, error) {
+	expr, err := formulaengine.NewFormulaParser().ParseCondition(rule)
+	if err != nil {
+		return "", nil, err
+	}
+	return b.Where(expr)
+}
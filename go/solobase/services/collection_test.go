@@ -0,0 +1,307 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suppers-ai/dynamicfields"
+	"github.com/suppers-ai/solobase/constants"
+	"github.com/suppers-ai/solobase/database"
+	"github.com/suppers-ai/solobase/models"
+)
+
+func newTestCollectionService(t *testing.T) *CollectionService {
+	db, err := database.New(database.Config{Type: "sqlite", Database: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Collection{}, &models.CollectionRecord{}))
+	return NewCollectionService(db)
+}
+
+func createTestCollection(t *testing.T, s *CollectionService, name string, fields ...*dynamicfields.Field) *models.Collection {
+	collection, err := s.CreateCollection(CollectionInput{Name: name, Schema: &dynamicfields.Schema{Fields: fields}})
+	require.NoError(t, err)
+	return collection
+}
+
+func TestExpandSkipsValuesThatAreNotIDs(t *testing.T) {
+	s := newTestCollectionService(t)
+	authors := createTestCollection(t, s, "authors", &dynamicfields.Field{Name: "name", Type: dynamicfields.FieldTypeText})
+	posts := createTestCollection(t, s, "posts", &dynamicfields.Field{
+		Name:     "author",
+		Type:     dynamicfields.FieldTypeText,
+		Metadata: map[string]interface{}{"collection": "authors"},
+	})
+
+	author, err := s.CreateRecord(authors, map[string]interface{}{"name": "Ada"}, nil)
+	require.NoError(t, err)
+	linked, err := s.CreateRecord(posts, map[string]interface{}{"author": author.ID.String()}, nil)
+	require.NoError(t, err)
+	// Records are not migrated when a field changes type, so older ones may
+	// hold values a relation would not accept
+	for _, value := range []interface{}{42, true, map[string]interface{}{"id": author.ID.String()}} {
+		record, err := s.CreateRecord(posts, map[string]interface{}{}, nil)
+		require.NoError(t, err)
+		require.NoError(t, s.db.Model(record).Update("data", models.JSON{"author": value}).Error)
+	}
+
+	page, err := s.ListRecords(posts, RecordQuery{Expand: []string{"author"}, PageSize: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Records, 4)
+	for _, record := range page.Records {
+		if record.ID == linked.ID {
+			expanded := record.Expand["author"].(models.CollectionRecord)
+			assert.Equal(t, author.ID, expanded.ID)
+		} else {
+			assert.NotContains(t, record.Expand, "author")
+		}
+	}
+}
+
+func TestCollectionCRUD(t *testing.T) {
+	s := newTestCollectionService(t)
+	collection := createTestCollection(t, s, "notes", &dynamicfields.Field{Name: "title", Type: dynamicfields.FieldTypeText})
+
+	byName, err := s.GetCollection("notes")
+	require.NoError(t, err)
+	byID, err := s.GetCollection(collection.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, byName.ID, byID.ID)
+
+	_, err = s.CreateCollection(CollectionInput{Name: "bad-name"})
+	assert.Error(t, err)
+	_, err = s.CreateCollection(CollectionInput{Name: "dupe", Schema: &dynamicfields.Schema{Fields: []*dynamicfields.Field{
+		{Name: "a b", Type: dynamicfields.FieldTypeText},
+	}}})
+	assert.Error(t, err)
+
+	updated, err := s.UpdateCollection("notes", map[string]interface{}{
+		"display_name": "Notes",
+		"schema": map[string]interface{}{"fields": []interface{}{
+			map[string]interface{}{"name": "title", "type": "text", "required": true},
+			map[string]interface{}{"name": "body", "type": "text"},
+		}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Notes", updated.DisplayName)
+	schema, err := updated.ParsedSchema()
+	require.NoError(t, err)
+	assert.Len(t, schema.Fields, 2)
+	assert.Equal(t, "notes", schema.Name)
+
+	// Rules cannot refer to fields the schema does not have
+	_, err = s.UpdateCollection("notes", map[string]interface{}{
+		"auth_rules": map[string]interface{}{"list": "missing = 1"},
+	})
+	assert.Error(t, err)
+
+	_, err = s.CreateRecord(updated, map[string]interface{}{"title": "first"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteCollection(collection.ID.String()))
+	_, err = s.GetCollection("notes")
+	assert.ErrorIs(t, err, ErrCollectionNotFound)
+	var records int64
+	require.NoError(t, s.db.Model(&models.CollectionRecord{}).Where("collection_id = ?", collection.ID).Count(&records).Error)
+	assert.Zero(t, records)
+	assert.ErrorIs(t, s.DeleteCollection("notes"), ErrCollectionNotFound)
+}
+
+func TestGetCollectionByName(t *testing.T) {
+	s := newTestCollectionService(t)
+
+	// A name made of 32 hex digits also parses as an ID
+	name := "deadbeefdeadbeefdeadbeefdeadbeef"
+	_, err := uuid.Parse(name)
+	require.NoError(t, err)
+	collection := createTestCollection(t, s, name)
+
+	found, err := s.GetCollectionByName(name)
+	require.NoError(t, err)
+	assert.Equal(t, collection.ID, found.ID)
+	_, err = s.GetCollection(name)
+	assert.ErrorIs(t, err, ErrCollectionNotFound)
+	_, err = s.GetCollectionByName(collection.ID.String())
+	assert.ErrorIs(t, err, ErrCollectionNotFound)
+}
+
+func TestRecordValidation(t *testing.T) {
+	s := newTestCollectionService(t)
+	minimum := 0.0
+	collection := createTestCollection(t, s, "products",
+		&dynamicfields.Field{Name: "name", Type: dynamicfields.FieldTypeText, Required: true},
+		&dynamicfields.Field{Name: "price", Type: dynamicfields.FieldTypeNumber, Validation: &dynamicfields.ValidationRules{Min: &minimum}},
+		&dynamicfields.Field{Name: "status", Type: dynamicfields.FieldTypeText, DefaultValue: "draft"},
+	)
+
+	_, err := s.CreateRecord(collection, map[string]interface{}{"price": 5.0}, nil)
+	var verrs *dynamicfields.ValidationErrors
+	require.ErrorAs(t, err, &verrs)
+	assert.Equal(t, "name", verrs.Errors[0].Field)
+
+	_, err = s.CreateRecord(collection, map[string]interface{}{"name": "Pen", "price": -1.0}, nil)
+	require.ErrorAs(t, err, &verrs)
+	assert.Equal(t, "price", verrs.Errors[0].Field)
+
+	// Unknown fields are dropped and defaults filled in
+	record, err := s.CreateRecord(collection, map[string]interface{}{"name": "Pen", "price": 2.5, "extra": true}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "draft", record.Data["status"])
+	assert.NotContains(t, record.Data, "extra")
+
+	// Updates are merged into the record and validated as a whole
+	updated, err := s.UpdateRecord(collection, record.ID.String(), map[string]interface{}{"price": 3.0}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Pen", updated.Data["name"])
+	assert.EqualValues(t, 3.0, updated.Data["price"])
+	_, err = s.UpdateRecord(collection, record.ID.String(), map[string]interface{}{"price": -3.0}, nil)
+	require.ErrorAs(t, err, &verrs)
+
+	fetched, err := s.GetRecord(collection, record.ID.String(), nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3.0, fetched.Data["price"])
+
+	require.NoError(t, s.DeleteRecord(collection, record.ID.String(), nil))
+	_, err = s.GetRecord(collection, record.ID.String(), nil)
+	assert.ErrorIs(t, err, ErrRecordNotFound)
+	_, err = s.GetRecord(collection, "not-an-id", nil)
+	assert.ErrorIs(t, err, ErrRecordNotFound)
+}
+
+// createRuleTestCollection creates a collection whose drafts are private to
+// their owner and whose records cannot be created as published
+func createRuleTestCollection(t *testing.T, s *CollectionService) *models.Collection {
+	collection, err := s.CreateCollection(CollectionInput{
+		Name: "posts",
+		Schema: &dynamicfields.Schema{Fields: []*dynamicfields.Field{
+			{Name: "title", Type: dynamicfields.FieldTypeText},
+			{Name: "status", Type: dynamicfields.FieldTypeText, DefaultValue: "draft"},
+		}},
+		AuthRules: map[string]interface{}{
+			models.CollectionRuleList:   "user_id = @request.auth.id || status = 'published'",
+			models.CollectionRuleView:   "user_id = @request.auth.id || status = 'published'",
+			models.CollectionRuleCreate: "status != 'published'",
+			models.CollectionRuleUpdate: "user_id = @request.auth.id",
+			models.CollectionRuleDelete: "user_id = @request.auth.id",
+		},
+	})
+	require.NoError(t, err)
+	return collection
+}
+
+func listRecordIDs(t *testing.T, s *CollectionService, collection *models.Collection, auth *RecordAuth) []uuid.UUID {
+	page, err := s.ListRecords(collection, RecordQuery{Auth: auth, PageSize: 10})
+	require.NoError(t, err)
+	var ids []uuid.UUID
+	for _, record := range page.Records {
+		ids = append(ids, record.ID)
+	}
+	return ids
+}
+
+func TestRecordRules(t *testing.T) {
+	s := newTestCollectionService(t)
+	collection := createRuleTestCollection(t, s)
+	alice := &RecordAuth{UserID: uuid.New().String(), Role: "user"}
+	bob := &RecordAuth{UserID: uuid.New().String(), Role: "user"}
+
+	draft, err := s.CreateRecord(collection, map[string]interface{}{"title": "Draft"}, alice)
+	require.NoError(t, err)
+	published, err := s.CreateRecord(collection, map[string]interface{}{"title": "News", "status": "published"}, nil)
+	require.NoError(t, err)
+
+	// Callers only list the records the list rule lets them see
+	assert.ElementsMatch(t, []uuid.UUID{draft.ID, published.ID}, listRecordIDs(t, s, collection, alice))
+	assert.Equal(t, []uuid.UUID{published.ID}, listRecordIDs(t, s, collection, bob))
+	assert.Equal(t, []uuid.UUID{published.ID}, listRecordIDs(t, s, collection, &RecordAuth{}))
+
+	_, err = s.GetRecord(collection, draft.ID.String(), alice)
+	require.NoError(t, err)
+	_, err = s.GetRecord(collection, draft.ID.String(), bob)
+	assert.ErrorIs(t, err, ErrRecordForbidden)
+	_, err = s.GetRecord(collection, published.ID.String(), bob)
+	require.NoError(t, err)
+
+	_, err = s.UpdateRecord(collection, draft.ID.String(), map[string]interface{}{"title": "Taken"}, bob)
+	assert.ErrorIs(t, err, ErrRecordForbidden)
+	assert.ErrorIs(t, s.DeleteRecord(collection, draft.ID.String(), bob), ErrRecordForbidden)
+	stored, err := s.GetRecord(collection, draft.ID.String(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Draft", stored.Data["title"])
+
+	updated, err := s.UpdateRecord(collection, draft.ID.String(), map[string]interface{}{"title": "Edited"}, alice)
+	require.NoError(t, err)
+	assert.Equal(t, "Edited", updated.Data["title"])
+	require.NoError(t, s.DeleteRecord(collection, draft.ID.String(), alice))
+}
+
+func TestCreateRuleChecksInsertedRecord(t *testing.T) {
+	s := newTestCollectionService(t)
+	collection := createRuleTestCollection(t, s)
+	alice := &RecordAuth{UserID: uuid.New().String(), Role: "user"}
+
+	// The rule sees the record as stored, defaults included, and a rejected
+	// record is rolled back
+	_, err := s.CreateRecord(collection, map[string]interface{}{"title": "News", "status": "published"}, alice)
+	assert.ErrorIs(t, err, ErrRecordForbidden)
+	var count int64
+	require.NoError(t, s.db.Model(&models.CollectionRecord{}).Where("collection_id = ?", collection.ID).Count(&count).Error)
+	assert.Zero(t, count)
+
+	record, err := s.CreateRecord(collection, map[string]interface{}{"title": "Draft"}, alice)
+	require.NoError(t, err)
+	assert.Equal(t, "draft", record.Data["status"])
+	require.NotNil(t, record.UserID)
+	assert.Equal(t, alice.UserID, record.UserID.String())
+}
+
+func TestUpdateRuleChecksUpdatedRecord(t *testing.T) {
+	s := newTestCollectionService(t)
+	collection, err := s.CreateCollection(CollectionInput{
+		Name: "posts",
+		Schema: &dynamicfields.Schema{Fields: []*dynamicfields.Field{
+			{Name: "title", Type: dynamicfields.FieldTypeText},
+			{Name: "status", Type: dynamicfields.FieldTypeText, DefaultValue: "draft"},
+		}},
+		AuthRules: map[string]interface{}{
+			models.CollectionRuleUpdate: "user_id = @request.auth.id && status != 'published'",
+		},
+	})
+	require.NoError(t, err)
+	alice := &RecordAuth{UserID: uuid.New().String(), Role: "user"}
+	record, err := s.CreateRecord(collection, map[string]interface{}{"title": "Draft"}, alice)
+	require.NoError(t, err)
+
+	// Owners may edit their drafts but not publish them, so the change is
+	// rolled back
+	_, err = s.UpdateRecord(collection, record.ID.String(), map[string]interface{}{"status": "published"}, alice)
+	assert.ErrorIs(t, err, ErrRecordForbidden)
+	stored, err := s.GetRecord(collection, record.ID.String(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "draft", stored.Data["status"])
+
+	updated, err := s.UpdateRecord(collection, record.ID.String(), map[string]interface{}{"title": "Edited"}, alice)
+	require.NoError(t, err)
+	assert.Equal(t, "Edited", updated.Data["title"])
+}
+
+func TestAdminsBypassRecordRules(t *testing.T) {
+	s := newTestCollectionService(t)
+	collection := createRuleTestCollection(t, s)
+	alice := &RecordAuth{UserID: uuid.New().String(), Role: "user"}
+	admin := &RecordAuth{UserID: uuid.New().String(), Role: constants.RoleAdmin.String()}
+
+	draft, err := s.CreateRecord(collection, map[string]interface{}{"title": "Draft"}, alice)
+	require.NoError(t, err)
+
+	assert.Equal(t, []uuid.UUID{draft.ID}, listRecordIDs(t, s, collection, admin))
+	_, err = s.GetRecord(collection, draft.ID.String(), admin)
+	require.NoError(t, err)
+	_, err = s.UpdateRecord(collection, draft.ID.String(), map[string]interface{}{"title": "Reviewed"}, admin)
+	require.NoError(t, err)
+	published, err := s.CreateRecord(collection, map[string]interface{}{"title": "News", "status": "published"}, admin)
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteRecord(collection, draft.ID.String(), admin))
+	require.NoError(t, s.DeleteRecord(collection, published.ID.String(), admin))
+}
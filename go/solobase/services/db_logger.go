@@ -239,8 +239,9 @@ func (l *DBLogger) GetRequestLogs(ctx context.Context, filter logger.RequestLogF
 	return []*logger.RequestLog{}, nil
 }
 
-// LogHTTPRequest logs an HTTP request to the request_logs table
-func (l *DBLogger) LogHTTPRequest(ctx context.Context, method, path string, statusCode int, duration time.Duration, userIP, userAgent string, userID *string, err error) {
+// LogHTTPRequest logs an HTTP request to the request_logs table. impersonatorID
+// is set for requests an admin made while impersonating userID.
+func (l *DBLogger) LogHTTPRequest(ctx context.Context, method, path string, statusCode int, duration time.Duration, userIP, userAgent string, userID, impersonatorID *string, err error) {
 	var errorStr *string
 	if err != nil {
 		errMsg := err.Error()
@@ -256,17 +257,18 @@ func (l *DBLogger) LogHTTPRequest(ctx context.Context, method, path string, stat
 	}
 
 	requestLog := &logger.RequestLogModel{
-		ID:         uuid.New(),
-		Level:      level,
-		Method:     method,
-		Path:       path,
-		StatusCode: statusCode,
-		ExecTimeMs: duration.Milliseconds(),
-		UserIP:     userIP,
-		UserAgent:  &userAgent,
-		UserID:     userID,
-		Error:      errorStr,
-		CreatedAt:  time.Now(),
+		ID:             uuid.New(),
+		Level:          level,
+		Method:         method,
+		Path:           path,
+		StatusCode:     statusCode,
+		ExecTimeMs:     duration.Milliseconds(),
+		UserIP:         userIP,
+		UserAgent:      &userAgent,
+		UserID:         userID,
+		ImpersonatorID: impersonatorID,
+		Error:          errorStr,
+		CreatedAt:      time.Now(),
 	}
 
 	// Send to channel for async batch processing (non-blocking)
@@ -278,6 +280,21 @@ func (l *DBLogger) LogHTTPRequest(ctx context.Context, method, path string, stat
 	}
 }
 
+// RequestAudit records who made a request. HTTPLoggingMiddleware puts one in
+// the request context and the auth middleware fills it in, since the caller is
+// only known once the request reaches the API routes.
+type RequestAudit struct {
+	UserID         string
+	ImpersonatorID string
+}
+
+// RequestAuditFromContext returns the request's audit record, or nil when the
+// request is not being logged
+func RequestAuditFromContext(ctx context.Context) *RequestAudit {
+	audit, _ := ctx.Value("requestAudit").(*RequestAudit)
+	return audit
+}
+
 // HTTPLoggingMiddleware creates a middleware that logs HTTP requests to database
 func HTTPLoggingMiddleware(dbLogger *DBLogger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -291,13 +308,21 @@ func HTTPLoggingMiddleware(dbLogger *DBLogger) func(http.Handler) http.Handler {
 			}
 			
 			// Get user ID from context if available
-			var userID *string
+			var userID, impersonatorID *string
 			if uid, ok := r.Context().Value("user_id").(string); ok {
 				userID = &uid
 			}
+			audit := &RequestAudit{}
 			
 			// Process request
-			next.ServeHTTP(wrapped, r)
+			next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), "requestAudit", audit)))
+			
+			if audit.UserID != "" {
+				userID = &audit.UserID
+			}
+			if audit.ImpersonatorID != "" {
+				impersonatorID = &audit.ImpersonatorID
+			}
 			
 			// Calculate duration
 			duration := time.Since(start)
@@ -312,6 +337,7 @@ func HTTPLoggingMiddleware(dbLogger *DBLogger) func(http.Handler) http.Handler {
 				r.RemoteAddr,
 				r.UserAgent(),
 				userID,
+				impersonatorID,
 				nil,
 			)
 		})
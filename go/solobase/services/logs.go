@@ -68,8 +68,9 @@ func (s *LogsService) GetLogs(page, size int, level, search, timeRange string) (
 	return logs, total, nil
 }
 
-// GetRequestLogs retrieves request logs with filters
-func (s *LogsService) GetRequestLogs(page, size int, method, path, timeRange string, minStatus, maxStatus int) ([]logger.RequestLogModel, int64, error) {
+// GetRequestLogs retrieves request logs with filters. impersonated limits them
+// to requests made by admins impersonating a user.
+func (s *LogsService) GetRequestLogs(page, size int, method, path, timeRange string, minStatus, maxStatus int, impersonated bool) ([]logger.RequestLogModel, int64, error) {
 	var logs []logger.RequestLogModel
 	var total int64
 
@@ -88,6 +89,10 @@ func (s *LogsService) GetRequestLogs(page, size int, method, path, timeRange str
 		query = query.Where("status_code >= ? AND status_code <= ?", minStatus, maxStatus)
 	}
 
+	if impersonated {
+		query = query.Where("impersonator_id IS NOT NULL")
+	}
+
 	// Apply time range filter
 	if timeRange != "" {
 		var startTime time.Time
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"time"
+
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/mailer"
+	"github.com/suppers-ai/solobase/models"
+)
+
+// ErrMailNotConfigured is returned when sending mail while SMTP is disabled or incomplete
+var ErrMailNotConfigured = errors.New("email delivery is not configured")
+
+// MailService sends the application's transactional emails using the SMTP
+// settings managed by SettingsService
+type MailService struct {
+	settings *SettingsService
+	// mailer overrides the SMTP mailer built from the settings, e.g. with mailer.NewMock()
+	mailer mailer.Mailer
+}
+
+func NewMailService(settingsService *SettingsService) *MailService {
+	return &MailService{settings: settingsService}
+}
+
+// SetMailer makes the service send through m instead of the configured SMTP server
+func (s *MailService) SetMailer(m mailer.Mailer) {
+	s.mailer = m
+}
+
+// Enabled reports whether emails can currently be sent
+func (s *MailService) Enabled() bool {
+	if s.mailer != nil {
+		return true
+	}
+	settings, err := s.settings.GetSettings()
+	return err == nil && smtpConfigured(settings)
+}
+
+// SendWelcome sends the welcome email, with a confirmation link if confirmURL is set
+func (s *MailService) SendWelcome(ctx context.Context, user *auth.User, confirmURL string) error {
+	return s.send(ctx, user, "Welcome to %s", mailer.PrebuiltTemplates{}.Welcome(), mailer.TemplateData{
+		"ConfirmURL": confirmURL,
+	})
+}
+
+// SendPasswordReset sends a link to choose a new password that expires after validFor
+func (s *MailService) SendPasswordReset(ctx context.Context, user *auth.User, resetURL string, validFor time.Duration) error {
+	expireHours := int(validFor.Hours())
+	if expireHours < 1 {
+		expireHours = 1
+	}
+	return s.send(ctx, user, "Reset your %s password", mailer.PrebuiltTemplates{}.PasswordReset(), mailer.TemplateData{
+		"ResetURL":    resetURL,
+		"ExpireHours": expireHours,
+	})
+}
+
+// SendAccountLocked tells a user their account was locked after repeated
+// failed logins, with a link to unlock it
+func (s *MailService) SendAccountLocked(ctx context.Context, user *auth.User, unlockURL string, lockedUntil time.Time) error {
+	message := fmt.Sprintf(
+		"Your account was locked after too many failed login attempts. It unlocks automatically at %s, "+
+			"or you can unlock it now with the link below. If these attempts were not you, consider changing your password.",
+		template.HTMLEscapeString(lockedUntil.UTC().Format("2006-01-02 15:04 MST")))
+	return s.send(ctx, user, "Your %s account has been locked", mailer.PrebuiltTemplates{}.Notification(), mailer.TemplateData{
+		"Title":        "Account locked",
+		"AlertType":    "warning",
+		"AlertMessage": "Too many failed login attempts",
+		"Message":      message,
+		"ActionURL":    unlockURL,
+		"ActionText":   "Unlock my account",
+	})
+}
+
+// SendMagicLink sends a single-use link that logs the user in without a password
+func (s *MailService) SendMagicLink(ctx context.Context, user *auth.User, loginURL string, validFor time.Duration) error {
+	minutes := int(validFor.Minutes())
+	if minutes < 1 {
+		minutes = 1
+	}
+	message := fmt.Sprintf(
+		"Use the link below to log in. It works once and expires in %d minutes. "+
+			"If you did not ask to log in, you can ignore this email.", minutes)
+	return s.send(ctx, user, "Your %s login link", mailer.PrebuiltTemplates{}.Notification(), mailer.TemplateData{
+		"Title":      "Log in",
+		"AlertType":  "info",
+		"Message":    message,
+		"ActionURL":  loginURL,
+		"ActionText": "Log in",
+	})
+}
+
+// SendOrganizationInvite invites an email address to join an organization.
+// The invitee may not have an account yet.
+func (s *MailService) SendOrganizationInvite(ctx context.Context, email, organization, inviter, inviteURL string, expiresAt time.Time) error {
+	message := fmt.Sprintf(
+		"%s invited you to join the %s organization. Accept the invitation with the link below before %s, "+
+			"signing up with this email address first if you do not have an account yet.",
+		template.HTMLEscapeString(inviter), template.HTMLEscapeString(organization),
+		template.HTMLEscapeString(expiresAt.UTC().Format("2006-01-02 15:04 MST")))
+	return s.send(ctx, &auth.User{Email: email}, "You have been invited to an organization on %s", mailer.PrebuiltTemplates{}.Notification(), mailer.TemplateData{
+		"Title":      "Organization invitation",
+		"AlertType":  "info",
+		"Message":    message,
+		"ActionURL":  inviteURL,
+		"ActionText": "Accept invitation",
+	})
+}
+
+// send renders an HTML template with the common app data and mails it to the
+// user. The subject is a format string for the app name.
+func (s *MailService) send(ctx context.Context, user *auth.User, subject, body string, data mailer.TemplateData) error {
+	settings, err := s.settings.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	m := s.mailer
+	if m == nil {
+		if !smtpConfigured(settings) {
+			return ErrMailNotConfigured
+		}
+		if settings.SMTPPassword, err = s.settings.GetSecret("smtp_password"); err != nil {
+			return err
+		}
+		smtpMailer, err := mailer.NewSMTP(smtpMailerConfig(settings))
+		if err != nil {
+			return err
+		}
+		defer smtpMailer.Close()
+		m = smtpMailer
+	}
+
+	data["AppName"] = settings.AppName
+	data["Name"] = displayName(user)
+	data["Year"] = time.Now().Year()
+
+	html, err := renderMailTemplate(body, data)
+	if err != nil {
+		return err
+	}
+
+	return m.Send(ctx, &mailer.Email{
+		From:     mailer.Address{Name: settings.AppName, Email: mailFromAddress(settings)},
+		To:       []mailer.Address{{Email: user.Email}},
+		Subject:  fmt.Sprintf(subject, settings.AppName),
+		HTMLBody: html,
+	})
+}
+
+func smtpConfigured(settings *models.AppSettings) bool {
+	return settings.SMTPEnabled && settings.SMTPHost != "" && mailFromAddress(settings) != ""
+}
+
+func smtpMailerConfig(settings *models.AppSettings) mailer.Config {
+	return mailer.Config{
+		Provider: "smtp",
+		From:     mailer.Address{Name: settings.AppName, Email: mailFromAddress(settings)},
+		Timeout:  30 * time.Second,
+		Extra: map[string]interface{}{
+			"smtp_host":      settings.SMTPHost,
+			"smtp_port":      settings.SMTPPort,
+			"smtp_username":  settings.SMTPUser,
+			"smtp_password":  settings.SMTPPassword,
+			"smtp_pool_size": 1,
+		},
+	}
+}
+
+// mailFromAddress falls back to the SMTP user, which is usually an address too
+func mailFromAddress(settings *models.AppSettings) string {
+	if settings.SMTPFrom != "" {
+		return settings.SMTPFrom
+	}
+	return settings.SMTPUser
+}
+
+func displayName(user *auth.User) string {
+	switch {
+	case user.DisplayName != "":
+		return user.DisplayName
+	case user.FirstName != "":
+		return user.FirstName
+	default:
+		return user.Email
+	}
+}
+
+// mailTemplateFuncs are the helpers used by the mailer's prebuilt templates
+var mailTemplateFuncs = template.FuncMap{
+	"safe": func(s string) template.HTML { return template.HTML(s) },
+	"default": func(def, val interface{}) interface{} {
+		if val == nil || val == "" {
+			return def
+		}
+		return val
+	},
+}
+
+func renderMailTemplate(text string, data mailer.TemplateData) (string, error) {
+	tmpl, err := template.New("email").Funcs(mailTemplateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,642 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/suppers-ai/solobase/database"
+	"github.com/suppers-ai/solobase/models"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// Built-in OAuth provider presets
+const (
+	OAuthPresetGoogle    = "google"
+	OAuthPresetGitHub    = "github"
+	OAuthPresetMicrosoft = "microsoft"
+)
+
+const (
+	// jwksMinRefresh limits how often an issuer's signing keys are fetched again
+	// when a token names an unknown key
+	jwksMinRefresh = time.Minute
+	// idTokenLeeway tolerates clock skew between us and the issuer
+	idTokenLeeway = time.Minute
+)
+
+var (
+	// ErrOAuthProviderNotFound is returned for unknown or disabled providers
+	ErrOAuthProviderNotFound = errors.New("login provider not found")
+	// ErrInvalidIDToken is returned when a provider's ID token fails verification
+	ErrInvalidIDToken = errors.New("invalid ID token")
+)
+
+var oauthProviderNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// oauthPreset holds the settings of a well known provider. OIDC providers
+// only need an issuer; others list their endpoints and load the user themselves.
+type oauthPreset struct {
+	displayName string
+	issuer      string
+	authURL     string
+	tokenURL    string
+	scopes      []string
+	userInfo    func(ctx context.Context, client *http.Client, token *oauth2.Token) (*ExternalIdentity, error)
+}
+
+var oauthPresets = map[string]oauthPreset{
+	OAuthPresetGoogle: {
+		displayName: "Google",
+		issuer:      "https://accounts.google.com",
+		scopes:      []string{"openid", "email", "profile"},
+	},
+	OAuthPresetMicrosoft: {
+		displayName: "Microsoft",
+		issuer:      "https://login.microsoftonline.com/common/v2.0",
+		scopes:      []string{"openid", "email", "profile"},
+	},
+	OAuthPresetGitHub: {
+		displayName: "GitHub",
+		authURL:     "https://github.com/login/oauth/authorize",
+		tokenURL:    "https://github.com/login/oauth/access_token",
+		scopes:      []string{"read:user", "user:email"},
+		userInfo:    githubUserInfo,
+	},
+}
+
+// ExternalIdentity is the account a provider vouched for at the end of a login
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// OAuthLoginRequest holds the values that tie a provider's callback to the
+// login that started it
+type OAuthLoginRequest struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code verifier
+}
+
+// NewOAuthLoginRequest generates the state, nonce and PKCE verifier for a login
+func NewOAuthLoginRequest(provider string) (*OAuthLoginRequest, error) {
+	state, err := randomToken(24)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomToken(24)
+	if err != nil {
+		return nil, err
+	}
+	return &OAuthLoginRequest{
+		Provider: provider,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+	}, nil
+}
+
+// oidcMetadata is the part of an issuer's discovery document we use
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jwksCache struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// OAuthService manages the configured login providers and runs the OAuth2 /
+// OpenID Connect authorization code flow against them
+type OAuthService struct {
+	db      *database.DB
+	client  *http.Client
+	secrets *SecretsService
+
+	mu        sync.Mutex
+	discovery map[string]*oidcMetadata
+	jwks      map[string]*jwksCache
+}
+
+func NewOAuthService(db *database.DB) *OAuthService {
+	return &OAuthService{
+		db:        db,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		discovery: make(map[string]*oidcMetadata),
+		jwks:      make(map[string]*jwksCache),
+	}
+}
+
+// SetSecrets encrypts client secrets from now on. Secrets saved before
+// encryption was enabled, or with a master key that is no longer the active
+// one, are re-encrypted with the active key.
+func (s *OAuthService) SetSecrets(secrets *SecretsService) error {
+	s.secrets = secrets
+
+	var providers []models.OAuthProvider
+	if err := s.db.Where("client_secret IS NOT NULL AND client_secret <> ''").Find(&providers).Error; err != nil {
+		return err
+	}
+	for _, provider := range providers {
+		if !secrets.NeedsRewrap(provider.ClientSecret) {
+			continue
+		}
+		secret, err := secrets.Rewrap(provider.ClientSecret, oauthSecretContext(provider.Name))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt client secret of provider %s: %w", provider.Name, err)
+		}
+		if err := s.db.Model(&models.OAuthProvider{}).Where("name = ?", provider.Name).Update("client_secret", secret).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListProviders returns every configured provider
+func (s *OAuthService) ListProviders() ([]models.OAuthProvider, error) {
+	var providers []models.OAuthProvider
+	err := s.db.Order("name").Find(&providers).Error
+	return providers, err
+}
+
+// ListEnabledProviders returns the providers offered on the login page
+func (s *OAuthService) ListEnabledProviders() ([]models.OAuthProvider, error) {
+	var providers []models.OAuthProvider
+	err := s.db.Where("enabled = ?", true).Order("name").Find(&providers).Error
+	return providers, err
+}
+
+// GetProvider returns an enabled provider by name
+func (s *OAuthService) GetProvider(name string) (*models.OAuthProvider, error) {
+	var provider models.OAuthProvider
+	err := s.db.Where("name = ? AND enabled = ?", name, true).First(&provider).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOAuthProviderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+// SaveProvider validates and creates or replaces a provider. An empty client
+// secret keeps the stored one.
+func (s *OAuthService) SaveProvider(provider *models.OAuthProvider) error {
+	if !oauthProviderNamePattern.MatchString(provider.Name) {
+		return fmt.Errorf("invalid provider name %q", provider.Name)
+	}
+	preset, isPreset := oauthPresets[provider.Preset]
+	if provider.Preset != "" && !isPreset {
+		return fmt.Errorf("unknown preset %q", provider.Preset)
+	}
+	if provider.ClientID == "" {
+		return errors.New("client ID is required")
+	}
+	if !isPreset && provider.IssuerURL == "" {
+		return errors.New("issuer URL is required for OpenID Connect providers")
+	}
+	if provider.DisplayName == "" {
+		provider.DisplayName = preset.displayName
+		if provider.DisplayName == "" {
+			provider.DisplayName = provider.Name
+		}
+	}
+	if len(provider.Scopes) == 0 {
+		provider.Scopes = preset.scopes
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+	}
+
+	if provider.ClientSecret == "" {
+		var existing models.OAuthProvider
+		if err := s.db.Where("name = ?", provider.Name).First(&existing).Error; err == nil {
+			provider.ClientSecret = existing.ClientSecret
+			provider.CreatedAt = existing.CreatedAt
+		}
+	} else if s.secrets != nil {
+		secret, err := s.secrets.Encrypt(provider.ClientSecret, oauthSecretContext(provider.Name))
+		if err != nil {
+			return err
+		}
+		provider.ClientSecret = secret
+	}
+	return s.db.Save(provider).Error
+}
+
+// clientSecret returns the decrypted client secret of a provider
+func (s *OAuthService) clientSecret(provider *models.OAuthProvider) (string, error) {
+	if !IsEncryptedSecret(provider.ClientSecret) {
+		return provider.ClientSecret, nil
+	}
+	if s.secrets == nil {
+		return "", fmt.Errorf("client secret of provider %s is encrypted but no master key is loaded", provider.Name)
+	}
+	return s.secrets.Decrypt(provider.ClientSecret, oauthSecretContext(provider.Name))
+}
+
+// oauthSecretContext binds an encrypted client secret to its provider
+func oauthSecretContext(name string) string {
+	return "oauth_provider:" + name
+}
+
+// DeleteProvider removes a provider. Users keep their linked identities and
+// can log in again if it is added back.
+func (s *OAuthService) DeleteProvider(name string) error {
+	result := s.db.Where("name = ?", name).Delete(&models.OAuthProvider{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOAuthProviderNotFound
+	}
+	return nil
+}
+
+// AuthCodeURL returns the provider URL that starts the login
+func (s *OAuthService) AuthCodeURL(ctx context.Context, provider *models.OAuthProvider, redirectURL string, req *OAuthLoginRequest) (string, error) {
+	config, metadata, err := s.oauth2Config(ctx, provider, redirectURL)
+	if err != nil {
+		return "", err
+	}
+
+	opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(req.Verifier)}
+	if metadata != nil {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", req.Nonce))
+	}
+	return config.AuthCodeURL(req.State, opts...), nil
+}
+
+// Exchange redeems the authorization code from the provider's callback and
+// returns the verified identity of the user
+func (s *OAuthService) Exchange(ctx context.Context, provider *models.OAuthProvider, redirectURL, code string, req *OAuthLoginRequest) (*ExternalIdentity, error) {
+	config, metadata, err := s.oauth2Config(ctx, provider, redirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, s.client)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(req.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	var identity *ExternalIdentity
+	if metadata == nil {
+		identity, err = oauthPresets[provider.Preset].userInfo(ctx, s.client, token)
+	} else {
+		identity, err = s.verifyIDToken(ctx, provider, metadata, token, req.Nonce)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identity.Provider = provider.Name
+	if identity.Subject == "" {
+		return nil, errors.New("provider did not return a user ID")
+	}
+	return identity, nil
+}
+
+// oauth2Config builds the client configuration for a provider. The metadata
+// is nil for providers that do not support OpenID Connect.
+func (s *OAuthService) oauth2Config(ctx context.Context, provider *models.OAuthProvider, redirectURL string) (*oauth2.Config, *oidcMetadata, error) {
+	secret, err := s.clientSecret(provider)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &oauth2.Config{
+		ClientID:     provider.ClientID,
+		ClientSecret: secret,
+		RedirectURL:  redirectURL,
+		Scopes:       provider.Scopes,
+	}
+
+	preset := oauthPresets[provider.Preset]
+	if preset.userInfo != nil && provider.IssuerURL == "" {
+		config.Endpoint = oauth2.Endpoint{AuthURL: preset.authURL, TokenURL: preset.tokenURL}
+		return config, nil, nil
+	}
+
+	issuer := provider.IssuerURL
+	if issuer == "" {
+		issuer = preset.issuer
+	}
+	metadata, err := s.discover(ctx, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.Endpoint = oauth2.Endpoint{AuthURL: metadata.AuthorizationEndpoint, TokenURL: metadata.TokenEndpoint}
+	return config, metadata, nil
+}
+
+// discover loads and caches an issuer's OpenID Connect discovery document
+func (s *OAuthService) discover(ctx context.Context, issuer string) (*oidcMetadata, error) {
+	issuer = strings.TrimRight(issuer, "/")
+
+	s.mu.Lock()
+	metadata, ok := s.discovery[issuer]
+	s.mu.Unlock()
+	if ok {
+		return metadata, nil
+	}
+
+	metadata = &oidcMetadata{}
+	if err := s.getJSON(ctx, issuer+"/.well-known/openid-configuration", "", metadata); err != nil {
+		return nil, fmt.Errorf("OpenID Connect discovery failed for %s: %w", issuer, err)
+	}
+	if !issuerMatches(metadata.Issuer, issuer) {
+		return nil, fmt.Errorf("discovery document of %s names issuer %s", issuer, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %s is incomplete", issuer)
+	}
+
+	s.mu.Lock()
+	s.discovery[issuer] = metadata
+	s.mu.Unlock()
+	return metadata, nil
+}
+
+// idTokenClaims are the ID token claims used to identify the user
+type idTokenClaims struct {
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	Name          string       `json:"name"`
+	TenantID      string       `json:"tid"` // Microsoft tenant, part of multi-tenant issuers
+	jwt.RegisteredClaims
+}
+
+// verifyIDToken checks the ID token's signature, issuer, audience, expiry and nonce
+func (s *OAuthService) verifyIDToken(ctx context.Context, provider *models.OAuthProvider, metadata *oidcMetadata, token *oauth2.Token, nonce string) (*ExternalIdentity, error) {
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, fmt.Errorf("%w: provider did not return an ID token", ErrInvalidIDToken)
+	}
+
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return s.signingKey(ctx, metadata.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithAudience(provider.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(idTokenLeeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	expectedIssuer := strings.ReplaceAll(metadata.Issuer, "{tenantid}", claims.TenantID)
+	if claims.Issuer != expectedIssuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %s", ErrInvalidIDToken, claims.Issuer)
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	identity := &ExternalIdentity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}
+
+	// Some providers only put the email in the user info response
+	if identity.Email == "" && metadata.UserinfoEndpoint != "" {
+		var info struct {
+			Subject       string       `json:"sub"`
+			Email         string       `json:"email"`
+			EmailVerified flexibleBool `json:"email_verified"`
+			Name          string       `json:"name"`
+		}
+		if err := s.getJSON(ctx, metadata.UserinfoEndpoint, token.AccessToken, &info); err == nil && info.Subject == claims.Subject {
+			identity.Email = info.Email
+			identity.EmailVerified = bool(info.EmailVerified)
+			if identity.Name == "" {
+				identity.Name = info.Name
+			}
+		}
+	}
+	return identity, nil
+}
+
+// signingKey returns an issuer's public key by key ID, refetching the key set
+// when the key is unknown
+func (s *OAuthService) signingKey(ctx context.Context, jwksURI, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	cache := s.jwks[jwksURI]
+	s.mu.Unlock()
+
+	if cache != nil {
+		if key, ok := cache.lookup(kid); ok {
+			return key, nil
+		}
+		if time.Since(cache.fetchedAt) < jwksMinRefresh {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := s.getJSON(ctx, jwksURI, "", &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	cache = &jwksCache{keys: make(map[string]crypto.PublicKey), fetchedAt: time.Now()}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			cache.keys[k.Kid] = key
+		}
+	}
+
+	s.mu.Lock()
+	s.jwks[jwksURI] = cache
+	s.mu.Unlock()
+
+	if key, ok := cache.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup finds a key by ID. Tokens without a key ID are accepted when the
+// issuer publishes a single key.
+func (c *jwksCache) lookup(kid string) (crypto.PublicKey, bool) {
+	if key, ok := c.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// getJSON fetches a JSON document, with a bearer token if one is given
+func (s *OAuthService) getJSON(ctx context.Context, url, bearer string, v interface{}) error {
+	return getJSON(ctx, s.client, url, bearer, v)
+}
+
+func getJSON(ctx context.Context, client *http.Client, url, bearer string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// issuerMatches compares a discovered issuer to the configured one. Multi-tenant
+// issuers such as Microsoft's common endpoint publish a {tenantid} template.
+func issuerMatches(discovered, configured string) bool {
+	discovered = strings.TrimRight(discovered, "/")
+	if discovered == configured {
+		return true
+	}
+	prefix, _, ok := strings.Cut(discovered, "{tenantid}")
+	return ok && strings.HasPrefix(configured, prefix)
+}
+
+// jsonWebKey is a public key from an issuer's JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// flexibleBool accepts booleans sent as JSON strings, which some providers do
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// githubUserInfo loads the GitHub account and its primary verified email,
+// since GitHub does not issue ID tokens
+func githubUserInfo(ctx context.Context, client *http.Client, token *oauth2.Token) (*ExternalIdentity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", token.AccessToken, &user); err != nil {
+		return nil, fmt.Errorf("failed to load GitHub user: %w", err)
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", token.AccessToken, &emails); err != nil {
+		return nil, fmt.Errorf("failed to load GitHub emails: %w", err)
+	}
+
+	identity := &ExternalIdentity{Subject: fmt.Sprint(user.ID), Name: user.Name}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = e.Verified
+		}
+	}
+	return identity, nil
+}
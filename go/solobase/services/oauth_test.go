@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/constants"
+	"github.com/suppers-ai/solobase/models"
+)
+
+// mockIssuer is a minimal OpenID Connect provider. It issues an ID token for
+// whatever claims the test sets, after checking the PKCE verifier.
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu         sync.Mutex
+	challenges map[string]string // code -> PKCE challenge
+	nonces     map[string]string // code -> nonce
+	claims     jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	m := &mockIssuer{key: key, challenges: map[string]string{}, nonces: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		code := r.PostForm.Get("code")
+		if _, secret, ok := r.BasicAuth(); !ok && r.PostForm.Get("client_secret") != "client-secret" || ok && secret != "client-secret" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+
+		m.mu.Lock()
+		challenge, nonce := m.challenges[code], m.nonces[code]
+		claims := jwt.MapClaims{}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		m.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if challenge == "" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		if _, ok := claims["nonce"]; !ok {
+			claims["nonce"] = nonce
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test-key"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize plays the user approving the login at the provider and returns the code
+func (m *mockIssuer) authorize(t *testing.T, authURL string) string {
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	q := u.Query()
+	require.Equal(t, "S256", q.Get("code_challenge_method"))
+
+	code := "code-" + q.Get("state")
+	m.mu.Lock()
+	m.challenges[code] = q.Get("code_challenge")
+	m.nonces[code] = q.Get("nonce")
+	m.mu.Unlock()
+	return code
+}
+
+func (m *mockIssuer) setClaims(claims jwt.MapClaims) {
+	m.mu.Lock()
+	m.claims = claims
+	m.mu.Unlock()
+}
+
+func newOAuthTestServices(t *testing.T, issuer *mockIssuer) (*AuthService, *OAuthService, *models.OAuthProvider) {
+	db := newTestDB(t)
+	oauthService := NewOAuthService(db)
+	require.NoError(t, oauthService.SaveProvider(&models.OAuthProvider{
+		Name:         "mock",
+		IssuerURL:    issuer.URL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		Enabled:      true,
+	}))
+	provider, err := oauthService.GetProvider("mock")
+	require.NoError(t, err)
+	return NewAuthService(db), oauthService, provider
+}
+
+func (m *mockIssuer) login(t *testing.T, oauthService *OAuthService, provider *models.OAuthProvider) (*ExternalIdentity, error) {
+	ctx := context.Background()
+	req, err := NewOAuthLoginRequest(provider.Name)
+	require.NoError(t, err)
+
+	authURL, err := oauthService.AuthCodeURL(ctx, provider, "http://app.test/callback", req)
+	require.NoError(t, err)
+	code := m.authorize(t, authURL)
+
+	return oauthService.Exchange(ctx, provider, "http://app.test/callback", code, req)
+}
+
+func idClaims(issuer, subject, email string, verified bool) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            issuer,
+		"sub":            subject,
+		"aud":            "client-id",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"email":          email,
+		"email_verified": verified,
+		"name":           "Test User",
+	}
+}
+
+func TestOAuthLoginWithMockIssuer(t *testing.T) {
+	issuer := newMockIssuer(t)
+	authService, oauthService, provider := newOAuthTestServices(t, issuer)
+
+	issuer.setClaims(idClaims(issuer.URL, "subject-1", "new@example.com", true))
+	identity, err := issuer.login(t, oauthService, provider)
+	require.NoError(t, err)
+	assert.Equal(t, "mock", identity.Provider)
+	assert.Equal(t, "subject-1", identity.Subject)
+	assert.Equal(t, "new@example.com", identity.Email)
+	assert.True(t, identity.EmailVerified)
+
+	// The first login creates a confirmed account
+	user, err := authService.LoginWithIdentity(identity, true)
+	require.NoError(t, err)
+	assert.Equal(t, "new@example.com", user.Email)
+	assert.True(t, user.Confirmed)
+
+	// Later logins find the linked account, even if the email changed
+	identity.Email = "renamed@example.com"
+	again, err := authService.LoginWithIdentity(identity, false)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, again.ID)
+
+	code, err := authService.CreateOAuthLoginCode(user.ID)
+	require.NoError(t, err)
+	loggedIn, err := authService.ConsumeOAuthLoginCode(code)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, loggedIn.ID)
+	_, err = authService.ConsumeOAuthLoginCode(code)
+	assert.ErrorIs(t, err, ErrInvalidOAuthLoginCode)
+}
+
+func TestOAuthLinksExistingAccountByVerifiedEmail(t *testing.T) {
+	issuer := newMockIssuer(t)
+	authService, _, _ := newOAuthTestServices(t, issuer)
+
+	existing := &auth.User{Email: "existing@example.com", Password: "password123"}
+	require.NoError(t, authService.CreateUser(existing))
+
+	_, err := authService.LoginWithIdentity(&ExternalIdentity{
+		Provider: "mock", Subject: "unverified", Email: "existing@example.com", EmailVerified: false,
+	}, true)
+	assert.ErrorIs(t, err, ErrOAuthEmailUnverified)
+
+	user, err := authService.LoginWithIdentity(&ExternalIdentity{
+		Provider: "mock", Subject: "verified", Email: "existing@example.com", EmailVerified: true,
+	}, true)
+	require.NoError(t, err)
+	assert.Equal(t, existing.ID, user.ID)
+
+	identities, err := authService.ListIdentities(existing.ID)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "verified", identities[0].Subject)
+
+	_, err = authService.LoginWithIdentity(&ExternalIdentity{
+		Provider: "mock", Subject: "someone-else", Email: "new@example.com", EmailVerified: true,
+	}, false)
+	assert.ErrorIs(t, err, ErrSignupDisabled)
+}
+
+func TestOAuthRejectsDeletedAccounts(t *testing.T) {
+	issuer := newMockIssuer(t)
+	authService, _, _ := newOAuthTestServices(t, issuer)
+
+	linked := &auth.User{Email: "linked@example.com", Password: "password123"}
+	require.NoError(t, authService.CreateUser(linked))
+	identity := &ExternalIdentity{Provider: "mock", Subject: "linked", Email: "linked@example.com", EmailVerified: true}
+	_, err := authService.LoginWithIdentity(identity, true)
+	require.NoError(t, err)
+	unlinked := &auth.User{Email: "unlinked@example.com", Password: "password123"}
+	require.NoError(t, authService.CreateUser(unlinked))
+
+	for _, user := range []*auth.User{linked, unlinked} {
+		require.NoError(t, authService.db.Model(user).Update("role", constants.RoleDeleted.String()).Error)
+	}
+
+	_, err = authService.LoginWithIdentity(identity, true)
+	assert.ErrorIs(t, err, ErrOAuthAccountDeleted)
+	_, err = authService.LoginWithIdentity(&ExternalIdentity{
+		Provider: "mock", Subject: "unlinked", Email: "unlinked@example.com", EmailVerified: true,
+	}, true)
+	assert.ErrorIs(t, err, ErrOAuthAccountDeleted)
+	identities, err := authService.ListIdentities(unlinked.ID)
+	require.NoError(t, err)
+	assert.Empty(t, identities)
+}
+
+func TestOAuthRejectsInvalidIDTokens(t *testing.T) {
+	issuer := newMockIssuer(t)
+	_, oauthService, provider := newOAuthTestServices(t, issuer)
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+	}{
+		{"wrong nonce", func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := idClaims(issuer.URL, "subject", "user@example.com", true)
+			tt.modify(claims)
+			issuer.setClaims(claims)
+
+			_, err := issuer.login(t, oauthService, provider)
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+}
+
+func TestOAuthRequiresPKCEVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	_, oauthService, provider := newOAuthTestServices(t, issuer)
+	issuer.setClaims(idClaims(issuer.URL, "subject", "user@example.com", true))
+
+	ctx := context.Background()
+	req, err := NewOAuthLoginRequest(provider.Name)
+	require.NoError(t, err)
+	authURL, err := oauthService.AuthCodeURL(ctx, provider, "http://app.test/callback", req)
+	require.NoError(t, err)
+	code := issuer.authorize(t, authURL)
+
+	req.Verifier = "a-different-verifier-that-does-not-match-the-challenge"
+	_, err = oauthService.Exchange(ctx, provider, "http://app.test/callback", code, req)
+	assert.Error(t, err)
+}
+
+func TestOAuthClientSecretEncrypted(t *testing.T) {
+	issuer := newMockIssuer(t)
+	_, oauthService, provider := newOAuthTestServices(t, issuer)
+	issuer.setClaims(idClaims(issuer.URL, "subject-1", "alice@example.com", true))
+
+	// Secrets saved before a master key was loaded are encrypted once it is
+	secrets := newTestSecrets(t, "k1")
+	require.NoError(t, oauthService.SetSecrets(secrets))
+	provider, err := oauthService.GetProvider(provider.Name)
+	require.NoError(t, err)
+	assert.True(t, IsEncryptedSecret(provider.ClientSecret))
+	_, err = issuer.login(t, oauthService, provider)
+	require.NoError(t, err)
+
+	provider.ClientSecret = "client-secret"
+	require.NoError(t, oauthService.SaveProvider(provider))
+	provider, err = oauthService.GetProvider(provider.Name)
+	require.NoError(t, err)
+	assert.NotContains(t, provider.ClientSecret, "client-secret")
+	_, err = issuer.login(t, oauthService, provider)
+	require.NoError(t, err)
+
+	// The secret cannot be used without its master key
+	oauthService.secrets = newTestSecrets(t, "other")
+	_, err = oauthService.AuthCodeURL(context.Background(), provider, "http://app.test/callback", &OAuthLoginRequest{})
+	assert.ErrorIs(t, err, ErrUnknownMasterKey)
+}
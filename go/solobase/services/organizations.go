@@ -0,0 +1,495 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/database"
+	"github.com/suppers-ai/solobase/models"
+	"gorm.io/gorm"
+)
+
+// Roles a user can have in an organization, from most to least privileged.
+// Owners manage the organization itself, admins manage its members and
+// resources, members work with its resources and viewers can only read them.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+	OrgRoleViewer = "viewer"
+)
+
+// InviteTTL is how long an organization invitation can be accepted
+const InviteTTL = 7 * 24 * time.Hour
+
+var orgRoleRanks = map[string]int{
+	OrgRoleViewer: 1,
+	OrgRoleMember: 2,
+	OrgRoleAdmin:  3,
+	OrgRoleOwner:  4,
+}
+
+var (
+	// ErrOrganizationNotFound is returned for organizations that do not exist
+	ErrOrganizationNotFound = errors.New("organization not found")
+	// ErrInvalidOrganization is returned for organizations without a name
+	ErrInvalidOrganization = errors.New("organization name is required")
+	// ErrOrganizationSlugTaken is returned when another organization uses the slug
+	ErrOrganizationSlugTaken = errors.New("organization slug is already taken")
+	// ErrInvalidOrganizationSlug is returned for slugs that are not lower case identifiers
+	ErrInvalidOrganizationSlug = errors.New("organization slugs may only contain lower case letters, digits and -")
+	// ErrOrganizationNotEmpty is returned when deleting an organization that still owns resources
+	ErrOrganizationNotEmpty = errors.New("organization still owns buckets, collections or product groups")
+	// ErrInvalidOrganizationRole is returned for roles other than owner, admin, member and viewer
+	ErrInvalidOrganizationRole = errors.New("organization role must be owner, admin, member or viewer")
+	// ErrNotOrganizationMember is returned when a user is not a member of the organization
+	ErrNotOrganizationMember = errors.New("not a member of this organization")
+	// ErrAlreadyOrganizationMember is returned when inviting a user who is already a member
+	ErrAlreadyOrganizationMember = errors.New("user is already a member of this organization")
+	// ErrLastOrganizationOwner is returned for changes that would leave an organization without an owner
+	ErrLastOrganizationOwner = errors.New("an organization must keep at least one owner")
+	// ErrInvalidInviteEmail is returned when inviting something that is not an email address
+	ErrInvalidInviteEmail = errors.New("a valid email address is required")
+	// ErrInvalidInvite is returned for unknown, expired or already accepted invitations
+	ErrInvalidInvite = errors.New("invitation is invalid or has expired")
+	// ErrInviteEmailMismatch is returned when accepting an invitation sent to another address
+	ErrInviteEmailMismatch = errors.New("invitation was sent to a different email address")
+)
+
+var orgSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// organizationOwnedTables are the tables of resources that can belong to an
+// organization, all with an organization_id column
+var organizationOwnedTables = []string{"storage_buckets", "collections", "ext_products_groups"}
+
+// UserOrganization is an organization together with the user's role in it
+type UserOrganization struct {
+	models.Organization
+	Role string `json:"role"`
+}
+
+// OrganizationService manages organizations, their members and invitations
+type OrganizationService struct {
+	db *database.DB
+}
+
+func NewOrganizationService(db *database.DB) *OrganizationService {
+	return &OrganizationService{db: db}
+}
+
+// ValidOrgRole reports whether role is one of the organization roles
+func ValidOrgRole(role string) bool {
+	return orgRoleRanks[role] > 0
+}
+
+// OrgRoleAtLeast reports whether role is at least as privileged as min
+func OrgRoleAtLeast(role, min string) bool {
+	return ValidOrgRole(role) && orgRoleRanks[role] >= orgRoleRanks[min]
+}
+
+// slugify turns an organization name into a slug
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimRight(b.String(), "-")
+	if len(slug) > 64 {
+		slug = strings.TrimRight(slug[:64], "-")
+	}
+	return slug
+}
+
+// CreateOrganization creates an organization with ownerID as its first owner.
+// The slug is derived from the name when empty.
+func (s *OrganizationService) CreateOrganization(name, slug, ownerID string) (*models.Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrInvalidOrganization
+	}
+	if slug == "" {
+		slug = slugify(name)
+	}
+	if !orgSlugPattern.MatchString(slug) {
+		return nil, ErrInvalidOrganizationSlug
+	}
+
+	org := &models.Organization{ID: uuid.New().String(), Name: name, Slug: slug, CreatedBy: ownerID}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Organization{}).Where("slug = ?", slug).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrOrganizationSlugTaken
+		}
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.OrganizationMember{
+			ID:             uuid.New().String(),
+			OrganizationID: org.ID,
+			UserID:         ownerID,
+			Role:           OrgRoleOwner,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// GetOrganization looks up an organization by ID or by slug
+func (s *OrganizationService) GetOrganization(idOrSlug string) (*models.Organization, error) {
+	var org models.Organization
+	query := s.db.Where("slug = ?", idOrSlug)
+	if _, err := uuid.Parse(idOrSlug); err == nil {
+		query = s.db.Where("id = ?", idOrSlug)
+	}
+	if err := query.First(&org).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// ListOrganizations returns every organization
+func (s *OrganizationService) ListOrganizations() ([]models.Organization, error) {
+	var orgs []models.Organization
+	if err := s.db.Order("name").Find(&orgs).Error; err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// ListUserOrganizations returns the organizations a user belongs to with their role in each
+func (s *OrganizationService) ListUserOrganizations(userID string) ([]UserOrganization, error) {
+	var orgs []UserOrganization
+	err := s.db.Table("organizations").
+		Select("organizations.*, organization_members.role").
+		Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ?", userID).
+		Order("organizations.name").
+		Scan(&orgs).Error
+	if err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// UpdateOrganization renames an organization
+func (s *OrganizationService) UpdateOrganization(id, name string) (*models.Organization, error) {
+	org, err := s.GetOrganization(id)
+	if err != nil {
+		return nil, err
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		return nil, ErrInvalidOrganization
+	}
+	org.Name = name
+	if err := s.db.Save(org).Error; err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// DeleteOrganization removes an organization with its members and
+// invitations. Organizations that still own resources cannot be deleted.
+func (s *OrganizationService) DeleteOrganization(id string) error {
+	org, err := s.GetOrganization(id)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range organizationOwnedTables {
+		if !s.db.Migrator().HasTable(table) {
+			continue
+		}
+		var count int64
+		if err := s.db.Table(table).Where("organization_id = ?", org.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrOrganizationNotEmpty
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ?", org.ID).Delete(&models.OrganizationInvite{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", org.ID).Delete(&models.OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(org).Error
+	})
+}
+
+// MemberRole returns a user's role in an organization, or ErrNotOrganizationMember
+func (s *OrganizationService) MemberRole(orgID, userID string) (string, error) {
+	return organizationRole(s.db.DB, orgID, userID)
+}
+
+// organizationRole looks up a user's role in an organization
+func organizationRole(db *gorm.DB, orgID, userID string) (string, error) {
+	if orgID == "" || userID == "" {
+		return "", ErrNotOrganizationMember
+	}
+	var member models.OrganizationMember
+	err := db.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrNotOrganizationMember
+	}
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
+
+// UserOrganizationRoles maps the IDs of the organizations a user belongs to onto their role
+func (s *OrganizationService) UserOrganizationRoles(userID string) (map[string]string, error) {
+	var members []models.OrganizationMember
+	if err := s.db.Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	roles := make(map[string]string, len(members))
+	for _, member := range members {
+		roles[member.OrganizationID] = member.Role
+	}
+	return roles, nil
+}
+
+// ListMembers returns the members of an organization with their email addresses
+func (s *OrganizationService) ListMembers(orgID string) ([]models.OrganizationMember, error) {
+	var members []models.OrganizationMember
+	if err := s.db.Where("organization_id = ?", orgID).Order("created_at").Find(&members).Error; err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]string, 0, len(members))
+	for _, member := range members {
+		userIDs = append(userIDs, member.UserID)
+	}
+	var users []auth.User
+	if len(userIDs) > 0 {
+		if err := s.db.Select("id", "email").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+			return nil, err
+		}
+	}
+	emails := make(map[string]string, len(users))
+	for _, user := range users {
+		emails[user.ID.String()] = user.Email
+	}
+	for i := range members {
+		members[i].Email = emails[members[i].UserID]
+	}
+	return members, nil
+}
+
+// UpdateMemberRole changes the role of a member
+func (s *OrganizationService) UpdateMemberRole(orgID, userID, role string) (*models.OrganizationMember, error) {
+	if !ValidOrgRole(role) {
+		return nil, ErrInvalidOrganizationRole
+	}
+
+	var member models.OrganizationMember
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&member).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotOrganizationMember
+			}
+			return err
+		}
+		if member.Role == OrgRoleOwner && role != OrgRoleOwner {
+			if err := ensureAnotherOwner(tx, orgID, userID); err != nil {
+				return err
+			}
+		}
+		member.Role = role
+		return tx.Save(&member).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// RemoveMember takes a user out of an organization
+func (s *OrganizationService) RemoveMember(orgID, userID string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var member models.OrganizationMember
+		if err := tx.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&member).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotOrganizationMember
+			}
+			return err
+		}
+		if member.Role == OrgRoleOwner {
+			if err := ensureAnotherOwner(tx, orgID, userID); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&member).Error
+	})
+}
+
+// ensureAnotherOwner returns ErrLastOrganizationOwner unless the organization
+// has an owner besides userID
+func ensureAnotherOwner(tx *gorm.DB, orgID, userID string) error {
+	var owners int64
+	if err := tx.Model(&models.OrganizationMember{}).
+		Where("organization_id = ? AND role = ? AND user_id <> ?", orgID, OrgRoleOwner, userID).
+		Count(&owners).Error; err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOrganizationOwner
+	}
+	return nil
+}
+
+// CreateInvite invites an email address to join an organization with a role.
+// It returns the invitation and the token to email, replacing any pending
+// invitation for the same address.
+func (s *OrganizationService) CreateInvite(orgID, email, role, invitedBy string) (*models.OrganizationInvite, string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, "", ErrInvalidInviteEmail
+	}
+	if !ValidOrgRole(role) {
+		return nil, "", ErrInvalidOrganizationRole
+	}
+
+	var members int64
+	if err := s.db.Model(&models.OrganizationMember{}).
+		Joins("JOIN users ON users.id = organization_members.user_id").
+		Where("organization_members.organization_id = ? AND LOWER(users.email) = ?", orgID, email).
+		Count(&members).Error; err != nil {
+		return nil, "", err
+	}
+	if members > 0 {
+		return nil, "", ErrAlreadyOrganizationMember
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	invite := &models.OrganizationInvite{
+		ID:             uuid.New().String(),
+		OrganizationID: orgID,
+		Email:          email,
+		Role:           role,
+		TokenHash:      hashToken(token),
+		InvitedBy:      invitedBy,
+		ExpiresAt:      time.Now().Add(InviteTTL),
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ? AND email = ? AND accepted_at IS NULL", orgID, email).
+			Delete(&models.OrganizationInvite{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(invite).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return invite, token, nil
+}
+
+// ListInvites returns the pending invitations of an organization
+func (s *OrganizationService) ListInvites(orgID string) ([]models.OrganizationInvite, error) {
+	var invites []models.OrganizationInvite
+	err := s.db.Where("organization_id = ? AND accepted_at IS NULL AND expires_at > ?", orgID, time.Now()).
+		Order("created_at DESC").
+		Find(&invites).Error
+	if err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// RevokeInvite deletes a pending invitation
+func (s *OrganizationService) RevokeInvite(orgID, inviteID string) error {
+	result := s.db.Where("id = ? AND organization_id = ? AND accepted_at IS NULL", inviteID, orgID).
+		Delete(&models.OrganizationInvite{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidInvite
+	}
+	return nil
+}
+
+// AcceptInvite adds the user to the organization they were invited to. The
+// invitation must have been sent to the user's email address. A user who is
+// already a member keeps their current role.
+func (s *OrganizationService) AcceptInvite(token string, user *auth.User) (*models.OrganizationMember, error) {
+	var member models.OrganizationMember
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var invite models.OrganizationInvite
+		err := tx.Where("token_hash = ? AND accepted_at IS NULL", hashToken(token)).First(&invite).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidInvite
+		}
+		if err != nil {
+			return err
+		}
+		if time.Now().After(invite.ExpiresAt) {
+			return ErrInvalidInvite
+		}
+		if !strings.EqualFold(invite.Email, user.Email) {
+			return ErrInviteEmailMismatch
+		}
+
+		// Only one of two concurrent acceptances may use the invitation
+		now := time.Now()
+		result := tx.Model(&models.OrganizationInvite{}).
+			Where("id = ? AND accepted_at IS NULL", invite.ID).
+			Update("accepted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidInvite
+		}
+
+		err = tx.Where("organization_id = ? AND user_id = ?", invite.OrganizationID, user.ID.String()).First(&member).Error
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		member = models.OrganizationMember{
+			ID:             uuid.New().String(),
+			OrganizationID: invite.OrganizationID,
+			UserID:         user.ID.String(),
+			Role:           invite.Role,
+		}
+		return tx.Create(&member).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// RemoveUserMemberships takes a deleted user out of every organization
+func (s *OrganizationService) RemoveUserMemberships(userID string) error {
+	return s.db.Where("user_id = ?", userID).Delete(&models.OrganizationMember{}).Error
+}
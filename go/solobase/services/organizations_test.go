@@ -0,0 +1,171 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/solobase/models"
+)
+
+func newOrgTestUser(t *testing.T, authService *AuthService, email string) *auth.User {
+	user := &auth.User{Email: email, Password: "unused", Role: "user"}
+	require.NoError(t, authService.CreateUser(user))
+	return user
+}
+
+func TestCreateOrganizationMakesCreatorOwner(t *testing.T) {
+	orgs := NewOrganizationService(newTestDB(t))
+	ownerID := uuid.New().String()
+
+	org, err := orgs.CreateOrganization("  Acme Corp ", "", ownerID)
+	require.NoError(t, err)
+	assert.Equal(t, "Acme Corp", org.Name)
+	assert.Equal(t, "acme-corp", org.Slug)
+
+	role, err := orgs.MemberRole(org.ID, ownerID)
+	require.NoError(t, err)
+	assert.Equal(t, OrgRoleOwner, role)
+
+	_, err = orgs.CreateOrganization("Acme", "acme-corp", uuid.New().String())
+	assert.ErrorIs(t, err, ErrOrganizationSlugTaken)
+	_, err = orgs.CreateOrganization("Acme", "Not A Slug", ownerID)
+	assert.ErrorIs(t, err, ErrInvalidOrganizationSlug)
+
+	found, err := orgs.GetOrganization("acme-corp")
+	require.NoError(t, err)
+	assert.Equal(t, org.ID, found.ID)
+
+	mine, err := orgs.ListUserOrganizations(ownerID)
+	require.NoError(t, err)
+	require.Len(t, mine, 1)
+	assert.Equal(t, OrgRoleOwner, mine[0].Role)
+}
+
+func TestOrganizationKeepsAnOwner(t *testing.T) {
+	db := newTestDB(t)
+	authService := NewAuthService(db)
+	orgs := NewOrganizationService(db)
+	owner := newOrgTestUser(t, authService, "owner@example.com")
+	admin := newOrgTestUser(t, authService, "admin@example.com")
+
+	org, err := orgs.CreateOrganization("Acme", "", owner.ID.String())
+	require.NoError(t, err)
+	_, token, err := orgs.CreateInvite(org.ID, admin.Email, OrgRoleAdmin, owner.ID.String())
+	require.NoError(t, err)
+	_, err = orgs.AcceptInvite(token, admin)
+	require.NoError(t, err)
+
+	_, err = orgs.UpdateMemberRole(org.ID, owner.ID.String(), OrgRoleAdmin)
+	assert.ErrorIs(t, err, ErrLastOrganizationOwner)
+	assert.ErrorIs(t, orgs.RemoveMember(org.ID, owner.ID.String()), ErrLastOrganizationOwner)
+
+	// Once another owner exists the first one may step down
+	_, err = orgs.UpdateMemberRole(org.ID, admin.ID.String(), OrgRoleOwner)
+	require.NoError(t, err)
+	require.NoError(t, orgs.RemoveMember(org.ID, owner.ID.String()))
+	_, err = orgs.MemberRole(org.ID, owner.ID.String())
+	assert.ErrorIs(t, err, ErrNotOrganizationMember)
+}
+
+func TestAcceptOrganizationInvite(t *testing.T) {
+	db := newTestDB(t)
+	authService := NewAuthService(db)
+	orgs := NewOrganizationService(db)
+	owner := newOrgTestUser(t, authService, "owner@example.com")
+	invitee := newOrgTestUser(t, authService, "invitee@example.com")
+	other := newOrgTestUser(t, authService, "other@example.com")
+
+	org, err := orgs.CreateOrganization("Acme", "", owner.ID.String())
+	require.NoError(t, err)
+
+	_, _, err = orgs.CreateInvite(org.ID, owner.Email, OrgRoleMember, owner.ID.String())
+	assert.ErrorIs(t, err, ErrAlreadyOrganizationMember)
+	_, _, err = orgs.CreateInvite(org.ID, invitee.Email, "superuser", owner.ID.String())
+	assert.ErrorIs(t, err, ErrInvalidOrganizationRole)
+
+	_, token, err := orgs.CreateInvite(org.ID, "Invitee@Example.com", OrgRoleViewer, owner.ID.String())
+	require.NoError(t, err)
+
+	_, err = orgs.AcceptInvite(token, other)
+	assert.ErrorIs(t, err, ErrInviteEmailMismatch)
+
+	member, err := orgs.AcceptInvite(token, invitee)
+	require.NoError(t, err)
+	assert.Equal(t, OrgRoleViewer, member.Role)
+
+	// Invitations work once
+	_, err = orgs.AcceptInvite(token, invitee)
+	assert.ErrorIs(t, err, ErrInvalidInvite)
+
+	invites, err := orgs.ListInvites(org.ID)
+	require.NoError(t, err)
+	assert.Empty(t, invites)
+}
+
+func TestExpiredOrganizationInvite(t *testing.T) {
+	db := newTestDB(t)
+	authService := NewAuthService(db)
+	orgs := NewOrganizationService(db)
+	owner := newOrgTestUser(t, authService, "owner@example.com")
+	invitee := newOrgTestUser(t, authService, "invitee@example.com")
+
+	org, err := orgs.CreateOrganization("Acme", "", owner.ID.String())
+	require.NoError(t, err)
+	invite, token, err := orgs.CreateInvite(org.ID, invitee.Email, OrgRoleMember, owner.ID.String())
+	require.NoError(t, err)
+	require.NoError(t, db.Model(invite).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+	_, err = orgs.AcceptInvite(token, invitee)
+	assert.ErrorIs(t, err, ErrInvalidInvite)
+	_, err = orgs.MemberRole(org.ID, invitee.ID.String())
+	assert.ErrorIs(t, err, ErrNotOrganizationMember)
+}
+
+func TestDeleteOrganizationWithResources(t *testing.T) {
+	db := newTestDB(t)
+	orgs := NewOrganizationService(db)
+	ownerID := uuid.New().String()
+
+	org, err := orgs.CreateOrganization("Acme", "", ownerID)
+	require.NoError(t, err)
+	collection := &models.Collection{Name: "notes", Schema: models.JSON{}, OrganizationID: &org.ID}
+	require.NoError(t, db.Create(collection).Error)
+
+	assert.ErrorIs(t, orgs.DeleteOrganization(org.ID), ErrOrganizationNotEmpty)
+
+	require.NoError(t, db.Delete(collection).Error)
+	require.NoError(t, orgs.DeleteOrganization(org.ID))
+	_, err = orgs.GetOrganization(org.ID)
+	assert.ErrorIs(t, err, ErrOrganizationNotFound)
+	_, err = orgs.MemberRole(org.ID, ownerID)
+	assert.ErrorIs(t, err, ErrNotOrganizationMember)
+}
+
+func TestCollectionOrganizationAccess(t *testing.T) {
+	db := newTestDB(t)
+	orgs := NewOrganizationService(db)
+	collections := NewCollectionService(db)
+	ownerID := uuid.New().String()
+
+	org, err := orgs.CreateOrganization("Acme", "", ownerID)
+	require.NoError(t, err)
+	viewerID := uuid.New().String()
+	require.NoError(t, db.Create(&models.OrganizationMember{
+		ID: uuid.New().String(), OrganizationID: org.ID, UserID: viewerID, Role: OrgRoleViewer,
+	}).Error)
+	collection := &models.Collection{OrganizationID: &org.ID}
+
+	owner := &RecordAuth{UserID: ownerID, Role: "user"}
+	viewer := &RecordAuth{UserID: viewerID, Role: "user"}
+	outsider := &RecordAuth{UserID: uuid.New().String(), Role: "user"}
+
+	assert.NoError(t, collections.checkOrganization(collection, models.CollectionRuleCreate, owner))
+	assert.NoError(t, collections.checkOrganization(collection, models.CollectionRuleView, viewer))
+	assert.ErrorIs(t, collections.checkOrganization(collection, models.CollectionRuleCreate, viewer), ErrRecordForbidden)
+	assert.ErrorIs(t, collections.checkOrganization(collection, models.CollectionRuleList, outsider), ErrRecordForbidden)
+	assert.NoError(t, collections.checkOrganization(collection, models.CollectionRuleDelete, &RecordAuth{UserID: outsider.UserID, Role: "admin"}))
+}
@@ -0,0 +1,468 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/suppers-ai/solobase/constants"
+	"github.com/suppers-ai/solobase/database"
+	"github.com/suppers-ai/solobase/models"
+	"gorm.io/gorm"
+)
+
+// Core permissions checked by the API. Extensions add their own when enabled.
+const (
+	PermissionAll                 = "*"
+	PermissionUsersRead           = "users.read"
+	PermissionUsersManage         = "users.manage"
+	PermissionUsersImpersonate    = "users.impersonate"
+	PermissionRolesManage         = "roles.manage"
+	PermissionSettingsManage      = "settings.manage"
+	PermissionStorageBucketCreate = "storage.bucket.create"
+	PermissionStorageBucketDelete = "storage.bucket.delete"
+	PermissionStorageAdmin        = "storage.admin"
+	PermissionOrganizationsManage = "organizations.manage"
+	PermissionCollectionsManage   = "collections.manage"
+)
+
+// corePermissions is the catalog of permissions defined by solobase itself
+var corePermissions = []models.Permission{
+	{Name: PermissionUsersRead, Description: "List and view user accounts"},
+	{Name: PermissionUsersManage, Description: "Update, delete and unlock user accounts"},
+	{Name: PermissionUsersImpersonate, Description: "Act as another user to help them, with every request logged"},
+	{Name: PermissionRolesManage, Description: "Manage roles and assign them to users"},
+	{Name: PermissionSettingsManage, Description: "Change app settings and login providers"},
+	{Name: PermissionStorageBucketCreate, Description: "Create storage buckets"},
+	{Name: PermissionStorageBucketDelete, Description: "Delete storage buckets and everything in them"},
+	{Name: PermissionStorageAdmin, Description: "View storage usage of all users"},
+	{Name: PermissionOrganizationsManage, Description: "Manage every organization as if its owner"},
+	{Name: PermissionCollectionsManage, Description: "Create, change and delete collections"},
+}
+
+// builtinRoles are created on first run. Their permissions can be changed
+// afterwards, except for admin which always has every permission.
+var builtinRoles = []struct {
+	role        models.Role
+	permissions []string
+}{
+	{models.Role{Name: constants.RoleAdmin.String(), DisplayName: "Administrator", Description: "Full access to everything"}, []string{PermissionAll}},
+	{models.Role{Name: constants.RoleManager.String(), DisplayName: "Manager", Description: "Oversees users and storage"}, []string{PermissionUsersRead, PermissionStorageBucketCreate, PermissionStorageAdmin}},
+	{models.Role{Name: constants.RoleUser.String(), DisplayName: "User", Description: "Regular account"}, []string{}},
+	{models.Role{Name: constants.RoleDeleted.String(), DisplayName: "Deleted", Description: "Banned account, denied every permission"}, []string{}},
+}
+
+var (
+	// ErrRoleNotFound is returned for roles that do not exist
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrRoleExists is returned when creating a role whose name is taken
+	ErrRoleExists = errors.New("role already exists")
+	// ErrBuiltinRole is returned for changes built-in roles do not allow
+	ErrBuiltinRole = errors.New("built-in roles cannot be deleted and the admin role cannot be changed")
+	// ErrInvalidRoleName is returned for role names that are not lower case identifiers
+	ErrInvalidRoleName = errors.New("role names may only contain lower case letters, digits, - and _")
+	// ErrUnknownPermission is returned when granting a permission that is not in the catalog
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// RBACService stores roles, the permissions granted to them and the extra
+// roles bound to users. The permissions of each role are cached in memory
+// and reloaded after any change.
+type RBACService struct {
+	db *database.DB
+
+	mu     sync.RWMutex
+	grants map[string][]string // role -> permissions, nil until loaded
+}
+
+func NewRBACService(db *database.DB) *RBACService {
+	service := &RBACService{db: db}
+	// Create the built-in roles and core permissions on first run
+	if err := service.initializeDefaults(); err != nil {
+		log.Printf("Warning: failed to initialize roles: %v", err)
+	}
+	return service
+}
+
+// initializeDefaults creates the core permissions and any missing built-in roles
+func (s *RBACService) initializeDefaults() error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, permission := range corePermissions {
+			permission := permission
+			if err := tx.Save(&permission).Error; err != nil {
+				return err
+			}
+		}
+		for _, builtin := range builtinRoles {
+			var count int64
+			if err := tx.Model(&models.Role{}).Where("name = ?", builtin.role.Name).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			role := builtin.role
+			role.Builtin = true
+			if err := tx.Create(&role).Error; err != nil {
+				return err
+			}
+			if err := setRolePermissions(tx, role.Name, builtin.permissions); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MatchPermission reports whether a granted permission covers the required
+// one. A grant ending in * covers every permission starting with its prefix.
+func MatchPermission(granted, required string) bool {
+	if strings.HasSuffix(granted, "*") {
+		return strings.HasPrefix(required, strings.TrimSuffix(granted, "*"))
+	}
+	return granted == required
+}
+
+// ListPermissions returns the permission catalog, core permissions first
+func (s *RBACService) ListPermissions() ([]models.Permission, error) {
+	var permissions []models.Permission
+	if err := s.db.Order("extension, name").Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// RegisterPermissions adds the permissions an extension requires to the
+// catalog, replacing those it registered before
+func (s *RBACService) RegisterPermissions(extension string, permissions []models.Permission) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("extension = ?", extension).Delete(&models.Permission{}).Error; err != nil {
+			return err
+		}
+		for _, permission := range permissions {
+			permission.Extension = extension
+			if err := tx.Save(&permission).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListRoles returns all roles with their permissions
+func (s *RBACService) ListRoles() ([]models.Role, error) {
+	var roles []models.Role
+	if err := s.db.Order("builtin DESC, name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	grants, err := s.loadGrants()
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		roles[i].Permissions = append([]string{}, grants[roles[i].Name]...)
+	}
+	return roles, nil
+}
+
+// GetRole returns a role with its permissions
+func (s *RBACService) GetRole(name string) (*models.Role, error) {
+	var role models.Role
+	if err := s.db.Where("name = ?", name).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, err
+	}
+	grants, err := s.loadGrants()
+	if err != nil {
+		return nil, err
+	}
+	role.Permissions = append([]string{}, grants[role.Name]...)
+	return &role, nil
+}
+
+// RoleExists reports whether a role with the name exists
+func (s *RBACService) RoleExists(name string) bool {
+	var count int64
+	s.db.Model(&models.Role{}).Where("name = ?", name).Count(&count)
+	return count > 0
+}
+
+// CreateRole adds a custom role
+func (s *RBACService) CreateRole(role *models.Role) error {
+	if !roleNamePattern.MatchString(role.Name) {
+		return ErrInvalidRoleName
+	}
+	if s.RoleExists(role.Name) {
+		return ErrRoleExists
+	}
+	if err := s.validatePermissions(role.Permissions); err != nil {
+		return err
+	}
+
+	role.Builtin = false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(role).Error; err != nil {
+			return err
+		}
+		return setRolePermissions(tx, role.Name, role.Permissions)
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidate()
+	return nil
+}
+
+// UpdateRole changes the description and permissions of a role
+func (s *RBACService) UpdateRole(name string, update *models.Role) (*models.Role, error) {
+	role, err := s.GetRole(name)
+	if err != nil {
+		return nil, err
+	}
+	if role.Name == constants.RoleAdmin.String() {
+		return nil, ErrBuiltinRole
+	}
+	if err := s.validatePermissions(update.Permissions); err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Role{}).Where("name = ?", name).Updates(map[string]interface{}{
+			"display_name": update.DisplayName,
+			"description":  update.Description,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return setRolePermissions(tx, name, update.Permissions)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate()
+	return s.GetRole(name)
+}
+
+// DeleteRole removes a custom role along with its grants and bindings. Users
+// whose Role column names it fall back to the permissions of no role.
+func (s *RBACService) DeleteRole(name string) error {
+	role, err := s.GetRole(name)
+	if err != nil {
+		return err
+	}
+	if role.Builtin {
+		return ErrBuiltinRole
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role = ?", name).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role = ?", name).Delete(&models.RoleBinding{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("name = ?", name).Delete(&models.Role{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidate()
+	return nil
+}
+
+// UserRoles returns the roles bound to a user, not including the one in their Role column
+func (s *RBACService) UserRoles(userID string) ([]string, error) {
+	var roles []string
+	err := s.db.Model(&models.RoleBinding{}).Where("user_id = ?", userID).Order("role").Pluck("role", &roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// BindRole gives a user an extra role. Binding a role twice is not an error.
+func (s *RBACService) BindRole(userID, role string) error {
+	if !s.RoleExists(role) {
+		return ErrRoleNotFound
+	}
+	var count int64
+	if err := s.db.Model(&models.RoleBinding{}).Where("user_id = ? AND role = ?", userID, role).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return s.db.Create(&models.RoleBinding{ID: uuid.New().String(), UserID: userID, Role: role}).Error
+}
+
+// UnbindRole takes an extra role away from a user
+func (s *RBACService) UnbindRole(userID, role string) error {
+	return s.db.Where("user_id = ? AND role = ?", userID, role).Delete(&models.RoleBinding{}).Error
+}
+
+// RemoveUserBindings removes all roles bound to a deleted user
+func (s *RBACService) RemoveUserBindings(userID string) error {
+	return s.db.Where("user_id = ?", userID).Delete(&models.RoleBinding{}).Error
+}
+
+// PermissionsForUser returns the permissions a user has through the role in
+// their Role column and the roles bound to them. Deleted users have none.
+func (s *RBACService) PermissionsForUser(userID, role string) ([]string, error) {
+	if role == constants.RoleDeleted.String() {
+		return []string{}, nil
+	}
+
+	roles := []string{role}
+	if userID != "" {
+		bound, err := s.UserRoles(userID)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, bound...)
+	}
+
+	grants, err := s.loadGrants()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	permissions := []string{}
+	for _, r := range roles {
+		for _, permission := range grants[r] {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+// UserRole returns the role in a user's Role column, for callers that only know the user ID
+func (s *RBACService) UserRole(userID string) (string, error) {
+	var roles []string
+	if err := s.db.Table("users").Where("id = ?", userID).Limit(1).Pluck("role", &roles).Error; err != nil {
+		return "", err
+	}
+	if len(roles) == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+	return roles[0], nil
+}
+
+// NewPermissionChecker returns a checker for one request. The user's
+// permissions are loaded on the first check and reused after that.
+func (s *RBACService) NewPermissionChecker(userID, role string) *PermissionChecker {
+	return &PermissionChecker{service: s, userID: userID, role: role}
+}
+
+// PermissionChecker answers permission checks for one user during one request
+type PermissionChecker struct {
+	service *RBACService
+	userID  string
+	role    string
+
+	once        sync.Once
+	permissions []string
+	err         error
+}
+
+// Has reports whether the user has a permission. Failing to load the
+// user's permissions denies everything.
+func (c *PermissionChecker) Has(permission string) bool {
+	c.once.Do(func() {
+		c.permissions, c.err = c.service.PermissionsForUser(c.userID, c.role)
+	})
+	if c.err != nil {
+		return false
+	}
+	for _, granted := range c.permissions {
+		if MatchPermission(granted, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// UserID returns the user the checker answers for
+func (c *PermissionChecker) UserID() string {
+	return c.userID
+}
+
+// validatePermissions checks that every permission is in the catalog or a
+// wildcard, which may cover permissions of extensions not enabled yet
+func (s *RBACService) validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if strings.HasSuffix(permission, "*") {
+			continue
+		}
+		var count int64
+		if err := s.db.Model(&models.Permission{}).Where("name = ?", permission).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("%w: %s", ErrUnknownPermission, permission)
+		}
+	}
+	return nil
+}
+
+// loadGrants returns the permissions of every role, reading them from the
+// database when the cache is empty
+func (s *RBACService) loadGrants() (map[string][]string, error) {
+	s.mu.RLock()
+	grants := s.grants
+	s.mu.RUnlock()
+	if grants != nil {
+		return grants, nil
+	}
+
+	var rows []models.RolePermission
+	if err := s.db.Order("role, permission").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	grants = make(map[string][]string)
+	for _, row := range rows {
+		grants[row.Role] = append(grants[row.Role], row.Permission)
+	}
+
+	s.mu.Lock()
+	s.grants = grants
+	s.mu.Unlock()
+	return grants, nil
+}
+
+// invalidate drops the cached grants after a change
+func (s *RBACService) invalidate() {
+	s.mu.Lock()
+	s.grants = nil
+	s.mu.Unlock()
+}
+
+// setRolePermissions replaces the permissions granted to a role
+func setRolePermissions(tx *gorm.DB, role string, permissions []string) error {
+	if err := tx.Where("role = ?", role).Delete(&models.RolePermission{}).Error; err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, permission := range permissions {
+		if seen[permission] {
+			continue
+		}
+		seen[permission] = true
+		if err := tx.Create(&models.RolePermission{Role: role, Permission: permission}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
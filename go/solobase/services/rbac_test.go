@@ -0,0 +1,97 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suppers-ai/solobase/models"
+)
+
+func TestMatchPermission(t *testing.T) {
+	assert.True(t, MatchPermission("*", "storage.bucket.delete"))
+	assert.True(t, MatchPermission("storage.*", "storage.bucket.delete"))
+	assert.True(t, MatchPermission("storage.bucket.delete", "storage.bucket.delete"))
+	assert.False(t, MatchPermission("storage.bucket.create", "storage.bucket.delete"))
+	assert.False(t, MatchPermission("storage.*", "storagebox.read"))
+}
+
+func TestBuiltinRoles(t *testing.T) {
+	rbac := NewRBACService(newTestDB(t))
+	userID := uuid.New().String()
+
+	assert.True(t, rbac.NewPermissionChecker(userID, "admin").Has(PermissionStorageBucketDelete))
+	assert.True(t, rbac.NewPermissionChecker(userID, "manager").Has(PermissionUsersRead))
+	assert.False(t, rbac.NewPermissionChecker(userID, "manager").Has(PermissionUsersManage))
+	assert.False(t, rbac.NewPermissionChecker(userID, "user").Has(PermissionUsersRead))
+	assert.True(t, rbac.NewPermissionChecker(userID, "admin").Has(PermissionUsersImpersonate))
+	assert.False(t, rbac.NewPermissionChecker(userID, "manager").Has(PermissionUsersImpersonate))
+	assert.True(t, rbac.NewPermissionChecker(userID, "admin").Has(PermissionCollectionsManage))
+	assert.False(t, rbac.NewPermissionChecker(userID, "user").Has(PermissionCollectionsManage))
+
+	// Deleted accounts get nothing, whatever else they are bound to
+	require.NoError(t, rbac.BindRole(userID, "admin"))
+	assert.False(t, rbac.NewPermissionChecker(userID, "deleted").Has(PermissionUsersRead))
+
+	_, err := rbac.UpdateRole("admin", &models.Role{Permissions: []string{}})
+	assert.ErrorIs(t, err, ErrBuiltinRole)
+	assert.ErrorIs(t, rbac.DeleteRole("user"), ErrBuiltinRole)
+}
+
+func TestCustomRoleBindings(t *testing.T) {
+	rbac := NewRBACService(newTestDB(t))
+	userID := uuid.New().String()
+
+	err := rbac.CreateRole(&models.Role{Name: "storage-admin", Permissions: []string{"storage.bucket.destroy"}})
+	assert.ErrorIs(t, err, ErrUnknownPermission)
+	assert.ErrorIs(t, rbac.CreateRole(&models.Role{Name: "Storage Admin"}), ErrInvalidRoleName)
+
+	require.NoError(t, rbac.CreateRole(&models.Role{Name: "storage-admin", Permissions: []string{"storage.*"}}))
+	assert.ErrorIs(t, rbac.CreateRole(&models.Role{Name: "storage-admin"}), ErrRoleExists)
+	assert.False(t, rbac.NewPermissionChecker(userID, "user").Has(PermissionStorageBucketDelete))
+
+	require.NoError(t, rbac.BindRole(userID, "storage-admin"))
+	require.NoError(t, rbac.BindRole(userID, "storage-admin"))
+	roles, err := rbac.UserRoles(userID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"storage-admin"}, roles)
+
+	checker := rbac.NewPermissionChecker(userID, "user")
+	assert.True(t, checker.Has(PermissionStorageBucketDelete))
+	assert.False(t, checker.Has(PermissionUsersRead))
+
+	// Changes apply to new checkers straight away
+	_, err = rbac.UpdateRole("storage-admin", &models.Role{Permissions: []string{PermissionStorageBucketCreate}})
+	require.NoError(t, err)
+	assert.False(t, rbac.NewPermissionChecker(userID, "user").Has(PermissionStorageBucketDelete))
+	assert.True(t, rbac.NewPermissionChecker(userID, "user").Has(PermissionStorageBucketCreate))
+
+	require.NoError(t, rbac.DeleteRole("storage-admin"))
+	roles, err = rbac.UserRoles(userID)
+	require.NoError(t, err)
+	assert.Empty(t, roles)
+	assert.ErrorIs(t, rbac.BindRole(userID, "storage-admin"), ErrRoleNotFound)
+}
+
+func TestRegisterExtensionPermissions(t *testing.T) {
+	rbac := NewRBACService(newTestDB(t))
+
+	require.NoError(t, rbac.RegisterPermissions("products", []models.Permission{
+		{Name: "products:read"}, {Name: "products:write"},
+	}))
+	require.NoError(t, rbac.CreateRole(&models.Role{Name: "editor", Permissions: []string{"products:write"}}))
+
+	// Registering again replaces what the extension registered before
+	require.NoError(t, rbac.RegisterPermissions("products", []models.Permission{{Name: "products:read"}}))
+	permissions, err := rbac.ListPermissions()
+	require.NoError(t, err)
+	var names []string
+	for _, p := range permissions {
+		if p.Extension == "products" {
+			names = append(names, p.Name)
+		}
+	}
+	assert.Equal(t, []string{"products:read"}, names)
+	assert.ErrorIs(t, rbac.CreateRole(&models.Role{Name: "writer", Permissions: []string{"products:write"}}), ErrUnknownPermission)
+}
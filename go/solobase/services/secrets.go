@@ -0,0 +1,295 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// RedactedSecret replaces secret values in API responses. Saving it back
+	// keeps the stored secret.
+	RedactedSecret = "********"
+
+	// secretPrefix marks encrypted values: enc:v1:<key id>:<wrapped data key>:<ciphertext>
+	secretPrefix = "enc:v1:"
+	// masterKeySize is the size of master and data keys, for AES-256-GCM
+	masterKeySize = 32
+	// defaultMasterKeyID names a master key given without an ID
+	defaultMasterKeyID = "default"
+)
+
+var (
+	// ErrUnknownMasterKey is returned for secrets encrypted with a master key that is not loaded
+	ErrUnknownMasterKey = errors.New("secret was encrypted with an unknown master key")
+	// ErrInvalidSecret is returned for encrypted values that are malformed or were tampered with
+	ErrInvalidSecret = errors.New("invalid encrypted secret")
+
+	masterKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// SecretsService encrypts secrets at rest with envelope encryption. Each
+// secret gets its own random data key, which is stored next to it wrapped by
+// a master key. Master keys have IDs, so keys can be rotated by loading a new
+// active key alongside the old ones and rewrapping.
+type SecretsService struct {
+	keys   map[string][]byte
+	active string
+}
+
+// NewSecretsService parses a master keyring. Entries are "id:base64key" and
+// separated by commas or newlines; the first entry is the active key used
+// for new secrets and the others can only decrypt. A single base64 key
+// without an ID is named "default". Lines starting with # are ignored.
+func NewSecretsService(keyring string) (*SecretsService, error) {
+	s := &SecretsService{keys: make(map[string][]byte)}
+
+	entries := strings.FieldsFunc(keyring, func(r rune) bool { return r == ',' || r == '\n' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encoded, found := strings.Cut(entry, ":")
+		if !found {
+			id, encoded = defaultMasterKeyID, entry
+		}
+		if !masterKeyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("master key ID %q may only contain letters, digits, - and _", id)
+		}
+		if _, exists := s.keys[id]; exists {
+			return nil, fmt.Errorf("master key ID %q is used twice", id)
+		}
+		key, err := decodeMasterKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", id, err)
+		}
+
+		s.keys[id] = key
+		if s.active == "" {
+			s.active = id
+		}
+	}
+
+	if s.active == "" {
+		return nil, errors.New("no master key found")
+	}
+	return s, nil
+}
+
+// LoadSecretsService loads the master keyring from key when it is set, or
+// else from keyFile. A missing key file is created with a new random key;
+// created reports whether that happened, as the file must then be backed up.
+func LoadSecretsService(key, keyFile string) (service *SecretsService, created bool, err error) {
+	if strings.TrimSpace(key) != "" {
+		service, err = NewSecretsService(key)
+		return service, false, err
+	}
+	if keyFile == "" {
+		return nil, false, errors.New("no master key or master key file configured")
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		keyring, err := GenerateMasterKey(defaultMasterKeyID)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+			return nil, false, fmt.Errorf("failed to create master key directory: %w", err)
+		}
+		if err := os.WriteFile(keyFile, []byte(keyring+"\n"), 0600); err != nil {
+			return nil, false, fmt.Errorf("failed to write master key file: %w", err)
+		}
+		data, created = []byte(keyring), true
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to read master key file: %w", err)
+	}
+
+	service, err = NewSecretsService(string(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("master key file %s: %w", keyFile, err)
+	}
+	return service, created, nil
+}
+
+// GenerateMasterKey returns a keyring entry with a new random master key
+func GenerateMasterKey(id string) (string, error) {
+	if !masterKeyIDPattern.MatchString(id) {
+		return "", fmt.Errorf("master key ID %q may only contain letters, digits, - and _", id)
+	}
+	key := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", fmt.Errorf("failed to generate master key: %w", err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key), nil
+}
+
+// decodeMasterKey accepts standard or URL base64, with or without padding
+func decodeMasterKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimRight(strings.TrimSpace(encoded), "=")
+	key, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.RawURLEncoding.DecodeString(encoded)
+	}
+	if err != nil {
+		return nil, errors.New("key is not valid base64")
+	}
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", masterKeySize, len(key))
+	}
+	return key, nil
+}
+
+// ActiveKeyID returns the ID of the master key used for new secrets
+func (s *SecretsService) ActiveKeyID() string {
+	return s.active
+}
+
+// IsEncryptedSecret reports whether a stored value was encrypted by Encrypt
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// Encrypt seals a secret. The context names what the secret is for, e.g.
+// "setting:smtp_password"; the same context must be given to Decrypt, so an
+// encrypted value cannot be copied over a different secret.
+func (s *SecretsService) Encrypt(plaintext, context string) (string, error) {
+	dataKey := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	ciphertext, err := sealGCM(dataKey, []byte(plaintext), []byte(context))
+	if err != nil {
+		return "", err
+	}
+	return s.wrap(s.active, dataKey, context, ciphertext)
+}
+
+// Decrypt opens a secret sealed by Encrypt with the same context
+func (s *SecretsService) Decrypt(value, context string) (string, error) {
+	keyID, dataKey, ciphertext, err := s.unwrap(value, context)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openGCM(dataKey, ciphertext, []byte(context))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidSecret, keyID)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRewrap reports whether a value is plaintext or was encrypted with a
+// master key other than the active one
+func (s *SecretsService) NeedsRewrap(value string) bool {
+	if !IsEncryptedSecret(value) {
+		return true
+	}
+	keyID, _, _ := strings.Cut(strings.TrimPrefix(value, secretPrefix), ":")
+	return keyID != s.active
+}
+
+// Rewrap wraps the data key of a secret with the active master key. The
+// secret itself is not decrypted; values that are not encrypted yet are
+// encrypted.
+func (s *SecretsService) Rewrap(value, context string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return s.Encrypt(value, context)
+	}
+	keyID, dataKey, ciphertext, err := s.unwrap(value, context)
+	if err != nil {
+		return "", err
+	}
+	if keyID == s.active {
+		return value, nil
+	}
+	return s.wrap(s.active, dataKey, context, ciphertext)
+}
+
+// wrap seals the data key with a master key and formats the stored value
+func (s *SecretsService) wrap(keyID string, dataKey []byte, context string, ciphertext []byte) (string, error) {
+	wrappedKey, err := sealGCM(s.keys[keyID], dataKey, wrapAAD(keyID, context))
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + keyID + ":" +
+		base64.RawURLEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// unwrap parses a stored value and opens its data key
+func (s *SecretsService) unwrap(value, context string) (keyID string, dataKey, ciphertext []byte, err error) {
+	if !IsEncryptedSecret(value) {
+		return "", nil, nil, ErrInvalidSecret
+	}
+	parts := strings.Split(strings.TrimPrefix(value, secretPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, ErrInvalidSecret
+	}
+	keyID = parts[0]
+
+	masterKey, ok := s.keys[keyID]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, keyID)
+	}
+	wrappedKey, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, ErrInvalidSecret
+	}
+	if ciphertext, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, ErrInvalidSecret
+	}
+	if dataKey, err = openGCM(masterKey, wrappedKey, wrapAAD(keyID, context)); err != nil {
+		return "", nil, nil, fmt.Errorf("%w: %s", ErrInvalidSecret, keyID)
+	}
+	return keyID, dataKey, ciphertext, nil
+}
+
+// wrapAAD binds a wrapped data key to its master key and secret
+func wrapAAD(keyID, context string) []byte {
+	return []byte("solobase-data-key:" + keyID + ":" + context)
+}
+
+// sealGCM encrypts with AES-256-GCM, prefixing the random nonce
+func sealGCM(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// openGCM decrypts a value sealed by sealGCM
+func openGCM(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrInvalidSecret
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
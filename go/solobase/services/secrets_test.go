@@ -0,0 +1,143 @@
+package services
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSecrets(t *testing.T, ids ...string) *SecretsService {
+	var keyring []string
+	for _, id := range ids {
+		entry, err := GenerateMasterKey(id)
+		require.NoError(t, err)
+		keyring = append(keyring, entry)
+	}
+	secrets, err := NewSecretsService(strings.Join(keyring, ","))
+	require.NoError(t, err)
+	return secrets
+}
+
+func TestSecretsEncryptDecrypt(t *testing.T) {
+	secrets := newTestSecrets(t, "k1")
+
+	encrypted, err := secrets.Encrypt("hunter2", "setting:smtp_password")
+	require.NoError(t, err)
+	assert.True(t, IsEncryptedSecret(encrypted))
+	assert.NotContains(t, encrypted, "hunter2")
+
+	plaintext, err := secrets.Decrypt(encrypted, "setting:smtp_password")
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", plaintext)
+
+	// A value copied over a different secret does not decrypt
+	_, err = secrets.Decrypt(encrypted, "setting:s3_secret_key")
+	assert.ErrorIs(t, err, ErrInvalidSecret)
+
+	_, err = newTestSecrets(t, "k1").Decrypt(encrypted, "setting:smtp_password")
+	assert.ErrorIs(t, err, ErrInvalidSecret)
+	_, err = newTestSecrets(t, "other").Decrypt(encrypted, "setting:smtp_password")
+	assert.ErrorIs(t, err, ErrUnknownMasterKey)
+}
+
+func TestSecretsRotation(t *testing.T) {
+	old := newTestSecrets(t, "old")
+	encrypted, err := old.Encrypt("hunter2", "ctx")
+	require.NoError(t, err)
+
+	// The new key goes first, the old one stays loaded to decrypt
+	newEntry, err := GenerateMasterKey("new")
+	require.NoError(t, err)
+	oldEntry := "old:" + base64Key(t, old, "old")
+	rotated, err := NewSecretsService(newEntry + "\n" + oldEntry)
+	require.NoError(t, err)
+	assert.Equal(t, "new", rotated.ActiveKeyID())
+
+	assert.True(t, rotated.NeedsRewrap(encrypted))
+	rewrapped, err := rotated.Rewrap(encrypted, "ctx")
+	require.NoError(t, err)
+	assert.False(t, rotated.NeedsRewrap(rewrapped))
+	assert.True(t, strings.HasPrefix(rewrapped, secretPrefix+"new:"))
+
+	// Once rewrapped the old key is no longer needed
+	onlyNew, err := NewSecretsService(newEntry)
+	require.NoError(t, err)
+	plaintext, err := onlyNew.Decrypt(rewrapped, "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", plaintext)
+}
+
+func TestLoadSecretsService(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys", "master.key")
+
+	secrets, created, err := LoadSecretsService("", keyFile)
+	require.NoError(t, err)
+	assert.True(t, created)
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	encrypted, err := secrets.Encrypt("hunter2", "ctx")
+	require.NoError(t, err)
+	reloaded, created, err := LoadSecretsService("", keyFile)
+	require.NoError(t, err)
+	assert.False(t, created)
+	plaintext, err := reloaded.Decrypt(encrypted, "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", plaintext)
+
+	_, err = NewSecretsService("k1:dG9vIHNob3J0")
+	assert.Error(t, err)
+	_, err = NewSecretsService("")
+	assert.Error(t, err)
+}
+
+func TestSecretSettings(t *testing.T) {
+	settingsService := newTestSettingsService(t)
+	require.NoError(t, settingsService.SetSetting("smtp_password", "saved-before-encryption"))
+
+	// Plaintext secrets are encrypted once a master key is loaded
+	secrets := newTestSecrets(t, "k1")
+	require.NoError(t, settingsService.SetSecrets(secrets))
+	assert.True(t, IsEncryptedSecret(storedSettingValue(t, settingsService, "smtp_password")))
+
+	_, err := settingsService.UpdateSettings(map[string]interface{}{"smtp_password": "hunter2"})
+	require.NoError(t, err)
+	assert.NotContains(t, storedSettingValue(t, settingsService, "smtp_password"), "hunter2")
+
+	value, err := settingsService.GetSetting("smtp_password")
+	require.NoError(t, err)
+	assert.Equal(t, RedactedSecret, value)
+	settings, err := settingsService.GetSettings()
+	require.NoError(t, err)
+	assert.Empty(t, settings.SMTPPassword)
+
+	// Saving the redacted value back keeps the secret
+	_, err = settingsService.UpdateSettings(map[string]interface{}{"smtp_password": RedactedSecret})
+	require.NoError(t, err)
+	password, err := settingsService.GetSecret("smtp_password")
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", password)
+
+	_, err = settingsService.GetSecret("app_name")
+	assert.Error(t, err)
+}
+
+// storedSettingValue reads a setting row as stored in the database
+func storedSettingValue(t *testing.T, settingsService *SettingsService, key string) string {
+	var value string
+	require.NoError(t, settingsService.db.Table("settings").Where("key = ?", key).Select("value").Scan(&value).Error)
+	return value
+}
+
+// base64Key returns the encoded master key with the ID
+func base64Key(t *testing.T, secrets *SecretsService, id string) string {
+	key, ok := secrets.keys[id]
+	require.True(t, ok)
+	return base64.StdEncoding.EncodeToString(key)
+}
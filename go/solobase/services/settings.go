@@ -4,14 +4,43 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/suppers-ai/solobase/database"
 	"github.com/suppers-ai/solobase/models"
 	"gorm.io/gorm"
 )
 
+// settingsCacheTTL bounds how stale cached settings may get when they are
+// changed by another instance
+const settingsCacheTTL = 5 * time.Second
+
 type SettingsService struct {
 	db *database.DB
+
+	// Settings are read on every request by the runtime policies, so the
+	// parsed settings are cached briefly and dropped on every change
+	mu       sync.Mutex
+	cached   *models.AppSettings
+	cachedAt time.Time
+
+	// secrets encrypts the secret settings. Without it they are stored as
+	// plaintext, which is only done in tests.
+	secrets *SecretsService
+}
+
+// secretSettings are encrypted at rest and redacted in API responses. They
+// are left out of GetSettings; the services using them call GetSecret.
+var secretSettings = map[string]bool{
+	"smtp_password": true,
+	"s3_access_key": true,
+	"s3_secret_key": true,
+}
+
+// IsSecretSetting reports whether a setting is encrypted and redacted
+func IsSecretSetting(key string) bool {
+	return secretSettings[key]
 }
 
 func NewSettingsService(db *database.DB) *SettingsService {
@@ -23,6 +52,37 @@ func NewSettingsService(db *database.DB) *SettingsService {
 	return service
 }
 
+// SetSecrets encrypts secret settings from now on. Secrets saved before
+// encryption was enabled, or with a master key that is no longer the active
+// one, are re-encrypted with the active key.
+func (s *SettingsService) SetSecrets(secrets *SecretsService) error {
+	s.secrets = secrets
+
+	keys := make([]string, 0, len(secretSettings))
+	for key := range secretSettings {
+		keys = append(keys, key)
+	}
+	var settings []models.Setting
+	if err := s.db.Where("key IN ?", keys).Find(&settings).Error; err != nil {
+		return err
+	}
+
+	for _, setting := range settings {
+		if setting.Value == "" || !secrets.NeedsRewrap(setting.Value) {
+			continue
+		}
+		value, err := secrets.Rewrap(setting.Value, settingSecretContext(setting.Key))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt setting %s: %w", setting.Key, err)
+		}
+		setting.Value, setting.Type = value, "secret"
+		if err := s.db.Save(&setting).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // initializeExtensionSettings creates extension-specific default settings
 func (s *SettingsService) initializeExtensionSettings() error {
 	// Initialize CloudStorage extension setting for showing usage in profile
@@ -52,17 +112,24 @@ func (s *SettingsService) initializeDefaults() error {
 		"app_url":                     defaults.AppURL,
 		"allow_signup":                defaults.AllowSignup,
 		"require_email_confirmation":  defaults.RequireEmailConfirmation,
+		"enable_magic_link":           defaults.EnableMagicLink,
 		"smtp_enabled":                defaults.SMTPEnabled,
 		"smtp_host":                   defaults.SMTPHost,
 		"smtp_port":                   defaults.SMTPPort,
 		"smtp_user":                   defaults.SMTPUser,
+		"smtp_from":                   defaults.SMTPFrom,
 		"storage_provider":            defaults.StorageProvider,
 		"s3_bucket":                   defaults.S3Bucket,
 		"s3_region":                   defaults.S3Region,
 		"max_upload_size":             defaults.MaxUploadSize,
 		"allowed_file_types":          defaults.AllowedFileTypes,
+		"trash_retention_days":        defaults.TrashRetentionDays,
 		"session_timeout":             defaults.SessionTimeout,
 		"password_min_length":         defaults.PasswordMinLength,
+		"two_factor_required_roles":   defaults.TwoFactorRequiredRoles,
+		"lockout_threshold":           defaults.LockoutThreshold,
+		"lockout_ip_threshold":        defaults.LockoutIPThreshold,
+		"lockout_duration":            defaults.LockoutDuration,
 		"enable_api_logs":             defaults.EnableAPILogs,
 		"enable_debug_mode":           defaults.EnableDebugMode,
 		"maintenance_mode":            defaults.MaintenanceMode,
@@ -82,6 +149,31 @@ func (s *SettingsService) initializeDefaults() error {
 
 // GetSettings retrieves all settings as AppSettings struct
 func (s *SettingsService) GetSettings() (*models.AppSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != nil && time.Since(s.cachedAt) < settingsCacheTTL {
+		settings := *s.cached
+		return &settings, nil
+	}
+
+	appSettings, err := s.loadSettings()
+	if err != nil {
+		return nil, err
+	}
+	s.cached, s.cachedAt = appSettings, time.Now()
+	settings := *appSettings
+	return &settings, nil
+}
+
+// invalidate drops the cached settings so the next read sees a change
+func (s *SettingsService) invalidate() {
+	s.mu.Lock()
+	s.cached = nil
+	s.mu.Unlock()
+}
+
+// loadSettings reads all settings from the database
+func (s *SettingsService) loadSettings() (*models.AppSettings, error) {
 	var settings []models.Setting
 	if err := s.db.Find(&settings).Error; err != nil {
 		return nil, err
@@ -122,9 +214,44 @@ func (s *SettingsService) GetSetting(key string) (interface{}, error) {
 		return nil, err
 	}
 
+	if secretSettings[key] {
+		if setting.Value == "" {
+			return "", nil
+		}
+		return RedactedSecret, nil
+	}
 	return s.parseValue(setting.Value, setting.Type)
 }
 
+// GetSecret returns the decrypted value of a secret setting, for the service
+// that uses it. It is empty when the secret is not set.
+func (s *SettingsService) GetSecret(key string) (string, error) {
+	if !secretSettings[key] {
+		return "", fmt.Errorf("setting %s is not a secret", key)
+	}
+
+	var setting models.Setting
+	if err := s.db.Where("key = ?", key).First(&setting).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if !IsEncryptedSecret(setting.Value) {
+		return setting.Value, nil
+	}
+	if s.secrets == nil {
+		return "", fmt.Errorf("setting %s is encrypted but no master key is loaded", key)
+	}
+	return s.secrets.Decrypt(setting.Value, settingSecretContext(key))
+}
+
+// settingSecretContext binds an encrypted setting to its key
+func settingSecretContext(key string) string {
+	return "setting:" + key
+}
+
 // SetSetting updates or creates a single setting (public method)
 func (s *SettingsService) SetSetting(key string, value interface{}) error {
 	return s.setSetting(key, value)
@@ -132,11 +259,43 @@ func (s *SettingsService) SetSetting(key string, value interface{}) error {
 
 // setSetting updates or creates a single setting
 func (s *SettingsService) setSetting(key string, value interface{}) error {
-	var setting models.Setting
-	
+	defer s.invalidate()
+
+	if secretSettings[key] {
+		return s.setSecretSetting(key, value)
+	}
+
 	// Determine type and convert value to string
 	valueStr, valueType := s.serializeValue(value)
-	
+	return s.saveSetting(key, valueStr, valueType)
+}
+
+// setSecretSetting encrypts and saves a secret setting. Saving the redacted
+// value returned by the API keeps the current secret.
+func (s *SettingsService) setSecretSetting(key string, value interface{}) error {
+	plaintext, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("setting %s must be a string", key)
+	}
+	if plaintext == RedactedSecret {
+		return nil
+	}
+
+	stored := plaintext
+	if s.secrets != nil && plaintext != "" {
+		encrypted, err := s.secrets.Encrypt(plaintext, settingSecretContext(key))
+		if err != nil {
+			return err
+		}
+		stored = encrypted
+	}
+	return s.saveSetting(key, stored, "secret")
+}
+
+// saveSetting updates or creates a setting row
+func (s *SettingsService) saveSetting(key, valueStr, valueType string) error {
+	var setting models.Setting
+
 	// Check if setting exists
 	err := s.db.Where("key = ?", key).First(&setting).Error
 	if err == gorm.ErrRecordNotFound {
@@ -224,6 +383,10 @@ func (s *SettingsService) applySetting(appSettings *models.AppSettings, setting
 		if v, ok := value.(bool); ok {
 			appSettings.RequireEmailConfirmation = v
 		}
+	case "enable_magic_link":
+		if v, ok := value.(bool); ok {
+			appSettings.EnableMagicLink = v
+		}
 	case "smtp_enabled":
 		if v, ok := value.(bool); ok {
 			appSettings.SMTPEnabled = v
@@ -233,16 +396,20 @@ func (s *SettingsService) applySetting(appSettings *models.AppSettings, setting
 			appSettings.SMTPHost = v
 		}
 	case "smtp_port":
-		if v, ok := value.(int); ok {
+		switch v := value.(type) {
+		case int:
 			appSettings.SMTPPort = v
+		case float64:
+			// Numbers saved from JSON requests are stored as floats
+			appSettings.SMTPPort = int(v)
 		}
 	case "smtp_user":
 		if v, ok := value.(string); ok {
 			appSettings.SMTPUser = v
 		}
-	case "smtp_password":
+	case "smtp_from":
 		if v, ok := value.(string); ok {
-			appSettings.SMTPPassword = v
+			appSettings.SMTPFrom = v
 		}
 	case "storage_provider":
 		if v, ok := value.(string); ok {
@@ -256,30 +423,42 @@ func (s *SettingsService) applySetting(appSettings *models.AppSettings, setting
 		if v, ok := value.(string); ok {
 			appSettings.S3Region = v
 		}
-	case "s3_access_key":
-		if v, ok := value.(string); ok {
-			appSettings.S3AccessKey = v
-		}
-	case "s3_secret_key":
-		if v, ok := value.(string); ok {
-			appSettings.S3SecretKey = v
-		}
 	case "max_upload_size":
-		if v, ok := value.(int); ok {
+		if v, ok := intSettingValue(value); ok {
 			appSettings.MaxUploadSize = int64(v)
 		}
 	case "allowed_file_types":
 		if v, ok := value.(string); ok {
 			appSettings.AllowedFileTypes = v
 		}
+	case "trash_retention_days":
+		if v, ok := intSettingValue(value); ok {
+			appSettings.TrashRetentionDays = v
+		}
 	case "session_timeout":
-		if v, ok := value.(int); ok {
+		if v, ok := intSettingValue(value); ok {
 			appSettings.SessionTimeout = v
 		}
 	case "password_min_length":
-		if v, ok := value.(int); ok {
+		if v, ok := intSettingValue(value); ok {
 			appSettings.PasswordMinLength = v
 		}
+	case "two_factor_required_roles":
+		if v, ok := value.(string); ok {
+			appSettings.TwoFactorRequiredRoles = v
+		}
+	case "lockout_threshold":
+		if v, ok := intSettingValue(value); ok {
+			appSettings.LockoutThreshold = v
+		}
+	case "lockout_ip_threshold":
+		if v, ok := intSettingValue(value); ok {
+			appSettings.LockoutIPThreshold = v
+		}
+	case "lockout_duration":
+		if v, ok := intSettingValue(value); ok {
+			appSettings.LockoutDuration = v
+		}
 	case "enable_api_logs":
 		if v, ok := value.(bool); ok {
 			appSettings.EnableAPILogs = v
@@ -307,11 +486,14 @@ func (s *SettingsService) applySetting(appSettings *models.AppSettings, setting
 
 // DeleteSetting removes a setting
 func (s *SettingsService) DeleteSetting(key string) error {
+	defer s.invalidate()
 	return s.db.Where("key = ?", key).Delete(&models.Setting{}).Error
 }
 
 // ResetToDefaults resets all settings to default values
 func (s *SettingsService) ResetToDefaults() error {
+	defer s.invalidate()
+
 	// Delete all existing settings
 	if err := s.db.Exec("DELETE FROM settings").Error; err != nil {
 		return err
@@ -319,4 +501,16 @@ func (s *SettingsService) ResetToDefaults() error {
 
 	// Reinitialize defaults
 	return s.initializeDefaults()
-}
\ No newline at end of file
+}
+
+// intSettingValue reads a whole number setting. Numbers saved from JSON
+// requests are stored as floats.
+func intSettingValue(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suppers-ai/solobase/models"
+)
+
+func newTestSettingsService(t *testing.T) *SettingsService {
+	db := newTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Setting{}))
+	return NewSettingsService(db)
+}
+
+func TestSettingsChangesApplyImmediately(t *testing.T) {
+	settingsService := newTestSettingsService(t)
+
+	settings, err := settingsService.GetSettings()
+	require.NoError(t, err)
+	assert.False(t, settings.MaintenanceMode)
+
+	// Copies are returned, so callers cannot change the cached settings
+	settings.MaintenanceMode = true
+	settings, err = settingsService.GetSettings()
+	require.NoError(t, err)
+	assert.False(t, settings.MaintenanceMode)
+
+	// Numbers saved from JSON requests arrive as floats
+	settings, err = settingsService.UpdateSettings(map[string]interface{}{
+		"maintenance_mode":    true,
+		"max_upload_size":     float64(1 << 20),
+		"session_timeout":     float64(60),
+		"password_min_length": float64(12),
+	})
+	require.NoError(t, err)
+	assert.True(t, settings.MaintenanceMode)
+	assert.Equal(t, int64(1<<20), settings.MaxUploadSize)
+	assert.Equal(t, 60, settings.SessionTimeout)
+	assert.Equal(t, 12, settings.PasswordMinLength)
+
+	require.NoError(t, settingsService.ResetToDefaults())
+	settings, err = settingsService.GetSettings()
+	require.NoError(t, err)
+	assert.False(t, settings.MaintenanceMode)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicyFromSettings(&models.AppSettings{PasswordMinLength: 10})
+
+	assert.NoError(t, policy.Validate("correct-horse", "alice@example.com"))
+	for _, password := range []string{
+		"short-1",                // too short
+		"onlyletterss",           // no numbers or symbols
+		"1234567890",             // no letters
+		"Password123",            // too common
+		"my-alice-password",      // contains the email
+		string(make([]byte, 80)), // too long for bcrypt
+	} {
+		assert.Error(t, policy.Validate(password, "alice@example.com"), password)
+	}
+
+	// The settings cannot ask for less than the minimum
+	assert.Equal(t, minPasswordLength, PasswordPolicyFromSettings(&models.AppSettings{PasswordMinLength: 2}).MinLength)
+	assert.Equal(t, 8, PasswordPolicyFromSettings(&models.AppSettings{}).MinLength)
+}
+
+func TestUploadPolicy(t *testing.T) {
+	policy := UploadPolicyFromSettings(&models.AppSettings{MaxUploadSize: 1000, AllowedFileTypes: "image/*, application/pdf, .md"})
+
+	assert.NoError(t, policy.Check("photo.png", "image/png", 1000))
+	assert.NoError(t, policy.Check("report.pdf", "application/pdf; name=report.pdf", 10))
+	assert.NoError(t, policy.Check("notes.md", "text/markdown", 10))
+	// Unknown types sent by the browser are looked up by extension
+	assert.NoError(t, policy.Check("scan.pdf", "application/octet-stream", 10))
+
+	assert.ErrorIs(t, policy.Check("photo.png", "image/png", 1001), ErrFileTooLarge)
+	assert.ErrorIs(t, policy.Check("app.exe", "application/octet-stream", 10), ErrFileTypeNotAllowed)
+	assert.ErrorIs(t, policy.Check("page.html", "text/html", 10), ErrFileTypeNotAllowed)
+
+	open := UploadPolicyFromSettings(&models.AppSettings{AllowedFileTypes: "*"})
+	assert.NoError(t, open.Check("app.exe", "", 1<<40))
+}
+
+func TestSessionPolicy(t *testing.T) {
+	day := SessionPolicyFromSettings(&models.AppSettings{SessionTimeout: 1440})
+	assert.Equal(t, 24*time.Hour, day.SessionTTL())
+	assert.Equal(t, AccessTokenTTL, day.AccessTokenTTL())
+
+	// Access tokens never outlive a short session
+	short := SessionPolicyFromSettings(&models.AppSettings{SessionTimeout: 5})
+	assert.Equal(t, 5*time.Minute, short.AccessTokenTTL())
+
+	assert.Equal(t, RefreshTokenTTL, SessionPolicy{}.SessionTTL())
+
+	authService := NewAuthService(newTestDB(t))
+	user := newTestUser(t, authService, "alice@example.com")
+	session, refreshToken, err := authService.CreateSession(user.ID, "test", "127.0.0.1", short)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), session.ExpiresAt, time.Minute)
+
+	session, _, err = authService.RefreshSession(refreshToken, "test", "127.0.0.1", day)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), session.ExpiresAt, time.Minute)
+}
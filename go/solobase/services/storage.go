@@ -2,13 +2,13 @@ package services
 
 import (
 	"bytes"
-	"crypto/md5"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,6 +16,7 @@ import (
 	"github.com/suppers-ai/solobase/database"
 	"github.com/suppers-ai/solobase/storage"
 	pkgstorage "github.com/suppers-ai/storage"
+	"gorm.io/gorm"
 )
 
 // EnhancedStorageService is an alias for StorageService
@@ -32,6 +33,11 @@ type StorageService struct {
 	storage  *storage.Storage
 	db       *database.DB
 	appID    string // Application ID for storage isolation
+
+	purgeHook StoragePurgeHook
+
+	// Resumable uploads being written, so each takes one chunk at a time
+	uploadLocks sync.Map
 }
 
 func NewStorageService(db *database.DB, cfg config.StorageConfig) *StorageService {
@@ -175,6 +181,50 @@ func (s *StorageService) GetObjectByKey(bucket, key string) (io.ReadCloser, stri
 	return reader, filename, object.ContentType, nil
 }
 
+// PutKeyedObject streams content to a key of a bucket without recording it
+// as a file, for content addressed by key such as extension data. A negative
+// size is for content of unknown length.
+func (s *StorageService) PutKeyedObject(bucket, key string, reader io.Reader, size int64, contentType string) error {
+	if s.storage == nil {
+		return fmt.Errorf("storage not initialized")
+	}
+	return s.storage.PutObject(bucket, key, pkgstorage.NewHashingReader(reader, size), size, contentType)
+}
+
+// GetKeyedObject opens content stored with PutKeyedObject
+func (s *StorageService) GetKeyedObject(bucket, key string) (io.ReadCloser, error) {
+	if s.storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+	return s.storage.GetObject(bucket, key)
+}
+
+// DeleteKeyedObject deletes content stored with PutKeyedObject
+func (s *StorageService) DeleteKeyedObject(bucket, key string) error {
+	if s.storage == nil {
+		return fmt.Errorf("storage not initialized")
+	}
+	return s.storage.DeleteObject(bucket, key)
+}
+
+// ListKeyedObjects lists the keys of a bucket under a prefix
+func (s *StorageService) ListKeyedObjects(bucket, prefix string) ([]string, error) {
+	if s.storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+	objects, err := s.storage.ListObjects(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		if !obj.IsDirectory {
+			keys = append(keys, obj.Key)
+		}
+	}
+	return keys, nil
+}
+
 // GeneratePresignedDownloadURL generates a presigned URL for downloading (S3 only)
 func (s *StorageService) GeneratePresignedDownloadURL(bucket, key string, expiry int) (string, error) {
 	if s.config.Type != "s3" {
@@ -197,6 +247,12 @@ func (s *StorageService) GeneratePresignedUploadURL(bucket, key, contentType str
 }
 
 func (s *StorageService) CreateBucket(name string, public bool) error {
+	return s.CreateOrganizationBucket(name, public, "")
+}
+
+// CreateOrganizationBucket creates a bucket owned by an organization, whose
+// members share its objects. An empty orgID creates a regular bucket.
+func (s *StorageService) CreateOrganizationBucket(name string, public bool, orgID string) error {
 	if s.storage == nil {
 		return fmt.Errorf("storage not initialized")
 	}
@@ -225,6 +281,9 @@ func (s *StorageService) CreateBucket(name string, public bool) error {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
+	if orgID != "" {
+		bucket.OrganizationID = &orgID
+	}
 
 	if err := s.db.Create(bucket).Error; err != nil {
 		// If we just created the bucket on disk, try to rollback
@@ -242,14 +301,55 @@ func (s *StorageService) DeleteBucket(name string) error {
 		return fmt.Errorf("storage not initialized")
 	}
 
+	// Abort resumable and multipart uploads still being written to the bucket
+	var uploads []pkgstorage.StorageUpload
+	if err := s.db.Where("bucket_name = ?", name).Find(&uploads).Error; err != nil {
+		return err
+	}
+	for i := range uploads {
+		if err := s.discardUpload(&uploads[i]); err != nil {
+			return err
+		}
+	}
+	var multipart []pkgstorage.StorageMultipartUpload
+	if err := s.db.Where("bucket_name = ?", name).Find(&multipart).Error; err != nil {
+		return err
+	}
+	for i := range multipart {
+		if err := s.discardMultipartUpload(&multipart[i]); err != nil {
+			return err
+		}
+	}
+
 	// Delete from storage provider
 	err := s.storage.DeleteBucket(name)
 	if err != nil {
 		return err
 	}
 
+	// Add up the space each user gets back, files in the trash included
+	type userUsage struct {
+		UserID string
+		Size   int64
+	}
+	var usage []userUsage
+	objectIDs := s.db.Unscoped().Model(&pkgstorage.StorageObject{}).Select("id").Where("bucket_name = ?", name)
+	for _, query := range []*gorm.DB{
+		s.db.Unscoped().Model(&pkgstorage.StorageObject{}).Where("bucket_name = ?", name),
+		s.db.Model(&pkgstorage.StorageObjectVersion{}).Where("object_id IN (?)", objectIDs),
+	} {
+		var rows []userUsage
+		if err := query.Select("user_id, COALESCE(SUM(size), 0) AS size").Group("user_id").Scan(&rows).Error; err != nil {
+			return err
+		}
+		usage = append(usage, rows...)
+	}
+
 	// Delete bucket and all objects from database
-	if err := s.db.Where("bucket_name = ?", name).Delete(&pkgstorage.StorageObject{}).Error; err != nil {
+	if err := s.db.Where("object_id IN (?)", objectIDs).Delete(&pkgstorage.StorageObjectVersion{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Unscoped().Where("bucket_name = ?", name).Delete(&pkgstorage.StorageObject{}).Error; err != nil {
 		return err
 	}
 
@@ -257,9 +357,30 @@ func (s *StorageService) DeleteBucket(name string) error {
 		return err
 	}
 
+	for _, row := range usage {
+		s.releaseSpace(row.UserID, name, "", row.Size)
+	}
+
 	return nil
 }
 
+// GetBucket returns the stored record of a bucket
+func (s *StorageService) GetBucket(name string) (*pkgstorage.StorageBucket, error) {
+	var bucket pkgstorage.StorageBucket
+	if err := s.db.Where("name = ?", name).First(&bucket).Error; err != nil {
+		return nil, err
+	}
+	return &bucket, nil
+}
+
+// ListBuckets returns the bucket records by name, without the object stats
+// GetBuckets adds
+func (s *StorageService) ListBuckets() ([]pkgstorage.StorageBucket, error) {
+	var buckets []pkgstorage.StorageBucket
+	err := s.db.Order("name").Find(&buckets).Error
+	return buckets, err
+}
+
 func (s *StorageService) GetBuckets() ([]interface{}, error) {
 	if s.storage == nil {
 		return []interface{}{}, nil
@@ -288,20 +409,24 @@ func (s *StorageService) GetBuckets() ([]interface{}, error) {
 			Scan(&totalSize)
 
 		result[i] = map[string]interface{}{
-			"id":         bucket.ID,
-			"name":       bucket.Name,
-			"public":     bucket.Public,
-			"created_at": bucket.CreatedAt.Format("2006-01-02"),
-			"files":      count,
-			"size":       formatBytes(totalSize),
-			"size_bytes": totalSize,
+			"id":              bucket.ID,
+			"name":            bucket.Name,
+			"public":          bucket.Public,
+			"organization_id": bucket.OrganizationID,
+			"versioning":      bucket.Versioning,
+			"max_versions":    bucket.MaxVersions,
+			"created_at":      bucket.CreatedAt.Format("2006-01-02"),
+			"files":           count,
+			"size":            formatBytes(totalSize),
+			"size_bytes":      totalSize,
 		}
 	}
 
 	return result, nil
 }
 
-// GetObjects returns objects in a bucket filtered by userID, appID, and parentFolderID
+// GetObjects returns objects in a bucket filtered by userID, appID, and parentFolderID.
+// Buckets owned by an organization list the objects of all its members.
 func (s *StorageService) GetObjects(bucket string, userID string, parentFolderID *string) ([]interface{}, error) {
 	if s.storage == nil {
 		return []interface{}{}, nil
@@ -310,7 +435,10 @@ func (s *StorageService) GetObjects(bucket string, userID string, parentFolderID
 	log.Printf("GetObjects: bucket=%s, userID=%s, parentFolderID=%v, appID=%s", bucket, userID, parentFolderID, s.appID)
 
 	// Build query for objects
-	query := s.db.Where("bucket_name = ? AND user_id = ?", bucket, userID)
+	query := s.db.Where("bucket_name = ?", bucket)
+	if record, err := s.GetBucket(bucket); err != nil || record.OrganizationID == nil {
+		query = query.Where("user_id = ?", userID)
+	}
 	
 	// Filter by app ID
 	if s.appID != "" {
@@ -352,6 +480,7 @@ func (s *StorageService) GetObjects(bucket string, userID string, parentFolderID
 			"size":             obj.Size,
 			"content_type":     obj.ContentType,
 			"checksum":         obj.Checksum,
+			"sha256":           obj.SHA256,
 			"metadata":         obj.Metadata,
 			"created_at":       obj.CreatedAt,
 			"updated_at":       obj.UpdatedAt,
@@ -365,22 +494,14 @@ func (s *StorageService) GetObjects(bucket string, userID string, parentFolderID
 	return result, nil
 }
 
+// UploadFile streams a file to the storage provider and records it. A
+// negative size is for content of unknown length; the size and checksums
+// are worked out as the content streams.
 func (s *StorageService) UploadFile(bucket, filename, userID string, reader io.Reader, size int64, mimeType string, parentFolderID *string) (interface{}, error) {
 	if s.storage == nil {
 		return nil, fmt.Errorf("storage not initialized")
 	}
 
-	// Read the content to calculate checksum
-	var buf bytes.Buffer
-	tee := io.TeeReader(reader, &buf)
-
-	// Calculate MD5 checksum
-	hash := md5.New()
-	if _, err := io.Copy(hash, tee); err != nil {
-		return nil, fmt.Errorf("failed to calculate checksum: %v", err)
-	}
-	checksum := hex.EncodeToString(hash.Sum(nil))
-
 	// Generate a unique ID for this object
 	objectID := uuid.New().String()
 	
@@ -388,11 +509,14 @@ func (s *StorageService) UploadFile(bucket, filename, userID string, reader io.R
 	// This keeps files organized and avoids collisions without complex paths
 	storageKey := fmt.Sprintf("%s/%s", objectID, filename)
 	
-	// Upload to storage provider
-	err := s.storage.PutObject(bucket, storageKey, &buf, size, mimeType)
+	// Upload to storage provider, hashing the content on the way
+	hashed := pkgstorage.NewHashingReader(reader, size)
+	err := s.storage.PutObject(bucket, storageKey, hashed, size, mimeType)
 	if err != nil {
 		return nil, err
 	}
+	size = hashed.Size()
+	checksum := hashed.ETag()
 
 	// Get app ID as pointer
 	var appIDPtr *string
@@ -409,6 +533,7 @@ func (s *StorageService) UploadFile(bucket, filename, userID string, reader io.R
 		Size:           size,
 		ContentType:    mimeType,
 		Checksum:       checksum,
+		SHA256:         hashed.SHA256(),
 		UserID:         userID,
 		AppID:          appIDPtr,
 		CreatedAt:      time.Now(),
@@ -426,6 +551,7 @@ func (s *StorageService) UploadFile(bucket, filename, userID string, reader io.R
 		"size":              size,
 		"content_type":      mimeType,
 		"checksum":          checksum,
+		"sha256":            storageObj.SHA256,
 		"parent_folder_id":  parentFolderID,
 		"app_id":            appIDPtr,
 		"url":               s.storage.GetPublicURL(bucket, storageKey),
@@ -576,38 +702,51 @@ func (s *StorageService) GetDB() *database.DB {
 	return s.db
 }
 
-func (s *StorageService) DeleteObject(bucket, objectID string) error {
+// DeleteUserObjects permanently deletes the files and folders a user owns in
+// every bucket, including those in the trash and those still being uploaded
+func (s *StorageService) DeleteUserObjects(userID string) error {
 	if s.storage == nil {
 		return fmt.Errorf("storage not initialized")
 	}
 
-	// Get object from database to get the actual key
-	var obj pkgstorage.StorageObject
-	if err := s.db.Where("id = ? AND bucket_name = ?", objectID, bucket).First(&obj).Error; err != nil {
-		return fmt.Errorf("object not found")
+	var uploads []pkgstorage.StorageUpload
+	if err := s.db.Where("user_id = ?", userID).Find(&uploads).Error; err != nil {
+		return err
 	}
-
-	// Build the storage key using the simple ID-based approach
-	storageKey := s.getStorageKey(&obj)
-	
-	// Delete from storage provider
-	if err := s.storage.DeleteObject(bucket, storageKey); err != nil {
+	for i := range uploads {
+		if err := s.discardUpload(&uploads[i]); err != nil {
+			return err
+		}
+	}
+	var multipart []pkgstorage.StorageMultipartUpload
+	if err := s.db.Where("user_id = ?", userID).Find(&multipart).Error; err != nil {
 		return err
 	}
+	for i := range multipart {
+		if err := s.discardMultipartUpload(&multipart[i]); err != nil {
+			return err
+		}
+	}
 
-	// Delete from database
-	if err := s.db.Delete(&obj).Error; err != nil {
+	var objects []pkgstorage.StorageObject
+	if err := s.db.Unscoped().Where("user_id = ?", userID).Find(&objects).Error; err != nil {
 		return err
 	}
 
+	for i := range objects {
+		if err := s.purgeObject(&objects[i]); err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (s *StorageService) GetTotalStorageUsed() (int64, error) {
 	var totalSize int64
 
-	// Get total storage used from database
-	if err := s.db.Model(&pkgstorage.StorageObject{}).
+	// Get total storage used from database, including files in the trash
+	if err := s.db.Unscoped().Model(&pkgstorage.StorageObject{}).
 		Select("COALESCE(SUM(size), 0)").
 		Scan(&totalSize).Error; err != nil {
 		return 0, err
@@ -616,11 +755,12 @@ func (s *StorageService) GetTotalStorageUsed() (int64, error) {
 	return totalSize, nil
 }
 
-// GetUserStorageUsed returns the total storage used by a specific user
+// GetUserStorageUsed returns the total storage used by a specific user. Files
+// in the trash count until they are purged.
 func (s *StorageService) GetUserStorageUsed(userID string) (int64, error) {
 	var totalSize int64
 
-	if err := s.db.Model(&pkgstorage.StorageObject{}).
+	if err := s.db.Unscoped().Model(&pkgstorage.StorageObject{}).
 		Select("COALESCE(SUM(size), 0)").
 		Where("user_id = ?", userID).
 		Scan(&totalSize).Error; err != nil {
@@ -637,7 +777,7 @@ func (s *StorageService) GetStorageStats(userID string) (map[string]interface{},
 	// Get total file count for user
 	var fileCount int64
 	if err := s.db.Model(&pkgstorage.StorageObject{}).
-		Where("user_id = ? AND NOT is_folder", userID).
+		Where("user_id = ? AND content_type <> ?", userID, "application/x-directory").
 		Count(&fileCount).Error; err != nil {
 		return nil, err
 	}
@@ -646,7 +786,7 @@ func (s *StorageService) GetStorageStats(userID string) (map[string]interface{},
 	// Get total folder count for user
 	var folderCount int64
 	if err := s.db.Model(&pkgstorage.StorageObject{}).
-		Where("user_id = ? AND is_folder", userID).
+		Where("user_id = ? AND content_type = ?", userID, "application/x-directory").
 		Count(&folderCount).Error; err != nil {
 		return nil, err
 	}
@@ -679,6 +819,13 @@ func (s *StorageService) GetStorageStats(userID string) (map[string]interface{},
 	}
 	stats["recent_uploads"] = recentCount
 
+	// Get items in the trash
+	trashedCount, err := s.CountTrash(userID)
+	if err != nil {
+		return nil, err
+	}
+	stats["trashed_count"] = trashedCount
+
 	return stats, nil
 }
 
@@ -696,7 +843,7 @@ func (s *StorageService) GetAllUsersStorageStats() (map[string]interface{}, erro
 	// Get total file count
 	var totalFiles int64
 	if err := s.db.Model(&pkgstorage.StorageObject{}).
-		Where("NOT is_folder").
+		Where("content_type <> ?", "application/x-directory").
 		Count(&totalFiles).Error; err != nil {
 		return nil, err
 	}
@@ -705,7 +852,7 @@ func (s *StorageService) GetAllUsersStorageStats() (map[string]interface{}, erro
 	// Get total folder count
 	var totalFolders int64
 	if err := s.db.Model(&pkgstorage.StorageObject{}).
-		Where("is_folder").
+		Where("content_type = ?", "application/x-directory").
 		Count(&totalFolders).Error; err != nil {
 		return nil, err
 	}
@@ -763,19 +910,14 @@ func (s *StorageService) RenameObject(bucket, objectID, newName string) error {
 		}
 		defer reader.Close()
 
-		// Read the content
-		content, err := io.ReadAll(reader)
-		if err != nil {
-			return fmt.Errorf("failed to read object: %v", err)
-		}
-
-		// Upload with new name
-		if err := s.storage.PutObject(bucket, newKey, bytes.NewReader(content), int64(len(content)), object.ContentType); err != nil {
+		// Stream the content to the new name
+		if err := s.storage.PutObject(bucket, newKey, reader, object.Size, object.ContentType); err != nil {
 			return fmt.Errorf("failed to put renamed object: %v", err)
 		}
 
-		// Delete old object from storage
-		if err := s.storage.DeleteObject(bucket, oldKey); err != nil {
+		// Delete old object from storage. Prior versions stay under the old
+		// key, which their records point to.
+		if err := s.deleteCurrentContent(bucket, oldKey); err != nil {
 			// Try to clean up the new object
 			s.storage.DeleteObject(bucket, newKey)
 			return fmt.Errorf("failed to delete old object: %v", err)
@@ -791,8 +933,7 @@ func (s *StorageService) RenameObject(bucket, objectID, newName string) error {
 			reader, _ := s.storage.GetObject(bucket, newKey)
 			if reader != nil {
 				defer reader.Close()
-				content, _ := io.ReadAll(reader)
-				s.storage.PutObject(bucket, oldKey, bytes.NewReader(content), int64(len(content)), object.ContentType)
+				s.storage.PutObject(bucket, oldKey, reader, object.Size, object.ContentType)
 				s.storage.DeleteObject(bucket, newKey)
 			}
 		}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	pkgstorage "github.com/suppers-ai/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrMultipartUploadNotFound = errors.New("multipart upload not found")
+	// ErrInvalidPart is returned when completing an upload with a part that
+	// was not uploaded or whose ETag does not match
+	ErrInvalidPart = errors.New("invalid part")
+	// ErrInvalidPartOrder is returned when completing an upload with parts
+	// that are not in ascending order
+	ErrInvalidPartOrder = errors.New("parts must be in ascending order")
+)
+
+// CreateMultipartUpload starts an upload of a file to a slash separated path
+// in a bucket, sent in numbered parts that may arrive in any order. The parts
+// are joined into the file by CompleteMultipartUpload. Uploads expire after
+// UploadExpiry without a new part.
+func (s *StorageService) CreateMultipartUpload(bucket, objectPath, userID, mimeType, metadata string) (*pkgstorage.StorageMultipartUpload, error) {
+	if s.storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+	if _, err := splitObjectPath(objectPath); err != nil {
+		return nil, err
+	}
+
+	var appIDPtr *string
+	if s.appID != "" {
+		appIDPtr = &s.appID
+	}
+
+	now := time.Now()
+	upload := &pkgstorage.StorageMultipartUpload{
+		ID:          uuid.New().String(),
+		BucketName:  bucket,
+		Key:         objectPath,
+		ContentType: mimeType,
+		Metadata:    metadata,
+		UserID:      userID,
+		AppID:       appIDPtr,
+		ExpiresAt:   now.Add(UploadExpiry),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.db.Create(upload).Error; err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// GetMultipartUpload returns a multipart upload to a bucket that has not
+// expired
+func (s *StorageService) GetMultipartUpload(bucket, id string) (*pkgstorage.StorageMultipartUpload, error) {
+	var upload pkgstorage.StorageMultipartUpload
+	if err := s.db.Where("id = ? AND bucket_name = ?", id, bucket).First(&upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMultipartUploadNotFound
+		}
+		return nil, err
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, ErrMultipartUploadNotFound
+	}
+	return &upload, nil
+}
+
+// ListMultipartUploads lists a user's unexpired multipart uploads to a
+// bucket, by path and then oldest first
+func (s *StorageService) ListMultipartUploads(bucket, userID string) ([]pkgstorage.StorageMultipartUpload, error) {
+	var uploads []pkgstorage.StorageMultipartUpload
+	err := s.db.Where("bucket_name = ? AND user_id = ? AND expires_at > ?", bucket, userID, time.Now()).
+		Order("object_key, created_at").Find(&uploads).Error
+	return uploads, err
+}
+
+// UploadPart streams a part of a multipart upload to the storage provider,
+// replacing any part uploaded before with the same number
+func (s *StorageService) UploadPart(upload *pkgstorage.StorageMultipartUpload, partNumber int, reader io.Reader, size int64) (*pkgstorage.StorageMultipartPart, error) {
+	if s.storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+
+	hashed := pkgstorage.NewHashingReader(reader, size)
+	if err := s.storage.PutObject(upload.BucketName, partKey(upload.ID, partNumber), hashed, size, "application/octet-stream"); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	part := &pkgstorage.StorageMultipartPart{
+		UploadID:   upload.ID,
+		PartNumber: partNumber,
+		Size:       hashed.Size(),
+		ETag:       hashed.ETag(),
+		UpdatedAt:  now,
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(part).Error; err != nil {
+			return err
+		}
+		return tx.Model(upload).Updates(map[string]interface{}{
+			"expires_at": now.Add(UploadExpiry),
+			"updated_at": now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return part, nil
+}
+
+// ListMultipartParts lists the parts uploaded so far, by part number
+func (s *StorageService) ListMultipartParts(upload *pkgstorage.StorageMultipartUpload) ([]pkgstorage.StorageMultipartPart, error) {
+	var parts []pkgstorage.StorageMultipartPart
+	err := s.db.Where("upload_id = ?", upload.ID).Order("part_number").Find(&parts).Error
+	return parts, err
+}
+
+// CompleteMultipartUpload joins the given parts, which must have been
+// uploaded with matching ETags, into the file at the upload's path. Parts
+// left out are discarded.
+func (s *StorageService) CompleteMultipartUpload(upload *pkgstorage.StorageMultipartUpload, parts []pkgstorage.StorageMultipartPart) (*pkgstorage.StorageObject, error) {
+	uploaded, err := s.ListMultipartParts(upload)
+	if err != nil {
+		return nil, err
+	}
+	byNumber := make(map[int]pkgstorage.StorageMultipartPart, len(uploaded))
+	for _, part := range uploaded {
+		byNumber[part.PartNumber] = part
+	}
+
+	if len(parts) == 0 {
+		return nil, ErrInvalidPart
+	}
+	keys := make([]string, len(parts))
+	var size int64
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return nil, ErrInvalidPartOrder
+		}
+		stored, ok := byNumber[part.PartNumber]
+		if !ok || !strings.EqualFold(strings.Trim(part.ETag, `"`), stored.ETag) {
+			return nil, fmt.Errorf("%w: part %d", ErrInvalidPart, part.PartNumber)
+		}
+		keys[i] = partKey(upload.ID, part.PartNumber)
+		size += stored.Size
+	}
+
+	content := &partsReader{storage: s, bucket: upload.BucketName, keys: keys}
+	defer content.Close()
+	obj, err := s.PutObjectAtPath(upload.BucketName, upload.Key, upload.UserID, content, size, upload.ContentType, upload.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.discardMultipartUpload(upload); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (s *StorageService) AbortMultipartUpload(upload *pkgstorage.StorageMultipartUpload) error {
+	return s.discardMultipartUpload(upload)
+}
+
+// discardMultipartUpload deletes the parts of a multipart upload from the
+// storage provider and forgets it
+func (s *StorageService) discardMultipartUpload(upload *pkgstorage.StorageMultipartUpload) error {
+	if s.storage == nil {
+		return fmt.Errorf("storage not initialized")
+	}
+	parts, err := s.ListMultipartParts(upload)
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		err := s.storage.DeleteObject(upload.BucketName, partKey(upload.ID, part.PartNumber))
+		if err != nil && !strings.Contains(err.Error(), "not found") {
+			return err
+		}
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("upload_id = ?", upload.ID).Delete(&pkgstorage.StorageMultipartPart{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(upload).Error
+	})
+}
+
+// partKey returns the storage key a part of a multipart upload is kept at
+func partKey(uploadID string, partNumber int) string {
+	return fmt.Sprintf(".multipart/%s/%05d", uploadID, partNumber)
+}
+
+// partsReader reads the parts of a multipart upload one after the other,
+// opening each only once the one before is read
+type partsReader struct {
+	storage *StorageService
+	bucket  string
+	keys    []string
+	current io.ReadCloser
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if len(p.keys) == 0 {
+				return 0, io.EOF
+			}
+			reader, err := p.storage.storage.GetObject(p.bucket, p.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			p.current, p.keys = reader, p.keys[1:]
+		}
+
+		n, err := p.current.Read(b)
+		if err == io.EOF {
+			p.current.Close()
+			p.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.current == nil {
+		return nil
+	}
+	err := p.current.Close()
+	p.current = nil
+	return err
+}
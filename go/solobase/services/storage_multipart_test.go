@@ -0,0 +1,117 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pkgstorage "github.com/suppers-ai/storage"
+)
+
+func putPath(t *testing.T, s *StorageService, objectPath, content string) *pkgstorage.StorageObject {
+	obj, err := s.PutObjectAtPath("int_storage", objectPath, "user-1", strings.NewReader(content), int64(len(content)), "text/plain", "")
+	require.NoError(t, err)
+	return obj
+}
+
+func listedPaths(t *testing.T, s *StorageService, prefix string, recursive bool) []string {
+	entries, err := s.ListObjectPaths("int_storage", "user-1", prefix, recursive)
+	require.NoError(t, err)
+	paths := make([]string, len(entries))
+	for i, entry := range entries {
+		paths[i] = entry.Path
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestStorageObjectPaths(t *testing.T) {
+	s, _ := newTestStorageService(t)
+
+	first := putPath(t, s, "docs/reports/a.txt", "one")
+	putPath(t, s, "docs/b.txt", "b")
+
+	// Writing to the same path replaces the file and reuses the folders
+	second := putPath(t, s, "docs/reports/a.txt", "two")
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, "two", readCurrent(t, s, first.ID))
+
+	found, err := s.FindObjectByPath("int_storage", "user-1", "docs/reports/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, found.ID)
+	folder, err := s.FindObjectByPath("int_storage", "user-1", "docs/reports/")
+	require.NoError(t, err)
+	assert.True(t, folder.IsFolder())
+
+	// Paths are resolved within the user's own objects
+	_, err = s.FindObjectByPath("int_storage", "user-2", "docs/reports/a.txt")
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+	_, err = s.FindObjectByPath("int_storage", "user-1", "docs/../b.txt")
+	assert.ErrorIs(t, err, ErrInvalidObjectPath)
+
+	assert.Equal(t, []string{"docs/"}, listedPaths(t, s, "", false))
+	assert.Equal(t, []string{"docs/b.txt", "docs/reports/"}, listedPaths(t, s, "docs/", false))
+	assert.Equal(t, []string{"docs/reports/", "docs/reports/a.txt"}, listedPaths(t, s, "docs/r", true))
+	assert.Empty(t, listedPaths(t, s, "missing/", true))
+}
+
+func TestStorageMultipartUpload(t *testing.T) {
+	s, path := newTestStorageService(t)
+
+	upload, err := s.CreateMultipartUpload("int_storage", "big/file.txt", "user-1", "text/plain", "")
+	require.NoError(t, err)
+
+	// Parts can arrive in any order, and a part sent again replaces it
+	two, err := s.UploadPart(upload, 2, strings.NewReader("world"), 5)
+	require.NoError(t, err)
+	_, err = s.UploadPart(upload, 1, strings.NewReader("bye, "), 5)
+	require.NoError(t, err)
+	one, err := s.UploadPart(upload, 1, strings.NewReader("hello, "), 7)
+	require.NoError(t, err)
+
+	parts, err := s.ListMultipartParts(upload)
+	require.NoError(t, err)
+	require.Len(t, parts, 2)
+	assert.Equal(t, int64(7), parts[0].Size)
+
+	_, err = s.CompleteMultipartUpload(upload, []pkgstorage.StorageMultipartPart{*two, *one})
+	assert.ErrorIs(t, err, ErrInvalidPartOrder)
+	_, err = s.CompleteMultipartUpload(upload, []pkgstorage.StorageMultipartPart{{PartNumber: 1, ETag: two.ETag}, *two})
+	assert.ErrorIs(t, err, ErrInvalidPart)
+
+	obj, err := s.CompleteMultipartUpload(upload, []pkgstorage.StorageMultipartPart{
+		{PartNumber: 1, ETag: `"` + strings.ToUpper(one.ETag) + `"`},
+		*two,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", readCurrent(t, s, obj.ID))
+	assert.Equal(t, int64(12), obj.Size)
+
+	// Completing discards the upload and its parts
+	_, err = s.GetMultipartUpload("int_storage", upload.ID)
+	assert.ErrorIs(t, err, ErrMultipartUploadNotFound)
+	_, err = os.Stat(filepath.Join(path, "int_storage", partKey(upload.ID, 1)))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStorageAbortMultipartUpload(t *testing.T) {
+	s, path := newTestStorageService(t)
+
+	upload, err := s.CreateMultipartUpload("int_storage", "file.txt", "user-1", "text/plain", "")
+	require.NoError(t, err)
+	_, err = s.UploadPart(upload, 1, strings.NewReader("part"), 4)
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(path, "int_storage", partKey(upload.ID, 1)))
+	require.NoError(t, err)
+
+	require.NoError(t, s.AbortMultipartUpload(upload))
+	_, err = os.Stat(filepath.Join(path, "int_storage", partKey(upload.ID, 1)))
+	assert.True(t, os.IsNotExist(err))
+	uploads, err := s.ListMultipartUploads("int_storage", "user-1")
+	require.NoError(t, err)
+	assert.Empty(t, uploads)
+}
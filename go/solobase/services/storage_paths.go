@@ -0,0 +1,281 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	pkgstorage "github.com/suppers-ai/storage"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidObjectPath is returned for paths with empty, "." or ".." parts,
+// which do not name a file or folder
+var ErrInvalidObjectPath = errors.New("invalid object path")
+
+// ObjectPath is a file or folder with its slash separated path in a bucket.
+// Folder paths end with a slash.
+type ObjectPath struct {
+	Path   string
+	Object pkgstorage.StorageObject
+}
+
+// FindObjectByPath looks up a file, or a folder when the path ends with a
+// slash, by its path through the folders of a bucket. userID limits the
+// lookup to the user's own objects, except in organization buckets. When
+// several files share a path the most recently updated one is returned.
+func (s *StorageService) FindObjectByPath(bucket, userID, objectPath string) (*pkgstorage.StorageObject, error) {
+	folder := strings.HasSuffix(objectPath, "/")
+	parts, err := splitObjectPath(strings.TrimSuffix(objectPath, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	scope := s.pathScope(bucket, userID)
+	parents, err := s.resolveFolders(scope, parts[:len(parts)-1])
+	if err != nil {
+		return nil, err
+	}
+	if parents != nil && len(parents) == 0 {
+		return nil, ErrObjectNotFound
+	}
+
+	var objects []pkgstorage.StorageObject
+	query := childrenOf(scope, parents).Where("object_name = ?", parts[len(parts)-1])
+	if folder {
+		query = query.Where("content_type = ?", "application/x-directory").Order("created_at")
+	} else {
+		query = query.Where("content_type <> ?", "application/x-directory").Order("updated_at DESC")
+	}
+	if err := query.Limit(1).Find(&objects).Error; err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, ErrObjectNotFound
+	}
+	return &objects[0], nil
+}
+
+// EnsureFolderPath returns the ID of the folder at a path, given as its
+// folder names, creating the folders that do not exist yet. A nil ID is the
+// bucket's root.
+func (s *StorageService) EnsureFolderPath(bucket, userID string, folders []string) (*string, error) {
+	var parentID *string
+	for _, name := range folders {
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			return nil, ErrInvalidObjectPath
+		}
+
+		var existing []pkgstorage.StorageObject
+		parents := []string(nil)
+		if parentID != nil {
+			parents = []string{*parentID}
+		}
+		err := childrenOf(s.pathScope(bucket, userID), parents).
+			Where("object_name = ? AND content_type = ?", name, "application/x-directory").
+			Order("created_at").Limit(1).Find(&existing).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) > 0 {
+			parentID = &existing[0].ID
+			continue
+		}
+
+		id, err := s.CreateFolderWithParent(bucket, name, userID, parentID)
+		if err != nil {
+			return nil, err
+		}
+		parentID = &id
+	}
+	return parentID, nil
+}
+
+// PutObjectAtPath stores a file at a slash separated path in a bucket,
+// creating the folders on the way. A file already at the path has its content
+// and metadata replaced, keeping the prior content as a version in a bucket
+// with versioning enabled.
+func (s *StorageService) PutObjectAtPath(bucket, objectPath, userID string, reader io.Reader, size int64, mimeType, metadata string) (*pkgstorage.StorageObject, error) {
+	parts, err := splitObjectPath(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	parentID, err := s.EnsureFolderPath(bucket, userID, parts[:len(parts)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	name := parts[len(parts)-1]
+	var obj *pkgstorage.StorageObject
+	existing, err := s.FindObjectByPath(bucket, userID, objectPath)
+	switch {
+	case err == nil:
+		if obj, err = s.ReplaceObjectContent(bucket, existing.ID, reader, size, mimeType); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, ErrObjectNotFound):
+		uploaded, err := s.UploadFile(bucket, name, userID, reader, size, mimeType, parentID)
+		if err != nil {
+			return nil, err
+		}
+		if obj, err = s.findObject(bucket, uploaded.(map[string]interface{})["id"].(string)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if obj.Metadata != metadata {
+		if err := s.db.Model(obj).Update("metadata", metadata).Error; err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// ListObjectPaths lists the files and folders whose paths start with prefix.
+// Without recursive only the entries in the folder the prefix ends in are
+// listed, and folders are not descended into. Entries are in no particular
+// order.
+func (s *StorageService) ListObjectPaths(bucket, userID, prefix string, recursive bool) ([]ObjectPath, error) {
+	dir, namePrefix := "", prefix
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, namePrefix = prefix[:i+1], prefix[i+1:]
+	}
+	var folders []string
+	if dir != "" {
+		var err error
+		if folders, err = splitObjectPath(strings.TrimSuffix(dir, "/")); err != nil {
+			// Nothing can have such a path
+			return []ObjectPath{}, nil
+		}
+	}
+
+	scope := s.pathScope(bucket, userID)
+	parents, err := s.resolveFolders(scope, folders)
+	if err != nil {
+		return nil, err
+	}
+	if parents != nil && len(parents) == 0 {
+		return []ObjectPath{}, nil
+	}
+
+	var children []pkgstorage.StorageObject
+	if err := childrenOf(scope, parents).Find(&children).Error; err != nil {
+		return nil, err
+	}
+
+	// Descend level by level, remembering the path of each folder found
+	var entries []ObjectPath
+	folderPaths := make(map[string]string)
+	for len(children) > 0 {
+		next := make([]string, 0)
+		for _, child := range children {
+			if child.ObjectName == "" || child.ObjectName == ".keep" {
+				continue
+			}
+			childPath := dir + child.ObjectName
+			if child.ParentFolderID != nil {
+				if parentPath, ok := folderPaths[*child.ParentFolderID]; ok {
+					childPath = parentPath + child.ObjectName
+				} else if !strings.HasPrefix(child.ObjectName, namePrefix) {
+					continue
+				}
+			} else if !strings.HasPrefix(child.ObjectName, namePrefix) {
+				continue
+			}
+			if child.IsFolder() {
+				childPath += "/"
+				folderPaths[child.ID] = childPath
+				next = append(next, child.ID)
+			}
+			entries = append(entries, ObjectPath{Path: childPath, Object: child})
+		}
+
+		if !recursive || len(next) == 0 {
+			break
+		}
+		children = nil
+		if err := childrenOf(scope, next).Find(&children).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return uniqueObjectPaths(entries), nil
+}
+
+// pathScope selects the objects of a bucket that paths are resolved
+// against: those of the app, and only the user's own outside organization
+// buckets, as GetObjects lists them
+func (s *StorageService) pathScope(bucket, userID string) *gorm.DB {
+	query := s.db.Where("bucket_name = ?", bucket)
+	if record, err := s.GetBucket(bucket); err != nil || record.OrganizationID == nil {
+		query = query.Where("user_id = ?", userID)
+	}
+	if s.appID != "" {
+		query = query.Where("app_id = ?", s.appID)
+	} else {
+		query = query.Where("app_id IS NULL")
+	}
+	return query.Session(&gorm.Session{})
+}
+
+// resolveFolders returns the IDs of the folders at a path given as folder
+// names. Folders of different users can share a path in an organization
+// bucket, so there may be several. nil is the bucket's root, and an empty
+// slice means there is no such folder.
+func (s *StorageService) resolveFolders(scope *gorm.DB, names []string) ([]string, error) {
+	var parents []string
+	for _, name := range names {
+		var ids []string
+		err := childrenOf(scope, parents).Model(&pkgstorage.StorageObject{}).
+			Where("object_name = ? AND content_type = ?", name, "application/x-directory").
+			Pluck("id", &ids).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return []string{}, nil
+		}
+		parents = ids
+	}
+	return parents, nil
+}
+
+// childrenOf selects the objects directly in the given folders, or in the
+// bucket's root for nil
+func childrenOf(scope *gorm.DB, parents []string) *gorm.DB {
+	if parents == nil {
+		return scope.Where("parent_folder_id IS NULL")
+	}
+	return scope.Where("parent_folder_id IN ?", parents)
+}
+
+// uniqueObjectPaths keeps one entry per path: the first folder, or the most
+// recently updated file
+func uniqueObjectPaths(entries []ObjectPath) []ObjectPath {
+	index := make(map[string]int, len(entries))
+	unique := make([]ObjectPath, 0, len(entries))
+	for _, entry := range entries {
+		i, seen := index[entry.Path]
+		switch {
+		case !seen:
+			index[entry.Path] = len(unique)
+			unique = append(unique, entry)
+		case entry.Object.IsFile() && entry.Object.UpdatedAt.After(unique[i].Object.UpdatedAt):
+			unique[i] = entry
+		}
+	}
+	return unique
+}
+
+// splitObjectPath splits a slash separated path into its parts
+func splitObjectPath(objectPath string) ([]string, error) {
+	parts := strings.Split(objectPath, "/")
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return nil, ErrInvalidObjectPath
+		}
+	}
+	return parts, nil
+}
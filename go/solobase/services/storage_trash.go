@@ -0,0 +1,350 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	pkgstorage "github.com/suppers-ai/storage"
+	"gorm.io/gorm"
+)
+
+// ErrObjectNotInTrash is returned when restoring or purging an object that
+// has not been deleted
+var ErrObjectNotInTrash = errors.New("object is not in the trash")
+
+// TrashPurgeInterval is how often objects past the trash retention period
+// are purged
+const TrashPurgeInterval = time.Hour
+
+// StoragePurgeHook is told about content deleted for good, with the bytes it
+// took up, so extensions can release the space from their quotas. The object
+// ID is empty when a whole bucket was deleted. Moving an object to the trash
+// releases nothing.
+type StoragePurgeHook func(ctx context.Context, userID, bucket, objectID string, size int64)
+
+// SetPurgeHook sets the hook told about content deleted for good
+func (s *StorageService) SetPurgeHook(hook StoragePurgeHook) {
+	s.purgeHook = hook
+}
+
+// releaseSpace tells the purge hook about content deleted for good
+func (s *StorageService) releaseSpace(userID, bucket, objectID string, size int64) {
+	if s.purgeHook != nil && userID != "" && size > 0 {
+		s.purgeHook(context.Background(), userID, bucket, objectID, size)
+	}
+}
+
+// DeleteObject moves an object to the trash. Deleting a folder moves
+// everything in it along, and restoring the folder brings it all back.
+func (s *StorageService) DeleteObject(bucket, objectID, userID string) error {
+	obj, err := s.findObject(bucket, objectID)
+	if err != nil {
+		return err
+	}
+
+	ids := []string{obj.ID}
+	if obj.IsFolder() {
+		var descendants []pkgstorage.StorageObject
+		if err := s.collectDescendants(s.db.DB, obj.ID, &descendants); err != nil {
+			return err
+		}
+		for _, child := range descendants {
+			ids = append(ids, child.ID)
+		}
+	}
+
+	var deletedBy *string
+	if userID != "" {
+		deletedBy = &userID
+	}
+	return s.db.Model(&pkgstorage.StorageObject{}).
+		Where("id IN ?", ids).
+		Updates(map[string]interface{}{
+			"deleted_at": time.Now(),
+			"deleted_by": deletedBy,
+		}).Error
+}
+
+// GetTrash lists the objects a user owns or deleted that are in the trash,
+// most recently deleted first. Objects deleted along with their folder are
+// left out, as restoring or purging the folder covers them.
+func (s *StorageService) GetTrash(userID string) ([]pkgstorage.StorageObject, error) {
+	query := s.trashQuery().Where("user_id = ? OR deleted_by = ?", userID, userID)
+	if s.appID != "" {
+		query = query.Where("app_id = ?", s.appID)
+	} else {
+		query = query.Where("app_id IS NULL")
+	}
+
+	var objects []pkgstorage.StorageObject
+	if err := query.Order("deleted_at DESC").Find(&objects).Error; err != nil {
+		return nil, err
+	}
+	return trashRoots(objects), nil
+}
+
+// CountTrash returns how many items GetTrash lists for a user
+func (s *StorageService) CountTrash(userID string) (int64, error) {
+	objects, err := s.GetTrash(userID)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(objects)), nil
+}
+
+// GetTrashedObject returns an object in the trash
+func (s *StorageService) GetTrashedObject(objectID string) (*pkgstorage.StorageObject, error) {
+	var obj pkgstorage.StorageObject
+	if err := s.db.Unscoped().Where("id = ?", objectID).First(&obj).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	if !obj.DeletedAt.Valid {
+		return nil, ErrObjectNotInTrash
+	}
+	return &obj, nil
+}
+
+// RestoreObject takes an object out of the trash, along with the objects
+// deleted with it when it is a folder. An object whose folder is still in
+// the trash, or gone, is restored to the root.
+func (s *StorageService) RestoreObject(objectID string) (*pkgstorage.StorageObject, error) {
+	obj, err := s.GetTrashedObject(objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{obj.ID}
+	if obj.IsFolder() {
+		var descendants []pkgstorage.StorageObject
+		if err := s.collectDescendants(s.db.Unscoped(), obj.ID, &descendants); err != nil {
+			return nil, err
+		}
+		// Objects deleted before the folder stay in the trash, and so does
+		// everything below them
+		restored := map[string]bool{obj.ID: true}
+		for _, child := range descendants {
+			if child.ParentFolderID != nil && restored[*child.ParentFolderID] &&
+				child.DeletedAt.Valid && child.DeletedAt.Time.Equal(obj.DeletedAt.Time) {
+				restored[child.ID] = true
+				ids = append(ids, child.ID)
+			}
+		}
+	}
+
+	if obj.ParentFolderID != nil {
+		var parents int64
+		if err := s.db.Model(&pkgstorage.StorageObject{}).
+			Where("id = ?", *obj.ParentFolderID).
+			Count(&parents).Error; err != nil {
+			return nil, err
+		}
+		if parents == 0 {
+			obj.ParentFolderID = nil
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&pkgstorage.StorageObject{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{"deleted_at": nil, "deleted_by": nil}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&pkgstorage.StorageObject{}).
+			Where("id = ?", obj.ID).
+			Update("parent_folder_id", obj.ParentFolderID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	obj.DeletedAt = gorm.DeletedAt{}
+	obj.DeletedBy = nil
+	return obj, nil
+}
+
+// PurgeObject permanently deletes an object in the trash, with its prior
+// versions and, for a folder, everything in it
+func (s *StorageService) PurgeObject(objectID string) error {
+	obj, err := s.GetTrashedObject(objectID)
+	if err != nil {
+		return err
+	}
+	return s.purgeTree(obj)
+}
+
+// EmptyTrash permanently deletes everything GetTrash lists for a user,
+// returning how many items were purged
+func (s *StorageService) EmptyTrash(userID string) (int, error) {
+	objects, err := s.GetTrash(userID)
+	if err != nil {
+		return 0, err
+	}
+	for i := range objects {
+		if err := s.purgeTree(&objects[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(objects), nil
+}
+
+// PurgeExpiredTrash permanently deletes the objects that have been in the
+// trash longer than retention, returning how many items were purged
+func (s *StorageService) PurgeExpiredTrash(retention time.Duration) (int, error) {
+	var objects []pkgstorage.StorageObject
+	if err := s.trashQuery().
+		Where("deleted_at < ?", time.Now().Add(-retention)).
+		Order("deleted_at").
+		Find(&objects).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, obj := range trashRoots(objects) {
+		// Objects purged meanwhile, along with their folder, are skipped
+		err := s.purgeTree(&obj)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// StartTrashPurge purges objects past the trash retention period now and
+// then every TrashPurgeInterval. The retention period is read from the
+// settings each time, and a period of 0 days keeps the trash until it is
+// emptied.
+func (s *StorageService) StartTrashPurge(settingsService *SettingsService) {
+	purge := func() {
+		settings, err := settingsService.GetSettings()
+		if err != nil || settings.TrashRetentionDays <= 0 {
+			return
+		}
+		retention := time.Duration(settings.TrashRetentionDays) * 24 * time.Hour
+		purged, err := s.PurgeExpiredTrash(retention)
+		if err != nil {
+			log.Printf("Failed to purge expired trash: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("Purged %d expired items from the trash", purged)
+		}
+	}
+
+	go func() {
+		purge()
+		ticker := time.NewTicker(TrashPurgeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purge()
+		}
+	}()
+}
+
+// trashQuery selects the objects in the trash
+func (s *StorageService) trashQuery() *gorm.DB {
+	return s.db.Unscoped().Where("deleted_at IS NOT NULL")
+}
+
+// purgeTree permanently deletes an object and everything below it, whether
+// or not it is in the trash
+func (s *StorageService) purgeTree(obj *pkgstorage.StorageObject) error {
+	if s.storage == nil {
+		return fmt.Errorf("storage not initialized")
+	}
+
+	objects := []pkgstorage.StorageObject{*obj}
+	if obj.IsFolder() {
+		if err := s.collectDescendants(s.db.Unscoped(), obj.ID, &objects); err != nil {
+			return err
+		}
+	}
+
+	// Delete the deepest objects first so an interrupted purge leaves no
+	// orphans behind
+	for i := len(objects) - 1; i >= 0; i-- {
+		if err := s.purgeObject(&objects[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeObject permanently deletes an object and its prior versions, telling
+// the purge hook about the space they took up
+func (s *StorageService) purgeObject(obj *pkgstorage.StorageObject) error {
+	if _, err := s.purgeObjectVersions(obj, 0); err != nil {
+		return err
+	}
+
+	storageKey := s.getStorageKey(obj)
+	deleteContent := s.deleteCurrentContent
+	if obj.IsFolder() {
+		storageKey += "/.keep"
+		deleteContent = s.storage.DeleteObject
+	}
+	// Files already gone from the provider only leave the row to delete
+	if err := deleteContent(obj.BucketName, storageKey); err != nil && !strings.Contains(err.Error(), "not found") {
+		return fmt.Errorf("failed to delete %s/%s: %w", obj.BucketName, storageKey, err)
+	}
+
+	result := s.db.Unscoped().Delete(obj)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrObjectNotFound
+	}
+
+	s.releaseSpace(obj.UserID, obj.BucketName, obj.ID, obj.Size)
+	return nil
+}
+
+// collectDescendants appends everything below a folder to objects, each
+// folder before its contents
+func (s *StorageService) collectDescendants(db *gorm.DB, folderID string, objects *[]pkgstorage.StorageObject) error {
+	parents := []string{folderID}
+	for len(parents) > 0 {
+		var children []pkgstorage.StorageObject
+		if err := db.Where("parent_folder_id IN ?", parents).Find(&children).Error; err != nil {
+			return err
+		}
+		parents = parents[:0]
+		for _, child := range children {
+			*objects = append(*objects, child)
+			if child.IsFolder() {
+				parents = append(parents, child.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// trashRoots leaves out the objects that were deleted along with their
+// folder, keeping the order of the rest
+func trashRoots(objects []pkgstorage.StorageObject) []pkgstorage.StorageObject {
+	deletedAt := make(map[string]time.Time, len(objects))
+	for _, obj := range objects {
+		deletedAt[obj.ID] = obj.DeletedAt.Time
+	}
+
+	roots := make([]pkgstorage.StorageObject, 0, len(objects))
+	for _, obj := range objects {
+		if obj.ParentFolderID != nil {
+			if parentDeletedAt, ok := deletedAt[*obj.ParentFolderID]; ok && parentDeletedAt.Equal(obj.DeletedAt.Time) {
+				continue
+			}
+		}
+		roots = append(roots, obj)
+	}
+	return roots
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uploadTestFile(t *testing.T, s *StorageService, name, content string, parentFolderID *string) string {
+	uploaded, err := s.UploadFile("int_storage", name, "user-1", bytes.NewReader([]byte(content)), int64(len(content)), "text/plain", parentFolderID)
+	require.NoError(t, err)
+	return uploaded.(map[string]interface{})["id"].(string)
+}
+
+func trashNames(t *testing.T, s *StorageService) []string {
+	objects, err := s.GetTrash("user-1")
+	require.NoError(t, err)
+	names := []string{}
+	for _, obj := range objects {
+		names = append(names, obj.ObjectName)
+	}
+	return names
+}
+
+func TestStorageTrash(t *testing.T) {
+	s, path := newTestStorageService(t)
+	released := map[string]int64{}
+	s.SetPurgeHook(func(ctx context.Context, userID, bucket, objectID string, size int64) {
+		released[userID] += size
+	})
+
+	docs, err := s.CreateFolderWithParent("int_storage", "docs", "user-1", nil)
+	require.NoError(t, err)
+	sub, err := s.CreateFolderWithParent("int_storage", "sub", "user-1", &docs)
+	require.NoError(t, err)
+	a := uploadTestFile(t, s, "a.txt", "aaa", &docs)
+	b := uploadTestFile(t, s, "b.txt", "bb", &sub)
+	c := uploadTestFile(t, s, "c.txt", "c", nil)
+
+	// A file deleted before its folder stays a separate trash item
+	require.NoError(t, s.DeleteObject("int_storage", b, "user-1"))
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, s.DeleteObject("int_storage", docs, "user-1"))
+	assert.Equal(t, []string{"docs", "b.txt"}, trashNames(t, s))
+
+	_, err = s.GetObjectInfo("int_storage", a)
+	assert.Error(t, err, "objects in the trash are hidden")
+	used, err := s.GetUserStorageUsed("user-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), used, "the trash counts until it is purged")
+	assert.Empty(t, released)
+
+	// Restoring the folder brings back what was deleted with it
+	_, err = s.RestoreObject(docs)
+	require.NoError(t, err)
+	for _, id := range []string{docs, sub, a} {
+		_, err := s.GetObjectInfo("int_storage", id)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"b.txt"}, trashNames(t, s))
+
+	restored, err := s.RestoreObject(b)
+	require.NoError(t, err)
+	assert.Equal(t, sub, *restored.ParentFolderID)
+
+	_, err = s.RestoreObject(c)
+	assert.ErrorIs(t, err, ErrObjectNotInTrash)
+
+	// An item whose folder is still in the trash is restored to the root
+	require.NoError(t, s.DeleteObject("int_storage", docs, "user-1"))
+	restored, err = s.RestoreObject(a)
+	require.NoError(t, err)
+	assert.Nil(t, restored.ParentFolderID)
+
+	// Purging the folder deletes everything in it for good
+	require.NoError(t, s.PurgeObject(docs))
+	_, err = s.GetTrashedObject(b)
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+	_, err = os.Stat(filepath.Join(path, "int_storage", b, "b.txt"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, int64(2), released["user-1"])
+
+	// Only items past the retention period are purged
+	require.NoError(t, s.DeleteObject("int_storage", c, "user-1"))
+	purged, err := s.PurgeExpiredTrash(time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+	purged, err = s.PurgeExpiredTrash(0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t, int64(3), released["user-1"])
+
+	require.NoError(t, s.DeleteObject("int_storage", a, "user-1"))
+	emptied, err := s.EmptyTrash("user-1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, emptied)
+	count, err := s.CountTrash("user-1")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	assert.Equal(t, int64(6), released["user-1"])
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/suppers-ai/solobase/storage"
+	pkgstorage "github.com/suppers-ai/storage"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUploadNotFound         = errors.New("upload not found")
+	ErrUploadExpired          = errors.New("upload expired")
+	ErrUploadChecksumMismatch = errors.New("upload checksum mismatch")
+	ErrUploadLocked           = errors.New("upload is being written by another request")
+)
+
+// ErrUploadOffsetMismatch is returned when a chunk does not start where the
+// upload's bytes end
+var ErrUploadOffsetMismatch = storage.ErrUploadOffsetMismatch
+
+// UploadExpiry is how long a resumable upload is kept after its last chunk
+const UploadExpiry = 24 * time.Hour
+
+// UploadCleanupInterval is how often expired uploads are removed
+const UploadCleanupInterval = time.Hour
+
+// UploadChecksum is the checksum a client sent along with a chunk. Hash is a
+// fresh hash of the algorithm the checksum was made with.
+type UploadChecksum struct {
+	Hash hash.Hash
+	Sum  []byte
+}
+
+// CreateUpload starts a resumable upload of a file of size bytes. The file is
+// written by WriteUpload in chunks, streamed to the storage provider, and
+// becomes an object once all its bytes are written. An empty file is stored
+// right away.
+func (s *StorageService) CreateUpload(bucket, filename, userID string, size int64, mimeType string, parentFolderID *string, metadata string) (*pkgstorage.StorageUpload, error) {
+	if s.storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("upload size cannot be negative")
+	}
+
+	objectID := uuid.New().String()
+	storageKey := fmt.Sprintf("%s/%s", objectID, filename)
+	uploadID, err := s.storage.CreateUpload(bucket, storageKey, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	hashState, err := pkgstorage.NewHashingReader(nil, -1).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	var appIDPtr *string
+	if s.appID != "" {
+		appIDPtr = &s.appID
+	}
+
+	now := time.Now()
+	upload := &pkgstorage.StorageUpload{
+		ID:             uuid.New().String(),
+		BucketName:     bucket,
+		ObjectID:       objectID,
+		ObjectName:     filename,
+		ParentFolderID: parentFolderID,
+		ContentType:    mimeType,
+		Size:           size,
+		Metadata:       metadata,
+		UploadID:       uploadID,
+		HashState:      hashState,
+		UserID:         userID,
+		AppID:          appIDPtr,
+		ExpiresAt:      now.Add(UploadExpiry),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := s.db.Create(upload).Error; err != nil {
+		s.storage.AbortUpload(bucket, storageKey, uploadID)
+		return nil, err
+	}
+
+	if size == 0 {
+		if err := s.completeUpload(upload); err != nil {
+			return nil, err
+		}
+	}
+	return upload, nil
+}
+
+// GetUpload returns a resumable upload that has not expired
+func (s *StorageService) GetUpload(id string) (*pkgstorage.StorageUpload, error) {
+	var upload pkgstorage.StorageUpload
+	if err := s.db.Where("id = ?", id).First(&upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+	return &upload, nil
+}
+
+// WriteUpload writes a chunk of a resumable upload starting at offset, which
+// must be the upload's current offset. Bytes beyond the upload's size are not
+// read. With a checksum, the chunk is only written when it matches. The bytes
+// written are kept even when reading the chunk fails part way, and the file
+// becomes an object once the last byte is written.
+func (s *StorageService) WriteUpload(id string, offset int64, reader io.Reader, checksum *UploadChecksum) (*pkgstorage.StorageUpload, error) {
+	if s.storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+	unlock, err := s.lockUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	upload, err := s.GetUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrUploadOffsetMismatch
+	}
+	reader = io.LimitReader(reader, upload.Size-upload.Offset)
+
+	// A chunk with a checksum is read in full before any of it is written
+	if checksum != nil {
+		spool, err := os.CreateTemp("", "solobase-upload-*")
+		if err != nil {
+			return upload, fmt.Errorf("failed to buffer chunk: %w", err)
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		if _, err := io.Copy(io.MultiWriter(spool, checksum.Hash), reader); err != nil {
+			return upload, fmt.Errorf("failed to read chunk: %w", err)
+		}
+		if !bytes.Equal(checksum.Hash.Sum(nil), checksum.Sum) {
+			return upload, ErrUploadChecksumMismatch
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return upload, fmt.Errorf("failed to buffer chunk: %w", err)
+		}
+		reader = spool
+	}
+
+	// Carry the hashes of the file over from the previous chunks
+	hashed, err := pkgstorage.ResumeHashingReader(reader, -1, upload.HashState)
+	hashing := err == nil
+	if !hashing {
+		hashed = pkgstorage.NewHashingReader(reader, -1)
+	}
+
+	written, writeErr := s.storage.WriteUpload(upload.BucketName, uploadKey(upload), upload.UploadID, offset, hashed)
+	if written > 0 {
+		upload.Offset += written
+		upload.HashState = nil
+		// Bytes read but not kept leave the hashes unknown
+		if hashing && hashed.Size() == written {
+			upload.HashState, _ = hashed.MarshalBinary()
+		}
+	}
+	upload.ExpiresAt = time.Now().Add(UploadExpiry)
+	upload.UpdatedAt = time.Now()
+	if err := s.db.Save(upload).Error; err != nil {
+		return upload, err
+	}
+	if writeErr != nil {
+		return upload, writeErr
+	}
+
+	if upload.Offset == upload.Size {
+		if err := s.completeUpload(upload); err != nil {
+			return upload, err
+		}
+		s.uploadLocks.Delete(id)
+	}
+	return upload, nil
+}
+
+// TerminateUpload discards a resumable upload and the bytes written
+func (s *StorageService) TerminateUpload(id string) error {
+	unlock, err := s.lockUpload(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var upload pkgstorage.StorageUpload
+	if err := s.db.Where("id = ?", id).First(&upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUploadNotFound
+		}
+		return err
+	}
+	if err := s.discardUpload(&upload); err != nil {
+		return err
+	}
+	s.uploadLocks.Delete(id)
+	return nil
+}
+
+// PurgeExpiredUploads discards the resumable and multipart uploads that have
+// expired, returning how many were removed
+func (s *StorageService) PurgeExpiredUploads() (int, error) {
+	var uploads []pkgstorage.StorageUpload
+	if err := s.db.Where("expires_at < ?", time.Now()).Find(&uploads).Error; err != nil {
+		return 0, err
+	}
+	var multipart []pkgstorage.StorageMultipartUpload
+	if err := s.db.Where("expires_at < ?", time.Now()).Find(&multipart).Error; err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for i := range multipart {
+		if err := s.discardMultipartUpload(&multipart[i]); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	for _, upload := range uploads {
+		if err := s.TerminateUpload(upload.ID); err != nil {
+			if errors.Is(err, ErrUploadLocked) || errors.Is(err, ErrUploadNotFound) {
+				// Being written again or already gone
+				continue
+			}
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// StartUploadCleanup removes expired uploads now and then every
+// UploadCleanupInterval
+func (s *StorageService) StartUploadCleanup() {
+	cleanup := func() {
+		removed, err := s.PurgeExpiredUploads()
+		if err != nil {
+			log.Printf("Failed to remove expired uploads: %v", err)
+		}
+		if removed > 0 {
+			log.Printf("Removed %d expired uploads", removed)
+		}
+	}
+
+	go func() {
+		cleanup()
+		ticker := time.NewTicker(UploadCleanupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			cleanup()
+		}
+	}()
+}
+
+// completeUpload makes a fully written upload's bytes the content of its
+// object
+func (s *StorageService) completeUpload(upload *pkgstorage.StorageUpload) error {
+	storageKey := uploadKey(upload)
+	if err := s.storage.CompleteUpload(upload.BucketName, storageKey, upload.UploadID); err != nil {
+		return err
+	}
+
+	checksum, sha256 := "", ""
+	if hashed, err := pkgstorage.ResumeHashingReader(nil, -1, upload.HashState); err == nil {
+		checksum, sha256 = hashed.ETag(), hashed.SHA256()
+	}
+
+	now := time.Now()
+	obj := &pkgstorage.StorageObject{
+		ID:             upload.ObjectID,
+		BucketName:     upload.BucketName,
+		ObjectName:     upload.ObjectName,
+		ParentFolderID: upload.ParentFolderID,
+		Size:           upload.Size,
+		ContentType:    upload.ContentType,
+		Checksum:       checksum,
+		SHA256:         sha256,
+		UserID:         upload.UserID,
+		AppID:          upload.AppID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(obj).Error; err != nil {
+			return err
+		}
+		return tx.Delete(upload).Error
+	})
+	if err != nil {
+		// Try to rollback storage upload
+		s.storage.DeleteObject(upload.BucketName, storageKey)
+		return err
+	}
+	return nil
+}
+
+// discardUpload aborts an upload in the storage provider and forgets it
+func (s *StorageService) discardUpload(upload *pkgstorage.StorageUpload) error {
+	if s.storage == nil {
+		return fmt.Errorf("storage not initialized")
+	}
+	if err := s.storage.AbortUpload(upload.BucketName, uploadKey(upload), upload.UploadID); err != nil {
+		return err
+	}
+	return s.db.Delete(upload).Error
+}
+
+// lockUpload claims an upload for the calling request, failing with
+// ErrUploadLocked while another request has it
+func (s *StorageService) lockUpload(id string) (func(), error) {
+	lock, _ := s.uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, ErrUploadLocked
+	}
+	return mu.Unlock, nil
+}
+
+// uploadKey returns the storage key a resumable upload is written to, the one
+// its object will have
+func uploadKey(upload *pkgstorage.StorageUpload) string {
+	return fmt.Sprintf("%s/%s", upload.ObjectID, upload.ObjectName)
+}
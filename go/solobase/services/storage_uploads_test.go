@@ -0,0 +1,124 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pkgstorage "github.com/suppers-ai/storage"
+)
+
+func TestStorageResumableUpload(t *testing.T) {
+	s, path := newTestStorageService(t)
+	content := "hello resumable world"
+
+	upload, err := s.CreateUpload("int_storage", "big.txt", "user-1", int64(len(content)), "text/plain", nil, "")
+	require.NoError(t, err)
+
+	written, err := s.WriteUpload(upload.ID, 0, strings.NewReader(content[:5]), nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), written.Offset)
+
+	// A chunk has to start where the last one ended
+	_, err = s.WriteUpload(upload.ID, 3, strings.NewReader(content[3:]), nil)
+	assert.ErrorIs(t, err, ErrUploadOffsetMismatch)
+
+	// A chunk that does not match its checksum is not written
+	bad := sha256.Sum256([]byte("something else"))
+	_, err = s.WriteUpload(upload.ID, 5, strings.NewReader(content[5:10]), &UploadChecksum{Hash: sha256.New(), Sum: bad[:]})
+	assert.ErrorIs(t, err, ErrUploadChecksumMismatch)
+	resumed, err := s.GetUpload(upload.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), resumed.Offset)
+
+	good := sha256.Sum256([]byte(content[5:10]))
+	written, err = s.WriteUpload(upload.ID, 5, strings.NewReader(content[5:10]), &UploadChecksum{Hash: sha256.New(), Sum: good[:]})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), written.Offset)
+
+	// Bytes past the declared length are ignored, and the last byte makes the
+	// file an object
+	written, err = s.WriteUpload(upload.ID, 10, strings.NewReader(content[10:]+"extra"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), written.Offset)
+
+	_, err = s.GetUpload(upload.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+	obj, err := s.GetObjectInfo("int_storage", upload.ObjectID)
+	require.NoError(t, err)
+	sum := md5.Sum([]byte(content))
+	assert.Equal(t, hex.EncodeToString(sum[:]), obj.Checksum)
+	sha := sha256.Sum256([]byte(content))
+	assert.Equal(t, hex.EncodeToString(sha[:]), obj.SHA256)
+	assert.Equal(t, int64(len(content)), obj.Size)
+	stored, err := os.ReadFile(filepath.Join(path, "int_storage", upload.ObjectID, "big.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, content, string(stored))
+
+	// An empty file is stored right away
+	empty, err := s.CreateUpload("int_storage", "empty.txt", "user-1", 0, "text/plain", nil, "")
+	require.NoError(t, err)
+	_, err = s.GetObjectInfo("int_storage", empty.ObjectID)
+	assert.NoError(t, err)
+}
+
+func TestStorageUploadExpiry(t *testing.T) {
+	s, path := newTestStorageService(t)
+
+	upload, err := s.CreateUpload("int_storage", "stale.txt", "user-1", 10, "text/plain", nil, "")
+	require.NoError(t, err)
+	_, err = s.WriteUpload(upload.ID, 0, bytes.NewReader([]byte("abc")), nil)
+	require.NoError(t, err)
+	kept, err := s.CreateUpload("int_storage", "fresh.txt", "user-1", 10, "text/plain", nil, "")
+	require.NoError(t, err)
+
+	require.NoError(t, s.db.Model(upload).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	_, err = s.GetUpload(upload.ID)
+	assert.ErrorIs(t, err, ErrUploadExpired)
+	_, err = s.WriteUpload(upload.ID, 3, bytes.NewReader([]byte("def")), nil)
+	assert.ErrorIs(t, err, ErrUploadExpired)
+
+	removed, err := s.PurgeExpiredUploads()
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	_, err = s.GetUpload(upload.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+	_, err = s.GetUpload(kept.ID)
+	assert.NoError(t, err)
+	entries, err := os.ReadDir(filepath.Join(path, ".uploads", "int_storage"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, s.TerminateUpload(kept.ID))
+	_, err = s.GetUpload(kept.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+}
+
+func TestStorageUploadFileStreams(t *testing.T) {
+	s, _ := newTestStorageService(t)
+	content := "streamed without a known size"
+
+	// The size and hashes are worked out as the content streams
+	uploaded, err := s.UploadFile("int_storage", "notes.txt", "user-1", strings.NewReader(content), -1, "text/plain", nil)
+	require.NoError(t, err)
+	obj, err := s.GetObjectInfo("int_storage", uploaded.(map[string]interface{})["id"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), obj.Size)
+	sha := sha256.Sum256([]byte(content))
+	assert.Equal(t, hex.EncodeToString(sha[:]), obj.SHA256)
+
+	// Content that does not match its declared size is not stored
+	_, err = s.UploadFile("int_storage", "short.txt", "user-1", strings.NewReader(content), 100, "text/plain", nil)
+	assert.ErrorIs(t, err, pkgstorage.ErrSizeMismatch)
+	used, err := s.GetUserStorageUsed("user-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), used)
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/suppers-ai/solobase/storage"
+	pkgstorage "github.com/suppers-ai/storage"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrObjectNotFound        = errors.New("object not found")
+	ErrObjectVersionNotFound = errors.New("object version not found")
+	ErrObjectIsFolder        = errors.New("folders have no content")
+)
+
+// ErrVersioningNotSupported is returned when the storage provider cannot keep
+// prior versions of files
+var ErrVersioningNotSupported = storage.ErrVersioningNotSupported
+
+// UpdateBucketVersioning enables or suspends versioning for a bucket and sets
+// how many prior versions are kept per file, 0 keeping all of them. Versions
+// kept while versioning was enabled stay until they are purged.
+func (s *StorageService) UpdateBucketVersioning(name string, enabled bool, maxVersions int) (*pkgstorage.StorageBucket, error) {
+	if s.storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+	if maxVersions < 0 {
+		return nil, fmt.Errorf("max versions cannot be negative")
+	}
+
+	bucket, err := s.GetBucket(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.storage.SetBucketVersioning(name, enabled); err != nil {
+		return nil, err
+	}
+
+	bucket.Versioning = enabled
+	bucket.MaxVersions = maxVersions
+	bucket.UpdatedAt = time.Now()
+	if err := s.db.Save(bucket).Error; err != nil {
+		return nil, err
+	}
+
+	// Apply a lower limit to the versions already kept
+	if maxVersions > 0 {
+		var objects []pkgstorage.StorageObject
+		if err := s.db.Where("bucket_name = ?", name).Find(&objects).Error; err != nil {
+			return nil, err
+		}
+		for i := range objects {
+			if _, err := s.purgeObjectVersions(&objects[i], maxVersions); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return bucket, nil
+}
+
+// ReplaceObjectContent overwrites the content of a file. In a bucket with
+// versioning enabled the prior content is kept as a version, and the oldest
+// versions beyond the bucket's limit are purged.
+func (s *StorageService) ReplaceObjectContent(bucket, objectID string, reader io.Reader, size int64, mimeType string) (*pkgstorage.StorageObject, error) {
+	if s.storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+
+	obj, err := s.findObject(bucket, objectID)
+	if err != nil {
+		return nil, err
+	}
+	if obj.IsFolder() {
+		return nil, ErrObjectIsFolder
+	}
+
+	if mimeType == "" {
+		mimeType = obj.ContentType
+	}
+
+	// Remember the version being replaced
+	record, err := s.GetBucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	storageKey := s.getStorageKey(obj)
+	priorVersionID := ""
+	if info, err := s.storage.GetObjectInfo(bucket, storageKey); err == nil {
+		priorVersionID = info.VersionID
+	}
+
+	// Hash the content as it streams to the provider
+	hashed := pkgstorage.NewHashingReader(reader, size)
+	if err := s.storage.PutObject(bucket, storageKey, hashed, size, mimeType); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	keepsPrior := pkgstorage.KeepsPriorVersion(record.Versioning, priorVersionID)
+	priorSize := obj.Size
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if keepsPrior {
+			if priorVersionID == "" {
+				priorVersionID = pkgstorage.NullVersionID
+			}
+			version := &pkgstorage.StorageObjectVersion{
+				ID:          uuid.New().String(),
+				ObjectID:    obj.ID,
+				VersionID:   priorVersionID,
+				StorageKey:  storageKey,
+				ObjectName:  obj.ObjectName,
+				Size:        obj.Size,
+				ContentType: obj.ContentType,
+				Checksum:    obj.Checksum,
+				SHA256:      obj.SHA256,
+				UserID:      obj.UserID,
+				CreatedAt:   obj.UpdatedAt,
+				ReplacedAt:  now,
+			}
+			if err := tx.Create(version).Error; err != nil {
+				return err
+			}
+		}
+
+		obj.Size = hashed.Size()
+		obj.ContentType = mimeType
+		obj.Checksum = hashed.ETag()
+		obj.SHA256 = hashed.SHA256()
+		obj.UpdatedAt = now
+		return tx.Save(obj).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !keepsPrior {
+		s.releaseSpace(obj.UserID, bucket, obj.ID, priorSize)
+	}
+
+	if record.MaxVersions > 0 {
+		if _, err := s.purgeObjectVersions(obj, record.MaxVersions); err != nil {
+			return nil, err
+		}
+	}
+
+	return obj, nil
+}
+
+// ListObjectVersions lists the prior versions of a file, newest first
+func (s *StorageService) ListObjectVersions(bucket, objectID string) ([]pkgstorage.StorageObjectVersion, error) {
+	if _, err := s.findObject(bucket, objectID); err != nil {
+		return nil, err
+	}
+
+	var versions []pkgstorage.StorageObjectVersion
+	if err := s.db.Where("object_id = ?", objectID).Order("replaced_at DESC").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// GetObjectVersion returns the content of a prior version of a file
+func (s *StorageService) GetObjectVersion(bucket, objectID, versionID string) (io.ReadCloser, *pkgstorage.StorageObjectVersion, error) {
+	if s.storage == nil {
+		return nil, nil, fmt.Errorf("storage not initialized")
+	}
+
+	version, err := s.findObjectVersion(bucket, objectID, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := s.storage.GetObjectVersion(bucket, version.StorageKey, version.VersionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader, version, nil
+}
+
+// RestoreObjectVersion makes a prior version the current content of a file.
+// The content it replaces is kept as a version like any other overwrite.
+func (s *StorageService) RestoreObjectVersion(bucket, objectID, versionID string) (*pkgstorage.StorageObject, error) {
+	reader, version, err := s.GetObjectVersion(bucket, objectID, versionID)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return s.ReplaceObjectContent(bucket, objectID, reader, version.Size, version.ContentType)
+}
+
+// DeleteObjectVersion permanently deletes a prior version of a file
+func (s *StorageService) DeleteObjectVersion(bucket, objectID, versionID string) error {
+	version, err := s.findObjectVersion(bucket, objectID, versionID)
+	if err != nil {
+		return err
+	}
+	return s.deleteObjectVersion(bucket, version)
+}
+
+// PurgeObjectVersions permanently deletes the prior versions of a file other
+// than the newest keep, returning how many were deleted
+func (s *StorageService) PurgeObjectVersions(bucket, objectID string, keep int) (int, error) {
+	if keep < 0 {
+		return 0, fmt.Errorf("keep cannot be negative")
+	}
+	obj, err := s.findObject(bucket, objectID)
+	if err != nil {
+		return 0, err
+	}
+	return s.purgeObjectVersions(obj, keep)
+}
+
+func (s *StorageService) purgeObjectVersions(obj *pkgstorage.StorageObject, keep int) (int, error) {
+	versions, err := pkgstorage.ObjectVersionsBeyond(s.db.DB, obj.ID, keep)
+	if err != nil {
+		return 0, err
+	}
+	for i := range versions {
+		if err := s.deleteObjectVersion(obj.BucketName, &versions[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(versions), nil
+}
+
+func (s *StorageService) deleteObjectVersion(bucket string, version *pkgstorage.StorageObjectVersion) error {
+	// Versions already gone from the provider only leave the row to delete
+	err := s.storage.DeleteObjectVersion(bucket, version.StorageKey, version.VersionID)
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		return fmt.Errorf("failed to delete object version: %w", err)
+	}
+	if err := s.db.Delete(version).Error; err != nil {
+		return err
+	}
+	s.releaseSpace(version.UserID, bucket, version.ObjectID, version.Size)
+	return nil
+}
+
+// deleteCurrentContent deletes the content of a file for good, where
+// DeleteObject would keep it as a version in a versioned bucket
+func (s *StorageService) deleteCurrentContent(bucket, storageKey string) error {
+	if info, err := s.storage.GetObjectInfo(bucket, storageKey); err == nil {
+		versionID := info.VersionID
+		if record, err := s.GetBucket(bucket); err == nil && record.Versioning && versionID == "" {
+			versionID = pkgstorage.NullVersionID
+		}
+		if versionID != "" {
+			err := s.storage.DeleteObjectVersion(bucket, storageKey, versionID)
+			if !errors.Is(err, ErrVersioningNotSupported) {
+				return err
+			}
+		}
+	}
+	return s.storage.DeleteObject(bucket, storageKey)
+}
+
+func (s *StorageService) findObject(bucket, objectID string) (*pkgstorage.StorageObject, error) {
+	var obj pkgstorage.StorageObject
+	if err := s.db.Where("id = ? AND bucket_name = ?", objectID, bucket).First(&obj).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func (s *StorageService) findObjectVersion(bucket, objectID, versionID string) (*pkgstorage.StorageObjectVersion, error) {
+	if _, err := s.findObject(bucket, objectID); err != nil {
+		return nil, err
+	}
+
+	var version pkgstorage.StorageObjectVersion
+	if err := s.db.Where("id = ? AND object_id = ?", versionID, objectID).First(&version).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrObjectVersionNotFound
+		}
+		return nil, err
+	}
+	return &version, nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suppers-ai/solobase/config"
+	pkgstorage "github.com/suppers-ai/storage"
+)
+
+func newTestStorageService(t *testing.T) (*StorageService, string) {
+	db := newTestDB(t)
+	require.NoError(t, db.AutoMigrate(&pkgstorage.StorageObject{}, &pkgstorage.StorageBucket{}, &pkgstorage.StorageObjectVersion{}, &pkgstorage.StorageUpload{}, &pkgstorage.StorageMultipartUpload{}, &pkgstorage.StorageMultipartPart{}))
+	path := t.TempDir()
+	return NewStorageService(db, config.StorageConfig{Type: "local", LocalStoragePath: path}), path
+}
+
+func replaceContent(t *testing.T, s *StorageService, objectID, content string) {
+	_, err := s.ReplaceObjectContent("int_storage", objectID, bytes.NewReader([]byte(content)), int64(len(content)), "text/plain")
+	require.NoError(t, err)
+}
+
+func readVersion(t *testing.T, s *StorageService, objectID, versionID string) string {
+	reader, _, err := s.GetObjectVersion("int_storage", objectID, versionID)
+	require.NoError(t, err)
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func readCurrent(t *testing.T, s *StorageService, objectID string) string {
+	reader, _, _, err := s.GetObject("int_storage", objectID)
+	require.NoError(t, err)
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestStorageObjectVersioning(t *testing.T) {
+	s, path := newTestStorageService(t)
+
+	uploaded, err := s.UploadFileBytes("int_storage", "notes.txt", "user-1", []byte("v1"), "text/plain")
+	require.NoError(t, err)
+	objectID := uploaded.(map[string]interface{})["id"].(string)
+
+	// Without versioning the content is overwritten in place
+	replaceContent(t, s, objectID, "v2")
+	versions, err := s.ListObjectVersions("int_storage", objectID)
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+
+	bucket, err := s.UpdateBucketVersioning("int_storage", true, 2)
+	require.NoError(t, err)
+	assert.True(t, bucket.Versioning)
+
+	replaceContent(t, s, objectID, "v3")
+	replaceContent(t, s, objectID, "v4")
+	replaceContent(t, s, objectID, "v5")
+	assert.Equal(t, "v5", readCurrent(t, s, objectID))
+
+	// Only the newest two prior versions are kept
+	versions, err = s.ListObjectVersions("int_storage", objectID)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "v4", readVersion(t, s, objectID, versions[0].ID))
+	assert.Equal(t, "v3", readVersion(t, s, objectID, versions[1].ID))
+	assert.Equal(t, int64(2), versions[1].Size)
+
+	// Restoring keeps the replaced content as a version too
+	restored, err := s.RestoreObjectVersion("int_storage", objectID, versions[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "v3", readCurrent(t, s, objectID))
+	versions, err = s.ListObjectVersions("int_storage", objectID)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "v5", readVersion(t, s, objectID, versions[0].ID))
+	assert.NotEqual(t, restored.Checksum, versions[0].Checksum)
+
+	purged, err := s.PurgeObjectVersions("int_storage", objectID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	versions, err = s.ListObjectVersions("int_storage", objectID)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+
+	_, _, err = s.GetObjectVersion("int_storage", objectID, "missing")
+	assert.ErrorIs(t, err, ErrObjectVersionNotFound)
+
+	// Deleting the file keeps its versions in the trash, purging it leaves
+	// none behind
+	require.NoError(t, s.DeleteObject("int_storage", objectID, "user-1"))
+	var count int64
+	s.db.Model(&pkgstorage.StorageObjectVersion{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+	require.NoError(t, s.PurgeObject(objectID))
+	s.db.Model(&pkgstorage.StorageObjectVersion{}).Count(&count)
+	assert.Zero(t, count)
+	_, err = os.Stat(filepath.Join(path, ".versions", "int_storage", objectID))
+	assert.True(t, os.IsNotExist(err), "prior versions are removed from the provider")
+	_, err = os.Stat(filepath.Join(path, "int_storage", objectID, "notes.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
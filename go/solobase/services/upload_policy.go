@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/suppers-ai/solobase/models"
+)
+
+var (
+	// ErrFileTooLarge is returned for uploads over the maximum upload size
+	ErrFileTooLarge = errors.New("file exceeds the maximum upload size")
+	// ErrFileTypeNotAllowed is returned for uploads whose type is not in the allowed file types
+	ErrFileTypeNotAllowed = errors.New("file type is not allowed")
+)
+
+// UploadPolicy limits the files users can upload
+type UploadPolicy struct {
+	// MaxSize is the largest upload in bytes, 0 for no limit
+	MaxSize int64
+	// AllowedTypes are MIME types, wildcards such as "image/*" or file
+	// extensions such as ".pdf". An empty list allows every type.
+	AllowedTypes []string
+}
+
+// UploadPolicyFromSettings builds the policy from the app settings
+func UploadPolicyFromSettings(settings *models.AppSettings) UploadPolicy {
+	policy := UploadPolicy{MaxSize: settings.MaxUploadSize}
+	if policy.MaxSize < 0 {
+		policy.MaxSize = 0
+	}
+	for _, allowed := range strings.Split(settings.AllowedFileTypes, ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == "*" || allowed == "*/*" {
+			policy.AllowedTypes = nil
+			break
+		}
+		if allowed != "" {
+			policy.AllowedTypes = append(policy.AllowedTypes, allowed)
+		}
+	}
+	return policy
+}
+
+// CheckSize rejects uploads over the maximum size
+func (p UploadPolicy) CheckSize(size int64) error {
+	if p.MaxSize > 0 && size > p.MaxSize {
+		return ErrFileTooLarge
+	}
+	return nil
+}
+
+// CheckType rejects files whose type is not allowed. Browsers send
+// application/octet-stream for types they do not know, so the type is then
+// taken from the file extension.
+func (p UploadPolicy) CheckType(filename, contentType string) error {
+	if len(p.AllowedTypes) == 0 {
+		return nil
+	}
+
+	ext := strings.ToLower(path.Ext(filename))
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		if byExtension, _, err := mime.ParseMediaType(mime.TypeByExtension(ext)); err == nil {
+			mediaType = byExtension
+		}
+	}
+	mediaType = strings.ToLower(mediaType)
+
+	for _, allowed := range p.AllowedTypes {
+		switch {
+		case strings.HasPrefix(allowed, "."):
+			if ext == allowed {
+				return nil
+			}
+		case strings.HasSuffix(allowed, "/*"):
+			if mediaType != "" && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+				return nil
+			}
+		case mediaType == allowed:
+			return nil
+		}
+	}
+	return ErrFileTypeNotAllowed
+}
+
+// Check rejects uploads the policy does not allow
+func (p UploadPolicy) Check(filename, contentType string, size int64) error {
+	if err := p.CheckSize(size); err != nil {
+		return err
+	}
+	return p.CheckType(filename, contentType)
+}
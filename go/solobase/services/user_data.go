@@ -0,0 +1,603 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/logger"
+	"github.com/suppers-ai/solobase/database"
+	"github.com/suppers-ai/solobase/models"
+	pkgstorage "github.com/suppers-ai/storage"
+	"gorm.io/gorm"
+)
+
+// UserDataExportExpiry is how long an export archive is kept for download
+const UserDataExportExpiry = 7 * 24 * time.Hour
+
+// UserDataExportPurgeInterval is how often expired export archives are deleted
+const UserDataExportPurgeInterval = time.Hour
+
+var (
+	// ErrUserDataJobNotFound is returned for unknown data export and erasure jobs
+	ErrUserDataJobNotFound = errors.New("user data job not found")
+	// ErrExportNotReady is returned when downloading an export that has not completed
+	ErrExportNotReady = errors.New("export is not ready")
+	// ErrExportExpired is returned when downloading an export whose archive
+	// has expired or was erased with the user
+	ErrExportExpired = errors.New("export is no longer available")
+)
+
+// UserDataArchive is the ZIP archive a data export is written to. It is safe
+// for concurrent use.
+type UserDataArchive struct {
+	mu     *sync.Mutex
+	zip    *zip.Writer
+	prefix string
+}
+
+// NewUserDataArchive writes an archive to zw
+func NewUserDataArchive(zw *zip.Writer) *UserDataArchive {
+	return &UserDataArchive{mu: &sync.Mutex{}, zip: zw}
+}
+
+// Dir returns the archive with the names of added files placed under dir
+func (a *UserDataArchive) Dir(dir string) *UserDataArchive {
+	return &UserDataArchive{mu: a.mu, zip: a.zip, prefix: a.prefix + archiveName(dir) + "/"}
+}
+
+// AddJSON adds a value as an indented JSON file
+func (a *UserDataArchive) AddJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return a.AddFile(name, bytes.NewReader(data))
+}
+
+// AddFile copies a file into the archive
+func (a *UserDataArchive) AddFile(name string, r io.Reader) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	w, err := a.zip.Create(a.prefix + archiveName(name))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	return nil
+}
+
+// archiveName keeps names inside the archive
+func archiveName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// UserDataHook exports or erases the data extensions keep about a user. The
+// archive is nil when erasing.
+type UserDataHook func(ctx context.Context, userID string, archive *UserDataArchive) error
+
+// UserDataService answers subject access and deletion requests. Exports
+// collect everything tied to a user into a ZIP archive in a directory kept
+// apart from storage, so it is never served as a file; erasures delete the
+// user's data, or anonymize it where rows must stay, such as request logs.
+type UserDataService struct {
+	db        *database.DB
+	storage   *StorageService
+	exportDir string
+	hook      UserDataHook
+}
+
+// NewUserDataService creates the service. Export archives are written to
+// exportDir.
+func NewUserDataService(db *database.DB, storage *StorageService, exportDir string) *UserDataService {
+	return &UserDataService{db: db, storage: storage, exportDir: exportDir}
+}
+
+// SetExtensionHook sets the hook extensions join exports and erasures through
+func (s *UserDataService) SetExtensionHook(hook UserDataHook) {
+	s.hook = hook
+}
+
+// StartExport queues an export of the user's data and runs it in the background
+func (s *UserDataService) StartExport(userID, requestedBy string) (*models.UserDataJob, error) {
+	return s.start(userID, models.UserDataJobExport, requestedBy)
+}
+
+// StartErasure queues the erasure of the user's data and runs it in the background
+func (s *UserDataService) StartErasure(userID, requestedBy string) (*models.UserDataJob, error) {
+	return s.start(userID, models.UserDataJobErase, requestedBy)
+}
+
+func (s *UserDataService) start(userID, kind, requestedBy string) (*models.UserDataJob, error) {
+	job, created, err := s.createJob(userID, kind, requestedBy)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		go s.runJob(context.Background(), job.ID)
+	}
+	return job, nil
+}
+
+// createJob queues a job. A job of the same kind that has not finished yet
+// is returned instead of queueing another.
+func (s *UserDataService) createJob(userID, kind, requestedBy string) (*models.UserDataJob, bool, error) {
+	var user auth.User
+	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, false, err
+	}
+
+	var existing models.UserDataJob
+	err := s.db.Where("user_id = ? AND kind = ? AND status IN ?", userID, kind,
+		[]string{models.UserDataJobPending, models.UserDataJobRunning}).First(&existing).Error
+	if err == nil {
+		return &existing, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	job := &models.UserDataJob{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Kind:        kind,
+		Status:      models.UserDataJobPending,
+		RequestedBy: requestedBy,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.db.Create(job).Error; err != nil {
+		return nil, false, err
+	}
+	return job, true, nil
+}
+
+// GetJob returns a job by ID
+func (s *UserDataService) GetJob(id string) (*models.UserDataJob, error) {
+	var job models.UserDataJob
+	err := s.db.Where("id = ?", id).First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserDataJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListJobs returns the jobs for a user, newest first
+func (s *UserDataService) ListJobs(userID string) ([]models.UserDataJob, error) {
+	var jobs []models.UserDataJob
+	if err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// OpenExport opens the archive of a completed export
+func (s *UserDataService) OpenExport(job *models.UserDataJob) (io.ReadCloser, string, error) {
+	if job.Kind != models.UserDataJobExport || job.Status != models.UserDataJobCompleted {
+		return nil, "", ErrExportNotReady
+	}
+	if job.Archive == "" || (job.ExpiresAt != nil && job.ExpiresAt.Before(time.Now())) {
+		return nil, "", ErrExportExpired
+	}
+	file, err := os.Open(filepath.Join(s.exportDir, job.Archive))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", ErrExportExpired
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	filename := fmt.Sprintf("user-data-%s-%s.zip", job.UserID, job.CreatedAt.UTC().Format("20060102-150405"))
+	return file, filename, nil
+}
+
+// PurgeExpiredExports deletes the archives of exports past their expiry
+func (s *UserDataService) PurgeExpiredExports() (int, error) {
+	var jobs []models.UserDataJob
+	if err := s.db.Where("kind = ? AND archive <> '' AND expires_at < ?", models.UserDataJobExport, time.Now()).
+		Find(&jobs).Error; err != nil {
+		return 0, err
+	}
+	for i, job := range jobs {
+		if err := s.deleteArchive(&job); err != nil {
+			return i, err
+		}
+	}
+	return len(jobs), nil
+}
+
+// StartExportPurge deletes expired export archives now and then every
+// UserDataExportPurgeInterval in the background
+func (s *UserDataService) StartExportPurge() {
+	purge := func() {
+		purged, err := s.PurgeExpiredExports()
+		if err != nil {
+			log.Printf("Failed to delete expired data exports: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("Deleted %d expired data exports", purged)
+		}
+	}
+
+	go func() {
+		purge()
+		ticker := time.NewTicker(UserDataExportPurgeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purge()
+		}
+	}()
+}
+
+// deleteArchive removes an export's archive and forgets it on the job
+func (s *UserDataService) deleteArchive(job *models.UserDataJob) error {
+	if err := os.Remove(filepath.Join(s.exportDir, job.Archive)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return s.db.Model(&models.UserDataJob{}).Where("id = ?", job.ID).Update("archive", "").Error
+}
+
+// ResumeJobs runs the jobs that were queued or interrupted when the server
+// stopped. Jobs can run again safely: exports are rebuilt from the start and
+// erasures delete whatever is left.
+func (s *UserDataService) ResumeJobs() error {
+	if err := s.db.Model(&models.UserDataJob{}).
+		Where("status = ?", models.UserDataJobRunning).
+		Update("status", models.UserDataJobPending).Error; err != nil {
+		return err
+	}
+
+	var jobs []models.UserDataJob
+	if err := s.db.Where("status = ?", models.UserDataJobPending).Find(&jobs).Error; err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		go s.runJob(context.Background(), job.ID)
+	}
+	return nil
+}
+
+// runJob claims a pending job, runs it and records the outcome
+func (s *UserDataService) runJob(ctx context.Context, jobID string) {
+	now := time.Now()
+	result := s.db.Model(&models.UserDataJob{}).
+		Where("id = ? AND status = ?", jobID, models.UserDataJobPending).
+		Updates(map[string]interface{}{"status": models.UserDataJobRunning, "started_at": now})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	job, err := s.GetJob(jobID)
+	if err != nil {
+		log.Printf("Failed to load user data job %s: %v", jobID, err)
+		return
+	}
+
+	switch job.Kind {
+	case models.UserDataJobExport:
+		err = s.export(ctx, job)
+	case models.UserDataJobErase:
+		err = s.erase(ctx, job.UserID)
+	default:
+		err = fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+
+	updates := map[string]interface{}{
+		"status":       models.UserDataJobCompleted,
+		"archive":      job.Archive,
+		"size":         job.Size,
+		"expires_at":   job.ExpiresAt,
+		"completed_at": time.Now(),
+	}
+	if err != nil {
+		log.Printf("User data %s job %s for user %s failed: %v", job.Kind, job.ID, job.UserID, err)
+		updates["status"] = models.UserDataJobFailed
+		updates["error"] = err.Error()
+	}
+	if err := s.db.Model(&models.UserDataJob{}).Where("id = ?", job.ID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to save user data job %s: %v", job.ID, err)
+	}
+}
+
+// export writes the user's data to a temporary file in the export directory
+// and renames it to the job's archive once complete
+func (s *UserDataService) export(ctx context.Context, job *models.UserDataJob) error {
+	if err := os.MkdirAll(s.exportDir, 0700); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+	file, err := os.CreateTemp(s.exportDir, "export-*.zip.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	if err := s.collect(ctx, job.UserID, NewUserDataArchive(zw)); err != nil {
+		zw.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	archive := job.ID + ".zip"
+	if err := os.Rename(file.Name(), filepath.Join(s.exportDir, archive)); err != nil {
+		return fmt.Errorf("failed to store export: %w", err)
+	}
+	expiresAt := time.Now().Add(UserDataExportExpiry)
+	job.Archive = archive
+	job.Size = size
+	job.ExpiresAt = &expiresAt
+	return nil
+}
+
+// analyticsTables hold the page views and events tracked for users
+var analyticsTables = []string{"page_views", "analytics_events"}
+
+// exportedRequestLog is a request log without its headers and bodies, which
+// can hold credentials
+type exportedRequestLog struct {
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	StatusCode   int       `json:"status_code"`
+	UserIP       string    `json:"user_ip"`
+	UserAgent    *string   `json:"user_agent,omitempty"`
+	Impersonated bool      `json:"impersonated"` // Made by an admin acting as the user
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// collect adds everything tied to the user to the archive
+func (s *UserDataService) collect(ctx context.Context, userID string, archive *UserDataArchive) error {
+	var user auth.User
+	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		return err
+	}
+	if err := archive.AddJSON("user.json", user); err != nil {
+		return err
+	}
+
+	// Sessions are exported without their tokens
+	var sessions []auth.Session
+	if err := s.db.Where("user_id = ?", userID).Order("created_at").Find(&sessions).Error; err != nil {
+		return err
+	}
+	infos := make([]SessionInfo, 0, len(sessions))
+	for i := range sessions {
+		infos = append(infos, newSessionInfo(&sessions[i], ""))
+	}
+	if err := archive.AddJSON("sessions.json", infos); err != nil {
+		return err
+	}
+
+	tables := []struct {
+		name  string
+		rows  interface{}
+		where string
+		arg   interface{}
+	}{
+		{"api_keys.json", &[]models.APIKey{}, "user_id = ?", userID},
+		{"s3_credentials.json", &[]models.S3Credential{}, "user_id = ?", userID},
+		{"oauth_identities.json", &[]models.OAuthIdentity{}, "user_id = ?", userID},
+		{"passkeys.json", &[]models.WebAuthnCredential{}, "user_id = ?", userID},
+		{"role_bindings.json", &[]models.RoleBinding{}, "user_id = ?", userID},
+		{"organization_memberships.json", &[]models.OrganizationMember{}, "user_id = ?", userID},
+		{"organization_invites.json", &[]models.OrganizationInvite{}, "LOWER(email) = ?", strings.ToLower(user.Email)},
+		{"collection_records.json", &[]models.CollectionRecord{}, "user_id = ?", userID},
+		{"logs.json", &[]logger.LogModel{}, "user_id = ?", userID},
+	}
+	for _, table := range tables {
+		if err := s.db.Where(table.where, table.arg).Order("created_at").Find(table.rows).Error; err != nil {
+			return fmt.Errorf("failed to read %s: %w", strings.TrimSuffix(table.name, ".json"), err)
+		}
+		if err := archive.AddJSON(table.name, table.rows); err != nil {
+			return err
+		}
+	}
+
+	var requestLogs []logger.RequestLogModel
+	if err := s.db.Where("user_id = ?", userID).Order("created_at").Find(&requestLogs).Error; err != nil {
+		return fmt.Errorf("failed to read request logs: %w", err)
+	}
+	exported := make([]exportedRequestLog, 0, len(requestLogs))
+	for _, entry := range requestLogs {
+		exported = append(exported, exportedRequestLog{
+			Method:       entry.Method,
+			Path:         entry.Path,
+			StatusCode:   entry.StatusCode,
+			UserIP:       entry.UserIP,
+			UserAgent:    entry.UserAgent,
+			Impersonated: entry.ImpersonatorID != nil,
+			CreatedAt:    entry.CreatedAt,
+		})
+	}
+	if err := archive.AddJSON("request_logs.json", exported); err != nil {
+		return err
+	}
+
+	// Analytics tables are created by the analytics API when it is mounted
+	for _, table := range analyticsTables {
+		if !s.db.Migrator().HasTable(table) {
+			continue
+		}
+		var rows []map[string]interface{}
+		if err := s.db.Table(table).Where("user_id = ?", userID).Order("created_at").Find(&rows).Error; err != nil {
+			return fmt.Errorf("failed to read %s: %w", table, err)
+		}
+		if err := archive.AddJSON(path.Join("analytics", table+".json"), rows); err != nil {
+			return err
+		}
+	}
+
+	if err := s.collectStorage(userID, archive.Dir("storage")); err != nil {
+		return err
+	}
+
+	if s.hook != nil {
+		if err := s.hook(ctx, userID, archive.Dir("extensions")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectStorage adds the user's storage objects and their files
+func (s *UserDataService) collectStorage(userID string, archive *UserDataArchive) error {
+	var objects []pkgstorage.StorageObject
+	if err := s.db.Where("user_id = ?", userID).Order("created_at").Find(&objects).Error; err != nil {
+		return fmt.Errorf("failed to read storage objects: %w", err)
+	}
+	if err := archive.AddJSON("objects.json", objects); err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		if obj.ContentType == "application/x-directory" {
+			continue
+		}
+		reader, _, _, err := s.storage.GetObject(obj.BucketName, obj.ID)
+		if err != nil {
+			return fmt.Errorf("failed to read %s/%s: %w", obj.BucketName, obj.ID, err)
+		}
+		err = archive.AddFile(path.Join(obj.BucketName, obj.ID, obj.ObjectName), reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userRows selects the rows of a table that belong to a user
+type userRows struct {
+	model interface{}
+	where string
+	arg   interface{}
+}
+
+// erase deletes the user's data. Extensions go first, so a failing extension
+// leaves the user in place and the erasure can be retried. Logs and
+// analytics are kept with the user's details removed, and the user row goes
+// last.
+func (s *UserDataService) erase(ctx context.Context, userID string) error {
+	if s.hook != nil {
+		if err := s.hook(ctx, userID, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := s.storage.DeleteUserObjects(userID); err != nil {
+		return fmt.Errorf("failed to delete storage objects: %w", err)
+	}
+
+	var exports []models.UserDataJob
+	if err := s.db.Where("user_id = ? AND kind = ? AND archive <> ''", userID, models.UserDataJobExport).
+		Find(&exports).Error; err != nil {
+		return err
+	}
+	for i := range exports {
+		if err := s.deleteArchive(&exports[i]); err != nil {
+			return fmt.Errorf("failed to delete export: %w", err)
+		}
+	}
+
+	// An erasure resumed after the user row was deleted has nothing left by email
+	var email string
+	var user auth.User
+	if err := s.db.Where("id = ?", userID).First(&user).Error; err == nil {
+		email = strings.ToLower(user.Email)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		deletes := []userRows{
+			{&auth.Session{}, "user_id = ?", userID},
+			{&auth.Token{}, "user_id = ?", userID},
+			{&models.APIKey{}, "user_id = ?", userID},
+			{&models.S3Credential{}, "user_id = ?", userID},
+			{&models.OAuthIdentity{}, "user_id = ?", userID},
+			{&models.WebAuthnCredential{}, "user_id = ?", userID},
+			{&models.WebAuthnChallenge{}, "user_id = ?", userID},
+			{&models.RoleBinding{}, "user_id = ?", userID},
+			{&models.OrganizationMember{}, "user_id = ?", userID},
+			{&models.DownloadToken{}, "user_id = ?", userID},
+			{&models.UploadToken{}, "user_id = ?", userID},
+			{&models.CollectionRecord{}, "user_id = ?", userID},
+		}
+		if email != "" {
+			deletes = append(deletes,
+				userRows{&models.OrganizationInvite{}, "LOWER(email) = ?", email},
+				userRows{&models.LoginAttempt{}, "email = ?", email},
+			)
+		}
+		for _, rows := range deletes {
+			if err := tx.Where(rows.where, rows.arg).Delete(rows.model).Error; err != nil {
+				return err
+			}
+		}
+
+		anonymize := []struct {
+			model   interface{}
+			where   string
+			updates map[string]interface{}
+		}{
+			{&logger.RequestLogModel{}, "user_id = ?", map[string]interface{}{
+				"user_id": nil, "impersonator_id": nil, "user_ip": "", "user_agent": nil,
+				"headers": nil, "request_body": nil, "response_body": nil,
+			}},
+			{&logger.RequestLogModel{}, "impersonator_id = ?", map[string]interface{}{"impersonator_id": nil}},
+			{&logger.LogModel{}, "user_id = ?", map[string]interface{}{"user_id": nil}},
+			{&models.Organization{}, "created_by = ?", map[string]interface{}{"created_by": nil}},
+			{&models.OrganizationInvite{}, "invited_by = ?", map[string]interface{}{"invited_by": nil}},
+		}
+		for _, a := range anonymize {
+			if err := tx.Model(a.model).Where(a.where, userID).Updates(a.updates).Error; err != nil {
+				return err
+			}
+		}
+
+		// Page views and events are kept for the totals, without who made them
+		for _, table := range analyticsTables {
+			if !tx.Migrator().HasTable(table) {
+				continue
+			}
+			updates := map[string]interface{}{"user_id": nil}
+			if table == "page_views" {
+				updates["session_id"] = nil
+				updates["user_agent"] = nil
+				updates["ip_address"] = nil
+			}
+			if err := tx.Table(table).Where("user_id = ?", userID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Where("id = ?", userID).Delete(&auth.User{}).Error
+	})
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	auth "github.com/suppers-ai/auth"
+	"github.com/suppers-ai/logger"
+	"github.com/suppers-ai/solobase/config"
+	"github.com/suppers-ai/solobase/models"
+	pkgstorage "github.com/suppers-ai/storage"
+)
+
+func newTestUserDataService(t *testing.T) (*UserDataService, *AuthService) {
+	db := newTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&models.APIKey{}, &models.S3Credential{}, &models.CollectionRecord{}, &models.DownloadToken{}, &models.UploadToken{},
+		&models.UserDataJob{}, &pkgstorage.StorageObject{}, &pkgstorage.StorageBucket{}, &pkgstorage.StorageObjectVersion{}, &pkgstorage.StorageUpload{}, &pkgstorage.StorageMultipartUpload{}, &pkgstorage.StorageMultipartPart{},
+		&logger.LogModel{}, &logger.RequestLogModel{},
+	))
+	storage := NewStorageService(db, config.StorageConfig{Type: "local", LocalStoragePath: t.TempDir()})
+	return NewUserDataService(db, storage, t.TempDir()), NewAuthService(db)
+}
+
+// runUserDataJob queues a job and runs it in the foreground
+func runUserDataJob(t *testing.T, s *UserDataService, userID, kind string) *models.UserDataJob {
+	job, created, err := s.createJob(userID, kind, "admin")
+	require.NoError(t, err)
+	require.True(t, created)
+	s.runJob(context.Background(), job.ID)
+	job, err = s.GetJob(job.ID)
+	require.NoError(t, err)
+	return job
+}
+
+func readArchive(t *testing.T, s *UserDataService, job *models.UserDataJob) map[string]string {
+	reader, _, err := s.OpenExport(job)
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestUserDataExportAndErasure(t *testing.T) {
+	s, authService := newTestUserDataService(t)
+	alice := newTestUser(t, authService, "alice@example.com")
+	bob := newTestUser(t, authService, "bob@example.com")
+	aliceID := alice.ID.String()
+
+	session, _, err := authService.CreateSession(alice.ID, "test-agent", "10.0.0.1", SessionPolicy{})
+	require.NoError(t, err)
+	uploaded, err := s.storage.UploadFileBytes("int_storage", "notes.txt", aliceID, []byte("alice's notes"), "text/plain")
+	require.NoError(t, err)
+	fileID := uploaded.(map[string]interface{})["id"].(string)
+	_, err = s.storage.UploadFileBytes("int_storage", "notes.txt", bob.ID.String(), []byte("bob's notes"), "text/plain")
+	require.NoError(t, err)
+	require.NoError(t, s.db.Create(&logger.RequestLogModel{
+		ID: uuid.New(), Level: "info", Method: "GET", Path: "/api/auth/me", StatusCode: 200,
+		UserIP: "10.0.0.1", UserID: &aliceID,
+	}).Error)
+
+	var erased []string
+	s.SetExtensionHook(func(ctx context.Context, userID string, archive *UserDataArchive) error {
+		if archive == nil {
+			erased = append(erased, userID)
+			return nil
+		}
+		return archive.Dir("notes").AddJSON("../../data.json", map[string]string{"user": userID})
+	})
+
+	export := runUserDataJob(t, s, aliceID, models.UserDataJobExport)
+	require.Equal(t, models.UserDataJobCompleted, export.Status, export.Error)
+	files := readArchive(t, s, export)
+
+	assert.Contains(t, files["user.json"], "alice@example.com")
+	assert.NotContains(t, files["user.json"], alice.Password)
+	assert.Contains(t, files["sessions.json"], session.ID)
+	assert.NotContains(t, files["sessions.json"], session.Token)
+	assert.Contains(t, files["request_logs.json"], "/api/auth/me")
+	assert.Equal(t, "alice's notes", files["storage/int_storage/"+fileID+"/notes.txt"])
+	assert.NotContains(t, files["storage/objects.json"], "bob's notes")
+	// Extensions cannot write outside their directory
+	assert.Contains(t, files["extensions/notes/data.json"], aliceID)
+
+	// Archives are kept out of storage, so later exports do not include them
+	files = readArchive(t, s, runUserDataJob(t, s, aliceID, models.UserDataJobExport))
+	assert.NotContains(t, files["storage/objects.json"], ".zip")
+	assert.FileExists(t, filepath.Join(s.exportDir, export.Archive))
+
+	job := runUserDataJob(t, s, aliceID, models.UserDataJobErase)
+	require.Equal(t, models.UserDataJobCompleted, job.Status, job.Error)
+	assert.Equal(t, []string{aliceID}, erased)
+
+	var count int64
+	s.db.Model(&auth.User{}).Where("id = ?", aliceID).Count(&count)
+	assert.Zero(t, count)
+	s.db.Model(&auth.Session{}).Where("user_id = ?", aliceID).Count(&count)
+	assert.Zero(t, count)
+	s.db.Model(&pkgstorage.StorageObject{}).Where("user_id = ?", aliceID).Count(&count)
+	assert.Zero(t, count)
+	_, _, _, err = s.storage.GetObject("int_storage", fileID)
+	assert.Error(t, err)
+
+	// Request logs stay, without who made them
+	var requestLog logger.RequestLogModel
+	require.NoError(t, s.db.Where("path = ?", "/api/auth/me").First(&requestLog).Error)
+	assert.Nil(t, requestLog.UserID)
+	assert.Empty(t, requestLog.UserIP)
+
+	// Other users keep their data
+	s.db.Model(&pkgstorage.StorageObject{}).Where("user_id = ?", bob.ID.String()).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// Exports are erased with the user's files
+	export, err = s.GetJob(export.ID)
+	require.NoError(t, err)
+	_, _, err = s.OpenExport(export)
+	assert.ErrorIs(t, err, ErrExportExpired)
+	entries, err := os.ReadDir(s.exportDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	_, _, err = s.OpenExport(job)
+	assert.ErrorIs(t, err, ErrExportNotReady)
+}
+
+func TestUserDataExportsExpire(t *testing.T) {
+	s, authService := newTestUserDataService(t)
+	alice := newTestUser(t, authService, "alice@example.com")
+
+	export := runUserDataJob(t, s, alice.ID.String(), models.UserDataJobExport)
+	require.Equal(t, models.UserDataJobCompleted, export.Status, export.Error)
+	require.NotNil(t, export.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(UserDataExportExpiry), *export.ExpiresAt, time.Minute)
+	fresh := runUserDataJob(t, s, alice.ID.String(), models.UserDataJobExport)
+
+	purged, err := s.PurgeExpiredExports()
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	require.NoError(t, s.db.Model(export).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	export, err = s.GetJob(export.ID)
+	require.NoError(t, err)
+	// Expired archives cannot be downloaded even before they are purged
+	_, _, err = s.OpenExport(export)
+	assert.ErrorIs(t, err, ErrExportExpired)
+
+	purged, err = s.PurgeExpiredExports()
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.NoFileExists(t, filepath.Join(s.exportDir, export.Archive))
+	reader, _, err := s.OpenExport(fresh)
+	require.NoError(t, err)
+	reader.Close()
+}
+
+func TestUserDataErasureStopsOnExtensionError(t *testing.T) {
+	s, authService := newTestUserDataService(t)
+	alice := newTestUser(t, authService, "alice@example.com")
+
+	s.SetExtensionHook(func(ctx context.Context, userID string, archive *UserDataArchive) error {
+		return errors.New("extension database unavailable")
+	})
+
+	job := runUserDataJob(t, s, alice.ID.String(), models.UserDataJobErase)
+	assert.Equal(t, models.UserDataJobFailed, job.Status)
+	assert.Contains(t, job.Error, "extension database unavailable")
+
+	// The user is kept, so the erasure can be started again
+	user, err := NewUserService(s.db).GetUserByID(alice.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", user.Email)
+
+	_, created, err := s.createJob(alice.ID.String(), models.UserDataJobErase, "admin")
+	require.NoError(t, err)
+	assert.True(t, created)
+}
@@ -0,0 +1,548 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
+	"strings"
+)
+
+// This file holds the parts of the WebAuthn protocol (https://www.w3.org/TR/webauthn-2/)
+// that a relying party needs: checking client data and authenticator data,
+// decoding COSE public keys and verifying assertion signatures. Attestation
+// statements are not checked since registrations ask for no attestation.
+
+// COSE algorithms accepted for passkeys, in order of preference
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// Authenticator data flags
+const (
+	authFlagUserPresent      = 0x01
+	authFlagUserVerified     = 0x04
+	authFlagBackupEligible   = 0x08
+	authFlagBackedUp         = 0x10
+	authFlagAttestedCredData = 0x40
+	authFlagExtensionData    = 0x80
+)
+
+// User verification requirements of a ceremony
+const (
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+)
+
+// ErrInvalidWebAuthnResponse is returned when a browser's answer to a
+// registration or login ceremony cannot be verified
+var ErrInvalidWebAuthnResponse = errors.New("invalid passkey response")
+
+// RelyingParty identifies the site passkeys are registered with. Browsers only
+// use a passkey on the domain it was created for.
+type RelyingParty struct {
+	ID     string // Domain of the site, e.g. example.com
+	Name   string
+	Origin string // Scheme, host and port, e.g. https://example.com
+}
+
+// RelyingPartyFromURL derives the relying party from the app's public URL
+func RelyingPartyFromURL(appURL, name string) (RelyingParty, error) {
+	u, err := url.Parse(appURL)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return RelyingParty{}, fmt.Errorf("app URL %q is not an http(s) URL", appURL)
+	}
+	return RelyingParty{
+		ID:     u.Hostname(),
+		Name:   name,
+		Origin: u.Scheme + "://" + u.Host,
+	}, nil
+}
+
+// The options and responses below use the JSON form of the WebAuthn API
+// (PublicKeyCredential.parseCreationOptionsFromJSON and toJSON), so they are
+// camelCase and binary values are base64url encoded.
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CredentialCreationOptions are passed to navigator.credentials.create to register a passkey
+type CredentialCreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// CredentialRequestOptions are passed to navigator.credentials.get to log in with a passkey
+type CredentialRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse is the new credential returned by navigator.credentials.create
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the signed challenge returned by navigator.credentials.get
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// collectedClientData is the JSON the browser signs along with the authenticator data
+type collectedClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// authenticatorData is the binary structure produced by the authenticator
+type authenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// Only set during registration
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte // COSE encoded
+}
+
+func (d *authenticatorData) has(flag byte) bool {
+	return d.Flags&flag != 0
+}
+
+// parseClientData decodes the client data of a ceremony and checks its type and origin
+func parseClientData(encoded, ceremony string, rp RelyingParty) ([]byte, *collectedClientData, error) {
+	raw, err := decodeBase64URL(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: client data is not base64url", ErrInvalidWebAuthnResponse)
+	}
+	var data collectedClientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, nil, fmt.Errorf("%w: client data is not JSON", ErrInvalidWebAuthnResponse)
+	}
+	switch {
+	case data.Type != ceremony:
+		return nil, nil, fmt.Errorf("%w: expected a %s ceremony", ErrInvalidWebAuthnResponse, ceremony)
+	case data.Origin != rp.Origin:
+		return nil, nil, fmt.Errorf("%w: unexpected origin %q", ErrInvalidWebAuthnResponse, data.Origin)
+	case data.CrossOrigin:
+		return nil, nil, fmt.Errorf("%w: cross-origin requests are not allowed", ErrInvalidWebAuthnResponse)
+	case data.Challenge == "":
+		return nil, nil, fmt.Errorf("%w: missing challenge", ErrInvalidWebAuthnResponse)
+	}
+	return raw, &data, nil
+}
+
+// parseAuthenticatorData decodes authenticator data and checks that it was
+// made for the relying party with the user present
+func parseAuthenticatorData(raw []byte, rp RelyingParty, userVerification string) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data is too short", ErrInvalidWebAuthnResponse)
+	}
+	data := &authenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rest := raw[37:]
+
+	if data.has(authFlagAttestedCredData) {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data is too short", ErrInvalidWebAuthnResponse)
+		}
+		data.AAGUID = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+			return nil, fmt.Errorf("%w: invalid credential ID", ErrInvalidWebAuthnResponse)
+		}
+		data.CredentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid credential public key: %v", ErrInvalidWebAuthnResponse, err)
+		}
+		data.PublicKey = rest[:n]
+		rest = rest[n:]
+	}
+	if data.has(authFlagExtensionData) {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid extension data: %v", ErrInvalidWebAuthnResponse, err)
+		}
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing bytes in authenticator data", ErrInvalidWebAuthnResponse)
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	switch {
+	case !bytes.Equal(data.RPIDHash, rpIDHash[:]):
+		return nil, fmt.Errorf("%w: credential belongs to another site", ErrInvalidWebAuthnResponse)
+	case !data.has(authFlagUserPresent):
+		return nil, fmt.Errorf("%w: user was not present", ErrInvalidWebAuthnResponse)
+	case userVerification == UserVerificationRequired && !data.has(authFlagUserVerified):
+		return nil, fmt.Errorf("%w: user was not verified", ErrInvalidWebAuthnResponse)
+	}
+	return data, nil
+}
+
+// parseAttestationObject returns the authenticator data of a registration.
+// Only the authenticator data is used, the attestation statement is ignored.
+func parseAttestationObject(encoded string) ([]byte, error) {
+	raw, err := decodeBase64URL(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestation object is not base64url", ErrInvalidWebAuthnResponse)
+	}
+	value, n, err := decodeCBOR(raw)
+	if err != nil || n != len(raw) {
+		return nil, fmt.Errorf("%w: invalid attestation object", ErrInvalidWebAuthnResponse)
+	}
+	object, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid attestation object", ErrInvalidWebAuthnResponse)
+	}
+	authData, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object has no authenticator data", ErrInvalidWebAuthnResponse)
+	}
+	return authData, nil
+}
+
+// parseCOSEKey decodes a COSE encoded public key and returns it with its algorithm
+func parseCOSEKey(raw []byte) (crypto.PublicKey, int, error) {
+	value, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	key, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("COSE key is not a map")
+	}
+	intParam := func(label int64) (int64, bool) {
+		v, ok := key[label].(int64)
+		return v, ok
+	}
+	bytesParam := func(label int64) []byte {
+		v, _ := key[label].([]byte)
+		return v
+	}
+
+	kty, _ := intParam(1)
+	alg, ok := intParam(3)
+	if !ok {
+		return nil, 0, errors.New("COSE key has no algorithm")
+	}
+
+	switch {
+	case kty == 2 && alg == coseAlgES256:
+		crv, _ := intParam(-1)
+		x, y := bytesParam(-2), bytesParam(-3)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("unsupported EC2 key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, errors.New("EC2 key is not on the curve")
+		}
+		return pub, coseAlgES256, nil
+	case kty == 1 && alg == coseAlgEdDSA:
+		crv, _ := intParam(-1)
+		x := bytesParam(-2)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("unsupported OKP key")
+		}
+		return ed25519.PublicKey(x), coseAlgEdDSA, nil
+	case kty == 3 && alg == coseAlgRS256:
+		n, e := bytesParam(-1), bytesParam(-2)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("unsupported RSA key")
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, coseAlgRS256, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported COSE key type %d with algorithm %d", kty, alg)
+}
+
+// verifyAssertionSignature checks the signature over the authenticator data
+// and the hash of the client data
+func verifyAssertionSignature(publicKey []byte, authData, clientData, signature []byte) error {
+	pub, alg, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWebAuthnResponse, err)
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+
+	valid := false
+	switch alg {
+	case coseAlgES256:
+		digest := sha256.Sum256(signed)
+		valid = ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], signature)
+	case coseAlgEdDSA:
+		valid = ed25519.Verify(pub.(ed25519.PublicKey), signed, signature)
+	case coseAlgRS256:
+		digest := sha256.Sum256(signed)
+		valid = rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return fmt.Errorf("%w: bad signature", ErrInvalidWebAuthnResponse)
+	}
+	return nil
+}
+
+// formatAAGUID formats an authenticator's AAGUID like a UUID, or returns ""
+// for authenticators that do not reveal their model
+func formatAAGUID(aaguid []byte) string {
+	if len(aaguid) != 16 || bytes.Equal(aaguid, make([]byte, 16)) {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", aaguid[0:4], aaguid[4:6], aaguid[6:8], aaguid[8:10], aaguid[10:16])
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// cborMaxDepth limits nesting so hostile input cannot exhaust the stack
+const cborMaxDepth = 16
+
+// decodeCBOR decodes the first CBOR data item (RFC 8949) in data and returns
+// it with the number of bytes it used. It covers what WebAuthn uses: integers
+// become int64, maps become map[interface{}]interface{} with int64 or string
+// keys, and indefinite lengths are rejected.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, int, error) {
+	if depth > cborMaxDepth {
+		return nil, 0, errors.New("cbor: nested too deeply")
+	}
+	if len(data) == 0 {
+		return nil, 0, errors.New("cbor: unexpected end of data")
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+
+	// Floats and simple values keep their raw additional information
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, 1, nil
+		case 21:
+			return true, 1, nil
+		case 22, 23:
+			return nil, 1, nil
+		case 25:
+			if len(data) < 3 {
+				return nil, 0, errors.New("cbor: unexpected end of data")
+			}
+			return halfToFloat(binary.BigEndian.Uint16(data[1:3])), 3, nil
+		case 26:
+			if len(data) < 5 {
+				return nil, 0, errors.New("cbor: unexpected end of data")
+			}
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(data[1:5]))), 5, nil
+		case 27:
+			if len(data) < 9 {
+				return nil, 0, errors.New("cbor: unexpected end of data")
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(data[1:9])), 9, nil
+		}
+		return nil, 0, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+
+	arg, n, err := cborArgument(data, info)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, 0, errors.New("cbor: integer overflows int64")
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, 0, errors.New("cbor: integer overflows int64")
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if arg > uint64(len(data)-n) {
+			return nil, 0, errors.New("cbor: unexpected end of data")
+		}
+		end := n + int(arg)
+		if major == 3 {
+			return string(data[n:end]), end, nil
+		}
+		return append([]byte{}, data[n:end]...), end, nil
+	case 4:
+		if arg > uint64(len(data)-n) {
+			return nil, 0, errors.New("cbor: unexpected end of data")
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, used, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			n += used
+		}
+		return items, n, nil
+	case 5:
+		if arg > uint64(len(data)-n) {
+			return nil, 0, errors.New("cbor: unexpected end of data")
+		}
+		entries := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, used, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errors.New("cbor: unsupported map key type")
+			}
+			if _, dup := entries[key]; dup {
+				return nil, 0, errors.New("cbor: duplicate map key")
+			}
+			value, used, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			entries[key] = value
+		}
+		return entries, n, nil
+	case 6:
+		// Tags carry no meaning for WebAuthn, so return the tagged value
+		value, used, err := decodeCBORItem(data[n:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return value, n + used, nil
+	}
+	return nil, 0, fmt.Errorf("cbor: unsupported major type %d", major)
+}
+
+// cborArgument reads the argument of a data item's initial byte and returns
+// it with the length of the header
+func cborArgument(data []byte, info byte) (uint64, int, error) {
+	switch {
+	case info < 24:
+		return uint64(info), 1, nil
+	case info == 24 && len(data) >= 2:
+		return uint64(data[1]), 2, nil
+	case info == 25 && len(data) >= 3:
+		return uint64(binary.BigEndian.Uint16(data[1:3])), 3, nil
+	case info == 26 && len(data) >= 5:
+		return uint64(binary.BigEndian.Uint32(data[1:5])), 5, nil
+	case info == 27 && len(data) >= 9:
+		return binary.BigEndian.Uint64(data[1:9]), 9, nil
+	case info == 31:
+		return 0, 0, errors.New("cbor: indefinite lengths are not supported")
+	case info > 27:
+		return 0, 0, fmt.Errorf("cbor: reserved additional information %d", info)
+	}
+	return 0, 0, errors.New("cbor: unexpected end of data")
+}
+
+// halfToFloat converts an IEEE 754 half-precision float
+func halfToFloat(h uint16) float64 {
+	exponent := int(h>>10) & 0x1f
+	mantissa := float64(h & 0x3ff)
+	var value float64
+	switch exponent {
+	case 0:
+		value = math.Ldexp(mantissa, -24)
+	case 31:
+		if mantissa == 0 {
+			value = math.Inf(1)
+		} else {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mantissa+1024, exponent-25)
+	}
+	if h&0x8000 != 0 {
+		return -value
+	}
+	return value
+}
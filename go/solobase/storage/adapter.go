@@ -3,6 +3,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
 	"time"
 
@@ -17,6 +18,7 @@ type Object struct {
 	LastModified time.Time `json:"last_modified"`
 	ETag         string    `json:"etag,omitempty"`
 	IsDirectory  bool      `json:"is_directory"`
+	VersionID    string    `json:"version_id,omitempty"`
 }
 
 // Bucket represents a storage bucket (adapter for package storage)
@@ -49,6 +51,35 @@ type Provider interface {
 	GetSignedURL(bucket, key string, expiry time.Duration) (string, error)
 }
 
+// ErrVersioningNotSupported is returned by providers that cannot keep prior
+// versions of objects
+var ErrVersioningNotSupported = errors.New("storage provider does not support versioning")
+
+// VersionedProvider is implemented by providers that keep the prior versions
+// of objects overwritten in versioned buckets (adapter for package storage)
+type VersionedProvider interface {
+	SetBucketVersioning(bucket string, enabled bool) error
+	GetObjectVersion(bucket, key, versionID string) (io.ReadCloser, error)
+	DeleteObjectVersion(bucket, key, versionID string) error
+}
+
+// ErrResumableNotSupported is returned by providers that cannot write an
+// object in chunks
+var ErrResumableNotSupported = errors.New("storage provider does not support resumable uploads")
+
+// ErrUploadOffsetMismatch is returned when a chunk does not start where the
+// upload's bytes end
+var ErrUploadOffsetMismatch = pkgstorage.ErrUploadOffsetMismatch
+
+// ResumableProvider is implemented by providers that can write an object in
+// chunks, so an interrupted upload can be resumed (adapter for package storage)
+type ResumableProvider interface {
+	CreateUpload(bucket, key, contentType string) (string, error)
+	WriteUpload(bucket, key, uploadID string, offset int64, reader io.Reader) (int64, error)
+	CompleteUpload(bucket, key, uploadID string) error
+	AbortUpload(bucket, key, uploadID string) error
+}
+
 // Storage wraps a storage provider
 type Storage struct {
 	provider Provider
@@ -99,6 +130,70 @@ func (s *Storage) GetObjectInfo(bucket, key string) (*Object, error) {
 	return s.provider.GetObjectInfo(bucket, key)
 }
 
+// SetBucketVersioning enables or suspends versioning for a bucket
+func (s *Storage) SetBucketVersioning(bucket string, enabled bool) error {
+	versioned, ok := s.provider.(VersionedProvider)
+	if !ok {
+		return ErrVersioningNotSupported
+	}
+	return versioned.SetBucketVersioning(bucket, enabled)
+}
+
+// GetObjectVersion retrieves one version of an object
+func (s *Storage) GetObjectVersion(bucket, key, versionID string) (io.ReadCloser, error) {
+	versioned, ok := s.provider.(VersionedProvider)
+	if !ok {
+		return nil, ErrVersioningNotSupported
+	}
+	return versioned.GetObjectVersion(bucket, key, versionID)
+}
+
+// DeleteObjectVersion permanently deletes one version of an object
+func (s *Storage) DeleteObjectVersion(bucket, key, versionID string) error {
+	versioned, ok := s.provider.(VersionedProvider)
+	if !ok {
+		return ErrVersioningNotSupported
+	}
+	return versioned.DeleteObjectVersion(bucket, key, versionID)
+}
+
+// CreateUpload starts a resumable upload to key and returns its ID
+func (s *Storage) CreateUpload(bucket, key, contentType string) (string, error) {
+	resumable, ok := s.provider.(ResumableProvider)
+	if !ok {
+		return "", ErrResumableNotSupported
+	}
+	return resumable.CreateUpload(bucket, key, contentType)
+}
+
+// WriteUpload writes the next chunk of a resumable upload, returning the bytes
+// kept
+func (s *Storage) WriteUpload(bucket, key, uploadID string, offset int64, reader io.Reader) (int64, error) {
+	resumable, ok := s.provider.(ResumableProvider)
+	if !ok {
+		return 0, ErrResumableNotSupported
+	}
+	return resumable.WriteUpload(bucket, key, uploadID, offset, reader)
+}
+
+// CompleteUpload makes the bytes of a resumable upload the object's content
+func (s *Storage) CompleteUpload(bucket, key, uploadID string) error {
+	resumable, ok := s.provider.(ResumableProvider)
+	if !ok {
+		return ErrResumableNotSupported
+	}
+	return resumable.CompleteUpload(bucket, key, uploadID)
+}
+
+// AbortUpload discards a resumable upload
+func (s *Storage) AbortUpload(bucket, key, uploadID string) error {
+	resumable, ok := s.provider.(ResumableProvider)
+	if !ok {
+		return ErrResumableNotSupported
+	}
+	return resumable.AbortUpload(bucket, key, uploadID)
+}
+
 // GetPublicURL gets the public URL for an object
 func (s *Storage) GetPublicURL(bucket, key string) string {
 	return s.provider.GetPublicURL(bucket, key)
@@ -244,6 +339,7 @@ func (p *providerAdapter) GetObjectInfo(bucket, key string) (*Object, error) {
 		LastModified: info.LastModified,
 		ETag:         info.ETag,
 		IsDirectory:  info.IsDir,
+		VersionID:    info.VersionID,
 	}, nil
 }
 
@@ -255,4 +351,61 @@ func (p *providerAdapter) GetPublicURL(bucket, key string) string {
 
 func (p *providerAdapter) GetSignedURL(bucket, key string, expiry time.Duration) (string, error) {
 	return p.provider.GeneratePresignedURL(p.ctx, bucket, key, expiry)
-}
\ No newline at end of file
+}
+
+func (p *providerAdapter) SetBucketVersioning(bucket string, enabled bool) error {
+	versioned, ok := p.provider.(pkgstorage.VersionedProvider)
+	if !ok {
+		return ErrVersioningNotSupported
+	}
+	return versioned.SetBucketVersioning(p.ctx, bucket, enabled)
+}
+
+func (p *providerAdapter) GetObjectVersion(bucket, key, versionID string) (io.ReadCloser, error) {
+	versioned, ok := p.provider.(pkgstorage.VersionedProvider)
+	if !ok {
+		return nil, ErrVersioningNotSupported
+	}
+	return versioned.GetObjectVersion(p.ctx, bucket, key, versionID)
+}
+
+func (p *providerAdapter) DeleteObjectVersion(bucket, key, versionID string) error {
+	versioned, ok := p.provider.(pkgstorage.VersionedProvider)
+	if !ok {
+		return ErrVersioningNotSupported
+	}
+	return versioned.DeleteObjectVersion(p.ctx, bucket, key, versionID)
+}
+func (p *providerAdapter) CreateUpload(bucket, key, contentType string) (string, error) {
+	resumable, ok := p.provider.(pkgstorage.ResumableProvider)
+	if !ok {
+		return "", ErrResumableNotSupported
+	}
+	return resumable.CreateUpload(p.ctx, bucket, key, pkgstorage.PutObjectOptions{
+		ContentType: contentType,
+	})
+}
+
+func (p *providerAdapter) WriteUpload(bucket, key, uploadID string, offset int64, reader io.Reader) (int64, error) {
+	resumable, ok := p.provider.(pkgstorage.ResumableProvider)
+	if !ok {
+		return 0, ErrResumableNotSupported
+	}
+	return resumable.WriteUpload(p.ctx, bucket, key, uploadID, offset, reader)
+}
+
+func (p *providerAdapter) CompleteUpload(bucket, key, uploadID string) error {
+	resumable, ok := p.provider.(pkgstorage.ResumableProvider)
+	if !ok {
+		return ErrResumableNotSupported
+	}
+	return resumable.CompleteUpload(p.ctx, bucket, key, uploadID)
+}
+
+func (p *providerAdapter) AbortUpload(bucket, key, uploadID string) error {
+	resumable, ok := p.provider.(pkgstorage.ResumableProvider)
+	if !ok {
+		return ErrResumableNotSupported
+	}
+	return resumable.AbortUpload(p.ctx, bucket, key, uploadID)
+}